@@ -0,0 +1,218 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/mvaleed/brook/internal/admin"
+)
+
+// adminFlags registers the flags locating the admin API of a broker, and
+// returns the function making its client once they are parsed.
+func adminFlags(fs *flag.FlagSet) func() *admin.Client {
+	url := os.Getenv("BROOK_ADMIN_URL")
+	if url == "" {
+		url = "http://localhost:9092"
+	}
+	server := fs.String("server", url, "URL of the broker's admin API (default from $BROOK_ADMIN_URL)")
+	token := fs.String("token", os.Getenv("BROOK_ADMIN_TOKEN"), "bearer token to authenticate with (default from $BROOK_ADMIN_TOKEN)")
+	return func() *admin.Client {
+		return &admin.Client{URL: *server, Token: *token}
+	}
+}
+
+func adminTopicsCommand(fs *flag.FlagSet) runFunc {
+	client := adminFlags(fs)
+	output := outputFlag(fs)
+
+	return func(c *cli, args []string) error {
+		if len(args) > 0 {
+			return usagef("unexpected arguments %q", args)
+		}
+
+		topics, err := client().ListTopics(c.ctx)
+		if err != nil {
+			return err
+		}
+		if *output == outputJSON {
+			return writeJSON(c.stdout, topics)
+		}
+		rows := make([][]string, len(topics))
+		for i, topic := range topics {
+			rows[i] = append([]string{topic.Name}, configCells(topic.Config)...)
+		}
+		return writeTable(c.stdout, append([]string{"TOPIC"}, configHeader...), rows)
+	}
+}
+
+func adminDescribeCommand(fs *flag.FlagSet) runFunc {
+	client := adminFlags(fs)
+	output := outputFlag(fs)
+
+	return func(c *cli, args []string) error {
+		if len(args) != 1 {
+			return usagef("expected a single topic")
+		}
+
+		d, err := client().DescribeTopic(c.ctx, args[0])
+		if err != nil {
+			return err
+		}
+		if *output == outputJSON {
+			return writeJSON(c.stdout, d)
+		}
+		if err := writeTable(c.stdout, configHeader, [][]string{configCells(d.Config)}); err != nil {
+			return err
+		}
+		fmt.Fprintln(c.stdout)
+		rows := make([][]string, len(d.Partitions))
+		for i, stats := range d.Partitions {
+			rows[i] = append([]string{strconv.Itoa(i)}, statsCells(stats)...)
+		}
+		return writeTable(c.stdout, append([]string{"PARTITION"}, statsHeader...), rows)
+	}
+}
+
+func adminAlterConfigCommand(fs *flag.FlagSet) runFunc {
+	client := adminFlags(fs)
+	output := outputFlag(fs)
+	retention := fs.Duration("retention", 0, "how long messages are kept, 0 to keep them forever")
+	compression := fs.String("compression", "", "codec messages are stored in, none or gzip")
+	maxSegmentBytes := fs.Int64("max-segment-bytes", 0, "segment size, 0 for the broker's, applied once the broker opens the topic again")
+	maxMessageBytes := fs.Int64("max-message-bytes", 0, "size limit of a message, 0 for the broker's")
+	durability := fs.String("durability", "", "async to acknowledge messages once buffered, medium once handed to the OS, full once fsynced, applied to the segments once the broker opens the topic again")
+	compact := fs.Bool("compact", false, "mark the topic as keyed state")
+	queue := fs.Bool("queue", false, "let consumer groups receive, ack and nack the messages of the topic one by one")
+	visibilityTimeout := fs.Duration("visibility-timeout", 0, "how long a message received from the queue stays invisible before it's received again, 0 for the broker's default")
+	timestampType := fs.String("timestamp-type", "", "log_append_time to stamp messages with the time the broker appends them, create_time to keep the time producers give")
+	maxTimestampSkew := fs.Duration("max-timestamp-skew", 0, "how far from the broker's clock create_time timestamps may be, 0 for the broker's default")
+
+	return func(c *cli, args []string) error {
+		if len(args) != 1 {
+			return usagef("expected a single topic")
+		}
+
+		// Only the flags given change
+		var change admin.ConfigChange
+		fs.Visit(func(f *flag.Flag) {
+			switch f.Name {
+			case "retention":
+				ms := retention.Milliseconds()
+				change.RetentionMs = &ms
+			case "compression":
+				change.Compression = compression
+			case "max-segment-bytes":
+				change.MaxSegmentBytes = maxSegmentBytes
+			case "max-message-bytes":
+				change.MaxMessageBytes = maxMessageBytes
+			case "durability":
+				change.Durability = durability
+			case "compact":
+				change.Compact = compact
+			case "queue":
+				change.Queue = queue
+			case "visibility-timeout":
+				ms := visibilityTimeout.Milliseconds()
+				change.VisibilityTimeoutMs = &ms
+			case "timestamp-type":
+				change.TimestampType = timestampType
+			case "max-timestamp-skew":
+				ms := maxTimestampSkew.Milliseconds()
+				change.MaxTimestampSkewMs = &ms
+			}
+		})
+		if change == (admin.ConfigChange{}) {
+			return usagef("expected at least one setting to change")
+		}
+
+		config, err := client().AlterConfig(c.ctx, args[0], change)
+		if err != nil {
+			return err
+		}
+		if *output == outputJSON {
+			return writeJSON(c.stdout, config)
+		}
+		return writeTable(c.stdout, configHeader, [][]string{configCells(config)})
+	}
+}
+
+func adminDeleteRecordsCommand(fs *flag.FlagSet) runFunc {
+	client := adminFlags(fs)
+	partition := fs.Int("partition", 0, "partition to delete records of")
+	before := fs.Int("before", -1, "offset to delete the records before, which becomes the first one")
+
+	return func(c *cli, args []string) error {
+		if len(args) != 1 {
+			return usagef("expected a single topic")
+		}
+		if *before < 0 {
+			return usagef("--before is required")
+		}
+
+		if err := client().DeleteRecordsBefore(c.ctx, args[0], *partition, *before); err != nil {
+			return err
+		}
+		_, err := fmt.Fprintf(c.stdout, "deleted the records of partition %d of %s before offset %d\n", *partition, args[0], *before)
+		return err
+	}
+}
+
+func adminReconfigureCommand(fs *flag.FlagSet) runFunc {
+	client := adminFlags(fs)
+	defaultRetention := fs.Duration("default-retention", 0, "retention of the topics created without a config, 0 to keep their messages forever")
+	logLevel := fs.String("log-level", "", "log level of the broker, debug, info, warn or error")
+
+	return func(c *cli, args []string) error {
+		if len(args) > 0 {
+			return usagef("unexpected arguments %q", args)
+		}
+
+		// Only the flags given change
+		var change admin.BrokerConfigChange
+		fs.Visit(func(f *flag.Flag) {
+			switch f.Name {
+			case "default-retention":
+				ms := defaultRetention.Milliseconds()
+				change.DefaultRetentionMs = &ms
+			case "log-level":
+				change.LogLevel = logLevel
+			}
+		})
+		if change == (admin.BrokerConfigChange{}) {
+			return usagef("expected at least one setting to change")
+		}
+
+		if err := client().ReconfigureBroker(c.ctx, change); err != nil {
+			return err
+		}
+		_, err := fmt.Fprintln(c.stdout, "reconfigured the broker")
+		return err
+	}
+}
+
+// configHeader heads the columns of configCells.
+var configHeader = []string{"PARTITIONS", "RETENTION", "COMPRESSION", "MAX SEGMENT BYTES", "DURABILITY", "COMPACT", "QUEUE"}
+
+// configCells are the table cells of the config of a topic.
+func configCells(config admin.TopicConfig) []string {
+	retention := "-"
+	if config.RetentionMs > 0 {
+		retention = (time.Duration(config.RetentionMs) * time.Millisecond).String()
+	}
+	maxSegmentBytes := "-"
+	if config.MaxSegmentBytes > 0 {
+		maxSegmentBytes = strconv.FormatInt(config.MaxSegmentBytes, 10)
+	}
+	return []string{
+		strconv.Itoa(config.Partitions),
+		retention,
+		config.Compression,
+		maxSegmentBytes,
+		config.Durability,
+		strconv.FormatBool(config.Compact),
+		strconv.FormatBool(config.Queue),
+	}
+}
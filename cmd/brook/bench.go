@@ -0,0 +1,321 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/mvaleed/brook/internal/brain"
+	"github.com/mvaleed/brook/internal/client"
+	"github.com/mvaleed/brook/internal/storage"
+)
+
+// benchFlags are the flags of both bench commands.
+type benchFlags struct {
+	dir         *string
+	output      *outputFormat
+	topic       *string
+	partitions  *int
+	messages    *int
+	payloadSize *int
+	concurrency *int
+}
+
+func newBenchFlags(fs *flag.FlagSet) benchFlags {
+	return benchFlags{
+		dir:         fs.String("dir", "", "directory to run the benchmark in, a temporary one removed afterwards by default"),
+		output:      outputFlag(fs),
+		topic:       fs.String("topic", "bench", "topic to benchmark"),
+		partitions:  fs.Int("partitions", 1, "partitions of the topic"),
+		messages:    fs.Int("messages", 100000, "messages to produce"),
+		payloadSize: fs.Int("payload-size", 100, "bytes of every message"),
+		concurrency: fs.Int("concurrency", 1, "producers, or consumers sharing the partitions"),
+	}
+}
+
+func (f benchFlags) validate() error {
+	if *f.partitions <= 0 || *f.messages <= 0 || *f.payloadSize < 0 || *f.concurrency <= 0 {
+		return usagef("--partitions, --messages and --concurrency must be positive, --payload-size can't be negative")
+	}
+	return nil
+}
+
+// openBroker opens a broker in the benchmark's directory and creates its
+// topic, returning the function closing it and removing a temporary
+// directory.
+func (f benchFlags) openBroker(c *cli, durability storage.DurabilityMode) (*brain.Broker, func() error, error) {
+	dir := *f.dir
+	remove := func() error { return nil }
+	if dir == "" {
+		var err error
+		if dir, err = os.MkdirTemp("", "brook-bench-"); err != nil {
+			return nil, nil, err
+		}
+		remove = func() error { return os.RemoveAll(dir) }
+	}
+
+	config := brain.DefaultBrokerConfig()
+	config.Partition = c.partitionConfig()
+	b, err := brain.OpenBroker(storage.Paths{Data: dir}, config)
+	if err != nil {
+		return nil, nil, errors.Join(err, remove())
+	}
+	closeBroker := func() error { return errors.Join(b.Close(), remove()) }
+
+	topic := brain.DefaultTopicConfig()
+	topic.Partitions = *f.partitions
+	topic.Durability = durability
+	if err := b.CreateTopic(context.Background(), *f.topic, topic); err != nil {
+		return nil, nil, errors.Join(err, closeBroker())
+	}
+	return b, closeBroker, nil
+}
+
+// benchResult is what a benchmark measured. Latencies are of a message sent,
+// or of a poll.
+type benchResult struct {
+	Messages int           `json:"messages"`
+	Bytes    int64         `json:"bytes"`
+	Duration time.Duration `json:"duration_ns"`
+	P50      time.Duration `json:"p50_ns"`
+	P99      time.Duration `json:"p99_ns"`
+	P999     time.Duration `json:"p999_ns"`
+	Max      time.Duration `json:"max_ns"`
+}
+
+func newBenchResult(messages int, bytes int64, duration time.Duration, latencies []time.Duration) benchResult {
+	slices.Sort(latencies)
+	percentile := func(p float64) time.Duration {
+		if len(latencies) == 0 {
+			return 0
+		}
+		return latencies[min(len(latencies)-1, int(p*float64(len(latencies))))]
+	}
+	r := benchResult{
+		Messages: messages,
+		Bytes:    bytes,
+		Duration: duration,
+		P50:      percentile(0.5),
+		P99:      percentile(0.99),
+		P999:     percentile(0.999),
+	}
+	if len(latencies) > 0 {
+		r.Max = latencies[len(latencies)-1]
+	}
+	return r
+}
+
+func (r benchResult) write(c *cli, output outputFormat, latency string) error {
+	if output == outputJSON {
+		return writeJSON(c.stdout, r)
+	}
+	seconds := r.Duration.Seconds()
+	return writeTable(c.stdout, []string{"MESSAGES", "DURATION", "MSG/S", "MB/S", latency + " P50", "P99", "P999", "MAX"}, [][]string{{
+		strconv.Itoa(r.Messages),
+		r.Duration.Round(time.Millisecond).String(),
+		strconv.FormatFloat(float64(r.Messages)/seconds, 'f', 0, 64),
+		strconv.FormatFloat(float64(r.Bytes)/seconds/1e6, 'f', 2, 64),
+		r.P50.String(),
+		r.P99.String(),
+		r.P999.String(),
+		r.Max.String(),
+	}})
+}
+
+func benchProduceCommand(fs *flag.FlagSet) runFunc {
+	flags := newBenchFlags(fs)
+	batchSize := fs.Int("batch-size", 1, "messages every producer sends at once, which partitions write together")
+	durability := fs.String("durability", "medium", "durability of the topic, async to return once messages are buffered, medium once they are handed to the OS or full once they are fsynced")
+
+	return func(c *cli, args []string) error {
+		if len(args) > 0 {
+			return usagef("unexpected arguments %q", args)
+		}
+		if err := flags.validate(); err != nil {
+			return err
+		}
+		if *batchSize <= 0 {
+			return usagef("--batch-size must be positive")
+		}
+		mode, err := parseDurability(*durability)
+		if err != nil {
+			return err
+		}
+
+		b, closeBroker, err := flags.openBroker(c, mode)
+		if err != nil {
+			return err
+		}
+		result, err := benchProduce(c.ctx, b, *flags.topic, *flags.messages, *flags.payloadSize, *batchSize, *flags.concurrency)
+		if err := errors.Join(err, closeBroker()); err != nil {
+			return err
+		}
+		return result.write(c, *flags.output, "SEND")
+	}
+}
+
+func parseDurability(s string) (storage.DurabilityMode, error) {
+	for _, mode := range []storage.DurabilityMode{storage.DurabilityAsync, storage.DurabilityMedium, storage.DurabilityFull} {
+		if s == mode.String() {
+			return mode, nil
+		}
+	}
+	return 0, usagef("invalid --durability %q, expected async, medium or full", s)
+}
+
+// benchProduce sends messages from concurrency producers, each sending
+// batchSize messages at once and waiting for them before the next ones.
+func benchProduce(ctx context.Context, b *brain.Broker, topic string, messages, payloadSize, batchSize, concurrency int) (benchResult, error) {
+	payload := make([]byte, payloadSize)
+	rand.Read(payload)
+	producer := client.NewProducer(b, client.ProducerConfig{Partitioner: &client.RoundRobinPartitioner{}})
+
+	var mu sync.Mutex
+	var failed error
+	latencies := make([]time.Duration, 0, messages)
+
+	// Producers take batches off a shared count until every message is sent
+	batches := make(chan int)
+	go func() {
+		defer close(batches)
+		for sent := 0; sent < messages; sent += batchSize {
+			batches <- min(batchSize, messages-sent)
+		}
+	}()
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	for range concurrency {
+		wg.Go(func() {
+			batch := make([]time.Duration, batchSize)
+			errs := make([]error, batchSize)
+			for n := range batches {
+				mu.Lock()
+				stop := failed != nil
+				mu.Unlock()
+				if stop {
+					// Drain the batches left
+					continue
+				}
+
+				var sends sync.WaitGroup
+				for i := range n {
+					sends.Go(func() {
+						sent := time.Now()
+						_, errs[i] = producer.Send(ctx, topic, nil, payload)
+						batch[i] = time.Since(sent)
+					})
+				}
+				sends.Wait()
+
+				mu.Lock()
+				latencies = append(latencies, batch[:n]...)
+				if err := errors.Join(errs[:n]...); err != nil && failed == nil {
+					failed = err
+				}
+				mu.Unlock()
+			}
+		})
+	}
+	wg.Wait()
+	duration := time.Since(start)
+
+	if failed != nil {
+		return benchResult{}, failed
+	}
+	return newBenchResult(messages, int64(messages)*int64(payloadSize), duration, latencies), nil
+}
+
+func benchConsumeCommand(fs *flag.FlagSet) runFunc {
+	flags := newBenchFlags(fs)
+	batchBytes := fs.Int("batch-bytes", 1<<20, "bytes a consumer polls from each partition at once")
+
+	return func(c *cli, args []string) error {
+		if len(args) > 0 {
+			return usagef("unexpected arguments %q", args)
+		}
+		if err := flags.validate(); err != nil {
+			return err
+		}
+		if *batchBytes <= 0 {
+			return usagef("--batch-bytes must be positive")
+		}
+
+		b, closeBroker, err := flags.openBroker(c, storage.DurabilityMedium)
+		if err != nil {
+			return err
+		}
+		result, err := benchConsume(c.ctx, b, *flags.topic, *flags.partitions, *flags.messages, *flags.payloadSize, *batchBytes, *flags.concurrency)
+		if err := errors.Join(err, closeBroker()); err != nil {
+			return err
+		}
+		return result.write(c, *flags.output, "POLL")
+	}
+}
+
+// benchConsume produces the messages, then times consumers of a group reading
+// them back, each consuming its share of the partitions.
+func benchConsume(ctx context.Context, b *brain.Broker, topic string, partitions, messages, payloadSize, batchBytes, concurrency int) (benchResult, error) {
+	if _, err := benchProduce(ctx, b, topic, messages, payloadSize, 64, 4); err != nil {
+		return benchResult{}, fmt.Errorf("failed to produce the messages to consume: %w", err)
+	}
+
+	consumers := make([]*client.Consumer, 0, concurrency)
+	for i := range min(concurrency, partitions) {
+		config := client.ConsumerConfig{Group: "bench", Topic: topic, MaxPartitionBytes: batchBytes}
+		for n := i; n < partitions; n += concurrency {
+			config.Partitions = append(config.Partitions, n)
+		}
+		consumer, err := client.NewConsumer(b, config)
+		if err != nil {
+			return benchResult{}, err
+		}
+		consumers = append(consumers, consumer)
+	}
+
+	var mu sync.Mutex
+	var errs []error
+	var latencies []time.Duration
+	consumed := 0
+	start := time.Now()
+	var wg sync.WaitGroup
+	for _, consumer := range consumers {
+		wg.Go(func() {
+			var polls []time.Duration
+			n := 0
+			for {
+				polled := time.Now()
+				records, err := consumer.Poll(ctx)
+				if err != nil {
+					mu.Lock()
+					errs = append(errs, err)
+					mu.Unlock()
+					return
+				}
+				if len(records) == 0 {
+					break
+				}
+				polls = append(polls, time.Since(polled))
+				n += len(records)
+			}
+			mu.Lock()
+			latencies = append(latencies, polls...)
+			consumed += n
+			mu.Unlock()
+		})
+	}
+	wg.Wait()
+	duration := time.Since(start)
+
+	if len(errs) > 0 {
+		return benchResult{}, errs[0]
+	}
+	return newBenchResult(consumed, int64(consumed)*int64(payloadSize), duration, latencies), nil
+}
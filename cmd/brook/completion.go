@@ -0,0 +1,70 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+func completionCommand(fs *flag.FlagSet) runFunc {
+	return func(c *cli, args []string) error {
+		if len(args) != 1 || args[0] != "bash" {
+			return usagef("only bash completion is supported")
+		}
+		_, err := fmt.Fprint(c.stdout, bashCompletion(c.commands))
+		return err
+	}
+}
+
+// bashCompletion generates the completion script from commands, so it never
+// falls behind them. Load it with: source <(brook completion bash)
+func bashCompletion(commands []command) string {
+	var top []string
+	groups := make(map[string][]string)
+	var cases strings.Builder
+	for _, cmd := range commands {
+		words := strings.Fields(cmd.name)
+		if !slices.Contains(top, words[0]) {
+			top = append(top, words[0])
+		}
+		if len(words) == 2 {
+			groups[words[0]] = append(groups[words[0]], words[1])
+		}
+
+		fs := flag.NewFlagSet(cmd.name, flag.ContinueOnError)
+		cmd.setup(fs)
+		var flags []string
+		fs.VisitAll(func(f *flag.Flag) {
+			flags = append(flags, "--"+f.Name)
+		})
+		fmt.Fprintf(&cases, "\t\t%q) words=%q ;;\n", cmd.name, strings.Join(flags, " "))
+	}
+
+	var groupCases strings.Builder
+	for _, group := range top {
+		if subs, ok := groups[group]; ok {
+			fmt.Fprintf(&groupCases, "\t%s) sub=2; [[ $COMP_CWORD -eq 2 ]] && words=%q ;;\n", group, strings.Join(subs, " "))
+		}
+	}
+
+	return fmt.Sprintf(`# bash completion for brook
+_brook() {
+	local cur=${COMP_WORDS[COMP_CWORD]} words="" sub=1
+	if [[ $COMP_CWORD -eq 1 ]]; then
+		COMPREPLY=($(compgen -W %q -- "$cur"))
+		return
+	fi
+	case ${COMP_WORDS[1]} in
+%s	esac
+	if [[ -z $words ]]; then
+		local cmd=${COMP_WORDS[1]}
+		[[ $sub -eq 2 ]] && cmd="$cmd ${COMP_WORDS[2]}"
+		case $cmd in
+%s		esac
+	fi
+	COMPREPLY=($(compgen -W "$words" -- "$cur"))
+}
+complete -o default -F _brook brook
+`, strings.Join(top, " "), groupCases.String(), cases.String())
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/mvaleed/brook/internal/config"
+)
+
+func configValidateCommand(fs *flag.FlagSet) runFunc {
+	return func(c *cli, args []string) error {
+		if len(args) != 1 {
+			return usagef("expected a single config file")
+		}
+
+		// The overrides of the environment count, they are part of the
+		// config the broker would start with
+		if _, err := config.Load(args[0], os.Environ()); err != nil {
+			return err
+		}
+		_, err := fmt.Fprintf(c.stdout, "%s is valid\n", args[0])
+		return err
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/mvaleed/brook/internal/brain"
+)
+
+func consumeCommand(fs *flag.FlagSet) runFunc {
+	dataDir := dataDirFlag(fs)
+	output := outputFlag(fs)
+	topicName := fs.String("topic", "", "topic to read (required)")
+	group := fs.String("group", "", "subscription to read as, resuming where it last stopped and committing what was printed")
+	fromBeginning := fs.Bool("from-beginning", false, "without --group, start at the first message instead of the end of the topic")
+	offset := fs.Int("offset", -1, "without --group, start at this offset")
+	maxMessages := fs.Int("max-messages", 0, "stop after this many messages, 0 for no limit")
+
+	return func(c *cli, args []string) error {
+		if *topicName == "" {
+			return usagef("--topic is required")
+		}
+		if len(args) > 0 {
+			return usagef("unexpected arguments %q", args)
+		}
+		if *group != "" && (*fromBeginning || *offset >= 0) {
+			return usagef("--group resumes where the subscription stopped, it can't be combined with --from-beginning or --offset")
+		}
+		if *fromBeginning && *offset >= 0 {
+			return usagef("--from-beginning and --offset are mutually exclusive")
+		}
+
+		ps, err := c.openPubSub(*dataDir)
+		if err != nil {
+			return err
+		}
+		err = consume(c, ps, *topicName, *group, *fromBeginning, *offset, *maxMessages, *output)
+		return errors.Join(err, ps.Close())
+	}
+}
+
+func consume(c *cli, ps *brain.PubSub, topicName, group string, fromBeginning bool, offset int, maxMessages int, output outputFormat) error {
+	topic, err := existingTopic(ps, topicName)
+	if err != nil {
+		return err
+	}
+
+	name := group
+	if name == "" {
+		// Never committed, so it leaves nothing behind
+		name = fmt.Sprintf("console-%d", os.Getpid())
+	}
+	sub, err := topic.Subscribe(name)
+	if err != nil {
+		return err
+	}
+	defer sub.Close()
+
+	if group == "" {
+		switch {
+		case fromBeginning:
+			sub.Seek(0)
+		case offset >= 0:
+			sub.Seek(offset)
+		default:
+			sub.Seek(topic.EndOffset())
+		}
+	}
+
+	// Nothing can be published to the data dir while it's open here, so
+	// consume stops once it has caught up: with a canceled context, Next
+	// returns the messages there are and fails instead of waiting for more.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	var rows [][]string
+	// Stopping early still commits what was printed
+	for consumed := 0; (maxMessages == 0 || consumed < maxMessages) && c.ctx.Err() == nil; consumed++ {
+		msg, err := sub.Next(ctx)
+		if errors.Is(err, context.Canceled) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		if output == outputJSON {
+			// One message per line, so a consumer doesn't have to wait for the end
+			err = writeJSON(c.stdout, struct {
+				Offset    int       `json:"offset"`
+				Timestamp time.Time `json:"timestamp"`
+				Data      string    `json:"data"`
+			}{msg.Offset, msg.Timestamp, string(msg.Data)})
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		rows = append(rows, []string{
+			strconv.Itoa(msg.Offset),
+			msg.Timestamp.UTC().Format(time.RFC3339Nano),
+			string(msg.Data),
+		})
+	}
+
+	if output == outputTable {
+		if err := writeTable(c.stdout, []string{"OFFSET", "TIMESTAMP", "DATA"}, rows); err != nil {
+			return err
+		}
+	}
+	if group != "" {
+		return sub.Commit()
+	}
+	return nil
+}
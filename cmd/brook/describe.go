@@ -0,0 +1,75 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/mvaleed/brook/internal/storage"
+)
+
+// describedTopic is a topic as brook describe prints it in JSON.
+type describedTopic struct {
+	Topic string `json:"topic"`
+	storage.PartitionStats
+}
+
+func describeCommand(fs *flag.FlagSet) runFunc {
+	dataDir := dataDirFlag(fs)
+	output := outputFlag(fs)
+
+	return func(c *cli, args []string) error {
+		if len(args) == 0 {
+			return usagef("expected at least one topic")
+		}
+
+		sources, err := c.openSources(*dataDir, args)
+		var topics []describedTopic
+		for _, source := range sources {
+			if err == nil {
+				var stats storage.PartitionStats
+				stats, err = source.Partition.Stats()
+				topics = append(topics, describedTopic{Topic: source.Name, PartitionStats: stats})
+			}
+			err = errors.Join(err, source.Partition.Close())
+		}
+		if err != nil {
+			return err
+		}
+
+		if *output == outputJSON {
+			return writeJSON(c.stdout, topics)
+		}
+		rows := make([][]string, len(topics))
+		for i, topic := range topics {
+			rows[i] = append([]string{topic.Topic}, statsCells(topic.PartitionStats)...)
+		}
+		return writeTable(c.stdout, append([]string{"TOPIC"}, statsHeader...), rows)
+	}
+}
+
+// statsHeader heads the columns of statsCells.
+var statsHeader = []string{"SEGMENTS", "BYTES", "INDEX BYTES", "OFFSETS", "OLDEST", "NEWEST", "ACTIVE FILL"}
+
+// statsCells are the table cells of the stats of a partition.
+func statsCells(stats storage.PartitionStats) []string {
+	return []string{
+		strconv.Itoa(stats.Segments),
+		strconv.FormatInt(stats.Bytes, 10),
+		strconv.FormatInt(stats.IndexBytes, 10),
+		fmt.Sprintf("%d-%d", stats.FirstOffset, stats.NextOffset),
+		timestampCell(stats.OldestTimestamp),
+		timestampCell(stats.NewestTimestamp),
+		fmt.Sprintf("%.1f%%", stats.ActiveSegmentFill*100),
+	}
+}
+
+// timestampCell is a timestamp as a table cell, - for none.
+func timestampCell(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.UTC().Format(time.RFC3339Nano)
+}
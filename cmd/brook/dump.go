@@ -0,0 +1,106 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mvaleed/brook/internal/storage"
+)
+
+// dumpedRecord is a storage.SegmentEntry as it's printed.
+type dumpedRecord struct {
+	Position    int64                       `json:"position"`
+	Offset      *int                        `json:"offset,omitempty"`
+	Size        uint64                      `json:"size"`
+	Timestamp   *time.Time                  `json:"timestamp,omitempty"`
+	ExpiresAt   *time.Time                  `json:"expires_at,omitempty"`
+	Payload     string                      `json:"payload"`
+	Diagnostics []storage.SegmentDiagnostic `json:"diagnostics,omitempty"`
+}
+
+func dumpCommand(fs *flag.FlagSet) runFunc {
+	output := outputFlag(fs)
+	head := fs.Int("head", 0, "only print the first n records, 0 for all")
+	maxPayload := fs.Int("max-payload", 100, "in table output, cut payloads after this many bytes")
+
+	return func(c *cli, args []string) error {
+		if len(args) != 1 {
+			return usagef("expected a single segment file")
+		}
+
+		var rows [][]string
+		records, problems := 0, 0
+		for entry, err := range storage.InspectSegment(args[0]) {
+			if err != nil {
+				return err
+			}
+			records++
+			problems += len(entry.Diagnostics)
+
+			if *output == outputJSON {
+				// One record per line, segments can be large
+				if err := writeJSON(c.stdout, newDumpedRecord(entry)); err != nil {
+					return err
+				}
+			} else {
+				rows = append(rows, dumpRow(entry, *maxPayload))
+			}
+			if records == *head {
+				break
+			}
+		}
+
+		if *output == outputJSON {
+			return nil
+		}
+		if err := writeTable(c.stdout, []string{"BYTE", "OFFSET", "SIZE", "TIMESTAMP", "PAYLOAD", "PROBLEMS"}, rows); err != nil {
+			return err
+		}
+		_, err := fmt.Fprintf(c.stdout, "%d records, %d problems\n", records, problems)
+		return err
+	}
+}
+
+func newDumpedRecord(entry storage.SegmentEntry) dumpedRecord {
+	dumped := dumpedRecord{Position: entry.Position, Diagnostics: entry.Diagnostics}
+	if entry.Record == nil {
+		return dumped
+	}
+
+	h := entry.Record.Header
+	timestamp := time.Unix(0, int64(h.Timestamp)).UTC()
+	dumped.Offset = &entry.Offset
+	dumped.Size = h.PayloadSize
+	dumped.Timestamp = &timestamp
+	if h.ExpiresAt != 0 {
+		expiresAt := time.Unix(0, int64(h.ExpiresAt)).UTC()
+		dumped.ExpiresAt = &expiresAt
+	}
+	dumped.Payload = string(entry.Record.Payload)
+	return dumped
+}
+
+func dumpRow(entry storage.SegmentEntry, maxPayload int) []string {
+	problems := make([]string, len(entry.Diagnostics))
+	for i, d := range entry.Diagnostics {
+		problems[i] = fmt.Sprintf("%s: %s", d.Kind, d.Detail)
+	}
+	row := []string{strconv.FormatInt(entry.Position, 10), "-", "-", "-", "-", strings.Join(problems, "; ")}
+	if entry.Record == nil {
+		return row
+	}
+
+	h := entry.Record.Header
+	payload := entry.Record.Payload
+	if len(payload) > maxPayload {
+		payload = payload[:maxPayload]
+	}
+	row[1] = strconv.Itoa(entry.Offset)
+	row[2] = strconv.FormatUint(h.PayloadSize, 10)
+	row[3] = time.Unix(0, int64(h.Timestamp)).UTC().Format(time.RFC3339Nano)
+	row[4] = strconv.Quote(string(payload))
+	return row
+}
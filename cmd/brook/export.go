@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+
+	"github.com/mvaleed/brook/internal/export"
+	"github.com/mvaleed/brook/internal/query"
+)
+
+func exportCommand(fs *flag.FlagSet) runFunc {
+	dataDir := dataDirFlag(fs)
+	topicName := fs.String("topic", "", "topic to export (required)")
+	format := fs.String("format", string(export.JSONL), "file format, jsonl, csv or parquet")
+	file := fs.String("file", "-", "file to write, - for stdout")
+	fromOffset := fs.Int("from-offset", 0, "first offset to export")
+	toOffset := fs.Int("to-offset", 0, "offset to stop exporting at, 0 for the end of the topic")
+	var since, until timeFlag
+	fs.Var(&since, "since", "only export records written at or after this RFC 3339 time")
+	fs.Var(&until, "until", "only export records written before this RFC 3339 time")
+
+	return func(c *cli, args []string) error {
+		if *topicName == "" {
+			return usagef("--topic is required")
+		}
+		if len(args) > 0 {
+			return usagef("unexpected arguments %q", args)
+		}
+		if *fromOffset < 0 || *toOffset < 0 {
+			return usagef("--from-offset and --to-offset can't be negative")
+		}
+		if !slices.Contains(export.Formats, export.Format(*format)) {
+			return usagef("invalid --format %q, expected one of %v", *format, export.Formats)
+		}
+
+		sources, err := c.openSources(*dataDir, []string{*topicName})
+		defer func() {
+			for _, source := range sources {
+				source.Partition.Close()
+			}
+		}()
+		if err != nil {
+			return err
+		}
+
+		if *file == "-" {
+			_, err := exportTopic(c.ctx, c.stdout, sources, *format, *fromOffset, *toOffset, since, until)
+			return err
+		}
+		f, err := os.Create(*file)
+		if err != nil {
+			return err
+		}
+		n, err := exportTopic(c.ctx, f, sources, *format, *fromOffset, *toOffset, since, until)
+		if err := errors.Join(err, f.Close()); err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(c.stdout, "exported %d records to %s\n", n, *file)
+		return err
+	}
+}
+
+func exportTopic(ctx context.Context, out io.Writer, sources []query.Source, format string, fromOffset, toOffset int, since, until timeFlag) (int, error) {
+	w, err := export.NewWriter(out, export.Format(format))
+	if err != nil {
+		return 0, err
+	}
+	q := query.Query{FromOffset: fromOffset, ToOffset: toOffset, Since: since.t, Until: until.t}
+	return export.Copy(w, query.Run(ctx, sources, q))
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strconv"
+)
+
+func groupsListCommand(fs *flag.FlagSet) runFunc {
+	client := adminFlags(fs)
+	output := outputFlag(fs)
+
+	return func(c *cli, args []string) error {
+		if len(args) > 0 {
+			return usagef("unexpected arguments %q", args)
+		}
+
+		groups, err := client().ListGroups(c.ctx)
+		if err != nil {
+			return err
+		}
+		if *output == outputJSON {
+			return writeJSON(c.stdout, groups)
+		}
+		rows := make([][]string, len(groups))
+		for i, group := range groups {
+			rows[i] = []string{group}
+		}
+		return writeTable(c.stdout, []string{"GROUP"}, rows)
+	}
+}
+
+func groupsDescribeCommand(fs *flag.FlagSet) runFunc {
+	client := adminFlags(fs)
+	output := outputFlag(fs)
+
+	return func(c *cli, args []string) error {
+		if len(args) != 1 {
+			return usagef("expected a single consumer group")
+		}
+
+		d, err := client().DescribeGroup(c.ctx, args[0])
+		if err != nil {
+			return err
+		}
+		if *output == outputJSON {
+			return writeJSON(c.stdout, d)
+		}
+		rows := make([][]string, len(d.Partitions))
+		for i, lag := range d.Partitions {
+			rows[i] = []string{
+				lag.Topic,
+				strconv.Itoa(lag.Partition),
+				strconv.Itoa(lag.CommittedOffset),
+				strconv.Itoa(lag.EndOffset),
+				strconv.Itoa(lag.Lag),
+			}
+		}
+		if err := writeTable(c.stdout, []string{"TOPIC", "PARTITION", "COMMITTED", "END OFFSET", "LAG"}, rows); err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(c.stdout, "\ntotal lag %d\n", d.Lag)
+		return err
+	}
+}
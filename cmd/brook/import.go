@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+
+	"github.com/mvaleed/brook/internal/storage"
+)
+
+var bulkFormats = []storage.BulkFormat{storage.BulkRaw, storage.BulkJSONL, storage.BulkCSV}
+
+func importCommand(fs *flag.FlagSet) runFunc {
+	dataDir := dataDirFlag(fs)
+	output := outputFlag(fs)
+	topicName := fs.String("topic", "", "topic to load into, created if it doesn't exist (required)")
+	format := fs.String("format", string(storage.BulkRaw), "file format: raw for a message per line, jsonl, or csv with a payload column or a message per row")
+	file := fs.String("file", "-", "file to load, - for stdin")
+
+	return func(c *cli, args []string) error {
+		if *topicName == "" {
+			return usagef("--topic is required")
+		}
+		if len(args) > 0 {
+			return usagef("unexpected arguments %q", args)
+		}
+		if !slices.Contains(bulkFormats, storage.BulkFormat(*format)) {
+			return usagef("invalid --format %q, expected one of %v", *format, bulkFormats)
+		}
+
+		var in io.Reader = c.stdin
+		if *file != "-" {
+			f, err := os.Open(*file)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			in = f
+		}
+
+		p, err := storage.Paths{Data: *dataDir}.OpenPartition(*topicName, c.partitionConfig())
+		if err != nil {
+			return err
+		}
+		imported, err := p.BulkAppend(ctxReader{c.ctx, in}, storage.BulkFormat(*format))
+		if err := errors.Join(err, p.Close()); err != nil {
+			return fmt.Errorf("imported %d messages: %w", imported, err)
+		}
+
+		if *output == outputJSON {
+			return writeJSON(c.stdout, struct {
+				Topic    string `json:"topic"`
+				Messages int    `json:"messages"`
+			}{*topicName, imported})
+		}
+		_, err = fmt.Fprintf(c.stdout, "imported %d messages to %s\n", imported, *topicName)
+		return err
+	}
+}
+
+// ctxReader fails reads once ctx is canceled, so that BulkAppend stops with
+// what it read appended.
+type ctxReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r ctxReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
@@ -1,15 +1,216 @@
+// Command brook is the command line tool of brook. The serve command runs a
+// broker, the admin commands talk to the admin API of one, and every other
+// command works directly on a local data directory, which must not be in use
+// by another process at the same time.
 package main
 
-import "fmt"
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/mvaleed/brook/internal/brain"
+	"github.com/mvaleed/brook/internal/storage"
+)
+
+// Exit codes are part of the interface, scripts rely on them.
+const (
+	exitOK    = 0
+	exitError = 1 // the command failed
+	exitUsage = 2 // the command line is invalid
+)
+
+// runFunc runs a command with the arguments left after its flags.
+type runFunc func(c *cli, args []string) error
+
+type command struct {
+	name    string // one word, or a group and a word such as "topics list"
+	args    string // positional arguments, for the usage line
+	summary string
+	// setup registers the command's flags on a fresh flag set and returns the
+	// function running the command with them.
+	setup func(fs *flag.FlagSet) runFunc
+}
+
+var commands = []command{
+	{name: "serve", summary: "run a broker serving its admin API, configured by a file", setup: serveCommand},
+	{name: "produce", summary: "publish every line of stdin to a topic", setup: produceCommand},
+	{name: "consume", summary: "print the messages of a topic", setup: consumeCommand},
+	{name: "topics create", args: "TOPIC...", summary: "create topics", setup: topicsCreateCommand},
+	{name: "topics list", summary: "list topics", setup: topicsListCommand},
+	{name: "describe", args: "TOPIC...", summary: "print the disk usage, offsets and timestamps of topics", setup: describeCommand},
+	{name: "admin topics", summary: "list the topics of a broker and their configs through its admin API", setup: adminTopicsCommand},
+	{name: "admin describe", args: "TOPIC", summary: "print the config and partitions of a topic through a broker's admin API", setup: adminDescribeCommand},
+	{name: "admin alter-config", args: "TOPIC", summary: "change the config of a topic through a broker's admin API", setup: adminAlterConfigCommand},
+	{name: "admin delete-records", args: "TOPIC", summary: "delete the records of a partition before an offset through a broker's admin API", setup: adminDeleteRecordsCommand},
+	{name: "admin reconfigure", summary: "change the default retention or the log level of a running broker through its admin API", setup: adminReconfigureCommand},
+	{name: "groups list", summary: "list the consumer groups of a broker through its admin API", setup: groupsListCommand},
+	{name: "groups describe", args: "GROUP", summary: "print the committed offsets and lag of a consumer group through a broker's admin API", setup: groupsDescribeCommand},
+	{name: "config validate", args: "FILE", summary: "check a broker config file, with the overrides of the environment", setup: configValidateCommand},
+	{name: "verify", summary: "check the segments and indexes of a topic offline", setup: verifyCommand},
+	{name: "dump", args: "SEGMENT", summary: "print the records of a segment file", setup: dumpCommand},
+	{name: "query", args: "TOPIC...", summary: "print the records of topics matching conditions on their JSON payloads", setup: queryCommand},
+	{name: "export", summary: "write the records of a topic to a JSON lines, CSV or Parquet file", setup: exportCommand},
+	{name: "import", summary: "load the messages of a raw, JSON lines or CSV file into a topic", setup: importCommand},
+	{name: "bench produce", summary: "measure the throughput and latency of producing to a scratch broker", setup: benchProduceCommand},
+	{name: "bench consume", summary: "measure the throughput and latency of consuming from a scratch broker", setup: benchConsumeCommand},
+	{name: "completion", args: "bash", summary: "print a shell completion script", setup: completionCommand},
+}
+
+type cli struct {
+	// ctx is canceled once brook is asked to stop, for commands to finish
+	// what is in flight and close what they opened
+	ctx      context.Context
+	commands []command
+	stdin    io.Reader
+	stdout   io.Writer
+	stderr   io.Writer
+}
+
+// usageError is a mistake in the command line rather than a failure.
+type usageError struct {
+	msg string
+}
+
+func (e usageError) Error() string {
+	return e.msg
+}
+
+func usagef(format string, args ...any) error {
+	return usageError{msg: fmt.Sprintf(format, args...)}
+}
 
 func main() {
-	mySlice := make([]int, 4, 5)
-	fmt.Println(mySlice)
-	change(mySlice)
-	fmt.Println(mySlice)
+	// The first SIGINT or SIGTERM asks the command to stop gracefully, a
+	// second one kills brook as usual
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		stop()
+		fmt.Fprintln(os.Stderr, "brook: stopping, interrupt again to exit right away")
+	}()
+	os.Exit(run(ctx, os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// run runs the command line args and returns the exit code. Commands stop
+// early once ctx is canceled, still leaving what they wrote durable.
+func run(ctx context.Context, args []string, stdin io.Reader, stdout io.Writer, stderr io.Writer) int {
+	c := &cli{ctx: ctx, commands: commands, stdin: stdin, stdout: stdout, stderr: stderr}
+
+	if len(args) == 0 || args[0] == "help" || args[0] == "-h" || args[0] == "--help" {
+		c.usage(stdout)
+		return exitOK
+	}
+
+	cmd, rest, ok := findCommand(args)
+	if !ok {
+		fmt.Fprintf(stderr, "brook: unknown command %q\n\n", strings.Join(args[:min(len(args), 2)], " "))
+		c.usage(stderr)
+		return exitUsage
+	}
+
+	fs := flag.NewFlagSet("brook "+cmd.name, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprintf(stderr, "Usage: brook %s [flags] %s\n\n%s.\n\nFlags:\n", cmd.name, cmd.args, capitalize(cmd.summary))
+		fs.PrintDefaults()
+	}
+	runCmd := cmd.setup(fs)
+	if err := fs.Parse(rest); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitOK
+		}
+		return exitUsage
+	}
+
+	err := runCmd(c, fs.Args())
+	var usageErr usageError
+	switch {
+	case err == nil:
+		return exitOK
+	case errors.As(err, &usageErr):
+		fmt.Fprintf(stderr, "brook %s: %s\n", cmd.name, err)
+		fs.Usage()
+		return exitUsage
+	default:
+		fmt.Fprintf(stderr, "brook %s: %s\n", cmd.name, err)
+		return exitError
+	}
+}
+
+// findCommand returns the command named by the first words of args and the
+// arguments after its name.
+func findCommand(args []string) (command, []string, bool) {
+	for _, cmd := range commands {
+		words := strings.Fields(cmd.name)
+		if len(args) >= len(words) && strings.Join(args[:len(words)], " ") == cmd.name {
+			return cmd, args[len(words):], true
+		}
+	}
+	return command{}, nil, false
+}
+
+func (c *cli) usage(w io.Writer) {
+	fmt.Fprintf(w, "Usage: brook COMMAND [flags]\n\nCommands:\n")
+	width := 0
+	for _, cmd := range commands {
+		width = max(width, len(cmd.name))
+	}
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "  %-*s %s\n", width, cmd.name, cmd.summary)
+	}
+	fmt.Fprintf(w, "\nRun 'brook COMMAND --help' for the flags of a command.\n")
+	fmt.Fprintf(w, "\nExit codes: %d on success, %d if the command failed, %d if the command line is invalid.\n", exitOK, exitError, exitUsage)
+}
+
+func capitalize(s string) string {
+	if s == "" {
+		return s
+	}
+	return strings.ToUpper(s[:1]) + s[1:]
+}
+
+// dataDirFlag registers the flag choosing the data directory, which defaults
+// to $BROOK_DATA_DIR.
+func dataDirFlag(fs *flag.FlagSet) *string {
+	dir := os.Getenv("BROOK_DATA_DIR")
+	if dir == "" {
+		dir = "data"
+	}
+	return fs.String("data-dir", dir, "data directory of the topics (default from $BROOK_DATA_DIR)")
+}
+
+// openPubSub opens the topics in dataDir.
+func (c *cli) openPubSub(dataDir string) (*brain.PubSub, error) {
+	return brain.OpenPubSub(storage.Paths{Data: dataDir}, c.partitionConfig())
+}
+
+// partitionConfig is the config of the partitions of topics. Storage only
+// gets to log warnings and errors, to stderr.
+func (c *cli) partitionConfig() storage.PartitionConfig {
+	config := storage.DefaultPartitionConfig()
+	config.Logger = slog.New(slog.NewTextHandler(c.stderr, &slog.HandlerOptions{Level: slog.LevelWarn}))
+	return config
 }
 
-func change(s []int) {
-	s = append(s, 1, 2)
-	s[3] = 10
+// existingTopic opens the topic called name, failing instead of creating it
+// if it doesn't exist.
+func existingTopic(ps *brain.PubSub, name string) (*brain.Topic, error) {
+	names, err := ps.Topics()
+	if err != nil {
+		return nil, err
+	}
+	for _, existing := range names {
+		if existing == name {
+			return ps.Topic(name)
+		}
+	}
+	return nil, fmt.Errorf("topic %s does not exist", name)
 }
@@ -0,0 +1,502 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/mvaleed/brook/internal/admin"
+	"github.com/mvaleed/brook/internal/brain"
+	"github.com/mvaleed/brook/internal/storage"
+)
+
+func runBrook(t *testing.T, stdin string, args ...string) (string, string, int) {
+	t.Helper()
+	var stdout, stderr bytes.Buffer
+	code := run(context.Background(), args, strings.NewReader(stdin), &stdout, &stderr)
+	return stdout.String(), stderr.String(), code
+}
+
+// interruptingReader reads data, then cancels the command's context with
+// interrupt and blocks until released.
+type interruptingReader struct {
+	data      []byte
+	interrupt func()
+	once      sync.Once
+	released  chan struct{}
+}
+
+func (r *interruptingReader) Read(p []byte) (int, error) {
+	if len(r.data) > 0 {
+		n := copy(p, r.data)
+		r.data = r.data[n:]
+		return n, nil
+	}
+	r.once.Do(func() { r.released = make(chan struct{}) })
+	r.interrupt()
+	<-r.released
+	return 0, io.EOF
+}
+
+func (r *interruptingReader) release() {
+	r.once.Do(func() { r.released = make(chan struct{}) })
+	close(r.released)
+}
+
+// syncBuffer is a bytes.Buffer a command can write to while the test reads it.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// freeAddress returns a localhost address nothing listens on.
+func freeAddress(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	address := ln.Addr().String()
+	require.NoError(t, ln.Close())
+	return address
+}
+
+func TestCLI(t *testing.T) {
+	t.Run("produce and consume", func(t *testing.T) {
+		dir := t.TempDir()
+
+		stdout, stderr, code := runBrook(t, "first\nsecond\r\nthird", "produce", "--data-dir", dir, "--topic", "orders")
+		require.Equal(t, exitOK, code, stderr)
+		require.Equal(t, "published 3 messages to orders\n", stdout)
+
+		// Starts at the end of the topic by default
+		stdout, _, code = runBrook(t, "", "consume", "--data-dir", dir, "--topic", "orders")
+		require.Equal(t, exitOK, code)
+		require.Equal(t, "OFFSET  TIMESTAMP  DATA\n", stdout)
+
+		stdout, _, code = runBrook(t, "", "consume", "--data-dir", dir, "--topic", "orders", "--offset", "1", "--output", "json")
+		require.Equal(t, exitOK, code)
+		lines := strings.Split(strings.TrimSpace(stdout), "\n")
+		require.Len(t, lines, 2)
+		var msg struct {
+			Offset int    `json:"offset"`
+			Data   string `json:"data"`
+		}
+		require.NoError(t, json.Unmarshal([]byte(lines[1]), &msg))
+		require.Equal(t, 2, msg.Offset)
+		require.Equal(t, "third", msg.Data)
+	})
+
+	t.Run("groups resume where they stopped", func(t *testing.T) {
+		dir := t.TempDir()
+		_, _, code := runBrook(t, "a\nb\nc\n", "produce", "--data-dir", dir, "--topic", "orders")
+		require.Equal(t, exitOK, code)
+
+		consume := func(args ...string) string {
+			stdout, stderr, code := runBrook(t, "", append([]string{"consume", "--data-dir", dir, "--topic", "orders", "--group", "billing"}, args...)...)
+			require.Equal(t, exitOK, code, stderr)
+			return stdout
+		}
+		require.Contains(t, consume("--max-messages", "2"), "b\n")
+		out := consume()
+		require.Contains(t, out, "c\n")
+		require.NotContains(t, out, "b\n")
+	})
+
+	t.Run("produce stops when interrupted", func(t *testing.T) {
+		dir := t.TempDir()
+		ctx, cancel := context.WithCancel(context.Background())
+		// Two lines, then stdin blocks like an idle pipe until brook is
+		// interrupted
+		stdin := &interruptingReader{data: []byte("first\nsecond\n"), interrupt: cancel}
+		defer stdin.release()
+
+		var stdout, stderr bytes.Buffer
+		code := run(ctx, []string{"produce", "--data-dir", dir, "--topic", "orders"}, stdin, &stdout, &stderr)
+		require.Equal(t, exitOK, code, stderr.String())
+		require.Equal(t, "published 2 messages to orders\n", stdout.String())
+
+		out, _, code := runBrook(t, "", "consume", "--data-dir", dir, "--topic", "orders", "--from-beginning")
+		require.Equal(t, exitOK, code)
+		require.Contains(t, out, "second")
+	})
+
+	t.Run("topics", func(t *testing.T) {
+		dir := t.TempDir()
+
+		stdout, _, code := runBrook(t, "", "topics", "list", "--data-dir", dir, "--output", "json")
+		require.Equal(t, exitOK, code)
+		require.Equal(t, "[]\n", stdout)
+
+		stdout, _, code = runBrook(t, "", "topics", "create", "--data-dir", dir, "payments", "orders")
+		require.Equal(t, exitOK, code)
+		require.Equal(t, "created topic payments\ncreated topic orders\n", stdout)
+
+		_, stderr, code := runBrook(t, "", "topics", "create", "--data-dir", dir, "orders")
+		require.Equal(t, exitError, code)
+		require.Contains(t, stderr, "topic orders already exists")
+
+		_, _, code = runBrook(t, "x\n", "produce", "--data-dir", dir, "--topic", "orders")
+		require.Equal(t, exitOK, code)
+		stdout, _, code = runBrook(t, "", "topics", "list", "--data-dir", dir)
+		require.Equal(t, exitOK, code)
+		require.Equal(t, "TOPIC     END OFFSET\norders    1\npayments  0\n", stdout)
+	})
+
+	t.Run("exit codes", func(t *testing.T) {
+		dir := t.TempDir()
+
+		_, _, code := runBrook(t, "", "unknown")
+		require.Equal(t, exitUsage, code)
+		_, _, code = runBrook(t, "", "produce", "--data-dir", dir)
+		require.Equal(t, exitUsage, code)
+		_, _, code = runBrook(t, "", "consume", "--data-dir", dir, "--topic", "orders", "--output", "yaml")
+		require.Equal(t, exitUsage, code)
+		_, _, code = runBrook(t, "", "consume", "--data-dir", dir, "--topic", "orders", "--group", "g", "--from-beginning")
+		require.Equal(t, exitUsage, code)
+
+		_, stderr, code := runBrook(t, "", "consume", "--data-dir", dir, "--topic", "orders")
+		require.Equal(t, exitError, code)
+		require.Contains(t, stderr, "topic orders does not exist")
+
+		_, _, code = runBrook(t, "", "produce", "--help")
+		require.Equal(t, exitOK, code)
+	})
+
+	t.Run("verify", func(t *testing.T) {
+		dir := t.TempDir()
+		_, _, code := runBrook(t, "a\nb\n", "produce", "--data-dir", dir, "--topic", "orders")
+		require.Equal(t, exitOK, code)
+
+		stdout, _, code := runBrook(t, "", "verify", "--data-dir", dir, "--topic", "orders")
+		require.Equal(t, exitOK, code)
+		require.Equal(t, "verified 2 records in 1 segments, 0 problems\n", stdout)
+
+		segment := filepath.Join(dir, "orders", "000000000000000.log")
+		require.NoError(t, os.Truncate(segment, storage.SegmentHeaderSize+storage.HeaderSize+1+10))
+		_, _, code = runBrook(t, "", "verify", "--data-dir", dir, "--topic", "orders")
+		require.Equal(t, exitError, code)
+
+		stdout, _, code = runBrook(t, "", "verify", "--data-dir", dir, "--topic", "orders", "--repair", "--output", "json")
+		require.Equal(t, exitOK, code)
+		var report storage.VerifyReport
+		require.NoError(t, json.Unmarshal([]byte(stdout), &report))
+		require.Equal(t, int64(1), report.Records)
+		require.True(t, report.Issues[0].Repaired)
+	})
+
+	t.Run("dump", func(t *testing.T) {
+		dir := t.TempDir()
+		_, _, code := runBrook(t, "a\nb\nc\n", "produce", "--data-dir", dir, "--topic", "orders")
+		require.Equal(t, exitOK, code)
+		segment := filepath.Join(dir, "orders", "000000000000000.log")
+
+		stdout, _, code := runBrook(t, "", "dump", "--head", "2", segment)
+		require.Equal(t, exitOK, code)
+		require.Contains(t, stdout, `"b"`)
+		require.NotContains(t, stdout, `"c"`)
+		require.True(t, strings.HasSuffix(stdout, "2 records, 0 problems\n"))
+
+		// Cut the last record in half
+		require.NoError(t, os.Truncate(segment, storage.SegmentHeaderSize+2*(storage.HeaderSize+1)+10))
+		stdout, _, code = runBrook(t, "", "dump", "--output", "json", segment)
+		require.Equal(t, exitOK, code)
+		lines := strings.Split(strings.TrimSpace(stdout), "\n")
+		require.Len(t, lines, 3)
+		var torn struct {
+			Position    int64
+			Offset      *int
+			Diagnostics []struct{ Kind string }
+		}
+		require.NoError(t, json.Unmarshal([]byte(lines[2]), &torn))
+		require.Nil(t, torn.Offset)
+		require.Equal(t, "torn-tail", torn.Diagnostics[0].Kind)
+	})
+
+	t.Run("describe", func(t *testing.T) {
+		dir := t.TempDir()
+		_, _, code := runBrook(t, "a\nb\nc\n", "produce", "--data-dir", dir, "--topic", "orders")
+		require.Equal(t, exitOK, code)
+
+		stdout, stderr, code := runBrook(t, "", "describe", "--data-dir", dir, "orders")
+		require.Equal(t, exitOK, code, stderr)
+		lines := strings.Split(strings.TrimSpace(stdout), "\n")
+		require.Len(t, lines, 2)
+		require.Equal(t, []string{"orders", "1"}, strings.Fields(lines[1])[:2])
+		require.Equal(t, "0-3", strings.Fields(lines[1])[4])
+
+		stdout, _, code = runBrook(t, "", "describe", "--data-dir", dir, "--output", "json", "orders")
+		require.Equal(t, exitOK, code)
+		var topics []struct {
+			Topic      string
+			Segments   int
+			NextOffset int `json:"next_offset"`
+		}
+		require.NoError(t, json.Unmarshal([]byte(stdout), &topics))
+		require.Len(t, topics, 1)
+		require.Equal(t, 3, topics[0].NextOffset)
+
+		_, _, code = runBrook(t, "", "describe", "--data-dir", dir)
+		require.Equal(t, exitUsage, code)
+		_, stderr, code = runBrook(t, "", "describe", "--data-dir", dir, "payments")
+		require.Equal(t, exitError, code)
+		require.Contains(t, stderr, "does not exist")
+	})
+
+	t.Run("admin", func(t *testing.T) {
+		b, err := brain.OpenBroker(storage.Paths{Data: t.TempDir()}, brain.DefaultBrokerConfig())
+		require.NoError(t, err)
+		defer b.Close()
+		server := httptest.NewServer(admin.NewHandler(b, admin.HandlerConfig{}))
+		defer server.Close()
+		ctx := context.Background()
+		require.NoError(t, b.CreateTopic(ctx, "orders", brain.TopicConfig{Partitions: 2}))
+		for range 3 {
+			require.NoError(t, b.Produce(ctx, "orders", 0, []byte("order")))
+		}
+
+		stdout, stderr, code := runBrook(t, "", "admin", "topics", "--server", server.URL)
+		require.Equal(t, exitOK, code, stderr)
+		require.Equal(t, []string{"orders", "2", "-", "none", "-", "medium", "false", "false"}, strings.Fields(strings.Split(stdout, "\n")[1]))
+
+		stdout, stderr, code = runBrook(t, "", "admin", "alter-config", "--server", server.URL, "--retention", "24h", "--durability", "full", "orders")
+		require.Equal(t, exitOK, code, stderr)
+		require.Contains(t, stdout, "24h0m0s")
+		config, err := b.TopicConfig("orders")
+		require.NoError(t, err)
+		require.Equal(t, 24*time.Hour, config.Retention)
+		require.Equal(t, storage.DurabilityFull, config.Durability)
+		_, _, code = runBrook(t, "", "admin", "alter-config", "--server", server.URL, "orders")
+		require.Equal(t, exitUsage, code)
+
+		stdout, stderr, code = runBrook(t, "", "admin", "delete-records", "--server", server.URL, "--before", "2", "orders")
+		require.Equal(t, exitOK, code, stderr)
+		require.Equal(t, "deleted the records of partition 0 of orders before offset 2\n", stdout)
+
+		stdout, stderr, code = runBrook(t, "", "admin", "describe", "--server", server.URL, "--output", "json", "orders")
+		require.Equal(t, exitOK, code, stderr)
+		var d admin.TopicDescription
+		require.NoError(t, json.Unmarshal([]byte(stdout), &d))
+		require.Len(t, d.Partitions, 2)
+		require.Equal(t, 2, d.Partitions[0].FirstOffset)
+		require.Equal(t, "full", d.Config.Durability)
+
+		_, stderr, code = runBrook(t, "", "admin", "describe", "--server", server.URL, "payments")
+		require.Equal(t, exitError, code)
+		require.Contains(t, stderr, "404")
+
+		require.NoError(t, b.CommitOffset(ctx, "billing", "orders", 0, 1))
+		stdout, stderr, code = runBrook(t, "", "groups", "describe", "--server", server.URL, "billing")
+		require.Equal(t, exitOK, code, stderr)
+		require.Equal(t, []string{"orders", "0", "1", "3", "2"}, strings.Fields(strings.Split(stdout, "\n")[1]))
+		require.Contains(t, stdout, "total lag 2\n")
+		stdout, stderr, code = runBrook(t, "", "groups", "list", "--server", server.URL, "--output", "json")
+		require.Equal(t, exitOK, code, stderr)
+		require.Equal(t, "[\"billing\"]\n", stdout)
+		_, stderr, code = runBrook(t, "", "groups", "describe", "--server", server.URL, "shipping")
+		require.Equal(t, exitError, code)
+		require.Contains(t, stderr, "404")
+
+		stdout, stderr, code = runBrook(t, "", "admin", "reconfigure", "--server", server.URL, "--default-retention", "1h")
+		require.Equal(t, exitOK, code, stderr)
+		require.Equal(t, "reconfigured the broker\n", stdout)
+		_, _, code = runBrook(t, "", "admin", "reconfigure", "--server", server.URL)
+		require.Equal(t, exitUsage, code)
+		// The test handler has no log level to change
+		_, stderr, code = runBrook(t, "", "admin", "reconfigure", "--server", server.URL, "--log-level", "debug")
+		require.Equal(t, exitError, code)
+		require.Contains(t, stderr, "400")
+	})
+
+	t.Run("serve", func(t *testing.T) {
+		dir := t.TempDir()
+		address := freeAddress(t)
+		file := filepath.Join(dir, "brook.yaml")
+		conf := fmt.Sprintf("data_dir: %s\nlog_level: warn\nlisteners:\n  - name: plain\n    address: %s\n", filepath.Join(dir, "data"), address)
+		require.NoError(t, os.WriteFile(file, []byte(conf), 0o644))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		var stderr syncBuffer
+		done := make(chan int)
+		go func() {
+			done <- run(ctx, []string{"serve", "--config", file}, strings.NewReader(""), io.Discard, &stderr)
+		}()
+
+		client := &admin.Client{URL: "http://" + address}
+		require.Eventually(t, func() bool {
+			_, err := client.ListTopics(ctx)
+			return err == nil
+		}, 5*time.Second, 10*time.Millisecond, stderr.String())
+		require.NotContains(t, stderr.String(), "serving", "below the configured level")
+		require.DirExists(t, filepath.Join(dir, "data"))
+		for _, probe := range []string{"/healthz", "/readyz"} {
+			resp, err := http.Get("http://" + address + probe)
+			require.NoError(t, err)
+			resp.Body.Close()
+			require.Equal(t, http.StatusOK, resp.StatusCode, probe)
+		}
+		info := "info"
+		require.NoError(t, client.ReconfigureBroker(ctx, admin.BrokerConfigChange{LogLevel: &info}))
+
+		cancel()
+		require.Equal(t, exitOK, <-done, stderr.String())
+		require.Contains(t, stderr.String(), "stopping")
+
+		_, _, code := runBrook(t, "", "serve")
+		require.Equal(t, exitUsage, code)
+	})
+
+	t.Run("config validate", func(t *testing.T) {
+		file := filepath.Join(t.TempDir(), "brook.yaml")
+		require.NoError(t, os.WriteFile(file, []byte("data_dir: /var/lib/brook\ntopic_defaults:\n  durability: full\n"), 0o644))
+		stdout, stderr, code := runBrook(t, "", "config", "validate", file)
+		require.Equal(t, exitOK, code, stderr)
+		require.Equal(t, file+" is valid\n", stdout)
+
+		t.Setenv("BROOK_TOPIC_DEFAULTS_DURABILITY", "eventually")
+		_, stderr, code = runBrook(t, "", "config", "validate", file)
+		require.Equal(t, exitError, code)
+		require.Contains(t, stderr, "invalid durability")
+
+		_, _, code = runBrook(t, "", "config", "validate")
+		require.Equal(t, exitUsage, code)
+	})
+
+	t.Run("query", func(t *testing.T) {
+		dir := t.TempDir()
+		orders := `{"id": 1, "status": "paid", "total": 120}` + "\n" +
+			`{"id": 2, "status": "new", "total": 15}` + "\n" +
+			`{"id": 3, "status": "paid", "total": 30}` + "\n"
+		_, _, code := runBrook(t, orders, "produce", "--data-dir", dir, "--topic", "orders")
+		require.Equal(t, exitOK, code)
+		_, _, code = runBrook(t, `{"id": 9, "status": "paid"}`+"\n", "produce", "--data-dir", dir, "--topic", "refunds")
+		require.Equal(t, exitOK, code)
+
+		stdout, stderr, code := runBrook(t, "", "query", "--data-dir", dir, "--select", "id,total", "--where", "status=paid", "orders", "refunds")
+		require.Equal(t, exitOK, code, stderr)
+		lines := strings.Split(strings.TrimSpace(stdout), "\n")
+		require.Len(t, lines, 4)
+		require.Equal(t, []string{"TOPIC", "OFFSET", "TIMESTAMP", "ID", "TOTAL"}, strings.Fields(lines[0]))
+		require.Equal(t, []string{"refunds", "0", "9", "-"}, slices.Delete(strings.Fields(lines[3]), 2, 3))
+
+		stdout, _, code = runBrook(t, "", "query", "--data-dir", dir, "--output", "json", "--where", "total<100", "--limit", "1", "orders")
+		require.Equal(t, exitOK, code)
+		var row struct {
+			Offset  int
+			Payload string
+		}
+		require.NoError(t, json.Unmarshal([]byte(stdout), &row))
+		require.Equal(t, 1, row.Offset)
+		require.Contains(t, row.Payload, `"new"`)
+
+		_, _, code = runBrook(t, "", "query", "--data-dir", dir, "--where", "status", "orders")
+		require.Equal(t, exitUsage, code)
+		_, stderr, code = runBrook(t, "", "query", "--data-dir", dir, "payments")
+		require.Equal(t, exitError, code)
+		require.Contains(t, stderr, "does not exist")
+	})
+
+	t.Run("export", func(t *testing.T) {
+		dir := t.TempDir()
+		_, _, code := runBrook(t, "a\nb,c\nd\n", "produce", "--data-dir", dir, "--topic", "orders")
+		require.Equal(t, exitOK, code)
+
+		stdout, stderr, code := runBrook(t, "", "export", "--data-dir", dir, "--topic", "orders", "--from-offset", "1")
+		require.Equal(t, exitOK, code, stderr)
+		lines := strings.Split(strings.TrimSpace(stdout), "\n")
+		require.Len(t, lines, 2)
+		require.Contains(t, lines[0], `"offset":1`)
+		require.Contains(t, lines[0], `"payload":"b,c"`)
+
+		file := filepath.Join(t.TempDir(), "orders.csv")
+		stdout, _, code = runBrook(t, "", "export", "--data-dir", dir, "--topic", "orders", "--format", "csv", "--to-offset", "2", "--file", file)
+		require.Equal(t, exitOK, code)
+		require.Equal(t, "exported 2 records to "+file+"\n", stdout)
+		data, err := os.ReadFile(file)
+		require.NoError(t, err)
+		require.Contains(t, string(data), `"b,c"`)
+
+		stdout, _, code = runBrook(t, "", "export", "--data-dir", dir, "--topic", "orders", "--format", "parquet")
+		require.Equal(t, exitOK, code)
+		require.True(t, strings.HasPrefix(stdout, "PAR1") && strings.HasSuffix(stdout, "PAR1"))
+
+		_, _, code = runBrook(t, "", "export", "--data-dir", dir, "--topic", "orders", "--format", "xml")
+		require.Equal(t, exitUsage, code)
+	})
+
+	t.Run("import", func(t *testing.T) {
+		dir := t.TempDir()
+		stdout, stderr, code := runBrook(t, "{\"id\": 1}\n{\"id\": 2}\n", "import", "--data-dir", dir, "--topic", "orders", "--format", "jsonl")
+		require.Equal(t, exitOK, code, stderr)
+		require.Equal(t, "imported 2 messages to orders\n", stdout)
+
+		// What is exported imports back as it was
+		file := filepath.Join(t.TempDir(), "orders.csv")
+		_, _, code = runBrook(t, "", "export", "--data-dir", dir, "--topic", "orders", "--format", "csv", "--file", file)
+		require.Equal(t, exitOK, code)
+		_, _, code = runBrook(t, "", "import", "--data-dir", dir, "--topic", "copy", "--format", "csv", "--file", file)
+		require.Equal(t, exitOK, code)
+		stdout, _, code = runBrook(t, "", "consume", "--data-dir", dir, "--topic", "copy", "--from-beginning")
+		require.Equal(t, exitOK, code)
+		require.Contains(t, stdout, `{"id": 2}`)
+
+		_, stderr, code = runBrook(t, "{oops\n", "import", "--data-dir", dir, "--topic", "orders", "--format", "jsonl")
+		require.Equal(t, exitError, code)
+		require.Contains(t, stderr, "imported 0 messages")
+		_, _, code = runBrook(t, "", "import", "--data-dir", dir, "--topic", "orders", "--format", "xml")
+		require.Equal(t, exitUsage, code)
+	})
+
+	t.Run("bench", func(t *testing.T) {
+		var result benchResult
+		stdout, stderr, code := runBrook(t, "", "bench", "produce", "--messages", "500", "--partitions", "3", "--concurrency", "4", "--batch-size", "8", "--output", "json")
+		require.Equal(t, exitOK, code, stderr)
+		require.NoError(t, json.Unmarshal([]byte(stdout), &result))
+		require.Equal(t, 500, result.Messages)
+		require.EqualValues(t, 500*100, result.Bytes)
+		require.Positive(t, result.P50)
+		require.LessOrEqual(t, result.P99, result.Max)
+
+		dir := t.TempDir()
+		stdout, stderr, code = runBrook(t, "", "bench", "consume", "--dir", dir, "--messages", "500", "--partitions", "3", "--concurrency", "2", "--batch-bytes", "1000")
+		require.Equal(t, exitOK, code, stderr)
+		require.Contains(t, stdout, "POLL P50")
+		require.Equal(t, "500", strings.Fields(strings.Split(stdout, "\n")[1])[0])
+
+		_, _, code = runBrook(t, "", "bench", "produce", "--durability", "eventually")
+		require.Equal(t, exitUsage, code)
+	})
+
+	t.Run("completion covers every command", func(t *testing.T) {
+		stdout, _, code := runBrook(t, "", "completion", "bash")
+		require.Equal(t, exitOK, code)
+		for _, cmd := range commands {
+			require.Contains(t, stdout, `"`+cmd.name+`")`)
+		}
+		require.Contains(t, stdout, "--from-beginning")
+	})
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"text/tabwriter"
+)
+
+// outputFormat is the value of the --output flag.
+type outputFormat string
+
+const (
+	outputTable outputFormat = "table"
+	outputJSON  outputFormat = "json"
+)
+
+func (f *outputFormat) String() string {
+	return string(*f)
+}
+
+func (f *outputFormat) Set(value string) error {
+	switch outputFormat(value) {
+	case outputTable, outputJSON:
+		*f = outputFormat(value)
+		return nil
+	default:
+		return fmt.Errorf("must be %s or %s", outputTable, outputJSON)
+	}
+}
+
+func outputFlag(fs *flag.FlagSet) *outputFormat {
+	format := outputTable
+	fs.Var(&format, "output", "output format, table or json")
+	return &format
+}
+
+// writeJSON writes v as a single line of JSON.
+func writeJSON(w io.Writer, v any) error {
+	return json.NewEncoder(w).Encode(v)
+}
+
+// writeTable writes rows as aligned columns under header.
+func writeTable(w io.Writer, header []string, rows [][]string) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, row := range append([][]string{header}, rows...) {
+		for i, cell := range row {
+			if i > 0 {
+				fmt.Fprint(tw, "\t")
+			}
+			fmt.Fprint(tw, cell)
+		}
+		fmt.Fprintln(tw)
+	}
+	return tw.Flush()
+}
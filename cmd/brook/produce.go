@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+)
+
+func produceCommand(fs *flag.FlagSet) runFunc {
+	dataDir := dataDirFlag(fs)
+	output := outputFlag(fs)
+	topicName := fs.String("topic", "", "topic to publish to, created if it doesn't exist (required)")
+
+	return func(c *cli, args []string) error {
+		if *topicName == "" {
+			return usagef("--topic is required")
+		}
+		if len(args) > 0 {
+			return usagef("unexpected arguments %q", args)
+		}
+
+		ps, err := c.openPubSub(*dataDir)
+		if err != nil {
+			return err
+		}
+		topic, err := ps.Topic(*topicName)
+		if err != nil {
+			return errors.Join(err, ps.Close())
+		}
+
+		produced, err := publishLines(c.ctx, topic.Publish, c.stdin)
+		if err := errors.Join(err, ps.Close()); err != nil {
+			return fmt.Errorf("published %d messages: %w", produced, err)
+		}
+
+		if *output == outputJSON {
+			return writeJSON(c.stdout, struct {
+				Topic    string `json:"topic"`
+				Messages int    `json:"messages"`
+			}{*topicName, produced})
+		}
+		_, err = fmt.Fprintf(c.stdout, "published %d messages to %s\n", produced, *topicName)
+		return err
+	}
+}
+
+// publishLines publishes every line of r, without its line ending, and
+// returns how many were published. Once ctx is canceled it stops at the next
+// line, the ones published so far are kept.
+func publishLines(ctx context.Context, publish func([]byte) error, r io.Reader) (int, error) {
+	type read struct {
+		line []byte
+		err  error
+	}
+	// Read in the background, a pipe can block for as long as it likes
+	reads := make(chan read)
+	go func() {
+		br := bufio.NewReader(r)
+		for {
+			line, err := br.ReadBytes('\n')
+			select {
+			case reads <- read{line, err}:
+			case <-ctx.Done():
+				return
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	published := 0
+	for {
+		var next read
+		select {
+		case next = <-reads:
+		case <-ctx.Done():
+			return published, nil
+		}
+		if len(next.line) > 0 {
+			line := bytes.TrimSuffix(bytes.TrimSuffix(next.line, []byte("\n")), []byte("\r"))
+			if err := publish(line); err != nil {
+				return published, err
+			}
+			published++
+		}
+		if next.err == io.EOF {
+			return published, nil
+		}
+		if next.err != nil {
+			return published, fmt.Errorf("failed to read stdin: %w", next.err)
+		}
+	}
+}
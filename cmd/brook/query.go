@@ -0,0 +1,195 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mvaleed/brook/internal/query"
+	"github.com/mvaleed/brook/internal/storage"
+)
+
+// conditionsFlag is a repeatable --where flag.
+type conditionsFlag []query.Condition
+
+func (f *conditionsFlag) String() string {
+	return ""
+}
+
+func (f *conditionsFlag) Set(value string) error {
+	c, err := query.ParseCondition(value)
+	if err != nil {
+		return err
+	}
+	*f = append(*f, c)
+	return nil
+}
+
+// timeFlag is an optional RFC 3339 time.
+type timeFlag struct {
+	t time.Time
+}
+
+func (f *timeFlag) String() string {
+	if f.t.IsZero() {
+		return ""
+	}
+	return f.t.Format(time.RFC3339)
+}
+
+func (f *timeFlag) Set(value string) error {
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return errors.New("must be an RFC 3339 time such as 2006-01-02T15:04:05Z")
+	}
+	f.t = t
+	return nil
+}
+
+// queriedRow is a query.Row as it's printed.
+type queriedRow struct {
+	Topic     string         `json:"topic"`
+	Offset    int            `json:"offset"`
+	Timestamp time.Time      `json:"timestamp"`
+	Fields    map[string]any `json:"fields,omitempty"`
+	Payload   *string        `json:"payload,omitempty"`
+}
+
+func queryCommand(fs *flag.FlagSet) runFunc {
+	dataDir := dataDirFlag(fs)
+	output := outputFlag(fs)
+	selectFields := fs.String("select", "", "comma separated payload fields to print, dotted paths into JSON objects, instead of the whole payload")
+	var where conditionsFlag
+	fs.Var(&where, "where", "only print records whose payload field matches FIELD OP VALUE, OP one of = != < <= > >= ~ (contains), repeatable")
+	fromOffset := fs.Int("from-offset", 0, "first offset to scan")
+	toOffset := fs.Int("to-offset", 0, "offset to stop scanning at, 0 for the end of the topic")
+	var since, until timeFlag
+	fs.Var(&since, "since", "only print records written at or after this RFC 3339 time")
+	fs.Var(&until, "until", "only print records written before this RFC 3339 time")
+	limit := fs.Int("limit", 0, "stop after this many records, 0 for no limit")
+
+	return func(c *cli, args []string) error {
+		if len(args) == 0 {
+			return usagef("expected at least one topic")
+		}
+		if *fromOffset < 0 || *toOffset < 0 || *limit < 0 {
+			return usagef("--from-offset, --to-offset and --limit can't be negative")
+		}
+
+		q := query.Query{
+			Where:      where,
+			FromOffset: *fromOffset,
+			ToOffset:   *toOffset,
+			Since:      since.t,
+			Until:      until.t,
+			Limit:      *limit,
+		}
+		if *selectFields != "" {
+			q.Select = strings.Split(*selectFields, ",")
+		}
+
+		sources, err := c.openSources(*dataDir, args)
+		defer func() {
+			for _, source := range sources {
+				source.Partition.Close()
+			}
+		}()
+		if err != nil {
+			return err
+		}
+		return printQuery(c, sources, q, *output)
+	}
+}
+
+// openSources opens the partitions of the topics, which must exist.
+func (c *cli) openSources(dataDir string, topics []string) ([]query.Source, error) {
+	var sources []query.Source
+	for _, topic := range topics {
+		if topic != filepath.Base(topic) {
+			return sources, usagef("invalid topic %q", topic)
+		}
+		dir := filepath.Join(dataDir, topic)
+		if _, err := os.Stat(dir); err != nil {
+			return sources, fmt.Errorf("topic %s does not exist", topic)
+		}
+		p, err := storage.NewPartitionWithConfig(dir, c.partitionConfig())
+		if err != nil {
+			return sources, fmt.Errorf("topic %s: %w", topic, err)
+		}
+		sources = append(sources, query.Source{Name: topic, Partition: p})
+	}
+	return sources, nil
+}
+
+func printQuery(c *cli, sources []query.Source, q query.Query, output outputFormat) error {
+	header := []string{"TOPIC", "OFFSET", "TIMESTAMP"}
+	if len(q.Select) == 0 {
+		header = append(header, "PAYLOAD")
+	} else {
+		for _, name := range q.Select {
+			header = append(header, strings.ToUpper(name))
+		}
+	}
+
+	var rows [][]string
+	for row, err := range query.Run(c.ctx, sources, q) {
+		if err != nil {
+			return err
+		}
+
+		if output == outputJSON {
+			// One record per line, topics can be large
+			if err := writeJSON(c.stdout, newQueriedRow(row, q.Select)); err != nil {
+				return err
+			}
+			continue
+		}
+		cells := []string{row.Source, strconv.Itoa(row.Offset), row.Timestamp.UTC().Format(time.RFC3339Nano)}
+		if len(q.Select) == 0 {
+			cells = append(cells, strconv.Quote(string(row.Payload)))
+		}
+		for _, v := range row.Fields {
+			cells = append(cells, fieldCell(v))
+		}
+		rows = append(rows, cells)
+	}
+
+	if output == outputJSON {
+		return nil
+	}
+	return writeTable(c.stdout, header, rows)
+}
+
+func newQueriedRow(row query.Row, names []string) queriedRow {
+	queried := queriedRow{Topic: row.Source, Offset: row.Offset, Timestamp: row.Timestamp.UTC()}
+	if len(names) == 0 {
+		payload := string(row.Payload)
+		queried.Payload = &payload
+		return queried
+	}
+	queried.Fields = make(map[string]any, len(names))
+	for i, name := range names {
+		queried.Fields[name] = row.Fields[i]
+	}
+	return queried
+}
+
+// fieldCell is a field as a table cell: strings as they are, missing fields
+// as -, anything else as JSON.
+func fieldCell(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return "-"
+	case string:
+		return v
+	default:
+		data, _ := json.Marshal(v)
+		return string(data)
+	}
+}
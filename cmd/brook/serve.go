@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/mvaleed/brook/internal/admin"
+	"github.com/mvaleed/brook/internal/brain"
+	"github.com/mvaleed/brook/internal/config"
+	"github.com/mvaleed/brook/internal/health"
+	"github.com/mvaleed/brook/internal/network"
+)
+
+// shutdownTimeout bounds how long a stopping broker waits for the requests
+// in flight.
+const shutdownTimeout = 10 * time.Second
+
+func serveCommand(fs *flag.FlagSet) runFunc {
+	path := fs.String("config", os.Getenv("BROOK_CONFIG"), "broker config file (default from $BROOK_CONFIG)")
+
+	return func(c *cli, args []string) error {
+		if *path == "" {
+			return usagef("--config is required")
+		}
+		if len(args) > 0 {
+			return usagef("unexpected arguments %q", args)
+		}
+
+		conf, err := config.Load(*path, os.Environ())
+		if err != nil {
+			return err
+		}
+		brokerConfig, err := conf.BrokerConfig()
+		if err != nil {
+			return err
+		}
+		level, err := conf.Level()
+		if err != nil {
+			return err
+		}
+		// Changed by the admin API while the broker runs
+		logLevel := new(slog.LevelVar)
+		logLevel.Set(level)
+		logger := slog.New(slog.NewTextHandler(c.stderr, &slog.HandlerOptions{Level: logLevel}))
+		brokerConfig.Partition.Logger = logger
+
+		b, err := brain.OpenBroker(conf.Paths(), brokerConfig)
+		if err != nil {
+			return err
+		}
+		ctx, cancel := context.WithCancel(c.ctx)
+		defer cancel()
+		// Retention, quotas and the log level change on SIGHUP, the listeners
+		// reload their certificates then too
+		go config.NewReloader(*path, conf, b, logLevel, logger).ReloadOnSIGHUP(ctx)
+
+		// The probes take no credentials, everything else is the admin API
+		checker := health.NewChecker(health.Config{DataDirs: append([]string{conf.DataDir}, conf.DataDirs...), Logger: logger})
+		mux := http.NewServeMux()
+		mux.Handle("GET /healthz", checker)
+		mux.Handle("GET /readyz", checker)
+		mux.Handle("/", admin.NewHandler(b, admin.HandlerConfig{Logger: logger, LogLevel: logLevel}))
+		err = serve(ctx, conf.Listeners, mux, logger)
+		return errors.Join(err, b.Close())
+	}
+}
+
+// serve serves handler on every listener, each with its own handler pool,
+// until ctx is canceled, then waits for the requests in flight, for
+// shutdownTimeout at most. A listener failing stops them all.
+func serve(ctx context.Context, listeners []config.ListenerConfig, handler http.Handler, logger *slog.Logger) error {
+	var lns []*network.Listener
+	var servers []*http.Server
+	listen := func(l config.ListenerConfig, logger *slog.Logger) (*network.Listener, *network.HandlerPool, error) {
+		poolConfig := l.PoolConfig()
+		poolConfig.Logger = logger
+		pool, err := network.NewHandlerPool(handler, poolConfig)
+		if err != nil {
+			return nil, nil, err
+		}
+		netConfig := l.NetworkConfig()
+		netConfig.Logger = logger
+		ln, err := network.Listen(netConfig)
+		return ln, pool, err
+	}
+	for _, l := range listeners {
+		lnLogger := logger.With("listener", l.Name)
+		ln, pool, err := listen(l, lnLogger)
+		if err != nil {
+			for _, ln := range lns {
+				ln.Close()
+			}
+			return fmt.Errorf("listener %s: %w", l.Name, err)
+		}
+		lns = append(lns, ln)
+		servers = append(servers, &http.Server{
+			Handler:     pool,
+			ConnContext: pool.ConnContext,
+			ErrorLog:    slog.NewLogLogger(lnLogger.Handler(), slog.LevelWarn),
+		})
+	}
+
+	failed := make(chan error, len(lns))
+	for i, ln := range lns {
+		logger.Info("serving", "listener", listeners[i].Name, "address", ln.Addr().String())
+		go ln.ReloadOnSIGHUP(ctx)
+		go func() {
+			failed <- servers[i].Serve(ln)
+		}()
+	}
+
+	var err error
+	select {
+	case <-ctx.Done():
+	case err = <-failed:
+	}
+	logger.Info("stopping")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	for _, server := range servers {
+		err = errors.Join(err, server.Shutdown(shutdownCtx))
+	}
+	return err
+}
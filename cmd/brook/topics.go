@@ -0,0 +1,112 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"slices"
+	"strconv"
+
+	"github.com/mvaleed/brook/internal/brain"
+)
+
+func topicsCreateCommand(fs *flag.FlagSet) runFunc {
+	dataDir := dataDirFlag(fs)
+	output := outputFlag(fs)
+
+	return func(c *cli, args []string) error {
+		if len(args) == 0 {
+			return usagef("at least one topic is required")
+		}
+
+		ps, err := c.openPubSub(*dataDir)
+		if err != nil {
+			return err
+		}
+		existing, err := ps.Topics()
+		if err != nil {
+			return errors.Join(err, ps.Close())
+		}
+
+		var created []string
+		for _, name := range args {
+			if slices.Contains(existing, name) || slices.Contains(created, name) {
+				err = fmt.Errorf("topic %s already exists", name)
+				break
+			}
+			if _, err = ps.Topic(name); err != nil {
+				break
+			}
+			created = append(created, name)
+		}
+		if err := errors.Join(err, ps.Close()); err != nil {
+			return err
+		}
+
+		if *output == outputJSON {
+			return writeJSON(c.stdout, struct {
+				Created []string `json:"created"`
+			}{created})
+		}
+		for _, name := range created {
+			if _, err := fmt.Fprintf(c.stdout, "created topic %s\n", name); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+func topicsListCommand(fs *flag.FlagSet) runFunc {
+	dataDir := dataDirFlag(fs)
+	output := outputFlag(fs)
+
+	return func(c *cli, args []string) error {
+		if len(args) > 0 {
+			return usagef("unexpected arguments %q", args)
+		}
+
+		ps, err := c.openPubSub(*dataDir)
+		if err != nil {
+			return err
+		}
+		topics, err := listTopics(ps)
+		if err := errors.Join(err, ps.Close()); err != nil {
+			return err
+		}
+
+		if *output == outputJSON {
+			if topics == nil {
+				topics = []topicInfo{}
+			}
+			return writeJSON(c.stdout, topics)
+		}
+		rows := make([][]string, len(topics))
+		for i, topic := range topics {
+			rows[i] = []string{topic.Name, strconv.Itoa(topic.EndOffset)}
+		}
+		return writeTable(c.stdout, []string{"TOPIC", "END OFFSET"}, rows)
+	}
+}
+
+type topicInfo struct {
+	Name      string `json:"name"`
+	EndOffset int    `json:"end_offset"`
+}
+
+func listTopics(ps *brain.PubSub) ([]topicInfo, error) {
+	names, err := ps.Topics()
+	if err != nil {
+		return nil, err
+	}
+
+	var topics []topicInfo
+	for _, name := range names {
+		topic, err := ps.Topic(name)
+		if err != nil {
+			return nil, err
+		}
+		topics = append(topics, topicInfo{Name: name, EndOffset: topic.EndOffset()})
+	}
+	return topics, nil
+}
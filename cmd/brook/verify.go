@@ -0,0 +1,60 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"path/filepath"
+	"strconv"
+
+	"github.com/mvaleed/brook/internal/storage"
+)
+
+func verifyCommand(fs *flag.FlagSet) runFunc {
+	dataDir := dataDirFlag(fs)
+	output := outputFlag(fs)
+	topicName := fs.String("topic", "", "topic to verify (required)")
+	repair := fs.Bool("repair", false, "repair what can be repaired, cutting segments at their first bad record")
+
+	return func(c *cli, args []string) error {
+		if *topicName == "" {
+			return usagef("--topic is required")
+		}
+		if len(args) > 0 {
+			return usagef("unexpected arguments %q", args)
+		}
+		if *topicName != filepath.Base(*topicName) {
+			return usagef("invalid topic %q", *topicName)
+		}
+
+		report, err := storage.VerifyPartition(filepath.Join(*dataDir, *topicName), *repair)
+		if err != nil {
+			return err
+		}
+
+		if *output == outputJSON {
+			if err := writeJSON(c.stdout, report); err != nil {
+				return err
+			}
+		} else {
+			rows := make([][]string, len(report.Issues))
+			for i, issue := range report.Issues {
+				position := "-"
+				if issue.Position >= 0 {
+					position = strconv.FormatInt(issue.Position, 10)
+				}
+				rows[i] = []string{issue.Path, position, strconv.FormatBool(issue.Repaired), issue.Problem}
+			}
+			if len(rows) > 0 {
+				if err := writeTable(c.stdout, []string{"FILE", "BYTE", "REPAIRED", "PROBLEM"}, rows); err != nil {
+					return err
+				}
+			}
+			fmt.Fprintf(c.stdout, "verified %d records in %d segments, %d problems\n", report.Records, report.Segments, len(report.Issues))
+		}
+
+		if !report.Healthy() {
+			return fmt.Errorf("topic %s has problems left", *topicName)
+		}
+		return nil
+	}
+}
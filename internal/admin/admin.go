@@ -0,0 +1,244 @@
+// Package admin serves the administration of a broker over HTTP: listing and
+// describing topics, altering their configs, peeking at and deleting old
+// records. The requests and responses are JSON, and Client makes them. A
+// dashboard built on the same requests is served under /ui/.
+//
+//	GET   /v1/topics                                         list topics
+//	GET   /v1/topics/{topic}                                 describe a topic
+//	PATCH /v1/topics/{topic}/config                          alter a config
+//	GET   /v1/topics/{topic}/partitions/{n}/messages         peek at messages
+//	POST  /v1/topics/{topic}/partitions/{n}/delete-records   delete records
+//	PATCH /v1/config                                         reconfigure the broker
+//	GET   /v1/groups                                         list consumer groups
+//	GET   /v1/groups/{group}                                 describe a consumer group
+package admin
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/mvaleed/brook/internal/brain"
+	"github.com/mvaleed/brook/internal/storage"
+)
+
+// TopicConfig is a brain.TopicConfig as JSON.
+type TopicConfig struct {
+	Partitions      int    `json:"partitions"`
+	RetentionMs     int64  `json:"retention_ms"`
+	Compression     string `json:"compression"`
+	MaxSegmentBytes int64  `json:"max_segment_bytes"`
+	// MaxMessageBytes is 0 for the broker's MaxRecordBytes.
+	MaxMessageBytes int64  `json:"max_message_bytes"`
+	Durability      string `json:"durability"`
+	Compact         bool   `json:"compact"`
+	Queue           bool   `json:"queue"`
+	// VisibilityTimeoutMs is 0 for brain.DefaultVisibilityTimeout.
+	VisibilityTimeoutMs int64 `json:"visibility_timeout_ms"`
+	// TimestampType is log_append_time or create_time, empty for
+	// log_append_time.
+	TimestampType string `json:"timestamp_type"`
+	// MaxTimestampSkewMs is 0 for brain.DefaultMaxTimestampSkew.
+	MaxTimestampSkewMs int64 `json:"max_timestamp_skew_ms"`
+}
+
+func newTopicConfig(c brain.TopicConfig) TopicConfig {
+	return TopicConfig{
+		Partitions:          c.Partitions,
+		RetentionMs:         c.Retention.Milliseconds(),
+		Compression:         string(c.Compression),
+		MaxSegmentBytes:     c.MaxSegmentBytes,
+		MaxMessageBytes:     c.MaxMessageBytes,
+		Durability:          c.Durability.String(),
+		Compact:             c.Compact,
+		Queue:               c.Queue,
+		VisibilityTimeoutMs: c.VisibilityTimeout.Milliseconds(),
+		TimestampType:       string(c.TimestampType),
+		MaxTimestampSkewMs:  c.MaxTimestampSkew.Milliseconds(),
+	}
+}
+
+// Topic is a topic and its config, as ListTopics returns them.
+type Topic struct {
+	Name   string      `json:"name"`
+	Config TopicConfig `json:"config"`
+}
+
+// TopicDescription is a brain.TopicDescription as JSON.
+type TopicDescription struct {
+	Name       string                   `json:"name"`
+	Config     TopicConfig              `json:"config"`
+	Partitions []storage.PartitionStats `json:"partitions"`
+}
+
+// ConfigChange is a brain.TopicConfigChange as JSON, the settings left out
+// stay as they are.
+type ConfigChange struct {
+	RetentionMs     *int64  `json:"retention_ms,omitempty"`
+	Compression     *string `json:"compression,omitempty"`
+	MaxSegmentBytes *int64  `json:"max_segment_bytes,omitempty"`
+	MaxMessageBytes *int64  `json:"max_message_bytes,omitempty"`
+	Durability      *string `json:"durability,omitempty"`
+	Compact         *bool   `json:"compact,omitempty"`
+	Queue           *bool   `json:"queue,omitempty"`
+	// VisibilityTimeoutMs is 0 for brain.DefaultVisibilityTimeout.
+	VisibilityTimeoutMs *int64  `json:"visibility_timeout_ms,omitempty"`
+	TimestampType       *string `json:"timestamp_type,omitempty"`
+	// MaxTimestampSkewMs is 0 for brain.DefaultMaxTimestampSkew.
+	MaxTimestampSkewMs *int64 `json:"max_timestamp_skew_ms,omitempty"`
+}
+
+func (c ConfigChange) topicConfigChange() (brain.TopicConfigChange, error) {
+	change := brain.TopicConfigChange{
+		MaxSegmentBytes: c.MaxSegmentBytes,
+		MaxMessageBytes: c.MaxMessageBytes,
+		Compact:         c.Compact,
+		Queue:           c.Queue,
+	}
+	if c.RetentionMs != nil {
+		retention := time.Duration(*c.RetentionMs) * time.Millisecond
+		change.Retention = &retention
+	}
+	if c.VisibilityTimeoutMs != nil {
+		timeout := time.Duration(*c.VisibilityTimeoutMs) * time.Millisecond
+		change.VisibilityTimeout = &timeout
+	}
+	if c.MaxTimestampSkewMs != nil {
+		skew := time.Duration(*c.MaxTimestampSkewMs) * time.Millisecond
+		change.MaxTimestampSkew = &skew
+	}
+	if c.Compression != nil {
+		compression := brain.Compression(*c.Compression)
+		change.Compression = &compression
+	}
+	if c.TimestampType != nil {
+		timestampType := brain.TimestampType(*c.TimestampType)
+		change.TimestampType = &timestampType
+	}
+	if c.Durability != nil {
+		durability, err := parseDurability(*c.Durability)
+		if err != nil {
+			return brain.TopicConfigChange{}, err
+		}
+		change.Durability = &durability
+	}
+	return change, nil
+}
+
+// parseDurability is the inverse of storage.DurabilityMode.String.
+func parseDurability(s string) (storage.DurabilityMode, error) {
+	for _, mode := range []storage.DurabilityMode{storage.DurabilityAsync, storage.DurabilityMedium, storage.DurabilityFull} {
+		if s == mode.String() {
+			return mode, nil
+		}
+	}
+	return 0, fmt.Errorf("%w: unknown durability %q", brain.ErrInvalidConfig, s)
+}
+
+// ByteRates is a brain.ByteRates as JSON.
+type ByteRates struct {
+	ProduceBytesPerSecond int64 `json:"produce_bytes_per_second"`
+	FetchBytesPerSecond   int64 `json:"fetch_bytes_per_second"`
+}
+
+func (r ByteRates) byteRates() brain.ByteRates {
+	return brain.ByteRates{ProduceBytesPerSecond: r.ProduceBytesPerSecond, FetchBytesPerSecond: r.FetchBytesPerSecond}
+}
+
+// QuotaConfig is a brain.QuotaConfig as JSON.
+type QuotaConfig struct {
+	Client     ByteRates            `json:"client"`
+	Topic      ByteRates            `json:"topic"`
+	Namespace  ByteRates            `json:"namespace"`
+	Clients    map[string]ByteRates `json:"clients,omitempty"`
+	Topics     map[string]ByteRates `json:"topics,omitempty"`
+	Namespaces map[string]ByteRates `json:"namespaces,omitempty"`
+}
+
+func (c QuotaConfig) quotaConfig() brain.QuotaConfig {
+	config := brain.QuotaConfig{Client: c.Client.byteRates(), Topic: c.Topic.byteRates(), Namespace: c.Namespace.byteRates()}
+	if len(c.Clients) > 0 {
+		config.Clients = make(map[string]brain.ByteRates, len(c.Clients))
+		for id, rates := range c.Clients {
+			config.Clients[id] = rates.byteRates()
+		}
+	}
+	if len(c.Topics) > 0 {
+		config.Topics = make(map[string]brain.ByteRates, len(c.Topics))
+		for name, rates := range c.Topics {
+			config.Topics[name] = rates.byteRates()
+		}
+	}
+	if len(c.Namespaces) > 0 {
+		config.Namespaces = make(map[string]brain.ByteRates, len(c.Namespaces))
+		for name, rates := range c.Namespaces {
+			config.Namespaces[name] = rates.byteRates()
+		}
+	}
+	return config
+}
+
+// BrokerConfigChange is a brain.BrokerConfigChange as JSON, with the log
+// level of the broker. The settings left out stay as they are.
+type BrokerConfigChange struct {
+	DefaultRetentionMs *int64       `json:"default_retention_ms,omitempty"`
+	Quotas             *QuotaConfig `json:"quotas,omitempty"`
+	// LogLevel is debug, info, warn or error.
+	LogLevel *string `json:"log_level,omitempty"`
+}
+
+func (c BrokerConfigChange) brokerConfigChange() brain.BrokerConfigChange {
+	var change brain.BrokerConfigChange
+	if c.DefaultRetentionMs != nil {
+		retention := time.Duration(*c.DefaultRetentionMs) * time.Millisecond
+		change.DefaultRetention = &retention
+	}
+	if c.Quotas != nil {
+		quotas := c.Quotas.quotaConfig()
+		change.Quotas = &quotas
+	}
+	return change
+}
+
+// parseLevel parses a log level as slog.Level.UnmarshalText does.
+func parseLevel(s string) (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(s)); err != nil {
+		return 0, fmt.Errorf("%w: unknown log level %q", brain.ErrInvalidConfig, s)
+	}
+	return level, nil
+}
+
+// GroupDescription is a brain.GroupDescription as JSON.
+type GroupDescription struct {
+	Name       string               `json:"name"`
+	Partitions []brain.PartitionLag `json:"partitions"`
+	Lag        int                  `json:"lag"`
+}
+
+// Message is a brain.Message as JSON, its data base64 encoded.
+type Message struct {
+	Offset    int       `json:"offset"`
+	Timestamp time.Time `json:"timestamp"`
+	Data      []byte    `json:"data"`
+}
+
+// DeleteRecords is the body of a delete-records request.
+type DeleteRecords struct {
+	BeforeOffset int `json:"before_offset"`
+}
+
+// PartitionOffsets are the offsets of a partition.
+type PartitionOffsets struct {
+	FirstOffset int `json:"first_offset"`
+	NextOffset  int `json:"next_offset"`
+	// TimestampOffset is the offset of the first message stamped at or after
+	// the timestamp asked for, NextOffset if there is none. It's only there
+	// if a timestamp was asked for.
+	TimestampOffset *int `json:"timestamp_offset,omitempty"`
+}
+
+// errorResponse is the body of the responses to failed requests.
+type errorResponse struct {
+	Error string `json:"error"`
+}
@@ -0,0 +1,276 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/mvaleed/brook/internal/brain"
+	"github.com/mvaleed/brook/internal/storage"
+)
+
+func openTestServer(t *testing.T, config brain.BrokerConfig, handlerConfig HandlerConfig) (*brain.Broker, *httptest.Server) {
+	t.Helper()
+	b, err := brain.OpenBroker(storage.Paths{Data: t.TempDir()}, config)
+	require.NoError(t, err)
+	t.Cleanup(func() { b.Close() })
+	server := httptest.NewServer(NewHandler(b, handlerConfig))
+	t.Cleanup(server.Close)
+	return b, server
+}
+
+func requireStatus(t *testing.T, code int, err error) {
+	t.Helper()
+	var apiErr *Error
+	require.True(t, errors.As(err, &apiErr), "%v", err)
+	require.Equal(t, code, apiErr.StatusCode, apiErr.Message)
+}
+
+func TestAdmin(t *testing.T) {
+	ctx := context.Background()
+	b, server := openTestServer(t, brain.DefaultBrokerConfig(), HandlerConfig{})
+	client := &Client{URL: server.URL}
+
+	require.NoError(t, b.CreateTopic(ctx, "orders", brain.TopicConfig{Partitions: 2}))
+	require.NoError(t, b.CreateTopic(ctx, "events", brain.DefaultTopicConfig()))
+	for range 4 {
+		require.NoError(t, b.Produce(ctx, "orders", 1, []byte("order")))
+	}
+
+	t.Run("list topics", func(t *testing.T) {
+		topics, err := client.ListTopics(ctx)
+		require.NoError(t, err)
+		require.Len(t, topics, 2)
+		require.Equal(t, "events", topics[0].Name)
+		require.Equal(t, Topic{Name: "orders", Config: TopicConfig{Partitions: 2, Compression: "none", Durability: "medium"}}, topics[1])
+	})
+
+	t.Run("describe topic", func(t *testing.T) {
+		d, err := client.DescribeTopic(ctx, "orders")
+		require.NoError(t, err)
+		require.Equal(t, "orders", d.Name)
+		require.Len(t, d.Partitions, 2)
+		require.Equal(t, 4, d.Partitions[1].NextOffset)
+
+		_, err = client.DescribeTopic(ctx, "payments")
+		requireStatus(t, http.StatusNotFound, err)
+
+		require.NoError(t, b.CreateTopic(ctx, "billing/orders", brain.DefaultTopicConfig()))
+		d, err = client.DescribeTopic(ctx, "billing/orders")
+		require.NoError(t, err)
+		require.Equal(t, "billing/orders", d.Name)
+	})
+
+	t.Run("alter config", func(t *testing.T) {
+		retention := int64(60000)
+		durability := "full"
+		config, err := client.AlterConfig(ctx, "orders", ConfigChange{RetentionMs: &retention, Durability: &durability})
+		require.NoError(t, err)
+		require.Equal(t, TopicConfig{Partitions: 2, RetentionMs: 60000, Compression: "none", Durability: "full"}, config)
+
+		stored, err := b.TopicConfig("orders")
+		require.NoError(t, err)
+		require.Equal(t, storage.DurabilityFull, stored.Durability)
+
+		durability = "eventually"
+		_, err = client.AlterConfig(ctx, "orders", ConfigChange{Durability: &durability})
+		requireStatus(t, http.StatusBadRequest, err)
+		timestampType := "wall_clock"
+		_, err = client.AlterConfig(ctx, "orders", ConfigChange{TimestampType: &timestampType})
+		requireStatus(t, http.StatusBadRequest, err)
+		compression := "zstd"
+		_, err = client.AlterConfig(ctx, "orders", ConfigChange{Compression: &compression})
+		requireStatus(t, http.StatusBadRequest, err)
+	})
+
+	t.Run("peek messages", func(t *testing.T) {
+		msgs, err := client.PeekMessages(ctx, "orders", 1, -1, 2)
+		require.NoError(t, err)
+		require.Len(t, msgs, 2)
+		require.Equal(t, 0, msgs[0].Offset)
+		require.Equal(t, []byte("order"), msgs[1].Data)
+
+		msgs, err = client.PeekMessages(ctx, "orders", 1, 3, 10)
+		require.NoError(t, err)
+		require.Len(t, msgs, 1)
+		require.Equal(t, 3, msgs[0].Offset)
+		msgs, err = client.PeekMessages(ctx, "orders", 1, 4, 10)
+		require.NoError(t, err)
+		require.Empty(t, msgs)
+
+		_, err = client.PeekMessages(ctx, "orders", 1, 5, 10)
+		requireStatus(t, http.StatusBadRequest, err)
+		_, err = client.PeekMessages(ctx, "orders", 1, 0, 1000)
+		requireStatus(t, http.StatusBadRequest, err)
+		_, err = client.PeekMessages(ctx, "orders", 2, 0, 10)
+		requireStatus(t, http.StatusNotFound, err)
+
+		msgs, err = client.PeekOffsets(ctx, "orders", 1, []int{3, 0, 7, 3})
+		require.NoError(t, err)
+		require.Len(t, msgs, 2)
+		require.Equal(t, 0, msgs[0].Offset)
+		require.Equal(t, 3, msgs[1].Offset)
+		_, err = client.PeekOffsets(ctx, "orders", 1, make([]int, 101))
+		requireStatus(t, http.StatusBadRequest, err)
+	})
+
+	t.Run("list offsets", func(t *testing.T) {
+		offsets, err := client.ListOffsets(ctx, "orders", 1)
+		require.NoError(t, err)
+		require.Equal(t, PartitionOffsets{FirstOffset: 0, NextOffset: 4}, offsets)
+
+		offset, err := client.OffsetForTimestamp(ctx, "orders", 1, time.Now().Add(-time.Hour))
+		require.NoError(t, err)
+		require.Zero(t, offset)
+		offset, err = client.OffsetForTimestamp(ctx, "orders", 1, time.Now().Add(time.Hour))
+		require.NoError(t, err)
+		require.Equal(t, 4, offset)
+
+		resp, err := http.Get(server.URL + "/v1/topics/orders/partitions/1/offsets?timestamp=yesterday")
+		require.NoError(t, err)
+		resp.Body.Close()
+		require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+		resp, err = http.Get(fmt.Sprintf("%s/v1/topics/orders/partitions/1/offsets?timestamp=%d", server.URL, time.Now().Add(-time.Hour).UnixMilli()))
+		require.NoError(t, err)
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&offsets))
+		resp.Body.Close()
+		require.Equal(t, 0, *offsets.TimestampOffset)
+
+		_, err = client.ListOffsets(ctx, "orders", 2)
+		requireStatus(t, http.StatusNotFound, err)
+		_, err = client.OffsetForTimestamp(ctx, "payments", 0, time.Now())
+		requireStatus(t, http.StatusNotFound, err)
+	})
+
+	t.Run("dashboard", func(t *testing.T) {
+		resp, err := http.Get(server.URL)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		require.Equal(t, server.URL+"/ui/", resp.Request.URL.String())
+		page, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.Contains(t, string(page), "/v1/topics")
+	})
+
+	t.Run("delete records", func(t *testing.T) {
+		require.NoError(t, client.DeleteRecordsBefore(ctx, "orders", 1, 2))
+		p, err := b.Partition("orders", 1)
+		require.NoError(t, err)
+		require.Equal(t, 2, p.FirstOffset())
+
+		requireStatus(t, http.StatusBadRequest, client.DeleteRecordsBefore(ctx, "orders", 1, 10))
+		requireStatus(t, http.StatusNotFound, client.DeleteRecordsBefore(ctx, "orders", 2, 0))
+		requireStatus(t, http.StatusNotFound, client.DeleteRecordsBefore(ctx, "payments", 0, 0))
+	})
+
+	t.Run("describe group", func(t *testing.T) {
+		groups, err := client.ListGroups(ctx)
+		require.NoError(t, err)
+		require.Empty(t, groups)
+
+		require.NoError(t, b.CommitOffset(ctx, "billing", "orders", 1, 3))
+		groups, err = client.ListGroups(ctx)
+		require.NoError(t, err)
+		require.Equal(t, []string{"billing"}, groups)
+
+		d, err := client.DescribeGroup(ctx, "billing")
+		require.NoError(t, err)
+		require.Equal(t, GroupDescription{
+			Name:       "billing",
+			Partitions: []brain.PartitionLag{{Topic: "orders", Partition: 1, CommittedOffset: 3, EndOffset: 4, Lag: 1}},
+			Lag:        1,
+		}, d)
+
+		_, err = client.DescribeGroup(ctx, "shipping")
+		requireStatus(t, http.StatusNotFound, err)
+	})
+}
+
+func TestAdmin_Reconfigure(t *testing.T) {
+	ctx := context.Background()
+	config := brain.DefaultBrokerConfig()
+	config.AutoCreateTopics = true
+	level := new(slog.LevelVar)
+	b, server := openTestServer(t, config, HandlerConfig{LogLevel: level})
+	client := &Client{URL: server.URL}
+
+	retention := int64(60000)
+	debug := "debug"
+	require.NoError(t, client.ReconfigureBroker(ctx, BrokerConfigChange{
+		DefaultRetentionMs: &retention,
+		Quotas:             &QuotaConfig{Clients: map[string]ByteRates{"app": {ProduceBytesPerSecond: 10}}},
+		LogLevel:           &debug,
+	}))
+	require.Equal(t, slog.LevelDebug, level.Level())
+
+	app := brain.WithClientID(ctx, "app")
+	require.NoError(t, b.Produce(app, "orders", 0, make([]byte, 100)))
+	require.ErrorIs(t, b.Produce(app, "orders", 0, make([]byte, 100)), brain.ErrThrottled)
+	created, err := b.TopicConfig("orders")
+	require.NoError(t, err)
+	require.Equal(t, time.Minute, created.Retention)
+
+	loud := "loud"
+	requireStatus(t, http.StatusBadRequest, client.ReconfigureBroker(ctx, BrokerConfigChange{LogLevel: &loud}))
+	requireStatus(t, http.StatusBadRequest, client.ReconfigureBroker(ctx, BrokerConfigChange{
+		Quotas: &QuotaConfig{Topic: ByteRates{FetchBytesPerSecond: -1}},
+	}))
+	require.Equal(t, slog.LevelDebug, level.Level())
+
+	_, server = openTestServer(t, config, HandlerConfig{})
+	requireStatus(t, http.StatusBadRequest, (&Client{URL: server.URL}).ReconfigureBroker(ctx, BrokerConfigChange{LogLevel: &debug}))
+}
+
+func TestAdmin_Authorization(t *testing.T) {
+	ctx := context.Background()
+	config := brain.DefaultBrokerConfig()
+	config.Authorize = true
+	config.SuperUsers = []string{"root"}
+	b, server := openTestServer(t, config, HandlerConfig{
+		Authenticators: []brain.Authenticator{&brain.TokenAuthenticator{Tokens: map[string]string{"s3cret": "root", "guest": "alice"}}},
+	})
+	require.NoError(t, b.CreateTopic(brain.WithPrincipal(ctx, "root"), "orders", brain.DefaultTopicConfig()))
+
+	_, err := (&Client{URL: server.URL, Token: "s3cret"}).DescribeTopic(ctx, "orders")
+	require.NoError(t, err)
+	_, err = (&Client{URL: server.URL, Token: "guest"}).DescribeTopic(ctx, "orders")
+	requireStatus(t, http.StatusForbidden, err)
+	_, err = (&Client{URL: server.URL}).DescribeTopic(ctx, "orders")
+	requireStatus(t, http.StatusForbidden, err)
+	_, err = (&Client{URL: server.URL, Token: "wrong"}).DescribeTopic(ctx, "orders")
+	requireStatus(t, http.StatusUnauthorized, err)
+
+	topics, err := (&Client{URL: server.URL, Token: "s3cret"}).ListTopics(ctx)
+	require.NoError(t, err)
+	require.Len(t, topics, 1)
+	for _, token := range []string{"guest", ""} {
+		topics, err := (&Client{URL: server.URL, Token: token}).ListTopics(ctx)
+		require.NoError(t, err)
+		require.Empty(t, topics, "topics are listed to whoever may administer them only")
+	}
+
+	_, err = (&Client{URL: server.URL, Token: "guest"}).ListOffsets(ctx, "orders", 0)
+	requireStatus(t, http.StatusForbidden, err)
+	_, err = (&Client{URL: server.URL, Token: "guest"}).PeekMessages(ctx, "orders", 0, 0, 1)
+	requireStatus(t, http.StatusForbidden, err)
+	_, err = (&Client{URL: server.URL, Token: "s3cret"}).ListOffsets(ctx, "orders", 0)
+	require.NoError(t, err)
+
+	_, err = (&Client{URL: server.URL, Token: "guest"}).ListGroups(ctx)
+	requireStatus(t, http.StatusForbidden, err)
+	_, err = (&Client{URL: server.URL, Token: "s3cret"}).ListGroups(ctx)
+	require.NoError(t, err)
+
+	requireStatus(t, http.StatusForbidden, (&Client{URL: server.URL, Token: "guest"}).ReconfigureBroker(ctx, BrokerConfigChange{}))
+	require.NoError(t, (&Client{URL: server.URL, Token: "s3cret"}).ReconfigureBroker(ctx, BrokerConfigChange{}))
+}
@@ -0,0 +1,184 @@
+package admin
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Error is the failure of a request, as the API reported it.
+type Error struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s (%d %s)", e.Message, e.StatusCode, http.StatusText(e.StatusCode))
+}
+
+// Client makes requests to the admin API of a broker.
+type Client struct {
+	// URL is where the API is served, like http://localhost:9092.
+	URL string
+	// Token is sent as a bearer token when set.
+	Token string
+	// HTTPClient defaults to http.DefaultClient.
+	HTTPClient *http.Client
+}
+
+// ListTopics returns the topics of the broker the caller may administer,
+// sorted by name.
+func (c *Client) ListTopics(ctx context.Context) ([]Topic, error) {
+	var topics []Topic
+	err := c.do(ctx, http.MethodGet, "/v1/topics", nil, &topics)
+	return topics, err
+}
+
+// DescribeTopic returns the config of the topic called name and the stats of
+// its partitions.
+func (c *Client) DescribeTopic(ctx context.Context, name string) (TopicDescription, error) {
+	var d TopicDescription
+	err := c.do(ctx, http.MethodGet, "/v1/topics/"+url.PathEscape(name), nil, &d)
+	return d, err
+}
+
+// AlterConfig applies change to the config of the topic called name and
+// returns the new config.
+func (c *Client) AlterConfig(ctx context.Context, name string, change ConfigChange) (TopicConfig, error) {
+	var config TopicConfig
+	err := c.do(ctx, http.MethodPatch, "/v1/topics/"+url.PathEscape(name)+"/config", change, &config)
+	return config, err
+}
+
+// PeekMessages returns at most limit messages of partition n of the topic
+// called name from offset on, or from the oldest one if offset is negative.
+func (c *Client) PeekMessages(ctx context.Context, name string, n int, offset int, limit int) ([]Message, error) {
+	query := url.Values{"limit": {strconv.Itoa(limit)}}
+	if offset >= 0 {
+		query.Set("offset", strconv.Itoa(offset))
+	}
+	path := fmt.Sprintf("/v1/topics/%s/partitions/%d/messages?%s", url.PathEscape(name), n, query.Encode())
+	var msgs []Message
+	err := c.do(ctx, http.MethodGet, path, nil, &msgs)
+	return msgs, err
+}
+
+// PeekOffsets returns the messages of partition n of the topic called name at
+// offsets, sorted by offset, leaving out the offsets without one.
+func (c *Client) PeekOffsets(ctx context.Context, name string, n int, offsets []int) ([]Message, error) {
+	list := make([]string, len(offsets))
+	for i, offset := range offsets {
+		list[i] = strconv.Itoa(offset)
+	}
+	query := url.Values{"offsets": {strings.Join(list, ",")}}
+	path := fmt.Sprintf("/v1/topics/%s/partitions/%d/messages?%s", url.PathEscape(name), n, query.Encode())
+	var msgs []Message
+	err := c.do(ctx, http.MethodGet, path, nil, &msgs)
+	return msgs, err
+}
+
+// DeleteRecordsBefore deletes the records of partition n of the topic called
+// name before offset.
+func (c *Client) DeleteRecordsBefore(ctx context.Context, name string, n int, offset int) error {
+	path := fmt.Sprintf("/v1/topics/%s/partitions/%d/delete-records", url.PathEscape(name), n)
+	return c.do(ctx, http.MethodPost, path, DeleteRecords{BeforeOffset: offset}, nil)
+}
+
+// ListOffsets returns the offset of the oldest message partition n of the
+// topic called name still holds and the offset of the next message published
+// to it.
+func (c *Client) ListOffsets(ctx context.Context, name string, n int) (PartitionOffsets, error) {
+	var offsets PartitionOffsets
+	path := fmt.Sprintf("/v1/topics/%s/partitions/%d/offsets", url.PathEscape(name), n)
+	err := c.do(ctx, http.MethodGet, path, nil, &offsets)
+	return offsets, err
+}
+
+// OffsetForTimestamp returns the offset of the first message of partition n
+// of the topic called name stamped at or after timestamp, or the offset of
+// the next message published to it if there is none.
+func (c *Client) OffsetForTimestamp(ctx context.Context, name string, n int, timestamp time.Time) (int, error) {
+	query := url.Values{"timestamp": {timestamp.Format(time.RFC3339Nano)}}
+	path := fmt.Sprintf("/v1/topics/%s/partitions/%d/offsets?%s", url.PathEscape(name), n, query.Encode())
+	var offsets PartitionOffsets
+	if err := c.do(ctx, http.MethodGet, path, nil, &offsets); err != nil {
+		return 0, err
+	}
+	if offsets.TimestampOffset == nil {
+		return 0, fmt.Errorf("no offset for timestamp %s in the response", timestamp.Format(time.RFC3339Nano))
+	}
+	return *offsets.TimestampOffset, nil
+}
+
+// ReconfigureBroker applies change to the broker.
+func (c *Client) ReconfigureBroker(ctx context.Context, change BrokerConfigChange) error {
+	return c.do(ctx, http.MethodPatch, "/v1/config", change, nil)
+}
+
+// ListGroups returns the consumer groups that committed an offset, sorted by
+// name.
+func (c *Client) ListGroups(ctx context.Context) ([]string, error) {
+	var groups []string
+	err := c.do(ctx, http.MethodGet, "/v1/groups", nil, &groups)
+	return groups, err
+}
+
+// DescribeGroup returns the offsets the consumer group called name committed
+// and its lag on every partition.
+func (c *Client) DescribeGroup(ctx context.Context, name string) (GroupDescription, error) {
+	var d GroupDescription
+	err := c.do(ctx, http.MethodGet, "/v1/groups/"+url.PathEscape(name), nil, &d)
+	return d, err
+}
+
+// do sends body as JSON and decodes the response into out, unless it's nil.
+func (c *Client) do(ctx context.Context, method string, path string, body any, out any) error {
+	var reqBody io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reqBody = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimSuffix(c.URL, "/")+path, reqBody)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		var failure errorResponse
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+		if json.Unmarshal(data, &failure) != nil || failure.Error == "" {
+			failure.Error = strings.TrimSpace(string(data))
+		}
+		return &Error{StatusCode: resp.StatusCode, Message: failure.Error}
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
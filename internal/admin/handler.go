@@ -0,0 +1,389 @@
+package admin
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mvaleed/brook/internal/brain"
+)
+
+// HandlerConfig configures a Handler.
+type HandlerConfig struct {
+	// Authenticators check the credentials of the Authorization header:
+	// Basic ones with the PLAIN mechanism, Bearer tokens with the TOKEN one.
+	// Requests without credentials are anonymous.
+	Authenticators []brain.Authenticator
+	// Logger receives the failed requests. Defaults to slog.Default().
+	Logger *slog.Logger
+	// LogLevel is the level of the broker's logs, changed by reconfiguring
+	// it. Without it the level can't be changed.
+	LogLevel *slog.LevelVar
+}
+
+// Handler serves the admin API of a broker. The broker authorizes every
+// request for the principal its credentials authenticate.
+type Handler struct {
+	broker         *brain.Broker
+	authenticators []brain.Authenticator
+	logger         *slog.Logger
+	logLevel       *slog.LevelVar
+	mux            *http.ServeMux
+}
+
+func NewHandler(b *brain.Broker, config HandlerConfig) *Handler {
+	logger := config.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	h := &Handler{
+		broker:         b,
+		authenticators: config.Authenticators,
+		logger:         logger,
+		logLevel:       config.LogLevel,
+		mux:            http.NewServeMux(),
+	}
+	h.mux.HandleFunc("GET /v1/topics", h.listTopics)
+	h.mux.HandleFunc("GET /v1/topics/{topic}", h.describeTopic)
+	h.mux.HandleFunc("PATCH /v1/topics/{topic}/config", h.alterConfig)
+	h.mux.HandleFunc("GET /v1/topics/{topic}/partitions/{partition}/messages", h.peekMessages)
+	h.mux.HandleFunc("POST /v1/topics/{topic}/partitions/{partition}/delete-records", h.deleteRecords)
+	h.mux.HandleFunc("GET /v1/topics/{topic}/partitions/{partition}/offsets", h.listOffsets)
+	h.mux.HandleFunc("PATCH /v1/config", h.reconfigure)
+	h.mux.HandleFunc("GET /v1/groups", h.listGroups)
+	h.mux.HandleFunc("GET /v1/groups/{group}", h.describeGroup)
+	h.mux.Handle("GET /ui/", http.FileServerFS(ui))
+	h.mux.Handle("GET /{$}", http.RedirectHandler("/ui/", http.StatusFound))
+	return h
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	principal, err := h.authenticate(r)
+	if err != nil {
+		h.fail(w, r, err)
+		return
+	}
+	h.mux.ServeHTTP(w, r.WithContext(brain.WithPrincipal(r.Context(), principal)))
+}
+
+// authenticate returns the principal of the credentials of r, "" if it has
+// none.
+func (h *Handler) authenticate(r *http.Request) (string, error) {
+	header := r.Header.Get("Authorization")
+	if header == "" {
+		return "", nil
+	}
+	if user, password, ok := r.BasicAuth(); ok {
+		return brain.Authenticate(h.authenticators, "PLAIN", []byte("\x00"+user+"\x00"+password))
+	}
+	if token, ok := strings.CutPrefix(header, "Bearer "); ok {
+		return brain.Authenticate(h.authenticators, "TOKEN", []byte(token))
+	}
+	return "", fmt.Errorf("%w: unsupported authorization scheme", brain.ErrAuthenticationFailed)
+}
+
+func (h *Handler) listTopics(w http.ResponseWriter, r *http.Request) {
+	topics := []Topic{}
+	for _, topic := range h.broker.ListTopics(r.Context()) {
+		topics = append(topics, Topic{Name: topic.Name, Config: newTopicConfig(topic.Config)})
+	}
+	h.reply(w, topics)
+}
+
+func (h *Handler) describeTopic(w http.ResponseWriter, r *http.Request) {
+	d, err := h.broker.DescribeTopic(r.Context(), r.PathValue("topic"))
+	if err != nil {
+		h.fail(w, r, err)
+		return
+	}
+	h.reply(w, TopicDescription{Name: d.Name, Config: newTopicConfig(d.Config), Partitions: d.Partitions})
+}
+
+func (h *Handler) alterConfig(w http.ResponseWriter, r *http.Request) {
+	var body ConfigChange
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		h.fail(w, r, badRequest{err})
+		return
+	}
+	change, err := body.topicConfigChange()
+	if err != nil {
+		h.fail(w, r, err)
+		return
+	}
+	config, err := h.broker.AlterTopicConfig(r.Context(), r.PathValue("topic"), change)
+	if err != nil {
+		h.fail(w, r, err)
+		return
+	}
+	h.reply(w, newTopicConfig(config))
+}
+
+// Peeks return at most maxPeekLimit messages, defaultPeekLimit unless asked
+// otherwise.
+const (
+	defaultPeekLimit = 10
+	maxPeekLimit     = 100
+)
+
+func (h *Handler) peekMessages(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("topic")
+	n, err := strconv.Atoi(r.PathValue("partition"))
+	if err != nil {
+		h.fail(w, r, badRequest{fmt.Errorf("invalid partition %q", r.PathValue("partition"))})
+		return
+	}
+	limit := defaultPeekLimit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit < 1 || limit > maxPeekLimit {
+			h.fail(w, r, badRequest{fmt.Errorf("invalid limit %q, must be from 1 to %d", v, maxPeekLimit)})
+			return
+		}
+	}
+
+	config, err := h.broker.TopicConfig(name)
+	if err == nil && (n < 0 || n >= config.Partitions) {
+		err = fmt.Errorf("%w: topic %s has no partition %d", errNotFound, name, n)
+	}
+	if err != nil {
+		h.fail(w, r, err)
+		return
+	}
+	if v := r.URL.Query().Get("offsets"); v != "" {
+		h.peekOffsets(w, r, name, n, v)
+		return
+	}
+	first, next, err := h.broker.ListOffsets(r.Context(), name, n)
+	if err != nil {
+		h.fail(w, r, err)
+		return
+	}
+	// Starts at the oldest message without an offset
+	offset := first
+	if v := r.URL.Query().Get("offset"); v != "" {
+		if offset, err = strconv.Atoi(v); err != nil {
+			h.fail(w, r, badRequest{fmt.Errorf("invalid offset %q", v)})
+			return
+		}
+		if offset < first || offset > next {
+			h.fail(w, r, fmt.Errorf("%w: partition %d of topic %s holds offsets %d to %d, can't peek at %d",
+				brain.ErrOffsetOutOfRange, n, name, first, next, offset))
+			return
+		}
+	}
+
+	msgs, err := h.broker.Fetch(r.Context(), name, n, offset, brain.DefaultMaxFetchBytes)
+	if err != nil {
+		h.fail(w, r, err)
+		return
+	}
+	peeked := make([]Message, 0, min(len(msgs), limit))
+	for _, msg := range msgs[:min(len(msgs), limit)] {
+		peeked = append(peeked, Message{Offset: msg.Offset, Timestamp: msg.Timestamp, Data: msg.Data})
+	}
+	h.reply(w, peeked)
+}
+
+// peekOffsets replies with the messages of partition n of the topic called
+// name at the comma separated offsets of list, at most maxPeekLimit of them.
+// The offsets without a message are left out.
+func (h *Handler) peekOffsets(w http.ResponseWriter, r *http.Request, name string, n int, list string) {
+	fields := strings.Split(list, ",")
+	if len(fields) > maxPeekLimit {
+		h.fail(w, r, badRequest{fmt.Errorf("can't peek at more than %d offsets", maxPeekLimit)})
+		return
+	}
+	offsets := make([]int, 0, len(fields))
+	for _, field := range fields {
+		offset, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil {
+			h.fail(w, r, badRequest{fmt.Errorf("invalid offset %q", field)})
+			return
+		}
+		offsets = append(offsets, offset)
+	}
+
+	msgs, err := h.broker.FetchOffsets(r.Context(), name, n, offsets)
+	if err != nil {
+		h.fail(w, r, err)
+		return
+	}
+	peeked := make([]Message, 0, len(msgs))
+	for _, msg := range msgs {
+		peeked = append(peeked, Message{Offset: msg.Offset, Timestamp: msg.Timestamp, Data: msg.Data})
+	}
+	h.reply(w, peeked)
+}
+
+func (h *Handler) deleteRecords(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("topic")
+	n, err := strconv.Atoi(r.PathValue("partition"))
+	if err != nil {
+		h.fail(w, r, badRequest{fmt.Errorf("invalid partition %q", r.PathValue("partition"))})
+		return
+	}
+	var body DeleteRecords
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		h.fail(w, r, badRequest{err})
+		return
+	}
+
+	config, err := h.broker.TopicConfig(name)
+	if err == nil && (n < 0 || n >= config.Partitions) {
+		err = fmt.Errorf("%w: topic %s has no partition %d", errNotFound, name, n)
+	}
+	if err == nil {
+		err = h.broker.DeleteRecordsBefore(r.Context(), name, n, body.BeforeOffset)
+	}
+	if err != nil {
+		h.fail(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// listOffsets replies with the first and next offsets of a partition, and
+// with the offset of the first message stamped at or after the timestamp
+// query parameter if there is one, in RFC 3339 or in milliseconds since the
+// epoch.
+func (h *Handler) listOffsets(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("topic")
+	n, err := strconv.Atoi(r.PathValue("partition"))
+	if err != nil {
+		h.fail(w, r, badRequest{fmt.Errorf("invalid partition %q", r.PathValue("partition"))})
+		return
+	}
+	var timestamp time.Time
+	if v := r.URL.Query().Get("timestamp"); v != "" {
+		if ms, err := strconv.ParseInt(v, 10, 64); err == nil {
+			timestamp = time.UnixMilli(ms)
+		} else if timestamp, err = time.Parse(time.RFC3339Nano, v); err != nil {
+			h.fail(w, r, badRequest{fmt.Errorf("invalid timestamp %q, must be RFC 3339 or milliseconds since the epoch", v)})
+			return
+		}
+	}
+
+	config, err := h.broker.TopicConfig(name)
+	if err == nil && (n < 0 || n >= config.Partitions) {
+		err = fmt.Errorf("%w: topic %s has no partition %d", errNotFound, name, n)
+	}
+	if err != nil {
+		h.fail(w, r, err)
+		return
+	}
+	var offsets PartitionOffsets
+	if !timestamp.IsZero() {
+		offset, err := h.broker.OffsetForTimestamp(r.Context(), name, n, timestamp)
+		if err != nil {
+			h.fail(w, r, err)
+			return
+		}
+		offsets.TimestampOffset = &offset
+	}
+	if offsets.FirstOffset, offsets.NextOffset, err = h.broker.ListOffsets(r.Context(), name, n); err != nil {
+		h.fail(w, r, err)
+		return
+	}
+	h.reply(w, offsets)
+}
+
+func (h *Handler) reconfigure(w http.ResponseWriter, r *http.Request) {
+	var body BrokerConfigChange
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		h.fail(w, r, badRequest{err})
+		return
+	}
+	var level slog.Level
+	if body.LogLevel != nil {
+		var err error
+		if level, err = parseLevel(*body.LogLevel); err != nil {
+			h.fail(w, r, err)
+			return
+		}
+		if h.logLevel == nil {
+			h.fail(w, r, fmt.Errorf("%w: the log level can't be changed", brain.ErrInvalidConfig))
+			return
+		}
+	}
+
+	// Authorizes the log level change too
+	if err := h.broker.Reconfigure(r.Context(), body.brokerConfigChange()); err != nil {
+		h.fail(w, r, err)
+		return
+	}
+	if body.LogLevel != nil {
+		h.logLevel.Set(level)
+		h.logger.Info("changed log level", "level", level)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) listGroups(w http.ResponseWriter, r *http.Request) {
+	groups, err := h.broker.Groups(r.Context())
+	if err != nil {
+		h.fail(w, r, err)
+		return
+	}
+	if groups == nil {
+		groups = []string{}
+	}
+	h.reply(w, groups)
+}
+
+func (h *Handler) describeGroup(w http.ResponseWriter, r *http.Request) {
+	d, err := h.broker.DescribeGroup(r.Context(), r.PathValue("group"))
+	if err != nil {
+		h.fail(w, r, err)
+		return
+	}
+	h.reply(w, GroupDescription{Name: d.Name, Partitions: d.Partitions, Lag: d.Lag})
+}
+
+func (h *Handler) reply(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+var errNotFound = errors.New("not found")
+
+// badRequest is a request that can't be decoded.
+type badRequest struct {
+	err error
+}
+
+func (e badRequest) Error() string {
+	return e.err.Error()
+}
+
+// fail replies with the status err maps to and its message.
+func (h *Handler) fail(w http.ResponseWriter, r *http.Request, err error) {
+	code := http.StatusInternalServerError
+	switch {
+	case errors.As(err, new(badRequest)),
+		errors.Is(err, brain.ErrInvalidConfig),
+		errors.Is(err, brain.ErrOffsetOutOfRange):
+		code = http.StatusBadRequest
+	case errors.Is(err, brain.ErrThrottled):
+		code = http.StatusTooManyRequests
+	case errors.Is(err, brain.ErrAuthenticationFailed):
+		code = http.StatusUnauthorized
+	case errors.Is(err, brain.ErrUnauthorized):
+		code = http.StatusForbidden
+	case errors.Is(err, brain.ErrUnknownTopic), errors.Is(err, brain.ErrUnknownPartition),
+		errors.Is(err, brain.ErrUnknownGroup), errors.Is(err, errNotFound):
+		code = http.StatusNotFound
+	case errors.Is(err, brain.ErrClosed):
+		code = http.StatusServiceUnavailable
+	}
+	if code == http.StatusInternalServerError {
+		h.logger.Error("admin request failed", "method", r.Method, "path", r.URL.Path, "err", err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(errorResponse{Error: err.Error()})
+}
@@ -0,0 +1,10 @@
+package admin
+
+import "embed"
+
+// ui is the dashboard served under /ui/. It's a single page doing nothing but
+// requests to the API, so it shows what the token it's given is allowed to
+// see.
+//
+//go:embed ui
+var ui embed.FS
@@ -0,0 +1,153 @@
+package brain
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/mvaleed/brook/internal/storage"
+)
+
+const aclsFileName = "acls.json"
+
+var ErrUnauthorized = errors.New("unauthorized")
+
+// Operation is what an ACL lets a principal do with a topic.
+type Operation string
+
+const (
+	OperationProduce Operation = "produce"
+	OperationConsume Operation = "consume"
+	// OperationAdmin covers creating and moving topics. On the topic "*" it
+	// also covers managing the ACLs, on "ns/*" the ACLs on topics of the
+	// namespace ns.
+	OperationAdmin Operation = "admin"
+)
+
+// ACL allows a principal an operation on some topics.
+type ACL struct {
+	// Principal is who is allowed, "*" for anyone including anonymous
+	// clients.
+	Principal string `json:"principal"`
+	// Topic is the topic the ACL is about, "*" for every topic, or a prefix
+	// ending with "*", like "billing.*", or "billing/*" for the topics of a
+	// namespace.
+	Topic     string    `json:"topic"`
+	Operation Operation `json:"operation"`
+}
+
+func (a ACL) validate() error {
+	if a.Principal == "" {
+		return errors.New("ACL principal is required")
+	}
+	if a.Topic == "" || strings.Contains(strings.TrimSuffix(a.Topic, "*"), "*") {
+		return fmt.Errorf("invalid ACL topic %q", a.Topic)
+	}
+	switch a.Operation {
+	case OperationProduce, OperationConsume, OperationAdmin:
+	default:
+		return fmt.Errorf("unknown operation %q", a.Operation)
+	}
+	return nil
+}
+
+func (a ACL) allows(principal string, topic string, op Operation) bool {
+	if a.Operation != op || a.Principal != "*" && a.Principal != principal {
+		return false
+	}
+	if prefix, ok := strings.CutSuffix(a.Topic, "*"); ok {
+		return strings.HasPrefix(topic, prefix)
+	}
+	return a.Topic == topic
+}
+
+// ACLStore keeps the ACLs of a broker in a file, so they survive restarts.
+// Anything not allowed by an ACL is denied.
+type ACLStore struct {
+	dir string
+
+	mu   sync.RWMutex
+	acls []ACL
+}
+
+// openACLStore loads the ACLs kept in dir, none if there is no file yet.
+func openACLStore(dir string) (*ACLStore, error) {
+	s := &ACLStore{dir: dir}
+	data, err := os.ReadFile(filepath.Join(dir, aclsFileName))
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read ACLs: %w", err)
+	}
+
+	if err := json.Unmarshal(data, &s.acls); err != nil {
+		return nil, fmt.Errorf("invalid ACLs: %w", err)
+	}
+	for _, acl := range s.acls {
+		if err := acl.validate(); err != nil {
+			return nil, fmt.Errorf("invalid ACLs: %w", err)
+		}
+	}
+	return s, nil
+}
+
+// Allowed reports whether principal may do op on topic.
+func (s *ACLStore) Allowed(principal string, topic string, op Operation) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return slices.ContainsFunc(s.acls, func(acl ACL) bool {
+		return acl.allows(principal, topic, op)
+	})
+}
+
+// List returns every ACL.
+func (s *ACLStore) List() []ACL {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return slices.Clone(s.acls)
+}
+
+// add stores acl, unless it's there already.
+func (s *ACLStore) add(acl ACL) error {
+	if err := acl.validate(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if slices.Contains(s.acls, acl) {
+		return nil
+	}
+	return s.store(append(slices.Clone(s.acls), acl))
+}
+
+// remove deletes acl, if it's there.
+func (s *ACLStore) remove(acl ACL) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := slices.Index(s.acls, acl)
+	if i < 0 {
+		return nil
+	}
+	return s.store(slices.Delete(slices.Clone(s.acls), i, i+1))
+}
+
+// store writes acls and makes them the current ones.
+// Caller must hold s.mu.
+func (s *ACLStore) store(acls []ACL) error {
+	data, err := json.MarshalIndent(acls, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := storage.WriteFileAtomic(s.dir, aclsFileName, data); err != nil {
+		return fmt.Errorf("failed to write ACLs: %w", err)
+	}
+	s.acls = acls
+	return nil
+}
@@ -0,0 +1,96 @@
+package brain
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/mvaleed/brook/internal/storage"
+)
+
+func TestACL(t *testing.T) {
+	for _, tt := range []struct {
+		acl     ACL
+		allowed []string // principal/topic pairs the ACL allows to produce
+		denied  []string
+	}{
+		{
+			acl:     ACL{Principal: "alice", Topic: "orders", Operation: OperationProduce},
+			allowed: []string{"alice/orders"},
+			denied:  []string{"bob/orders", "alice/orders.eu", "/orders"},
+		},
+		{
+			acl:     ACL{Principal: "*", Topic: "billing.*", Operation: OperationProduce},
+			allowed: []string{"alice/billing.eu", "/billing.us", "bob/billing."},
+			denied:  []string{"alice/billing", "alice/orders"},
+		},
+		{
+			acl:     ACL{Principal: "alice", Topic: "*", Operation: OperationProduce},
+			allowed: []string{"alice/orders", "alice/billing.eu"},
+			denied:  []string{"bob/orders"},
+		},
+		{
+			acl:    ACL{Principal: "alice", Topic: "orders", Operation: OperationConsume},
+			denied: []string{"alice/orders"},
+		},
+	} {
+		for _, pair := range tt.allowed {
+			principal, topic, _ := strings.Cut(pair, "/")
+			require.True(t, tt.acl.allows(principal, topic, OperationProduce), "%v should allow %s", tt.acl, pair)
+		}
+		for _, pair := range tt.denied {
+			principal, topic, _ := strings.Cut(pair, "/")
+			require.False(t, tt.acl.allows(principal, topic, OperationProduce), "%v should deny %s", tt.acl, pair)
+		}
+	}
+
+	for _, acl := range []ACL{
+		{Topic: "orders", Operation: OperationProduce},
+		{Principal: "alice", Operation: OperationProduce},
+		{Principal: "alice", Topic: "or*ders", Operation: OperationProduce},
+		{Principal: "alice", Topic: "orders", Operation: "delete"},
+	} {
+		require.Error(t, acl.validate(), "%v", acl)
+	}
+}
+
+func TestBroker_Authorize(t *testing.T) {
+	paths := storage.Paths{Data: t.TempDir()}
+	config := DefaultBrokerConfig()
+	config.Authorize = true
+	config.SuperUsers = []string{"root"}
+	b, err := OpenBroker(paths, config)
+	require.NoError(t, err)
+
+	root := WithPrincipal(context.Background(), "root")
+	alice := WithPrincipal(context.Background(), "alice")
+	anonymous := context.Background()
+
+	require.ErrorIs(t, b.CreateTopic(alice, "orders", DefaultTopicConfig()), ErrUnauthorized)
+	require.NoError(t, b.CreateTopic(root, "orders", DefaultTopicConfig()))
+
+	require.ErrorIs(t, b.AddACL(alice, ACL{Principal: "alice", Topic: "*", Operation: OperationAdmin}), ErrUnauthorized)
+	require.NoError(t, b.AddACL(root, ACL{Principal: "alice", Topic: "orders", Operation: OperationProduce}))
+	require.NoError(t, b.AddACL(root, ACL{Principal: "*", Topic: "orders", Operation: OperationConsume}))
+	require.Error(t, b.AddACL(root, ACL{Principal: "alice", Topic: "orders", Operation: "delete"}))
+
+	require.NoError(t, b.Produce(alice, "orders", 0, []byte("order")))
+	require.ErrorIs(t, b.Produce(anonymous, "orders", 0, []byte("order")), ErrUnauthorized)
+	msgs, err := b.Fetch(anonymous, "orders", 0, 0, 1024)
+	require.NoError(t, err)
+	require.Len(t, msgs, 1)
+	require.ErrorIs(t, b.MovePartition(alice, "orders", 0, t.TempDir()), ErrUnauthorized)
+
+	// The ACLs survive a restart
+	require.NoError(t, b.Close())
+	b, err = OpenBroker(paths, config)
+	require.NoError(t, err)
+	defer b.Close()
+	require.Len(t, b.ACLs(), 2)
+	require.NoError(t, b.Produce(alice, "orders", 0, []byte("order")))
+
+	require.NoError(t, b.RemoveACL(root, ACL{Principal: "alice", Topic: "orders", Operation: OperationProduce}))
+	require.ErrorIs(t, b.Produce(alice, "orders", 0, []byte("order")), ErrUnauthorized)
+}
@@ -0,0 +1,239 @@
+package brain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/mvaleed/brook/internal/storage"
+)
+
+var (
+	ErrInvalidConfig    = errors.New("invalid config")
+	ErrOffsetOutOfRange = errors.New("offset out of range")
+)
+
+// TopicDescription is a topic's config and what its partitions hold on disk.
+type TopicDescription struct {
+	Name   string
+	Config TopicConfig
+	// Partitions are the stats of every partition, by number.
+	Partitions []storage.PartitionStats
+}
+
+// DescribeTopic returns the config of the topic called name and the stats of
+// its partitions. It needs the admin operation on the topic.
+func (b *Broker) DescribeTopic(ctx context.Context, name string) (TopicDescription, error) {
+	if err := b.authorize(ctx, name, OperationAdmin); err != nil {
+		return TopicDescription{}, err
+	}
+	config, err := b.TopicConfig(name)
+	if err != nil {
+		return TopicDescription{}, err
+	}
+
+	d := TopicDescription{Name: name, Config: config}
+	for n := range config.Partitions {
+		p, err := b.Partition(name, n)
+		if err != nil {
+			return TopicDescription{}, err
+		}
+		stats, err := p.Stats()
+		if err != nil {
+			return TopicDescription{}, fmt.Errorf("partition %d of %s: %w", n, name, err)
+		}
+		d.Partitions = append(d.Partitions, stats)
+	}
+	return d, nil
+}
+
+// TopicListing is a topic and its config.
+type TopicListing struct {
+	Name   string
+	Config TopicConfig
+}
+
+// ListTopics returns the topics the principal of ctx has the admin operation
+// on, with their configs, sorted by name. The others are left out.
+func (b *Broker) ListTopics(ctx context.Context) []TopicListing {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	var topics []TopicListing
+	for name := range b.topics {
+		if b.authorize(ctx, name, OperationAdmin) == nil {
+			topics = append(topics, TopicListing{Name: name, Config: b.metadata.Topics[name].config()})
+		}
+	}
+	slices.SortFunc(topics, func(a, b TopicListing) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return topics
+}
+
+// TopicConfigChange is a change to the config of a topic. Nil fields are left
+// as they are, and the number of partitions can't change.
+type TopicConfigChange struct {
+	Retention *time.Duration
+	// Compression applies to the messages produced from then on, the ones
+	// already stored stay in the codec they were stored in.
+	Compression *Compression
+	// MaxSegmentBytes applies once the broker opens the topic again, the
+	// open partitions keep their segment size until then.
+	MaxSegmentBytes *int64
+	// MaxMessageBytes applies to the messages produced from then on, up to
+	// the MaxRecordBytes of the open partitions: above it, once the broker
+	// opens the topic again.
+	MaxMessageBytes *int64
+	// Durability applies to the segments once the broker opens the topic
+	// again, though Produce fsyncs the messages of a topic made full-durable
+	// right away.
+	Durability *storage.DurabilityMode
+	Compact    *bool
+	Queue      *bool
+	// VisibilityTimeout applies to the messages received from then on.
+	VisibilityTimeout *time.Duration
+	// TimestampType applies to the messages produced from then on.
+	TimestampType    *TimestampType
+	MaxTimestampSkew *time.Duration
+}
+
+func (c TopicConfigChange) apply(config TopicConfig) TopicConfig {
+	if c.Retention != nil {
+		config.Retention = *c.Retention
+	}
+	if c.Compression != nil {
+		config.Compression = *c.Compression
+	}
+	if c.MaxSegmentBytes != nil {
+		config.MaxSegmentBytes = *c.MaxSegmentBytes
+	}
+	if c.MaxMessageBytes != nil {
+		config.MaxMessageBytes = *c.MaxMessageBytes
+	}
+	if c.Durability != nil {
+		config.Durability = *c.Durability
+	}
+	if c.Compact != nil {
+		config.Compact = *c.Compact
+	}
+	if c.Queue != nil {
+		config.Queue = *c.Queue
+	}
+	if c.VisibilityTimeout != nil {
+		config.VisibilityTimeout = *c.VisibilityTimeout
+	}
+	if c.TimestampType != nil {
+		config.TimestampType = *c.TimestampType
+	}
+	if c.MaxTimestampSkew != nil {
+		config.MaxTimestampSkew = *c.MaxTimestampSkew
+	}
+	return config.withDefaults()
+}
+
+// AlterTopicConfig durably applies change to the config of the topic called
+// name and returns the new config. It needs the admin operation on the topic.
+// An invalid config is an error matching ErrInvalidConfig.
+func (b *Broker) AlterTopicConfig(ctx context.Context, name string, change TopicConfigChange) (TopicConfig, error) {
+	if err := b.authorize(ctx, name, OperationAdmin); err != nil {
+		return TopicConfig{}, err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return TopicConfig{}, ErrClosed
+	}
+	previous, ok := b.metadata.Topics[name]
+	if !ok {
+		return TopicConfig{}, fmt.Errorf("%w: %s", ErrUnknownTopic, name)
+	}
+	config := change.apply(previous.config())
+	if err := config.Validate(); err != nil {
+		return TopicConfig{}, fmt.Errorf("%w: %w", ErrInvalidConfig, err)
+	}
+
+	topic := newTopicMetadata(config)
+	topic.DataDirs = previous.DataDirs
+	b.metadata.Topics[name] = topic
+	if err := storeMetadata(b.metaDir(), b.metadata); err != nil {
+		b.metadata.Topics[name] = previous
+		return TopicConfig{}, err
+	}
+	b.logger.Info("altered topic config", "topic", name)
+	return config, nil
+}
+
+// DeleteRecordsBefore deletes the records of partition n of the topic called
+// name before offset, see storage.Partition.TruncateBefore. It needs the
+// admin operation on the topic. An offset past the end of the partition is
+// an error matching ErrOffsetOutOfRange.
+func (b *Broker) DeleteRecordsBefore(ctx context.Context, name string, n int, offset int) error {
+	if err := b.authorize(ctx, name, OperationAdmin); err != nil {
+		return err
+	}
+	p, err := b.Partition(name, n)
+	if err != nil {
+		return err
+	}
+	if offset < 0 || offset > p.NextOffset() {
+		return fmt.Errorf("%w: partition %d of topic %s ends at %d, can't delete records before %d",
+			ErrOffsetOutOfRange, n, name, p.NextOffset(), offset)
+	}
+	if err := p.TruncateBefore(offset); err != nil {
+		return err
+	}
+	b.logger.Info("deleted records", "topic", name, "partition", n, "before", offset)
+	return nil
+}
+
+// BrokerConfigChange is a change to the settings of a broker that apply
+// without opening it again. Nil fields are left as they are.
+type BrokerConfigChange struct {
+	// DefaultRetention is the retention of the topics created from now on
+	// without a config.
+	DefaultRetention *time.Duration
+	// Quotas replaces every quota. The clients and topics still limited keep
+	// the debt they are in.
+	Quotas *QuotaConfig
+}
+
+// Reconfigure applies change to the running broker. It needs the admin
+// operation on the topic "*". An invalid change is an error matching
+// ErrInvalidConfig. The change isn't stored: a broker opened again takes the
+// settings of its BrokerConfig.
+func (b *Broker) Reconfigure(ctx context.Context, change BrokerConfigChange) error {
+	if err := b.authorize(ctx, "*", OperationAdmin); err != nil {
+		return err
+	}
+	if change.DefaultRetention != nil && *change.DefaultRetention < 0 {
+		return fmt.Errorf("%w: retention can't be negative", ErrInvalidConfig)
+	}
+	if change.Quotas != nil {
+		if err := change.Quotas.validate(); err != nil {
+			return fmt.Errorf("%w: %w", ErrInvalidConfig, err)
+		}
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return ErrClosed
+	}
+	if change.DefaultRetention != nil {
+		b.config.DefaultTopic.Retention = *change.DefaultRetention
+		b.logger.Info("changed default retention", "retention", *change.DefaultRetention)
+	}
+	if change.Quotas != nil {
+		b.config.Quotas = *change.Quotas
+		b.quotas.reconfigure(*change.Quotas)
+		b.logger.Info("changed quotas")
+	}
+	return nil
+}
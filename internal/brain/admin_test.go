@@ -0,0 +1,183 @@
+package brain
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/mvaleed/brook/internal/storage"
+)
+
+func TestBroker_DescribeTopic(t *testing.T) {
+	b := openTestBroker(t, storage.Paths{Data: t.TempDir()})
+	defer b.Close()
+	ctx := context.Background()
+
+	require.NoError(t, b.CreateTopic(ctx, "orders", TopicConfig{Partitions: 2}))
+	for range 3 {
+		require.NoError(t, b.Produce(ctx, "orders", 1, []byte("order")))
+	}
+
+	d, err := b.DescribeTopic(ctx, "orders")
+	require.NoError(t, err)
+	require.Equal(t, "orders", d.Name)
+	require.Equal(t, 2, d.Config.Partitions)
+	require.Len(t, d.Partitions, 2)
+	require.Equal(t, 0, d.Partitions[0].NextOffset)
+	require.Equal(t, 3, d.Partitions[1].NextOffset)
+	require.Equal(t, int64(3*(storage.HeaderSize+len("order"))), d.Partitions[1].ActiveSegmentBytes)
+
+	_, err = b.DescribeTopic(ctx, "payments")
+	require.ErrorIs(t, err, ErrUnknownTopic)
+
+	t.Run("needs the admin operation", func(t *testing.T) {
+		config := DefaultBrokerConfig()
+		config.Authorize = true
+		config.SuperUsers = []string{"root"}
+		b, err := OpenBroker(storage.Paths{Data: t.TempDir()}, config)
+		require.NoError(t, err)
+		defer b.Close()
+
+		root := WithPrincipal(ctx, "root")
+		require.NoError(t, b.CreateTopic(root, "orders", DefaultTopicConfig()))
+		_, err = b.DescribeTopic(WithPrincipal(ctx, "alice"), "orders")
+		require.ErrorIs(t, err, ErrUnauthorized)
+		_, err = b.DescribeTopic(root, "orders")
+		require.NoError(t, err)
+	})
+}
+
+func TestBroker_ListTopics(t *testing.T) {
+	ctx := context.Background()
+	config := DefaultBrokerConfig()
+	config.Authorize = true
+	config.SuperUsers = []string{"root"}
+	b, err := OpenBroker(storage.Paths{Data: t.TempDir()}, config)
+	require.NoError(t, err)
+	defer b.Close()
+
+	root := WithPrincipal(ctx, "root")
+	require.NoError(t, b.CreateTopic(root, "payments", TopicConfig{Partitions: 2}))
+	require.NoError(t, b.CreateTopic(root, "orders", DefaultTopicConfig()))
+	require.NoError(t, b.AddACL(root, ACL{Principal: "alice", Topic: "orders", Operation: OperationAdmin}))
+
+	topics := b.ListTopics(root)
+	require.Len(t, topics, 2)
+	require.Equal(t, "orders", topics[0].Name)
+	require.Equal(t, "payments", topics[1].Name)
+	require.Equal(t, 2, topics[1].Config.Partitions)
+
+	// Only the ones the principal may administer
+	topics = b.ListTopics(WithPrincipal(ctx, "alice"))
+	require.Len(t, topics, 1)
+	require.Equal(t, "orders", topics[0].Name)
+	require.Empty(t, b.ListTopics(ctx))
+}
+
+func TestBroker_AlterTopicConfig(t *testing.T) {
+	paths := storage.Paths{Data: t.TempDir()}
+	b := openTestBroker(t, paths)
+	ctx := context.Background()
+	require.NoError(t, b.CreateTopic(ctx, "orders", TopicConfig{Partitions: 2, Retention: time.Hour}))
+
+	compression := CompressionGzip
+	durability := storage.DurabilityFull
+	config, err := b.AlterTopicConfig(ctx, "orders", TopicConfigChange{Compression: &compression, Durability: &durability})
+	require.NoError(t, err)
+	require.Equal(t, TopicConfig{Partitions: 2, Retention: time.Hour, Compression: CompressionGzip, Durability: storage.DurabilityFull}, config)
+
+	retention := -time.Hour
+	_, err = b.AlterTopicConfig(ctx, "orders", TopicConfigChange{Retention: &retention})
+	require.ErrorIs(t, err, ErrInvalidConfig)
+	_, err = b.AlterTopicConfig(ctx, "payments", TopicConfigChange{Compression: &compression})
+	require.ErrorIs(t, err, ErrUnknownTopic)
+
+	// The change survives a restart
+	require.NoError(t, b.Close())
+	b = openTestBroker(t, paths)
+	defer b.Close()
+	config, err = b.TopicConfig("orders")
+	require.NoError(t, err)
+	require.Equal(t, CompressionGzip, config.Compression)
+	require.Equal(t, storage.DurabilityFull, config.Durability)
+}
+
+func TestBroker_DeleteRecordsBefore(t *testing.T) {
+	b := openTestBroker(t, storage.Paths{Data: t.TempDir()})
+	defer b.Close()
+	ctx := context.Background()
+	require.NoError(t, b.CreateTopic(ctx, "orders", DefaultTopicConfig()))
+	for i := range 5 {
+		require.NoError(t, b.Produce(ctx, "orders", 0, fmt.Appendf(nil, "order %d", i)))
+	}
+
+	require.NoError(t, b.DeleteRecordsBefore(ctx, "orders", 0, 3))
+	p, err := b.Partition("orders", 0)
+	require.NoError(t, err)
+	require.Equal(t, 3, p.FirstOffset())
+	msgs, err := b.Fetch(ctx, "orders", 0, 3, 1024)
+	require.NoError(t, err)
+	require.Len(t, msgs, 2)
+	require.Equal(t, "order 3", string(msgs[0].Data))
+
+	require.ErrorIs(t, b.DeleteRecordsBefore(ctx, "orders", 0, 6), ErrOffsetOutOfRange)
+	require.ErrorIs(t, b.DeleteRecordsBefore(ctx, "payments", 0, 1), ErrUnknownTopic)
+}
+
+func TestBroker_Reconfigure(t *testing.T) {
+	config := DefaultBrokerConfig()
+	config.AutoCreateTopics = true
+	config.Quotas = QuotaConfig{Client: ByteRates{ProduceBytesPerSecond: 1000}}
+	b, err := OpenBroker(storage.Paths{Data: t.TempDir()}, config)
+	require.NoError(t, err)
+	defer b.Close()
+	now := time.Now()
+	b.quotas.now = func() time.Time { return now }
+	app := WithClientID(context.Background(), "app")
+	data := make([]byte, 600)
+
+	require.NoError(t, b.Produce(app, "orders", 0, data))
+	require.NoError(t, b.Produce(app, "orders", 0, data))
+	require.ErrorIs(t, b.Produce(app, "orders", 0, data), ErrThrottled)
+
+	// The debt is kept at the new rate: 200 bytes at 2000 per second
+	retention := time.Hour
+	require.NoError(t, b.Reconfigure(app, BrokerConfigChange{
+		DefaultRetention: &retention,
+		Quotas:           &QuotaConfig{Client: ByteRates{ProduceBytesPerSecond: 2000}},
+	}))
+	var throttled *ThrottleError
+	require.ErrorAs(t, b.Produce(app, "orders", 0, data), &throttled)
+	require.Equal(t, 100*time.Millisecond, throttled.RetryAfter)
+
+	// Topics created from now on take the new default
+	require.NoError(t, b.Produce(WithClientID(context.Background(), "other"), "payments", 0, data))
+	created, err := b.TopicConfig("payments")
+	require.NoError(t, err)
+	require.Equal(t, time.Hour, created.Retention)
+
+	// Without quotas nothing is throttled anymore
+	require.NoError(t, b.Reconfigure(app, BrokerConfigChange{Quotas: &QuotaConfig{}}))
+	require.NoError(t, b.Produce(app, "orders", 0, data))
+
+	negative := -time.Second
+	require.ErrorIs(t, b.Reconfigure(app, BrokerConfigChange{DefaultRetention: &negative}), ErrInvalidConfig)
+	require.ErrorIs(t, b.Reconfigure(app, BrokerConfigChange{Quotas: &QuotaConfig{Topic: ByteRates{FetchBytesPerSecond: -1}}}), ErrInvalidConfig)
+
+	t.Run("needs the admin operation on every topic", func(t *testing.T) {
+		config := DefaultBrokerConfig()
+		config.Authorize = true
+		config.SuperUsers = []string{"root"}
+		b, err := OpenBroker(storage.Paths{Data: t.TempDir()}, config)
+		require.NoError(t, err)
+		defer b.Close()
+
+		ctx := context.Background()
+		require.NoError(t, b.AddACL(WithPrincipal(ctx, "root"), ACL{Principal: "alice", Topic: "orders", Operation: OperationAdmin}))
+		require.ErrorIs(t, b.Reconfigure(WithPrincipal(ctx, "alice"), BrokerConfigChange{}), ErrUnauthorized)
+		require.NoError(t, b.Reconfigure(WithPrincipal(ctx, "root"), BrokerConfigChange{}))
+	})
+}
@@ -0,0 +1,104 @@
+package brain
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+)
+
+var ErrAuthenticationFailed = errors.New("authentication failed")
+
+// Authenticator checks the credentials a client presents for one SASL-style
+// mechanism and tells who the client is.
+type Authenticator interface {
+	// Mechanism is the name clients ask for, like "PLAIN".
+	Mechanism() string
+	// Authenticate returns the principal the credentials belong to, or an
+	// error matching ErrAuthenticationFailed.
+	Authenticate(credentials []byte) (string, error)
+}
+
+// PlainAuthenticator implements SASL PLAIN (RFC 4616): the credentials are an
+// optional authorization identity, a user name and a password separated by
+// NUL bytes. Only use it over TLS, the password travels in the clear.
+type PlainAuthenticator struct {
+	// Users maps user names to their password.
+	Users map[string]string
+}
+
+func (a *PlainAuthenticator) Mechanism() string {
+	return "PLAIN"
+}
+
+func (a *PlainAuthenticator) Authenticate(credentials []byte) (string, error) {
+	parts := bytes.Split(credentials, []byte{0})
+	if len(parts) != 3 {
+		return "", fmt.Errorf("%w: malformed PLAIN credentials", ErrAuthenticationFailed)
+	}
+	authzid, user, password := string(parts[0]), string(parts[1]), parts[2]
+	if authzid != "" && authzid != user {
+		return "", fmt.Errorf("%w: %s can't act as %s", ErrAuthenticationFailed, user, authzid)
+	}
+
+	want, ok := a.Users[user]
+	// Compared whether the user exists or not, so that timing doesn't tell
+	if !equalSecrets(password, []byte(want)) || !ok {
+		return "", fmt.Errorf("%w: invalid user name or password", ErrAuthenticationFailed)
+	}
+	return user, nil
+}
+
+// TokenAuthenticator authenticates clients by a bearer token, such as one
+// handed out to a service.
+type TokenAuthenticator struct {
+	// Tokens maps tokens to the principal they authenticate.
+	Tokens map[string]string
+}
+
+func (a *TokenAuthenticator) Mechanism() string {
+	return "TOKEN"
+}
+
+func (a *TokenAuthenticator) Authenticate(credentials []byte) (string, error) {
+	for token, principal := range a.Tokens {
+		if equalSecrets(credentials, []byte(token)) {
+			return principal, nil
+		}
+	}
+	return "", fmt.Errorf("%w: invalid token", ErrAuthenticationFailed)
+}
+
+// equalSecrets compares secrets in constant time, whatever their lengths.
+func equalSecrets(a []byte, b []byte) bool {
+	ha, hb := sha256.Sum256(a), sha256.Sum256(b)
+	return subtle.ConstantTimeCompare(ha[:], hb[:]) == 1
+}
+
+// Authenticate authenticates credentials with the authenticator of mechanism
+// among authenticators.
+func Authenticate(authenticators []Authenticator, mechanism string, credentials []byte) (string, error) {
+	for _, a := range authenticators {
+		if a.Mechanism() == mechanism {
+			return a.Authenticate(credentials)
+		}
+	}
+	return "", fmt.Errorf("%w: unsupported mechanism %s", ErrAuthenticationFailed, mechanism)
+}
+
+type principalKey struct{}
+
+// WithPrincipal returns a context carrying the authenticated principal making
+// requests with it, which the broker authorizes them for.
+func WithPrincipal(ctx context.Context, principal string) context.Context {
+	return context.WithValue(ctx, principalKey{}, principal)
+}
+
+// Principal returns the principal carried by ctx, "" for an anonymous
+// client.
+func Principal(ctx context.Context) string {
+	principal, _ := ctx.Value(principalKey{}).(string)
+	return principal
+}
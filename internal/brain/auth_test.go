@@ -0,0 +1,47 @@
+package brain
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuthenticate(t *testing.T) {
+	authenticators := []Authenticator{
+		&PlainAuthenticator{Users: map[string]string{"alice": "secret"}},
+		&TokenAuthenticator{Tokens: map[string]string{"t0k3n": "billing-service"}},
+	}
+
+	for _, tt := range []struct {
+		name        string
+		mechanism   string
+		credentials string
+		principal   string
+	}{
+		{name: "plain", mechanism: "PLAIN", credentials: "\x00alice\x00secret", principal: "alice"},
+		{name: "plain with authorization identity", mechanism: "PLAIN", credentials: "alice\x00alice\x00secret", principal: "alice"},
+		{name: "wrong password", mechanism: "PLAIN", credentials: "\x00alice\x00guess"},
+		{name: "unknown user", mechanism: "PLAIN", credentials: "\x00bob\x00secret"},
+		{name: "acting as someone else", mechanism: "PLAIN", credentials: "bob\x00alice\x00secret"},
+		{name: "malformed", mechanism: "PLAIN", credentials: "alice:secret"},
+		{name: "token", mechanism: "TOKEN", credentials: "t0k3n", principal: "billing-service"},
+		{name: "wrong token", mechanism: "TOKEN", credentials: "t0k3"},
+		{name: "unknown mechanism", mechanism: "SCRAM-SHA-256", credentials: "t0k3n"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			principal, err := Authenticate(authenticators, tt.mechanism, []byte(tt.credentials))
+			if tt.principal == "" {
+				require.ErrorIs(t, err, ErrAuthenticationFailed)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.principal, principal)
+		})
+	}
+
+	t.Run("principal travels in the context", func(t *testing.T) {
+		require.Empty(t, Principal(context.Background()))
+		require.Equal(t, "alice", Principal(WithPrincipal(context.Background(), "alice")))
+	})
+}
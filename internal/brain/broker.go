@@ -0,0 +1,734 @@
+package brain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/mvaleed/brook/internal/storage"
+)
+
+var (
+	ErrTopicExists      = errors.New("topic already exists")
+	ErrUnknownTopic     = errors.New("unknown topic")
+	ErrUnknownPartition = errors.New("unknown partition")
+)
+
+// Compression is the codec a topic's messages are stored and fetched in.
+// Producers either compress with it, or say which codec they compressed with
+// and the broker recompresses, see Broker.ProduceCompressed.
+type Compression string
+
+const (
+	CompressionNone Compression = "none"
+	CompressionGzip Compression = "gzip"
+)
+
+// TopicConfig is the configuration of a topic, kept in the broker metadata.
+// The zero value of an override leaves the broker's setting in place.
+type TopicConfig struct {
+	// Partitions is the number of partitions of the topic. It can't change
+	// once the topic is created.
+	Partitions int
+	// Retention is how long messages are kept, zero to keep them forever.
+	Retention time.Duration
+	// Compression defaults to CompressionNone.
+	Compression Compression
+	// MaxSegmentBytes overrides the segment size of the broker's partition
+	// config.
+	MaxSegmentBytes int64
+	// MaxMessageBytes caps the size of a message, as produced and as
+	// stored. Larger ones are refused with a MessageTooLargeError before
+	// they are appended. Defaults to the MaxRecordBytes of the broker's
+	// partition config, which is raised for the topic's partitions if
+	// MaxMessageBytes is above it.
+	MaxMessageBytes int64
+	// Durability is DurabilityMedium (the default), where Produce returns
+	// once the message is handed to the OS, DurabilityAsync, where it returns
+	// once the message is buffered in the process, or DurabilityFull, where it
+	// returns once the message is fsynced. The partitions write their
+	// segments this way, see storage.PartitionConfig.Durability.
+	Durability storage.DurabilityMode
+	// Compact marks the topic as keyed state of which only the last message
+	// of each key matters. It is only bookkeeping for now, nothing is
+	// compacted.
+	Compact bool
+	// Queue lets consumer groups receive, ack and nack the messages of the
+	// topic one by one, see Broker.Receive, besides consuming them in order.
+	Queue bool
+	// VisibilityTimeout is how long a message received from a queue stays
+	// invisible to the rest of the group before it's received again, unless
+	// acked. DefaultVisibilityTimeout if zero.
+	VisibilityTimeout time.Duration
+	// TimestampType is where the timestamps of the messages come from,
+	// TimestampLogAppendTime if empty.
+	TimestampType TimestampType
+	// MaxTimestampSkew is how far from the broker's clock the timestamps
+	// producers give messages may be with TimestampCreateTime.
+	// DefaultMaxTimestampSkew if zero.
+	MaxTimestampSkew time.Duration
+}
+
+func DefaultTopicConfig() TopicConfig {
+	return TopicConfig{
+		Partitions:  1,
+		Compression: CompressionNone,
+		Durability:  storage.DurabilityMedium,
+	}
+}
+
+// withDefaults fills in the settings left empty.
+func (c TopicConfig) withDefaults() TopicConfig {
+	if c.Compression == "" {
+		c.Compression = CompressionNone
+	}
+	if c.Durability == 0 {
+		c.Durability = storage.DurabilityMedium
+	}
+	return c
+}
+
+// Validate returns why c can't configure a topic, nil if it can.
+func (c TopicConfig) Validate() error {
+	if c.Partitions <= 0 {
+		return errors.New("partitions must be positive")
+	}
+	if c.Retention < 0 {
+		return errors.New("retention can't be negative")
+	}
+	switch c.Compression {
+	case "", CompressionNone, CompressionGzip:
+	default:
+		return fmt.Errorf("unknown compression %q", c.Compression)
+	}
+	if c.MaxSegmentBytes < 0 {
+		return errors.New("max segment bytes can't be negative")
+	}
+	if c.MaxMessageBytes < 0 || c.MaxMessageBytes > math.MaxUint32-storage.HeaderSize {
+		return fmt.Errorf("max message bytes must be in [0, %d]", uint32(math.MaxUint32-storage.HeaderSize))
+	}
+	if c.VisibilityTimeout < 0 {
+		return errors.New("visibility timeout can't be negative")
+	}
+	switch c.TimestampType {
+	case "", TimestampLogAppendTime, TimestampCreateTime:
+	default:
+		return fmt.Errorf("unknown timestamp type %q", c.TimestampType)
+	}
+	if c.MaxTimestampSkew < 0 {
+		return errors.New("max timestamp skew can't be negative")
+	}
+	switch c.Durability {
+	case 0, storage.DurabilityAsync, storage.DurabilityMedium, storage.DurabilityFull:
+	default:
+		return fmt.Errorf("unsupported topic durability %s", c.Durability)
+	}
+	return nil
+}
+
+// partitionConfig is the config of the topic's partitions, the broker's one
+// with the topic's overrides.
+func (c TopicConfig) partitionConfig(base storage.PartitionConfig) storage.PartitionConfig {
+	if c.MaxSegmentBytes > 0 {
+		base.MaxSegmentBytes = c.MaxSegmentBytes
+	}
+	if c.MaxMessageBytes > (TopicConfig{}).maxMessageBytes(base) {
+		base.MaxRecordBytes = c.MaxMessageBytes
+	}
+	base.Durability = c.withDefaults().Durability
+	return base
+}
+
+// BrokerConfig configures a Broker.
+type BrokerConfig struct {
+	// Partition is the config of every partition, before the overrides of
+	// its topic.
+	Partition storage.PartitionConfig
+	// AutoCreateTopics lets Produce create the topics it doesn't know, with
+	// DefaultTopic as their config.
+	AutoCreateTopics bool
+	DefaultTopic     TopicConfig
+	// Quotas caps the produce and fetch byte rates of clients and topics.
+	Quotas QuotaConfig
+	// Authorize enforces the ACLs on the principal in the context of every
+	// request, see WithPrincipal. Without it anyone can do anything.
+	Authorize bool
+	// SuperUsers are the principals allowed everything whatever the ACLs,
+	// to set them up to begin with.
+	SuperUsers []string
+	// ValidateSchemas makes Produce reject the payloads of topics with a
+	// registered schema that don't match it, see RegisterSchema.
+	ValidateSchemas bool
+	// DataDirs are more data directories, one per disk, that new partitions
+	// are spread over along with the data path of the broker. One going
+	// read-only only fences the partitions it holds, see ErrDataDirOffline.
+	DataDirs []string
+	// Placement chooses the directory of each new partition.
+	Placement Placement
+	// RetentionCheckInterval is how often the segments past the retention
+	// of their topic are deleted, zero to never delete them.
+	RetentionCheckInterval time.Duration
+	// OpenConcurrency is how many partitions are opened at once when the
+	// broker opens, DefaultOpenConcurrency if zero.
+	OpenConcurrency int
+	// MaxFetchBytes caps the payload bytes a fetch returns, whatever it asks
+	// for, DefaultMaxFetchBytes if zero. The first message of a fetch is
+	// returned whatever its size, for the consumer to get past it.
+	MaxFetchBytes int
+}
+
+// Validate returns why c can't configure a broker, nil if it can. The default
+// topic config only matters with AutoCreateTopics.
+func (c BrokerConfig) Validate() error {
+	if err := c.Partition.Validate(); err != nil {
+		return fmt.Errorf("invalid partition config: %w", err)
+	}
+	switch c.Placement {
+	case PlacementRoundRobin, PlacementFreeSpace:
+	default:
+		return fmt.Errorf("unknown placement %s", c.Placement)
+	}
+	if c.RetentionCheckInterval < 0 {
+		return errors.New("retention check interval can't be negative")
+	}
+	if c.OpenConcurrency < 0 {
+		return errors.New("open concurrency can't be negative")
+	}
+	if c.MaxFetchBytes < 0 {
+		return errors.New("max fetch bytes can't be negative")
+	}
+	if c.AutoCreateTopics {
+		if err := c.DefaultTopic.Validate(); err != nil {
+			return fmt.Errorf("invalid default topic config: %w", err)
+		}
+	}
+	return c.Quotas.validate()
+}
+
+func DefaultBrokerConfig() BrokerConfig {
+	return BrokerConfig{
+		Partition:              storage.DefaultPartitionConfig(),
+		DefaultTopic:           DefaultTopicConfig(),
+		RetentionCheckInterval: DefaultRetentionCheckInterval,
+	}
+}
+
+// Broker keeps many topics, each split in partitions, under one set of paths.
+// The topics and their configs are kept in a metadata file under the meta
+// path, so a broker opened again finds every topic it had. Partition n of
+// topic t lives in the directory t-n, as in Kafka.
+type Broker struct {
+	paths  storage.Paths
+	config BrokerConfig
+	logger *slog.Logger
+	quotas *quotas
+	queues *queues
+	acls   *ACLStore
+	// schemas has its own lock, taken before mu
+	schemas *schemaRegistry
+
+	moveMu   sync.Mutex // serializes MovePartition
+	mu       sync.RWMutex
+	closed   bool
+	metadata metadata
+	topics   map[string][]*storage.Partition
+	// offlineDirs holds why each offline data directory went offline
+	offlineDirs map[string]error
+	// placed counts the partitions placed round robin
+	placed int
+	// diskFree returns the free space of a directory, replaced by tests
+	diskFree func(dir string) (uint64, error)
+
+	// stopRetention and retentionDone stop the retention worker and tell
+	// it's done, nil without one
+	stopRetention     chan struct{}
+	retentionDone     chan struct{}
+	stopRetentionOnce sync.Once
+}
+
+// OpenBroker opens the broker kept under paths, with every partition of every
+// topic in its metadata. The partitions are opened concurrently, see
+// BrokerConfig.OpenConcurrency.
+func OpenBroker(paths storage.Paths, config BrokerConfig) (*Broker, error) {
+	if err := paths.Validate(); err != nil {
+		return nil, err
+	}
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
+	logger := config.Partition.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+
+	b := &Broker{
+		paths:   paths,
+		config:  config,
+		logger:  logger,
+		quotas:  newQuotas(config.Quotas),
+		queues:  newQueues(),
+		schemas: newSchemaRegistry(),
+		topics:  make(map[string][]*storage.Partition),
+
+		offlineDirs: make(map[string]error),
+		diskFree:    storage.FreeSpace,
+	}
+	b.checkDataDirs()
+
+	var err error
+	b.metadata, err = loadMetadata(b.metaDir())
+	if err != nil {
+		return nil, err
+	}
+	b.acls, err = openACLStore(b.metaDir())
+	if err != nil {
+		return nil, err
+	}
+	if err := b.openTopics(); err != nil {
+		return nil, errors.Join(err, b.Close())
+	}
+	if partitions, ok := b.topics[schemasTopic]; ok {
+		if err := b.schemas.load(partitions[0]); err != nil {
+			return nil, errors.Join(err, b.Close())
+		}
+	}
+	if config.RetentionCheckInterval > 0 {
+		b.stopRetention = make(chan struct{})
+		b.retentionDone = make(chan struct{})
+		go b.enforceRetention(config.RetentionCheckInterval)
+	}
+	return b, nil
+}
+
+func (b *Broker) metaDir() string {
+	if b.paths.Meta == "" {
+		return b.paths.Data
+	}
+	return b.paths.Meta
+}
+
+// partitionName is the directory of a topic's partition, within the one of
+// its namespace.
+func partitionName(topic string, partition int) string {
+	_, name := SplitTopicName(topic)
+	return name + "-" + strconv.Itoa(partition)
+}
+
+// partitionPaths returns the paths partition n of topic is kept under, the
+// broker's unless it was moved to another data path.
+func (b *Broker) partitionPaths(topic topicMetadata, n int) storage.Paths {
+	paths := b.paths
+	if dir, ok := topic.DataDirs[n]; ok {
+		paths.Data = dir
+	}
+	return paths
+}
+
+// openPartitions opens every partition of topic, or none of them.
+func (b *Broker) openPartitions(name string, topic topicMetadata) ([]*storage.Partition, error) {
+	partitionConfig := topic.config().partitionConfig(b.config.Partition)
+	partitions := make([]*storage.Partition, 0, topic.Partitions)
+	for i := range topic.Partitions {
+		p, err := namespacePaths(b.partitionPaths(topic, i), name).OpenPartition(partitionName(name, i), partitionConfig)
+		if err != nil {
+			for _, opened := range partitions {
+				err = errors.Join(err, opened.Close())
+			}
+			return nil, fmt.Errorf("failed to open partition %d of topic %s: %w", i, name, err)
+		}
+		partitions = append(partitions, p)
+	}
+	return partitions, nil
+}
+
+// CreateTopic creates the topic called name with its partitions, and records
+// it in the metadata.
+func (b *Broker) CreateTopic(ctx context.Context, name string, config TopicConfig) error {
+	if err := b.authorize(ctx, name, OperationAdmin); err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return ErrClosed
+	}
+	if _, ok := b.topics[name]; ok {
+		return fmt.Errorf("%w: %s", ErrTopicExists, name)
+	}
+	_, err := b.createTopic(name, config)
+	return err
+}
+
+// createTopic creates a topic that doesn't exist yet and returns its
+// partitions.
+// Caller must hold b.mu.
+func (b *Broker) createTopic(name string, config TopicConfig) ([]*storage.Partition, error) {
+	if err := validateTopicName(name); err != nil {
+		return nil, err
+	}
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	config = config.withDefaults()
+
+	topic := newTopicMetadata(config)
+	dirs, err := b.placePartitions(config)
+	if err != nil {
+		return nil, err
+	}
+	for n, dir := range dirs {
+		if dir == filepath.Clean(b.paths.Data) {
+			continue
+		}
+		if topic.DataDirs == nil {
+			topic.DataDirs = make(map[int]string)
+		}
+		topic.DataDirs[n] = dir
+	}
+	partitions, err := b.openPartitions(name, topic)
+	if err != nil {
+		return nil, err
+	}
+
+	b.metadata.Topics[name] = topic
+	if err := storeMetadata(b.metaDir(), b.metadata); err != nil {
+		delete(b.metadata.Topics, name)
+		for _, p := range partitions {
+			err = errors.Join(err, p.Close())
+		}
+		return nil, err
+	}
+	b.topics[name] = partitions
+	return partitions, nil
+}
+
+// Topics returns the names of every topic, sorted.
+func (b *Broker) Topics() []string {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	names := make([]string, 0, len(b.topics))
+	for name := range b.topics {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// TopicConfig returns the config the topic called name was created with.
+func (b *Broker) TopicConfig(name string) (TopicConfig, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	topic, ok := b.metadata.Topics[name]
+	if !ok {
+		return TopicConfig{}, fmt.Errorf("%w: %s", ErrUnknownTopic, name)
+	}
+	return topic.config(), nil
+}
+
+// Partition returns partition n of the topic called name.
+func (b *Broker) Partition(name string, n int) (*storage.Partition, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.partition(name, n)
+}
+
+// Caller must hold b.mu.
+func (b *Broker) partition(name string, n int) (*storage.Partition, error) {
+	if b.closed {
+		return nil, ErrClosed
+	}
+	partitions, ok := b.topics[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownTopic, name)
+	}
+	if n < 0 || n >= len(partitions) {
+		return nil, fmt.Errorf("%w: topic %s has no partition %d", ErrUnknownPartition, name, n)
+	}
+	return partitions[n], nil
+}
+
+// Produce appends data to partition n of the topic called name, creating the
+// topic first if it's unknown and AutoCreateTopics is set. It returns as
+// soon as the topic's durability allows. A client or topic over its produce
+// quota gets a ThrottleError.
+func (b *Broker) Produce(ctx context.Context, name string, n int, data []byte) error {
+	return b.produce(ctx, name, n, data, time.Time{}, "")
+}
+
+// produce is ProduceCompressed, and ProduceAt with data in the codec of the
+// topic when codec is empty.
+func (b *Broker) produce(ctx context.Context, name string, n int, data []byte, timestamp time.Time, codec Compression) error {
+	if err := b.authorize(ctx, name, OperationProduce); err != nil {
+		return err
+	}
+	client := ClientID(ctx)
+	if err := b.quotas.admit(client, name, quotaProduce); err != nil {
+		return err
+	}
+	p, config, err := b.producePartition(ctx, name, n)
+	if err != nil {
+		return err
+	}
+	timestamp, err = config.messageTimestamp(timestamp, time.Now())
+	if err != nil {
+		return err
+	}
+	limit := config.maxMessageBytes(b.config.Partition)
+	if int64(len(data)) > limit {
+		return &MessageTooLargeError{Topic: name, Size: int64(len(data)), Limit: limit}
+	}
+	stored := data
+	if codec != "" {
+		if stored, err = recompress(data, codec, config.Compression, limit); err != nil {
+			var tooLarge *MessageTooLargeError
+			if errors.As(err, &tooLarge) {
+				tooLarge.Topic = name
+			}
+			return err
+		}
+		if int64(len(stored)) > limit {
+			return &MessageTooLargeError{Topic: name, Size: int64(len(stored)), Limit: limit}
+		}
+	}
+	if b.config.ValidateSchemas {
+		if err := b.schemas.validate(name, stored); err != nil {
+			return err
+		}
+	}
+
+	if err := p.AppendAt(ctx, stored, timestamp); err != nil {
+		var tooLarge *storage.RecordTooLargeError
+		if errors.As(err, &tooLarge) {
+			// Over the limit of partitions opened before it was raised
+			return &MessageTooLargeError{Topic: name, Size: tooLarge.Size, Limit: tooLarge.Limit}
+		}
+		return b.writeFailed(name, n, err)
+	}
+	b.quotas.charge(client, name, quotaProduce, len(data))
+	if config.Durability == storage.DurabilityFull {
+		if err := p.Sync(); err != nil {
+			return b.writeFailed(name, n, err)
+		}
+	}
+	return nil
+}
+
+// Fetch returns the messages of partition n of the topic called name from
+// offset on, as many as fit in maxBytes, and in BrokerConfig.MaxFetchBytes,
+// but at least one if there is any. The message that doesn't fit isn't read.
+// Expired messages are skipped. It doesn't wait for messages to be published,
+// and returns none if there aren't any past offset. A client or topic over its
+// fetch quota gets a ThrottleError.
+func (b *Broker) Fetch(ctx context.Context, name string, n int, offset int, maxBytes int) ([]Message, error) {
+	msgs, _, err := b.FetchFiltered(ctx, name, n, offset, maxBytes, FetchFilter{})
+	return msgs, err
+}
+
+// FetchOffsets returns the messages of partition n of the topic called name
+// at offsets, sorted by offset, for tools that look up messages here and
+// there rather than read the partition in order. Offsets without a message,
+// out of range or compacted away, are left out, and so are the ones past the
+// messages that fit in BrokerConfig.MaxFetchBytes, as with Fetch the first
+// message is returned whatever its size. It takes the consume operation on
+// the topic, and is held to its fetch quotas like Fetch.
+func (b *Broker) FetchOffsets(ctx context.Context, name string, n int, offsets []int) ([]Message, error) {
+	if err := b.authorize(ctx, name, OperationConsume); err != nil {
+		return nil, err
+	}
+	client := ClientID(ctx)
+	if err := b.quotas.admit(client, name, quotaFetch); err != nil {
+		return nil, err
+	}
+	p, err := b.Partition(name, n)
+	if err != nil {
+		return nil, err
+	}
+
+	maxBytes := DefaultMaxFetchBytes
+	if b.config.MaxFetchBytes > 0 {
+		maxBytes = b.config.MaxFetchBytes
+	}
+	records, err := p.FindRecords(ctx, offsets, int64(maxBytes))
+	if err != nil {
+		return nil, err
+	}
+	msgs := make([]Message, 0, len(records))
+	size := 0
+	for offset, record := range records {
+		msgs = append(msgs, Message{
+			Offset:    offset,
+			Timestamp: time.Unix(0, int64(record.Header.Timestamp)),
+			Data:      record.Payload,
+		})
+		size += len(record.Payload)
+	}
+	slices.SortFunc(msgs, func(a, b Message) int {
+		return a.Offset - b.Offset
+	})
+	b.quotas.charge(client, name, quotaFetch, size)
+	return msgs, nil
+}
+
+// producePartition returns partition n of the topic called name along with
+// the topic's config, auto-creating the topic if needed. Auto-creating takes
+// the admin operation on the topic, like CreateTopic.
+func (b *Broker) producePartition(ctx context.Context, name string, n int) (*storage.Partition, TopicConfig, error) {
+	b.mu.RLock()
+	p, err := b.partition(name, n)
+	if err == nil {
+		err = b.checkOnline(name, n)
+	}
+	config := b.metadata.Topics[name].config()
+	b.mu.RUnlock()
+	if !errors.Is(err, ErrUnknownTopic) || !b.config.AutoCreateTopics {
+		return p, config, err
+	}
+	if err := b.authorize(ctx, name, OperationAdmin); err != nil {
+		return nil, TopicConfig{}, err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	// Someone else may have created it in the meantime
+	p, err = b.partition(name, n)
+	if errors.Is(err, ErrUnknownTopic) {
+		b.logger.Info("auto-creating topic", "topic", name)
+		if _, err = b.createTopic(name, b.config.DefaultTopic); err != nil {
+			return nil, TopicConfig{}, err
+		}
+		p, err = b.partition(name, n)
+	}
+	return p, b.metadata.Topics[name].config(), err
+}
+
+// MovePartition moves partition n of the topic called name to the data path
+// dataDir, see storage.Partition.Move. The partition keeps serving reads and
+// appends while it moves. The new location is recorded in the metadata
+// before the old directory is removed, so a crash in between leaves a stale
+// copy behind rather than a lost partition.
+func (b *Broker) MovePartition(ctx context.Context, name string, n int, dataDir string) error {
+	if err := b.authorize(ctx, name, OperationAdmin); err != nil {
+		return err
+	}
+
+	b.moveMu.Lock()
+	defer b.moveMu.Unlock()
+
+	b.mu.RLock()
+	p, err := b.partition(name, n)
+	topic := b.metadata.Topics[name]
+	b.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+
+	oldDir := filepath.Join(namespacePaths(b.partitionPaths(topic, n), name).Data, partitionName(name, n))
+	if err := (storage.Paths{Data: dataDir}).Validate(); err != nil {
+		return err
+	}
+	if err := p.Move(filepath.Join(namespacePaths(storage.Paths{Data: dataDir}, name).Data, partitionName(name, n))); err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	topic = b.metadata.Topics[name]
+	topic.DataDirs = maps.Clone(topic.DataDirs)
+	if topic.DataDirs == nil {
+		topic.DataDirs = make(map[int]string)
+	}
+	topic.DataDirs[n] = dataDir
+	if filepath.Clean(dataDir) == filepath.Clean(b.paths.Data) {
+		delete(topic.DataDirs, n)
+	}
+	previous := b.metadata.Topics[name]
+	b.metadata.Topics[name] = topic
+	err = storeMetadata(b.metaDir(), b.metadata)
+	if err != nil {
+		b.metadata.Topics[name] = previous
+	}
+	b.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("partition %d of topic %s was moved to %s but the move couldn't be recorded: %w", n, name, dataDir, err)
+	}
+
+	b.logger.Info("moved partition", "topic", name, "partition", n, "to", dataDir)
+	return os.RemoveAll(oldDir)
+}
+
+// authorize returns an error matching ErrUnauthorized unless the principal
+// of ctx may do op on topic.
+func (b *Broker) authorize(ctx context.Context, topic string, op Operation) error {
+	if !b.config.Authorize {
+		return nil
+	}
+	principal := Principal(ctx)
+	if slices.Contains(b.config.SuperUsers, principal) || b.acls.Allowed(principal, topic, op) {
+		return nil
+	}
+	return fmt.Errorf("%w: %q may not %s topic %s", ErrUnauthorized, principal, op, topic)
+}
+
+// ACLs returns the ACLs of the broker.
+func (b *Broker) ACLs() []ACL {
+	return b.acls.List()
+}
+
+// AddACL durably adds acl. It takes the admin operation on the topic "*", or
+// on "ns/*" for an ACL on topics of the namespace ns only.
+func (b *Broker) AddACL(ctx context.Context, acl ACL) error {
+	if err := b.authorize(ctx, aclScope(acl), OperationAdmin); err != nil {
+		return err
+	}
+	return b.acls.add(acl)
+}
+
+// RemoveACL durably removes acl. It takes the admin operation on the topic
+// "*", or on "ns/*" for an ACL on topics of the namespace ns only.
+func (b *Broker) RemoveACL(ctx context.Context, acl ACL) error {
+	if err := b.authorize(ctx, aclScope(acl), OperationAdmin); err != nil {
+		return err
+	}
+	return b.acls.remove(acl)
+}
+
+// Close stops the retention worker and closes every partition.
+func (b *Broker) Close() error {
+	// The worker takes mu, so it is stopped before
+	b.stopRetentionOnce.Do(func() {
+		if b.stopRetention != nil {
+			close(b.stopRetention)
+			<-b.retentionDone
+		}
+	})
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return nil
+	}
+	b.closed = true
+
+	var errs []error
+	for _, partitions := range b.topics {
+		for _, p := range partitions {
+			errs = append(errs, p.Close())
+		}
+	}
+	return errors.Join(errs...)
+}
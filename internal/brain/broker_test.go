@@ -0,0 +1,328 @@
+package brain
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/mvaleed/brook/internal/storage"
+)
+
+func openTestBroker(t *testing.T, paths storage.Paths) *Broker {
+	t.Helper()
+	b, err := OpenBroker(paths, DefaultBrokerConfig())
+	require.NoError(t, err)
+	return b
+}
+
+func TestBroker(t *testing.T) {
+	t.Run("topics survive a restart", func(t *testing.T) {
+		paths := storage.Paths{Data: t.TempDir(), Meta: t.TempDir()}
+		b := openTestBroker(t, paths)
+
+		require.NoError(t, b.CreateTopic(context.Background(), "orders", TopicConfig{Partitions: 3, Retention: 24 * time.Hour, Compression: CompressionGzip}))
+		require.NoError(t, b.CreateTopic(context.Background(), "events", TopicConfig{Partitions: 1}))
+		require.ErrorIs(t, b.CreateTopic(context.Background(), "orders", DefaultTopicConfig()), ErrTopicExists)
+
+		for i := range 3 {
+			p, err := b.Partition("orders", i)
+			require.NoError(t, err)
+			require.NoError(t, p.Append(fmt.Appendf(nil, "order for partition %d", i)))
+		}
+		require.NoError(t, b.Close())
+
+		require.FileExists(t, filepath.Join(paths.Meta, metadataFileName))
+		require.DirExists(t, filepath.Join(paths.Data, "orders-2"))
+
+		b = openTestBroker(t, paths)
+		defer b.Close()
+		require.Equal(t, []string{"events", "orders"}, b.Topics())
+
+		config, err := b.TopicConfig("orders")
+		require.NoError(t, err)
+		require.Equal(t, TopicConfig{
+			Partitions:  3,
+			Retention:   24 * time.Hour,
+			Compression: CompressionGzip,
+			Durability:  storage.DurabilityMedium,
+		}, config)
+		config, err = b.TopicConfig("events")
+		require.NoError(t, err)
+		require.Equal(t, CompressionNone, config.Compression)
+
+		p, err := b.Partition("orders", 1)
+		require.NoError(t, err)
+		record, err := p.Read(0)
+		require.NoError(t, err)
+		require.Equal(t, "order for partition 1", string(record.Payload))
+	})
+	t.Run("topics override the partition config", func(t *testing.T) {
+		paths := storage.Paths{Data: t.TempDir()}
+		b := openTestBroker(t, paths)
+		config := TopicConfig{Partitions: 1, MaxSegmentBytes: 1024, Durability: storage.DurabilityFull, Compact: true}
+		require.NoError(t, b.CreateTopic(context.Background(), "state", config))
+		require.NoError(t, b.CreateTopic(context.Background(), "metrics", TopicConfig{Partitions: 1, Durability: storage.DurabilityAsync}))
+		require.Error(t, b.CreateTopic(context.Background(), "billing", TopicConfig{Partitions: 1, Durability: storage.DurabilityMode(7)}))
+
+		for i := range 50 {
+			require.NoError(t, b.Produce(context.Background(), "state", 0, fmt.Appendf(nil, "state %d", i)))
+		}
+		p, err := b.Partition("state", 0)
+		require.NoError(t, err)
+		// Every produce was fsynced
+		require.Equal(t, 50, p.DurableOffset())
+		require.Equal(t, storage.DurabilityFull, p.DurabilityInfo().Mode)
+
+		require.NoError(t, b.Produce(context.Background(), "metrics", 0, []byte("cpu 0.5")))
+		p, err = b.Partition("metrics", 0)
+		require.NoError(t, err)
+		require.Equal(t, storage.DurabilityAsync, p.DurabilityInfo().Mode)
+		msgs, err := b.Fetch(context.Background(), "metrics", 0, 0, 1024)
+		require.NoError(t, err)
+		require.Len(t, msgs, 1)
+		require.NoError(t, b.Close())
+
+		segments, err := filepath.Glob(filepath.Join(paths.Data, "state-0", "*.log"))
+		require.NoError(t, err)
+		require.Greater(t, len(segments), 1)
+
+		// And the overrides are back after a restart
+		b = openTestBroker(t, paths)
+		defer b.Close()
+		restored, err := b.TopicConfig("state")
+		require.NoError(t, err)
+		require.Equal(t, int64(1024), restored.MaxSegmentBytes)
+		require.Equal(t, storage.DurabilityFull, restored.Durability)
+		require.True(t, restored.Compact)
+		p, err = b.Partition("metrics", 0)
+		require.NoError(t, err)
+		require.Equal(t, storage.DurabilityAsync, p.DurabilityInfo().Mode)
+		require.Equal(t, 1, p.NextOffset())
+	})
+	t.Run("produce auto-creates topics", func(t *testing.T) {
+		config := DefaultBrokerConfig()
+		config.AutoCreateTopics = true
+		config.DefaultTopic.Partitions = 2
+		b, err := OpenBroker(storage.Paths{Data: t.TempDir()}, config)
+		require.NoError(t, err)
+		defer b.Close()
+
+		require.NoError(t, b.Produce(context.Background(), "clicks", 1, []byte("click")))
+		require.Equal(t, []string{"clicks"}, b.Topics())
+		created, err := b.TopicConfig("clicks")
+		require.NoError(t, err)
+		require.Equal(t, 2, created.Partitions)
+
+		p, err := b.Partition("clicks", 1)
+		require.NoError(t, err)
+		record, err := p.Read(0)
+		require.NoError(t, err)
+		require.Equal(t, "click", string(record.Payload))
+
+		// The topic is created even if the partition doesn't exist
+		require.Error(t, b.Produce(context.Background(), "views", 5, []byte("view")))
+		require.Equal(t, []string{"clicks", "views"}, b.Topics())
+	})
+	t.Run("produce to an unknown topic", func(t *testing.T) {
+		b := openTestBroker(t, storage.Paths{Data: t.TempDir()})
+		defer b.Close()
+		require.ErrorIs(t, b.Produce(context.Background(), "clicks", 0, []byte("click")), ErrUnknownTopic)
+		require.Empty(t, b.Topics())
+	})
+	t.Run("partitions move to other data paths", func(t *testing.T) {
+		paths := storage.Paths{Data: t.TempDir()}
+		other := t.TempDir()
+		b := openTestBroker(t, paths)
+		require.NoError(t, b.CreateTopic(context.Background(), "orders", TopicConfig{Partitions: 2}))
+		require.NoError(t, b.Produce(context.Background(), "orders", 1, []byte("before")))
+
+		require.NoError(t, b.MovePartition(context.Background(), "orders", 1, other))
+		require.NoDirExists(t, filepath.Join(paths.Data, "orders-1"))
+		require.DirExists(t, filepath.Join(other, "orders-1"))
+		require.NoError(t, b.Produce(context.Background(), "orders", 1, []byte("after")))
+		require.NoError(t, b.Close())
+
+		b = openTestBroker(t, paths)
+		p, err := b.Partition("orders", 1)
+		require.NoError(t, err)
+		require.Equal(t, 2, p.NextOffset())
+		record, err := p.Read(1)
+		require.NoError(t, err)
+		require.Equal(t, "after", string(record.Payload))
+
+		// And back
+		require.NoError(t, b.MovePartition(context.Background(), "orders", 1, paths.Data))
+		require.NoDirExists(t, filepath.Join(other, "orders-1"))
+		require.Empty(t, b.metadata.Topics["orders"].DataDirs)
+		require.NoError(t, b.Close())
+
+		b = openTestBroker(t, paths)
+		defer b.Close()
+		p, err = b.Partition("orders", 1)
+		require.NoError(t, err)
+		require.Equal(t, 2, p.NextOffset())
+	})
+	t.Run("unknown topics and partitions", func(t *testing.T) {
+		b := openTestBroker(t, storage.Paths{Data: t.TempDir()})
+		defer b.Close()
+		require.NoError(t, b.CreateTopic(context.Background(), "orders", DefaultTopicConfig()))
+
+		_, err := b.Partition("payments", 0)
+		require.ErrorIs(t, err, ErrUnknownTopic)
+		_, err = b.TopicConfig("payments")
+		require.ErrorIs(t, err, ErrUnknownTopic)
+		_, err = b.Partition("orders", 1)
+		require.Error(t, err)
+	})
+	t.Run("invalid topics are rejected", func(t *testing.T) {
+		b := openTestBroker(t, storage.Paths{Data: t.TempDir()})
+		defer b.Close()
+
+		require.Error(t, b.CreateTopic(context.Background(), "", DefaultTopicConfig()))
+		require.Error(t, b.CreateTopic(context.Background(), "../orders", DefaultTopicConfig()))
+		require.Error(t, b.CreateTopic(context.Background(), ".orders", DefaultTopicConfig()))
+		require.Error(t, b.CreateTopic(context.Background(), "orders", TopicConfig{}))
+		require.Error(t, b.CreateTopic(context.Background(), "orders", TopicConfig{Partitions: 1, Compression: "brotli"}))
+		require.Empty(t, b.Topics())
+	})
+	t.Run("partitions open concurrently", func(t *testing.T) {
+		paths := storage.Paths{Data: t.TempDir()}
+		b := openTestBroker(t, paths)
+		for _, name := range []string{"orders", "events", "payments"} {
+			require.NoError(t, b.CreateTopic(context.Background(), name, TopicConfig{Partitions: 4}))
+			for i := range 4 {
+				p, err := b.Partition(name, i)
+				require.NoError(t, err)
+				require.NoError(t, p.Append(fmt.Appendf(nil, "%s %d", name, i)))
+			}
+		}
+		require.NoError(t, b.Close())
+
+		config := DefaultBrokerConfig()
+		config.OpenConcurrency = 3
+		b, err := OpenBroker(paths, config)
+		require.NoError(t, err)
+		defer b.Close()
+		for _, name := range []string{"orders", "events", "payments"} {
+			for i := range 4 {
+				p, err := b.Partition(name, i)
+				require.NoError(t, err)
+				require.True(t, p.RecoveryReport().CleanShutdown)
+				record, err := p.Read(0)
+				require.NoError(t, err)
+				require.Equal(t, fmt.Sprintf("%s %d", name, i), string(record.Payload))
+			}
+		}
+	})
+	t.Run("a partition failing to open fails the broker open", func(t *testing.T) {
+		paths := storage.Paths{Data: t.TempDir()}
+		b := openTestBroker(t, paths)
+		require.NoError(t, b.CreateTopic(context.Background(), "orders", TopicConfig{Partitions: 3}))
+		require.NoError(t, b.Close())
+
+		broken := filepath.Join(paths.Data, "orders-2")
+		require.NoError(t, os.RemoveAll(broken))
+		require.NoError(t, os.WriteFile(broken, nil, 0o644))
+		_, err := OpenBroker(paths, DefaultBrokerConfig())
+		require.ErrorContains(t, err, "failed to open partition 2 of topic orders")
+
+		// The partitions that did open were closed cleanly again
+		require.NoError(t, os.Remove(broken))
+		b = openTestBroker(t, paths)
+		defer b.Close()
+		p, err := b.Partition("orders", 0)
+		require.NoError(t, err)
+		require.True(t, p.RecoveryReport().CleanShutdown)
+	})
+	t.Run("fetches are bounded in bytes", func(t *testing.T) {
+		ctx := context.Background()
+		config := DefaultBrokerConfig()
+		config.MaxFetchBytes = 500 << 10
+		b, err := OpenBroker(storage.Paths{Data: t.TempDir()}, config)
+		require.NoError(t, err)
+		defer b.Close()
+		require.NoError(t, b.CreateTopic(ctx, "images", DefaultTopicConfig()))
+
+		payload := make([]byte, 200<<10)
+		for range 10 {
+			require.NoError(t, b.Produce(ctx, "images", 0, payload))
+		}
+
+		// The config caps what the fetch asks for
+		msgs, err := b.Fetch(ctx, "images", 0, 0, 100<<20)
+		require.NoError(t, err)
+		require.Len(t, msgs, 2)
+		msgs, err = b.Fetch(ctx, "images", 0, 2, 300<<10)
+		require.NoError(t, err)
+		require.Len(t, msgs, 1)
+		require.Equal(t, 2, msgs[0].Offset)
+
+		// The first message comes back however large it is
+		msgs, err = b.Fetch(ctx, "images", 0, 9, 1)
+		require.NoError(t, err)
+		require.Len(t, msgs, 1)
+	})
+	t.Run("corrupt metadata fails the open", func(t *testing.T) {
+		dir := t.TempDir()
+		require.NoError(t, os.WriteFile(filepath.Join(dir, metadataFileName), []byte("{"), 0o644))
+		_, err := OpenBroker(storage.Paths{Data: dir}, DefaultBrokerConfig())
+		require.Error(t, err)
+
+		require.NoError(t, os.WriteFile(filepath.Join(dir, metadataFileName), []byte(`{"version": 2}`), 0o644))
+		_, err = OpenBroker(storage.Paths{Data: dir}, DefaultBrokerConfig())
+		require.ErrorContains(t, err, "version 2")
+	})
+	t.Run("closed broker", func(t *testing.T) {
+		b := openTestBroker(t, storage.Paths{Data: t.TempDir()})
+		require.NoError(t, b.CreateTopic(context.Background(), "orders", DefaultTopicConfig()))
+		require.NoError(t, b.Close())
+		require.NoError(t, b.Close())
+
+		_, err := b.Partition("orders", 0)
+		require.ErrorIs(t, err, ErrClosed)
+		require.ErrorIs(t, b.CreateTopic(context.Background(), "events", DefaultTopicConfig()), ErrClosed)
+	})
+}
+
+func TestBroker_FetchOffsets(t *testing.T) {
+	ctx := context.Background()
+	b := openTestBroker(t, storage.Paths{Data: t.TempDir()})
+	defer b.Close()
+	require.NoError(t, b.CreateTopic(ctx, "orders", TopicConfig{Partitions: 1}))
+	for i := range 5 {
+		require.NoError(t, b.Produce(ctx, "orders", 0, fmt.Appendf(nil, "order %d", i)))
+	}
+
+	msgs, err := b.FetchOffsets(ctx, "orders", 0, []int{4, 1, 9, 1})
+	require.NoError(t, err)
+	require.Len(t, msgs, 2)
+	require.Equal(t, 1, msgs[0].Offset)
+	require.Equal(t, []byte("order 1"), msgs[0].Data)
+	require.Equal(t, 4, msgs[1].Offset)
+
+	_, err = b.FetchOffsets(ctx, "orders", 1, []int{0})
+	require.ErrorIs(t, err, ErrUnknownPartition)
+
+	t.Run("bounded in bytes", func(t *testing.T) {
+		config := DefaultBrokerConfig()
+		config.MaxFetchBytes = 500 << 10
+		b, err := OpenBroker(storage.Paths{Data: t.TempDir()}, config)
+		require.NoError(t, err)
+		defer b.Close()
+		require.NoError(t, b.CreateTopic(ctx, "images", DefaultTopicConfig()))
+		for range 5 {
+			require.NoError(t, b.Produce(ctx, "images", 0, make([]byte, 200<<10)))
+		}
+
+		msgs, err := b.FetchOffsets(ctx, "images", 0, []int{4, 0, 2})
+		require.NoError(t, err)
+		require.Len(t, msgs, 2)
+		require.Equal(t, 0, msgs[0].Offset)
+		require.Equal(t, 2, msgs[1].Offset)
+	})
+}
@@ -0,0 +1,102 @@
+package brain
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"time"
+)
+
+var ErrInvalidCompression = errors.New("payload isn't compressed with its codec")
+
+// Compress returns data compressed with c.
+func (c Compression) Compress(data []byte) ([]byte, error) {
+	switch c {
+	case "", CompressionNone:
+		return data, nil
+	case CompressionGzip:
+		var buf bytes.Buffer
+		w := gzip.NewWriter(&buf)
+		if _, err := w.Write(data); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	default:
+		return nil, fmt.Errorf("unknown compression %q", c)
+	}
+}
+
+// Decompress returns data, compressed with c, as it was before. Data that
+// isn't compressed with c is an error matching ErrInvalidCompression.
+func (c Compression) Decompress(data []byte) ([]byte, error) {
+	return c.decompress(data, math.MaxInt64)
+}
+
+// decompress is Decompress, failing with a MessageTooLargeError once the
+// data decompresses past limit bytes, so a small message can't blow up into
+// a huge one in memory.
+func (c Compression) decompress(data []byte, limit int64) ([]byte, error) {
+	switch c {
+	case "", CompressionNone:
+		return data, nil
+	case CompressionGzip:
+		r, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidCompression, err)
+		}
+		var src io.Reader = r
+		if limit < math.MaxInt64 {
+			src = io.LimitReader(r, limit+1)
+		}
+		decompressed, err := io.ReadAll(src)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidCompression, err)
+		}
+		if int64(len(decompressed)) > limit {
+			return nil, &MessageTooLargeError{Size: int64(len(decompressed)), Limit: limit}
+		}
+		return decompressed, nil
+	default:
+		return nil, fmt.Errorf("unknown compression %q", c)
+	}
+}
+
+// recompress returns data, compressed with from, compressed with to instead,
+// as long as it's no larger than limit bytes decompressed. Data already
+// compressed with to passes through untouched.
+func recompress(data []byte, from Compression, to Compression, limit int64) ([]byte, error) {
+	if from == "" {
+		from = CompressionNone
+	}
+	if from == to {
+		return data, nil
+	}
+	decompressed, err := from.decompress(data, limit)
+	if err != nil {
+		return nil, err
+	}
+	return to.Compress(decompressed)
+}
+
+// ProduceCompressed is ProduceAt for data its producer compressed with
+// codec, which may not be the codec of the topic. The broker recompresses
+// it to the topic's codec before it's appended, so a topic's messages are
+// all stored and fetched in the same codec whatever their producers use.
+// Data in the topic's codec is appended as it is.
+func (b *Broker) ProduceCompressed(ctx context.Context, name string, n int, data []byte, timestamp time.Time, codec Compression) error {
+	switch codec {
+	case "":
+		codec = CompressionNone
+	case CompressionNone, CompressionGzip:
+	default:
+		return fmt.Errorf("%w: unknown compression %q", ErrInvalidCompression, codec)
+	}
+	return b.produce(ctx, name, n, data, timestamp, codec)
+}
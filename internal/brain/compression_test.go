@@ -0,0 +1,46 @@
+package brain
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/mvaleed/brook/internal/storage"
+)
+
+func TestBroker_ProduceCompressed(t *testing.T) {
+	ctx := context.Background()
+	b := openTestBroker(t, storage.Paths{Data: t.TempDir()})
+	defer b.Close()
+	require.NoError(t, b.CreateTopic(ctx, "logs", TopicConfig{Partitions: 1, Compression: CompressionGzip}))
+	require.NoError(t, b.CreateTopic(ctx, "events", TopicConfig{Partitions: 1}))
+
+	line := []byte("GET /index.html 200")
+	gzipped, err := CompressionGzip.Compress(line)
+	require.NoError(t, err)
+
+	// Recompressed to the topic's codec, or passed through if it's the same
+	require.NoError(t, b.ProduceCompressed(ctx, "logs", 0, line, time.Time{}, CompressionNone))
+	require.NoError(t, b.ProduceCompressed(ctx, "logs", 0, gzipped, time.Time{}, CompressionGzip))
+	require.NoError(t, b.ProduceCompressed(ctx, "events", 0, gzipped, time.Time{}, CompressionGzip))
+
+	msgs, err := b.Fetch(ctx, "logs", 0, 0, 1<<20)
+	require.NoError(t, err)
+	require.Len(t, msgs, 2)
+	require.Equal(t, gzipped, msgs[1].Data)
+	for _, msg := range msgs {
+		data, err := CompressionGzip.Decompress(msg.Data)
+		require.NoError(t, err)
+		require.Equal(t, line, data)
+	}
+	msgs, err = b.Fetch(ctx, "events", 0, 0, 1<<20)
+	require.NoError(t, err)
+	require.Equal(t, line, msgs[0].Data)
+
+	err = b.ProduceCompressed(ctx, "events", 0, line, time.Time{}, CompressionGzip)
+	require.ErrorIs(t, err, ErrInvalidCompression)
+	err = b.ProduceCompressed(ctx, "events", 0, line, time.Time{}, "zstd")
+	require.ErrorIs(t, err, ErrInvalidCompression)
+}
@@ -0,0 +1,104 @@
+package brain
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/mvaleed/brook/internal/storage"
+)
+
+const cursorsDirName = "cursors"
+
+type cursor struct {
+	Offset int `json:"offset"`
+}
+
+// cursorStore keeps the committed position of every subscription of a topic,
+// one file per subscription.
+type cursorStore struct {
+	dir string
+}
+
+func (cs *cursorStore) path(name string) (string, error) {
+	if name == "" || name != filepath.Base(name) || name[0] == '.' {
+		return "", fmt.Errorf("invalid subscription name %q", name)
+	}
+	return filepath.Join(cs.dir, name+".json"), nil
+}
+
+// load returns the committed position of the subscription, 0 if it never
+// committed.
+func (cs *cursorStore) load(name string) (int, error) {
+	offset, _, err := cs.lookup(name)
+	return offset, err
+}
+
+// lookup returns the committed position of the subscription, false if it
+// never committed.
+func (cs *cursorStore) lookup(name string) (int, bool, error) {
+	path, err := cs.path(name)
+	if err != nil {
+		return 0, false, err
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to read cursor of %s: %w", name, err)
+	}
+
+	var c cursor
+	if err := json.Unmarshal(data, &c); err != nil {
+		return 0, false, fmt.Errorf("invalid cursor of %s: %w", name, err)
+	}
+	return c.Offset, true, nil
+}
+
+// store atomically replaces the committed position of the subscription.
+func (cs *cursorStore) store(name string, offset int) error {
+	path, err := cs.path(name)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(cursor{Offset: offset})
+	if err != nil {
+		return err
+	}
+
+	// The directory is only created with the first cursor
+	if err := os.MkdirAll(cs.dir, 0o755); err != nil {
+		return fmt.Errorf("failed to commit cursor of %s: %w", name, err)
+	}
+	if err := storage.WriteFileAtomic(cs.dir, filepath.Base(path), data); err != nil {
+		return fmt.Errorf("failed to commit cursor of %s: %w", name, err)
+	}
+	return nil
+}
+
+// names returns the subscriptions that committed a position, sorted.
+func (cs *cursorStore) names() ([]string, error) {
+	entries, err := os.ReadDir(cs.dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list cursors: %w", err)
+	}
+
+	var names []string
+	for _, entry := range entries {
+		name, ok := strings.CutSuffix(entry.Name(), ".json")
+		// Skips the temporary files of interrupted stores
+		if !ok || entry.IsDir() || name == "" || name[0] == '.' {
+			continue
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
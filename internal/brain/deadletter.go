@@ -0,0 +1,104 @@
+package brain
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// SubscriptionConfig configures a subscription, see Topic.SubscribeWithConfig.
+type SubscriptionConfig struct {
+	// DeadLetter, when its Topic is set, takes the messages that keep failing
+	// out of the way.
+	DeadLetter DeadLetterConfig
+	// MaxFetchBytes caps the payload bytes a Fetch returns, DefaultMaxFetchBytes
+	// if zero. Its first message is returned whatever its size.
+	MaxFetchBytes int
+}
+
+// DeadLetterConfig routes a message to a dead-letter topic once it failed
+// MaxDeliveries times, see Subscription.Fail.
+type DeadLetterConfig struct {
+	Topic         *Topic
+	MaxDeliveries int
+}
+
+func (c DeadLetterConfig) validate() error {
+	if c.Topic != nil && c.MaxDeliveries <= 0 {
+		return errors.New("max deliveries must be positive")
+	}
+	return nil
+}
+
+// DeadLetter is what a dead-letter topic holds, as JSON: a message that
+// failed too often, and where and why it failed.
+type DeadLetter struct {
+	Topic        string    `json:"topic"`
+	Subscription string    `json:"subscription"`
+	Offset       int       `json:"offset"`
+	Timestamp    time.Time `json:"timestamp"` // when the message was published
+	FailedAt     time.Time `json:"failed_at"`
+	Deliveries   int       `json:"deliveries"`
+	Error        string    `json:"error"`
+	Data         []byte    `json:"data"`
+}
+
+// ParseDeadLetter decodes a message of a dead-letter topic.
+func ParseDeadLetter(data []byte) (DeadLetter, error) {
+	var dl DeadLetter
+	if err := json.Unmarshal(data, &dl); err != nil {
+		return DeadLetter{}, fmt.Errorf("invalid dead letter: %w", err)
+	}
+	return dl, nil
+}
+
+// Fail reports that processing msg failed because of cause. The subscription
+// goes back to msg, so that Next returns it again, and later messages after
+// it. Once msg failed MaxDeliveries times it is published to the dead-letter
+// topic instead, and the subscription carries on after it. Without a
+// dead-letter topic msg is redelivered for as long as it fails.
+//
+// Deliveries are counted by the subscription in memory, they start over when
+// it's reopened. Fail moves the subscription like Seek, the move is only kept
+// if it's committed.
+func (s *Subscription) Fail(msg Message, cause error) error {
+	s.failures[msg.Offset]++
+	deliveries := s.failures[msg.Offset]
+
+	dl := s.config.DeadLetter
+	if dl.Topic == nil || deliveries < dl.MaxDeliveries {
+		s.position = msg.Offset
+		return nil
+	}
+
+	data, err := json.Marshal(DeadLetter{
+		Topic:        s.topic.name,
+		Subscription: s.name,
+		Offset:       msg.Offset,
+		Timestamp:    msg.Timestamp,
+		FailedAt:     time.Now(),
+		Deliveries:   deliveries,
+		Error:        errorString(cause),
+		Data:         msg.Data,
+	})
+	if err != nil {
+		return err
+	}
+	if err := dl.Topic.Publish(data); err != nil {
+		// Delivered again, and dead-lettered by the next failure
+		s.position = msg.Offset
+		return fmt.Errorf("failed to publish dead letter of offset %d: %w", msg.Offset, err)
+	}
+
+	delete(s.failures, msg.Offset)
+	s.position = msg.Offset + 1
+	return nil
+}
+
+func errorString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
@@ -0,0 +1,91 @@
+package brain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSubscription_Fail(t *testing.T) {
+	t.Run("messages failing too often are dead-lettered", func(t *testing.T) {
+		ps := openTestPubSub(t, t.TempDir())
+		defer ps.Close()
+		orders, err := ps.Topic("orders")
+		require.NoError(t, err)
+		dlq, err := ps.Topic("orders.dlq")
+		require.NoError(t, err)
+		for i := range 3 {
+			require.NoError(t, orders.Publish(fmt.Appendf(nil, "order %d", i)))
+		}
+
+		sub, err := orders.SubscribeWithConfig("billing", SubscriptionConfig{
+			DeadLetter: DeadLetterConfig{Topic: dlq, MaxDeliveries: 3},
+		})
+		require.NoError(t, err)
+		defer sub.Close()
+
+		ctx := context.Background()
+		msg, err := sub.Next(ctx)
+		require.NoError(t, err)
+		require.NoError(t, sub.Commit())
+
+		// Offset 1 is poison, it comes back until it's dead-lettered
+		for range 3 {
+			msg, err = sub.Next(ctx)
+			require.NoError(t, err)
+			require.Equal(t, 1, msg.Offset)
+			require.NoError(t, sub.Fail(msg, errors.New("invalid order")))
+		}
+		msg, err = sub.Next(ctx)
+		require.NoError(t, err)
+		require.Equal(t, 2, msg.Offset)
+		require.NoError(t, sub.Commit())
+
+		require.Equal(t, 1, dlq.EndOffset())
+		letters, err := dlq.Subscribe("ops")
+		require.NoError(t, err)
+		defer letters.Close()
+		msg, err = letters.Next(ctx)
+		require.NoError(t, err)
+
+		dl, err := ParseDeadLetter(msg.Data)
+		require.NoError(t, err)
+		require.Equal(t, "orders", dl.Topic)
+		require.Equal(t, "billing", dl.Subscription)
+		require.Equal(t, 1, dl.Offset)
+		require.Equal(t, 3, dl.Deliveries)
+		require.Equal(t, "invalid order", dl.Error)
+		require.Equal(t, "order 1", string(dl.Data))
+		require.False(t, dl.Timestamp.IsZero())
+	})
+	t.Run("without a dead-letter topic messages are redelivered", func(t *testing.T) {
+		ps := openTestPubSub(t, t.TempDir())
+		defer ps.Close()
+		orders, err := ps.Topic("orders")
+		require.NoError(t, err)
+		require.NoError(t, orders.Publish([]byte("order")))
+
+		sub, err := orders.Subscribe("billing")
+		require.NoError(t, err)
+		defer sub.Close()
+		for range 10 {
+			msg, err := sub.Next(context.Background())
+			require.NoError(t, err)
+			require.Equal(t, 0, msg.Offset)
+			require.NoError(t, sub.Fail(msg, nil))
+		}
+	})
+	t.Run("max deliveries must be positive", func(t *testing.T) {
+		ps := openTestPubSub(t, t.TempDir())
+		defer ps.Close()
+		orders, err := ps.Topic("orders")
+		require.NoError(t, err)
+		_, err = orders.SubscribeWithConfig("billing", SubscriptionConfig{
+			DeadLetter: DeadLetterConfig{Topic: orders},
+		})
+		require.Error(t, err)
+	})
+}
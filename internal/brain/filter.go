@@ -0,0 +1,107 @@
+package brain
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"time"
+
+	"github.com/mvaleed/brook/internal/storage"
+)
+
+// maxFilteredOut caps the messages a FetchFiltered call skips, so that a
+// filter matching nothing in a long partition doesn't hold the fetch up.
+const maxFilteredOut = 10000
+
+// DefaultMaxFetchBytes is the most payload bytes a fetch returns, unless
+// configured otherwise.
+const DefaultMaxFetchBytes = 50 << 20
+
+// FetchFilter selects the messages FetchFiltered returns. It is evaluated on
+// the broker while reading the partition, so the messages it drops don't
+// count against the fetch quota or maxBytes. A message that doesn't fit in
+// what is left of maxBytes ends the fetch before it is read, and so before
+// the filter gets to drop it. Messages have no keys or headers, only the
+// timestamp and payload can be matched. The zero FetchFilter keeps every
+// message.
+type FetchFilter struct {
+	// From and Until bound the timestamps of the messages kept, From
+	// included and Until excluded. Zero leaves them unbounded.
+	From  time.Time
+	Until time.Time
+	// Prefix keeps the messages whose payload starts with it, like ones
+	// framed with a schema ID or led by a key.
+	Prefix []byte
+}
+
+func (f FetchFilter) keep(timestamp time.Time, payload []byte) bool {
+	if !f.From.IsZero() && timestamp.Before(f.From) {
+		return false
+	}
+	if !f.Until.IsZero() && !timestamp.Before(f.Until) {
+		return false
+	}
+	return bytes.HasPrefix(payload, f.Prefix)
+}
+
+// FetchFiltered is Fetch returning only the messages filter keeps. It also
+// returns the offset to fetch from next, past the messages it skipped, which
+// moves on even when none is kept.
+func (b *Broker) FetchFiltered(ctx context.Context, name string, n int, offset int, maxBytes int, filter FetchFilter) ([]Message, int, error) {
+	if err := b.authorize(ctx, name, OperationConsume); err != nil {
+		return nil, offset, err
+	}
+	client := ClientID(ctx)
+	if err := b.quotas.admit(client, name, quotaFetch); err != nil {
+		return nil, offset, err
+	}
+	p, err := b.Partition(name, n)
+	if err != nil {
+		return nil, offset, err
+	}
+
+	if b.config.MaxFetchBytes > 0 {
+		maxBytes = min(maxBytes, b.config.MaxFetchBytes)
+	} else {
+		maxBytes = min(maxBytes, DefaultMaxFetchBytes)
+	}
+
+	var msgs []Message
+	size := 0
+	filteredOut := 0
+	for ; offset < p.NextOffset() && filteredOut < maxFilteredOut; offset++ {
+		var record storage.Record
+		if len(msgs) == 0 {
+			record, err = p.ReadContext(ctx, offset)
+		} else {
+			// What doesn't fit is left on disk, kept by the filter or not
+			record, err = p.ReadWithin(ctx, offset, int64(maxBytes-size))
+		}
+		if errors.Is(err, storage.ErrRecordOverLimit) {
+			break
+		}
+		if errors.Is(err, storage.ErrRecordExpired) {
+			continue
+		}
+		if err != nil {
+			if len(msgs) > 0 {
+				// What was read is returned, the error comes up again next time
+				break
+			}
+			return nil, offset, err
+		}
+		timestamp := time.Unix(0, int64(record.Header.Timestamp))
+		if !filter.keep(timestamp, record.Payload) {
+			filteredOut++
+			continue
+		}
+		msgs = append(msgs, Message{
+			Offset:    offset,
+			Timestamp: timestamp,
+			Data:      record.Payload,
+		})
+		size += len(record.Payload)
+	}
+	b.quotas.charge(client, name, quotaFetch, size)
+	return msgs, offset, nil
+}
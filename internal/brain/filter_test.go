@@ -0,0 +1,68 @@
+package brain
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/mvaleed/brook/internal/storage"
+)
+
+func TestBroker_FetchFiltered(t *testing.T) {
+	ctx := context.Background()
+	b := openTestBroker(t, storage.Paths{Data: t.TempDir()})
+	defer b.Close()
+	require.NoError(t, b.CreateTopic(ctx, "events", DefaultTopicConfig()))
+
+	for i := range 6 {
+		kind := "click"
+		if i%2 == 1 {
+			kind = "view"
+		}
+		require.NoError(t, b.Produce(ctx, "events", 0, fmt.Appendf(nil, "%s %d", kind, i)))
+	}
+	msgs, err := b.Fetch(ctx, "events", 0, 0, 1<<20)
+	require.NoError(t, err)
+
+	data := func(msgs []Message) []string {
+		var values []string
+		for _, msg := range msgs {
+			values = append(values, string(msg.Data))
+		}
+		return values
+	}
+
+	filtered, next, err := b.FetchFiltered(ctx, "events", 0, 0, 1<<20, FetchFilter{Prefix: []byte("view")})
+	require.NoError(t, err)
+	require.Equal(t, []string{"view 1", "view 3", "view 5"}, data(filtered))
+	require.Equal(t, 6, next)
+
+	// maxBytes only counts what is kept
+	filtered, next, err = b.FetchFiltered(ctx, "events", 0, 0, 13, FetchFilter{Prefix: []byte("click")})
+	require.NoError(t, err)
+	require.Equal(t, []string{"click 0"}, data(filtered))
+	require.Equal(t, 2, next, "view 1 was skipped")
+
+	// A message that doesn't fit isn't read, even to be filtered out
+	filtered, next, err = b.FetchFiltered(ctx, "events", 0, 0, 7, FetchFilter{Prefix: []byte("click")})
+	require.NoError(t, err)
+	require.Equal(t, []string{"click 0"}, data(filtered))
+	require.Equal(t, 1, next)
+
+	filtered, _, err = b.FetchFiltered(ctx, "events", 0, 0, 1<<20, FetchFilter{From: msgs[2].Timestamp, Until: msgs[4].Timestamp})
+	require.NoError(t, err)
+	for _, msg := range filtered {
+		require.False(t, msg.Timestamp.Before(msgs[2].Timestamp))
+		require.True(t, msg.Timestamp.Before(msgs[4].Timestamp))
+	}
+	require.Contains(t, data(filtered), "click 2")
+
+	// Nothing kept, the next offset still moves on
+	filtered, next, err = b.FetchFiltered(ctx, "events", 0, 2, 1<<20, FetchFilter{From: time.Now().Add(time.Hour)})
+	require.NoError(t, err)
+	require.Empty(t, filtered)
+	require.Equal(t, 6, next)
+}
@@ -0,0 +1,301 @@
+package brain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mvaleed/brook/internal/metrics"
+)
+
+const groupsDirName = "groups"
+
+var ErrUnknownGroup = errors.New("unknown consumer group")
+
+// groupDir returns the directory of the consumer group called group.
+func (b *Broker) groupDir(group string) (string, error) {
+	if !validName(group) {
+		return "", fmt.Errorf("invalid consumer group name %q", group)
+	}
+	return filepath.Join(b.metaDir(), groupsDirName, group), nil
+}
+
+// groupCursors returns where the offsets committed by the consumer group
+// called group for the partitions of the topic called name are kept: one
+// cursor per partition, named like the partition's directory, in the
+// directory of the topic's namespace if it has one.
+func (b *Broker) groupCursors(group string, name string) (*cursorStore, error) {
+	dir, err := b.groupDir(group)
+	if err != nil {
+		return nil, err
+	}
+	if namespace, _ := SplitTopicName(name); namespace != "" {
+		dir = filepath.Join(dir, namespacesDirName, namespace)
+	}
+	return &cursorStore{dir: dir}, nil
+}
+
+// CommitOffset durably records that the consumer group called group is done
+// with partition n of the topic called name up to offset, which is the next
+// one it will consume. It takes the consume operation on the topic.
+func (b *Broker) CommitOffset(ctx context.Context, group string, name string, n int, offset int) error {
+	if err := b.authorize(ctx, name, OperationConsume); err != nil {
+		return err
+	}
+	if _, err := b.Partition(name, n); err != nil {
+		return err
+	}
+	cursors, err := b.groupCursors(group, name)
+	if err != nil {
+		return err
+	}
+	return cursors.store(partitionName(name, n), offset)
+}
+
+// CommittedOffset returns the offset last committed by the consumer group
+// called group for partition n of the topic called name, false if it never
+// committed one.
+func (b *Broker) CommittedOffset(ctx context.Context, group string, name string, n int) (int, bool, error) {
+	if err := b.authorize(ctx, name, OperationConsume); err != nil {
+		return 0, false, err
+	}
+	cursors, err := b.groupCursors(group, name)
+	if err != nil {
+		return 0, false, err
+	}
+	return cursors.lookup(partitionName(name, n))
+}
+
+// Offsets returns the offset of the oldest message partition n of the topic
+// called name still holds, and the offset the next message published to it
+// will get.
+func (b *Broker) Offsets(name string, n int) (int, int, error) {
+	p, err := b.Partition(name, n)
+	if err != nil {
+		return 0, 0, err
+	}
+	return p.FirstOffset(), p.NextOffset(), nil
+}
+
+// ListOffsets is Offsets for the principal of ctx, who needs the consume
+// operation on the topic.
+func (b *Broker) ListOffsets(ctx context.Context, name string, n int) (int, int, error) {
+	if err := b.authorize(ctx, name, OperationConsume); err != nil {
+		return 0, 0, err
+	}
+	return b.Offsets(name, n)
+}
+
+// OffsetForTimestamp returns the offset of the first message of partition n
+// of the topic called name stamped at or after timestamp, or the offset the
+// next message published to it will get if there is none, for consumers to
+// start from a point in time. It takes the consume operation on the topic.
+func (b *Broker) OffsetForTimestamp(ctx context.Context, name string, n int, timestamp time.Time) (int, error) {
+	if err := b.authorize(ctx, name, OperationConsume); err != nil {
+		return 0, err
+	}
+	p, err := b.Partition(name, n)
+	if err != nil {
+		return 0, err
+	}
+	return p.OffsetForTimestamp(timestamp)
+}
+
+// PartitionLag is how far a consumer group is behind on a partition.
+type PartitionLag struct {
+	Topic     string `json:"topic"`
+	Partition int    `json:"partition"`
+	// CommittedOffset is the offset the group committed, the next one it
+	// will consume.
+	CommittedOffset int `json:"committed_offset"`
+	// EndOffset is the high watermark of the partition, the offset the next
+	// message published to it will get.
+	EndOffset int `json:"end_offset"`
+	// Lag is how many messages the group has yet to consume, EndOffset minus
+	// CommittedOffset.
+	Lag int `json:"lag"`
+}
+
+// GroupDescription is what a consumer group committed and how far behind it
+// is.
+type GroupDescription struct {
+	Name string
+	// Partitions are the partitions the group committed an offset for,
+	// sorted by topic and number.
+	Partitions []PartitionLag
+	// Lag is the sum of the lag of every partition.
+	Lag int
+}
+
+// Groups returns the names of the consumer groups that committed an offset,
+// sorted. Groups span topics, so it needs the admin operation on every
+// topic, "*".
+func (b *Broker) Groups(ctx context.Context) ([]string, error) {
+	if err := b.authorize(ctx, "*", OperationAdmin); err != nil {
+		return nil, err
+	}
+	return b.groupNames()
+}
+
+// groupNames returns the names of the consumer groups that committed an
+// offset, sorted.
+func (b *Broker) groupNames() ([]string, error) {
+	entries, err := os.ReadDir(filepath.Join(b.metaDir(), groupsDirName))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list consumer groups: %w", err)
+	}
+
+	var names []string
+	for _, entry := range entries {
+		if entry.IsDir() && validName(entry.Name()) {
+			names = append(names, entry.Name())
+		}
+	}
+	return names, nil
+}
+
+// DescribeGroup returns the offsets the consumer group called group committed
+// and its lag on every partition it committed one for. It needs the admin
+// operation on each of their topics. A group that never committed is an error
+// matching ErrUnknownGroup.
+func (b *Broker) DescribeGroup(ctx context.Context, group string) (GroupDescription, error) {
+	lags, err := b.groupLag(group)
+	if err != nil {
+		return GroupDescription{}, err
+	}
+	if len(lags) == 0 {
+		return GroupDescription{}, fmt.Errorf("%w: %s", ErrUnknownGroup, group)
+	}
+
+	d := GroupDescription{Name: group, Partitions: lags}
+	for i, lag := range lags {
+		if i == 0 || lag.Topic != lags[i-1].Topic {
+			if err := b.authorize(ctx, lag.Topic, OperationAdmin); err != nil {
+				return GroupDescription{}, err
+			}
+		}
+		d.Lag += lag.Lag
+	}
+	return d, nil
+}
+
+// groupLag returns the lag of the consumer group called group on the
+// partitions it committed an offset for, sorted by topic and number. The
+// offsets committed for partitions that no longer exist are left out.
+func (b *Broker) groupLag(group string) ([]PartitionLag, error) {
+	dir, err := b.groupDir(group)
+	if err != nil {
+		return nil, err
+	}
+	// The topics outside namespaces, then the ones of each namespace
+	prefixes := []string{""}
+	entries, err := os.ReadDir(filepath.Join(dir, namespacesDirName))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to list the namespaces of consumer group %s: %w", group, err)
+	}
+	for _, entry := range entries {
+		if entry.IsDir() && validName(entry.Name()) {
+			prefixes = append(prefixes, entry.Name()+"/")
+		}
+	}
+
+	var lags []PartitionLag
+	for _, prefix := range prefixes {
+		cursors, err := b.groupCursors(group, prefix)
+		if err != nil {
+			return nil, err
+		}
+		names, err := cursors.names()
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range names {
+			i := strings.LastIndexByte(name, '-')
+			if i < 0 {
+				continue
+			}
+			n, err := strconv.Atoi(name[i+1:])
+			if err != nil {
+				continue
+			}
+			topic := prefix + name[:i]
+			p, err := b.Partition(topic, n)
+			if errors.Is(err, ErrClosed) {
+				return nil, err
+			}
+			if err != nil {
+				// Deleted since the group committed
+				continue
+			}
+			committed, ok, err := cursors.lookup(name)
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				continue
+			}
+			end := p.NextOffset()
+			lags = append(lags, PartitionLag{
+				Topic:           topic,
+				Partition:       n,
+				CommittedOffset: committed,
+				EndOffset:       end,
+				Lag:             max(end-committed, 0),
+			})
+		}
+	}
+	slices.SortFunc(lags, func(a, b PartitionLag) int {
+		if c := strings.Compare(a.Topic, b.Topic); c != 0 {
+			return c
+		}
+		return a.Partition - b.Partition
+	})
+	return lags, nil
+}
+
+// Collect implements metrics.Collector, with the committed offset and lag of
+// every consumer group on every partition. Groups that can't be read are
+// left out.
+func (b *Broker) Collect() []metrics.Sample {
+	groups, err := b.groupNames()
+	if err != nil {
+		return nil
+	}
+
+	var samples []metrics.Sample
+	for _, group := range groups {
+		lags, err := b.groupLag(group)
+		if err != nil {
+			continue
+		}
+		for _, lag := range lags {
+			labels := map[string]string{"group": group, "topic": lag.Topic, "partition": strconv.Itoa(lag.Partition)}
+			samples = append(samples,
+				metrics.Sample{
+					Name:   "brook_consumer_group_committed_offset",
+					Help:   "Offset the consumer group committed for the partition.",
+					Type:   metrics.Gauge,
+					Labels: labels,
+					Value:  float64(lag.CommittedOffset),
+				},
+				metrics.Sample{
+					Name:   "brook_consumer_group_lag",
+					Help:   "Messages of the partition the consumer group has yet to consume.",
+					Type:   metrics.Gauge,
+					Labels: labels,
+					Value:  float64(lag.Lag),
+				},
+			)
+		}
+	}
+	return samples
+}
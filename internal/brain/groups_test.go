@@ -0,0 +1,144 @@
+package brain
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/mvaleed/brook/internal/metrics"
+	"github.com/mvaleed/brook/internal/storage"
+)
+
+func TestBroker_GroupOffsets(t *testing.T) {
+	paths := storage.Paths{Data: t.TempDir()}
+	b := openTestBroker(t, paths)
+	ctx := context.Background()
+	require.NoError(t, b.CreateTopic(ctx, "orders", TopicConfig{Partitions: 2}))
+	for range 3 {
+		require.NoError(t, b.Produce(ctx, "orders", 1, []byte("order")))
+	}
+
+	first, next, err := b.Offsets("orders", 1)
+	require.NoError(t, err)
+	require.Equal(t, 0, first)
+	require.Equal(t, 3, next)
+
+	_, ok, err := b.CommittedOffset(ctx, "billing", "orders", 1)
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	require.NoError(t, b.CommitOffset(ctx, "billing", "orders", 1, 2))
+	require.ErrorIs(t, b.CommitOffset(ctx, "billing", "payments", 0, 2), ErrUnknownTopic)
+	require.Error(t, b.CommitOffset(ctx, "../billing", "orders", 1, 2))
+	require.NoError(t, b.Close())
+
+	// Committed offsets survive a restart, per group and partition
+	b = openTestBroker(t, paths)
+	defer b.Close()
+	offset, ok, err := b.CommittedOffset(ctx, "billing", "orders", 1)
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, 2, offset)
+	_, ok, err = b.CommittedOffset(ctx, "billing", "orders", 0)
+	require.NoError(t, err)
+	require.False(t, ok)
+	_, ok, err = b.CommittedOffset(ctx, "shipping", "orders", 1)
+	require.NoError(t, err)
+	require.False(t, ok)
+}
+
+func TestBroker_DescribeGroup(t *testing.T) {
+	b := openTestBroker(t, storage.Paths{Data: t.TempDir()})
+	defer b.Close()
+	ctx := context.Background()
+	require.NoError(t, b.CreateTopic(ctx, "orders", TopicConfig{Partitions: 2}))
+	require.NoError(t, b.CreateTopic(ctx, "refunds", DefaultTopicConfig()))
+	for range 5 {
+		require.NoError(t, b.Produce(ctx, "orders", 0, []byte("order")))
+		require.NoError(t, b.Produce(ctx, "refunds", 0, []byte("refund")))
+	}
+
+	groups, err := b.Groups(ctx)
+	require.NoError(t, err)
+	require.Empty(t, groups)
+	_, err = b.DescribeGroup(ctx, "billing")
+	require.ErrorIs(t, err, ErrUnknownGroup)
+
+	require.NoError(t, b.CommitOffset(ctx, "billing", "refunds", 0, 5))
+	require.NoError(t, b.CommitOffset(ctx, "billing", "orders", 1, 0))
+	require.NoError(t, b.CommitOffset(ctx, "billing", "orders", 0, 2))
+	require.NoError(t, b.CommitOffset(ctx, "shipping", "orders", 0, 4))
+
+	groups, err = b.Groups(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []string{"billing", "shipping"}, groups)
+
+	d, err := b.DescribeGroup(ctx, "billing")
+	require.NoError(t, err)
+	require.Equal(t, GroupDescription{
+		Name: "billing",
+		Partitions: []PartitionLag{
+			{Topic: "orders", Partition: 0, CommittedOffset: 2, EndOffset: 5, Lag: 3},
+			{Topic: "orders", Partition: 1, CommittedOffset: 0, EndOffset: 0, Lag: 0},
+			{Topic: "refunds", Partition: 0, CommittedOffset: 5, EndOffset: 5, Lag: 0},
+		},
+		Lag: 3,
+	}, d)
+
+	r := &metrics.Registry{}
+	r.Register(b)
+	var out strings.Builder
+	_, err = r.WriteTo(&out)
+	require.NoError(t, err)
+	require.Contains(t, out.String(), `brook_consumer_group_lag{group="billing",partition="0",topic="orders"} 3`+"\n")
+	require.Contains(t, out.String(), `brook_consumer_group_lag{group="shipping",partition="0",topic="orders"} 1`+"\n")
+	require.Contains(t, out.String(), `brook_consumer_group_committed_offset{group="billing",partition="0",topic="refunds"} 5`+"\n")
+}
+
+func TestBroker_GroupsAuthorization(t *testing.T) {
+	ctx := context.Background()
+	config := DefaultBrokerConfig()
+	config.Authorize = true
+	config.SuperUsers = []string{"root"}
+	b, err := OpenBroker(storage.Paths{Data: t.TempDir()}, config)
+	require.NoError(t, err)
+	defer b.Close()
+
+	root := WithPrincipal(ctx, "root")
+	require.NoError(t, b.CreateTopic(root, "orders", DefaultTopicConfig()))
+	require.NoError(t, b.CommitOffset(root, "billing", "orders", 0, 0))
+	require.NoError(t, b.AddACL(root, ACL{Principal: "alice", Topic: "orders", Operation: OperationAdmin}))
+	require.NoError(t, b.AddACL(root, ACL{Principal: "bob", Topic: "*", Operation: OperationAdmin}))
+
+	for _, principal := range []string{"", "alice"} {
+		_, err := b.Groups(WithPrincipal(ctx, principal))
+		require.ErrorIs(t, err, ErrUnauthorized, principal)
+	}
+	groups, err := b.Groups(WithPrincipal(ctx, "bob"))
+	require.NoError(t, err)
+	require.Equal(t, []string{"billing"}, groups)
+}
+
+func TestBroker_ListOffsets(t *testing.T) {
+	ctx := context.Background()
+	config := DefaultBrokerConfig()
+	config.Authorize = true
+	config.SuperUsers = []string{"root"}
+	b, err := OpenBroker(storage.Paths{Data: t.TempDir()}, config)
+	require.NoError(t, err)
+	defer b.Close()
+
+	root := WithPrincipal(ctx, "root")
+	require.NoError(t, b.CreateTopic(root, "orders", DefaultTopicConfig()))
+	require.NoError(t, b.Produce(root, "orders", 0, []byte("order")))
+	require.NoError(t, b.AddACL(root, ACL{Principal: "alice", Topic: "orders", Operation: OperationConsume}))
+
+	_, _, err = b.ListOffsets(ctx, "orders", 0)
+	require.ErrorIs(t, err, ErrUnauthorized)
+	first, next, err := b.ListOffsets(WithPrincipal(ctx, "alice"), "orders", 0)
+	require.NoError(t, err)
+	require.Equal(t, 0, first)
+	require.Equal(t, 1, next)
+}
@@ -0,0 +1,193 @@
+package brain
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"slices"
+	"unicode/utf8"
+)
+
+// jsonSchema is the part of JSON Schema payloads are validated with: types,
+// object properties, array items, enums, bounds and patterns. Other keywords
+// are ignored, as validators do with keywords they don't know.
+type jsonSchema struct {
+	Types                []string
+	Properties           map[string]*jsonSchema
+	Required             []string
+	AdditionalProperties *bool
+	Items                *jsonSchema
+	Enum                 []any
+	Minimum              *float64
+	Maximum              *float64
+	MinLength            *int
+	MaxLength            *int
+	MinItems             *int
+	MaxItems             *int
+	Pattern              *regexp.Regexp
+}
+
+func parseJSONSchema(data []byte) (*jsonSchema, error) {
+	var raw struct {
+		Type                 json.RawMessage            `json:"type"`
+		Properties           map[string]json.RawMessage `json:"properties"`
+		Required             []string                   `json:"required"`
+		AdditionalProperties *bool                      `json:"additionalProperties"`
+		Items                json.RawMessage            `json:"items"`
+		Enum                 []any                      `json:"enum"`
+		Minimum              *float64                   `json:"minimum"`
+		Maximum              *float64                   `json:"maximum"`
+		MinLength            *int                       `json:"minLength"`
+		MaxLength            *int                       `json:"maxLength"`
+		MinItems             *int                       `json:"minItems"`
+		MaxItems             *int                       `json:"maxItems"`
+		Pattern              *string                    `json:"pattern"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+
+	s := &jsonSchema{
+		Required:             raw.Required,
+		AdditionalProperties: raw.AdditionalProperties,
+		Enum:                 raw.Enum,
+		Minimum:              raw.Minimum,
+		Maximum:              raw.Maximum,
+		MinLength:            raw.MinLength,
+		MaxLength:            raw.MaxLength,
+		MinItems:             raw.MinItems,
+		MaxItems:             raw.MaxItems,
+	}
+	// The type is a name or a list of them
+	if len(raw.Type) > 0 {
+		var name string
+		if err := json.Unmarshal(raw.Type, &name); err == nil {
+			s.Types = []string{name}
+		} else if err := json.Unmarshal(raw.Type, &s.Types); err != nil {
+			return nil, fmt.Errorf("type: %w", err)
+		}
+		for _, t := range s.Types {
+			switch t {
+			case "object", "array", "string", "number", "integer", "boolean", "null":
+			default:
+				return nil, fmt.Errorf("unknown type %q", t)
+			}
+		}
+	}
+	if raw.Properties != nil {
+		s.Properties = make(map[string]*jsonSchema, len(raw.Properties))
+		for name, property := range raw.Properties {
+			var err error
+			if s.Properties[name], err = parseJSONSchema(property); err != nil {
+				return nil, fmt.Errorf("property %s: %w", name, err)
+			}
+		}
+	}
+	if len(raw.Items) > 0 {
+		var err error
+		if s.Items, err = parseJSONSchema(raw.Items); err != nil {
+			return nil, fmt.Errorf("items: %w", err)
+		}
+	}
+	if raw.Pattern != nil {
+		var err error
+		if s.Pattern, err = regexp.Compile(*raw.Pattern); err != nil {
+			return nil, fmt.Errorf("pattern: %w", err)
+		}
+	}
+	return s, nil
+}
+
+// jsonType returns the JSON Schema type of a value decoded by encoding/json.
+// Whole numbers are integers, and numbers too.
+func jsonType(v any) string {
+	switch v := v.(type) {
+	case map[string]any:
+		return "object"
+	case []any:
+		return "array"
+	case string:
+		return "string"
+	case float64:
+		if v == math.Trunc(v) {
+			return "integer"
+		}
+		return "number"
+	case bool:
+		return "boolean"
+	default:
+		return "null"
+	}
+}
+
+// validate checks v against s, path is where v is in the payload.
+func (s *jsonSchema) validate(v any, path string) error {
+	if path == "" {
+		path = "$"
+	}
+	if len(s.Types) > 0 {
+		t := jsonType(v)
+		if !slices.Contains(s.Types, t) && !(t == "integer" && slices.Contains(s.Types, "number")) {
+			return fmt.Errorf("%s is a %s, not %v", path, t, s.Types)
+		}
+	}
+	if len(s.Enum) > 0 && !slices.ContainsFunc(s.Enum, func(e any) bool { return reflect.DeepEqual(e, v) }) {
+		return fmt.Errorf("%s isn't one of %v", path, s.Enum)
+	}
+
+	switch v := v.(type) {
+	case map[string]any:
+		for _, name := range s.Required {
+			if _, ok := v[name]; !ok {
+				return fmt.Errorf("%s.%s is required", path, name)
+			}
+		}
+		for name, value := range v {
+			property, ok := s.Properties[name]
+			if !ok {
+				if s.AdditionalProperties != nil && !*s.AdditionalProperties {
+					return fmt.Errorf("%s.%s isn't allowed", path, name)
+				}
+				continue
+			}
+			if err := property.validate(value, path+"."+name); err != nil {
+				return err
+			}
+		}
+	case []any:
+		if s.MinItems != nil && len(v) < *s.MinItems {
+			return fmt.Errorf("%s has fewer than %d items", path, *s.MinItems)
+		}
+		if s.MaxItems != nil && len(v) > *s.MaxItems {
+			return fmt.Errorf("%s has more than %d items", path, *s.MaxItems)
+		}
+		if s.Items != nil {
+			for i, item := range v {
+				if err := s.Items.validate(item, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+					return err
+				}
+			}
+		}
+	case string:
+		n := utf8.RuneCountInString(v)
+		if s.MinLength != nil && n < *s.MinLength {
+			return fmt.Errorf("%s is shorter than %d", path, *s.MinLength)
+		}
+		if s.MaxLength != nil && n > *s.MaxLength {
+			return fmt.Errorf("%s is longer than %d", path, *s.MaxLength)
+		}
+		if s.Pattern != nil && !s.Pattern.MatchString(v) {
+			return fmt.Errorf("%s doesn't match %s", path, s.Pattern)
+		}
+	case float64:
+		if s.Minimum != nil && v < *s.Minimum {
+			return fmt.Errorf("%s is less than %v", path, *s.Minimum)
+		}
+		if s.Maximum != nil && v > *s.Maximum {
+			return fmt.Errorf("%s is more than %v", path, *s.Maximum)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package brain
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestJSONSchema(t *testing.T) {
+	s, err := parseJSONSchema([]byte(`{
+		"type": "object",
+		"properties": {
+			"name": {"type": "string", "minLength": 1, "maxLength": 5, "pattern": "^[a-z]+$"},
+			"price": {"type": "number", "minimum": 0, "maximum": 100},
+			"count": {"type": ["integer", "null"]},
+			"tags": {"type": "array", "items": {"type": "string"}, "minItems": 1, "maxItems": 2}
+		},
+		"required": ["name"],
+		"additionalProperties": false
+	}`))
+	require.NoError(t, err)
+
+	for _, test := range []struct {
+		payload string
+		err     string
+	}{
+		{payload: `{"name": "pen", "price": 1.5, "count": 3, "tags": ["a"]}`},
+		{payload: `{"name": "pen", "price": 2, "count": null}`},
+		{payload: `[]`, err: "$ is a array"},
+		{payload: `{}`, err: "$.name is required"},
+		{payload: `{"name": ""}`, err: "$.name is shorter than 1"},
+		{payload: `{"name": "pencil"}`, err: "$.name is longer than 5"},
+		{payload: `{"name": "Pen"}`, err: "$.name doesn't match"},
+		{payload: `{"name": "pen", "price": -1}`, err: "$.price is less than 0"},
+		{payload: `{"name": "pen", "price": 101}`, err: "$.price is more than 100"},
+		{payload: `{"name": "pen", "count": 1.5}`, err: "$.count is a number"},
+		{payload: `{"name": "pen", "tags": []}`, err: "$.tags has fewer than 1 items"},
+		{payload: `{"name": "pen", "tags": ["a", "b", "c"]}`, err: "$.tags has more than 2 items"},
+		{payload: `{"name": "pen", "tags": [1]}`, err: "$.tags[0] is a integer"},
+		{payload: `{"name": "pen", "color": "red"}`, err: "$.color isn't allowed"},
+	} {
+		var v any
+		require.NoError(t, json.Unmarshal([]byte(test.payload), &v))
+		err := s.validate(v, "")
+		if test.err == "" {
+			require.NoError(t, err, test.payload)
+		} else {
+			require.ErrorContains(t, err, test.err, test.payload)
+		}
+	}
+
+	for _, invalid := range []string{`[`, `{"type": "thing"}`, `{"type": 3}`, `{"pattern": "("}`, `{"items": {"type": "x"}}`} {
+		_, err := parseJSONSchema([]byte(invalid))
+		require.Error(t, err, invalid)
+	}
+}
@@ -0,0 +1,126 @@
+package brain
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/mvaleed/brook/internal/storage"
+)
+
+const (
+	metadataFileName = "topics.json"
+	metadataVersion  = 1
+)
+
+// metadata is the broker state kept on disk: the topics and their configs.
+type metadata struct {
+	Version int                      `json:"version"`
+	Topics  map[string]topicMetadata `json:"topics"`
+}
+
+type topicMetadata struct {
+	Partitions          int           `json:"partitions"`
+	RetentionMs         int64         `json:"retention_ms,omitempty"`
+	Compression         Compression   `json:"compression,omitempty"`
+	MaxSegmentBytes     int64         `json:"max_segment_bytes,omitempty"`
+	MaxMessageBytes     int64         `json:"max_message_bytes,omitempty"`
+	Durability          string        `json:"durability,omitempty"`
+	Compact             bool          `json:"compact,omitempty"`
+	Queue               bool          `json:"queue,omitempty"`
+	VisibilityTimeoutMs int64         `json:"visibility_timeout_ms,omitempty"`
+	TimestampType       TimestampType `json:"timestamp_type,omitempty"`
+	MaxTimestampSkewMs  int64         `json:"max_timestamp_skew_ms,omitempty"`
+	// DataDirs holds the data path of the partitions moved away from the
+	// broker's
+	DataDirs map[int]string `json:"data_dirs,omitempty"`
+}
+
+func newTopicMetadata(config TopicConfig) topicMetadata {
+	return topicMetadata{
+		Partitions:          config.Partitions,
+		RetentionMs:         config.Retention.Milliseconds(),
+		Compression:         config.Compression,
+		MaxSegmentBytes:     config.MaxSegmentBytes,
+		MaxMessageBytes:     config.MaxMessageBytes,
+		Durability:          config.Durability.String(),
+		Compact:             config.Compact,
+		Queue:               config.Queue,
+		VisibilityTimeoutMs: config.VisibilityTimeout.Milliseconds(),
+		TimestampType:       config.TimestampType,
+		MaxTimestampSkewMs:  config.MaxTimestampSkew.Milliseconds(),
+	}
+}
+
+func (m topicMetadata) config() TopicConfig {
+	return TopicConfig{
+		Partitions:        m.Partitions,
+		Retention:         time.Duration(m.RetentionMs) * time.Millisecond,
+		Compression:       m.Compression,
+		MaxSegmentBytes:   m.MaxSegmentBytes,
+		MaxMessageBytes:   m.MaxMessageBytes,
+		Durability:        parseDurability(m.Durability),
+		Compact:           m.Compact,
+		Queue:             m.Queue,
+		VisibilityTimeout: time.Duration(m.VisibilityTimeoutMs) * time.Millisecond,
+		TimestampType:     m.TimestampType,
+		MaxTimestampSkew:  time.Duration(m.MaxTimestampSkewMs) * time.Millisecond,
+	}
+}
+
+// parseDurability is the inverse of DurabilityMode.String, 0 for anything
+// else.
+func parseDurability(s string) storage.DurabilityMode {
+	for _, mode := range []storage.DurabilityMode{storage.DurabilityAsync, storage.DurabilityMedium, storage.DurabilityFull} {
+		if s == mode.String() {
+			return mode
+		}
+	}
+	return 0
+}
+
+// loadMetadata reads the metadata kept in dir, empty if there is none yet.
+func loadMetadata(dir string) (metadata, error) {
+	data, err := os.ReadFile(filepath.Join(dir, metadataFileName))
+	if errors.Is(err, os.ErrNotExist) {
+		return metadata{Version: metadataVersion, Topics: make(map[string]topicMetadata)}, nil
+	}
+	if err != nil {
+		return metadata{}, fmt.Errorf("failed to read broker metadata: %w", err)
+	}
+
+	var m metadata
+	if err := json.Unmarshal(data, &m); err != nil {
+		return metadata{}, fmt.Errorf("invalid broker metadata: %w", err)
+	}
+	if m.Version != metadataVersion {
+		return metadata{}, fmt.Errorf("unsupported broker metadata version %d", m.Version)
+	}
+	if m.Topics == nil {
+		m.Topics = make(map[string]topicMetadata)
+	}
+	for name, topic := range m.Topics {
+		if topic.Durability != "" && parseDurability(topic.Durability) == 0 {
+			return metadata{}, fmt.Errorf("invalid config of topic %s: unknown durability %q", name, topic.Durability)
+		}
+		if err := topic.config().Validate(); err != nil {
+			return metadata{}, fmt.Errorf("invalid config of topic %s: %w", name, err)
+		}
+	}
+	return m, nil
+}
+
+// storeMetadata atomically replaces the metadata kept in dir.
+func storeMetadata(dir string, m metadata) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := storage.WriteFileAtomic(dir, metadataFileName, data); err != nil {
+		return fmt.Errorf("failed to write broker metadata: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package brain
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/mvaleed/brook/internal/storage"
+)
+
+// namespacesDirName holds a directory for each namespace, under the data and
+// meta paths and under the directory of each consumer group. Partition
+// directories end with their number, so it can't clash with one.
+const namespacesDirName = "namespaces"
+
+// SplitTopicName returns the namespace of the topic called name and its name
+// within it. A topic is put in a namespace by naming it like
+// "namespace/topic", the namespace of the others is "".
+func SplitTopicName(name string) (namespace string, topic string) {
+	if namespace, topic, ok := strings.Cut(name, "/"); ok {
+		return namespace, topic
+	}
+	return "", name
+}
+
+// validName reports whether name can name a directory or file on its own.
+func validName(name string) bool {
+	return name != "" && name[0] != '.' && !strings.ContainsAny(name, `/\`)
+}
+
+// validateTopicName returns an error unless name is a valid name, or a valid
+// namespace and a valid name joined by a slash.
+func validateTopicName(name string) error {
+	namespace, topic, ok := strings.Cut(name, "/")
+	if ok && validName(namespace) && validName(topic) || !ok && validName(name) {
+		return nil
+	}
+	return fmt.Errorf("invalid topic name %q", name)
+}
+
+// namespacePaths returns where the partitions of the topic called name are
+// kept under paths: in the directory of its namespace, if it has one.
+func namespacePaths(paths storage.Paths, name string) storage.Paths {
+	namespace, _ := SplitTopicName(name)
+	if namespace == "" {
+		return paths
+	}
+	paths.Data = filepath.Join(paths.Data, namespacesDirName, namespace)
+	if paths.Meta != "" {
+		paths.Meta = filepath.Join(paths.Meta, namespacesDirName, namespace)
+	}
+	return paths
+}
+
+// aclScope returns the topic whose admin operation manages acl: "ns/*" for
+// an ACL on topics of the namespace ns only, so that the namespace has
+// admins of its own, and "*" for any other.
+func aclScope(acl ACL) string {
+	namespace, _ := SplitTopicName(acl.Topic)
+	if namespace == "" || strings.Contains(namespace, "*") {
+		return "*"
+	}
+	return namespace + "/*"
+}
@@ -0,0 +1,129 @@
+package brain
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/mvaleed/brook/internal/storage"
+)
+
+func TestSplitTopicName(t *testing.T) {
+	namespace, topic := SplitTopicName("billing/orders")
+	require.Equal(t, "billing", namespace)
+	require.Equal(t, "orders", topic)
+	namespace, topic = SplitTopicName("orders")
+	require.Equal(t, "", namespace)
+	require.Equal(t, "orders", topic)
+
+	for _, name := range []string{"orders", "billing/orders", "billing.eu/orders-v2"} {
+		require.NoError(t, validateTopicName(name), name)
+	}
+	for _, name := range []string{"", "/orders", "billing/", "a/b/c", ".hidden/orders", "billing/.orders", `billing\orders`} {
+		require.Error(t, validateTopicName(name), name)
+	}
+}
+
+func TestBroker_Namespaces(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("topics of namespaces are kept apart", func(t *testing.T) {
+		paths := storage.Paths{Data: t.TempDir(), Meta: t.TempDir()}
+		b := openTestBroker(t, paths)
+		for _, name := range []string{"orders", "billing/orders", "shipping/orders"} {
+			require.NoError(t, b.CreateTopic(ctx, name, DefaultTopicConfig()))
+			require.NoError(t, b.Produce(ctx, name, 0, []byte(name)))
+		}
+		require.NoError(t, b.Produce(ctx, "billing/orders", 0, []byte("billing/orders")))
+		require.DirExists(t, filepath.Join(paths.Data, "namespaces", "billing", "orders-0"))
+		require.DirExists(t, filepath.Join(paths.Meta, "namespaces", "shipping", "orders-0"))
+
+		require.NoError(t, b.CommitOffset(ctx, "audit", "orders", 0, 1))
+		require.NoError(t, b.CommitOffset(ctx, "audit", "billing/orders", 0, 1))
+		require.NoError(t, b.Close())
+
+		b = openTestBroker(t, paths)
+		defer b.Close()
+		require.Equal(t, []string{"billing/orders", "orders", "shipping/orders"}, b.Topics())
+		msgs, err := b.Fetch(ctx, "shipping/orders", 0, 0, 1024)
+		require.NoError(t, err)
+		require.Len(t, msgs, 1)
+		require.Equal(t, []byte("shipping/orders"), msgs[0].Data)
+
+		// Group offsets are kept per namespace too
+		_, ok, err := b.CommittedOffset(ctx, "audit", "shipping/orders", 0)
+		require.NoError(t, err)
+		require.False(t, ok)
+		d, err := b.DescribeGroup(ctx, "audit")
+		require.NoError(t, err)
+		require.Equal(t, []PartitionLag{
+			{Topic: "billing/orders", Partition: 0, CommittedOffset: 1, EndOffset: 2, Lag: 1},
+			{Topic: "orders", Partition: 0, CommittedOffset: 1, EndOffset: 1, Lag: 0},
+		}, d.Partitions)
+
+		require.NoError(t, b.MovePartition(ctx, "billing/orders", 0, t.TempDir()))
+		msgs, err = b.Fetch(ctx, "billing/orders", 0, 0, 1024)
+		require.NoError(t, err)
+		require.Len(t, msgs, 2)
+		require.NoDirExists(t, filepath.Join(paths.Data, "namespaces", "billing", "orders-0"))
+	})
+
+	t.Run("namespaces are throttled across their topics", func(t *testing.T) {
+		config := DefaultBrokerConfig()
+		config.Quotas = QuotaConfig{
+			Namespace:  ByteRates{ProduceBytesPerSecond: 100},
+			Namespaces: map[string]ByteRates{"batch": {}},
+		}
+		b, err := OpenBroker(storage.Paths{Data: t.TempDir()}, config)
+		require.NoError(t, err)
+		defer b.Close()
+		now := time.Now()
+		b.quotas.now = func() time.Time { return now }
+		for _, name := range []string{"orders", "billing/orders", "billing/refunds", "batch/orders"} {
+			require.NoError(t, b.CreateTopic(ctx, name, DefaultTopicConfig()))
+		}
+		data := bytes.Repeat([]byte("x"), 150)
+
+		require.NoError(t, b.Produce(ctx, "billing/orders", 0, data))
+		err = b.Produce(ctx, "billing/refunds", 0, data)
+		var throttled *ThrottleError
+		require.True(t, errors.As(err, &throttled))
+		require.Equal(t, "namespace billing", throttled.Quota)
+
+		// Neither topics outside namespaces nor the namespaces without a limit
+		// are throttled
+		for range 3 {
+			require.NoError(t, b.Produce(ctx, "orders", 0, data))
+			require.NoError(t, b.Produce(ctx, "batch/orders", 0, data))
+		}
+	})
+
+	t.Run("namespaces have admins of their own", func(t *testing.T) {
+		config := DefaultBrokerConfig()
+		config.Authorize = true
+		config.SuperUsers = []string{"root"}
+		b, err := OpenBroker(storage.Paths{Data: t.TempDir()}, config)
+		require.NoError(t, err)
+		defer b.Close()
+		root := WithPrincipal(ctx, "root")
+		alice := WithPrincipal(ctx, "alice")
+		bob := WithPrincipal(ctx, "bob")
+
+		require.NoError(t, b.AddACL(root, ACL{Principal: "alice", Topic: "billing/*", Operation: OperationAdmin}))
+		require.NoError(t, b.CreateTopic(alice, "billing/orders", DefaultTopicConfig()))
+		require.ErrorIs(t, b.CreateTopic(alice, "shipping/orders", DefaultTopicConfig()), ErrUnauthorized)
+		require.ErrorIs(t, b.CreateTopic(alice, "orders", DefaultTopicConfig()), ErrUnauthorized)
+
+		require.NoError(t, b.AddACL(alice, ACL{Principal: "bob", Topic: "billing/orders", Operation: OperationProduce}))
+		require.NoError(t, b.Produce(bob, "billing/orders", 0, []byte("order")))
+		require.ErrorIs(t, b.AddACL(alice, ACL{Principal: "bob", Topic: "shipping/*", Operation: OperationProduce}), ErrUnauthorized)
+		require.ErrorIs(t, b.AddACL(alice, ACL{Principal: "bob", Topic: "*", Operation: OperationProduce}), ErrUnauthorized)
+		require.NoError(t, b.RemoveACL(alice, ACL{Principal: "bob", Topic: "billing/orders", Operation: OperationProduce}))
+		require.ErrorIs(t, b.Produce(bob, "billing/orders", 0, []byte("order")), ErrUnauthorized)
+	})
+}
@@ -0,0 +1,108 @@
+package brain
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/mvaleed/brook/internal/storage"
+)
+
+// DefaultOpenConcurrency is how many partitions the broker opens at once,
+// unless configured otherwise. Opening is mostly waiting on the disk, for
+// the recovery scans of partitions that weren't closed cleanly.
+const DefaultOpenConcurrency = 8
+
+// openJob is a partition for openTopics to open.
+type openJob struct {
+	topic     string
+	partition int
+	paths     storage.Paths
+	config    storage.PartitionConfig
+}
+
+// openTopics opens every partition of every topic in the metadata, up to
+// OpenConcurrency of them at once, logging the progress as each one opens.
+// It opens them all or none of them.
+func (b *Broker) openTopics() error {
+	var jobs []openJob
+	for name, topic := range b.metadata.Topics {
+		config := topic.config().partitionConfig(b.config.Partition)
+		b.topics[name] = make([]*storage.Partition, topic.Partitions)
+		for i := range topic.Partitions {
+			jobs = append(jobs, openJob{topic: name, partition: i, paths: namespacePaths(b.partitionPaths(topic, i), name), config: config})
+		}
+	}
+	if len(jobs) == 0 {
+		return nil
+	}
+
+	workers := b.config.OpenConcurrency
+	if workers == 0 {
+		workers = DefaultOpenConcurrency
+	}
+	start := time.Now()
+	queue := make(chan openJob)
+	var mu sync.Mutex // guards b.topics, errs, opened and failed
+	var errs []error
+	var opened int
+	failed := false
+
+	var wg sync.WaitGroup
+	for range min(workers, len(jobs)) {
+		wg.Go(func() {
+			for job := range queue {
+				jobStart := time.Now()
+				p, err := job.paths.OpenPartition(partitionName(job.topic, job.partition), job.config)
+
+				mu.Lock()
+				if err != nil {
+					errs = append(errs, fmt.Errorf("failed to open partition %d of topic %s: %w", job.partition, job.topic, err))
+					failed = true
+					mu.Unlock()
+					continue
+				}
+				b.topics[job.topic][job.partition] = p
+				opened++
+				report := p.RecoveryReport()
+				b.logger.Info("opened partition",
+					"topic", job.topic,
+					"partition", job.partition,
+					"progress", fmt.Sprintf("%d/%d", opened, len(jobs)),
+					"duration", time.Since(jobStart),
+					"clean_shutdown", report.CleanShutdown,
+					"scanned_segments", report.ScannedSegments,
+				)
+				mu.Unlock()
+			}
+		})
+	}
+	for _, job := range jobs {
+		mu.Lock()
+		stop := failed
+		mu.Unlock()
+		if stop {
+			// The partitions left aren't opened, the opened ones are closed
+			// below
+			break
+		}
+		queue <- job
+	}
+	close(queue)
+	wg.Wait()
+
+	if err := errors.Join(errs...); err != nil {
+		for name, partitions := range b.topics {
+			for _, p := range partitions {
+				if p != nil {
+					err = errors.Join(err, p.Close())
+				}
+			}
+			delete(b.topics, name)
+		}
+		return err
+	}
+	b.logger.Info("opened topics", "topics", len(b.topics), "partitions", len(jobs), "duration", time.Since(start))
+	return nil
+}
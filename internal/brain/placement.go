@@ -0,0 +1,171 @@
+package brain
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"syscall"
+
+	"github.com/mvaleed/brook/internal/storage"
+)
+
+// ErrDataDirOffline is returned for a produce to a partition kept in a data
+// directory that stopped taking writes. Its partitions are fenced: they are
+// still read from, but nothing is appended to them until the broker opens
+// again.
+var ErrDataDirOffline = errors.New("data directory offline")
+
+// Placement chooses the data directory of each new partition among the
+// broker's.
+type Placement int
+
+const (
+	// PlacementRoundRobin places partitions in every directory in turn.
+	PlacementRoundRobin Placement = iota
+	// PlacementFreeSpace places each partition in the directory with the
+	// most free space, counting a segment for each partition placed before
+	// it. It falls back to round robin where free space can't be told.
+	PlacementFreeSpace
+)
+
+func (p Placement) String() string {
+	switch p {
+	case PlacementRoundRobin:
+		return "round-robin"
+	case PlacementFreeSpace:
+		return "free-space"
+	default:
+		return fmt.Sprintf("Placement(%d)", int(p))
+	}
+}
+
+// dataDirs returns every data directory of the broker, its data path first.
+func (b *Broker) dataDirs() []string {
+	dirs := []string{filepath.Clean(b.paths.Data)}
+	for _, dir := range b.config.DataDirs {
+		if dir = filepath.Clean(dir); !slices.Contains(dirs, dir) {
+			dirs = append(dirs, dir)
+		}
+	}
+	return dirs
+}
+
+// checkDataDirs marks offline the data directories, other than the data
+// path, that can't be written to. The data path must work for the broker to
+// open at all.
+func (b *Broker) checkDataDirs() {
+	for _, dir := range b.dataDirs()[1:] {
+		if err := (storage.Paths{Data: dir}).Validate(); err != nil {
+			b.fenceDataDir(dir, err)
+		}
+	}
+}
+
+// placePartitions returns the data directory of each partition of a new
+// topic of config, skipping the offline ones.
+// Caller must hold b.mu.
+func (b *Broker) placePartitions(config TopicConfig) ([]string, error) {
+	var online []string
+	for _, dir := range b.dataDirs() {
+		if _, offline := b.offlineDirs[dir]; !offline {
+			online = append(online, dir)
+		}
+	}
+	if len(online) == 0 {
+		return nil, fmt.Errorf("no data directory to place partitions in: %w", ErrDataDirOffline)
+	}
+
+	placed := make([]string, config.Partitions)
+	if b.config.Placement == PlacementFreeSpace {
+		if free, err := b.freeSpace(online); err == nil {
+			segment := uint64(config.partitionConfig(b.config.Partition).MaxSegmentBytes)
+			for n := range placed {
+				i := 0
+				for j := range online {
+					if free[j] > free[i] {
+						i = j
+					}
+				}
+				placed[n] = online[i]
+				free[i] -= min(free[i], segment)
+			}
+			return placed, nil
+		} else if !errors.Is(err, errors.ErrUnsupported) {
+			b.logger.Warn("failed to check free space, placing partitions round robin", "err", err)
+		}
+	}
+	for n := range placed {
+		placed[n] = online[b.placed%len(online)]
+		b.placed++
+	}
+	return placed, nil
+}
+
+// freeSpace returns the free space of each of dirs.
+func (b *Broker) freeSpace(dirs []string) ([]uint64, error) {
+	free := make([]uint64, len(dirs))
+	for i, dir := range dirs {
+		var err error
+		if free[i], err = b.diskFree(dir); err != nil {
+			return nil, err
+		}
+	}
+	return free, nil
+}
+
+// partitionDir returns the data directory of partition n of topic.
+func (b *Broker) partitionDir(topic topicMetadata, n int) string {
+	return filepath.Clean(b.partitionPaths(topic, n).Data)
+}
+
+// checkOnline returns an error matching ErrDataDirOffline if partition n of
+// the topic called name is fenced.
+// Caller must hold b.mu.
+func (b *Broker) checkOnline(name string, n int) error {
+	dir := b.partitionDir(b.metadata.Topics[name], n)
+	if cause, offline := b.offlineDirs[dir]; offline {
+		return fmt.Errorf("%w: %s, holding partition %d of topic %s: %w", ErrDataDirOffline, dir, n, name, cause)
+	}
+	return nil
+}
+
+// writeFailed fences the data directory of partition n of the topic called
+// name if err tells the disk behind it stopped taking writes, and returns
+// err.
+func (b *Broker) writeFailed(name string, n int, err error) error {
+	if !errors.Is(err, syscall.EROFS) && !errors.Is(err, syscall.EIO) {
+		return err
+	}
+	b.mu.RLock()
+	dir := b.partitionDir(b.metadata.Topics[name], n)
+	b.mu.RUnlock()
+	b.fenceDataDir(dir, err)
+	return fmt.Errorf("%w: %s: %w", ErrDataDirOffline, dir, err)
+}
+
+// fenceDataDir marks dir offline, fencing every partition in it.
+func (b *Broker) fenceDataDir(dir string, cause error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.offlineDirs[dir]; ok {
+		return
+	}
+	b.offlineDirs[dir] = cause
+	b.logger.Error("data directory offline, fencing its partitions", "dir", dir, "err", cause)
+}
+
+// OfflineDataDirs returns the data directories that stopped taking writes,
+// sorted.
+func (b *Broker) OfflineDataDirs() []string {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	dirs := make([]string, 0, len(b.offlineDirs))
+	for dir := range b.offlineDirs {
+		dirs = append(dirs, dir)
+	}
+	slices.Sort(dirs)
+	return dirs
+}
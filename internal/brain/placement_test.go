@@ -0,0 +1,110 @@
+package brain
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/mvaleed/brook/internal/storage"
+)
+
+func TestBroker_Placement(t *testing.T) {
+	ctx := context.Background()
+	openJBODBroker := func(t *testing.T, placement Placement, dataDirs ...string) (*Broker, storage.Paths) {
+		t.Helper()
+		paths := storage.Paths{Data: t.TempDir(), Meta: t.TempDir()}
+		config := DefaultBrokerConfig()
+		config.DataDirs = dataDirs
+		config.Placement = placement
+		b, err := OpenBroker(paths, config)
+		require.NoError(t, err)
+		t.Cleanup(func() { b.Close() })
+		return b, paths
+	}
+	requireDir := func(t *testing.T, dir string, topic string, n int) {
+		t.Helper()
+		require.DirExists(t, filepath.Join(dir, partitionName(topic, n)))
+	}
+
+	t.Run("round robin", func(t *testing.T) {
+		disk1, disk2 := t.TempDir(), t.TempDir()
+		b, paths := openJBODBroker(t, PlacementRoundRobin, disk1, disk2)
+		require.NoError(t, b.CreateTopic(ctx, "orders", TopicConfig{Partitions: 4}))
+		require.NoError(t, b.CreateTopic(ctx, "events", TopicConfig{Partitions: 1}))
+
+		requireDir(t, paths.Data, "orders", 0)
+		requireDir(t, disk1, "orders", 1)
+		requireDir(t, disk2, "orders", 2)
+		requireDir(t, paths.Data, "orders", 3)
+		// Carries on where the last topic left off
+		requireDir(t, disk1, "events", 0)
+
+		// The placement survives a restart
+		require.NoError(t, b.Produce(ctx, "orders", 2, []byte("order")))
+		require.NoError(t, b.Close())
+		config := DefaultBrokerConfig()
+		config.DataDirs = []string{disk1, disk2}
+		b, err := OpenBroker(paths, config)
+		require.NoError(t, err)
+		defer b.Close()
+		msgs, err := b.Fetch(ctx, "orders", 2, 0, 1024)
+		require.NoError(t, err)
+		require.Len(t, msgs, 1)
+	})
+
+	t.Run("free space", func(t *testing.T) {
+		disk1 := t.TempDir()
+		b, paths := openJBODBroker(t, PlacementFreeSpace, disk1)
+		segment := uint64(b.config.Partition.MaxSegmentBytes)
+		b.diskFree = func(dir string) (uint64, error) {
+			if dir == disk1 {
+				return 3 * segment, nil
+			}
+			return segment, nil
+		}
+		require.NoError(t, b.CreateTopic(ctx, "orders", TopicConfig{Partitions: 4}))
+		requireDir(t, disk1, "orders", 0)
+		requireDir(t, disk1, "orders", 1)
+		// Both have a segment left, the first directory wins
+		requireDir(t, paths.Data, "orders", 2)
+		requireDir(t, disk1, "orders", 3)
+	})
+
+	t.Run("read-only directory fences its partitions", func(t *testing.T) {
+		disk1 := t.TempDir()
+		b, _ := openJBODBroker(t, PlacementRoundRobin, disk1)
+		require.NoError(t, b.CreateTopic(ctx, "orders", TopicConfig{Partitions: 2}))
+		require.NoError(t, b.Produce(ctx, "orders", 1, []byte("order")))
+
+		err := b.writeFailed("orders", 1, &os.PathError{Op: "write", Path: disk1, Err: syscall.EROFS})
+		require.ErrorIs(t, err, ErrDataDirOffline)
+		require.Equal(t, []string{filepath.Clean(disk1)}, b.OfflineDataDirs())
+
+		require.ErrorIs(t, b.Produce(ctx, "orders", 1, []byte("order")), ErrDataDirOffline)
+		require.NoError(t, b.Produce(ctx, "orders", 0, []byte("order")))
+		msgs, err := b.Fetch(ctx, "orders", 1, 0, 1024)
+		require.NoError(t, err)
+		require.Len(t, msgs, 1)
+
+		// New partitions go to the directories left
+		require.NoError(t, b.CreateTopic(ctx, "events", TopicConfig{Partitions: 2}))
+		require.Empty(t, b.metadata.Topics["events"].DataDirs)
+
+		// Other failures don't fence anything
+		require.ErrorIs(t, b.writeFailed("orders", 0, os.ErrClosed), os.ErrClosed)
+		require.Len(t, b.OfflineDataDirs(), 1)
+	})
+
+	t.Run("unusable directory is offline from the start", func(t *testing.T) {
+		file := filepath.Join(t.TempDir(), "not-a-dir")
+		require.NoError(t, os.WriteFile(file, nil, 0o644))
+		b, paths := openJBODBroker(t, PlacementRoundRobin, file)
+		require.Equal(t, []string{file}, b.OfflineDataDirs())
+		require.NoError(t, b.CreateTopic(ctx, "orders", TopicConfig{Partitions: 2}))
+		requireDir(t, paths.Data, "orders", 1)
+	})
+}
@@ -0,0 +1,113 @@
+// Package brain is the coordination layer on top of storage: topics,
+// subscribers and their offsets.
+package brain
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/mvaleed/brook/internal/storage"
+)
+
+var ErrClosed = errors.New("pubsub is closed")
+
+// PubSub is an embedded, broker-less publish/subscribe facade. Topics live in
+// the same process as their publishers and subscribers, and are stored on
+// local disk so that nothing published is lost across restarts and every
+// subscription resumes where it left off. It's meant for applications that
+// want durable in-process eventing before splitting into services.
+type PubSub struct {
+	paths  storage.Paths
+	config storage.PartitionConfig
+
+	mu     sync.Mutex
+	closed bool
+	topics map[string]*Topic
+}
+
+// OpenPubSub opens the topics kept under paths, which are created lazily by
+// Topic.
+func OpenPubSub(paths storage.Paths, config storage.PartitionConfig) (*PubSub, error) {
+	if err := paths.Validate(); err != nil {
+		return nil, err
+	}
+
+	return &PubSub{
+		paths:  paths,
+		config: config,
+		topics: make(map[string]*Topic),
+	}, nil
+}
+
+// Topic returns the topic called name, creating it if it doesn't exist yet.
+func (ps *PubSub) Topic(name string) (*Topic, error) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	if ps.closed {
+		return nil, ErrClosed
+	}
+	if t, ok := ps.topics[name]; ok {
+		return t, nil
+	}
+
+	partition, err := ps.paths.OpenPartition(name, ps.config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open topic %s: %w", name, err)
+	}
+
+	meta := ps.paths.Meta
+	if meta == "" {
+		meta = ps.paths.Data
+	}
+	t := newTopic(name, partition, filepath.Join(meta, name, cursorsDirName))
+	ps.topics[name] = t
+	return t, nil
+}
+
+// Topics returns the names of every topic stored under the data path, sorted,
+// whether it was opened by this PubSub or not.
+func (ps *PubSub) Topics() ([]string, error) {
+	entries, err := os.ReadDir(ps.paths.Data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list topics: %w", err)
+	}
+
+	var names []string
+	for _, entry := range entries {
+		// Hidden entries include the tombstones of deleted partitions
+		if !entry.IsDir() || strings.HasPrefix(entry.Name(), ".") || strings.Contains(entry.Name(), ".deleted-") {
+			continue
+		}
+		segments, err := filepath.Glob(filepath.Join(ps.paths.Data, entry.Name(), "*.log"))
+		if err != nil {
+			return nil, err
+		}
+		if len(segments) > 0 {
+			names = append(names, entry.Name())
+		}
+	}
+	return names, nil
+}
+
+// Close closes every topic. Subscriptions waiting for messages return
+// ErrClosed.
+func (ps *PubSub) Close() error {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	if ps.closed {
+		return nil
+	}
+	ps.closed = true
+
+	var errs []error
+	for _, t := range ps.topics {
+		errs = append(errs, t.close())
+	}
+	return errors.Join(errs...)
+}
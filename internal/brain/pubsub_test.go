@@ -0,0 +1,235 @@
+package brain
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/mvaleed/brook/internal/storage"
+)
+
+func openTestPubSub(t *testing.T, dir string) *PubSub {
+	t.Helper()
+	ps, err := OpenPubSub(storage.Paths{Data: dir}, storage.DefaultPartitionConfig())
+	require.NoError(t, err)
+	return ps
+}
+
+func TestPubSub(t *testing.T) {
+	t.Run("publish and subscribe", func(t *testing.T) {
+		ps := openTestPubSub(t, t.TempDir())
+		defer ps.Close()
+
+		topic, err := ps.Topic("orders")
+		require.NoError(t, err)
+		same, err := ps.Topic("orders")
+		require.NoError(t, err)
+		require.Same(t, topic, same)
+
+		for i := range 3 {
+			require.NoError(t, topic.Publish(fmt.Appendf(nil, "order %d", i)))
+		}
+
+		sub, err := topic.Subscribe("billing")
+		require.NoError(t, err)
+		defer sub.Close()
+
+		for i := range 3 {
+			msg, err := sub.Next(context.Background())
+			require.NoError(t, err)
+			require.Equal(t, i, msg.Offset)
+			require.Equal(t, fmt.Sprintf("order %d", i), string(msg.Data))
+		}
+
+		_, err = topic.Subscribe("billing")
+		require.ErrorIs(t, err, ErrSubscribed)
+	})
+	t.Run("next waits for a publish", func(t *testing.T) {
+		ps := openTestPubSub(t, t.TempDir())
+		defer ps.Close()
+		topic, err := ps.Topic("orders")
+		require.NoError(t, err)
+		sub, err := topic.Subscribe("billing")
+		require.NoError(t, err)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		_, err = sub.Next(ctx)
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+
+		go func() {
+			time.Sleep(10 * time.Millisecond)
+			topic.Publish([]byte("late order"))
+		}()
+		msg, err := sub.Next(context.Background())
+		require.NoError(t, err)
+		require.Equal(t, "late order", string(msg.Data))
+	})
+	t.Run("fetch", func(t *testing.T) {
+		ps := openTestPubSub(t, t.TempDir())
+		defer ps.Close()
+		topic, err := ps.Topic("orders")
+		require.NoError(t, err)
+		sub, err := topic.Subscribe("billing")
+		require.NoError(t, err)
+		defer sub.Close()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		_, err = sub.Fetch(ctx, 10)
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+
+		for i := range 5 {
+			require.NoError(t, topic.PublishContext(context.Background(), fmt.Appendf(nil, "order %d", i)))
+		}
+		msgs, err := sub.Fetch(context.Background(), 3)
+		require.NoError(t, err)
+		require.Len(t, msgs, 3)
+		msgs, err = sub.Fetch(context.Background(), 10)
+		require.NoError(t, err)
+		require.Len(t, msgs, 2)
+		require.Equal(t, "order 4", string(msgs[1].Data))
+	})
+	t.Run("fetch is bounded in bytes", func(t *testing.T) {
+		ps := openTestPubSub(t, t.TempDir())
+		defer ps.Close()
+		topic, err := ps.Topic("images")
+		require.NoError(t, err)
+		sub, err := topic.SubscribeWithConfig("thumbnails", SubscriptionConfig{MaxFetchBytes: 250})
+		require.NoError(t, err)
+		defer sub.Close()
+
+		for range 5 {
+			require.NoError(t, topic.PublishContext(context.Background(), make([]byte, 100)))
+		}
+		msgs, err := sub.Fetch(context.Background(), 10)
+		require.NoError(t, err)
+		require.Len(t, msgs, 2)
+		msgs, err = sub.Fetch(context.Background(), 10)
+		require.NoError(t, err)
+		require.Len(t, msgs, 2)
+	})
+	t.Run("cursors survive a restart", func(t *testing.T) {
+		dir := t.TempDir()
+		ps := openTestPubSub(t, dir)
+		topic, err := ps.Topic("orders")
+		require.NoError(t, err)
+		for i := range 5 {
+			require.NoError(t, topic.Publish(fmt.Appendf(nil, "order %d", i)))
+		}
+
+		sub, err := topic.Subscribe("billing")
+		require.NoError(t, err)
+		for range 2 {
+			_, err := sub.Next(context.Background())
+			require.NoError(t, err)
+		}
+		require.NoError(t, sub.Commit())
+		// Read but not committed
+		_, err = sub.Next(context.Background())
+		require.NoError(t, err)
+		require.NoError(t, ps.Close())
+
+		ps = openTestPubSub(t, dir)
+		defer ps.Close()
+		topic, err = ps.Topic("orders")
+		require.NoError(t, err)
+
+		sub, err = topic.Subscribe("billing")
+		require.NoError(t, err)
+		msg, err := sub.Next(context.Background())
+		require.NoError(t, err)
+		require.Equal(t, 2, msg.Offset)
+
+		// Other subscriptions have their own cursor
+		other, err := topic.Subscribe("shipping")
+		require.NoError(t, err)
+		msg, err = other.Next(context.Background())
+		require.NoError(t, err)
+		require.Equal(t, 0, msg.Offset)
+	})
+	t.Run("close wakes up waiting subscriptions", func(t *testing.T) {
+		ps := openTestPubSub(t, t.TempDir())
+		topic, err := ps.Topic("orders")
+		require.NoError(t, err)
+		sub, err := topic.Subscribe("billing")
+		require.NoError(t, err)
+
+		done := make(chan error)
+		go func() {
+			_, err := sub.Next(context.Background())
+			done <- err
+		}()
+		time.Sleep(10 * time.Millisecond)
+
+		require.NoError(t, ps.Close())
+		require.ErrorIs(t, <-done, ErrClosed)
+		require.ErrorIs(t, topic.Publish([]byte("order")), ErrClosed)
+		_, err = ps.Topic("orders")
+		require.ErrorIs(t, err, ErrClosed)
+	})
+	t.Run("invalid names", func(t *testing.T) {
+		ps := openTestPubSub(t, t.TempDir())
+		defer ps.Close()
+
+		_, err := ps.Topic("../orders")
+		require.Error(t, err)
+
+		topic, err := ps.Topic("orders")
+		require.NoError(t, err)
+		_, err = topic.Subscribe(filepath.Join("..", "billing"))
+		require.Error(t, err)
+	})
+	t.Run("seek", func(t *testing.T) {
+		ps := openTestPubSub(t, t.TempDir())
+		defer ps.Close()
+		topic, err := ps.Topic("orders")
+		require.NoError(t, err)
+		for i := range 3 {
+			require.NoError(t, topic.Publish(fmt.Appendf(nil, "order %d", i)))
+		}
+		require.Equal(t, 3, topic.EndOffset())
+
+		sub, err := topic.Subscribe("billing")
+		require.NoError(t, err)
+		defer sub.Close()
+		sub.Seek(2)
+		msg, err := sub.Next(context.Background())
+		require.NoError(t, err)
+		require.Equal(t, "order 2", string(msg.Data))
+	})
+	t.Run("list topics", func(t *testing.T) {
+		dir := t.TempDir()
+		ps := openTestPubSub(t, dir)
+		for _, name := range []string{"payments", "orders"} {
+			_, err := ps.Topic(name)
+			require.NoError(t, err)
+		}
+		require.NoError(t, ps.Close())
+
+		ps = openTestPubSub(t, dir)
+		defer ps.Close()
+		topics, err := ps.Topics()
+		require.NoError(t, err)
+		require.Equal(t, []string{"orders", "payments"}, topics)
+	})
+	t.Run("messages over the limit are refused", func(t *testing.T) {
+		config := storage.DefaultPartitionConfig()
+		config.MaxRecordBytes = 10
+		ps, err := OpenPubSub(storage.Paths{Data: t.TempDir()}, config)
+		require.NoError(t, err)
+		defer ps.Close()
+		topic, err := ps.Topic("orders")
+		require.NoError(t, err)
+
+		err = topic.Publish([]byte("way more than ten bytes"))
+		require.ErrorIs(t, err, ErrMessageTooLarge)
+		require.EqualError(t, err, "message too large: 23 bytes, at most 10")
+		require.NoError(t, topic.Publish([]byte("ten bytes!")))
+		require.Equal(t, 1, topic.EndOffset())
+	})
+}
@@ -0,0 +1,271 @@
+package brain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/mvaleed/brook/internal/storage"
+)
+
+// DefaultVisibilityTimeout is how long a message received from a queue stays
+// invisible when its topic doesn't say.
+const DefaultVisibilityTimeout = 30 * time.Second
+
+var ErrNotQueue = errors.New("topic is not a queue")
+
+// Delivery is a message received from a queue.
+type Delivery struct {
+	Message
+	// Deliveries counts the times the message was received, this time
+	// included.
+	Deliveries int
+}
+
+type queueKey struct {
+	group     string
+	topic     string
+	partition int
+}
+
+// queueState is what a consumer group received from a partition of a queue.
+// Every message before floor is acked, the ones after it are in acked once
+// they are, and in leases once received.
+type queueState struct {
+	mu     sync.Mutex
+	floor  int
+	acked  map[int]bool
+	leases map[int]*lease
+}
+
+// lease is a received message that isn't acked yet.
+type lease struct {
+	visibleAt  time.Time
+	deliveries int
+}
+
+// queues holds the state of every partition of a queue a group received
+// from. Only the floor of each is kept on disk, as the offset the group
+// committed.
+type queues struct {
+	mu     sync.Mutex
+	states map[queueKey]*queueState
+	now    func() time.Time
+}
+
+func newQueues() *queues {
+	return &queues{states: make(map[queueKey]*queueState), now: time.Now}
+}
+
+// queue returns the state of partition n of the queue called name for the
+// consumer group called group, starting from the offset the group committed.
+// It fails with ErrNotQueue if the topic isn't a queue.
+func (b *Broker) queue(group string, name string, n int) (*queueState, TopicConfig, *cursorStore, error) {
+	config, err := b.TopicConfig(name)
+	if err != nil {
+		return nil, TopicConfig{}, nil, err
+	}
+	if !config.Queue {
+		return nil, TopicConfig{}, nil, fmt.Errorf("%w: %s", ErrNotQueue, name)
+	}
+	if _, err := b.Partition(name, n); err != nil {
+		return nil, TopicConfig{}, nil, err
+	}
+	cursors, err := b.groupCursors(group, name)
+	if err != nil {
+		return nil, TopicConfig{}, nil, err
+	}
+
+	b.queues.mu.Lock()
+	defer b.queues.mu.Unlock()
+
+	key := queueKey{group: group, topic: name, partition: n}
+	if q, ok := b.queues.states[key]; ok {
+		return q, config, cursors, nil
+	}
+	floor, err := cursors.load(partitionName(name, n))
+	if err != nil {
+		return nil, TopicConfig{}, nil, err
+	}
+	q := &queueState{floor: floor, acked: make(map[int]bool), leases: make(map[int]*lease)}
+	b.queues.states[key] = q
+	return q, config, cursors, nil
+}
+
+// raiseFloor moves the floor of q to offset, forgetting what came before.
+// Caller must hold q.mu.
+func (q *queueState) raiseFloor(offset int) {
+	for ; q.floor < offset; q.floor++ {
+		delete(q.acked, q.floor)
+		delete(q.leases, q.floor)
+	}
+	for q.acked[q.floor] {
+		delete(q.acked, q.floor)
+		q.floor++
+	}
+}
+
+// Receive returns at most max messages of partition n of the queue called
+// name that the consumer group called group hasn't acked and that no member
+// of it received within the visibility timeout of the topic. They stay
+// invisible to the group for that long, and are received again unless acked
+// before. Expired messages are never received, they count as acked. It
+// doesn't wait for messages to be published, and takes the consume operation
+// on the topic. A topic that isn't a queue is an error matching ErrNotQueue.
+//
+// Only the offset before which every message is acked is kept on disk, as
+// the offset the group committed: a broker opened again delivers the messages
+// after it again, and counts their deliveries from zero.
+func (b *Broker) Receive(ctx context.Context, group string, name string, n int, max int) ([]Delivery, error) {
+	if err := b.authorize(ctx, name, OperationConsume); err != nil {
+		return nil, err
+	}
+	q, config, cursors, err := b.queue(group, name, n)
+	if err != nil {
+		return nil, err
+	}
+	client := ClientID(ctx)
+	if err := b.quotas.admit(client, name, quotaFetch); err != nil {
+		return nil, err
+	}
+	p, err := b.Partition(name, n)
+	if err != nil {
+		return nil, err
+	}
+	timeout := config.VisibilityTimeout
+	if timeout == 0 {
+		timeout = DefaultVisibilityTimeout
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	// Retention removed what came before
+	q.raiseFloor(p.FirstOffset())
+	floor := q.floor
+	now := b.queues.now()
+	var deliveries []Delivery
+	size := 0
+	for offset := q.floor; offset < p.NextOffset() && len(deliveries) < max; offset++ {
+		l := q.leases[offset]
+		if q.acked[offset] || (l != nil && now.Before(l.visibleAt)) {
+			continue
+		}
+		record, err := p.ReadContext(ctx, offset)
+		if errors.Is(err, storage.ErrRecordExpired) {
+			// Nobody can receive it anymore, it's done with like an acked
+			// one so the committed offset moves past it
+			delete(q.leases, offset)
+			q.acked[offset] = true
+			continue
+		}
+		if err != nil {
+			if len(deliveries) > 0 {
+				// What was received is returned, the error comes up again
+				// next time
+				break
+			}
+			return nil, err
+		}
+		if l == nil {
+			l = &lease{}
+			q.leases[offset] = l
+		}
+		l.deliveries++
+		l.visibleAt = now.Add(timeout)
+		deliveries = append(deliveries, Delivery{
+			Message: Message{
+				Offset:    offset,
+				Timestamp: time.Unix(0, int64(record.Header.Timestamp)),
+				Data:      record.Payload,
+			},
+			Deliveries: l.deliveries,
+		})
+		size += len(record.Payload)
+	}
+	q.raiseFloor(q.floor)
+	if q.floor != floor {
+		if err := cursors.store(partitionName(name, n), q.floor); err != nil {
+			return nil, err
+		}
+	}
+	b.quotas.charge(client, name, quotaFetch, size)
+	return deliveries, nil
+}
+
+// Ack reports that the consumer group called group is done with the message
+// at offset of partition n of the queue called name, which it won't receive
+// again. Once every message before an offset is acked, it is committed as
+// the offset of the group. Acking a message twice does nothing. It takes the
+// consume operation on the topic.
+func (b *Broker) Ack(ctx context.Context, group string, name string, n int, offset int) error {
+	if err := b.authorize(ctx, name, OperationConsume); err != nil {
+		return err
+	}
+	q, _, cursors, err := b.queue(group, name, n)
+	if err != nil {
+		return err
+	}
+	_, next, err := b.Offsets(name, n)
+	if err != nil {
+		return err
+	}
+	if offset < 0 || offset >= next {
+		return fmt.Errorf("%w: partition %d of topic %s ends at %d, can't ack %d", ErrOffsetOutOfRange, n, name, next, offset)
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if offset < q.floor {
+		return nil
+	}
+	delete(q.leases, offset)
+	q.acked[offset] = true
+	floor := q.floor
+	q.raiseFloor(floor)
+	if q.floor == floor {
+		return nil
+	}
+	return cursors.store(partitionName(name, n), q.floor)
+}
+
+// Nack reports that the consumer group called group failed to process the
+// message at offset of partition n of the queue called name, which it
+// receives again after delay instead of after the visibility timeout. It
+// takes the consume operation on the topic.
+func (b *Broker) Nack(ctx context.Context, group string, name string, n int, offset int, delay time.Duration) error {
+	if err := b.authorize(ctx, name, OperationConsume); err != nil {
+		return err
+	}
+	if delay < 0 {
+		return errors.New("nack delay can't be negative")
+	}
+	q, _, _, err := b.queue(group, name, n)
+	if err != nil {
+		return err
+	}
+	_, next, err := b.Offsets(name, n)
+	if err != nil {
+		return err
+	}
+	if offset < 0 || offset >= next {
+		return fmt.Errorf("%w: partition %d of topic %s ends at %d, can't nack %d", ErrOffsetOutOfRange, n, name, next, offset)
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if offset < q.floor || q.acked[offset] {
+		return fmt.Errorf("offset %d of partition %d of topic %s is already acked", offset, n, name)
+	}
+	l := q.leases[offset]
+	if l == nil {
+		l = &lease{}
+		q.leases[offset] = l
+	}
+	l.visibleAt = b.queues.now().Add(delay)
+	return nil
+}
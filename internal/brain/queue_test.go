@@ -0,0 +1,130 @@
+package brain
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/mvaleed/brook/internal/storage"
+)
+
+func offsets(deliveries []Delivery) []int {
+	var offs []int
+	for _, d := range deliveries {
+		offs = append(offs, d.Offset)
+	}
+	return offs
+}
+
+func TestBroker_Queue(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("redelivers what isn't acked in time", func(t *testing.T) {
+		paths := storage.Paths{Data: t.TempDir()}
+		b := openTestBroker(t, paths)
+		now := time.Now()
+		b.queues.now = func() time.Time { return now }
+		require.NoError(t, b.CreateTopic(ctx, "jobs", TopicConfig{Partitions: 1, Queue: true, VisibilityTimeout: time.Minute}))
+		for i := range 5 {
+			require.NoError(t, b.Produce(ctx, "jobs", 0, fmt.Appendf(nil, "job %d", i)))
+		}
+
+		deliveries, err := b.Receive(ctx, "workers", "jobs", 0, 2)
+		require.NoError(t, err)
+		require.Equal(t, []int{0, 1}, offsets(deliveries))
+		require.Equal(t, []byte("job 0"), deliveries[0].Data)
+		require.Equal(t, 1, deliveries[0].Deliveries)
+		// Received messages are invisible to the rest of the group, not to
+		// other groups
+		deliveries, err = b.Receive(ctx, "workers", "jobs", 0, 10)
+		require.NoError(t, err)
+		require.Equal(t, []int{2, 3, 4}, offsets(deliveries))
+		deliveries, err = b.Receive(ctx, "auditors", "jobs", 0, 1)
+		require.NoError(t, err)
+		require.Equal(t, []int{0}, offsets(deliveries))
+
+		require.NoError(t, b.Ack(ctx, "workers", "jobs", 0, 0))
+		require.NoError(t, b.Ack(ctx, "workers", "jobs", 0, 2))
+		require.NoError(t, b.Nack(ctx, "workers", "jobs", 0, 3, 0))
+		deliveries, err = b.Receive(ctx, "workers", "jobs", 0, 10)
+		require.NoError(t, err)
+		require.Equal(t, []int{3}, offsets(deliveries))
+		require.Equal(t, 2, deliveries[0].Deliveries)
+
+		now = now.Add(time.Minute)
+		deliveries, err = b.Receive(ctx, "workers", "jobs", 0, 10)
+		require.NoError(t, err)
+		require.Equal(t, []int{1, 3, 4}, offsets(deliveries))
+		require.Equal(t, []int{2, 3, 2}, []int{deliveries[0].Deliveries, deliveries[1].Deliveries, deliveries[2].Deliveries})
+
+		// The offset before which everything is acked is committed
+		offset, ok, err := b.CommittedOffset(ctx, "workers", "jobs", 0)
+		require.NoError(t, err)
+		require.True(t, ok)
+		require.Equal(t, 1, offset)
+		require.NoError(t, b.Ack(ctx, "workers", "jobs", 0, 1))
+		require.NoError(t, b.Ack(ctx, "workers", "jobs", 0, 1))
+		offset, _, err = b.CommittedOffset(ctx, "workers", "jobs", 0)
+		require.NoError(t, err)
+		require.Equal(t, 3, offset)
+		require.Error(t, b.Nack(ctx, "workers", "jobs", 0, 2, 0))
+		require.NoError(t, b.Close())
+
+		// What's after it is delivered again by a broker opened again
+		b = openTestBroker(t, paths)
+		defer b.Close()
+		deliveries, err = b.Receive(ctx, "workers", "jobs", 0, 10)
+		require.NoError(t, err)
+		require.Equal(t, []int{3, 4}, offsets(deliveries))
+		require.Equal(t, 1, deliveries[0].Deliveries)
+	})
+
+	t.Run("expired messages count as acked", func(t *testing.T) {
+		b := openTestBroker(t, storage.Paths{Data: t.TempDir()})
+		defer b.Close()
+		require.NoError(t, b.CreateTopic(ctx, "jobs", TopicConfig{Partitions: 1, Queue: true}))
+		p, err := b.Partition("jobs", 0)
+		require.NoError(t, err)
+		require.NoError(t, p.AppendWithTTL([]byte("job 0"), time.Millisecond))
+		require.NoError(t, p.AppendWithTTL([]byte("job 1"), time.Millisecond))
+		require.NoError(t, b.Produce(ctx, "jobs", 0, []byte("job 2")))
+		time.Sleep(5 * time.Millisecond)
+
+		deliveries, err := b.Receive(ctx, "workers", "jobs", 0, 10)
+		require.NoError(t, err)
+		require.Equal(t, []int{2}, offsets(deliveries))
+		offset, ok, err := b.CommittedOffset(ctx, "workers", "jobs", 0)
+		require.NoError(t, err)
+		require.True(t, ok)
+		require.Equal(t, 2, offset)
+
+		require.NoError(t, b.Ack(ctx, "workers", "jobs", 0, 2))
+		offset, _, err = b.CommittedOffset(ctx, "workers", "jobs", 0)
+		require.NoError(t, err)
+		require.Equal(t, 3, offset)
+	})
+
+	t.Run("only topics in queue mode", func(t *testing.T) {
+		b := openTestBroker(t, storage.Paths{Data: t.TempDir()})
+		defer b.Close()
+		require.NoError(t, b.CreateTopic(ctx, "orders", DefaultTopicConfig()))
+		require.NoError(t, b.Produce(ctx, "orders", 0, []byte("order")))
+
+		_, err := b.Receive(ctx, "billing", "orders", 0, 1)
+		require.ErrorIs(t, err, ErrNotQueue)
+		require.ErrorIs(t, b.Ack(ctx, "billing", "orders", 0, 0), ErrNotQueue)
+
+		queue := true
+		_, err = b.AlterTopicConfig(ctx, "orders", TopicConfigChange{Queue: &queue})
+		require.NoError(t, err)
+		deliveries, err := b.Receive(ctx, "billing", "orders", 0, 1)
+		require.NoError(t, err)
+		require.Equal(t, []int{0}, offsets(deliveries))
+		require.ErrorIs(t, b.Ack(ctx, "billing", "orders", 0, 1), ErrOffsetOutOfRange)
+		_, err = b.Receive(ctx, "billing", "payments", 0, 1)
+		require.ErrorIs(t, err, ErrUnknownTopic)
+	})
+}
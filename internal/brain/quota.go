@@ -0,0 +1,260 @@
+package brain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrThrottled = errors.New("throttled")
+
+// ThrottleError is returned for a produce or fetch over quota, before it
+// touches the disk. It matches ErrThrottled.
+type ThrottleError struct {
+	// Quota is the quota that ran out, like "client app-1" or "topic orders".
+	Quota string
+	// RetryAfter is how long to back off for the quota to allow requests
+	// again.
+	RetryAfter time.Duration
+}
+
+func (e *ThrottleError) Error() string {
+	return fmt.Sprintf("throttled by the quota of %s, retry after %s", e.Quota, e.RetryAfter)
+}
+
+func (e *ThrottleError) Is(target error) bool {
+	return target == ErrThrottled
+}
+
+// ByteRates caps how fast data is produced and fetched, in bytes per second.
+// Zero leaves a direction unlimited.
+type ByteRates struct {
+	ProduceBytesPerSecond int64
+	FetchBytesPerSecond   int64
+}
+
+// QuotaConfig caps the byte rates of every client, of every topic summed
+// over its clients, and of every namespace summed over its topics. A client
+// is told apart by the ID in the context of its requests, see WithClientID.
+type QuotaConfig struct {
+	// Client applies to each client without an override.
+	Client ByteRates
+	// Topic applies to each topic without an override.
+	Topic ByteRates
+	// Namespace applies to each namespace without an override. Topics
+	// outside namespaces are only limited by their own quota.
+	Namespace  ByteRates
+	Clients    map[string]ByteRates
+	Topics     map[string]ByteRates
+	Namespaces map[string]ByteRates
+}
+
+func (c QuotaConfig) validate() error {
+	rates := []ByteRates{c.Client, c.Topic, c.Namespace}
+	for _, r := range c.Clients {
+		rates = append(rates, r)
+	}
+	for _, r := range c.Topics {
+		rates = append(rates, r)
+	}
+	for _, r := range c.Namespaces {
+		rates = append(rates, r)
+	}
+	for _, r := range rates {
+		if r.ProduceBytesPerSecond < 0 || r.FetchBytesPerSecond < 0 {
+			return errors.New("quota byte rates can't be negative")
+		}
+	}
+	return nil
+}
+
+type clientIDKey struct{}
+
+// WithClientID returns a context carrying the ID of the client making
+// requests with it, which its quotas are kept under.
+func WithClientID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, clientIDKey{}, id)
+}
+
+// ClientID returns the client ID carried by ctx, "" if there is none.
+func ClientID(ctx context.Context) string {
+	id, _ := ctx.Value(clientIDKey{}).(string)
+	return id
+}
+
+// tokenBucket holds up to a second worth of bytes at its rate. Requests may
+// take it into debt, and nothing more goes through until the debt is paid
+// back: a single large request isn't refused, but it still counts.
+type tokenBucket struct {
+	quota  string
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(quota string, rate int64, now time.Time) *tokenBucket {
+	return &tokenBucket{quota: quota, rate: float64(rate), tokens: float64(rate), last: now}
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	b.tokens = min(b.rate, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+}
+
+// debt returns how long until the bucket is out of debt, zero if it isn't.
+func (b *tokenBucket) debt(now time.Time) time.Duration {
+	b.refill(now)
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+type quotaDirection int
+
+const (
+	quotaProduce quotaDirection = iota
+	quotaFetch
+)
+
+type quotaKey struct {
+	quota     string // "client <id>", "topic <name>" or "namespace <name>"
+	direction quotaDirection
+}
+
+// quotas tracks the token buckets of the broker's quotas, created for each
+// client, topic and namespace the first time it shows up.
+type quotas struct {
+	config QuotaConfig
+	now    func() time.Time
+
+	mu    sync.Mutex
+	state map[quotaKey]*tokenBucket
+}
+
+func newQuotas(config QuotaConfig) *quotas {
+	return &quotas{
+		config: config,
+		now:    time.Now,
+		state:  make(map[quotaKey]*tokenBucket),
+	}
+}
+
+type namedRates struct {
+	quota string
+	rates ByteRates
+}
+
+// rates returns the byte rates of client, of topic and of its namespace if
+// it has one.
+func (q *quotas) rates(client string, topic string) []namedRates {
+	clientRates, ok := q.config.Clients[client]
+	if !ok {
+		clientRates = q.config.Client
+	}
+	topicRates, ok := q.config.Topics[topic]
+	if !ok {
+		topicRates = q.config.Topic
+	}
+	rates := []namedRates{
+		{"client " + client, clientRates},
+		{"topic " + topic, topicRates},
+	}
+	if namespace, _ := SplitTopicName(topic); namespace != "" {
+		rates = append(rates, namedRates{"namespace " + namespace, q.namespaceRates(namespace)})
+	}
+	return rates
+}
+
+func (q *quotas) namespaceRates(namespace string) ByteRates {
+	if r, ok := q.config.Namespaces[namespace]; ok {
+		return r
+	}
+	return q.config.Namespace
+}
+
+// buckets returns the token buckets of the limited quotas of client, topic
+// and its namespace.
+// Caller must hold q.mu.
+func (q *quotas) buckets(client string, topic string, direction quotaDirection, now time.Time) []*tokenBucket {
+	var buckets []*tokenBucket
+	for _, r := range q.rates(client, topic) {
+		rate := r.rates.ProduceBytesPerSecond
+		if direction == quotaFetch {
+			rate = r.rates.FetchBytesPerSecond
+		}
+		if rate == 0 {
+			continue
+		}
+
+		key := quotaKey{quota: r.quota, direction: direction}
+		b, ok := q.state[key]
+		if !ok {
+			b = newTokenBucket(r.quota, rate, now)
+			q.state[key] = b
+		}
+		buckets = append(buckets, b)
+	}
+	return buckets
+}
+
+// admit returns a ThrottleError if a quota of client, topic or its namespace
+// is in debt.
+func (q *quotas) admit(client string, topic string, direction quotaDirection) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	now := q.now()
+	for _, b := range q.buckets(client, topic, direction, now) {
+		if wait := b.debt(now); wait > 0 {
+			return &ThrottleError{Quota: b.quota, RetryAfter: wait}
+		}
+	}
+	return nil
+}
+
+// charge takes n bytes from the quotas of client, topic and its namespace.
+func (q *quotas) charge(client string, topic string, direction quotaDirection, n int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	now := q.now()
+	for _, b := range q.buckets(client, topic, direction, now) {
+		b.refill(now)
+		b.tokens -= float64(n)
+	}
+}
+
+// reconfigure replaces the config of the quotas. The buckets of the quotas
+// still limited keep their debt at their new rate, the others are dropped.
+func (q *quotas) reconfigure(config QuotaConfig) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.config = config
+	now := q.now()
+	for key, b := range q.state {
+		var r ByteRates
+		if id, ok := strings.CutPrefix(key.quota, "client "); ok {
+			r = q.rates(id, "")[0].rates
+		} else if namespace, ok := strings.CutPrefix(key.quota, "namespace "); ok {
+			r = q.namespaceRates(namespace)
+		} else {
+			r = q.rates("", strings.TrimPrefix(key.quota, "topic "))[1].rates
+		}
+		rate := r.ProduceBytesPerSecond
+		if key.direction == quotaFetch {
+			rate = r.FetchBytesPerSecond
+		}
+		if rate == 0 {
+			delete(q.state, key)
+			continue
+		}
+		b.refill(now)
+		b.rate = float64(rate)
+		b.tokens = min(b.tokens, b.rate)
+	}
+}
@@ -0,0 +1,114 @@
+package brain
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/mvaleed/brook/internal/storage"
+)
+
+func TestQuotas(t *testing.T) {
+	openQuotaBroker := func(t *testing.T, quotas QuotaConfig) (*Broker, *time.Time) {
+		t.Helper()
+		config := DefaultBrokerConfig()
+		config.Quotas = quotas
+		b, err := OpenBroker(storage.Paths{Data: t.TempDir()}, config)
+		require.NoError(t, err)
+		t.Cleanup(func() { b.Close() })
+		require.NoError(t, b.CreateTopic(context.Background(), "orders", DefaultTopicConfig()))
+		require.NoError(t, b.CreateTopic(context.Background(), "events", DefaultTopicConfig()))
+
+		now := time.Now()
+		b.quotas.now = func() time.Time { return now }
+		return b, &now
+	}
+
+	t.Run("produce is throttled per client", func(t *testing.T) {
+		b, now := openQuotaBroker(t, QuotaConfig{
+			Client:  ByteRates{ProduceBytesPerSecond: 1000},
+			Clients: map[string]ByteRates{"importer": {}},
+		})
+		app := WithClientID(context.Background(), "app")
+		other := WithClientID(context.Background(), "other")
+		data := bytes.Repeat([]byte("x"), 600)
+
+		// The second one goes into debt, the third waits for it to be paid
+		require.NoError(t, b.Produce(app, "orders", 0, data))
+		require.NoError(t, b.Produce(app, "orders", 0, data))
+		err := b.Produce(app, "orders", 0, data)
+		require.ErrorIs(t, err, ErrThrottled)
+		var throttled *ThrottleError
+		require.True(t, errors.As(err, &throttled))
+		require.Equal(t, "client app", throttled.Quota)
+		require.Equal(t, 200*time.Millisecond, throttled.RetryAfter)
+
+		// The throttled produce didn't write anything
+		p, err := b.Partition("orders", 0)
+		require.NoError(t, err)
+		require.Equal(t, 2, p.NextOffset())
+
+		// Other clients have their own quota, and the importer none at all
+		require.NoError(t, b.Produce(other, "orders", 0, data))
+		for range 10 {
+			require.NoError(t, b.Produce(WithClientID(context.Background(), "importer"), "orders", 0, data))
+		}
+
+		*now = now.Add(throttled.RetryAfter)
+		require.NoError(t, b.Produce(app, "orders", 0, data))
+	})
+	t.Run("topics are throttled across clients", func(t *testing.T) {
+		b, _ := openQuotaBroker(t, QuotaConfig{
+			Topics: map[string]ByteRates{"orders": {ProduceBytesPerSecond: 100}},
+		})
+		data := bytes.Repeat([]byte("x"), 150)
+
+		require.NoError(t, b.Produce(WithClientID(context.Background(), "a"), "orders", 0, data))
+		err := b.Produce(WithClientID(context.Background(), "b"), "orders", 0, data)
+		var throttled *ThrottleError
+		require.True(t, errors.As(err, &throttled))
+		require.Equal(t, "topic orders", throttled.Quota)
+
+		// Other topics aren't limited
+		require.NoError(t, b.Produce(context.Background(), "events", 0, data))
+		require.NoError(t, b.Produce(context.Background(), "events", 0, data))
+	})
+	t.Run("fetch is throttled by the bytes returned", func(t *testing.T) {
+		b, now := openQuotaBroker(t, QuotaConfig{
+			Client: ByteRates{FetchBytesPerSecond: 100},
+		})
+		for range 5 {
+			require.NoError(t, b.Produce(context.Background(), "orders", 0, bytes.Repeat([]byte("x"), 50)))
+		}
+
+		ctx := WithClientID(context.Background(), "reader")
+		msgs, err := b.Fetch(ctx, "orders", 0, 0, 150)
+		require.NoError(t, err)
+		require.Len(t, msgs, 3)
+		require.Equal(t, 2, msgs[2].Offset)
+
+		_, err = b.Fetch(ctx, "orders", 0, 3, 150)
+		require.ErrorIs(t, err, ErrThrottled)
+
+		*now = now.Add(500 * time.Millisecond)
+		msgs, err = b.Fetch(ctx, "orders", 0, 3, 150)
+		require.NoError(t, err)
+		require.Len(t, msgs, 2)
+
+		// Nothing left
+		*now = now.Add(time.Second)
+		msgs, err = b.Fetch(ctx, "orders", 0, 5, 150)
+		require.NoError(t, err)
+		require.Empty(t, msgs)
+	})
+	t.Run("negative rates are rejected", func(t *testing.T) {
+		config := DefaultBrokerConfig()
+		config.Quotas.Clients = map[string]ByteRates{"app": {FetchBytesPerSecond: -1}}
+		_, err := OpenBroker(storage.Paths{Data: t.TempDir()}, config)
+		require.Error(t, err)
+	})
+}
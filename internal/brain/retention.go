@@ -0,0 +1,64 @@
+package brain
+
+import (
+	"time"
+
+	"github.com/mvaleed/brook/internal/storage"
+)
+
+// DefaultRetentionCheckInterval is how often the broker deletes the segments
+// past the retention of their topic, unless configured otherwise.
+const DefaultRetentionCheckInterval = 5 * time.Minute
+
+// enforceRetention deletes, every interval until the broker is closed, the
+// segments of every partition whose records are all older than the retention
+// of their topic. The retention is read again on every pass, so a topic's
+// new retention applies from the next one.
+func (b *Broker) enforceRetention(interval time.Duration) {
+	defer close(b.retentionDone)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			b.deleteExpiredSegments(time.Now())
+		case <-b.stopRetention:
+			return
+		}
+	}
+}
+
+// deleteExpiredSegments runs a retention pass at now.
+func (b *Broker) deleteExpiredSegments(now time.Time) {
+	b.mu.RLock()
+	if b.closed {
+		b.mu.RUnlock()
+		return
+	}
+	type target struct {
+		topic      string
+		retention  time.Duration
+		partitions []*storage.Partition
+	}
+	var targets []target
+	for name, topic := range b.metadata.Topics {
+		if topic.RetentionMs > 0 {
+			targets = append(targets, target{name, topic.config().Retention, b.topics[name]})
+		}
+	}
+	b.mu.RUnlock()
+
+	for _, t := range targets {
+		for n, p := range t.partitions {
+			deleted, err := p.DeleteSegmentsBefore(now.Add(-t.retention))
+			if err != nil {
+				b.logger.Warn("failed to enforce retention", "topic", t.topic, "partition", n, "err", err)
+				continue
+			}
+			if deleted > 0 {
+				b.logger.Info("deleted expired records", "topic", t.topic, "partition", n, "records", deleted, "retention", t.retention)
+			}
+		}
+	}
+}
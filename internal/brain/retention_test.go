@@ -0,0 +1,81 @@
+package brain
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/mvaleed/brook/internal/storage"
+)
+
+func TestBroker_Retention(t *testing.T) {
+	openRetentionBroker := func(t *testing.T, interval time.Duration) *Broker {
+		t.Helper()
+		config := DefaultBrokerConfig()
+		config.Partition.MaxSegmentRecords = 10
+		config.RetentionCheckInterval = interval
+		b, err := OpenBroker(storage.Paths{Data: t.TempDir()}, config)
+		require.NoError(t, err)
+		t.Cleanup(func() { b.Close() })
+		return b
+	}
+	ctx := context.Background()
+
+	t.Run("deletes the expired segments", func(t *testing.T) {
+		b := openRetentionBroker(t, 0)
+		require.NoError(t, b.CreateTopic(ctx, "orders", TopicConfig{Partitions: 1, Retention: time.Hour}))
+		require.NoError(t, b.CreateTopic(ctx, "ledger", TopicConfig{Partitions: 1}))
+		for range 25 {
+			require.NoError(t, b.Produce(ctx, "orders", 0, []byte("order")))
+			require.NoError(t, b.Produce(ctx, "ledger", 0, []byte("entry")))
+		}
+		orders, err := b.Partition("orders", 0)
+		require.NoError(t, err)
+		ledger, err := b.Partition("ledger", 0)
+		require.NoError(t, err)
+
+		b.deleteExpiredSegments(time.Now())
+		require.Equal(t, 0, orders.FirstOffset())
+
+		// The active segment stays, and topics without retention keep
+		// everything
+		b.deleteExpiredSegments(time.Now().Add(2 * time.Hour))
+		require.Equal(t, 20, orders.FirstOffset())
+		require.Equal(t, 0, ledger.FirstOffset())
+	})
+
+	t.Run("altered retention applies to the next pass", func(t *testing.T) {
+		b := openRetentionBroker(t, 0)
+		require.NoError(t, b.CreateTopic(ctx, "orders", TopicConfig{Partitions: 1}))
+		for range 25 {
+			require.NoError(t, b.Produce(ctx, "orders", 0, []byte("order")))
+		}
+		p, err := b.Partition("orders", 0)
+		require.NoError(t, err)
+
+		b.deleteExpiredSegments(time.Now().Add(2 * time.Hour))
+		require.Equal(t, 0, p.FirstOffset())
+
+		retention := time.Hour
+		_, err = b.AlterTopicConfig(ctx, "orders", TopicConfigChange{Retention: &retention})
+		require.NoError(t, err)
+		b.deleteExpiredSegments(time.Now().Add(2 * time.Hour))
+		require.Equal(t, 20, p.FirstOffset())
+	})
+
+	t.Run("worker runs until close", func(t *testing.T) {
+		b := openRetentionBroker(t, 10*time.Millisecond)
+		require.NoError(t, b.CreateTopic(ctx, "orders", TopicConfig{Partitions: 1, Retention: time.Millisecond}))
+		for range 25 {
+			require.NoError(t, b.Produce(ctx, "orders", 0, []byte("order")))
+		}
+		p, err := b.Partition("orders", 0)
+		require.NoError(t, err)
+
+		require.Eventually(t, func() bool { return p.FirstOffset() == 20 }, 5*time.Second, 10*time.Millisecond)
+		require.NoError(t, b.Close())
+		require.NoError(t, b.Close())
+	})
+}
@@ -0,0 +1,266 @@
+package brain
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/mvaleed/brook/internal/storage"
+)
+
+var (
+	ErrUnknownSchema  = errors.New("unknown schema")
+	ErrInvalidPayload = errors.New("payload doesn't match the topic's schema")
+)
+
+// SchemaType is the format a schema is written in.
+type SchemaType string
+
+const (
+	SchemaJSON     SchemaType = "JSON"
+	SchemaAvro     SchemaType = "AVRO"
+	SchemaProtobuf SchemaType = "PROTOBUF"
+)
+
+// Schema is a version of the schema of a subject. The subject of the
+// messages of a topic is the topic's name.
+type Schema struct {
+	// ID identifies the schema across subjects, it is what payloads are
+	// framed with.
+	ID      int        `json:"id"`
+	Subject string     `json:"subject"`
+	Version int        `json:"version"`
+	Type    SchemaType `json:"type"`
+	Schema  string     `json:"schema"`
+}
+
+// schemasTopic is the internal topic schemas are kept in, created with the
+// first one.
+const schemasTopic = "_schemas"
+
+// Payloads are framed with the ID of their schema like Confluent's wire
+// format: a zero byte, then the ID as a big endian uint32.
+const (
+	schemaMagic       = 0
+	schemaFrameLength = 5
+)
+
+// EncodeSchemaID frames payload with the ID of the schema it's written with.
+func EncodeSchemaID(id int, payload []byte) []byte {
+	framed := make([]byte, 0, schemaFrameLength+len(payload))
+	framed = append(framed, schemaMagic)
+	framed = binary.BigEndian.AppendUint32(framed, uint32(id))
+	return append(framed, payload...)
+}
+
+// DecodeSchemaID returns the schema ID a payload is framed with, and the
+// payload.
+func DecodeSchemaID(data []byte) (int, []byte, error) {
+	if len(data) < schemaFrameLength || data[0] != schemaMagic {
+		return 0, nil, errors.New("payload isn't framed with a schema id")
+	}
+	return int(binary.BigEndian.Uint32(data[1:])), data[schemaFrameLength:], nil
+}
+
+// registeredSchema is a schema with what validates payloads against it.
+type registeredSchema struct {
+	Schema
+	json *jsonSchema // for SchemaJSON only
+}
+
+func compileSchema(s Schema) (*registeredSchema, error) {
+	r := &registeredSchema{Schema: s}
+	switch s.Type {
+	case SchemaJSON:
+		var err error
+		if r.json, err = parseJSONSchema([]byte(s.Schema)); err != nil {
+			return nil, fmt.Errorf("invalid json schema: %w", err)
+		}
+	case SchemaAvro:
+		// Avro schemas are JSON, payloads aren't decoded though
+		if !json.Valid([]byte(s.Schema)) {
+			return nil, errors.New("invalid avro schema: not json")
+		}
+	case SchemaProtobuf:
+		if s.Schema == "" {
+			return nil, errors.New("empty protobuf schema")
+		}
+	default:
+		return nil, fmt.Errorf("unknown schema type %q", s.Type)
+	}
+	return r, nil
+}
+
+// schemaRegistry holds the schemas, loaded from the schemas topic.
+type schemaRegistry struct {
+	mu       sync.RWMutex
+	byID     map[int]*registeredSchema
+	subjects map[string][]*registeredSchema // by version
+}
+
+func newSchemaRegistry() *schemaRegistry {
+	return &schemaRegistry{
+		byID:     make(map[int]*registeredSchema),
+		subjects: make(map[string][]*registeredSchema),
+	}
+}
+
+// load reads every schema in p.
+func (r *schemaRegistry) load(p *storage.Partition) error {
+	for offset := p.FirstOffset(); offset < p.NextOffset(); offset++ {
+		record, err := p.Read(offset)
+		if err != nil {
+			return fmt.Errorf("offset %d of %s: %w", offset, schemasTopic, err)
+		}
+		var s Schema
+		if err := json.Unmarshal(record.Payload, &s); err != nil {
+			return fmt.Errorf("offset %d of %s: %w", offset, schemasTopic, err)
+		}
+		compiled, err := compileSchema(s)
+		if err != nil {
+			return fmt.Errorf("schema %d: %w", s.ID, err)
+		}
+		r.add(compiled)
+	}
+	return nil
+}
+
+// Caller must hold r.mu.
+func (r *schemaRegistry) add(s *registeredSchema) {
+	r.byID[s.ID] = s
+	r.subjects[s.Subject] = append(r.subjects[s.Subject], s)
+}
+
+// validate checks that data is framed with a schema of the subject and, for
+// JSON schemas, that it matches. Subjects without a schema take anything.
+func (r *schemaRegistry) validate(subject string, data []byte) error {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if len(r.subjects[subject]) == 0 {
+		return nil
+	}
+	id, payload, err := DecodeSchemaID(data)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidPayload, err)
+	}
+	s, ok := r.byID[id]
+	if !ok || s.Subject != subject {
+		return fmt.Errorf("%w: schema %d isn't one of topic %s", ErrInvalidPayload, id, subject)
+	}
+	if s.json == nil {
+		return nil
+	}
+
+	var value any
+	if err := json.Unmarshal(payload, &value); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidPayload, err)
+	}
+	if err := s.json.validate(value, ""); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidPayload, err)
+	}
+	return nil
+}
+
+// RegisterSchema registers schema as the latest version of the schema of
+// subject and returns it, or returns the version it's already registered as.
+// It needs the admin operation on the topic called subject. Once a topic has
+// a schema and the broker validates schemas, Produce only takes payloads
+// framed with one of the topic's schema IDs, see EncodeSchemaID.
+func (b *Broker) RegisterSchema(ctx context.Context, subject string, schemaType SchemaType, schema string) (Schema, error) {
+	if err := b.authorize(ctx, subject, OperationAdmin); err != nil {
+		return Schema{}, err
+	}
+	if err := validateTopicName(subject); err != nil {
+		return Schema{}, err
+	}
+
+	r := b.schemas
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	versions := r.subjects[subject]
+	for _, s := range versions {
+		if s.Type == schemaType && s.Schema.Schema == schema {
+			return s.Schema, nil
+		}
+	}
+	compiled, err := compileSchema(Schema{
+		ID:      len(r.byID) + 1,
+		Subject: subject,
+		Version: len(versions) + 1,
+		Type:    schemaType,
+		Schema:  schema,
+	})
+	if err != nil {
+		return Schema{}, err
+	}
+
+	p, err := b.schemasPartition()
+	if err != nil {
+		return Schema{}, err
+	}
+	data, err := json.Marshal(compiled.Schema)
+	if err != nil {
+		return Schema{}, err
+	}
+	if err := p.AppendContext(ctx, data); err != nil {
+		return Schema{}, err
+	}
+	if err := p.Sync(); err != nil {
+		return Schema{}, err
+	}
+	r.add(compiled)
+	b.logger.Info("registered schema",
+		"subject", subject, "version", compiled.Version, "id", compiled.ID, "type", schemaType)
+	return compiled.Schema, nil
+}
+
+// schemasPartition returns the partition of the schemas topic, creating it
+// the first time.
+func (b *Broker) schemasPartition() (*storage.Partition, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return nil, ErrClosed
+	}
+	if partitions, ok := b.topics[schemasTopic]; ok {
+		return partitions[0], nil
+	}
+	config := DefaultTopicConfig()
+	config.Durability = storage.DurabilityFull
+	config.Compact = true
+	partitions, err := b.createTopic(schemasTopic, config)
+	if err != nil {
+		return nil, err
+	}
+	return partitions[0], nil
+}
+
+// SchemaByID returns the schema with the given ID.
+func (b *Broker) SchemaByID(id int) (Schema, error) {
+	b.schemas.mu.RLock()
+	defer b.schemas.mu.RUnlock()
+
+	s, ok := b.schemas.byID[id]
+	if !ok {
+		return Schema{}, fmt.Errorf("%w: %d", ErrUnknownSchema, id)
+	}
+	return s.Schema, nil
+}
+
+// LatestSchema returns the latest version of the schema of subject.
+func (b *Broker) LatestSchema(subject string) (Schema, error) {
+	b.schemas.mu.RLock()
+	defer b.schemas.mu.RUnlock()
+
+	versions := b.schemas.subjects[subject]
+	if len(versions) == 0 {
+		return Schema{}, fmt.Errorf("%w: no schema for subject %s", ErrUnknownSchema, subject)
+	}
+	return versions[len(versions)-1].Schema, nil
+}
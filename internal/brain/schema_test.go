@@ -0,0 +1,101 @@
+package brain
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/mvaleed/brook/internal/storage"
+)
+
+const orderSchema = `{
+	"type": "object",
+	"properties": {
+		"id": {"type": "integer", "minimum": 1},
+		"status": {"enum": ["new", "paid"]}
+	},
+	"required": ["id"]
+}`
+
+func TestBroker_Schemas(t *testing.T) {
+	ctx := context.Background()
+	paths := storage.Paths{Data: t.TempDir()}
+	config := DefaultBrokerConfig()
+	config.ValidateSchemas = true
+	b, err := OpenBroker(paths, config)
+	require.NoError(t, err)
+	require.NoError(t, b.CreateTopic(ctx, "orders", DefaultTopicConfig()))
+	require.NoError(t, b.CreateTopic(ctx, "events", DefaultTopicConfig()))
+
+	// Anything goes before the topic has a schema
+	require.NoError(t, b.Produce(ctx, "orders", 0, []byte("not json")))
+
+	v1, err := b.RegisterSchema(ctx, "orders", SchemaJSON, orderSchema)
+	require.NoError(t, err)
+	require.Equal(t, Schema{ID: 1, Subject: "orders", Version: 1, Type: SchemaJSON, Schema: orderSchema}, v1)
+	again, err := b.RegisterSchema(ctx, "orders", SchemaJSON, orderSchema)
+	require.NoError(t, err)
+	require.Equal(t, v1, again)
+	events, err := b.RegisterSchema(ctx, "events", SchemaAvro, `{"type": "string"}`)
+	require.NoError(t, err)
+	require.Equal(t, 2, events.ID)
+	v2, err := b.RegisterSchema(ctx, "orders", SchemaJSON, `{"type": "object"}`)
+	require.NoError(t, err)
+	require.Equal(t, 3, v2.ID)
+	require.Equal(t, 2, v2.Version)
+
+	_, err = b.RegisterSchema(ctx, "orders", SchemaJSON, `{"type": "thing"}`)
+	require.Error(t, err)
+	_, err = b.RegisterSchema(ctx, "orders", SchemaAvro, `{`)
+	require.Error(t, err)
+	_, err = b.RegisterSchema(ctx, "orders", "XML", `<a/>`)
+	require.Error(t, err)
+
+	require.NoError(t, b.Produce(ctx, "orders", 0, EncodeSchemaID(1, []byte(`{"id": 7, "status": "paid"}`))))
+	require.NoError(t, b.Produce(ctx, "orders", 0, EncodeSchemaID(3, []byte(`{}`))))
+	for _, payload := range [][]byte{
+		[]byte(`{"id": 7}`),                                 // not framed
+		EncodeSchemaID(2, []byte(`{"id": 7}`)),              // another subject's schema
+		EncodeSchemaID(9, []byte(`{"id": 7}`)),              // unknown
+		EncodeSchemaID(1, []byte(`{"id": 0}`)),              // under the minimum
+		EncodeSchemaID(1, []byte(`{"status": "paid"}`)),     // missing id
+		EncodeSchemaID(1, []byte(`{"id": 1, "status": 3}`)), // not in the enum
+		EncodeSchemaID(1, []byte(`{"id": `)),                // not json
+	} {
+		require.ErrorIs(t, b.Produce(ctx, "orders", 0, payload), ErrInvalidPayload, "%q", payload)
+	}
+	// Only framing is checked for Avro
+	require.NoError(t, b.Produce(ctx, "events", 0, EncodeSchemaID(2, []byte{0x02, 'a'})))
+	require.NoError(t, b.Close())
+
+	// The schemas survive a restart
+	b, err = OpenBroker(paths, config)
+	require.NoError(t, err)
+	defer b.Close()
+	latest, err := b.LatestSchema("orders")
+	require.NoError(t, err)
+	require.Equal(t, v2, latest)
+	s, err := b.SchemaByID(2)
+	require.NoError(t, err)
+	require.Equal(t, events, s)
+	_, err = b.SchemaByID(4)
+	require.ErrorIs(t, err, ErrUnknownSchema)
+	_, err = b.LatestSchema("payments")
+	require.ErrorIs(t, err, ErrUnknownSchema)
+	require.ErrorIs(t, b.Produce(ctx, "orders", 0, []byte(`{"id": 7}`)), ErrInvalidPayload)
+}
+
+func TestSchemaID(t *testing.T) {
+	framed := EncodeSchemaID(258, []byte("payload"))
+	require.Equal(t, []byte{0, 0, 0, 1, 2}, framed[:5])
+	id, payload, err := DecodeSchemaID(framed)
+	require.NoError(t, err)
+	require.Equal(t, 258, id)
+	require.Equal(t, "payload", string(payload))
+
+	_, _, err = DecodeSchemaID([]byte{0, 0, 1})
+	require.Error(t, err)
+	_, _, err = DecodeSchemaID([]byte{1, 0, 0, 0, 1})
+	require.Error(t, err)
+}
@@ -0,0 +1,38 @@
+package brain
+
+import (
+	"fmt"
+
+	"github.com/mvaleed/brook/internal/storage"
+)
+
+// MessageTooLargeError is returned for a message over the size limit of its
+// topic, before it's appended. It matches ErrMessageTooLarge.
+type MessageTooLargeError struct {
+	Topic string
+	// Size is the size of the message in bytes, as produced or once
+	// recompressed to the topic's codec. For a message that decompresses
+	// past the limit it's how much of it was decompressed.
+	Size  int64
+	Limit int64
+}
+
+func (e *MessageTooLargeError) Error() string {
+	return fmt.Sprintf("message of %d bytes to topic %s is over the limit of %d bytes", e.Size, e.Topic, e.Limit)
+}
+
+func (e *MessageTooLargeError) Is(target error) bool {
+	return target == ErrMessageTooLarge
+}
+
+// maxMessageBytes returns how large a message of the topic of c may be, with
+// base as the config of its partitions.
+func (c TopicConfig) maxMessageBytes(base storage.PartitionConfig) int64 {
+	if c.MaxMessageBytes > 0 {
+		return c.MaxMessageBytes
+	}
+	if base.MaxRecordBytes > 0 {
+		return base.MaxRecordBytes
+	}
+	return storage.DefaultMaxRecordBytes
+}
@@ -0,0 +1,49 @@
+package brain
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/mvaleed/brook/internal/storage"
+)
+
+func TestBroker_MaxMessageBytes(t *testing.T) {
+	ctx := context.Background()
+	config := DefaultBrokerConfig()
+	config.Partition.MaxRecordBytes = 64
+	b, err := OpenBroker(storage.Paths{Data: t.TempDir()}, config)
+	require.NoError(t, err)
+	defer b.Close()
+	require.NoError(t, b.CreateTopic(ctx, "small", TopicConfig{Partitions: 1, MaxMessageBytes: 16}))
+	require.NoError(t, b.CreateTopic(ctx, "large", TopicConfig{Partitions: 1, MaxMessageBytes: 4096}))
+	require.NoError(t, b.CreateTopic(ctx, "default", TopicConfig{Partitions: 1}))
+
+	require.NoError(t, b.Produce(ctx, "small", 0, bytes.Repeat([]byte("a"), 16)))
+	err = b.Produce(ctx, "small", 0, bytes.Repeat([]byte("a"), 17))
+	require.ErrorIs(t, err, ErrMessageTooLarge)
+	var tooLarge *MessageTooLargeError
+	require.ErrorAs(t, err, &tooLarge)
+	require.Equal(t, MessageTooLargeError{Topic: "small", Size: 17, Limit: 16}, *tooLarge)
+
+	// Above the broker's MaxRecordBytes for the topic that raised it
+	require.NoError(t, b.Produce(ctx, "large", 0, bytes.Repeat([]byte("a"), 4096)))
+	require.ErrorIs(t, b.Produce(ctx, "default", 0, bytes.Repeat([]byte("a"), 65)), ErrMessageTooLarge)
+
+	// A small message decompressing past the limit isn't inflated in memory
+	bomb, err := CompressionGzip.Compress(make([]byte, 1<<20))
+	require.NoError(t, err)
+	require.Less(t, len(bomb), 4096)
+	err = b.ProduceCompressed(ctx, "large", 0, bomb, time.Time{}, CompressionGzip)
+	require.ErrorAs(t, err, &tooLarge)
+	require.Equal(t, MessageTooLargeError{Topic: "large", Size: 4097, Limit: 4096}, *tooLarge)
+
+	msgs, err := b.Fetch(ctx, "small", 0, 0, 1<<20)
+	require.NoError(t, err)
+	require.Len(t, msgs, 1)
+
+	require.Error(t, b.CreateTopic(ctx, "negative", TopicConfig{Partitions: 1, MaxMessageBytes: -1}))
+}
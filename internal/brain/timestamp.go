@@ -0,0 +1,54 @@
+package brain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// DefaultMaxTimestampSkew is how far the timestamp of a message may be from
+// the broker's clock in a topic with TimestampCreateTime, unless its config
+// says otherwise.
+const DefaultMaxTimestampSkew = time.Hour
+
+var ErrInvalidTimestamp = errors.New("invalid timestamp")
+
+// TimestampType is where the timestamps of a topic's messages come from.
+type TimestampType string
+
+const (
+	// TimestampLogAppendTime stamps every message with the time the broker
+	// appends it, so timestamps go up with offsets.
+	TimestampLogAppendTime TimestampType = "log_append_time"
+	// TimestampCreateTime keeps the timestamp its producer gives a message,
+	// see Broker.ProduceAt, as long as it's within the topic's
+	// MaxTimestampSkew of the broker's clock. Timestamps needn't go up with
+	// offsets then.
+	TimestampCreateTime TimestampType = "create_time"
+)
+
+// messageTimestamp returns the timestamp a message produced at timestamp
+// gets in the topic of c, zero for the time it is appended. A timestamp too
+// far from now is an error matching ErrInvalidTimestamp.
+func (c TopicConfig) messageTimestamp(timestamp time.Time, now time.Time) (time.Time, error) {
+	if c.TimestampType != TimestampCreateTime || timestamp.IsZero() {
+		return time.Time{}, nil
+	}
+	skew := c.MaxTimestampSkew
+	if skew == 0 {
+		skew = DefaultMaxTimestampSkew
+	}
+	if d := timestamp.Sub(now).Abs(); d > skew {
+		return time.Time{}, fmt.Errorf("%w: %s is %s away from the broker's clock, more than the %s allowed", ErrInvalidTimestamp, timestamp.Format(time.RFC3339Nano), d, skew)
+	}
+	return timestamp, nil
+}
+
+// ProduceAt is Produce for a message its producer created at timestamp. A
+// topic with TimestampCreateTime keeps it as the timestamp of the message,
+// other topics stamp the message with the time it is appended like Produce
+// does. A zero timestamp is the time of the append either way.
+func (b *Broker) ProduceAt(ctx context.Context, name string, n int, data []byte, timestamp time.Time) error {
+	return b.produce(ctx, name, n, data, timestamp, "")
+}
@@ -0,0 +1,82 @@
+package brain
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/mvaleed/brook/internal/storage"
+)
+
+func TestBroker_ProduceAt(t *testing.T) {
+	ctx := context.Background()
+	paths := storage.Paths{Data: t.TempDir()}
+	b := openTestBroker(t, paths)
+	require.NoError(t, b.CreateTopic(ctx, "clicks", TopicConfig{Partitions: 1}))
+	require.NoError(t, b.CreateTopic(ctx, "sensors", TopicConfig{Partitions: 1, TimestampType: TimestampCreateTime, MaxTimestampSkew: 10 * time.Minute}))
+	require.Error(t, b.CreateTopic(ctx, "billing", TopicConfig{Partitions: 1, TimestampType: "wall_clock"}))
+
+	created := time.Now().Add(-5 * time.Minute).Truncate(time.Millisecond)
+	require.NoError(t, b.ProduceAt(ctx, "clicks", 0, []byte("click"), created))
+	require.NoError(t, b.ProduceAt(ctx, "sensors", 0, []byte("21.5"), created))
+	require.NoError(t, b.Produce(ctx, "sensors", 0, []byte("21.7")))
+
+	// Log append time ignores the producer's timestamp
+	msgs, err := b.Fetch(ctx, "clicks", 0, 0, 1024)
+	require.NoError(t, err)
+	require.WithinDuration(t, time.Now(), msgs[0].Timestamp, time.Minute)
+
+	msgs, err = b.Fetch(ctx, "sensors", 0, 0, 1024)
+	require.NoError(t, err)
+	require.Len(t, msgs, 2)
+	require.True(t, created.Equal(msgs[0].Timestamp), msgs[0].Timestamp)
+	require.WithinDuration(t, time.Now(), msgs[1].Timestamp, time.Minute)
+
+	t.Run("skew", func(t *testing.T) {
+		err := b.ProduceAt(ctx, "sensors", 0, []byte("21.9"), time.Now().Add(time.Hour))
+		require.ErrorIs(t, err, ErrInvalidTimestamp)
+		err = b.ProduceAt(ctx, "sensors", 0, []byte("21.9"), time.Now().Add(-time.Hour))
+		require.ErrorIs(t, err, ErrInvalidTimestamp)
+
+		skew := 2 * time.Hour
+		_, err = b.AlterTopicConfig(ctx, "sensors", TopicConfigChange{MaxTimestampSkew: &skew})
+		require.NoError(t, err)
+		require.NoError(t, b.ProduceAt(ctx, "sensors", 0, []byte("21.9"), time.Now().Add(-time.Hour)))
+	})
+
+	t.Run("survives a restart", func(t *testing.T) {
+		require.NoError(t, b.Close())
+		b = openTestBroker(t, paths)
+		defer b.Close()
+		config, err := b.TopicConfig("sensors")
+		require.NoError(t, err)
+		require.Equal(t, TimestampCreateTime, config.TimestampType)
+		require.Equal(t, 2*time.Hour, config.MaxTimestampSkew)
+	})
+}
+
+func TestBroker_OffsetForTimestamp(t *testing.T) {
+	ctx := context.Background()
+	b := openTestBroker(t, storage.Paths{Data: t.TempDir()})
+	defer b.Close()
+	require.NoError(t, b.CreateTopic(ctx, "sensors", TopicConfig{Partitions: 1, TimestampType: TimestampCreateTime}))
+
+	start := time.Now().Add(-30 * time.Minute).Truncate(time.Millisecond)
+	for i := range 3 {
+		require.NoError(t, b.ProduceAt(ctx, "sensors", 0, []byte("21.5"), start.Add(time.Duration(i)*time.Minute)))
+	}
+
+	offset, err := b.OffsetForTimestamp(ctx, "sensors", 0, start.Add(time.Second))
+	require.NoError(t, err)
+	require.Equal(t, 1, offset)
+	offset, err = b.OffsetForTimestamp(ctx, "sensors", 0, time.Now())
+	require.NoError(t, err)
+	require.Equal(t, 3, offset)
+
+	_, err = b.OffsetForTimestamp(ctx, "sensors", 1, start)
+	require.Error(t, err)
+	_, err = b.OffsetForTimestamp(ctx, "clicks", 0, start)
+	require.ErrorIs(t, err, ErrUnknownTopic)
+}
@@ -0,0 +1,285 @@
+package brain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/mvaleed/brook/internal/storage"
+)
+
+var (
+	ErrSubscribed      = errors.New("subscription is already open")
+	ErrMessageTooLarge = errors.New("message too large")
+)
+
+type Message struct {
+	Offset    int
+	Timestamp time.Time
+	Data      []byte
+}
+
+// Topic is a named stream of messages, stored in a single partition.
+type Topic struct {
+	name      string
+	partition *storage.Partition
+	cursors   *cursorStore
+
+	mu     sync.Mutex
+	closed bool
+	subs   map[string]bool
+	// published is closed and replaced on every publish, to wake up
+	// subscriptions waiting for new messages
+	published chan struct{}
+}
+
+func newTopic(name string, partition *storage.Partition, cursorsDir string) *Topic {
+	return &Topic{
+		name:      name,
+		partition: partition,
+		cursors:   &cursorStore{dir: cursorsDir},
+		subs:      make(map[string]bool),
+		published: make(chan struct{}),
+	}
+}
+
+func (t *Topic) Name() string {
+	return t.name
+}
+
+// EndOffset returns the offset the next published message will get.
+func (t *Topic) EndOffset() int {
+	return t.partition.NextOffset()
+}
+
+// Publish durably appends data to the topic.
+func (t *Topic) Publish(data []byte) error {
+	return t.PublishContext(context.Background(), data)
+}
+
+// PublishContext is Publish that stops waiting once ctx is done, for a
+// publisher that went away. The message may still be published then.
+func (t *Topic) PublishContext(ctx context.Context, data []byte) error {
+	if err := t.partition.AppendContext(ctx, data); err != nil {
+		if errors.Is(err, storage.ErrPartitionClosed) {
+			return ErrClosed
+		}
+		var tooLarge *storage.RecordTooLargeError
+		if errors.As(err, &tooLarge) {
+			return fmt.Errorf("%w: %d bytes, at most %d", ErrMessageTooLarge, tooLarge.Size, tooLarge.Limit)
+		}
+		return err
+	}
+
+	t.mu.Lock()
+	close(t.published)
+	t.published = make(chan struct{})
+	t.mu.Unlock()
+	return nil
+}
+
+// Subscribe opens the subscription called name. A subscription starts at the
+// beginning of the topic the first time, and after that where it was last
+// committed. Only one subscription of a name can be open at a time.
+func (t *Topic) Subscribe(name string) (*Subscription, error) {
+	return t.SubscribeWithConfig(name, SubscriptionConfig{})
+}
+
+// SubscribeWithConfig is Subscribe with a config, such as a dead-letter topic.
+func (t *Topic) SubscribeWithConfig(name string, config SubscriptionConfig) (*Subscription, error) {
+	if err := config.DeadLetter.validate(); err != nil {
+		return nil, err
+	}
+	if config.MaxFetchBytes < 0 {
+		return nil, errors.New("max fetch bytes can't be negative")
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.closed {
+		return nil, ErrClosed
+	}
+	if t.subs[name] {
+		return nil, fmt.Errorf("%w: %s", ErrSubscribed, name)
+	}
+
+	position, err := t.cursors.load(name)
+	if err != nil {
+		return nil, err
+	}
+
+	t.subs[name] = true
+	return &Subscription{
+		topic:     t,
+		name:      name,
+		config:    config,
+		position:  position,
+		committed: position,
+		failures:  make(map[int]int),
+	}, nil
+}
+
+// wait returns a channel that is closed once a message is published after
+// the call.
+func (t *Topic) wait() <-chan struct{} {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.published
+}
+
+func (t *Topic) unsubscribe(name string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.subs, name)
+}
+
+func (t *Topic) close() error {
+	t.mu.Lock()
+	t.closed = true
+	close(t.published)
+	t.published = make(chan struct{})
+	t.mu.Unlock()
+
+	return t.partition.Close()
+}
+
+func (t *Topic) isClosed() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.closed
+}
+
+// Subscription reads a topic in order. Delivery is at least once: messages
+// after the last Commit are delivered again when the subscription is
+// reopened. A Subscription is not safe for concurrent use.
+type Subscription struct {
+	topic     *Topic
+	name      string
+	config    SubscriptionConfig
+	position  int // offset of the next message Next returns
+	committed int
+	failures  map[int]int // deliveries that failed by offset, see Fail
+}
+
+// Next returns the next message, waiting for one to be published if needed.
+// Messages whose TTL ran out are skipped.
+func (s *Subscription) Next(ctx context.Context) (Message, error) {
+	for {
+		// Grab the channel before looking, so a publish in between isn't missed
+		published := s.topic.wait()
+		msg, ok, err := s.poll(ctx, -1)
+		if err != nil || ok {
+			return msg, err
+		}
+
+		select {
+		case <-published:
+		case <-ctx.Done():
+			return Message{}, ctx.Err()
+		}
+	}
+}
+
+// Fetch returns up to max of the next messages (at least one), as many as fit
+// in SubscriptionConfig.MaxFetchBytes. It waits like Next for the first one,
+// then takes whatever else has been published without waiting for more, so
+// with a deadline on ctx it is a long poll.
+func (s *Subscription) Fetch(ctx context.Context, max int) ([]Message, error) {
+	first, err := s.Next(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	maxBytes := s.config.MaxFetchBytes
+	if maxBytes == 0 {
+		maxBytes = DefaultMaxFetchBytes
+	}
+	msgs := []Message{first}
+	size := len(first.Data)
+	for len(msgs) < max && size < maxBytes {
+		msg, ok, err := s.poll(ctx, int64(maxBytes-size))
+		if err != nil || !ok {
+			// What was read is returned, the error comes up again next time
+			break
+		}
+		msgs = append(msgs, msg)
+		size += len(msg.Data)
+	}
+	return msgs, nil
+}
+
+// poll returns the next message if it has been published, without waiting.
+// With limit >= 0, a message whose payload is over limit isn't read and
+// counts as not published yet.
+func (s *Subscription) poll(ctx context.Context, limit int64) (Message, bool, error) {
+	for {
+		if s.topic.isClosed() {
+			return Message{}, false, ErrClosed
+		}
+		if s.position >= s.topic.partition.NextOffset() {
+			return Message{}, false, nil
+		}
+
+		var record storage.Record
+		var err error
+		if limit < 0 {
+			record, err = s.topic.partition.ReadContext(ctx, s.position)
+		} else {
+			record, err = s.topic.partition.ReadWithin(ctx, s.position, limit)
+		}
+		if errors.Is(err, storage.ErrRecordOverLimit) {
+			return Message{}, false, nil
+		}
+		if errors.Is(err, storage.ErrRecordExpired) {
+			s.position++
+			continue
+		}
+		if errors.Is(err, storage.ErrPartitionClosed) {
+			return Message{}, false, ErrClosed
+		}
+		if err != nil {
+			return Message{}, false, err
+		}
+
+		msg := Message{
+			Offset:    s.position,
+			Timestamp: time.Unix(0, int64(record.Header.Timestamp)),
+			Data:      record.Payload,
+		}
+		s.position++
+		return msg, true, nil
+	}
+}
+
+// Seek moves the subscription to offset, so that Next returns the message at
+// offset next. The move is only kept if it is committed.
+func (s *Subscription) Seek(offset int) {
+	s.position = offset
+}
+
+// Commit durably records that every message returned by Next so far has been
+// processed.
+func (s *Subscription) Commit() error {
+	if s.position == s.committed {
+		return nil
+	}
+	if err := s.topic.cursors.store(s.name, s.position); err != nil {
+		return err
+	}
+	s.committed = s.position
+	// Committed messages are done with, whatever failed before
+	for offset := range s.failures {
+		if offset < s.committed {
+			delete(s.failures, offset)
+		}
+	}
+	return nil
+}
+
+// Close closes the subscription without committing.
+func (s *Subscription) Close() {
+	s.topic.unsubscribe(s.name)
+}
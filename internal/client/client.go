@@ -0,0 +1,27 @@
+// Package client is the library applications produce and consume through.
+// It talks to a Broker, which for now is the in-process brain.Broker; the
+// same clients will work over the network once the broker has a protocol.
+package client
+
+import (
+	"context"
+	"time"
+
+	"github.com/mvaleed/brook/internal/brain"
+)
+
+// Broker is what clients send their requests to. *brain.Broker implements it.
+type Broker interface {
+	Produce(ctx context.Context, topic string, partition int, data []byte) error
+	ProduceAt(ctx context.Context, topic string, partition int, data []byte, timestamp time.Time) error
+	ProduceCompressed(ctx context.Context, topic string, partition int, data []byte, timestamp time.Time, codec brain.Compression) error
+	Fetch(ctx context.Context, topic string, partition int, offset int, maxBytes int) ([]brain.Message, error)
+	FetchFiltered(ctx context.Context, topic string, partition int, offset int, maxBytes int, filter brain.FetchFilter) ([]brain.Message, int, error)
+	TopicConfig(topic string) (brain.TopicConfig, error)
+	Offsets(topic string, partition int) (first int, next int, err error)
+	OffsetForTimestamp(ctx context.Context, topic string, partition int, timestamp time.Time) (int, error)
+	CommitOffset(ctx context.Context, group string, topic string, partition int, offset int) error
+	CommittedOffset(ctx context.Context, group string, topic string, partition int) (int, bool, error)
+}
+
+var _ Broker = (*brain.Broker)(nil)
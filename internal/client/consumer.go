@@ -0,0 +1,310 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/mvaleed/brook/internal/brain"
+)
+
+var (
+	ErrNoCommittedOffset = errors.New("no committed offset")
+	ErrOffsetOutOfRange  = errors.New("offset out of range")
+)
+
+// OffsetReset decides where a consumer starts in a partition it has no
+// committed offset for, or whose offset isn't in the partition anymore
+// because retention removed the messages.
+type OffsetReset int
+
+const (
+	// OffsetResetEarliest starts at the oldest message still kept.
+	OffsetResetEarliest OffsetReset = iota
+	// OffsetResetLatest starts after the last message, with the messages
+	// published from then on.
+	OffsetResetLatest
+	// OffsetResetNone fails with ErrNoCommittedOffset or
+	// ErrOffsetOutOfRange instead, for the application to decide.
+	OffsetResetNone
+)
+
+const defaultMaxPartitionBytes = 1 << 20
+
+// ConsumerConfig configures a Consumer.
+type ConsumerConfig struct {
+	// Group is the consumer group the offsets are committed for.
+	Group string
+	Topic string
+	// Partitions are the partitions of Topic to consume, every one of them
+	// if empty.
+	Partitions []int
+	// Reset applies when the committed offset is missing or out of range.
+	// Defaults to OffsetResetEarliest.
+	Reset OffsetReset
+	// MaxPartitionBytes caps the payload bytes a Poll takes from each
+	// partition, though it always takes at least one message if there is
+	// any. Defaults to 1MiB.
+	MaxPartitionBytes int
+	// Filter drops the messages it doesn't keep on the broker, before they
+	// are fetched. The zero FetchFilter keeps them all.
+	Filter brain.FetchFilter
+	// Deserializer decodes records in Decode. Defaults to RawDeserializer.
+	Deserializer Deserializer
+}
+
+// Record is a message consumed from a partition.
+type Record struct {
+	Partition int
+	Offset    int
+	Timestamp time.Time
+	Value     []byte
+}
+
+// Consumer reads the partitions of a topic for a consumer group. Delivery is
+// at least once: records polled after the last Commit are delivered again to
+// the next consumer of the group. A Consumer is not safe for concurrent use.
+type Consumer struct {
+	broker    Broker
+	config    ConsumerConfig
+	positions map[int]int // next offset to poll by partition, once known
+	paused    map[int]bool
+}
+
+func NewConsumer(broker Broker, config ConsumerConfig) (*Consumer, error) {
+	if config.Group == "" || config.Topic == "" {
+		return nil, errors.New("consumer group and topic are required")
+	}
+	topic, err := broker.TopicConfig(config.Topic)
+	if err != nil {
+		return nil, err
+	}
+	if len(config.Partitions) == 0 {
+		for n := range topic.Partitions {
+			config.Partitions = append(config.Partitions, n)
+		}
+	}
+	for _, n := range config.Partitions {
+		if n < 0 || n >= topic.Partitions {
+			return nil, fmt.Errorf("topic %s has no partition %d", config.Topic, n)
+		}
+	}
+	if config.MaxPartitionBytes <= 0 {
+		config.MaxPartitionBytes = defaultMaxPartitionBytes
+	}
+	if config.Deserializer == nil {
+		config.Deserializer = RawDeserializer{}
+	}
+
+	return &Consumer{
+		broker:    broker,
+		config:    config,
+		positions: make(map[int]int),
+		paused:    make(map[int]bool),
+	}, nil
+}
+
+// Poll returns the records published to the consumer's partitions since the
+// last Poll, without waiting for more. It returns none once the consumer is
+// caught up. Paused partitions aren't fetched from.
+func (c *Consumer) Poll(ctx context.Context) ([]Record, error) {
+	var records []Record
+	for _, n := range c.config.Partitions {
+		if c.paused[n] {
+			continue
+		}
+		position, err := c.validPosition(ctx, n)
+		if err != nil {
+			return records, err
+		}
+
+		msgs, next, err := c.broker.FetchFiltered(ctx, c.config.Topic, n, position, c.config.MaxPartitionBytes, c.config.Filter)
+		if err != nil {
+			return records, err
+		}
+		for _, msg := range msgs {
+			records = append(records, Record{Partition: n, Offset: msg.Offset, Timestamp: msg.Timestamp, Value: msg.Data})
+		}
+		// Past the messages filtered out too
+		c.positions[n] = next
+	}
+	return records, nil
+}
+
+// Decode decodes the value of r into v with the consumer's Deserializer.
+func (c *Consumer) Decode(r Record, v any) error {
+	return c.config.Deserializer.Deserialize(c.config.Topic, r.Value, v)
+}
+
+// Position returns the offset of the next record Poll returns for partition
+// n.
+func (c *Consumer) Position(ctx context.Context, n int) (int, error) {
+	if err := c.checkPartition(n); err != nil {
+		return 0, err
+	}
+	return c.validPosition(ctx, n)
+}
+
+// Seek moves the consumer to offset in partition n. An offset out of range
+// is reset on the next Poll according to the consumer's OffsetReset.
+func (c *Consumer) Seek(n int, offset int) error {
+	if err := c.checkPartition(n); err != nil {
+		return err
+	}
+	c.positions[n] = offset
+	return nil
+}
+
+// SeekToBeginning moves the consumer to the oldest record of every partition
+// given, or of all of its partitions if none is.
+func (c *Consumer) SeekToBeginning(partitions ...int) error {
+	return c.seekTo(partitions, func(first, next int) int { return first })
+}
+
+// SeekToEnd moves the consumer past the last record of every partition
+// given, or of all of its partitions if none is.
+func (c *Consumer) SeekToEnd(partitions ...int) error {
+	return c.seekTo(partitions, func(first, next int) int { return next })
+}
+
+// SeekToTimestamp moves the consumer to the first record stamped at or after
+// timestamp in every partition given, or in all of its partitions if none is,
+// past the last record of those with none.
+func (c *Consumer) SeekToTimestamp(ctx context.Context, timestamp time.Time, partitions ...int) error {
+	if len(partitions) == 0 {
+		partitions = c.config.Partitions
+	}
+	for _, n := range partitions {
+		if err := c.checkPartition(n); err != nil {
+			return err
+		}
+		offset, err := c.broker.OffsetForTimestamp(ctx, c.config.Topic, n, timestamp)
+		if err != nil {
+			return err
+		}
+		c.positions[n] = offset
+	}
+	return nil
+}
+
+func (c *Consumer) seekTo(partitions []int, offset func(first, next int) int) error {
+	if len(partitions) == 0 {
+		partitions = c.config.Partitions
+	}
+	for _, n := range partitions {
+		if err := c.checkPartition(n); err != nil {
+			return err
+		}
+		first, next, err := c.broker.Offsets(c.config.Topic, n)
+		if err != nil {
+			return err
+		}
+		c.positions[n] = offset(first, next)
+	}
+	return nil
+}
+
+// Pause stops Poll from fetching the partitions given, or all of the
+// consumer's partitions if none is, until they are resumed. The consumer
+// keeps its position in them and still commits it.
+func (c *Consumer) Pause(partitions ...int) error {
+	return c.setPaused(partitions, true)
+}
+
+// Resume lets Poll fetch the partitions given again, or all of the
+// consumer's partitions if none is, from where it stopped.
+func (c *Consumer) Resume(partitions ...int) error {
+	return c.setPaused(partitions, false)
+}
+
+// Paused returns the paused partitions, in the order of the consumer's.
+func (c *Consumer) Paused() []int {
+	var paused []int
+	for _, n := range c.config.Partitions {
+		if c.paused[n] {
+			paused = append(paused, n)
+		}
+	}
+	return paused
+}
+
+func (c *Consumer) setPaused(partitions []int, paused bool) error {
+	if len(partitions) == 0 {
+		partitions = c.config.Partitions
+	}
+	for _, n := range partitions {
+		if err := c.checkPartition(n); err != nil {
+			return err
+		}
+	}
+	for _, n := range partitions {
+		if paused {
+			c.paused[n] = true
+		} else {
+			delete(c.paused, n)
+		}
+	}
+	return nil
+}
+
+// Commit durably records the position of the consumer in every partition it
+// has polled or moved in.
+func (c *Consumer) Commit(ctx context.Context) error {
+	for _, n := range c.config.Partitions {
+		position, ok := c.positions[n]
+		if !ok {
+			continue
+		}
+		if err := c.broker.CommitOffset(ctx, c.config.Group, c.config.Topic, n, position); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Consumer) checkPartition(n int) error {
+	if !slices.Contains(c.config.Partitions, n) {
+		return fmt.Errorf("partition %d of topic %s isn't consumed", n, c.config.Topic)
+	}
+	return nil
+}
+
+// validPosition returns the position of the consumer in partition n, starting
+// from the committed offset the first time and resetting it if it's out of
+// range.
+func (c *Consumer) validPosition(ctx context.Context, n int) (int, error) {
+	position, ok := c.positions[n]
+	if !ok {
+		committed, found, err := c.broker.CommittedOffset(ctx, c.config.Group, c.config.Topic, n)
+		if err != nil {
+			return 0, err
+		}
+		if !found && c.config.Reset == OffsetResetNone {
+			return 0, fmt.Errorf("%w for group %s in partition %d of topic %s", ErrNoCommittedOffset, c.config.Group, n, c.config.Topic)
+		}
+		// A missing offset is reset like one out of range
+		position = committed
+		if !found {
+			position = -1
+		}
+	}
+
+	first, next, err := c.broker.Offsets(c.config.Topic, n)
+	if err != nil {
+		return 0, err
+	}
+	if position < first || position > next {
+		switch c.config.Reset {
+		case OffsetResetEarliest:
+			position = first
+		case OffsetResetLatest:
+			position = next
+		default:
+			return 0, fmt.Errorf("%w: offset %d of partition %d of topic %s, which holds [%d, %d)", ErrOffsetOutOfRange, position, n, c.config.Topic, first, next)
+		}
+	}
+	c.positions[n] = position
+	return position, nil
+}
@@ -0,0 +1,189 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/mvaleed/brook/internal/brain"
+)
+
+func values(records []Record) []string {
+	var vs []string
+	for _, r := range records {
+		vs = append(vs, fmt.Sprintf("%d/%d:%s", r.Partition, r.Offset, r.Value))
+	}
+	return vs
+}
+
+func newConsumerTestBroker(t *testing.T) *brain.Broker {
+	t.Helper()
+	b := openTestBroker(t, brain.DefaultBrokerConfig())
+	ctx := context.Background()
+	require.NoError(t, b.CreateTopic(ctx, "orders", brain.TopicConfig{Partitions: 2}))
+	for i := range 3 {
+		require.NoError(t, b.Produce(ctx, "orders", 0, fmt.Appendf(nil, "a%d", i)))
+		require.NoError(t, b.Produce(ctx, "orders", 1, fmt.Appendf(nil, "b%d", i)))
+	}
+	return b
+}
+
+func TestConsumer(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("polls every partition and resumes from the commit", func(t *testing.T) {
+		b := newConsumerTestBroker(t)
+		c, err := NewConsumer(b, ConsumerConfig{Group: "billing", Topic: "orders"})
+		require.NoError(t, err)
+
+		records, err := c.Poll(ctx)
+		require.NoError(t, err)
+		require.Equal(t, []string{"0/0:a0", "0/1:a1", "0/2:a2", "1/0:b0", "1/1:b1", "1/2:b2"}, values(records))
+		records, err = c.Poll(ctx)
+		require.NoError(t, err)
+		require.Empty(t, records)
+
+		require.NoError(t, c.Seek(0, 1))
+		require.NoError(t, c.Commit(ctx))
+		require.NoError(t, b.Produce(ctx, "orders", 1, []byte("b3")))
+
+		c, err = NewConsumer(b, ConsumerConfig{Group: "billing", Topic: "orders"})
+		require.NoError(t, err)
+		records, err = c.Poll(ctx)
+		require.NoError(t, err)
+		require.Equal(t, []string{"0/1:a1", "0/2:a2", "1/3:b3"}, values(records))
+	})
+	t.Run("seek to the beginning and the end", func(t *testing.T) {
+		b := newConsumerTestBroker(t)
+		c, err := NewConsumer(b, ConsumerConfig{Group: "billing", Topic: "orders", Partitions: []int{1}})
+		require.NoError(t, err)
+
+		require.NoError(t, c.SeekToEnd())
+		records, err := c.Poll(ctx)
+		require.NoError(t, err)
+		require.Empty(t, records)
+
+		require.NoError(t, c.SeekToBeginning(1))
+		position, err := c.Position(ctx, 1)
+		require.NoError(t, err)
+		require.Equal(t, 0, position)
+		records, err = c.Poll(ctx)
+		require.NoError(t, err)
+		require.Len(t, records, 3)
+
+		require.Error(t, c.Seek(0, 0))
+		require.Error(t, c.SeekToEnd(0))
+	})
+	t.Run("seek to a timestamp", func(t *testing.T) {
+		b := newConsumerTestBroker(t)
+		require.NoError(t, b.CreateTopic(ctx, "sensors", brain.TopicConfig{Partitions: 2, TimestampType: brain.TimestampCreateTime}))
+		start := time.Now().Add(-10 * time.Minute)
+		for i := range 3 {
+			require.NoError(t, b.ProduceAt(ctx, "sensors", 0, fmt.Appendf(nil, "a%d", i), start.Add(time.Duration(i)*time.Minute)))
+		}
+		require.NoError(t, b.ProduceAt(ctx, "sensors", 1, []byte("b0"), start))
+		c, err := NewConsumer(b, ConsumerConfig{Group: "billing", Topic: "sensors"})
+		require.NoError(t, err)
+
+		require.NoError(t, c.SeekToTimestamp(ctx, start.Add(time.Minute)))
+		records, err := c.Poll(ctx)
+		require.NoError(t, err)
+		require.Equal(t, []string{"0/1:a1", "0/2:a2"}, values(records))
+
+		require.NoError(t, c.SeekToTimestamp(ctx, start, 1))
+		records, err = c.Poll(ctx)
+		require.NoError(t, err)
+		require.Equal(t, []string{"1/0:b0"}, values(records))
+		require.Error(t, c.SeekToTimestamp(ctx, start, 2))
+	})
+	t.Run("offset reset policies", func(t *testing.T) {
+		b := newConsumerTestBroker(t)
+
+		latest, err := NewConsumer(b, ConsumerConfig{Group: "new", Topic: "orders", Reset: OffsetResetLatest})
+		require.NoError(t, err)
+		records, err := latest.Poll(ctx)
+		require.NoError(t, err)
+		require.Empty(t, records)
+
+		none, err := NewConsumer(b, ConsumerConfig{Group: "new", Topic: "orders", Reset: OffsetResetNone})
+		require.NoError(t, err)
+		_, err = none.Poll(ctx)
+		require.ErrorIs(t, err, ErrNoCommittedOffset)
+
+		// An offset retention removed is out of range
+		require.NoError(t, b.CommitOffset(ctx, "old", "orders", 0, 1))
+		p, err := b.Partition("orders", 0)
+		require.NoError(t, err)
+		require.NoError(t, p.TruncateBefore(2))
+
+		none, err = NewConsumer(b, ConsumerConfig{Group: "old", Topic: "orders", Partitions: []int{0}, Reset: OffsetResetNone})
+		require.NoError(t, err)
+		_, err = none.Poll(ctx)
+		require.ErrorIs(t, err, ErrOffsetOutOfRange)
+
+		earliest, err := NewConsumer(b, ConsumerConfig{Group: "old", Topic: "orders", Partitions: []int{0}})
+		require.NoError(t, err)
+		records, err = earliest.Poll(ctx)
+		require.NoError(t, err)
+		require.Equal(t, []string{"0/2:a2"}, values(records))
+
+		// So is one past the end
+		require.NoError(t, earliest.Seek(0, 10))
+		records, err = earliest.Poll(ctx)
+		require.NoError(t, err)
+		require.Equal(t, []string{"0/2:a2"}, values(records))
+	})
+	t.Run("filtered on the broker", func(t *testing.T) {
+		b := newConsumerTestBroker(t)
+		c, err := NewConsumer(b, ConsumerConfig{Group: "billing", Topic: "orders", Filter: brain.FetchFilter{Prefix: []byte("b")}})
+		require.NoError(t, err)
+
+		records, err := c.Poll(ctx)
+		require.NoError(t, err)
+		require.Equal(t, []string{"1/0:b0", "1/1:b1", "1/2:b2"}, values(records))
+		// Past the records filtered out
+		position, err := c.Position(ctx, 0)
+		require.NoError(t, err)
+		require.Equal(t, 3, position)
+	})
+	t.Run("paused partitions are skipped until resumed", func(t *testing.T) {
+		b := newConsumerTestBroker(t)
+		c, err := NewConsumer(b, ConsumerConfig{Group: "billing", Topic: "orders"})
+		require.NoError(t, err)
+
+		require.NoError(t, c.Pause(1))
+		require.Equal(t, []int{1}, c.Paused())
+		records, err := c.Poll(ctx)
+		require.NoError(t, err)
+		require.Equal(t, []string{"0/0:a0", "0/1:a1", "0/2:a2"}, values(records))
+		require.Error(t, c.Pause(2))
+
+		require.NoError(t, b.Produce(ctx, "orders", 0, []byte("a3")))
+		require.NoError(t, c.Pause())
+		records, err = c.Poll(ctx)
+		require.NoError(t, err)
+		require.Empty(t, records)
+
+		require.NoError(t, c.Resume(1))
+		require.Equal(t, []int{0}, c.Paused())
+		records, err = c.Poll(ctx)
+		require.NoError(t, err)
+		require.Equal(t, []string{"1/0:b0", "1/1:b1", "1/2:b2"}, values(records))
+		require.NoError(t, c.Resume())
+		records, err = c.Poll(ctx)
+		require.NoError(t, err)
+		require.Equal(t, []string{"0/3:a3"}, values(records))
+	})
+	t.Run("invalid config", func(t *testing.T) {
+		b := newConsumerTestBroker(t)
+		_, err := NewConsumer(b, ConsumerConfig{Topic: "orders"})
+		require.Error(t, err)
+		_, err = NewConsumer(b, ConsumerConfig{Group: "billing", Topic: "payments"})
+		require.ErrorIs(t, err, brain.ErrUnknownTopic)
+		_, err = NewConsumer(b, ConsumerConfig{Group: "billing", Topic: "orders", Partitions: []int{2}})
+		require.Error(t, err)
+	})
+}
@@ -0,0 +1,82 @@
+package client
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/mvaleed/brook/internal/brain"
+)
+
+const defaultMetadataMaxAge = 5 * time.Minute
+
+// topicMetadata is what a client knows about a topic.
+type topicMetadata struct {
+	partitions int
+	fetched    time.Time
+}
+
+// metadataCache keeps the metadata of the topics a client sends to, so it
+// isn't asked of the broker for every record. An entry is fetched again
+// once it's older than maxAge, or right away once a request fails because
+// of it, see stale. Topics the broker doesn't know aren't cached: they may
+// be created any time.
+type metadataCache struct {
+	broker Broker
+	maxAge time.Duration
+	now    func() time.Time
+
+	mu     sync.Mutex
+	topics map[string]topicMetadata
+}
+
+func newMetadataCache(broker Broker, maxAge time.Duration) *metadataCache {
+	if maxAge <= 0 {
+		maxAge = defaultMetadataMaxAge
+	}
+	return &metadataCache{
+		broker: broker,
+		maxAge: maxAge,
+		now:    time.Now,
+		topics: make(map[string]topicMetadata),
+	}
+}
+
+// partitions returns how many partitions topic has, an error matching
+// brain.ErrUnknownTopic if the broker doesn't know it.
+func (c *metadataCache) partitions(topic string) (int, error) {
+	now := c.now()
+	c.mu.Lock()
+	m, ok := c.topics[topic]
+	c.mu.Unlock()
+	if ok && now.Sub(m.fetched) < c.maxAge {
+		return m.partitions, nil
+	}
+
+	config, err := c.broker.TopicConfig(topic)
+	if err != nil {
+		if errors.Is(err, brain.ErrUnknownTopic) {
+			c.invalidate(topic)
+		}
+		return 0, err
+	}
+	c.mu.Lock()
+	c.topics[topic] = topicMetadata{partitions: config.Partitions, fetched: now}
+	c.mu.Unlock()
+	return config.Partitions, nil
+}
+
+// invalidate drops what is known about topic, for the next request to fetch
+// it again.
+func (c *metadataCache) invalidate(topic string) {
+	c.mu.Lock()
+	delete(c.topics, topic)
+	c.mu.Unlock()
+}
+
+// stale reports whether a request failed with err because the metadata it
+// was routed with is out of date, and is worth retrying once it's fetched
+// again.
+func stale(err error) bool {
+	return errors.Is(err, brain.ErrUnknownTopic) || errors.Is(err, brain.ErrUnknownPartition)
+}
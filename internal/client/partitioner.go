@@ -0,0 +1,105 @@
+package client
+
+import (
+	"hash/fnv"
+	"sync"
+)
+
+// Partitioner picks the partition a record goes to among the n partitions of
+// its topic. Records with the same key must land on the same partition for
+// their order to be kept. Partitioners are called concurrently.
+type Partitioner interface {
+	Partition(topic string, key []byte, n int) int
+}
+
+// PartitionerFunc turns a function into a Partitioner.
+type PartitionerFunc func(topic string, key []byte, n int) int
+
+func (f PartitionerFunc) Partition(topic string, key []byte, n int) int {
+	return f(topic, key, n)
+}
+
+// hashKey spreads keys over n partitions. FNV-1a is stable across processes
+// and versions, so a key keeps its partition as long as n doesn't change.
+func hashKey(key []byte, n int) int {
+	h := fnv.New32a()
+	h.Write(key)
+	return int(h.Sum32() % uint32(n))
+}
+
+// HashPartitioner sends a record to the partition its key hashes to. Records
+// without a key all go to partition 0.
+type HashPartitioner struct{}
+
+func (HashPartitioner) Partition(topic string, key []byte, n int) int {
+	if len(key) == 0 {
+		return 0
+	}
+	return hashKey(key, n)
+}
+
+// RoundRobinPartitioner spreads records evenly over the partitions of each
+// topic, ignoring their keys.
+type RoundRobinPartitioner struct {
+	mu   sync.Mutex
+	next map[string]int
+}
+
+func (p *RoundRobinPartitioner) Partition(topic string, key []byte, n int) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.next == nil {
+		p.next = make(map[string]int)
+	}
+	partition := p.next[topic] % n
+	p.next[topic] = partition + 1
+	return partition
+}
+
+const defaultStickyRecords = 100
+
+// StickyPartitioner hashes keyed records like HashPartitioner, and sends the
+// records without a key to one partition for a while before moving on to the
+// next, so that they batch well while still spreading out over time. It is
+// the default, as in Kafka.
+type StickyPartitioner struct {
+	// Records is how many keyless records go to a partition before moving
+	// on. Defaults to 100.
+	Records int
+
+	mu     sync.Mutex
+	sticky map[string]*stickyPartition
+}
+
+type stickyPartition struct {
+	partition int
+	records   int
+}
+
+func (p *StickyPartitioner) Partition(topic string, key []byte, n int) int {
+	if len(key) > 0 {
+		return hashKey(key, n)
+	}
+
+	limit := p.Records
+	if limit <= 0 {
+		limit = defaultStickyRecords
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.sticky == nil {
+		p.sticky = make(map[string]*stickyPartition)
+	}
+	s, ok := p.sticky[topic]
+	if !ok {
+		s = &stickyPartition{}
+		p.sticky[topic] = s
+	}
+	if s.records >= limit {
+		s.partition++
+		s.records = 0
+	}
+	s.records++
+	return s.partition % n
+}
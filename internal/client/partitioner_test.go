@@ -0,0 +1,49 @@
+package client
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPartitioners(t *testing.T) {
+	t.Run("hash keeps keys together", func(t *testing.T) {
+		var p HashPartitioner
+		seen := make(map[int]bool)
+		for i := range 100 {
+			key := fmt.Appendf(nil, "customer-%d", i)
+			partition := p.Partition("orders", key, 8)
+			require.Equal(t, partition, p.Partition("other", key, 8))
+			seen[partition] = true
+		}
+		// Spread over every partition
+		require.Len(t, seen, 8)
+		require.Equal(t, 0, p.Partition("orders", nil, 8))
+	})
+	t.Run("round robin", func(t *testing.T) {
+		var p RoundRobinPartitioner
+		var got []int
+		for range 5 {
+			got = append(got, p.Partition("orders", []byte("key"), 3))
+		}
+		require.Equal(t, []int{0, 1, 2, 0, 1}, got)
+		require.Equal(t, 0, p.Partition("events", nil, 3))
+	})
+	t.Run("sticky", func(t *testing.T) {
+		p := StickyPartitioner{Records: 2}
+		var got []int
+		for range 7 {
+			got = append(got, p.Partition("orders", nil, 3))
+		}
+		require.Equal(t, []int{0, 0, 1, 1, 2, 2, 0}, got)
+
+		// Keyed records are hashed
+		var hash HashPartitioner
+		require.Equal(t, hash.Partition("orders", []byte("key"), 3), p.Partition("orders", []byte("key"), 3))
+	})
+	t.Run("func", func(t *testing.T) {
+		p := PartitionerFunc(func(topic string, key []byte, n int) int { return n - 1 })
+		require.Equal(t, 4, p.Partition("orders", nil, 5))
+	})
+}
@@ -0,0 +1,274 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/mvaleed/brook/internal/brain"
+)
+
+// ProducerConfig configures a Producer.
+type ProducerConfig struct {
+	// Partitioner picks the partition of every record. Defaults to a
+	// StickyPartitioner.
+	Partitioner Partitioner
+	// Serializer turns the values of SendValue into payloads. Defaults to
+	// RawSerializer.
+	Serializer Serializer
+	// MetadataMaxAge is how long the partitions of a topic are routed to
+	// without asking the broker again. A record the broker refuses because
+	// its topic or partition changed is routed again with fresh metadata
+	// anyway. Defaults to five minutes.
+	MetadataMaxAge time.Duration
+	// MaxInFlight caps the records SendAsync has sent that the broker hasn't
+	// acknowledged yet, over all partitions. Unless Ordered is set, records
+	// to the same partition are sent without waiting for each other, so
+	// above 1 they may be appended out of order; their completions are still
+	// reported in the order they were sent. Defaults to 5.
+	MaxInFlight int
+	// Ordered has SendAsync send the records of a partition one after
+	// another, each once the broker appended the one before, so they are
+	// appended in the order they were sent, as with an idempotent Kafka
+	// producer. Records of different partitions are still in flight
+	// together, up to MaxInFlight; the ones waiting for the record before
+	// them count against it too. A record that fails doesn't stop the ones
+	// after it.
+	Ordered bool
+	// Compression compresses the records before they are sent. The broker
+	// recompresses them if their topic is stored in another codec. Without
+	// it records are sent as they are, already in the codec of their topic.
+	Compression brain.Compression
+	// MaxRequestBytes caps the size of a record's value. Larger ones fail
+	// with a brain.MessageTooLargeError without being sent. The broker
+	// still enforces the limit of the topic. Defaults to 16MiB.
+	MaxRequestBytes int
+}
+
+const (
+	defaultMaxInFlight     = 5
+	defaultMaxRequestBytes = 16 << 20
+)
+
+// Producer sends records to the partitions of topics. It is safe for
+// concurrent use.
+type Producer struct {
+	broker      Broker
+	partitioner Partitioner
+	serializer  Serializer
+	metadata    *metadataCache
+	compression brain.Compression
+	maxRequest  int
+	ordered     bool
+
+	inFlight chan struct{} // holds a token for every record SendAsync has in flight
+	lanesMu  sync.Mutex
+	lanes    map[lane]chan struct{} // closed once the last record sent to the partition is done
+
+	pendingMu sync.Mutex
+	pending   int           // records SendAsync sent that aren't done
+	flushed   chan struct{} // closed once pending drops to zero, nil while it is
+}
+
+// lane is a partition whose records are done in the order they were sent.
+type lane struct {
+	topic     string
+	partition int
+}
+
+func NewProducer(broker Broker, config ProducerConfig) *Producer {
+	partitioner := config.Partitioner
+	if partitioner == nil {
+		partitioner = &StickyPartitioner{}
+	}
+	serializer := config.Serializer
+	if serializer == nil {
+		serializer = RawSerializer{}
+	}
+	maxInFlight := config.MaxInFlight
+	if maxInFlight <= 0 {
+		maxInFlight = defaultMaxInFlight
+	}
+	maxRequest := config.MaxRequestBytes
+	if maxRequest <= 0 {
+		maxRequest = defaultMaxRequestBytes
+	}
+	return &Producer{
+		broker:      broker,
+		partitioner: partitioner,
+		serializer:  serializer,
+		metadata:    newMetadataCache(broker, config.MetadataMaxAge),
+		compression: config.Compression,
+		maxRequest:  maxRequest,
+		ordered:     config.Ordered,
+		inFlight:    make(chan struct{}, maxInFlight),
+		lanes:       make(map[lane]chan struct{}),
+	}
+}
+
+// Send appends value to the partition of topic its key is routed to, and
+// returns that partition. The key only decides the partition, it isn't
+// stored. A topic the broker doesn't know is sent to partition 0, which
+// creates it if the broker auto-creates topics.
+func (p *Producer) Send(ctx context.Context, topic string, key []byte, value []byte) (int, error) {
+	return p.SendAt(ctx, topic, key, value, time.Time{})
+}
+
+// SendAt is Send for a record created at timestamp, which it keeps in topics
+// with brain.TimestampCreateTime, see brain.Broker.ProduceAt.
+func (p *Producer) SendAt(ctx context.Context, topic string, key []byte, value []byte, timestamp time.Time) (int, error) {
+	partition, known, err := p.route(topic, key)
+	if err != nil {
+		return 0, err
+	}
+	return p.produce(ctx, topic, key, value, timestamp, partition, known)
+}
+
+// SendAsync sends value like SendAt without waiting for the broker to
+// append it, so several records can be on their way at once, to the same
+// partition too unless the producer is Ordered. It blocks while the producer
+// has MaxInFlight records in flight, until ctx is done, which bounds the
+// append of the record too. done, if not nil, is called with the partition
+// of the record once it's appended, or with the error it failed with, on a
+// goroutine of the producer. The records of a partition are done in the
+// order SendAsync was called, even when the broker appended them in another,
+// unless one is routed again because the topic changed under it.
+func (p *Producer) SendAsync(ctx context.Context, topic string, key []byte, value []byte, timestamp time.Time, done func(partition int, err error)) error {
+	select {
+	case p.inFlight <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	partition, known, err := p.route(topic, key)
+	if err != nil {
+		<-p.inFlight
+		return err
+	}
+
+	l := lane{topic: topic, partition: partition}
+	finished := make(chan struct{})
+	p.lanesMu.Lock()
+	previous := p.lanes[l]
+	p.lanes[l] = finished
+	p.lanesMu.Unlock()
+
+	p.addPending(1)
+	go func() {
+		defer p.addPending(-1)
+		if p.ordered && previous != nil {
+			// Sent once the record before it is appended
+			<-previous
+		}
+		partition, err := p.produce(ctx, topic, key, value, timestamp, partition, known)
+		<-p.inFlight
+
+		// The records sent before to the partition are done first
+		if previous != nil {
+			<-previous
+		}
+		if done != nil {
+			done(partition, err)
+		}
+		p.lanesMu.Lock()
+		if p.lanes[l] == finished {
+			delete(p.lanes, l)
+		}
+		p.lanesMu.Unlock()
+		close(finished)
+	}()
+	return nil
+}
+
+// addPending counts n more records SendAsync sent that aren't done.
+func (p *Producer) addPending(n int) {
+	p.pendingMu.Lock()
+	defer p.pendingMu.Unlock()
+	if p.pending == 0 {
+		p.flushed = make(chan struct{})
+	}
+	p.pending += n
+	if p.pending == 0 {
+		close(p.flushed)
+		p.flushed = nil
+	}
+}
+
+// Flush waits for every record SendAsync sent to be done, or for ctx to be.
+func (p *Producer) Flush(ctx context.Context) error {
+	p.pendingMu.Lock()
+	flushed := p.flushed
+	p.pendingMu.Unlock()
+	if flushed == nil {
+		return nil
+	}
+	select {
+	case <-flushed:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// produce appends value to partition, routed from metadata that was known
+// or not, once more with fresh metadata if the broker says it's stale.
+func (p *Producer) produce(ctx context.Context, topic string, key []byte, value []byte, timestamp time.Time, partition int, known bool) (int, error) {
+	if len(value) > p.maxRequest {
+		return 0, &brain.MessageTooLargeError{Topic: topic, Size: int64(len(value)), Limit: int64(p.maxRequest)}
+	}
+	data := value
+	if p.compression != "" {
+		var err error
+		if data, err = p.compression.Compress(value); err != nil {
+			return 0, err
+		}
+	}
+	err := p.append(ctx, topic, partition, data, timestamp)
+	if err != nil && known && stale(err) {
+		p.metadata.invalidate(topic)
+		if partition, _, err = p.route(topic, key); err != nil {
+			return 0, err
+		}
+		err = p.append(ctx, topic, partition, data, timestamp)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return partition, nil
+}
+
+// append sends data, compressed with the producer's codec, to partition.
+func (p *Producer) append(ctx context.Context, topic string, partition int, data []byte, timestamp time.Time) error {
+	if p.compression == "" {
+		return p.broker.ProduceAt(ctx, topic, partition, data, timestamp)
+	}
+	return p.broker.ProduceCompressed(ctx, topic, partition, data, timestamp, p.compression)
+}
+
+// route returns the partition of topic a record with key goes to, and
+// whether the topic is known.
+func (p *Producer) route(topic string, key []byte) (int, bool, error) {
+	partitions, err := p.metadata.partitions(topic)
+	known := err == nil
+	if errors.Is(err, brain.ErrUnknownTopic) {
+		partitions = 1
+	} else if err != nil {
+		return 0, false, err
+	}
+
+	partition := p.partitioner.Partition(topic, key, partitions)
+	if partition < 0 || partition >= partitions {
+		return 0, false, fmt.Errorf("partitioner picked partition %d of topic %s, which has %d", partition, topic, partitions)
+	}
+	return partition, known, nil
+}
+
+// SendValue is Send for a value serialized by the producer's Serializer.
+func (p *Producer) SendValue(ctx context.Context, topic string, key []byte, v any) (int, error) {
+	value, err := p.serializer.Serialize(topic, v)
+	if err != nil {
+		return 0, err
+	}
+	return p.Send(ctx, topic, key, value)
+}
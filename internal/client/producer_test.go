@@ -0,0 +1,314 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/mvaleed/brook/internal/brain"
+	"github.com/mvaleed/brook/internal/storage"
+)
+
+func openTestBroker(t *testing.T, config brain.BrokerConfig) *brain.Broker {
+	t.Helper()
+	b, err := brain.OpenBroker(storage.Paths{Data: t.TempDir()}, config)
+	require.NoError(t, err)
+	t.Cleanup(func() { b.Close() })
+	return b
+}
+
+// metadataBroker counts the topic metadata lookups, and can report a
+// partition count that is out of date.
+type metadataBroker struct {
+	*brain.Broker
+	lookups    int
+	partitions int
+}
+
+func (b *metadataBroker) TopicConfig(topic string) (brain.TopicConfig, error) {
+	b.lookups++
+	config, err := b.Broker.TopicConfig(topic)
+	if err == nil && b.partitions > 0 {
+		config.Partitions = b.partitions
+	}
+	return config, err
+}
+
+// blockingBroker holds produces until they are released.
+type blockingBroker struct {
+	*brain.Broker
+	release chan struct{}
+	waiting atomic.Int32
+}
+
+func (b *blockingBroker) ProduceAt(ctx context.Context, topic string, partition int, data []byte, timestamp time.Time) error {
+	b.waiting.Add(1)
+	<-b.release
+	return b.Broker.ProduceAt(ctx, topic, partition, data, timestamp)
+}
+
+// jitterBroker takes a random while to append, so that records sent together
+// can overtake each other.
+type jitterBroker struct {
+	*brain.Broker
+}
+
+func (b jitterBroker) ProduceAt(ctx context.Context, topic string, partition int, data []byte, timestamp time.Time) error {
+	time.Sleep(time.Duration(rand.IntN(500)) * time.Microsecond)
+	return b.Broker.ProduceAt(ctx, topic, partition, data, timestamp)
+}
+
+func TestProducer(t *testing.T) {
+	t.Run("records with a key stay in order on one partition", func(t *testing.T) {
+		b := openTestBroker(t, brain.DefaultBrokerConfig())
+		ctx := context.Background()
+		require.NoError(t, b.CreateTopic(ctx, "orders", brain.TopicConfig{Partitions: 4}))
+
+		producer := NewProducer(b, ProducerConfig{})
+		partitions := make(map[string]int)
+		for i := range 40 {
+			key := fmt.Sprintf("customer-%d", i%5)
+			partition, err := producer.Send(ctx, "orders", []byte(key), fmt.Appendf(nil, "%s order %d", key, i))
+			require.NoError(t, err)
+			if previous, ok := partitions[key]; ok {
+				require.Equal(t, previous, partition)
+			}
+			partitions[key] = partition
+		}
+
+		// Each customer's orders are in the order they were sent
+		total := 0
+		for partition := range 4 {
+			msgs, err := b.Fetch(ctx, "orders", partition, 0, 1<<20)
+			require.NoError(t, err)
+			last := make(map[string]int)
+			for _, msg := range msgs {
+				var key string
+				var n int
+				_, err := fmt.Sscanf(string(msg.Data), "%s order %d", &key, &n)
+				require.NoError(t, err)
+				require.Equal(t, partitions[key], partition)
+				if previous, ok := last[key]; ok {
+					require.Greater(t, n, previous)
+				}
+				last[key] = n
+			}
+			total += len(msgs)
+		}
+		require.Equal(t, 40, total)
+	})
+	t.Run("records keep their timestamp with create time", func(t *testing.T) {
+		b := openTestBroker(t, brain.DefaultBrokerConfig())
+		ctx := context.Background()
+		require.NoError(t, b.CreateTopic(ctx, "readings", brain.TopicConfig{Partitions: 1, TimestampType: brain.TimestampCreateTime}))
+
+		created := time.Now().Add(-time.Minute).Truncate(time.Millisecond)
+		producer := NewProducer(b, ProducerConfig{})
+		_, err := producer.SendAt(ctx, "readings", nil, []byte("21.5"), created)
+		require.NoError(t, err)
+		msgs, err := b.Fetch(ctx, "readings", 0, 0, 1<<20)
+		require.NoError(t, err)
+		require.True(t, created.Equal(msgs[0].Timestamp), msgs[0].Timestamp)
+	})
+	t.Run("unknown topics go to partition 0", func(t *testing.T) {
+		config := brain.DefaultBrokerConfig()
+		config.AutoCreateTopics = true
+		b := openTestBroker(t, config)
+
+		producer := NewProducer(b, ProducerConfig{Partitioner: &RoundRobinPartitioner{}})
+		partition, err := producer.Send(context.Background(), "clicks", nil, []byte("click"))
+		require.NoError(t, err)
+		require.Equal(t, 0, partition)
+		require.Equal(t, []string{"clicks"}, b.Topics())
+	})
+	t.Run("partitions out of range are refused", func(t *testing.T) {
+		b := openTestBroker(t, brain.DefaultBrokerConfig())
+		require.NoError(t, b.CreateTopic(context.Background(), "orders", brain.TopicConfig{Partitions: 2}))
+
+		producer := NewProducer(b, ProducerConfig{
+			Partitioner: PartitionerFunc(func(string, []byte, int) int { return 2 }),
+		})
+		_, err := producer.Send(context.Background(), "orders", nil, []byte("order"))
+		require.Error(t, err)
+	})
+	t.Run("topic metadata is cached", func(t *testing.T) {
+		b := &metadataBroker{Broker: openTestBroker(t, brain.DefaultBrokerConfig())}
+		ctx := context.Background()
+		require.NoError(t, b.CreateTopic(ctx, "orders", brain.TopicConfig{Partitions: 2}))
+
+		producer := NewProducer(b, ProducerConfig{MetadataMaxAge: time.Minute})
+		now := time.Now()
+		producer.metadata.now = func() time.Time { return now }
+		for range 5 {
+			_, err := producer.Send(ctx, "orders", nil, []byte("order"))
+			require.NoError(t, err)
+		}
+		require.Equal(t, 1, b.lookups)
+
+		now = now.Add(time.Minute)
+		_, err := producer.Send(ctx, "orders", nil, []byte("order"))
+		require.NoError(t, err)
+		require.Equal(t, 2, b.lookups)
+
+		// Unknown topics are looked up every time, they may be created
+		_, err = producer.Send(ctx, "refunds", nil, []byte("refund"))
+		require.ErrorIs(t, err, brain.ErrUnknownTopic)
+		require.NoError(t, b.CreateTopic(ctx, "refunds", brain.TopicConfig{Partitions: 1}))
+		_, err = producer.Send(ctx, "refunds", nil, []byte("refund"))
+		require.NoError(t, err)
+	})
+	t.Run("stale metadata is refreshed", func(t *testing.T) {
+		b := &metadataBroker{Broker: openTestBroker(t, brain.DefaultBrokerConfig()), partitions: 4}
+		ctx := context.Background()
+		require.NoError(t, b.CreateTopic(ctx, "orders", brain.TopicConfig{Partitions: 2}))
+
+		producer := NewProducer(b, ProducerConfig{
+			Partitioner: PartitionerFunc(func(_ string, _ []byte, n int) int { return n - 1 }),
+		})
+		// Cached while the topic had 4 partitions
+		_, err := producer.metadata.partitions("orders")
+		require.NoError(t, err)
+		b.partitions = 0
+		partition, err := producer.Send(ctx, "orders", nil, []byte("order"))
+		require.NoError(t, err)
+		require.Equal(t, 1, partition)
+		require.Equal(t, 2, b.lookups)
+	})
+	t.Run("async sends are done in the order of each partition", func(t *testing.T) {
+		b := openTestBroker(t, brain.DefaultBrokerConfig())
+		ctx := context.Background()
+		require.NoError(t, b.CreateTopic(ctx, "orders", brain.TopicConfig{Partitions: 2}))
+
+		producer := NewProducer(b, ProducerConfig{Partitioner: HashPartitioner{}, MaxInFlight: 3})
+		var mu sync.Mutex
+		var errs []error
+		done := make(map[int][]int)
+		for i := range 50 {
+			key := fmt.Sprintf("customer-%d", i%2)
+			err := producer.SendAsync(ctx, "orders", []byte(key), fmt.Appendf(nil, "%s order %d", key, i), time.Time{}, func(partition int, err error) {
+				mu.Lock()
+				defer mu.Unlock()
+				errs = append(errs, err)
+				done[partition] = append(done[partition], i)
+			})
+			require.NoError(t, err)
+		}
+		require.NoError(t, producer.Flush(ctx))
+		require.Len(t, errs, 50)
+		for _, err := range errs {
+			require.NoError(t, err)
+		}
+
+		total := 0
+		for partition := range 2 {
+			require.IsIncreasing(t, done[partition])
+			msgs, err := b.Fetch(ctx, "orders", partition, 0, 1<<20)
+			require.NoError(t, err)
+			require.Len(t, msgs, len(done[partition]))
+			total += len(msgs)
+		}
+		require.Equal(t, 50, total)
+	})
+	t.Run("ordered async sends are appended in order", func(t *testing.T) {
+		b := openTestBroker(t, brain.DefaultBrokerConfig())
+		ctx := context.Background()
+		require.NoError(t, b.CreateTopic(ctx, "orders", brain.TopicConfig{Partitions: 1}))
+
+		producer := NewProducer(jitterBroker{b}, ProducerConfig{MaxInFlight: 5, Ordered: true})
+		var mu sync.Mutex
+		var errs []error
+		for i := range 100 {
+			err := producer.SendAsync(ctx, "orders", nil, fmt.Appendf(nil, "order %d", i), time.Time{}, func(_ int, err error) {
+				mu.Lock()
+				defer mu.Unlock()
+				errs = append(errs, err)
+			})
+			require.NoError(t, err)
+		}
+		require.NoError(t, producer.Flush(ctx))
+		require.Len(t, errs, 100)
+		for _, err := range errs {
+			require.NoError(t, err)
+		}
+
+		msgs, err := b.Fetch(ctx, "orders", 0, 0, 1<<20)
+		require.NoError(t, err)
+		require.Len(t, msgs, 100)
+		for i, msg := range msgs {
+			require.Equal(t, i, msg.Offset)
+			require.Equal(t, fmt.Sprintf("order %d", i), string(msg.Data))
+		}
+	})
+	t.Run("ordered async sends of other partitions don't wait", func(t *testing.T) {
+		b := &blockingBroker{Broker: openTestBroker(t, brain.DefaultBrokerConfig()), release: make(chan struct{})}
+		ctx := context.Background()
+		require.NoError(t, b.CreateTopic(ctx, "orders", brain.TopicConfig{Partitions: 2}))
+
+		byKey := PartitionerFunc(func(_ string, key []byte, _ int) int { return int(key[0] - '0') })
+		producer := NewProducer(b, ProducerConfig{Partitioner: byKey, MaxInFlight: 3, Ordered: true})
+		for _, key := range []string{"0", "0", "1"} {
+			require.NoError(t, producer.SendAsync(ctx, "orders", []byte(key), []byte("order"), time.Time{}, nil))
+		}
+		// The second record of partition 0 waits for the first
+		require.Eventually(t, func() bool { return b.waiting.Load() == 2 }, time.Second, time.Millisecond)
+		require.Never(t, func() bool { return b.waiting.Load() > 2 }, 20*time.Millisecond, time.Millisecond)
+
+		close(b.release)
+		require.NoError(t, producer.Flush(ctx))
+		require.EqualValues(t, 3, b.waiting.Load())
+	})
+	t.Run("async sends wait for room in flight", func(t *testing.T) {
+		b := &blockingBroker{Broker: openTestBroker(t, brain.DefaultBrokerConfig()), release: make(chan struct{})}
+		ctx := context.Background()
+		require.NoError(t, b.CreateTopic(ctx, "orders", brain.TopicConfig{Partitions: 2}))
+
+		producer := NewProducer(b, ProducerConfig{MaxInFlight: 2})
+		for range 2 {
+			require.NoError(t, producer.SendAsync(ctx, "orders", nil, []byte("order"), time.Time{}, nil))
+		}
+		// Both are sent to the partition at once
+		require.Eventually(t, func() bool { return b.waiting.Load() == 2 }, time.Second, time.Millisecond)
+		timeout, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+		defer cancel()
+		require.ErrorIs(t, producer.SendAsync(timeout, "orders", nil, []byte("order"), time.Time{}, nil), context.DeadlineExceeded)
+		require.ErrorIs(t, producer.Flush(timeout), context.DeadlineExceeded)
+
+		close(b.release)
+		require.NoError(t, producer.Flush(ctx))
+		msgs, err := b.Fetch(ctx, "orders", 0, 0, 1<<20)
+		require.NoError(t, err)
+		require.Len(t, msgs, 2)
+	})
+	t.Run("compressed records are stored in the topic's codec", func(t *testing.T) {
+		b := openTestBroker(t, brain.DefaultBrokerConfig())
+		ctx := context.Background()
+		require.NoError(t, b.CreateTopic(ctx, "orders", brain.TopicConfig{Partitions: 1}))
+
+		producer := NewProducer(b, ProducerConfig{Compression: brain.CompressionGzip})
+		_, err := producer.Send(ctx, "orders", nil, []byte("order"))
+		require.NoError(t, err)
+		msgs, err := b.Fetch(ctx, "orders", 0, 0, 1<<20)
+		require.NoError(t, err)
+		require.Equal(t, []byte("order"), msgs[0].Data)
+	})
+	t.Run("records over the max request size aren't sent", func(t *testing.T) {
+		b := openTestBroker(t, brain.DefaultBrokerConfig())
+		ctx := context.Background()
+		require.NoError(t, b.CreateTopic(ctx, "orders", brain.TopicConfig{Partitions: 1}))
+
+		producer := NewProducer(b, ProducerConfig{MaxRequestBytes: 8})
+		_, err := producer.Send(ctx, "orders", nil, []byte("a big order"))
+		require.ErrorIs(t, err, brain.ErrMessageTooLarge)
+		_, err = producer.Send(ctx, "orders", nil, []byte("order"))
+		require.NoError(t, err)
+		_, next, err := b.Offsets("orders", 0)
+		require.NoError(t, err)
+		require.Equal(t, 1, next)
+	})
+}
@@ -0,0 +1,153 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/mvaleed/brook/internal/brain"
+)
+
+// Serializer turns the values an application sends into payloads.
+type Serializer interface {
+	Serialize(topic string, v any) ([]byte, error)
+}
+
+// Deserializer turns payloads back into values, decoding into v, which is a
+// pointer.
+type Deserializer interface {
+	Deserialize(topic string, data []byte, v any) error
+}
+
+// Payloads framed with the ID of their schema in the registry start like
+// brain.EncodeSchemaID frames them, so the broker can validate them.
+
+// RawSerializer sends []byte and string values as they are.
+type RawSerializer struct{}
+
+func (RawSerializer) Serialize(topic string, v any) ([]byte, error) {
+	switch v := v.(type) {
+	case []byte:
+		return v, nil
+	case string:
+		return []byte(v), nil
+	default:
+		return nil, fmt.Errorf("raw serializer can't send a %T", v)
+	}
+}
+
+// RawDeserializer decodes payloads into a *[]byte or a *string.
+type RawDeserializer struct{}
+
+func (RawDeserializer) Deserialize(topic string, data []byte, v any) error {
+	switch v := v.(type) {
+	case *[]byte:
+		*v = data
+	case *string:
+		*v = string(data)
+	default:
+		return fmt.Errorf("raw deserializer can't decode into a %T", v)
+	}
+	return nil
+}
+
+// JSONSerializer encodes values with encoding/json, framed with SchemaID if
+// it's set.
+type JSONSerializer struct {
+	SchemaID int
+}
+
+func (s JSONSerializer) Serialize(topic string, v any) ([]byte, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	if s.SchemaID == 0 {
+		return data, nil
+	}
+	return brain.EncodeSchemaID(s.SchemaID, data), nil
+}
+
+// JSONDeserializer decodes JSON payloads, framed with a schema ID or not. JSON
+// never starts with the zero byte of the framing.
+type JSONDeserializer struct{}
+
+func (JSONDeserializer) Deserialize(topic string, data []byte, v any) error {
+	if len(data) > 0 && data[0] == 0 {
+		var err error
+		if _, data, err = brain.DecodeSchemaID(data); err != nil {
+			return err
+		}
+	}
+	return json.Unmarshal(data, v)
+}
+
+// ProtobufSerializer frames the values Marshal encodes, proto.Marshal for
+// instance, with SchemaID and the index of the message in the schema, which
+// is always its first message. That is how Confluent's serializers frame
+// them.
+type ProtobufSerializer struct {
+	SchemaID int
+	Marshal  func(v any) ([]byte, error)
+}
+
+func (s ProtobufSerializer) Serialize(topic string, v any) ([]byte, error) {
+	if s.SchemaID == 0 {
+		return nil, errors.New("protobuf serializer needs a schema id")
+	}
+	data, err := s.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	// A single 0 stands for the message indexes [0]
+	return brain.EncodeSchemaID(s.SchemaID, append([]byte{0}, data...)), nil
+}
+
+// ProtobufDeserializer decodes payloads framed by a ProtobufSerializer with
+// Unmarshal, proto.Unmarshal for instance.
+type ProtobufDeserializer struct {
+	Unmarshal func(data []byte, v any) error
+}
+
+func (d ProtobufDeserializer) Deserialize(topic string, data []byte, v any) error {
+	_, data, err := brain.DecodeSchemaID(data)
+	if err != nil {
+		return err
+	}
+	if len(data) == 0 || data[0] != 0 {
+		return errors.New("protobuf payload isn't of the first message of its schema")
+	}
+	return d.Unmarshal(data[1:], v)
+}
+
+// AvroSerializer frames the values Marshal encodes with SchemaID, the ID of
+// the writer's schema.
+type AvroSerializer struct {
+	SchemaID int
+	Marshal  func(v any) ([]byte, error)
+}
+
+func (s AvroSerializer) Serialize(topic string, v any) ([]byte, error) {
+	if s.SchemaID == 0 {
+		return nil, errors.New("avro serializer needs a schema id")
+	}
+	data, err := s.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return brain.EncodeSchemaID(s.SchemaID, data), nil
+}
+
+// AvroDeserializer decodes payloads framed by an AvroSerializer with
+// Unmarshal, which is handed the ID of the writer's schema to resolve it.
+type AvroDeserializer struct {
+	Unmarshal func(schemaID int, data []byte, v any) error
+}
+
+func (d AvroDeserializer) Deserialize(topic string, data []byte, v any) error {
+	id, data, err := brain.DecodeSchemaID(data)
+	if err != nil {
+		return err
+	}
+	return d.Unmarshal(id, data, v)
+}
@@ -0,0 +1,96 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/mvaleed/brook/internal/brain"
+)
+
+type order struct {
+	ID     int    `json:"id"`
+	Status string `json:"status"`
+}
+
+func TestSerde(t *testing.T) {
+	t.Run("raw", func(t *testing.T) {
+		data, err := RawSerializer{}.Serialize("orders", "hello")
+		require.NoError(t, err)
+		require.Equal(t, "hello", string(data))
+		_, err = RawSerializer{}.Serialize("orders", 42)
+		require.Error(t, err)
+
+		var s string
+		require.NoError(t, RawDeserializer{}.Deserialize("orders", data, &s))
+		require.Equal(t, "hello", s)
+		var b []byte
+		require.NoError(t, RawDeserializer{}.Deserialize("orders", data, &b))
+		require.Equal(t, data, b)
+	})
+	t.Run("json", func(t *testing.T) {
+		for _, id := range []int{0, 7} {
+			data, err := JSONSerializer{SchemaID: id}.Serialize("orders", order{ID: 1, Status: "new"})
+			require.NoError(t, err)
+			var o order
+			require.NoError(t, JSONDeserializer{}.Deserialize("orders", data, &o))
+			require.Equal(t, order{ID: 1, Status: "new"}, o)
+		}
+	})
+	t.Run("protobuf", func(t *testing.T) {
+		// JSON stands in for the protobuf encoding
+		s := ProtobufSerializer{SchemaID: 3, Marshal: json.Marshal}
+		data, err := s.Serialize("orders", order{ID: 1})
+		require.NoError(t, err)
+		require.Equal(t, []byte{0, 0, 0, 0, 3, 0}, data[:6])
+
+		var o order
+		require.NoError(t, ProtobufDeserializer{Unmarshal: json.Unmarshal}.Deserialize("orders", data, &o))
+		require.Equal(t, order{ID: 1}, o)
+
+		_, err = ProtobufSerializer{Marshal: json.Marshal}.Serialize("orders", order{})
+		require.Error(t, err)
+		require.Error(t, ProtobufDeserializer{Unmarshal: json.Unmarshal}.Deserialize("orders", []byte(`{}`), &o))
+	})
+	t.Run("avro", func(t *testing.T) {
+		s := AvroSerializer{SchemaID: 5, Marshal: json.Marshal}
+		data, err := s.Serialize("orders", order{ID: 2})
+		require.NoError(t, err)
+
+		var writer int
+		var o order
+		d := AvroDeserializer{Unmarshal: func(schemaID int, data []byte, v any) error {
+			writer = schemaID
+			return json.Unmarshal(data, v)
+		}}
+		require.NoError(t, d.Deserialize("orders", data, &o))
+		require.Equal(t, 5, writer)
+		require.Equal(t, order{ID: 2}, o)
+	})
+	t.Run("framed payloads pass the broker's validation", func(t *testing.T) {
+		config := brain.DefaultBrokerConfig()
+		config.ValidateSchemas = true
+		b := openTestBroker(t, config)
+		ctx := context.Background()
+		require.NoError(t, b.CreateTopic(ctx, "orders", brain.DefaultTopicConfig()))
+		schema, err := b.RegisterSchema(ctx, "orders", brain.SchemaJSON, `{"type": "object", "required": ["id"]}`)
+		require.NoError(t, err)
+
+		producer := NewProducer(b, ProducerConfig{Serializer: JSONSerializer{SchemaID: schema.ID}})
+		_, err = producer.SendValue(ctx, "orders", nil, order{ID: 1, Status: "paid"})
+		require.NoError(t, err)
+		_, err = producer.SendValue(ctx, "orders", nil, []int{1})
+		require.ErrorIs(t, err, brain.ErrInvalidPayload)
+
+		consumer, err := NewConsumer(b, ConsumerConfig{Group: "billing", Topic: "orders", Deserializer: JSONDeserializer{}})
+		require.NoError(t, err)
+		records, err := consumer.Poll(ctx)
+		require.NoError(t, err)
+		require.Len(t, records, 1)
+		var o order
+		require.NoError(t, consumer.Decode(records[0], &o))
+		require.Equal(t, order{ID: 1, Status: "paid"}, o)
+	})
+}
@@ -0,0 +1,397 @@
+// Package config loads the configuration of a broker from a YAML file, with
+// environment variables overriding its settings. JSON files load too, JSON
+// being YAML.
+//
+// Every scalar setting can be overridden by the variable named after its
+// path in the file, upper cased, with BROOK_ in front: topic_defaults.retention
+// is overridden by BROOK_TOPIC_DEFAULTS_RETENTION. Lists and maps, like the
+// listeners and the quota overrides, can only be set in the file.
+//
+// A Reloader applies the settings that can change while the broker runs,
+// on SIGHUP.
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/mvaleed/brook/internal/brain"
+	"github.com/mvaleed/brook/internal/network"
+	"github.com/mvaleed/brook/internal/storage"
+)
+
+// EnvPrefix starts the names of the variables overriding settings.
+const EnvPrefix = "BROOK_"
+
+// Config is the configuration of a broker.
+type Config struct {
+	// DataDir is where partitions are kept.
+	DataDir string `yaml:"data_dir"`
+	// DataDirs are more data directories, one per disk, new partitions are
+	// spread over along with DataDir.
+	DataDirs []string `yaml:"data_dirs"`
+	// Placement is how new partitions are spread over the data
+	// directories, round-robin or free-space.
+	Placement string `yaml:"placement"`
+	// OpenConcurrency is how many partitions are opened at once when the
+	// broker starts.
+	OpenConcurrency int `yaml:"open_concurrency"`
+	// MaxFetchBytes caps the payload bytes a single fetch reads and
+	// returns, past its first message.
+	MaxFetchBytes int `yaml:"max_fetch_bytes"`
+	// MetaDir is where the broker metadata and the partition checkpoints
+	// are kept, DataDir if empty.
+	MetaDir       string           `yaml:"meta_dir"`
+	Listeners     []ListenerConfig `yaml:"listeners"`
+	TopicDefaults TopicDefaults    `yaml:"topic_defaults"`
+	Segments      SegmentConfig    `yaml:"segments"`
+	Quotas        QuotaConfig      `yaml:"quotas"`
+	// LogLevel is debug, info, warn or error.
+	LogLevel string `yaml:"log_level"`
+}
+
+// ListenerConfig is a network.Config.
+type ListenerConfig struct {
+	Name    string     `yaml:"name"`
+	Address string     `yaml:"address"`
+	TLS     *TLSConfig `yaml:"tls"`
+	// MaxConnections caps the connections open at once, 0 for no limit.
+	MaxConnections int           `yaml:"max_connections"`
+	ReadTimeout    time.Duration `yaml:"read_timeout"`
+	WriteTimeout   time.Duration `yaml:"write_timeout"`
+	IdleTimeout    time.Duration `yaml:"idle_timeout"`
+	// KeepAlive is the TCP keep-alive interval, 15s if zero, negative to
+	// turn it off.
+	KeepAlive time.Duration `yaml:"keep_alive"`
+	// MaxHandlers caps the requests handled at once, 0 for no limit.
+	// HandlerQueueDepth requests wait for a handler past it, the others
+	// are shed with a retriable busy error.
+	MaxHandlers          int `yaml:"max_handlers"`
+	HandlerQueueDepth    int `yaml:"handler_queue_depth"`
+	ConnectionQueueDepth int `yaml:"connection_queue_depth"`
+}
+
+// TLSConfig is a network.TLSConfig.
+type TLSConfig struct {
+	CertFile     string `yaml:"cert_file"`
+	KeyFile      string `yaml:"key_file"`
+	ClientCAFile string `yaml:"client_ca_file"`
+}
+
+// TopicDefaults is the config of the topics created without one.
+type TopicDefaults struct {
+	// AutoCreate creates the topics produced to that don't exist.
+	AutoCreate  bool          `yaml:"auto_create"`
+	Partitions  int           `yaml:"partitions"`
+	Retention   time.Duration `yaml:"retention"`
+	Compression string        `yaml:"compression"`
+	// Durability is async, medium or full.
+	Durability string `yaml:"durability"`
+}
+
+// SegmentConfig sizes the segments of every partition.
+type SegmentConfig struct {
+	MaxBytes    int64         `yaml:"max_bytes"`
+	MaxRecords  int64         `yaml:"max_records"`
+	MaxAge      time.Duration `yaml:"max_age"`
+	Preallocate bool          `yaml:"preallocate"`
+}
+
+// QuotaConfig is a brain.QuotaConfig.
+type QuotaConfig struct {
+	Client     ByteRates            `yaml:"client"`
+	Topic      ByteRates            `yaml:"topic"`
+	Namespace  ByteRates            `yaml:"namespace"`
+	Clients    map[string]ByteRates `yaml:"clients"`
+	Topics     map[string]ByteRates `yaml:"topics"`
+	Namespaces map[string]ByteRates `yaml:"namespaces"`
+}
+
+// ByteRates is a brain.ByteRates.
+type ByteRates struct {
+	ProduceBytesPerSecond int64 `yaml:"produce_bytes_per_second"`
+	FetchBytesPerSecond   int64 `yaml:"fetch_bytes_per_second"`
+}
+
+func (r ByteRates) byteRates() brain.ByteRates {
+	return brain.ByteRates{ProduceBytesPerSecond: r.ProduceBytesPerSecond, FetchBytesPerSecond: r.FetchBytesPerSecond}
+}
+
+// Default is the configuration settings left out of a file take.
+func Default() Config {
+	partition := storage.DefaultPartitionConfig()
+	topic := brain.DefaultTopicConfig()
+	return Config{
+		DataDir:         "data",
+		Placement:       brain.PlacementRoundRobin.String(),
+		OpenConcurrency: brain.DefaultOpenConcurrency,
+		MaxFetchBytes:   brain.DefaultMaxFetchBytes,
+		Listeners:       []ListenerConfig{{Name: "plain", Address: ":9092"}},
+		TopicDefaults: TopicDefaults{
+			Partitions:  topic.Partitions,
+			Compression: string(topic.Compression),
+			Durability:  topic.Durability.String(),
+		},
+		Segments: SegmentConfig{
+			MaxBytes:   partition.MaxSegmentBytes,
+			MaxRecords: partition.MaxSegmentRecords,
+			MaxAge:     partition.MaxSegmentAge,
+		},
+		LogLevel: slog.LevelInfo.String(),
+	}
+}
+
+// Load reads the configuration in the file at path over the defaults, then
+// applies the overrides of environ, a list of KEY=VALUE like os.Environ
+// returns, and validates the result. Settings the file has that Config
+// doesn't are an error, they are likely misspelled.
+func Load(path string, environ []string) (Config, error) {
+	c := Default()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Config{}, err
+	}
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&c); err != nil && !errors.Is(err, io.EOF) {
+		return Config{}, fmt.Errorf("%s: %w", path, err)
+	}
+	if err := c.applyEnv(environ); err != nil {
+		return Config{}, err
+	}
+	if err := c.Validate(); err != nil {
+		return Config{}, fmt.Errorf("%s: %w", path, err)
+	}
+	return c, nil
+}
+
+// applyEnv sets the settings overridden by the variables of environ.
+func (c *Config) applyEnv(environ []string) error {
+	vars := make(map[string]string)
+	for _, kv := range environ {
+		if key, value, ok := strings.Cut(kv, "="); ok && strings.HasPrefix(key, EnvPrefix) {
+			vars[key] = value
+		}
+	}
+	return applyEnv(reflect.ValueOf(c).Elem(), strings.TrimSuffix(EnvPrefix, "_"), vars)
+}
+
+func applyEnv(v reflect.Value, prefix string, vars map[string]string) error {
+	t := v.Type()
+	for i := range t.NumField() {
+		tag, _, _ := strings.Cut(t.Field(i).Tag.Get("yaml"), ",")
+		name := prefix + "_" + strings.ToUpper(tag)
+		field := v.Field(i)
+		if field.Kind() == reflect.Struct && field.Type() != reflect.TypeFor[time.Duration]() {
+			if err := applyEnv(field, name, vars); err != nil {
+				return err
+			}
+			continue
+		}
+		value, ok := vars[name]
+		if !ok {
+			continue
+		}
+		if err := setScalar(field, value); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// setScalar parses value into v, a scalar setting.
+func setScalar(v reflect.Value, value string) error {
+	if v.Type() == reflect.TypeFor[time.Duration]() {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(value)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	default:
+		return fmt.Errorf("a %s can't be set from the environment", v.Kind())
+	}
+	return nil
+}
+
+// Validate checks the configuration is one a broker can start with.
+func (c Config) Validate() error {
+	if c.DataDir == "" {
+		return errors.New("data_dir is required")
+	}
+	names := make(map[string]bool)
+	for i, l := range c.Listeners {
+		if l.Name == "" || l.Address == "" {
+			return fmt.Errorf("listener %d needs a name and an address", i)
+		}
+		if names[l.Name] {
+			return fmt.Errorf("listener %s is defined twice", l.Name)
+		}
+		names[l.Name] = true
+		if l.TLS != nil && (l.TLS.CertFile == "" || l.TLS.KeyFile == "") {
+			return fmt.Errorf("listener %s: tls needs both cert_file and key_file", l.Name)
+		}
+		if l.MaxConnections < 0 {
+			return fmt.Errorf("listener %s: max_connections can't be negative", l.Name)
+		}
+		if l.ReadTimeout < 0 || l.WriteTimeout < 0 || l.IdleTimeout < 0 {
+			return fmt.Errorf("listener %s: read_timeout, write_timeout and idle_timeout can't be negative", l.Name)
+		}
+		if l.MaxHandlers < 0 || l.HandlerQueueDepth < 0 || l.ConnectionQueueDepth < 0 {
+			return fmt.Errorf("listener %s: max_handlers, handler_queue_depth and connection_queue_depth can't be negative", l.Name)
+		}
+	}
+
+	if _, err := c.Level(); err != nil {
+		return err
+	}
+
+	broker, err := c.BrokerConfig()
+	if err != nil {
+		return err
+	}
+	// Checked even without auto_create, a mistake there is still one
+	if err := broker.DefaultTopic.Validate(); err != nil {
+		return fmt.Errorf("topic_defaults: %w", err)
+	}
+	return broker.Validate()
+}
+
+// Paths returns the paths the broker keeps its data under.
+func (c Config) Paths() storage.Paths {
+	return storage.Paths{Data: c.DataDir, Meta: c.MetaDir}
+}
+
+// BrokerConfig returns the config of the broker.
+func (c Config) BrokerConfig() (brain.BrokerConfig, error) {
+	durability, err := parseDurability(c.TopicDefaults.Durability)
+	if err != nil {
+		return brain.BrokerConfig{}, fmt.Errorf("topic_defaults: %w", err)
+	}
+
+	placement, err := parsePlacement(c.Placement)
+	if err != nil {
+		return brain.BrokerConfig{}, err
+	}
+
+	broker := brain.DefaultBrokerConfig()
+	broker.DataDirs = c.DataDirs
+	broker.Placement = placement
+	broker.OpenConcurrency = c.OpenConcurrency
+	broker.MaxFetchBytes = c.MaxFetchBytes
+	broker.Partition.MaxSegmentBytes = c.Segments.MaxBytes
+	broker.Partition.MaxSegmentRecords = c.Segments.MaxRecords
+	broker.Partition.MaxSegmentAge = c.Segments.MaxAge
+	broker.Partition.PreallocateSegments = c.Segments.Preallocate
+	broker.AutoCreateTopics = c.TopicDefaults.AutoCreate
+	broker.DefaultTopic = brain.TopicConfig{
+		Partitions:  c.TopicDefaults.Partitions,
+		Retention:   c.TopicDefaults.Retention,
+		Compression: brain.Compression(c.TopicDefaults.Compression),
+		Durability:  durability,
+	}
+	broker.Quotas = brain.QuotaConfig{
+		Client:    c.Quotas.Client.byteRates(),
+		Topic:     c.Quotas.Topic.byteRates(),
+		Namespace: c.Quotas.Namespace.byteRates(),
+	}
+	if len(c.Quotas.Clients) > 0 {
+		broker.Quotas.Clients = make(map[string]brain.ByteRates, len(c.Quotas.Clients))
+		for id, rates := range c.Quotas.Clients {
+			broker.Quotas.Clients[id] = rates.byteRates()
+		}
+	}
+	if len(c.Quotas.Topics) > 0 {
+		broker.Quotas.Topics = make(map[string]brain.ByteRates, len(c.Quotas.Topics))
+		for name, rates := range c.Quotas.Topics {
+			broker.Quotas.Topics[name] = rates.byteRates()
+		}
+	}
+	if len(c.Quotas.Namespaces) > 0 {
+		broker.Quotas.Namespaces = make(map[string]brain.ByteRates, len(c.Quotas.Namespaces))
+		for name, rates := range c.Quotas.Namespaces {
+			broker.Quotas.Namespaces[name] = rates.byteRates()
+		}
+	}
+	return broker, nil
+}
+
+// Level returns the log level.
+func (c Config) Level() (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(c.LogLevel)); err != nil {
+		return 0, fmt.Errorf("invalid log_level %q, expected debug, info, warn or error", c.LogLevel)
+	}
+	return level, nil
+}
+
+// NetworkConfig returns the config of the listener l.
+func (l ListenerConfig) NetworkConfig() network.Config {
+	config := network.Config{
+		Address:        l.Address,
+		MaxConnections: l.MaxConnections,
+		ReadTimeout:    l.ReadTimeout,
+		WriteTimeout:   l.WriteTimeout,
+		IdleTimeout:    l.IdleTimeout,
+		KeepAlive:      l.KeepAlive,
+	}
+	if l.TLS != nil {
+		config.TLS = &network.TLSConfig{CertFile: l.TLS.CertFile, KeyFile: l.TLS.KeyFile, ClientCAFile: l.TLS.ClientCAFile}
+	}
+	return config
+}
+
+// PoolConfig returns the config of the handler pool of the listener l.
+func (l ListenerConfig) PoolConfig() network.PoolConfig {
+	return network.PoolConfig{
+		Handlers:             l.MaxHandlers,
+		QueueDepth:           l.HandlerQueueDepth,
+		ConnectionQueueDepth: l.ConnectionQueueDepth,
+	}
+}
+
+// parseDurability is the inverse of storage.DurabilityMode.String.
+func parseDurability(s string) (storage.DurabilityMode, error) {
+	for _, mode := range []storage.DurabilityMode{storage.DurabilityAsync, storage.DurabilityMedium, storage.DurabilityFull} {
+		if s == mode.String() {
+			return mode, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid durability %q, expected async, medium or full", s)
+}
+
+// parsePlacement is the inverse of brain.Placement.String.
+func parsePlacement(s string) (brain.Placement, error) {
+	for _, p := range []brain.Placement{brain.PlacementRoundRobin, brain.PlacementFreeSpace} {
+		if s == p.String() {
+			return p, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid placement %q, expected round-robin or free-space", s)
+}
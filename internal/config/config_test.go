@@ -0,0 +1,133 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/mvaleed/brook/internal/brain"
+	"github.com/mvaleed/brook/internal/network"
+	"github.com/mvaleed/brook/internal/storage"
+)
+
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "brook.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+	return path
+}
+
+func TestLoad(t *testing.T) {
+	t.Run("file over the defaults", func(t *testing.T) {
+		path := writeConfig(t, `
+data_dir: /var/lib/brook
+data_dirs: [/mnt/disk1, /mnt/disk2]
+placement: free-space
+open_concurrency: 16
+max_fetch_bytes: 1048576
+listeners:
+  - name: internal
+    address: ":9092"
+    max_connections: 1000
+    idle_timeout: 10m
+    max_handlers: 64
+    handler_queue_depth: 256
+  - name: external
+    address: ":9093"
+    tls:
+      cert_file: broker.pem
+      key_file: broker-key.pem
+topic_defaults:
+  auto_create: true
+  partitions: 3
+  retention: 168h
+  durability: full
+segments:
+  max_bytes: 268435456
+quotas:
+  client:
+    produce_bytes_per_second: 1048576
+  topics:
+    orders:
+      fetch_bytes_per_second: 4096
+`)
+		c, err := Load(path, nil)
+		require.NoError(t, err)
+		require.Equal(t, "/var/lib/brook", c.DataDir)
+		require.Len(t, c.Listeners, 2)
+		require.Equal(t, "broker.pem", c.Listeners[1].NetworkConfig().TLS.CertFile)
+		require.Nil(t, c.Listeners[0].NetworkConfig().TLS)
+		require.Equal(t, 1000, c.Listeners[0].NetworkConfig().MaxConnections)
+		require.Equal(t, 10*time.Minute, c.Listeners[0].NetworkConfig().IdleTimeout)
+		require.Equal(t, network.PoolConfig{Handlers: 64, QueueDepth: 256}, c.Listeners[0].PoolConfig())
+
+		broker, err := c.BrokerConfig()
+		require.NoError(t, err)
+		require.True(t, broker.AutoCreateTopics)
+		require.Equal(t, []string{"/mnt/disk1", "/mnt/disk2"}, broker.DataDirs)
+		require.Equal(t, brain.PlacementFreeSpace, broker.Placement)
+		require.Equal(t, 16, broker.OpenConcurrency)
+		require.Equal(t, 1<<20, broker.MaxFetchBytes)
+		require.Equal(t, brain.TopicConfig{Partitions: 3, Retention: 168 * time.Hour, Compression: brain.CompressionNone, Durability: storage.DurabilityFull}, broker.DefaultTopic)
+		require.Equal(t, int64(268435456), broker.Partition.MaxSegmentBytes)
+		// Left out, so the default
+		require.Equal(t, storage.DefaultPartitionConfig().MaxSegmentRecords, broker.Partition.MaxSegmentRecords)
+		require.Equal(t, int64(1048576), broker.Quotas.Client.ProduceBytesPerSecond)
+		require.Equal(t, int64(4096), broker.Quotas.Topics["orders"].FetchBytesPerSecond)
+	})
+
+	t.Run("environment overrides", func(t *testing.T) {
+		path := writeConfig(t, "data_dir: /var/lib/brook\ntopic_defaults:\n  partitions: 3\n")
+		c, err := Load(path, []string{
+			"BROOK_DATA_DIR=/data",
+			"BROOK_TOPIC_DEFAULTS_RETENTION=24h",
+			"BROOK_TOPIC_DEFAULTS_AUTO_CREATE=true",
+			"BROOK_SEGMENTS_MAX_RECORDS=500",
+			"HOME=/root",
+		})
+		require.NoError(t, err)
+		require.Equal(t, "/data", c.DataDir)
+		require.Equal(t, 24*time.Hour, c.TopicDefaults.Retention)
+		require.True(t, c.TopicDefaults.AutoCreate)
+		require.Equal(t, 3, c.TopicDefaults.Partitions)
+		require.Equal(t, int64(500), c.Segments.MaxRecords)
+
+		_, err = Load(path, []string{"BROOK_SEGMENTS_MAX_AGE=soon"})
+		require.ErrorContains(t, err, "BROOK_SEGMENTS_MAX_AGE")
+	})
+
+	t.Run("empty file", func(t *testing.T) {
+		c, err := Load(writeConfig(t, ""), nil)
+		require.NoError(t, err)
+		require.Equal(t, Default(), c)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		for content, msg := range map[string]string{
+			"data_dri: /data": "field data_dri not found",
+			"topic_defaults:\n  durability: eventually":                                    "invalid durability",
+			"topic_defaults:\n  compression: zstd":                                         "unknown compression",
+			"segments:\n  max_bytes: 0":                                                    "max segment bytes",
+			"quotas:\n  client:\n    fetch_bytes_per_second: -1":                           "can't be negative",
+			"listeners:\n  - name: a\n    address: ':1'\n  - name: a\n    address: ':2'":   "defined twice",
+			"listeners:\n  - name: a\n    address: ':1'\n    tls:\n      cert_file: a.pem": "key_file",
+			"listeners:\n  - name: a\n    address: ':1'\n    max_connections: -1":          "max_connections",
+			"listeners:\n  - name: a\n    address: ':1'\n    read_timeout: -1s":            "read_timeout",
+			"listeners:\n  - name: a\n    address: ':1'\n    max_handlers: -1":             "max_handlers",
+			"data_dir: ''":         "data_dir is required",
+			"log_level: loud":      "invalid log_level",
+			"placement: random":    "invalid placement",
+			"open_concurrency: -1": "open concurrency",
+			"max_fetch_bytes: -1":  "max fetch bytes",
+		} {
+			_, err := Load(writeConfig(t, content), nil)
+			require.ErrorContains(t, err, msg, content)
+		}
+
+		_, err := Load(filepath.Join(t.TempDir(), "missing.yaml"), nil)
+		require.ErrorIs(t, err, os.ErrNotExist)
+	})
+}
@@ -0,0 +1,107 @@
+package config
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"os/signal"
+	"reflect"
+	"syscall"
+
+	"github.com/mvaleed/brook/internal/brain"
+)
+
+// Reloader applies the settings of a config file that can change while the
+// broker runs: the retention of the topics created without a config, the
+// quotas and the log level. The other settings only apply once the broker
+// starts again.
+type Reloader struct {
+	path   string
+	broker *brain.Broker
+	level  *slog.LevelVar
+	logger *slog.Logger
+	// environ returns the overrides, replaced by tests
+	environ func() []string
+	config  Config
+}
+
+// NewReloader returns a Reloader of the file at path, which config was loaded
+// from. The log level is set on level, which may be nil to leave it alone.
+func NewReloader(path string, config Config, b *brain.Broker, level *slog.LevelVar, logger *slog.Logger) *Reloader {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return &Reloader{path: path, broker: b, level: level, logger: logger, environ: os.Environ, config: config}
+}
+
+// Reload loads the file again and applies the settings that can change. An
+// invalid file is an error, and nothing changes then. The broker authorizes
+// the change for the principal of ctx.
+func (r *Reloader) Reload(ctx context.Context) error {
+	c, err := Load(r.path, r.environ())
+	if err != nil {
+		return err
+	}
+	broker, err := c.BrokerConfig()
+	if err != nil {
+		return err
+	}
+	level, err := c.Level()
+	if err != nil {
+		return err
+	}
+
+	if err := r.broker.Reconfigure(ctx, brain.BrokerConfigChange{
+		DefaultRetention: &broker.DefaultTopic.Retention,
+		Quotas:           &broker.Quotas,
+	}); err != nil {
+		return err
+	}
+	if r.level != nil {
+		r.level.Set(level)
+	}
+	if changed := restartSettings(r.config, c); len(changed) > 0 {
+		r.logger.Warn("changed settings apply once the broker starts again", "settings", changed)
+	}
+	r.config = c
+	r.logger.Info("reloaded config", "path", r.path)
+	return nil
+}
+
+// ReloadOnSIGHUP reloads the file every time the process gets a SIGHUP, until
+// ctx is done. A failed reload is logged and the previous settings stay in
+// use.
+func (r *Reloader) ReloadOnSIGHUP(ctx context.Context) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	for {
+		select {
+		case <-hup:
+			if err := r.Reload(ctx); err != nil {
+				r.logger.Error("failed to reload config", "path", r.path, "error", err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// restartSettings returns the top-level settings that differ between
+// previous and current, other than the ones a Reloader applies.
+func restartSettings(previous Config, current Config) []string {
+	for _, c := range []*Config{&previous, &current} {
+		c.TopicDefaults.Retention = 0
+		c.Quotas = QuotaConfig{}
+		c.LogLevel = ""
+	}
+	var changed []string
+	p, c := reflect.ValueOf(previous), reflect.ValueOf(current)
+	for i := range p.NumField() {
+		if !reflect.DeepEqual(p.Field(i).Interface(), c.Field(i).Interface()) {
+			changed = append(changed, p.Type().Field(i).Tag.Get("yaml"))
+		}
+	}
+	return changed
+}
@@ -0,0 +1,60 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/mvaleed/brook/internal/brain"
+	"github.com/mvaleed/brook/internal/storage"
+)
+
+func TestReloader(t *testing.T) {
+	ctx := context.Background()
+	path := writeConfig(t, "topic_defaults:\n  auto_create: true\n")
+	c, err := Load(path, nil)
+	require.NoError(t, err)
+	config, err := c.BrokerConfig()
+	require.NoError(t, err)
+	b, err := brain.OpenBroker(storage.Paths{Data: t.TempDir()}, config)
+	require.NoError(t, err)
+	defer b.Close()
+
+	level := new(slog.LevelVar)
+	var logs bytes.Buffer
+	r := NewReloader(path, c, b, level, slog.New(slog.NewTextHandler(&logs, nil)))
+	r.environ = func() []string { return []string{"BROOK_LOG_LEVEL=debug"} }
+
+	require.NoError(t, os.WriteFile(path, []byte(`
+topic_defaults:
+  auto_create: true
+  retention: 24h
+quotas:
+  clients:
+    app:
+      produce_bytes_per_second: 10
+segments:
+  max_bytes: 1024
+`), 0o644))
+	require.NoError(t, r.Reload(ctx))
+	require.Equal(t, slog.LevelDebug, level.Level())
+	require.Contains(t, logs.String(), "settings=[segments]")
+
+	app := brain.WithClientID(ctx, "app")
+	require.NoError(t, b.Produce(app, "orders", 0, make([]byte, 100)))
+	require.ErrorIs(t, b.Produce(app, "orders", 0, make([]byte, 100)), brain.ErrThrottled)
+	created, err := b.TopicConfig("orders")
+	require.NoError(t, err)
+	require.Equal(t, 24*time.Hour, created.Retention)
+
+	// An invalid file changes nothing
+	require.NoError(t, os.WriteFile(path, []byte("quotas:\n  client:\n    fetch_bytes_per_second: -1\nlog_level: error\n"), 0o644))
+	require.ErrorContains(t, r.Reload(ctx), "can't be negative")
+	require.Equal(t, slog.LevelDebug, level.Level())
+	require.ErrorIs(t, b.Produce(app, "orders", 0, make([]byte, 100)), brain.ErrThrottled)
+}
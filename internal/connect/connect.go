@@ -0,0 +1,199 @@
+// Package connect pipes external systems in and out of the broker. A Source
+// connector publishes what it reads from a system to a topic, a Sink
+// connector writes the messages of a topic to a system. Both checkpoint how
+// far they got in an internal topic and carry on from there when they run
+// again, so delivery is at least once.
+package connect
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/mvaleed/brook/internal/brain"
+	"github.com/mvaleed/brook/internal/client"
+	"github.com/mvaleed/brook/internal/storage"
+)
+
+// Broker is what connectors publish, consume and checkpoint through.
+// *brain.Broker implements it.
+type Broker interface {
+	client.Broker
+	CreateTopic(ctx context.Context, name string, config brain.TopicConfig) error
+}
+
+var _ Broker = (*brain.Broker)(nil)
+
+// SourceRecord is a record read by a Source.
+type SourceRecord struct {
+	// Topic is the topic the record is published to, the connector's one
+	// if empty.
+	Topic string
+	// Key routes the record to a partition of the topic.
+	Key   []byte
+	Value []byte
+	// Position is where the source is once the record is read. It is
+	// checkpointed once the record is published, and handed back to Start
+	// when the connector runs again.
+	Position []byte
+}
+
+// Source reads records from an external system.
+type Source interface {
+	// Start starts reading after position, the last one checkpointed, or
+	// from the beginning if it is nil.
+	Start(ctx context.Context, position []byte) error
+	// Poll returns the next records, none if there are none yet.
+	Poll(ctx context.Context) ([]SourceRecord, error)
+	Close() error
+}
+
+// Committer is a Source that acknowledges what it reads upstream rather
+// than resuming from a position, like a queue.
+type Committer interface {
+	Source
+	// Commit is called once the records Poll returned so far are published
+	// and checkpointed.
+	Commit(ctx context.Context) error
+}
+
+// Sink writes records to an external system.
+type Sink interface {
+	Start(ctx context.Context) error
+	// Put writes records, in order for each partition.
+	Put(ctx context.Context, records []client.Record) error
+	// Flush makes the records put so far durable. Offsets are checkpointed
+	// only after it returns.
+	Flush(ctx context.Context) error
+	Close() error
+}
+
+// BufferingSink is a Sink that holds records back past Flush until it has
+// enough of them to write together, like one writing them to objects.
+type BufferingSink interface {
+	Sink
+	// Offsets returns the offset after the last record written durably, by
+	// partition. They are checkpointed instead of the offsets of the
+	// records flushed.
+	Offsets() map[int]int
+}
+
+const (
+	DefaultOffsetsTopic = "connect-offsets"
+	defaultPollInterval = time.Second
+	checkpointBytes     = 1 << 20
+)
+
+// Config configures a connector.
+type Config struct {
+	// Name identifies the connector, its checkpoints are kept under it.
+	Name string
+	// Topic is the topic a source publishes to, or a sink consumes.
+	Topic string
+	// OffsetsTopic is the topic checkpoints are written to. Defaults to
+	// DefaultOffsetsTopic, it is created on first use.
+	OffsetsTopic string
+	// PollInterval is how long the connector waits once it has caught up.
+	// Defaults to a second.
+	PollInterval time.Duration
+	// Logger defaults to slog.Default().
+	Logger *slog.Logger
+}
+
+func (c Config) withDefaults() (Config, error) {
+	if c.Name == "" || c.Topic == "" {
+		return Config{}, errors.New("connector name and topic are required")
+	}
+	if c.OffsetsTopic == "" {
+		c.OffsetsTopic = DefaultOffsetsTopic
+	}
+	if c.PollInterval <= 0 {
+		c.PollInterval = defaultPollInterval
+	}
+	if c.Logger == nil {
+		c.Logger = slog.Default()
+	}
+	return c, nil
+}
+
+// checkpoint is how far a connector got, as written to the offsets topic.
+// The latest checkpoint of a connector wins.
+type checkpoint struct {
+	Connector string `json:"connector"`
+	// Position is the position of a source
+	Position []byte `json:"position,omitempty"`
+	// Offsets are the offsets a sink carries on from, by partition
+	Offsets map[int]int `json:"offsets,omitempty"`
+}
+
+// loadCheckpoint returns the latest checkpoint of the connector, creating the
+// offsets topic if it doesn't exist yet. Checkpoints are fsynced, they are few
+// and losing one redelivers everything since the one before.
+func loadCheckpoint(ctx context.Context, broker Broker, config Config) (checkpoint, error) {
+	topicConfig := brain.DefaultTopicConfig()
+	topicConfig.Durability = storage.DurabilityFull
+	topicConfig.Compact = true
+	err := broker.CreateTopic(ctx, config.OffsetsTopic, topicConfig)
+	if err != nil && !errors.Is(err, brain.ErrTopicExists) {
+		return checkpoint{}, err
+	}
+
+	latest := checkpoint{Connector: config.Name}
+	offset, _, err := broker.Offsets(config.OffsetsTopic, 0)
+	if err != nil {
+		return checkpoint{}, err
+	}
+	for {
+		msgs, err := broker.Fetch(ctx, config.OffsetsTopic, 0, offset, checkpointBytes)
+		if err != nil {
+			return checkpoint{}, err
+		}
+		if len(msgs) == 0 {
+			return latest, nil
+		}
+		for _, msg := range msgs {
+			var c checkpoint
+			if err := json.Unmarshal(msg.Data, &c); err != nil {
+				return checkpoint{}, fmt.Errorf("offset %d of %s: %w", msg.Offset, config.OffsetsTopic, err)
+			}
+			if c.Connector == config.Name {
+				latest = c
+			}
+			offset = msg.Offset + 1
+		}
+	}
+}
+
+func storeCheckpoint(ctx context.Context, broker Broker, config Config, c checkpoint) error {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	return broker.Produce(ctx, config.OffsetsTopic, 0, data)
+}
+
+// run calls step until ctx is done, waiting for the poll interval whenever
+// step had nothing to do.
+func run(ctx context.Context, config Config, step func(ctx context.Context) (int, error)) error {
+	for {
+		n, err := step(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+		if n > 0 {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(config.PollInterval):
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package connect
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/mvaleed/brook/internal/brain"
+	"github.com/mvaleed/brook/internal/storage"
+)
+
+func openTestBroker(t *testing.T) *brain.Broker {
+	t.Helper()
+	config := brain.DefaultBrokerConfig()
+	config.AutoCreateTopics = true
+	b, err := brain.OpenBroker(storage.Paths{Data: t.TempDir()}, config)
+	require.NoError(t, err)
+	t.Cleanup(func() { b.Close() })
+	return b
+}
+
+func appendFile(t *testing.T, path string, data string) {
+	t.Helper()
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	require.NoError(t, err)
+	_, err = f.WriteString(data)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+}
+
+func topicValues(t *testing.T, b *brain.Broker, topic string) []string {
+	t.Helper()
+	msgs, err := b.Fetch(context.Background(), topic, 0, 0, 1<<20)
+	require.NoError(t, err)
+	var values []string
+	for _, msg := range msgs {
+		values = append(values, string(msg.Data))
+	}
+	return values
+}
+
+func TestSourceConnector(t *testing.T) {
+	ctx := context.Background()
+	b := openTestBroker(t)
+	path := filepath.Join(t.TempDir(), "in.txt")
+	appendFile(t, path, "one\ntwo\nthr")
+
+	start := func() (*SourceConnector, *FileSource) {
+		source := &FileSource{Path: path, MaxLines: 2}
+		c, err := NewSourceConnector(b, source, Config{Name: "lines", Topic: "lines"})
+		require.NoError(t, err)
+		require.NoError(t, c.start(ctx))
+		return c, source
+	}
+
+	c, source := start()
+	n, err := c.step(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 2, n)
+	// The partial line waits for its newline
+	n, err = c.step(ctx)
+	require.NoError(t, err)
+	require.Zero(t, n)
+	appendFile(t, path, "ee\nfour\n")
+	n, err = c.step(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 2, n)
+	require.Equal(t, []string{"one", "two", "three", "four"}, topicValues(t, b, "lines"))
+	require.NoError(t, source.Close())
+
+	// A restart carries on from the checkpoint
+	appendFile(t, path, "five\n")
+	c, source = start()
+	defer source.Close()
+	_, err = c.step(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []string{"one", "two", "three", "four", "five"}, topicValues(t, b, "lines"))
+
+	_, err = NewSourceConnector(b, source, Config{Name: "lines"})
+	require.Error(t, err)
+}
+
+func TestSinkConnector(t *testing.T) {
+	ctx := context.Background()
+	b := openTestBroker(t)
+	require.NoError(t, b.CreateTopic(ctx, "lines", brain.TopicConfig{Partitions: 2}))
+	path := filepath.Join(t.TempDir(), "out.txt")
+
+	start := func() (*SinkConnector, *FileSink) {
+		sink := &FileSink{Path: path}
+		c, err := NewSinkConnector(b, sink, Config{Name: "archive", Topic: "lines"})
+		require.NoError(t, err)
+		require.NoError(t, c.start(ctx))
+		return c, sink
+	}
+
+	require.NoError(t, b.Produce(ctx, "lines", 0, []byte("one")))
+	require.NoError(t, b.Produce(ctx, "lines", 1, []byte("two")))
+	c, sink := start()
+	n, err := c.step(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 2, n)
+	n, err = c.step(ctx)
+	require.NoError(t, err)
+	require.Zero(t, n)
+	require.NoError(t, sink.Close())
+
+	// A restart carries on from the checkpoint
+	require.NoError(t, b.Produce(ctx, "lines", 1, []byte("three")))
+	c, sink = start()
+	n, err = c.step(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 1, n)
+	require.NoError(t, sink.Close())
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, "one\ntwo\nthree\n", string(data))
+
+	// Checkpoints of other connectors don't get in the way
+	require.NoError(t, storeCheckpoint(ctx, b, c.config, checkpoint{Connector: "other", Offsets: map[int]int{0: 42}}))
+	checkpoint, err := loadCheckpoint(ctx, b, c.config)
+	require.NoError(t, err)
+	require.Equal(t, map[int]int{0: 1, 1: 2}, checkpoint.Offsets)
+}
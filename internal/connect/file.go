@@ -0,0 +1,137 @@
+package connect
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+
+	"github.com/mvaleed/brook/internal/client"
+)
+
+const defaultFileSourceLines = 1000
+
+// FileSource reads the lines of a file as records, following it as it
+// grows. Its position is the byte offset after the last line read. A last
+// line without its newline isn't read until the newline is written.
+type FileSource struct {
+	Path string
+	// MaxLines caps the records a Poll returns. Defaults to 1000.
+	MaxLines int
+
+	file   *os.File
+	r      *bufio.Reader
+	offset int64 // of the next line
+}
+
+func (s *FileSource) Start(ctx context.Context, position []byte) error {
+	if position != nil {
+		offset, err := strconv.ParseInt(string(position), 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid file source position %q", position)
+		}
+		s.offset = offset
+	}
+	f, err := os.Open(s.Path)
+	if err != nil {
+		return err
+	}
+	s.file = f
+	return s.seek()
+}
+
+func (s *FileSource) seek() error {
+	if _, err := s.file.Seek(s.offset, io.SeekStart); err != nil {
+		return err
+	}
+	s.r = bufio.NewReader(s.file)
+	return nil
+}
+
+func (s *FileSource) Poll(ctx context.Context) ([]SourceRecord, error) {
+	maxLines := s.MaxLines
+	if maxLines <= 0 {
+		maxLines = defaultFileSourceLines
+	}
+
+	var records []SourceRecord
+	for len(records) < maxLines {
+		line, err := s.r.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			// The partial line is read again once it's complete
+			return records, s.seek()
+		}
+		if err != nil {
+			return records, err
+		}
+		s.offset += int64(len(line))
+		records = append(records, SourceRecord{
+			Value:    bytes.TrimSuffix(line, []byte("\n")),
+			Position: strconv.AppendInt(nil, s.offset, 10),
+		})
+	}
+	return records, nil
+}
+
+func (s *FileSource) Close() error {
+	if s.file == nil {
+		return nil
+	}
+	return s.file.Close()
+}
+
+// FileSink appends the value of every record to a file as a line, or to
+// stdout if Path is "-".
+type FileSink struct {
+	Path string
+
+	file *os.File
+	w    *bufio.Writer
+}
+
+func (s *FileSink) Start(ctx context.Context) error {
+	if s.Path == "-" {
+		s.file = os.Stdout
+	} else {
+		f, err := os.OpenFile(s.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+		if err != nil {
+			return err
+		}
+		s.file = f
+	}
+	s.w = bufio.NewWriter(s.file)
+	return nil
+}
+
+func (s *FileSink) Put(ctx context.Context, records []client.Record) error {
+	for _, r := range records {
+		s.w.Write(r.Value)
+		if err := s.w.WriteByte('\n'); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Flush writes out what is buffered and fsyncs the file. Stdout isn't
+// fsynced, it is usually a pipe or a terminal.
+func (s *FileSink) Flush(ctx context.Context) error {
+	if err := s.w.Flush(); err != nil {
+		return err
+	}
+	if s.file == os.Stdout {
+		return nil
+	}
+	return s.file.Sync()
+}
+
+func (s *FileSink) Close() error {
+	if s.file == nil || s.file == os.Stdout {
+		return nil
+	}
+	return errors.Join(s.w.Flush(), s.file.Close())
+}
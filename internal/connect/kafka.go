@@ -0,0 +1,296 @@
+package connect
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/mvaleed/brook/internal/kafka"
+)
+
+// KafkaRoute mirrors the Kafka topic Source to the topic called Topic, or to
+// the connector's topic if empty.
+type KafkaRoute struct {
+	Source string
+	Topic  string
+}
+
+// KafkaSource mirrors topics of a Kafka cluster, to migrate off it or to run
+// alongside it. Every record is published keyed by its Kafka key, so the
+// records of a key stay in order. Messages have no headers and are
+// timestamped when they're published, set Envelope to keep them. Only
+// committed records are mirrored. The position is the next offset of every
+// partition, checkpointed after the records before it are published.
+//
+// Partitions are looked up when the source starts, the ones added to a topic
+// later are mirrored once it starts again.
+type KafkaSource struct {
+	// Brokers are addresses of brokers of the cluster to look it up from.
+	Brokers []string
+	Options kafka.Options
+	Routes  []KafkaRoute
+	// StartAtLatest makes partitions without a position start at their
+	// end, mirroring only what is written to them from then on. They start
+	// at their first record otherwise.
+	StartAtLatest bool
+	// Envelope publishes every record as a JSON object holding its key,
+	// headers, timestamp and value, rather than its value alone.
+	Envelope bool
+	// MaxBytes caps what a Poll fetches from each partition. Defaults to
+	// 1MiB.
+	MaxBytes int32
+
+	dial       func(ctx context.Context, address string) (kafkaConn, error)
+	conns      map[string]kafkaConn // by address
+	partitions map[kafkaPartitionKey]*kafkaPartition
+	stale      bool // a leader moved, partitions are looked up again
+}
+
+// kafkaConn is a connection to a Kafka broker, *kafka.Conn outside of tests.
+type kafkaConn interface {
+	Metadata(ctx context.Context, topics ...string) (kafka.Metadata, error)
+	ListOffset(ctx context.Context, topic string, partition int32, timestamp int64) (int64, error)
+	Fetch(ctx context.Context, req kafka.FetchRequest) (kafka.FetchResponse, error)
+	Close() error
+}
+
+type kafkaPartitionKey struct {
+	topic string
+	id    int32
+}
+
+// kafkaPartition is a partition being mirrored.
+type kafkaPartition struct {
+	route  KafkaRoute
+	id     int32
+	leader string
+	offset int64 // next to fetch
+}
+
+// kafkaEnvelope is a record published with Envelope.
+type kafkaEnvelope struct {
+	Key       []byte        `json:"key,omitempty"`
+	Headers   []kafkaHeader `json:"headers,omitempty"`
+	Timestamp time.Time     `json:"timestamp"`
+	Value     []byte        `json:"value"`
+}
+
+type kafkaHeader struct {
+	Key   string `json:"key"`
+	Value []byte `json:"value"`
+}
+
+func (s *KafkaSource) Start(ctx context.Context, position []byte) error {
+	if len(s.Brokers) == 0 || len(s.Routes) == 0 {
+		return errors.New("kafka source needs brokers and routes")
+	}
+	if s.dial == nil {
+		s.dial = func(ctx context.Context, address string) (kafkaConn, error) {
+			return kafka.Dial(ctx, address, s.Options)
+		}
+	}
+	offsets := make(map[string]int64)
+	if position != nil {
+		if err := json.Unmarshal(position, &offsets); err != nil {
+			return fmt.Errorf("invalid kafka source position: %w", err)
+		}
+	}
+	s.conns = make(map[string]kafkaConn)
+	s.partitions = make(map[kafkaPartitionKey]*kafkaPartition)
+	return s.lookup(ctx, offsets)
+}
+
+// lookup finds the leader of every partition of the routes' topics. The ones
+// not mirrored yet start at their offset in offsets, keyed by topic/partition,
+// or at the start or the end of the partition.
+func (s *KafkaSource) lookup(ctx context.Context, offsets map[string]int64) error {
+	topics := make([]string, len(s.Routes))
+	for i, route := range s.Routes {
+		topics[i] = route.Source
+	}
+	var metadata kafka.Metadata
+	var err error
+	for _, address := range s.Brokers {
+		var c kafkaConn
+		if c, err = s.conn(ctx, address); err != nil {
+			continue
+		}
+		if metadata, err = c.Metadata(ctx, topics...); err == nil {
+			break
+		}
+		s.drop(address)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to look up kafka topics: %w", err)
+	}
+
+	routes := make(map[string]KafkaRoute, len(s.Routes))
+	for _, route := range s.Routes {
+		routes[route.Source] = route
+	}
+	for _, topic := range metadata.Topics {
+		if topic.Err != nil {
+			return fmt.Errorf("kafka topic %s: %w", topic.Name, topic.Err)
+		}
+		for _, pm := range topic.Partitions {
+			if pm.Err != nil && !errors.Is(pm.Err, kafka.ErrLeaderNotAvailable) {
+				return fmt.Errorf("kafka partition %s/%d: %w", topic.Name, pm.ID, pm.Err)
+			}
+			key := kafkaPartitionKey{topic: topic.Name, id: pm.ID}
+			p, ok := s.partitions[key]
+			if !ok {
+				p = &kafkaPartition{route: routes[topic.Name], id: pm.ID, offset: -1}
+				if offset, ok := offsets[partitionName(key)]; ok {
+					p.offset = offset
+				}
+				s.partitions[key] = p
+			}
+			// Without a leader it is fetched once there is one
+			p.leader = metadata.Brokers[pm.Leader]
+		}
+	}
+	s.stale = false
+	return nil
+}
+
+func partitionName(key kafkaPartitionKey) string {
+	return key.topic + "/" + strconv.Itoa(int(key.id))
+}
+
+// conn returns the connection to the broker at address, connecting first if
+// there is none.
+func (s *KafkaSource) conn(ctx context.Context, address string) (kafkaConn, error) {
+	if c, ok := s.conns[address]; ok {
+		return c, nil
+	}
+	c, err := s.dial(ctx, address)
+	if err != nil {
+		return nil, err
+	}
+	s.conns[address] = c
+	return c, nil
+}
+
+// drop closes the connection to address, after it failed.
+func (s *KafkaSource) drop(address string) {
+	if c, ok := s.conns[address]; ok {
+		c.Close()
+		delete(s.conns, address)
+	}
+}
+
+// Poll fetches from every partition once. A partition whose leader moved is
+// skipped until the next Poll, which looks the leaders up again. A partition
+// whose offset is gone, its records deleted by retention before they were
+// mirrored, starts again at its first record.
+func (s *KafkaSource) Poll(ctx context.Context) ([]SourceRecord, error) {
+	if s.stale {
+		if err := s.lookup(ctx, nil); err != nil {
+			return nil, err
+		}
+	}
+
+	var records []SourceRecord
+	for key, p := range s.partitions {
+		if p.leader == "" {
+			s.stale = true
+			continue
+		}
+		c, err := s.conn(ctx, p.leader)
+		if err != nil {
+			return nil, err
+		}
+		if p.offset < 0 {
+			start := kafka.Earliest
+			if s.StartAtLatest {
+				start = kafka.Latest
+			}
+			if p.offset, err = c.ListOffset(ctx, key.topic, p.id, start); err != nil {
+				p.offset = -1
+				if err = s.fail(p, err); err != nil {
+					return nil, err
+				}
+				continue
+			}
+		}
+
+		resp, err := c.Fetch(ctx, kafka.FetchRequest{Topic: key.topic, Partition: p.id, Offset: p.offset, MaxBytes: s.MaxBytes})
+		if errors.Is(err, kafka.ErrOffsetOutOfRange) {
+			if p.offset, err = c.ListOffset(ctx, key.topic, p.id, kafka.Earliest); err != nil {
+				p.offset = -1
+				if err = s.fail(p, err); err != nil {
+					return nil, err
+				}
+			}
+			continue
+		}
+		if err != nil {
+			if err = s.fail(p, err); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		for _, r := range resp.Records {
+			value := r.Value
+			if s.Envelope {
+				if value, err = json.Marshal(newKafkaEnvelope(r)); err != nil {
+					return nil, err
+				}
+			}
+			records = append(records, SourceRecord{Topic: p.route.Topic, Key: r.Key, Value: value})
+		}
+		p.offset = resp.Next
+	}
+
+	// The position is only checkpointed after the last record
+	if len(records) > 0 {
+		offsets := make(map[string]int64, len(s.partitions))
+		for key, p := range s.partitions {
+			if p.offset >= 0 {
+				offsets[partitionName(key)] = p.offset
+			}
+		}
+		position, err := json.Marshal(offsets)
+		if err != nil {
+			return nil, err
+		}
+		records[len(records)-1].Position = position
+	}
+	return records, nil
+}
+
+// fail handles an error fetching p: nil if the partition moved and has to be
+// looked up again, err otherwise.
+func (s *KafkaSource) fail(p *kafkaPartition, err error) error {
+	var kerr kafka.Error
+	if errors.As(err, &kerr) && kerr.Stale() {
+		s.stale = true
+		return nil
+	}
+	if !errors.As(err, &kerr) {
+		// The connection is broken, or in the middle of a response
+		s.drop(p.leader)
+	}
+	return err
+}
+
+func newKafkaEnvelope(r kafka.Record) kafkaEnvelope {
+	e := kafkaEnvelope{Key: r.Key, Timestamp: r.Timestamp.UTC(), Value: r.Value}
+	for _, h := range r.Headers {
+		e.Headers = append(e.Headers, kafkaHeader(h))
+	}
+	return e
+}
+
+func (s *KafkaSource) Close() error {
+	var errs []error
+	for address, c := range s.conns {
+		errs = append(errs, c.Close())
+		delete(s.conns, address)
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,186 @@
+package connect
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/mvaleed/brook/internal/kafka"
+)
+
+// fakeKafka is a cluster of two brokers, a and b, whose partitions are
+// slices of records.
+type fakeKafka struct {
+	mu sync.Mutex
+	// topics holds the records of each partition, their offsets starting
+	// at first
+	topics map[string][][]kafka.Record
+	first  int64
+	// leaders are the brokers leading the partitions of each topic
+	leaders map[string][]string
+	dials   int
+}
+
+type fakeKafkaConn struct {
+	cluster *fakeKafka
+	address string
+}
+
+func (f *fakeKafka) dial(ctx context.Context, address string) (kafkaConn, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.dials++
+	return &fakeKafkaConn{cluster: f, address: address}, nil
+}
+
+func (f *fakeKafka) produce(topic string, partition int, key, value string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	records := f.topics[topic][partition]
+	r := kafka.Record{
+		Offset:    f.first + int64(len(records)),
+		Timestamp: time.UnixMilli(int64(1000 + len(records))),
+		Value:     []byte(value),
+		Headers:   []kafka.Header{{Key: "source", Value: []byte("test")}},
+	}
+	if key != "" {
+		r.Key = []byte(key)
+	}
+	f.topics[topic][partition] = append(records, r)
+}
+
+func (c *fakeKafkaConn) Metadata(ctx context.Context, topics ...string) (kafka.Metadata, error) {
+	c.cluster.mu.Lock()
+	defer c.cluster.mu.Unlock()
+	m := kafka.Metadata{Brokers: map[int32]string{1: "a", 2: "b"}}
+	for _, name := range topics {
+		topic := kafka.TopicMetadata{Name: name}
+		if _, ok := c.cluster.topics[name]; !ok {
+			topic.Err = kafka.ErrUnknownTopicOrPartition
+		}
+		for id, leader := range c.cluster.leaders[name] {
+			node := int32(1)
+			if leader == "b" {
+				node = 2
+			}
+			topic.Partitions = append(topic.Partitions, kafka.PartitionMetadata{ID: int32(id), Leader: node})
+		}
+		m.Topics = append(m.Topics, topic)
+	}
+	return m, nil
+}
+
+func (c *fakeKafkaConn) ListOffset(ctx context.Context, topic string, partition int32, timestamp int64) (int64, error) {
+	c.cluster.mu.Lock()
+	defer c.cluster.mu.Unlock()
+	if c.cluster.leaders[topic][partition] != c.address {
+		return 0, kafka.ErrNotLeaderForPartition
+	}
+	if timestamp == kafka.Latest {
+		return c.cluster.first + int64(len(c.cluster.topics[topic][partition])), nil
+	}
+	return c.cluster.first, nil
+}
+
+func (c *fakeKafkaConn) Fetch(ctx context.Context, req kafka.FetchRequest) (kafka.FetchResponse, error) {
+	c.cluster.mu.Lock()
+	defer c.cluster.mu.Unlock()
+	if c.cluster.leaders[req.Topic][req.Partition] != c.address {
+		return kafka.FetchResponse{}, kafka.ErrNotLeaderForPartition
+	}
+	records := c.cluster.topics[req.Topic][req.Partition]
+	end := c.cluster.first + int64(len(records))
+	if req.Offset < c.cluster.first || req.Offset > end {
+		return kafka.FetchResponse{}, kafka.ErrOffsetOutOfRange
+	}
+	return kafka.FetchResponse{Records: records[req.Offset-c.cluster.first:], Next: end, HighWatermark: end}, nil
+}
+
+func (c *fakeKafkaConn) Close() error {
+	return nil
+}
+
+func TestKafkaSource(t *testing.T) {
+	ctx := context.Background()
+	b := openTestBroker(t)
+	cluster := &fakeKafka{
+		topics:  map[string][][]kafka.Record{"orders": {nil, nil}, "payments": {nil}},
+		leaders: map[string][]string{"orders": {"a", "b"}, "payments": {"b"}},
+	}
+	cluster.produce("orders", 0, "user-1", "order 1")
+	cluster.produce("orders", 1, "user-2", "order 2")
+	cluster.produce("payments", 0, "", "payment 1")
+
+	start := func(envelope bool) (*SourceConnector, *KafkaSource) {
+		source := &KafkaSource{
+			Brokers:  []string{"a"},
+			Routes:   []KafkaRoute{{Source: "orders", Topic: "mirrored-orders"}, {Source: "payments"}},
+			Envelope: envelope,
+			dial:     cluster.dial,
+		}
+		c, err := NewSourceConnector(b, source, Config{Name: "mirror", Topic: "mirrored-payments"})
+		require.NoError(t, err)
+		require.NoError(t, c.start(ctx))
+		return c, source
+	}
+
+	c, source := start(false)
+	n, err := c.step(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 3, n)
+	require.ElementsMatch(t, []string{"order 1", "order 2"}, topicValues(t, b, "mirrored-orders"))
+	require.Equal(t, []string{"payment 1"}, topicValues(t, b, "mirrored-payments"))
+	n, err = c.step(ctx)
+	require.NoError(t, err)
+	require.Zero(t, n)
+
+	// A partition moving to another broker is looked up again
+	cluster.mu.Lock()
+	cluster.leaders["orders"][1] = "a"
+	cluster.mu.Unlock()
+	cluster.produce("orders", 1, "user-2", "order 3")
+	n, err = c.step(ctx)
+	require.NoError(t, err)
+	require.Zero(t, n)
+	n, err = c.step(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 1, n)
+	require.NoError(t, source.Close())
+
+	// A restart carries on from the checkpoint, records in an envelope
+	cluster.produce("payments", 0, "user-1", "payment 2")
+	c, source = start(true)
+	defer source.Close()
+	n, err = c.step(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 1, n)
+	payments := topicValues(t, b, "mirrored-payments")
+	require.Len(t, payments, 2)
+	var envelope kafkaEnvelope
+	require.NoError(t, json.Unmarshal([]byte(payments[1]), &envelope))
+	require.Equal(t, kafkaEnvelope{
+		Key:       []byte("user-1"),
+		Headers:   []kafkaHeader{{Key: "source", Value: []byte("test")}},
+		Timestamp: time.UnixMilli(1001).UTC(),
+		Value:     []byte("payment 2"),
+	}, envelope)
+
+	// Once the offsets mirrored are gone, every partition now starting at
+	// offset 10, partitions start again at their first record
+	cluster.mu.Lock()
+	cluster.first = 10
+	cluster.mu.Unlock()
+	n, err = c.step(ctx)
+	require.NoError(t, err)
+	require.Zero(t, n)
+	n, err = c.step(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 5, n)
+
+	require.ErrorIs(t, (&KafkaSource{Brokers: []string{"a"}, Routes: []KafkaRoute{{Source: "missing"}}, dial: cluster.dial}).Start(ctx, nil),
+		kafka.ErrUnknownTopicOrPartition)
+}
@@ -0,0 +1,166 @@
+package connect
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/mvaleed/brook/internal/client"
+	"github.com/mvaleed/brook/internal/mqtt"
+)
+
+const defaultMQTTMessages = 1000
+
+// MQTTRoute sends the MQTT messages whose topic matches Filter, which can
+// have + and # wildcards, to the topic called Topic, or to the connector's
+// topic if empty.
+type MQTTRoute struct {
+	Filter string
+	Topic  string
+}
+
+// MQTTSource bridges MQTT messages into topics: it subscribes to the filters
+// of its routes at QoS 1, publishes every message to the topic of the first
+// route matching it, keyed by its MQTT topic so that the messages of a device
+// stay in order, and acknowledges it once it's published. The session has to
+// be persistent, without Options.CleanSession, for the server to keep the
+// messages not acknowledged while the connector is down.
+type MQTTSource struct {
+	Address string
+	Options mqtt.Options
+	Routes  []MQTTRoute
+	// MaxMessages caps the records a Poll returns. Defaults to 1000.
+	MaxMessages int
+
+	client *mqtt.Client
+	polled []mqtt.Message // not acknowledged yet
+}
+
+var _ Committer = (*MQTTSource)(nil)
+
+// Start connects and subscribes. MQTT sources have no position, it is
+// ignored.
+func (s *MQTTSource) Start(ctx context.Context, position []byte) error {
+	if len(s.Routes) == 0 {
+		return errors.New("mqtt source has no routes")
+	}
+	c, err := mqtt.Dial(ctx, s.Address, s.Options)
+	if err != nil {
+		return err
+	}
+	subs := make([]mqtt.Subscription, 0, len(s.Routes))
+	for _, route := range s.Routes {
+		subs = append(subs, mqtt.Subscription{Filter: route.Filter, QoS: 1})
+	}
+	if err := c.Subscribe(ctx, subs...); err != nil {
+		c.Close()
+		return err
+	}
+	s.client = c
+	s.polled = nil
+	return nil
+}
+
+func (s *MQTTSource) Poll(ctx context.Context) ([]SourceRecord, error) {
+	maxMessages := s.MaxMessages
+	if maxMessages <= 0 {
+		maxMessages = defaultMQTTMessages
+	}
+
+	var records []SourceRecord
+	for len(records) < maxMessages {
+		var m mqtt.Message
+		select {
+		case msg, ok := <-s.client.Messages():
+			if !ok {
+				return records, s.client.Err()
+			}
+			m = msg
+		default:
+			return records, nil
+		}
+
+		s.polled = append(s.polled, m)
+		route, ok := s.route(m.Topic)
+		if !ok {
+			// Overlapping subscriptions the server widened, acknowledged
+			// with the rest
+			continue
+		}
+		records = append(records, SourceRecord{Topic: route.Topic, Key: []byte(m.Topic), Value: m.Payload})
+	}
+	return records, nil
+}
+
+func (s *MQTTSource) route(topic string) (MQTTRoute, bool) {
+	for _, route := range s.Routes {
+		if mqtt.Match(route.Filter, topic) {
+			return route, true
+		}
+	}
+	return MQTTRoute{}, false
+}
+
+// Commit acknowledges the messages polled so far.
+func (s *MQTTSource) Commit(ctx context.Context) error {
+	for len(s.polled) > 0 {
+		if err := s.client.Ack(s.polled[0]); err != nil {
+			return err
+		}
+		s.polled = s.polled[1:]
+	}
+	return nil
+}
+
+func (s *MQTTSource) Close() error {
+	if s.client == nil {
+		return nil
+	}
+	return s.client.Close()
+}
+
+// MQTTSink bridges a topic out to MQTT, publishing the value of every message
+// to the MQTT topic Topic.
+type MQTTSink struct {
+	Address string
+	Options mqtt.Options
+	Topic   string
+	// QoS is 0 or 1. At 1 every message is acknowledged by the server
+	// before its offset is checkpointed.
+	QoS byte
+
+	client *mqtt.Client
+}
+
+func (s *MQTTSink) Start(ctx context.Context) error {
+	if s.QoS > 1 {
+		return fmt.Errorf("unsupported mqtt qos %d", s.QoS)
+	}
+	c, err := mqtt.Dial(ctx, s.Address, s.Options)
+	if err != nil {
+		return err
+	}
+	s.client = c
+	return nil
+}
+
+func (s *MQTTSink) Put(ctx context.Context, records []client.Record) error {
+	for _, r := range records {
+		if err := s.client.Publish(ctx, mqtt.Message{Topic: s.Topic, Payload: r.Value, QoS: s.QoS}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Flush does nothing, Put has waited for the server already.
+func (s *MQTTSink) Flush(ctx context.Context) error {
+	return nil
+}
+
+func (s *MQTTSink) Close() error {
+	if s.client == nil {
+		return nil
+	}
+	return s.client.Close()
+}
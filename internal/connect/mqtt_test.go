@@ -0,0 +1,115 @@
+package connect
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/mvaleed/brook/internal/mqtt"
+)
+
+// fakeMQTTServer accepts one connection and speaks just enough MQTT to it for
+// a source: it accepts the CONNECT and SUBSCRIBE, and sends what it's given.
+type fakeMQTTServer struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func startFakeMQTTServer(t *testing.T) (string, <-chan *fakeMQTTServer) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { ln.Close() })
+
+	servers := make(chan *fakeMQTTServer, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		t.Cleanup(func() { conn.Close() })
+		s := &fakeMQTTServer{t: t, conn: conn, r: bufio.NewReader(conn)}
+		s.read() // CONNECT
+		conn.Write([]byte{0x20, 2, 0, 0})
+		_, body := s.read() // SUBSCRIBE
+		suback := []byte{0x90, 2, body[0], body[1]}
+		for filters := body[2:]; len(filters) > 0; {
+			n := binary.BigEndian.Uint16(filters)
+			filters = filters[2+n+1:]
+			suback = append(suback, 1)
+			suback[1]++
+		}
+		conn.Write(suback)
+		servers <- s
+	}()
+	return ln.Addr().String(), servers
+}
+
+// read returns the first byte and the body of a packet, which the tests keep
+// under 128 bytes.
+func (s *fakeMQTTServer) read() (byte, []byte) {
+	header := make([]byte, 2)
+	_, err := io.ReadFull(s.r, header)
+	require.NoError(s.t, err)
+	body := make([]byte, header[1])
+	_, err = io.ReadFull(s.r, body)
+	require.NoError(s.t, err)
+	return header[0], body
+}
+
+// publish sends a QoS 1 message with packet id id.
+func (s *fakeMQTTServer) publish(topic string, payload string, id uint16) {
+	body := binary.BigEndian.AppendUint16(nil, uint16(len(topic)))
+	body = append(body, topic...)
+	body = binary.BigEndian.AppendUint16(body, id)
+	body = append(body, payload...)
+	_, err := s.conn.Write(append([]byte{0x32, byte(len(body))}, body...))
+	require.NoError(s.t, err)
+}
+
+func TestMQTTSource(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	b := openTestBroker(t)
+
+	addr, servers := startFakeMQTTServer(t)
+	source := &MQTTSource{
+		Address: addr,
+		Options: mqtt.Options{ClientID: "bridge"},
+		Routes: []MQTTRoute{
+			{Filter: "sensors/+/temp", Topic: "temperatures"},
+			{Filter: "sensors/#"},
+		},
+	}
+	c, err := NewSourceConnector(b, source, Config{Name: "sensors", Topic: "sensors"})
+	require.NoError(t, err)
+	require.NoError(t, c.start(ctx))
+	defer source.Close()
+	s := <-servers
+
+	s.publish("sensors/a/temp", "21.5", 1)
+	s.publish("sensors/a/humidity", "40", 2)
+	require.Eventually(t, func() bool { return len(source.client.Messages()) == 2 }, 5*time.Second, time.Millisecond)
+
+	n, err := c.step(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 2, n)
+	require.Equal(t, []string{"21.5"}, topicValues(t, b, "temperatures"))
+	require.Equal(t, []string{"40"}, topicValues(t, b, "sensors"))
+
+	// Acknowledged once published
+	for _, id := range []byte{1, 2} {
+		kind, body := s.read()
+		require.Equal(t, byte(0x40), kind)
+		require.Equal(t, []byte{0, id}, body)
+	}
+
+	require.Error(t, (&MQTTSource{Address: addr}).Start(ctx, nil))
+}
@@ -0,0 +1,178 @@
+package connect
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/mvaleed/brook/internal/client"
+	"github.com/mvaleed/brook/internal/export"
+	"github.com/mvaleed/brook/internal/query"
+	"github.com/mvaleed/brook/internal/storage"
+)
+
+const (
+	defaultObjectBytes = 64 << 20
+	defaultObjectAge   = 5 * time.Minute
+)
+
+// ObjectSink archives the messages of a topic to an object store (S3, GCS,
+// ...), batching the messages of each partition into objects of JSON lines
+// or Parquet files. An object is written once it holds MaxBytes, or once its
+// first message has waited MaxAge. The object of partition n starting at
+// offset o is <Prefix>/<n>/<o>.<Format>, with o padded to 20 digits so that
+// objects list in order. A batch written again after a restart overwrites
+// its object.
+type ObjectSink struct {
+	Store  storage.ObjectStore
+	Prefix string
+	// Format is export.JSONL, the default, or export.Parquet, written like
+	// brook export writes it: a row group of the columns offset, timestamp
+	// and payload per object.
+	Format export.Format
+	// MaxBytes defaults to 64MiB.
+	MaxBytes int
+	// MaxAge defaults to 5 minutes.
+	MaxAge time.Duration
+
+	batches map[int]*objectBatch // being filled
+	ready   []*objectBatch       // full, to be written
+	offsets map[int]int          // after the last batch written
+	now     func() time.Time
+}
+
+var _ BufferingSink = (*ObjectSink)(nil)
+
+type objectBatch struct {
+	partition int
+	first     int
+	next      int
+	started   time.Time
+	buf       bytes.Buffer
+	size      int                   // of the messages in buf or buffered by parquet
+	parquet   *export.ParquetWriter // to buf, nil for JSON lines
+}
+
+// objectLine is a message as archived.
+type objectLine struct {
+	Partition int       `json:"partition"`
+	Offset    int       `json:"offset"`
+	Timestamp time.Time `json:"timestamp"`
+	Value     []byte    `json:"value"`
+}
+
+func (s *ObjectSink) Start(ctx context.Context) error {
+	switch s.Format {
+	case "":
+		s.Format = export.JSONL
+	case export.JSONL, export.Parquet:
+	default:
+		return fmt.Errorf("unsupported object format %q, expected %s or %s", s.Format, export.JSONL, export.Parquet)
+	}
+	if s.MaxBytes <= 0 {
+		s.MaxBytes = defaultObjectBytes
+	}
+	if s.MaxAge <= 0 {
+		s.MaxAge = defaultObjectAge
+	}
+	if s.now == nil {
+		s.now = time.Now
+	}
+	s.batches = make(map[int]*objectBatch)
+	s.ready = nil
+	s.offsets = make(map[int]int)
+	return nil
+}
+
+func (s *ObjectSink) Put(ctx context.Context, records []client.Record) error {
+	for _, r := range records {
+		batch, ok := s.batches[r.Partition]
+		if !ok {
+			batch = &objectBatch{partition: r.Partition, first: r.Offset, started: s.now()}
+			if s.Format == export.Parquet {
+				// The whole object in a single row group
+				batch.parquet = export.NewParquetWriter(&batch.buf, s.MaxBytes)
+			}
+			s.batches[r.Partition] = batch
+		}
+		if batch.parquet != nil {
+			if err := batch.parquet.Write(query.Row{Offset: r.Offset, Timestamp: r.Timestamp, Payload: r.Value}); err != nil {
+				return err
+			}
+			// The offset and the timestamp take 8 bytes each, the length
+			// of the payload 4
+			batch.size += 20 + len(r.Value)
+		} else {
+			line, err := json.Marshal(objectLine{Partition: r.Partition, Offset: r.Offset, Timestamp: r.Timestamp, Value: r.Value})
+			if err != nil {
+				return err
+			}
+			batch.buf.Write(line)
+			batch.buf.WriteByte('\n')
+			batch.size = batch.buf.Len()
+		}
+		batch.next = r.Offset + 1
+
+		if batch.size >= s.MaxBytes {
+			if err := s.seal(batch); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// seal ends the object of batch, to be written next.
+func (s *ObjectSink) seal(batch *objectBatch) error {
+	if batch.parquet != nil {
+		if err := batch.parquet.Close(); err != nil {
+			return err
+		}
+	}
+	s.ready = append(s.ready, batch)
+	delete(s.batches, batch.partition)
+	return nil
+}
+
+// Flush writes the batches that are full or old enough.
+func (s *ObjectSink) Flush(ctx context.Context) error {
+	now := s.now()
+	for _, n := range slices.Sorted(maps.Keys(s.batches)) {
+		batch := s.batches[n]
+		if now.Sub(batch.started) >= s.MaxAge {
+			if err := s.seal(batch); err != nil {
+				return err
+			}
+		}
+	}
+
+	for len(s.ready) > 0 {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		batch := s.ready[0]
+		key := fmt.Sprintf("%s/%d/%020d.%s", s.Prefix, batch.partition, batch.first, s.Format)
+		if err := s.Store.Put(key, bytes.NewReader(batch.buf.Bytes()), int64(batch.buf.Len())); err != nil {
+			return fmt.Errorf("writing object %s: %w", key, err)
+		}
+		s.offsets[batch.partition] = batch.next
+		s.ready = s.ready[1:]
+	}
+	return nil
+}
+
+func (s *ObjectSink) Offsets() map[int]int {
+	return maps.Clone(s.offsets)
+}
+
+// Close drops the batches not written yet, their messages are consumed again
+// from the last checkpoint.
+func (s *ObjectSink) Close() error {
+	s.batches = nil
+	s.ready = nil
+	return nil
+}
@@ -0,0 +1,114 @@
+package connect
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/mvaleed/brook/internal/brain"
+	"github.com/mvaleed/brook/internal/export"
+	"github.com/mvaleed/brook/internal/storage"
+)
+
+func readObjectLines(t *testing.T, path string) []string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	var values []string
+	for _, line := range strings.Split(strings.TrimSuffix(string(data), "\n"), "\n") {
+		var l objectLine
+		require.NoError(t, json.Unmarshal([]byte(line), &l))
+		values = append(values, string(l.Value))
+	}
+	return values
+}
+
+func TestObjectSink(t *testing.T) {
+	ctx := context.Background()
+	b := openTestBroker(t)
+	require.NoError(t, b.CreateTopic(ctx, "events", brain.TopicConfig{Partitions: 2}))
+	bucket := t.TempDir()
+	store, err := storage.NewDirObjectStore(bucket)
+	require.NoError(t, err)
+
+	now := time.Now()
+	start := func() (*SinkConnector, *ObjectSink) {
+		// Three messages of 70 to 90 bytes fill an object
+		sink := &ObjectSink{Store: store, Prefix: "events", MaxBytes: 200, MaxAge: time.Minute, now: func() time.Time { return now }}
+		c, err := NewSinkConnector(b, sink, Config{Name: "archive", Topic: "events"})
+		require.NoError(t, err)
+		require.NoError(t, c.start(ctx))
+		return c, sink
+	}
+
+	c, sink := start()
+	for _, v := range []string{"a", "b", "c", "d"} {
+		require.NoError(t, b.Produce(ctx, "events", 0, []byte(v)))
+	}
+	require.NoError(t, b.Produce(ctx, "events", 1, []byte("x")))
+	_, err = c.step(ctx)
+	require.NoError(t, err)
+
+	full := filepath.Join(bucket, "events", "0", "00000000000000000000.jsonl")
+	require.Equal(t, []string{"a", "b", "c"}, readObjectLines(t, full))
+	checkpoint, err := loadCheckpoint(ctx, b, c.config)
+	require.NoError(t, err)
+	require.Equal(t, map[int]int{0: 3}, checkpoint.Offsets)
+
+	// The rest waits for its batch to get old enough, across a restart
+	require.NoError(t, sink.Close())
+	c, sink = start()
+	defer sink.Close()
+	_, err = c.step(ctx)
+	require.NoError(t, err)
+	require.NoFileExists(t, filepath.Join(bucket, "events", "0", "00000000000000000003.jsonl"))
+
+	now = now.Add(time.Minute)
+	n, err := c.step(ctx)
+	require.NoError(t, err)
+	require.Zero(t, n)
+	require.Equal(t, []string{"d"}, readObjectLines(t, filepath.Join(bucket, "events", "0", "00000000000000000003.jsonl")))
+	require.Equal(t, []string{"x"}, readObjectLines(t, filepath.Join(bucket, "events", "1", "00000000000000000000.jsonl")))
+	checkpoint, err = loadCheckpoint(ctx, b, c.config)
+	require.NoError(t, err)
+	require.Equal(t, map[int]int{0: 4, 1: 1}, checkpoint.Offsets)
+}
+
+func TestObjectSink_Parquet(t *testing.T) {
+	ctx := context.Background()
+	b := openTestBroker(t)
+	require.NoError(t, b.CreateTopic(ctx, "events", brain.TopicConfig{Partitions: 1}))
+	bucket := t.TempDir()
+	store, err := storage.NewDirObjectStore(bucket)
+	require.NoError(t, err)
+
+	// Two messages of 21 to 25 bytes fill an object
+	sink := &ObjectSink{Store: store, Prefix: "events", Format: export.Parquet, MaxBytes: 40}
+	c, err := NewSinkConnector(b, sink, Config{Name: "archive", Topic: "events"})
+	require.NoError(t, err)
+	require.NoError(t, c.start(ctx))
+	defer sink.Close()
+	for _, v := range []string{"a", "bbbbb", "c"} {
+		require.NoError(t, b.Produce(ctx, "events", 0, []byte(v)))
+	}
+	_, err = c.step(ctx)
+	require.NoError(t, err)
+
+	data, err := os.ReadFile(filepath.Join(bucket, "events", "0", "00000000000000000000.parquet"))
+	require.NoError(t, err)
+	require.True(t, bytes.HasPrefix(data, []byte("PAR1")))
+	require.True(t, bytes.HasSuffix(data, []byte("PAR1")))
+	require.Contains(t, string(data), "bbbbb")
+	checkpoint, err := loadCheckpoint(ctx, b, c.config)
+	require.NoError(t, err)
+	require.Equal(t, map[int]int{0: 2}, checkpoint.Offsets)
+
+	require.Error(t, (&ObjectSink{Store: store, Format: export.CSV}).Start(ctx))
+}
@@ -0,0 +1,100 @@
+package connect
+
+import (
+	"context"
+	"maps"
+
+	"github.com/mvaleed/brook/internal/client"
+)
+
+// SinkConnector writes the messages of a topic to a Sink.
+type SinkConnector struct {
+	broker   Broker
+	sink     Sink
+	config   Config
+	consumer *client.Consumer
+	offsets  map[int]int // next offset by partition, as checkpointed last
+}
+
+func NewSinkConnector(broker Broker, sink Sink, config Config) (*SinkConnector, error) {
+	config, err := config.withDefaults()
+	if err != nil {
+		return nil, err
+	}
+	consumer, err := client.NewConsumer(broker, client.ConsumerConfig{Group: config.Name, Topic: config.Topic})
+	if err != nil {
+		return nil, err
+	}
+	return &SinkConnector{broker: broker, sink: sink, config: config, consumer: consumer}, nil
+}
+
+// Run starts the sink and writes the messages of the topic to it from the
+// last checkpoint on, until ctx is done or the sink fails, checkpointing after
+// every flush. The sink is closed when it returns.
+func (c *SinkConnector) Run(ctx context.Context) error {
+	if err := c.start(ctx); err != nil {
+		return err
+	}
+	defer c.sink.Close()
+	return run(ctx, c.config, c.step)
+}
+
+func (c *SinkConnector) start(ctx context.Context) error {
+	checkpoint, err := loadCheckpoint(ctx, c.broker, c.config)
+	if err != nil {
+		return err
+	}
+	// Partitions without a checkpoint start from the beginning
+	for n, offset := range checkpoint.Offsets {
+		if err := c.consumer.Seek(n, offset); err != nil {
+			return err
+		}
+	}
+	c.offsets = checkpoint.Offsets
+	if c.offsets == nil {
+		c.offsets = make(map[int]int)
+	}
+
+	c.config.Logger.Info("starting sink connector",
+		"connector", c.config.Name, "topic", c.config.Topic, "offsets", checkpoint.Offsets)
+	return c.sink.Start(ctx)
+}
+
+// step writes a batch of messages to the sink, flushes it, and checkpoints
+// the offsets after what the sink wrote, returning the number of messages.
+// A BufferingSink is flushed even without new messages, for it to write out
+// what has been waiting for long enough.
+func (c *SinkConnector) step(ctx context.Context) (int, error) {
+	records, err := c.consumer.Poll(ctx)
+	if err != nil {
+		return 0, err
+	}
+	buffering, isBuffering := c.sink.(BufferingSink)
+	if len(records) == 0 && !isBuffering {
+		return 0, nil
+	}
+	if len(records) > 0 {
+		if err := c.sink.Put(ctx, records); err != nil {
+			return 0, err
+		}
+	}
+	if err := c.sink.Flush(ctx); err != nil {
+		return 0, err
+	}
+
+	offsets := maps.Clone(c.offsets)
+	if isBuffering {
+		maps.Copy(offsets, buffering.Offsets())
+	} else {
+		for _, r := range records {
+			offsets[r.Partition] = r.Offset + 1
+		}
+	}
+	if !maps.Equal(offsets, c.offsets) {
+		if err := storeCheckpoint(ctx, c.broker, c.config, checkpoint{Connector: c.config.Name, Offsets: offsets}); err != nil {
+			return 0, err
+		}
+		c.offsets = offsets
+	}
+	return len(records), nil
+}
@@ -0,0 +1,77 @@
+package connect
+
+import (
+	"context"
+
+	"github.com/mvaleed/brook/internal/client"
+)
+
+// SourceConnector publishes the records of a Source to a topic.
+type SourceConnector struct {
+	broker   Broker
+	source   Source
+	config   Config
+	producer *client.Producer
+}
+
+func NewSourceConnector(broker Broker, source Source, config Config) (*SourceConnector, error) {
+	config, err := config.withDefaults()
+	if err != nil {
+		return nil, err
+	}
+	return &SourceConnector{
+		broker:   broker,
+		source:   source,
+		config:   config,
+		producer: client.NewProducer(broker, client.ProducerConfig{}),
+	}, nil
+}
+
+// Run starts the source from the last checkpoint and publishes its records
+// until ctx is done or publishing fails, checkpointing after every batch. The
+// source is closed when it returns.
+func (c *SourceConnector) Run(ctx context.Context) error {
+	if err := c.start(ctx); err != nil {
+		return err
+	}
+	defer c.source.Close()
+	return run(ctx, c.config, c.step)
+}
+
+func (c *SourceConnector) start(ctx context.Context) error {
+	checkpoint, err := loadCheckpoint(ctx, c.broker, c.config)
+	if err != nil {
+		return err
+	}
+	c.config.Logger.Info("starting source connector",
+		"connector", c.config.Name, "topic", c.config.Topic, "position", string(checkpoint.Position))
+	return c.source.Start(ctx, checkpoint.Position)
+}
+
+// step publishes a batch of records and checkpoints the position after them,
+// returning the number of records. A Committer source commits them after.
+func (c *SourceConnector) step(ctx context.Context) (int, error) {
+	records, err := c.source.Poll(ctx)
+	if err != nil || len(records) == 0 {
+		return 0, err
+	}
+	for _, r := range records {
+		topic := r.Topic
+		if topic == "" {
+			topic = c.config.Topic
+		}
+		if _, err := c.producer.Send(ctx, topic, r.Key, r.Value); err != nil {
+			return 0, err
+		}
+	}
+	position := records[len(records)-1].Position
+	if err := storeCheckpoint(ctx, c.broker, c.config, checkpoint{Connector: c.config.Name, Position: position}); err != nil {
+		return 0, err
+	}
+	if committer, ok := c.source.(Committer); ok {
+		if err := committer.Commit(ctx); err != nil {
+			return 0, err
+		}
+	}
+	return len(records), nil
+}
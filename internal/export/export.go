@@ -0,0 +1,125 @@
+// Package export writes records of partitions to the files downstream
+// analytics tools read: JSON lines, CSV or Parquet. Records are written as
+// they are read, only Parquet buffers them, a row group at a time.
+package export
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"iter"
+	"strconv"
+	"time"
+
+	"github.com/mvaleed/brook/internal/query"
+)
+
+// Format is a file format records are exported to.
+type Format string
+
+const (
+	JSONL   Format = "jsonl"
+	CSV     Format = "csv"
+	Parquet Format = "parquet"
+)
+
+// Formats are the formats records can be exported to.
+var Formats = []Format{JSONL, CSV, Parquet}
+
+// Writer writes records to a file.
+type Writer interface {
+	Write(row query.Row) error
+	// Close writes what is buffered and ends the file. It doesn't close the
+	// underlying io.Writer.
+	Close() error
+}
+
+// NewWriter returns a Writer of the given format to w.
+func NewWriter(w io.Writer, format Format) (Writer, error) {
+	switch format {
+	case JSONL:
+		return &jsonlWriter{enc: json.NewEncoder(w)}, nil
+	case CSV:
+		return newCSVWriter(w), nil
+	case Parquet:
+		return NewParquetWriter(w, 0), nil
+	default:
+		return nil, fmt.Errorf("unknown format %q, expected one of %v", format, Formats)
+	}
+}
+
+// Copy writes every row to w and closes it, returning how many rows it
+// wrote. Rows are expected to have their whole payload, not selected fields.
+func Copy(w Writer, rows iter.Seq2[query.Row, error]) (int, error) {
+	n := 0
+	for row, err := range rows {
+		if err != nil {
+			return n, err
+		}
+		if err := w.Write(row); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, w.Close()
+}
+
+// jsonlRecord is a record as a line of JSON. Payloads that aren't valid
+// UTF-8 have their invalid bytes replaced, Parquet keeps them as they are.
+type jsonlRecord struct {
+	Offset    int       `json:"offset"`
+	Timestamp time.Time `json:"timestamp"`
+	Payload   string    `json:"payload"`
+}
+
+type jsonlWriter struct {
+	enc *json.Encoder
+}
+
+func (w *jsonlWriter) Write(row query.Row) error {
+	return w.enc.Encode(jsonlRecord{Offset: row.Offset, Timestamp: row.Timestamp.UTC(), Payload: string(row.Payload)})
+}
+
+func (w *jsonlWriter) Close() error {
+	return nil
+}
+
+// csvWriter writes a header, then a line per record.
+type csvWriter struct {
+	w      *csv.Writer
+	header bool
+}
+
+func newCSVWriter(w io.Writer) *csvWriter {
+	return &csvWriter{w: csv.NewWriter(w)}
+}
+
+func (w *csvWriter) Write(row query.Row) error {
+	if !w.header {
+		if err := w.writeHeader(); err != nil {
+			return err
+		}
+	}
+	return w.w.Write([]string{
+		strconv.Itoa(row.Offset),
+		row.Timestamp.UTC().Format(time.RFC3339Nano),
+		string(row.Payload),
+	})
+}
+
+func (w *csvWriter) writeHeader() error {
+	w.header = true
+	return w.w.Write([]string{"offset", "timestamp", "payload"})
+}
+
+func (w *csvWriter) Close() error {
+	// Even a file without records has its header
+	if !w.header {
+		if err := w.writeHeader(); err != nil {
+			return err
+		}
+	}
+	w.w.Flush()
+	return w.w.Error()
+}
@@ -0,0 +1,254 @@
+package export
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/mvaleed/brook/internal/query"
+)
+
+func testRows(n int) []query.Row {
+	start := time.Date(2026, 1, 2, 3, 4, 5, 6000, time.UTC)
+	rows := make([]query.Row, n)
+	for i := range rows {
+		rows[i] = query.Row{
+			Offset:    10 + i,
+			Timestamp: start.Add(time.Duration(i) * time.Second),
+			Payload:   []byte(`{"n": ` + string(rune('0'+i%10)) + `, "s": "a,\"b\""}`),
+		}
+	}
+	return rows
+}
+
+func seq(rows []query.Row) func(func(query.Row, error) bool) {
+	return func(yield func(query.Row, error) bool) {
+		for _, row := range rows {
+			if !yield(row, nil) {
+				return
+			}
+		}
+	}
+}
+
+func TestCopy(t *testing.T) {
+	rows := testRows(3)
+
+	t.Run("jsonl", func(t *testing.T) {
+		var buf bytes.Buffer
+		w, err := NewWriter(&buf, JSONL)
+		require.NoError(t, err)
+		n, err := Copy(w, seq(rows))
+		require.NoError(t, err)
+		require.Equal(t, 3, n)
+
+		dec := json.NewDecoder(&buf)
+		for _, row := range rows {
+			var record jsonlRecord
+			require.NoError(t, dec.Decode(&record))
+			require.Equal(t, jsonlRecord{Offset: row.Offset, Timestamp: row.Timestamp, Payload: string(row.Payload)}, record)
+		}
+		require.False(t, dec.More())
+	})
+
+	t.Run("csv", func(t *testing.T) {
+		var buf bytes.Buffer
+		w, err := NewWriter(&buf, CSV)
+		require.NoError(t, err)
+		_, err = Copy(w, seq(rows))
+		require.NoError(t, err)
+
+		lines, err := csv.NewReader(&buf).ReadAll()
+		require.NoError(t, err)
+		require.Len(t, lines, 4)
+		require.Equal(t, []string{"offset", "timestamp", "payload"}, lines[0])
+		require.Equal(t, []string{"11", "2026-01-02T03:04:06.000006Z", string(rows[1].Payload)}, lines[2])
+
+		buf.Reset()
+		w, _ = NewWriter(&buf, CSV)
+		_, err = Copy(w, seq(nil))
+		require.NoError(t, err)
+		require.Equal(t, "offset,timestamp,payload\n", buf.String())
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		_, err := NewWriter(&bytes.Buffer{}, "xml")
+		require.Error(t, err)
+
+		w, _ := NewWriter(&bytes.Buffer{}, JSONL)
+		failing := func(yield func(query.Row, error) bool) {
+			if yield(rows[0], nil) {
+				yield(query.Row{}, errors.New("boom"))
+			}
+		}
+		n, err := Copy(w, failing)
+		require.EqualError(t, err, "boom")
+		require.Equal(t, 1, n)
+	})
+}
+
+func TestParquetWriter(t *testing.T) {
+	t.Run("row groups", func(t *testing.T) {
+		rows := testRows(25)
+		var buf bytes.Buffer
+		// A row takes 16 bytes, and 27 for its payload and length, so row
+		// groups hold 10 rows
+		_, err := Copy(NewParquetWriter(&buf, 430), seq(rows))
+		require.NoError(t, err)
+
+		file := buf.Bytes()
+		meta := readParquetFooter(t, file)
+		require.EqualValues(t, 25, meta[3])
+		require.Equal(t, "brook", string(meta[6].([]byte)))
+
+		schema := meta[2].([]any)
+		require.Len(t, schema, 4)
+		require.EqualValues(t, 3, schema[0].(map[int16]any)[5])
+		var names []string
+		for _, element := range schema[1:] {
+			names = append(names, string(element.(map[int16]any)[4].([]byte)))
+		}
+		require.Equal(t, []string{"offset", "timestamp", "payload"}, names)
+		require.EqualValues(t, parquetTimestampMicros, schema[2].(map[int16]any)[6])
+
+		// Read the columns back from their pages
+		var offsets, timestamps []int64
+		var payloads [][]byte
+		var groupRows []int64
+		for _, group := range meta[4].([]any) {
+			group := group.(map[int16]any)
+			groupRows = append(groupRows, group[3].(int64))
+			for i, chunk := range group[1].([]any) {
+				chunkMeta := chunk.(map[int16]any)[3].(map[int16]any)
+				require.Equal(t, names[i], string(chunkMeta[3].([]any)[0].([]byte)))
+				values := readParquetPage(t, file, chunkMeta[9].(int64), chunkMeta[5].(int64))
+				for len(values) > 0 {
+					switch i {
+					case 0:
+						offsets = append(offsets, int64(binary.LittleEndian.Uint64(values)))
+						values = values[8:]
+					case 1:
+						timestamps = append(timestamps, int64(binary.LittleEndian.Uint64(values)))
+						values = values[8:]
+					case 2:
+						n := binary.LittleEndian.Uint32(values)
+						payloads = append(payloads, values[4:4+n])
+						values = values[4+n:]
+					}
+				}
+			}
+		}
+		require.Equal(t, []int64{10, 10, 5}, groupRows)
+		for i, row := range rows {
+			require.EqualValues(t, row.Offset, offsets[i])
+			require.Equal(t, row.Timestamp.UnixMicro(), timestamps[i])
+			require.Equal(t, row.Payload, payloads[i])
+		}
+	})
+
+	t.Run("empty", func(t *testing.T) {
+		var buf bytes.Buffer
+		require.NoError(t, NewParquetWriter(&buf, 0).Close())
+		meta := readParquetFooter(t, buf.Bytes())
+		require.EqualValues(t, 0, meta[3])
+		require.Empty(t, meta[4])
+	})
+}
+
+// readParquetFooter checks the magic of file and decodes its FileMetaData.
+func readParquetFooter(t *testing.T, file []byte) map[int16]any {
+	t.Helper()
+	require.True(t, bytes.HasPrefix(file, []byte(parquetMagic)))
+	require.True(t, bytes.HasSuffix(file, []byte(parquetMagic)))
+	n := int(binary.LittleEndian.Uint32(file[len(file)-8:]))
+	r := &compactReader{t: t, buf: file[len(file)-8-n : len(file)-8]}
+	meta := r.structure()
+	require.Empty(t, r.buf)
+	return meta
+}
+
+// readParquetPage decodes the header of the data page at offset and returns
+// its values.
+func readParquetPage(t *testing.T, file []byte, offset, values int64) []byte {
+	t.Helper()
+	r := &compactReader{t: t, buf: file[offset:]}
+	header := r.structure()
+	require.EqualValues(t, parquetDataPage, header[1])
+	require.Equal(t, header[2], header[3])
+	require.EqualValues(t, values, header[5].(map[int16]any)[1])
+	return r.buf[:header[2].(int64)]
+}
+
+// compactReader decodes Thrift's compact protocol into maps of field IDs to
+// int64, []byte, []any and nested maps.
+type compactReader struct {
+	t   *testing.T
+	buf []byte
+}
+
+func (r *compactReader) varint() int64 {
+	v, n := binary.Varint(r.buf)
+	require.Positive(r.t, n)
+	r.buf = r.buf[n:]
+	return v
+}
+
+func (r *compactReader) uvarint() uint64 {
+	v, n := binary.Uvarint(r.buf)
+	require.Positive(r.t, n)
+	r.buf = r.buf[n:]
+	return v
+}
+
+func (r *compactReader) structure() map[int16]any {
+	fields := make(map[int16]any)
+	var last int16
+	for {
+		b := r.buf[0]
+		r.buf = r.buf[1:]
+		if b == 0 {
+			return fields
+		}
+		id := last + int16(b>>4)
+		if b>>4 == 0 {
+			id = int16(r.varint())
+		}
+		last = id
+		fields[id] = r.value(b & 0x0f)
+	}
+}
+
+func (r *compactReader) value(typ byte) any {
+	switch typ {
+	case compactI32, compactI64:
+		return r.varint()
+	case compactBinary:
+		n := r.uvarint()
+		v := r.buf[:n]
+		r.buf = r.buf[n:]
+		return v
+	case compactList:
+		b := r.buf[0]
+		r.buf = r.buf[1:]
+		n := uint64(b >> 4)
+		if n == 15 {
+			n = r.uvarint()
+		}
+		list := make([]any, n)
+		for i := range list {
+			list[i] = r.value(b & 0x0f)
+		}
+		return list
+	case compactStruct:
+		return r.structure()
+	default:
+		r.t.Fatalf("unexpected compact type %d", typ)
+		return nil
+	}
+}
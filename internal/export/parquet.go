@@ -0,0 +1,208 @@
+package export
+
+import (
+	"encoding/binary"
+	"io"
+
+	"github.com/mvaleed/brook/internal/query"
+)
+
+const defaultRowGroupBytes = 64 << 20
+
+// Parquet files start and end with this
+const parquetMagic = "PAR1"
+
+// Parquet enums, as in parquet.thrift
+const (
+	parquetInt64     = 2
+	parquetByteArray = 6
+
+	parquetRequired = 0
+
+	parquetTimestampMicros = 10
+
+	parquetPlain = 0
+	parquetRLE   = 3
+
+	parquetUncompressed = 0
+
+	parquetDataPage = 0
+)
+
+// parquetColumn is a column of a row group being buffered, its values PLAIN
+// encoded.
+type parquetColumn struct {
+	name      string
+	typ       int32
+	converted int32 // -1 for none
+	values    []byte
+}
+
+// parquetChunk is where a column chunk was written, for the footer.
+type parquetChunk struct {
+	offset int64
+	size   int64
+}
+
+type parquetRowGroup struct {
+	rows   int64
+	chunks []parquetChunk
+}
+
+// ParquetWriter writes records as a Parquet file with the required columns
+// offset (INT64), timestamp (INT64, TIMESTAMP_MICROS) and payload
+// (BYTE_ARRAY). Columns are PLAIN encoded and uncompressed, a single data
+// page per column chunk.
+type ParquetWriter struct {
+	w             io.Writer
+	rowGroupBytes int
+	offset        int64 // in the file
+	columns       []*parquetColumn
+	rows          int64 // in the row group being buffered
+	rowGroups     []parquetRowGroup
+	err           error
+}
+
+// NewParquetWriter returns a ParquetWriter to w, buffering row groups of up
+// to rowGroupBytes of values, 64MiB if it's 0.
+func NewParquetWriter(w io.Writer, rowGroupBytes int) *ParquetWriter {
+	if rowGroupBytes <= 0 {
+		rowGroupBytes = defaultRowGroupBytes
+	}
+	return &ParquetWriter{
+		w:             w,
+		rowGroupBytes: rowGroupBytes,
+		columns: []*parquetColumn{
+			{name: "offset", typ: parquetInt64, converted: -1},
+			{name: "timestamp", typ: parquetInt64, converted: parquetTimestampMicros},
+			{name: "payload", typ: parquetByteArray, converted: -1},
+		},
+	}
+}
+
+func (w *ParquetWriter) Write(row query.Row) error {
+	if w.offset == 0 {
+		w.write([]byte(parquetMagic))
+	}
+	offset, timestamp, payload := w.columns[0], w.columns[1], w.columns[2]
+	offset.values = binary.LittleEndian.AppendUint64(offset.values, uint64(row.Offset))
+	timestamp.values = binary.LittleEndian.AppendUint64(timestamp.values, uint64(row.Timestamp.UnixMicro()))
+	payload.values = binary.LittleEndian.AppendUint32(payload.values, uint32(len(row.Payload)))
+	payload.values = append(payload.values, row.Payload...)
+	w.rows++
+
+	if len(offset.values)+len(timestamp.values)+len(payload.values) >= w.rowGroupBytes {
+		w.flushRowGroup()
+	}
+	return w.err
+}
+
+// flushRowGroup writes the row group being buffered.
+func (w *ParquetWriter) flushRowGroup() {
+	if w.rows == 0 {
+		return
+	}
+	group := parquetRowGroup{rows: w.rows}
+	for _, column := range w.columns {
+		header := &compactWriter{}
+		header.begin(0)
+		header.i32(1, parquetDataPage)
+		header.i32(2, int32(len(column.values)))
+		header.i32(3, int32(len(column.values)))
+		header.begin(5)
+		header.i32(1, int32(w.rows))
+		header.i32(2, parquetPlain)
+		header.i32(3, parquetRLE)
+		header.i32(4, parquetRLE)
+		header.end()
+		header.end()
+
+		// Required columns have no repetition or definition levels, the
+		// page is only the values
+		chunk := parquetChunk{offset: w.offset, size: int64(len(header.buf) + len(column.values))}
+		w.write(header.buf)
+		w.write(column.values)
+		group.chunks = append(group.chunks, chunk)
+		column.values = column.values[:0]
+	}
+	w.rowGroups = append(w.rowGroups, group)
+	w.rows = 0
+}
+
+func (w *ParquetWriter) write(p []byte) {
+	if w.err != nil {
+		return
+	}
+	var n int
+	n, w.err = w.w.Write(p)
+	w.offset += int64(n)
+}
+
+func (w *ParquetWriter) Close() error {
+	if w.offset == 0 {
+		w.write([]byte(parquetMagic))
+	}
+	w.flushRowGroup()
+	footer := w.footer()
+	w.write(footer)
+	w.write(binary.LittleEndian.AppendUint32(nil, uint32(len(footer))))
+	w.write([]byte(parquetMagic))
+	return w.err
+}
+
+// footer returns the FileMetaData of the file.
+func (w *ParquetWriter) footer() []byte {
+	var rows int64
+	for _, group := range w.rowGroups {
+		rows += group.rows
+	}
+
+	m := &compactWriter{}
+	m.begin(0)
+	m.i32(1, 1) // version
+	m.list(2, compactStruct, len(w.columns)+1)
+	m.begin(0)
+	m.string(4, "schema")
+	m.i32(5, int32(len(w.columns)))
+	m.end()
+	for _, column := range w.columns {
+		m.begin(0)
+		m.i32(1, column.typ)
+		m.i32(3, parquetRequired)
+		m.string(4, column.name)
+		if column.converted >= 0 {
+			m.i32(6, column.converted)
+		}
+		m.end()
+	}
+	m.i64(3, rows)
+	m.list(4, compactStruct, len(w.rowGroups))
+	for _, group := range w.rowGroups {
+		var size int64
+		m.begin(0)
+		m.list(1, compactStruct, len(group.chunks))
+		for i, chunk := range group.chunks {
+			column := w.columns[i]
+			size += chunk.size
+			m.begin(0)
+			m.i64(2, chunk.offset)
+			m.begin(3)
+			m.i32(1, column.typ)
+			m.i32s(2, parquetPlain)
+			m.strings(3, column.name)
+			m.i32(4, parquetUncompressed)
+			m.i64(5, group.rows)
+			m.i64(6, chunk.size)
+			m.i64(7, chunk.size)
+			m.i64(9, chunk.offset)
+			m.end()
+			m.end()
+		}
+		m.i64(2, size)
+		m.i64(3, group.rows)
+		m.end()
+	}
+	m.string(6, "brook")
+	m.end()
+	return m.buf
+}
@@ -0,0 +1,94 @@
+package export
+
+import (
+	"encoding/binary"
+)
+
+// Parquet metadata is encoded with Thrift's compact protocol. compactWriter
+// writes the little of it the footer and page headers need.
+type compactWriter struct {
+	buf []byte
+	// last is the ID of the last field written in each struct being written
+	last []int16
+}
+
+// Compact protocol types
+const (
+	compactI32    = 5
+	compactI64    = 6
+	compactBinary = 8
+	compactList   = 9
+	compactStruct = 12
+)
+
+func (w *compactWriter) field(id int16, typ byte) {
+	last := &w.last[len(w.last)-1]
+	if delta := id - *last; delta > 0 && delta <= 15 {
+		w.buf = append(w.buf, byte(delta)<<4|typ)
+	} else {
+		w.buf = append(w.buf, typ)
+		w.buf = binary.AppendVarint(w.buf, int64(id))
+	}
+	*last = id
+}
+
+func (w *compactWriter) i32(id int16, v int32) {
+	w.field(id, compactI32)
+	w.buf = binary.AppendVarint(w.buf, int64(v))
+}
+
+func (w *compactWriter) i64(id int16, v int64) {
+	w.field(id, compactI64)
+	w.buf = binary.AppendVarint(w.buf, v)
+}
+
+func (w *compactWriter) string(id int16, s string) {
+	w.field(id, compactBinary)
+	w.appendString(s)
+}
+
+func (w *compactWriter) appendString(s string) {
+	w.buf = binary.AppendUvarint(w.buf, uint64(len(s)))
+	w.buf = append(w.buf, s...)
+}
+
+// list starts a list field of n elements of type typ, written right after.
+func (w *compactWriter) list(id int16, typ byte, n int) {
+	w.field(id, compactList)
+	if n < 15 {
+		w.buf = append(w.buf, byte(n)<<4|typ)
+	} else {
+		w.buf = append(w.buf, 0xf0|typ)
+		w.buf = binary.AppendUvarint(w.buf, uint64(n))
+	}
+}
+
+// i32s writes a list field of i32s.
+func (w *compactWriter) i32s(id int16, vs ...int32) {
+	w.list(id, compactI32, len(vs))
+	for _, v := range vs {
+		w.buf = binary.AppendVarint(w.buf, int64(v))
+	}
+}
+
+// strings writes a list field of strings.
+func (w *compactWriter) strings(id int16, ss ...string) {
+	w.list(id, compactBinary, len(ss))
+	for _, s := range ss {
+		w.appendString(s)
+	}
+}
+
+// begin starts a struct field, or a struct element of a list or the top
+// level struct if id is 0, whose fields are written until end.
+func (w *compactWriter) begin(id int16) {
+	if id != 0 {
+		w.field(id, compactStruct)
+	}
+	w.last = append(w.last, 0)
+}
+
+func (w *compactWriter) end() {
+	w.buf = append(w.buf, 0)
+	w.last = w.last[:len(w.last)-1]
+}
@@ -0,0 +1,24 @@
+//go:build linux
+
+package health
+
+import (
+	"math"
+	"os"
+	"syscall"
+)
+
+// openFiles returns the descriptors the process has open, counted in
+// /proc/self/fd, and its soft limit on them.
+func openFiles() (int, int, error) {
+	var limit syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &limit); err != nil {
+		return 0, 0, err
+	}
+	entries, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		return 0, 0, err
+	}
+	// RLIM_INFINITY doesn't fit in an int
+	return len(entries), int(min(limit.Cur, math.MaxInt)), nil
+}
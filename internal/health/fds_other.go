@@ -0,0 +1,10 @@
+//go:build !linux
+
+package health
+
+import "errors"
+
+// openFiles needs /proc/self/fd, which only Linux has.
+func openFiles() (int, int, error) {
+	return 0, 0, errors.ErrUnsupported
+}
@@ -0,0 +1,203 @@
+// Package health serves the liveness and readiness endpoints of a broker,
+// /healthz and /readyz, as Kubernetes probes them. Liveness only tells that
+// the process answers. Readiness runs self-checks of the storage: that every
+// data directory takes writes, that fsync is fast enough, and that the
+// process has file descriptors to spare.
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// Check is a self-check readiness runs. Run returns why the broker isn't fit
+// to serve, nil if it is.
+type Check struct {
+	Name string
+	Run  func(ctx context.Context) error
+}
+
+// Config configures a Checker.
+type Config struct {
+	// DataDirs are the directories checked for writes: a probe file is
+	// written, fsynced and removed in each.
+	DataDirs []string
+	// MaxFsyncLatency fails readiness when fsyncing a probe file takes
+	// longer. Defaults to one second.
+	MaxFsyncLatency time.Duration
+	// MinFDHeadroom fails readiness when fewer than this fraction of the
+	// file descriptors the process may open are left. Defaults to 0.1.
+	// The check only runs on Linux.
+	MinFDHeadroom float64
+	// Checks are run along with the storage ones, for what the storage
+	// knows nothing about, like replication lag.
+	Checks []Check
+	// Timeout bounds a readiness probe, all checks included. Defaults to
+	// five seconds.
+	Timeout time.Duration
+	// Logger receives the checks that fail. Defaults to slog.Default().
+	Logger *slog.Logger
+}
+
+func (c Config) withDefaults() Config {
+	if c.MaxFsyncLatency == 0 {
+		c.MaxFsyncLatency = time.Second
+	}
+	if c.MinFDHeadroom == 0 {
+		c.MinFDHeadroom = 0.1
+	}
+	if c.Timeout == 0 {
+		c.Timeout = 5 * time.Second
+	}
+	if c.Logger == nil {
+		c.Logger = slog.Default()
+	}
+	return c
+}
+
+// Result is the outcome of a check.
+type Result struct {
+	Name     string        `json:"name"`
+	OK       bool          `json:"ok"`
+	Error    string        `json:"error,omitempty"`
+	Duration time.Duration `json:"duration_ns"`
+}
+
+// Report is the outcome of a readiness probe.
+type Report struct {
+	Ready  bool     `json:"ready"`
+	Checks []Result `json:"checks"`
+}
+
+// Checker runs the checks and serves the probes.
+type Checker struct {
+	config Config
+	checks []Check
+	// openFiles returns the descriptors the process has open and may open,
+	// replaced by tests
+	openFiles func() (int, int, error)
+}
+
+func NewChecker(config Config) *Checker {
+	config = config.withDefaults()
+	c := &Checker{config: config, openFiles: openFiles}
+	for _, dir := range config.DataDirs {
+		c.checks = append(c.checks, Check{
+			Name: "data-dir " + dir,
+			Run:  func(ctx context.Context) error { return c.probeDir(dir) },
+		})
+	}
+	c.checks = append(c.checks, Check{Name: "file-descriptors", Run: c.checkFDs})
+	c.checks = append(c.checks, config.Checks...)
+	return c
+}
+
+// Ready runs every check concurrently and reports whether they all passed.
+func (c *Checker) Ready(ctx context.Context) Report {
+	ctx, cancel := context.WithTimeout(ctx, c.config.Timeout)
+	defer cancel()
+
+	results := make([]Result, len(c.checks))
+	var wg sync.WaitGroup
+	for i, check := range c.checks {
+		wg.Go(func() {
+			results[i] = c.run(ctx, check)
+		})
+	}
+	wg.Wait()
+
+	report := Report{Ready: true, Checks: results}
+	for _, result := range results {
+		if !result.OK {
+			report.Ready = false
+			c.config.Logger.Warn("readiness check failed", "check", result.Name, "err", result.Error)
+		}
+	}
+	return report
+}
+
+// run runs check, giving up on it when ctx is done.
+func (c *Checker) run(ctx context.Context, check Check) Result {
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() {
+		done <- check.Run(ctx)
+	}()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = fmt.Errorf("timed out: %w", ctx.Err())
+	}
+	result := Result{Name: check.Name, OK: err == nil, Duration: time.Since(start)}
+	if err != nil {
+		result.Error = err.Error()
+	}
+	return result
+}
+
+// probeDir writes, fsyncs and removes a probe file in dir.
+func (c *Checker) probeDir(dir string) error {
+	f, err := os.CreateTemp(dir, ".health-*")
+	if err != nil {
+		return fmt.Errorf("not writable: %w", err)
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.Write([]byte("brook")); err != nil {
+		f.Close()
+		return fmt.Errorf("not writable: %w", err)
+	}
+	start := time.Now()
+	err = f.Sync()
+	latency := time.Since(start)
+	if err := errors.Join(err, f.Close()); err != nil {
+		return fmt.Errorf("fsync failed: %w", err)
+	}
+	if latency > c.config.MaxFsyncLatency {
+		return fmt.Errorf("fsync took %s, more than %s", latency, c.config.MaxFsyncLatency)
+	}
+	return nil
+}
+
+// checkFDs fails when the process is close to its file descriptor limit.
+func (c *Checker) checkFDs(ctx context.Context) error {
+	open, limit, err := c.openFiles()
+	if errors.Is(err, errors.ErrUnsupported) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if headroom := 1 - float64(open)/float64(limit); headroom < c.config.MinFDHeadroom {
+		return fmt.Errorf("%d of %d file descriptors open", open, limit)
+	}
+	return nil
+}
+
+// ServeHTTP serves /healthz and /readyz. Both answer 200 when the broker is
+// fine and 503 when it isn't, readiness with the Report as JSON.
+func (c *Checker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/healthz":
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		_, _ = w.Write([]byte("ok\n"))
+	case "/readyz":
+		report := c.Ready(r.Context())
+		w.Header().Set("Content-Type", "application/json")
+		if !report.Ready {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		_ = json.NewEncoder(w).Encode(report)
+	default:
+		http.NotFound(w, r)
+	}
+}
@@ -0,0 +1,110 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestChecker(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("ready", func(t *testing.T) {
+		dir := t.TempDir()
+		c := NewChecker(Config{DataDirs: []string{dir}})
+		report := c.Ready(ctx)
+		require.True(t, report.Ready, "%+v", report)
+		require.Equal(t, []string{"data-dir " + dir, "file-descriptors"}, names(report))
+
+		// The probe files are removed
+		entries, err := os.ReadDir(dir)
+		require.NoError(t, err)
+		require.Empty(t, entries)
+	})
+
+	t.Run("data dir not writable", func(t *testing.T) {
+		missing := filepath.Join(t.TempDir(), "missing")
+		report := NewChecker(Config{DataDirs: []string{missing}}).Ready(ctx)
+		require.False(t, report.Ready)
+		require.False(t, report.Checks[0].OK)
+		require.Contains(t, report.Checks[0].Error, "not writable")
+	})
+
+	t.Run("slow fsync", func(t *testing.T) {
+		report := NewChecker(Config{DataDirs: []string{t.TempDir()}, MaxFsyncLatency: time.Nanosecond}).Ready(ctx)
+		require.False(t, report.Ready)
+		require.Contains(t, report.Checks[0].Error, "fsync took")
+	})
+
+	t.Run("file descriptor headroom", func(t *testing.T) {
+		c := NewChecker(Config{})
+		c.openFiles = func() (int, int, error) { return 950, 1000, nil }
+		report := c.Ready(ctx)
+		require.False(t, report.Ready)
+		require.Equal(t, "950 of 1000 file descriptors open", report.Checks[0].Error)
+
+		c.openFiles = func() (int, int, error) { return 0, 0, errors.ErrUnsupported }
+		require.True(t, c.Ready(ctx).Ready)
+	})
+
+	t.Run("extra checks time out", func(t *testing.T) {
+		c := NewChecker(Config{
+			Timeout: 10 * time.Millisecond,
+			Checks: []Check{{Name: "replication-lag", Run: func(ctx context.Context) error {
+				<-ctx.Done()
+				time.Sleep(time.Second)
+				return nil
+			}}},
+		})
+		report := c.Ready(ctx)
+		require.False(t, report.Ready)
+		require.Equal(t, "replication-lag", report.Checks[1].Name)
+		require.Contains(t, report.Checks[1].Error, "timed out")
+	})
+}
+
+func names(report Report) []string {
+	var names []string
+	for _, result := range report.Checks {
+		names = append(names, result.Name)
+	}
+	return names
+}
+
+func TestChecker_ServeHTTP(t *testing.T) {
+	c := NewChecker(Config{DataDirs: []string{t.TempDir()}})
+	server := httptest.NewServer(c)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/healthz")
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp, err = http.Get(server.URL + "/readyz")
+	require.NoError(t, err)
+	var report Report
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&report))
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.True(t, report.Ready)
+
+	c.openFiles = func() (int, int, error) { return 10, 10, nil }
+	resp, err = http.Get(server.URL + "/readyz")
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+
+	resp, err = http.Get(server.URL + "/livez")
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
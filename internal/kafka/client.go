@@ -0,0 +1,284 @@
+// Package kafka is a small Kafka client, the part of the protocol mirroring
+// topics out of a Kafka cluster needs: finding the leaders of partitions,
+// listing their offsets and fetching their records. It speaks the request
+// versions every broker since Kafka 1.0 understands, without SASL or
+// compression other than gzip.
+package kafka
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Special timestamps of ListOffset
+const (
+	Latest   int64 = -1
+	Earliest int64 = -2
+)
+
+const (
+	defaultFetchBytes = 1 << 20
+	readCommitted     = 1
+)
+
+// Options configures a connection.
+type Options struct {
+	// ClientID identifies the client in the broker's logs and quotas.
+	ClientID string
+	// TLS, when set, connects over TLS with it.
+	TLS *tls.Config
+}
+
+// Conn is a connection to a broker. Requests are sent one at a time, it is
+// safe for concurrent use.
+type Conn struct {
+	conn     net.Conn
+	r        *bufio.Reader
+	clientID string
+
+	mu          sync.Mutex
+	correlation int32
+}
+
+// Dial connects to the broker at address.
+func Dial(ctx context.Context, address string, opts Options) (*Conn, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	if opts.TLS != nil {
+		tlsConn := tls.Client(conn, opts.TLS)
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		conn = tlsConn
+	}
+	return &Conn{conn: conn, r: bufio.NewReader(conn), clientID: opts.ClientID}, nil
+}
+
+func (c *Conn) Close() error {
+	return c.conn.Close()
+}
+
+// roundTrip sends a request and returns its response, after the correlation
+// ID. A request interrupted by ctx leaves the connection unusable.
+func (c *Conn) roundTrip(ctx context.Context, apiKey, version int16, body []byte) (*decoder, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		c.conn.SetDeadline(deadline)
+		defer c.conn.SetDeadline(time.Time{})
+	}
+	stop := context.AfterFunc(ctx, func() { c.conn.SetDeadline(time.Unix(1, 0)) })
+	defer stop()
+
+	c.correlation++
+	e := &encoder{buf: make([]byte, 4, 64+len(body))}
+	e.int16(apiKey)
+	e.int16(version)
+	e.int32(c.correlation)
+	e.string(c.clientID)
+	e.buf = append(e.buf, body...)
+	binary.BigEndian.PutUint32(e.buf, uint32(len(e.buf)-4))
+	if _, err := c.conn.Write(e.buf); err != nil {
+		return nil, c.ctxErr(ctx, err)
+	}
+
+	var size [4]byte
+	if _, err := io.ReadFull(c.r, size[:]); err != nil {
+		return nil, c.ctxErr(ctx, err)
+	}
+	n := binary.BigEndian.Uint32(size[:])
+	if n < 4 || n > maxResponseBytes {
+		return nil, fmt.Errorf("kafka response of %d bytes is out of bounds", n)
+	}
+	resp := make([]byte, n)
+	if _, err := io.ReadFull(c.r, resp); err != nil {
+		return nil, c.ctxErr(ctx, err)
+	}
+	d := &decoder{buf: resp}
+	if correlation := d.int32(); correlation != c.correlation {
+		return nil, fmt.Errorf("kafka response to request %d instead of %d", correlation, c.correlation)
+	}
+	return d, nil
+}
+
+// ctxErr returns the error of ctx if it is why a read or write failed.
+func (c *Conn) ctxErr(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
+// Metadata is the layout of a cluster.
+type Metadata struct {
+	// Brokers are the addresses of the brokers, by node ID.
+	Brokers map[int32]string
+	Topics  []TopicMetadata
+}
+
+type TopicMetadata struct {
+	Name       string
+	Err        error
+	Partitions []PartitionMetadata
+}
+
+type PartitionMetadata struct {
+	ID     int32
+	Leader int32 // node ID, -1 if there is none
+	Err    error
+}
+
+// Metadata returns the brokers of the cluster and the partitions of topics.
+func (c *Conn) Metadata(ctx context.Context, topics ...string) (Metadata, error) {
+	e := &encoder{}
+	e.int32(int32(len(topics)))
+	for _, topic := range topics {
+		e.string(topic)
+	}
+	d, err := c.roundTrip(ctx, apiMetadata, 1, e.buf)
+	if err != nil {
+		return Metadata{}, err
+	}
+
+	m := Metadata{Brokers: make(map[int32]string)}
+	for range d.array() {
+		id := d.int32()
+		host := d.string()
+		port := d.int32()
+		d.string() // rack
+		m.Brokers[id] = net.JoinHostPort(host, strconv.Itoa(int(port)))
+	}
+	d.int32() // controller
+	for range d.array() {
+		topic := TopicMetadata{Err: errorCode(d.int16()), Name: d.string()}
+		d.bool() // internal
+		for range d.array() {
+			partition := PartitionMetadata{Err: errorCode(d.int16()), ID: d.int32(), Leader: d.int32()}
+			for range d.array() { // replicas
+				d.int32()
+			}
+			for range d.array() { // in sync replicas
+				d.int32()
+			}
+			topic.Partitions = append(topic.Partitions, partition)
+		}
+		m.Topics = append(m.Topics, topic)
+	}
+	return m, d.err
+}
+
+// ListOffset returns the offset of the first record of a partition written at
+// or after timestamp, in milliseconds, or Earliest or Latest for the first
+// offset or the end of the partition.
+func (c *Conn) ListOffset(ctx context.Context, topic string, partition int32, timestamp int64) (int64, error) {
+	e := &encoder{}
+	e.int32(-1) // replica, a consumer
+	e.int32(1)
+	e.string(topic)
+	e.int32(1)
+	e.int32(partition)
+	e.int64(timestamp)
+	d, err := c.roundTrip(ctx, apiListOffsets, 1, e.buf)
+	if err != nil {
+		return 0, err
+	}
+
+	for range d.array() {
+		name := d.string()
+		for range d.array() {
+			id := d.int32()
+			code := d.int16()
+			d.int64() // timestamp
+			offset := d.int64()
+			if d.err == nil && name == topic && id == partition {
+				return offset, errorCode(code)
+			}
+		}
+	}
+	return 0, firstErr(d.err, errMalformed)
+}
+
+// FetchRequest asks for the records of a partition from Offset on.
+type FetchRequest struct {
+	Topic     string
+	Partition int32
+	Offset    int64
+	// MaxBytes is the most the broker answers with, although a batch of
+	// records larger than it is answered in full. Defaults to 1MiB.
+	MaxBytes int32
+	// MaxWait is how long the broker waits for records if there are none.
+	MaxWait time.Duration
+}
+
+// FetchResponse holds the records fetched.
+type FetchResponse struct {
+	Records []Record
+	// Next is the offset to fetch next. It can be past the last record,
+	// records being compacted away or transaction markers.
+	Next int64
+	// HighWatermark is the offset after the last record of the partition
+	// consumers can read.
+	HighWatermark int64
+}
+
+// Fetch fetches committed records of a partition, leaving out the ones of
+// aborted transactions.
+func (c *Conn) Fetch(ctx context.Context, req FetchRequest) (FetchResponse, error) {
+	if req.MaxBytes <= 0 {
+		req.MaxBytes = defaultFetchBytes
+	}
+	e := &encoder{}
+	e.int32(-1) // replica, a consumer
+	e.int32(int32(req.MaxWait / time.Millisecond))
+	e.int32(1) // min bytes
+	e.int32(req.MaxBytes)
+	e.int8(readCommitted)
+	e.int32(1)
+	e.string(req.Topic)
+	e.int32(1)
+	e.int32(req.Partition)
+	e.int64(req.Offset)
+	e.int32(req.MaxBytes)
+	d, err := c.roundTrip(ctx, apiFetch, 4, e.buf)
+	if err != nil {
+		return FetchResponse{}, err
+	}
+
+	d.int32() // throttle time
+	for range d.array() {
+		name := d.string()
+		for range d.array() {
+			id := d.int32()
+			code := d.int16()
+			resp := FetchResponse{Next: req.Offset, HighWatermark: d.int64()}
+			d.int64() // last stable offset
+			var aborted []abortedTransaction
+			for range d.array() {
+				aborted = append(aborted, abortedTransaction{producerID: d.int64(), firstOffset: d.int64()})
+			}
+			data := d.bytes()
+			if d.err != nil || name != req.Topic || id != req.Partition {
+				continue
+			}
+			if err := errorCode(code); err != nil {
+				return FetchResponse{}, err
+			}
+			resp.Records, resp.Next, err = decodeRecords(data, req.Offset, aborted)
+			return resp, err
+		}
+	}
+	return FetchResponse{}, firstErr(d.err, errMalformed)
+}
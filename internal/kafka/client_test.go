@@ -0,0 +1,351 @@
+package kafka
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// testBatch is a record batch as the fake broker serves it.
+type testBatch struct {
+	baseOffset    int64
+	records       []Record
+	gzip          bool
+	transactional bool
+	producerID    int64
+	// control makes it a transaction marker, abort or commit
+	control string
+	// headerCount, if set, is the header count written for every record
+	headerCount int64
+}
+
+func (b testBatch) encode() []byte {
+	base := b.records[0].Timestamp.UnixMilli()
+	var records []byte
+	for _, r := range b.records {
+		var rec []byte
+		rec = append(rec, 0)
+		rec = binary.AppendVarint(rec, r.Timestamp.UnixMilli()-base)
+		rec = binary.AppendVarint(rec, r.Offset-b.baseOffset)
+		rec = appendVarbytes(rec, r.Key)
+		rec = appendVarbytes(rec, r.Value)
+		headerCount := int64(len(r.Headers))
+		if b.headerCount != 0 {
+			headerCount = b.headerCount
+		}
+		rec = binary.AppendVarint(rec, headerCount)
+		for _, h := range r.Headers {
+			rec = appendVarbytes(rec, []byte(h.Key))
+			rec = appendVarbytes(rec, h.Value)
+		}
+		records = binary.AppendVarint(records, int64(len(rec)))
+		records = append(records, rec...)
+	}
+
+	var attributes int16
+	if b.gzip {
+		attributes |= compressionGzip
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		zw.Write(records)
+		zw.Close()
+		records = buf.Bytes()
+	}
+	if b.transactional {
+		attributes |= transactionalFlag
+	}
+	if b.control != "" {
+		attributes |= controlFlag
+	}
+
+	last := b.records[len(b.records)-1]
+	e := &encoder{}
+	e.int16(attributes)
+	e.int32(int32(last.Offset - b.baseOffset))
+	e.int64(base)
+	e.int64(last.Timestamp.UnixMilli())
+	e.int64(b.producerID)
+	e.int16(0)
+	e.int32(0)
+	e.int32(int32(len(b.records)))
+	e.buf = append(e.buf, records...)
+	crc := crc32.Checksum(e.buf, castagnoli)
+
+	batch := &encoder{}
+	batch.int64(b.baseOffset)
+	batch.int32(int32(4 + 1 + 4 + len(e.buf)))
+	batch.int32(0)
+	batch.int8(2)
+	batch.int32(int32(crc))
+	batch.buf = append(batch.buf, e.buf...)
+	return batch.buf
+}
+
+func appendVarbytes(b, data []byte) []byte {
+	if data == nil {
+		return binary.AppendVarint(b, -1)
+	}
+	b = binary.AppendVarint(b, int64(len(data)))
+	return append(b, data...)
+}
+
+// marker returns a control record marking the end of a transaction.
+func marker(offset int64, abort bool) Record {
+	key := &encoder{}
+	key.int16(0)
+	if abort {
+		key.int16(0)
+	} else {
+		key.int16(1)
+	}
+	return Record{Offset: offset, Timestamp: time.UnixMilli(1000), Key: key.buf, Value: []byte{0, 0, 0, 0, 0, 0}}
+}
+
+// fakeBroker serves a single partition, orders/0, with the batches it holds.
+type fakeBroker struct {
+	t       *testing.T
+	addr    string
+	batches []testBatch
+	aborted []abortedTransaction
+	// code answers fetches with an error code
+	code int16
+}
+
+func newFakeBroker(t *testing.T, batches ...testBatch) *fakeBroker {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+	b := &fakeBroker{t: t, addr: l.Addr().String(), batches: batches}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go b.serve(conn)
+		}
+	}()
+	return b
+}
+
+func (b *fakeBroker) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	for {
+		var size [4]byte
+		if _, err := io.ReadFull(r, size[:]); err != nil {
+			return
+		}
+		req := make([]byte, binary.BigEndian.Uint32(size[:]))
+		if _, err := io.ReadFull(r, req); err != nil {
+			return
+		}
+		d := &decoder{buf: req}
+		apiKey, version, correlation := d.int16(), d.int16(), d.int32()
+		d.string()
+
+		resp := &encoder{buf: make([]byte, 4)}
+		resp.int32(correlation)
+		switch {
+		case apiKey == apiMetadata && version == 1:
+			host, port, _ := net.SplitHostPort(b.addr)
+			p, _ := strconv.Atoi(port)
+			resp.int32(1)
+			resp.int32(7)
+			resp.string(host)
+			resp.int32(int32(p))
+			resp.int16(-1)
+			resp.int32(7)
+			resp.int32(2)
+			resp.int16(0)
+			resp.string("orders")
+			resp.int8(0)
+			resp.int32(1)
+			resp.int16(0)
+			resp.int32(0)
+			resp.int32(7)
+			resp.int32(1)
+			resp.int32(7)
+			resp.int32(1)
+			resp.int32(7)
+			resp.int16(int16(ErrUnknownTopicOrPartition))
+			resp.string("missing")
+			resp.int8(0)
+			resp.int32(0)
+		case apiKey == apiListOffsets && version == 1:
+			d.int32()
+			d.int32()
+			d.string()
+			d.int32()
+			d.int32()
+			timestamp := d.int64()
+			offset := b.batches[0].baseOffset
+			if timestamp == Latest {
+				offset = 100
+			}
+			resp.int32(1)
+			resp.string("orders")
+			resp.int32(1)
+			resp.int32(0)
+			resp.int16(0)
+			resp.int64(-1)
+			resp.int64(offset)
+		case apiKey == apiFetch && version == 4:
+			d.int32()
+			d.int32()
+			d.int32()
+			d.int32()
+			require.EqualValues(b.t, readCommitted, d.int8())
+			d.int32()
+			d.string()
+			d.int32()
+			d.int32()
+			offset := d.int64()
+			resp.int32(0)
+			resp.int32(1)
+			resp.string("orders")
+			resp.int32(1)
+			resp.int32(0)
+			resp.int16(b.code)
+			resp.int64(100)
+			resp.int64(100)
+			resp.int32(int32(len(b.aborted)))
+			for _, a := range b.aborted {
+				resp.int64(a.producerID)
+				resp.int64(a.firstOffset)
+			}
+			var records []byte
+			for _, batch := range b.batches {
+				last := batch.records[len(batch.records)-1].Offset
+				if last >= offset {
+					records = append(records, batch.encode()...)
+				}
+			}
+			resp.int32(int32(len(records)))
+			resp.buf = append(resp.buf, records...)
+		default:
+			b.t.Errorf("unexpected request %d v%d", apiKey, version)
+			return
+		}
+		binary.BigEndian.PutUint32(resp.buf, uint32(len(resp.buf)-4))
+		if _, err := conn.Write(resp.buf); err != nil {
+			return
+		}
+	}
+}
+
+func testRecord(offset int64, value string) Record {
+	return Record{Offset: offset, Timestamp: time.UnixMilli(1000 + offset), Value: []byte(value)}
+}
+
+func TestConn(t *testing.T) {
+	ctx := context.Background()
+	keyed := testRecord(11, "b")
+	keyed.Key = []byte("user-1")
+	keyed.Headers = []Header{{Key: "trace", Value: []byte("abc")}, {Key: "empty"}}
+	b := newFakeBroker(t,
+		testBatch{baseOffset: 10, records: []Record{testRecord(10, "a"), keyed}},
+		testBatch{baseOffset: 12, gzip: true, records: []Record{testRecord(12, "c"), testRecord(14, "e")}},
+	)
+
+	c, err := Dial(ctx, b.addr, Options{ClientID: "test"})
+	require.NoError(t, err)
+	defer c.Close()
+
+	t.Run("metadata", func(t *testing.T) {
+		m, err := c.Metadata(ctx, "orders", "missing")
+		require.NoError(t, err)
+		require.Equal(t, map[int32]string{7: b.addr}, m.Brokers)
+		require.Len(t, m.Topics, 2)
+		require.Equal(t, TopicMetadata{Name: "orders", Partitions: []PartitionMetadata{{ID: 0, Leader: 7}}}, m.Topics[0])
+		require.ErrorIs(t, m.Topics[1].Err, ErrUnknownTopicOrPartition)
+	})
+
+	t.Run("list offsets", func(t *testing.T) {
+		offset, err := c.ListOffset(ctx, "orders", 0, Earliest)
+		require.NoError(t, err)
+		require.EqualValues(t, 10, offset)
+		offset, err = c.ListOffset(ctx, "orders", 0, Latest)
+		require.NoError(t, err)
+		require.EqualValues(t, 100, offset)
+	})
+
+	t.Run("fetch", func(t *testing.T) {
+		resp, err := c.Fetch(ctx, FetchRequest{Topic: "orders", Offset: 11})
+		require.NoError(t, err)
+		require.EqualValues(t, 15, resp.Next)
+		require.EqualValues(t, 100, resp.HighWatermark)
+		require.Len(t, resp.Records, 3)
+		require.Equal(t, keyed.Key, resp.Records[0].Key)
+		require.Equal(t, keyed.Headers[0], resp.Records[0].Headers[0])
+		require.Equal(t, "empty", resp.Records[0].Headers[1].Key)
+		require.Equal(t, time.UnixMilli(1011), resp.Records[0].Timestamp)
+		require.Equal(t, "e", string(resp.Records[2].Value))
+		require.EqualValues(t, 14, resp.Records[2].Offset)
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		b.code = int16(ErrNotLeaderForPartition)
+		defer func() { b.code = 0 }()
+		_, err := c.Fetch(ctx, FetchRequest{Topic: "orders", Offset: 10})
+		var kerr Error
+		require.ErrorAs(t, err, &kerr)
+		require.True(t, kerr.Stale())
+	})
+}
+
+func TestDecodeRecords(t *testing.T) {
+	t.Run("batch cut short", func(t *testing.T) {
+		first := testBatch{baseOffset: 0, records: []Record{testRecord(0, "a")}}.encode()
+		second := testBatch{baseOffset: 1, records: []Record{testRecord(1, "b")}}.encode()
+		records, next, err := decodeRecords(append(first, second[:len(second)-3]...), 0, nil)
+		require.NoError(t, err)
+		require.Len(t, records, 1)
+		require.EqualValues(t, 1, next)
+	})
+
+	t.Run("corrupt batch", func(t *testing.T) {
+		batch := testBatch{baseOffset: 0, records: []Record{testRecord(0, "a")}}.encode()
+		batch[len(batch)-1] ^= 0xff
+		_, _, err := decodeRecords(batch, 0, nil)
+		require.ErrorContains(t, err, "corrupt")
+	})
+
+	t.Run("header count past the record", func(t *testing.T) {
+		batch := testBatch{baseOffset: 0, headerCount: 1 << 40, records: []Record{testRecord(0, "a")}}.encode()
+		_, _, err := decodeRecords(batch, 0, nil)
+		require.ErrorIs(t, err, errMalformed)
+	})
+
+	t.Run("aborted transactions are left out", func(t *testing.T) {
+		var data []byte
+		for _, b := range []testBatch{
+			{baseOffset: 0, records: []Record{testRecord(0, "committed")}},
+			{baseOffset: 1, transactional: true, producerID: 5, records: []Record{testRecord(1, "aborted")}},
+			{baseOffset: 2, transactional: true, producerID: 6, records: []Record{testRecord(2, "in other transaction")}},
+			{baseOffset: 3, control: "abort", transactional: true, producerID: 5, records: []Record{marker(3, true)}},
+			{baseOffset: 4, control: "commit", transactional: true, producerID: 6, records: []Record{marker(4, false)}},
+			{baseOffset: 5, transactional: true, producerID: 5, records: []Record{testRecord(5, "next transaction")}},
+		} {
+			data = append(data, b.encode()...)
+		}
+		records, next, err := decodeRecords(data, 0, []abortedTransaction{{producerID: 5, firstOffset: 1}})
+		require.NoError(t, err)
+		require.EqualValues(t, 6, next)
+		var values []string
+		for _, r := range records {
+			values = append(values, string(r.Value))
+		}
+		require.Equal(t, []string{"committed", "in other transaction", "next transaction"}, values)
+	})
+}
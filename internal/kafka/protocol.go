@@ -0,0 +1,194 @@
+package kafka
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// API keys of the requests the client sends
+const (
+	apiFetch       = 1
+	apiListOffsets = 2
+	apiMetadata    = 3
+)
+
+// maxResponseBytes caps the responses read, fetches are bounded by their
+// MaxBytes anyway.
+const maxResponseBytes = 256 << 20
+
+var errMalformed = errors.New("malformed kafka response")
+
+// Error is an error code a broker answered with.
+type Error int16
+
+const (
+	ErrOffsetOutOfRange        Error = 1
+	ErrUnknownTopicOrPartition Error = 3
+	ErrLeaderNotAvailable      Error = 5
+	ErrNotLeaderForPartition   Error = 6
+)
+
+func (e Error) Error() string {
+	switch e {
+	case ErrOffsetOutOfRange:
+		return "kafka: offset out of range"
+	case ErrUnknownTopicOrPartition:
+		return "kafka: unknown topic or partition"
+	case ErrLeaderNotAvailable:
+		return "kafka: leader not available"
+	case ErrNotLeaderForPartition:
+		return "kafka: not leader for partition"
+	default:
+		return fmt.Sprintf("kafka: error code %d", int16(e))
+	}
+}
+
+// Stale tells if the error means the client's metadata is out of date, the
+// partition having moved to another broker.
+func (e Error) Stale() bool {
+	return e == ErrLeaderNotAvailable || e == ErrNotLeaderForPartition || e == ErrUnknownTopicOrPartition
+}
+
+func errorCode(code int16) error {
+	if code == 0 {
+		return nil
+	}
+	return Error(code)
+}
+
+// encoder appends the fields of a request.
+type encoder struct {
+	buf []byte
+}
+
+func (e *encoder) int8(v int8)   { e.buf = append(e.buf, byte(v)) }
+func (e *encoder) int16(v int16) { e.buf = binary.BigEndian.AppendUint16(e.buf, uint16(v)) }
+func (e *encoder) int32(v int32) { e.buf = binary.BigEndian.AppendUint32(e.buf, uint32(v)) }
+func (e *encoder) int64(v int64) { e.buf = binary.BigEndian.AppendUint64(e.buf, uint64(v)) }
+
+func (e *encoder) string(s string) {
+	e.int16(int16(len(s)))
+	e.buf = append(e.buf, s...)
+}
+
+// decoder reads the fields of a response. The first error sticks, reads
+// after it return zero values.
+type decoder struct {
+	buf []byte
+	err error
+}
+
+func (d *decoder) take(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if n < 0 || len(d.buf) < n {
+		d.err = errMalformed
+		return nil
+	}
+	b := d.buf[:n]
+	d.buf = d.buf[n:]
+	return b
+}
+
+func (d *decoder) int8() int8 {
+	if b := d.take(1); b != nil {
+		return int8(b[0])
+	}
+	return 0
+}
+
+func (d *decoder) int16() int16 {
+	if b := d.take(2); b != nil {
+		return int16(binary.BigEndian.Uint16(b))
+	}
+	return 0
+}
+
+func (d *decoder) int32() int32 {
+	if b := d.take(4); b != nil {
+		return int32(binary.BigEndian.Uint32(b))
+	}
+	return 0
+}
+
+func (d *decoder) int64() int64 {
+	if b := d.take(8); b != nil {
+		return int64(binary.BigEndian.Uint64(b))
+	}
+	return 0
+}
+
+func (d *decoder) bool() bool {
+	return d.int8() != 0
+}
+
+// string reads a string, nullable ones as "" when null.
+func (d *decoder) string() string {
+	n := d.int16()
+	if n < 0 {
+		return ""
+	}
+	return string(d.take(int(n)))
+}
+
+// bytes reads bytes prefixed with a 32 bit length, nil when null.
+func (d *decoder) bytes() []byte {
+	n := d.int32()
+	if n < 0 {
+		return nil
+	}
+	return d.take(int(n))
+}
+
+// array reads the length of an array, 0 when null. It is checked against
+// what is left, each element taking a byte at least.
+func (d *decoder) array() int {
+	n := d.int32()
+	if n < 0 {
+		return 0
+	}
+	if int(n) > len(d.buf) {
+		d.err = errMalformed
+		return 0
+	}
+	return int(n)
+}
+
+// varint reads a zigzag varint, as records are written with.
+func (d *decoder) varint() int64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(d.buf)
+	if n <= 0 {
+		d.err = errMalformed
+		return 0
+	}
+	d.buf = d.buf[n:]
+	return v
+}
+
+// varintArray reads the varint length of an array, as the headers of a record
+// are counted, 0 when null. It is checked against what is left like array's.
+func (d *decoder) varintArray() int {
+	n := d.varint()
+	if n < 0 {
+		return 0
+	}
+	if n > int64(len(d.buf)) {
+		d.err = errMalformed
+		return 0
+	}
+	return int(n)
+}
+
+// varbytes reads bytes prefixed with their varint length, nil when null.
+func (d *decoder) varbytes() []byte {
+	n := d.varint()
+	if n < 0 {
+		return nil
+	}
+	return d.take(int(n))
+}
@@ -0,0 +1,174 @@
+package kafka
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"sort"
+	"time"
+)
+
+// Record is a record fetched from a partition.
+type Record struct {
+	Offset    int64
+	Timestamp time.Time
+	Key       []byte
+	Value     []byte
+	Headers   []Header
+}
+
+type Header struct {
+	Key   string
+	Value []byte
+}
+
+// Attributes of record batches
+const (
+	compressionMask   = 0x07
+	compressionGzip   = 1
+	logAppendTime     = 0x08
+	transactionalFlag = 0x10
+	controlFlag       = 0x20
+)
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// abortedTransaction is a transaction of a producer, starting at an offset,
+// that was aborted.
+type abortedTransaction struct {
+	producerID  int64
+	firstOffset int64
+}
+
+// decodeRecords decodes the record batches of a fetch, returning the records
+// from offset from on and the offset after the last batch. A batch cut short
+// at the end, the fetch having reached its size limit, is left for the next
+// fetch. Records of aborted transactions are left out.
+func decodeRecords(data []byte, from int64, aborted []abortedTransaction) ([]Record, int64, error) {
+	sort.Slice(aborted, func(i, j int) bool { return aborted[i].firstOffset < aborted[j].firstOffset })
+	aborting := make(map[int64]bool) // producers whose transaction is being aborted
+
+	var records []Record
+	next := from
+	for len(data) >= 12 {
+		baseOffset := int64(binary.BigEndian.Uint64(data))
+		length := int(int32(binary.BigEndian.Uint32(data[8:])))
+		if length < 0 || len(data) < 12+length {
+			break
+		}
+		batch := data[12 : 12+length]
+		data = data[12+length:]
+
+		d := &decoder{buf: batch}
+		d.int32() // partition leader epoch
+		if magic := d.int8(); d.err == nil && magic != 2 {
+			return nil, 0, fmt.Errorf("kafka: unsupported message format %d, only record batches are", magic)
+		}
+		crc := uint32(d.int32())
+		if d.err == nil && crc32.Checksum(d.buf, castagnoli) != crc {
+			return nil, 0, fmt.Errorf("kafka: corrupt record batch at offset %d", baseOffset)
+		}
+		attributes := d.int16()
+		lastOffsetDelta := d.int32()
+		baseTimestamp := d.int64()
+		maxTimestamp := d.int64()
+		producerID := d.int64()
+		d.int16() // producer epoch
+		d.int32() // base sequence
+		count := d.int32()
+		if d.err != nil {
+			return nil, 0, d.err
+		}
+		next = max(next, baseOffset+int64(lastOffsetDelta)+1)
+
+		for len(aborted) > 0 && aborted[0].firstOffset <= baseOffset+int64(lastOffsetDelta) {
+			aborting[aborted[0].producerID] = true
+			aborted = aborted[1:]
+		}
+		if attributes&controlFlag != 0 {
+			// Transaction markers, the abort one ends its transaction
+			if abortMarker(d.buf) {
+				delete(aborting, producerID)
+			}
+			continue
+		}
+		if attributes&transactionalFlag != 0 && aborting[producerID] {
+			continue
+		}
+
+		switch attributes & compressionMask {
+		case 0:
+		case compressionGzip:
+			zr, err := gzip.NewReader(bytes.NewReader(d.buf))
+			if err != nil {
+				return nil, 0, fmt.Errorf("kafka: record batch at offset %d: %w", baseOffset, err)
+			}
+			// Bounded like the responses, a small batch can inflate
+			// without end
+			if d.buf, err = io.ReadAll(io.LimitReader(zr, maxResponseBytes+1)); err != nil {
+				return nil, 0, fmt.Errorf("kafka: record batch at offset %d: %w", baseOffset, err)
+			}
+			if len(d.buf) > maxResponseBytes {
+				return nil, 0, fmt.Errorf("kafka: record batch at offset %d inflates past %d bytes", baseOffset, maxResponseBytes)
+			}
+		default:
+			return nil, 0, fmt.Errorf("kafka: record batch at offset %d has unsupported compression %d, only gzip is", baseOffset, attributes&compressionMask)
+		}
+
+		for range count {
+			r := &decoder{buf: d.take(int(d.varint()))}
+			r.int8() // attributes
+			timestampDelta := r.varint()
+			record := Record{Offset: baseOffset + r.varint()}
+			if attributes&logAppendTime != 0 {
+				record.Timestamp = time.UnixMilli(maxTimestamp)
+			} else {
+				record.Timestamp = time.UnixMilli(baseTimestamp + timestampDelta)
+			}
+			record.Key = r.varbytes()
+			record.Value = r.varbytes()
+			for range r.varintArray() {
+				key := string(r.varbytes())
+				value := r.varbytes()
+				if r.err != nil {
+					break
+				}
+				record.Headers = append(record.Headers, Header{Key: key, Value: value})
+			}
+			if err := firstErr(d.err, r.err); err != nil {
+				return nil, 0, fmt.Errorf("kafka: record batch at offset %d: %w", baseOffset, err)
+			}
+			if record.Offset >= from {
+				records = append(records, record)
+			}
+		}
+	}
+	return records, next, nil
+}
+
+// firstErr returns the first of errs that isn't nil.
+func firstErr(errs ...error) error {
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// abortMarker tells if the records of a control batch are an abort marker,
+// whose key is a version and a type, 0 for abort.
+func abortMarker(records []byte) bool {
+	d := &decoder{buf: records}
+	r := &decoder{buf: d.take(int(d.varint()))}
+	r.int8()
+	r.varint()
+	r.varint()
+	key := &decoder{buf: r.varbytes()}
+	key.int16()
+	kind := key.int16()
+	return firstErr(d.err, r.err, key.err) == nil && kind == 0
+}
@@ -0,0 +1,154 @@
+// Package metrics exposes brook's internal numbers in the Prometheus text
+// exposition format. It is deliberately tiny: components implement Collector
+// and hand back plain samples on every scrape, there are no client side
+// counters or histograms to keep in sync.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+type Type string
+
+const (
+	Gauge   Type = "gauge"
+	Counter Type = "counter"
+)
+
+// Sample is a single value of a metric. Samples that share a Name must share
+// Help and Type too.
+type Sample struct {
+	Name   string
+	Help   string
+	Type   Type
+	Labels map[string]string
+	Value  float64
+}
+
+// Collector is anything that can report samples when scraped.
+type Collector interface {
+	Collect() []Sample
+}
+
+// CollectorFunc adapts a plain function to a Collector.
+type CollectorFunc func() []Sample
+
+func (f CollectorFunc) Collect() []Sample {
+	return f()
+}
+
+type Registry struct {
+	mu         sync.RWMutex
+	collectors []Collector
+}
+
+// Default is the process wide registry.
+var Default = &Registry{}
+
+func (r *Registry) Register(c Collector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.collectors = append(r.collectors, c)
+}
+
+// Unregister removes a collector. Collectors are compared with ==, so a func
+// collector has to be registered by pointer to be removable.
+func (r *Registry) Unregister(c Collector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.collectors = slices.DeleteFunc(r.collectors, func(other Collector) bool {
+		return other == c
+	})
+}
+
+// WriteTo writes every registered collector's samples to w. Samples are
+// grouped by metric name, in the order the names were first seen.
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.RLock()
+	collectors := slices.Clone(r.collectors)
+	r.mu.RUnlock()
+
+	var names []string
+	byName := make(map[string][]Sample)
+	for _, c := range collectors {
+		for _, s := range c.Collect() {
+			if _, ok := byName[s.Name]; !ok {
+				names = append(names, s.Name)
+			}
+			byName[s.Name] = append(byName[s.Name], s)
+		}
+	}
+
+	cw := &countingWriter{w: bufio.NewWriter(w)}
+	for _, name := range names {
+		samples := byName[name]
+		fmt.Fprintf(cw, "# HELP %s %s\n", name, escape(samples[0].Help, false))
+		fmt.Fprintf(cw, "# TYPE %s %s\n", name, samples[0].Type)
+		for _, s := range samples {
+			fmt.Fprintf(cw, "%s%s %s\n", name, formatLabels(s.Labels), strconv.FormatFloat(s.Value, 'g', -1, 64))
+		}
+	}
+	if cw.err != nil {
+		return cw.n, cw.err
+	}
+	return cw.n, cw.w.Flush()
+}
+
+// ServeHTTP makes the registry usable as a /metrics handler.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_, _ = r.WriteTo(w)
+}
+
+func formatLabels(labels map[string]string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, k := range slices.Sorted(maps.Keys(labels)) {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(k)
+		b.WriteString(`="`)
+		b.WriteString(escape(labels[k], true))
+		b.WriteByte('"')
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+func escape(s string, quotes bool) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, "\n", `\n`)
+	if quotes {
+		s = strings.ReplaceAll(s, `"`, `\"`)
+	}
+	return s
+}
+
+type countingWriter struct {
+	w   *bufio.Writer
+	n   int64
+	err error
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	if cw.err != nil {
+		return 0, cw.err
+	}
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	cw.err = err
+	return n, err
+}
@@ -0,0 +1,82 @@
+package metrics
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistry_WriteTo(t *testing.T) {
+	t.Run("groups samples by name", func(t *testing.T) {
+		r := &Registry{}
+		r.Register(CollectorFunc(func() []Sample {
+			return []Sample{
+				{Name: "brook_a", Help: "A help.", Type: Gauge, Labels: map[string]string{"partition": "p0"}, Value: 1},
+				{Name: "brook_b_total", Help: "B help.", Type: Counter, Value: 3},
+			}
+		}))
+		r.Register(CollectorFunc(func() []Sample {
+			return []Sample{
+				{Name: "brook_a", Help: "A help.", Type: Gauge, Labels: map[string]string{"partition": "p1", "dir": "/x"}, Value: 2.5},
+			}
+		}))
+
+		var b strings.Builder
+		_, err := r.WriteTo(&b)
+		require.NoError(t, err)
+
+		expected := `# HELP brook_a A help.
+# TYPE brook_a gauge
+brook_a{partition="p0"} 1
+brook_a{dir="/x",partition="p1"} 2.5
+# HELP brook_b_total B help.
+# TYPE brook_b_total counter
+brook_b_total 3
+`
+		require.Equal(t, expected, b.String())
+	})
+
+	t.Run("escapes label values", func(t *testing.T) {
+		r := &Registry{}
+		r.Register(CollectorFunc(func() []Sample {
+			return []Sample{
+				{Name: "brook_a", Help: "h", Type: Gauge, Labels: map[string]string{"dir": "a\"b\\c\nd"}, Value: 1},
+			}
+		}))
+
+		var b strings.Builder
+		_, err := r.WriteTo(&b)
+		require.NoError(t, err)
+		require.Contains(t, b.String(), `brook_a{dir="a\"b\\c\nd"} 1`)
+	})
+
+	t.Run("unregister", func(t *testing.T) {
+		r := &Registry{}
+		c := CollectorFunc(func() []Sample {
+			return []Sample{{Name: "brook_a", Help: "h", Type: Gauge, Value: 1}}
+		})
+		r.Register(&c)
+		r.Unregister(&c)
+
+		var b strings.Builder
+		_, err := r.WriteTo(&b)
+		require.NoError(t, err)
+		require.Empty(t, b.String())
+	})
+}
+
+func TestRegistry_ServeHTTP(t *testing.T) {
+	r := &Registry{}
+	r.Register(CollectorFunc(func() []Sample {
+		return []Sample{{Name: "brook_a", Help: "h", Type: Gauge, Value: 1}}
+	}))
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+
+	require.Equal(t, 200, rec.Code)
+	require.Contains(t, rec.Header().Get("Content-Type"), "text/plain")
+	require.Contains(t, rec.Body.String(), "brook_a 1")
+}
@@ -0,0 +1,392 @@
+// Package mqtt is a small MQTT 3.1.1 client, the part of the protocol the
+// MQTT bridge needs: connecting with a persistent session, subscribing, and
+// publishing and receiving at QoS 0 and 1.
+package mqtt
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrClosed = errors.New("mqtt client closed")
+
+// Message is an application message, published or received.
+type Message struct {
+	Topic   string
+	Payload []byte
+	// QoS is 0 for at most once, 1 for at least once. QoS 2 isn't
+	// supported.
+	QoS    byte
+	Retain bool
+
+	id uint16 // packet id of a QoS 1 message received, to acknowledge it
+}
+
+// Subscription is a topic filter, with + and # wildcards, and the maximum
+// QoS messages matching it are received with.
+type Subscription struct {
+	Filter string
+	QoS    byte
+}
+
+const (
+	defaultKeepAlive = time.Minute
+	messagesBuffer   = 256
+)
+
+// Options configures a connection.
+type Options struct {
+	ClientID string
+	Username string
+	Password string
+	// CleanSession starts a new session. Without it the server keeps the
+	// subscriptions and the QoS 1 messages not acknowledged yet across
+	// connections of the client id, which is how messages aren't lost
+	// while the client is away.
+	CleanSession bool
+	// KeepAlive is how often the client pings the server when idle, which
+	// the server takes it for dead after 1.5 times. Defaults to a minute.
+	KeepAlive time.Duration
+	// TLS, when set, connects over TLS with it.
+	TLS *tls.Config
+}
+
+// Client is a connection to an MQTT server. It is safe for concurrent use.
+type Client struct {
+	conn      net.Conn
+	keepAlive time.Duration
+	messages  chan Message
+
+	writeMu sync.Mutex
+
+	mu      sync.Mutex
+	nextID  uint16
+	pending map[uint16]chan packet // waiting for their PUBACK or SUBACK
+	err     error                  // why the connection ended, once it has
+
+	done chan struct{}
+}
+
+// Dial connects to the server at address and opens a session.
+func Dial(ctx context.Context, address string, opts Options) (*Client, error) {
+	if opts.ClientID == "" && !opts.CleanSession {
+		return nil, errors.New("a persistent mqtt session needs a client id")
+	}
+	if opts.KeepAlive <= 0 {
+		opts.KeepAlive = defaultKeepAlive
+	}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	if opts.TLS != nil {
+		tlsConn := tls.Client(conn, opts.TLS)
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		conn = tlsConn
+	}
+
+	r := bufio.NewReader(conn)
+	if err := connect(ctx, conn, r, opts); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	c := &Client{
+		conn:      conn,
+		keepAlive: opts.KeepAlive,
+		messages:  make(chan Message, messagesBuffer),
+		pending:   make(map[uint16]chan packet),
+		done:      make(chan struct{}),
+	}
+	go c.readLoop(r)
+	go c.pingLoop()
+	return c, nil
+}
+
+// connect sends the CONNECT packet and waits for the CONNACK.
+func connect(ctx context.Context, conn net.Conn, r *bufio.Reader, opts Options) error {
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+		defer conn.SetDeadline(time.Time{})
+	}
+
+	var flags byte
+	if opts.CleanSession {
+		flags |= 0x02
+	}
+	if opts.Username != "" {
+		flags |= 0x80
+	}
+	if opts.Password != "" {
+		flags |= 0x40
+	}
+	body := appendString(nil, "MQTT")
+	body = append(body, 4, flags) // protocol level 4 is 3.1.1
+	body = binary.BigEndian.AppendUint16(body, uint16(opts.KeepAlive/time.Second))
+	body = appendString(body, opts.ClientID)
+	if opts.Username != "" {
+		body = appendString(body, opts.Username)
+	}
+	if opts.Password != "" {
+		body = appendString(body, opts.Password)
+	}
+	if err := writePacket(conn, packet{kind: typeConnect, body: body}); err != nil {
+		return err
+	}
+
+	p, err := readPacket(r)
+	if err != nil {
+		return err
+	}
+	if p.kind != typeConnack || len(p.body) != 2 {
+		return errMalformed
+	}
+	if code := p.body[1]; code != 0 {
+		return fmt.Errorf("mqtt server refused the connection with code %d", code)
+	}
+	return nil
+}
+
+// Messages returns the messages received on the subscriptions. It is closed
+// once the connection ends. QoS 1 messages have to be acknowledged with Ack,
+// the server sends them again on the next connection otherwise.
+func (c *Client) Messages() <-chan Message {
+	return c.messages
+}
+
+// Subscribe subscribes to subs and waits for the server to confirm.
+func (c *Client) Subscribe(ctx context.Context, subs ...Subscription) error {
+	id, reply, err := c.await()
+	if err != nil {
+		return err
+	}
+	defer c.forget(id)
+
+	body := binary.BigEndian.AppendUint16(nil, id)
+	for _, sub := range subs {
+		body = appendString(body, sub.Filter)
+		body = append(body, sub.QoS)
+	}
+	if err := c.write(packet{kind: typeSubscribe, flags: 0x02, body: body}); err != nil {
+		return err
+	}
+
+	p, err := c.wait(ctx, reply)
+	if err != nil {
+		return err
+	}
+	if p.kind != typeSuback || len(p.body) != 2+len(subs) {
+		return errMalformed
+	}
+	for i, code := range p.body[2:] {
+		if code == 0x80 {
+			return fmt.Errorf("mqtt server refused the subscription to %s", subs[i].Filter)
+		}
+	}
+	return nil
+}
+
+// Publish publishes m. A QoS 1 message is waited on until the server
+// acknowledges it.
+func (c *Client) Publish(ctx context.Context, m Message) error {
+	if m.QoS > 1 {
+		return fmt.Errorf("unsupported mqtt qos %d", m.QoS)
+	}
+	if m.QoS == 0 {
+		return c.write(publishPacket(m, 0))
+	}
+
+	id, reply, err := c.await()
+	if err != nil {
+		return err
+	}
+	defer c.forget(id)
+	if err := c.write(publishPacket(m, id)); err != nil {
+		return err
+	}
+	p, err := c.wait(ctx, reply)
+	if err != nil {
+		return err
+	}
+	if p.kind != typePuback {
+		return errMalformed
+	}
+	return nil
+}
+
+// Ack acknowledges a QoS 1 message received, for the server not to send it
+// again. It does nothing for QoS 0 messages.
+func (c *Client) Ack(m Message) error {
+	if m.QoS == 0 {
+		return nil
+	}
+	return c.write(idPacket(typePuback, 0, m.id))
+}
+
+// Err returns why the connection ended, nil while it's up.
+func (c *Client) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+// Close disconnects from the server.
+func (c *Client) Close() error {
+	err := c.write(packet{kind: typeDisconnect})
+	c.fail(ErrClosed)
+	return errors.Join(err, c.conn.Close())
+}
+
+// await returns a packet id, and the channel its reply is sent to.
+func (c *Client) await() (uint16, chan packet, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return 0, nil, c.err
+	}
+	for {
+		// 0 isn't a valid packet id
+		c.nextID++
+		if _, ok := c.pending[c.nextID]; c.nextID != 0 && !ok {
+			break
+		}
+	}
+	reply := make(chan packet, 1)
+	c.pending[c.nextID] = reply
+	return c.nextID, reply, nil
+}
+
+func (c *Client) forget(id uint16) {
+	c.mu.Lock()
+	delete(c.pending, id)
+	c.mu.Unlock()
+}
+
+func (c *Client) wait(ctx context.Context, reply chan packet) (packet, error) {
+	select {
+	case p := <-reply:
+		return p, nil
+	case <-c.done:
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return packet{}, c.err
+	case <-ctx.Done():
+		return packet{}, ctx.Err()
+	}
+}
+
+func (c *Client) write(p packet) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	select {
+	case <-c.done:
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return c.err
+	default:
+	}
+	c.conn.SetWriteDeadline(time.Now().Add(c.keepAlive))
+	return writePacket(c.conn, p)
+}
+
+// fail ends the connection with err, the first time it's called.
+func (c *Client) fail(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return
+	}
+	c.err = err
+	close(c.done)
+}
+
+func (c *Client) readLoop(r *bufio.Reader) {
+	defer close(c.messages)
+	for {
+		// The server answers the pings, so something comes well within
+		// this
+		c.conn.SetReadDeadline(time.Now().Add(c.keepAlive * 3 / 2))
+		p, err := readPacket(r)
+		if err != nil {
+			c.fail(fmt.Errorf("mqtt connection lost: %w", err))
+			c.conn.Close()
+			return
+		}
+
+		switch p.kind {
+		case typePublish:
+			m, err := parsePublish(p)
+			if err != nil {
+				c.fail(err)
+				c.conn.Close()
+				return
+			}
+			select {
+			case c.messages <- m:
+			case <-c.done:
+				return
+			}
+		case typePuback, typeSuback:
+			id, _, err := readPacketID(p.body)
+			if err != nil {
+				continue
+			}
+			c.mu.Lock()
+			reply, ok := c.pending[id]
+			c.mu.Unlock()
+			if ok {
+				select {
+				case reply <- p:
+				default: // a duplicate
+				}
+			}
+		}
+	}
+}
+
+func (c *Client) pingLoop() {
+	ticker := time.NewTicker(c.keepAlive / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+			if err := c.write(packet{kind: typePingreq}); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// Match tells if topic matches filter, where + matches a level and a trailing
+// # matches any number of them, including none.
+func Match(filter string, topic string) bool {
+	filterLevels := strings.Split(filter, "/")
+	topicLevels := strings.Split(topic, "/")
+	for i, level := range filterLevels {
+		if level == "#" {
+			return i == len(filterLevels)-1
+		}
+		if i >= len(topicLevels) {
+			return false
+		}
+		if level != "+" && level != topicLevels[i] {
+			return false
+		}
+	}
+	return len(filterLevels) == len(topicLevels)
+}
@@ -0,0 +1,164 @@
+package mqtt
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// testServer is the server side of a single connection, driven by the test.
+type testServer struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// startTestServer accepts a connection, reads its CONNECT and accepts it.
+func startTestServer(t *testing.T) (string, <-chan *testServer) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { ln.Close() })
+
+	servers := make(chan *testServer, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		t.Cleanup(func() { conn.Close() })
+		s := &testServer{t: t, conn: conn, r: bufio.NewReader(conn)}
+		if p, err := readPacket(s.r); err != nil || p.kind != typeConnect {
+			return
+		}
+		s.send(packet{kind: typeConnack, body: []byte{0, 0}})
+		servers <- s
+	}()
+	return ln.Addr().String(), servers
+}
+
+func (s *testServer) read() packet {
+	p, err := readPacket(s.r)
+	require.NoError(s.t, err)
+	return p
+}
+
+func (s *testServer) send(p packet) {
+	require.NoError(s.t, writePacket(s.conn, p))
+}
+
+func TestClient(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	addr, servers := startTestServer(t)
+	c, err := Dial(ctx, addr, Options{ClientID: "bridge"})
+	require.NoError(t, err)
+	s := <-servers
+
+	// Subscribe waits for the SUBACK
+	subscribed := make(chan error, 1)
+	go func() {
+		subscribed <- c.Subscribe(ctx, Subscription{Filter: "sensors/+/temp", QoS: 1})
+	}()
+	p := s.read()
+	require.Equal(t, byte(typeSubscribe), p.kind)
+	id, rest, err := readPacketID(p.body)
+	require.NoError(t, err)
+	filter, rest, err := readString(rest)
+	require.NoError(t, err)
+	require.Equal(t, "sensors/+/temp", filter)
+	require.Equal(t, []byte{1}, rest)
+	s.send(packet{kind: typeSuback, body: append(idPacket(0, 0, id).body, 1)})
+	require.NoError(t, <-subscribed)
+
+	// Messages received are acknowledged by Ack
+	s.send(publishPacket(Message{Topic: "sensors/a/temp", Payload: []byte("21.5"), QoS: 1}, 7))
+	m := <-c.Messages()
+	require.Equal(t, "sensors/a/temp", m.Topic)
+	require.Equal(t, "21.5", string(m.Payload))
+	require.NoError(t, c.Ack(m))
+	require.Equal(t, idPacket(typePuback, 0, 7), s.read())
+
+	// Publish waits for the PUBACK
+	published := make(chan error, 1)
+	go func() {
+		published <- c.Publish(ctx, Message{Topic: "commands/a", Payload: []byte("reset"), QoS: 1})
+	}()
+	p = s.read()
+	m, err = parsePublish(p)
+	require.NoError(t, err)
+	require.Equal(t, "commands/a", m.Topic)
+	require.Equal(t, "reset", string(m.Payload))
+	s.send(idPacket(typePuback, 0, m.id))
+	require.NoError(t, <-published)
+
+	require.NoError(t, c.Close())
+	require.Equal(t, byte(typeDisconnect), s.read().kind)
+	_, ok := <-c.Messages()
+	require.False(t, ok)
+	require.ErrorIs(t, c.Publish(ctx, Message{Topic: "commands/a"}), ErrClosed)
+}
+
+func TestClient_ConnectionLost(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	addr, servers := startTestServer(t)
+	c, err := Dial(ctx, addr, Options{ClientID: "bridge"})
+	require.NoError(t, err)
+	s := <-servers
+
+	published := make(chan error, 1)
+	go func() {
+		published <- c.Publish(ctx, Message{Topic: "commands/a", QoS: 1})
+	}()
+	s.read()
+	s.conn.Close()
+	require.ErrorContains(t, <-published, "connection lost")
+	_, ok := <-c.Messages()
+	require.False(t, ok)
+}
+
+func TestPacket(t *testing.T) {
+	// Remaining lengths of 1 to 3 bytes
+	for _, size := range []int{0, 127, 128, 16383, 16384, 100000} {
+		p := packet{kind: typePublish, flags: 2, body: make([]byte, size)}
+		r, w := net.Pipe()
+		go func() {
+			writePacket(w, p)
+			w.Close()
+		}()
+		got, err := readPacket(bufio.NewReader(r))
+		require.NoError(t, err)
+		require.Equal(t, p, got)
+	}
+
+	_, err := parsePublish(packet{kind: typePublish, flags: 2, body: []byte{0, 5, 'a'}})
+	require.ErrorIs(t, err, errMalformed)
+}
+
+func TestMatch(t *testing.T) {
+	for _, test := range []struct {
+		filter string
+		topic  string
+		match  bool
+	}{
+		{"sensors/a/temp", "sensors/a/temp", true},
+		{"sensors/a/temp", "sensors/b/temp", false},
+		{"sensors/+/temp", "sensors/b/temp", true},
+		{"sensors/+/temp", "sensors/b/c/temp", false},
+		{"sensors/+", "sensors", false},
+		{"sensors/#", "sensors", true},
+		{"sensors/#", "sensors/a/temp", true},
+		{"#", "sensors/a", true},
+		{"sensors/#/temp", "sensors/a/temp", false},
+		{"sensors", "sensors/a", false},
+	} {
+		require.Equal(t, test.match, Match(test.filter, test.topic), "%s %s", test.filter, test.topic)
+	}
+}
@@ -0,0 +1,147 @@
+package mqtt
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Control packet types, MQTT 3.1.1 section 2.2.1.
+const (
+	typeConnect    = 1
+	typeConnack    = 2
+	typePublish    = 3
+	typePuback     = 4
+	typeSubscribe  = 8
+	typeSuback     = 9
+	typePingreq    = 12
+	typePingresp   = 13
+	typeDisconnect = 14
+)
+
+// maxPacketBytes caps the remaining length of the packets read, the protocol
+// allows up to 256MiB.
+const maxPacketBytes = 16 << 20
+
+var errMalformed = errors.New("malformed mqtt packet")
+
+// packet is a control packet: its type and flags, the first byte, and what
+// follows the remaining length.
+type packet struct {
+	kind  byte
+	flags byte
+	body  []byte
+}
+
+func readPacket(r *bufio.Reader) (packet, error) {
+	first, err := r.ReadByte()
+	if err != nil {
+		return packet{}, err
+	}
+
+	// The remaining length is a varint of up to 4 bytes
+	length := 0
+	for i := 0; ; i++ {
+		if i == 4 {
+			return packet{}, errMalformed
+		}
+		b, err := r.ReadByte()
+		if err != nil {
+			return packet{}, err
+		}
+		length |= int(b&0x7f) << (7 * i)
+		if b&0x80 == 0 {
+			break
+		}
+	}
+	if length > maxPacketBytes {
+		return packet{}, fmt.Errorf("mqtt packet of %d bytes is over the %d bytes limit", length, maxPacketBytes)
+	}
+
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return packet{}, err
+	}
+	return packet{kind: first >> 4, flags: first & 0x0f, body: body}, nil
+}
+
+func writePacket(w io.Writer, p packet) error {
+	buf := make([]byte, 0, 5+len(p.body))
+	buf = append(buf, p.kind<<4|p.flags)
+	length := len(p.body)
+	for {
+		b := byte(length & 0x7f)
+		length >>= 7
+		if length > 0 {
+			b |= 0x80
+		}
+		buf = append(buf, b)
+		if length == 0 {
+			break
+		}
+	}
+	buf = append(buf, p.body...)
+	_, err := w.Write(buf)
+	return err
+}
+
+// appendString appends s prefixed with its 16 bit length.
+func appendString(b []byte, s string) []byte {
+	b = binary.BigEndian.AppendUint16(b, uint16(len(s)))
+	return append(b, s...)
+}
+
+func readString(b []byte) (string, []byte, error) {
+	if len(b) < 2 {
+		return "", nil, errMalformed
+	}
+	n := int(binary.BigEndian.Uint16(b))
+	if len(b) < 2+n {
+		return "", nil, errMalformed
+	}
+	return string(b[2 : 2+n]), b[2+n:], nil
+}
+
+func readPacketID(b []byte) (uint16, []byte, error) {
+	if len(b) < 2 {
+		return 0, nil, errMalformed
+	}
+	return binary.BigEndian.Uint16(b), b[2:], nil
+}
+
+func publishPacket(m Message, id uint16) packet {
+	flags := m.QoS << 1
+	if m.Retain {
+		flags |= 1
+	}
+	body := appendString(nil, m.Topic)
+	if m.QoS > 0 {
+		body = binary.BigEndian.AppendUint16(body, id)
+	}
+	return packet{kind: typePublish, flags: flags, body: append(body, m.Payload...)}
+}
+
+func parsePublish(p packet) (Message, error) {
+	m := Message{QoS: (p.flags >> 1) & 3, Retain: p.flags&1 != 0}
+	if m.QoS > 1 {
+		return Message{}, fmt.Errorf("unsupported mqtt qos %d", m.QoS)
+	}
+	topic, rest, err := readString(p.body)
+	if err != nil {
+		return Message{}, err
+	}
+	m.Topic = topic
+	if m.QoS > 0 {
+		if m.id, rest, err = readPacketID(rest); err != nil {
+			return Message{}, err
+		}
+	}
+	m.Payload = rest
+	return m, nil
+}
+
+func idPacket(kind byte, flags byte, id uint16) packet {
+	return packet{kind: kind, flags: flags, body: binary.BigEndian.AppendUint16(nil, id)}
+}
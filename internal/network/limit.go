@@ -0,0 +1,49 @@
+package network
+
+import (
+	"log/slog"
+	"net"
+	"sync"
+)
+
+// limitListener closes the connections it accepts over its limit right away,
+// so a storm of them can't use up the broker's file descriptors and
+// goroutines. Clients see the connection closed and retry later.
+type limitListener struct {
+	net.Listener
+	slots  chan struct{} // holds a token for every open connection
+	logger *slog.Logger
+}
+
+func newLimitListener(ln net.Listener, maxConnections int, logger *slog.Logger) *limitListener {
+	return &limitListener{Listener: ln, slots: make(chan struct{}, maxConnections), logger: logger}
+}
+
+func (l *limitListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		select {
+		case l.slots <- struct{}{}:
+			return &limitedConn{Conn: conn, release: func() { <-l.slots }}, nil
+		default:
+			l.logger.Debug("refused connection over the limit", "remote", conn.RemoteAddr().String(), "max_connections", cap(l.slots))
+			conn.Close()
+		}
+	}
+}
+
+// limitedConn gives its slot back once closed.
+type limitedConn struct {
+	net.Conn
+	release func()
+	once    sync.Once
+}
+
+func (c *limitedConn) Close() error {
+	err := c.Conn.Close()
+	c.once.Do(c.release)
+	return err
+}
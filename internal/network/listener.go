@@ -0,0 +1,130 @@
+// Package network is the network side of the broker. It opens the listeners,
+// optionally serving TLS with certificates that are reloaded without a
+// restart, and bounds the requests handled at once with a HandlerPool.
+package network
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// Config configures a listener.
+type Config struct {
+	// Address is the TCP address to listen on, like ":9092" or
+	// "127.0.0.1:9092".
+	Address string
+	// TLS serves TLS on the listener when set. Without it connections are
+	// plain TCP, only fit for localhost.
+	TLS *TLSConfig
+	// MaxConnections caps the connections open at once. The ones accepted
+	// over it are closed right away. Zero leaves them unlimited.
+	MaxConnections int
+	// ReadTimeout bounds how long a read waits for the client, replacing
+	// the read deadline of the connection. Zero lets reads wait forever.
+	ReadTimeout time.Duration
+	// WriteTimeout bounds how long a write waits for the client to take
+	// the data, replacing the write deadline of the connection. Zero lets
+	// writes wait forever.
+	WriteTimeout time.Duration
+	// IdleTimeout closes the connections nothing was read from or written
+	// to for that long. Zero keeps them open.
+	IdleTimeout time.Duration
+	// KeepAlive is the interval of the TCP keep-alive probes, which find out
+	// about clients that went away without closing their connection.
+	// Defaults to 15 seconds, negative turns them off.
+	KeepAlive time.Duration
+	// Logger receives certificate reloads. Defaults to slog.Default().
+	Logger *slog.Logger
+}
+
+// Listener is a TCP listener that serves TLS when configured to.
+type Listener struct {
+	net.Listener
+	certs  *certReloader // nil without TLS
+	logger *slog.Logger
+}
+
+// Listen starts listening on config.Address.
+func Listen(config Config) (*Listener, error) {
+	if config.Address == "" {
+		return nil, errors.New("listen address is required")
+	}
+	if config.MaxConnections < 0 {
+		return nil, errors.New("max connections can't be negative")
+	}
+	if config.ReadTimeout < 0 || config.WriteTimeout < 0 || config.IdleTimeout < 0 {
+		return nil, errors.New("connection timeouts can't be negative")
+	}
+	logger := config.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+
+	var certs *certReloader
+	if config.TLS != nil {
+		var err error
+		certs, err = newCertReloader(*config.TLS)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	lc := net.ListenConfig{KeepAlive: config.KeepAlive}
+	ln, err := lc.Listen(context.Background(), "tcp", config.Address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", config.Address, err)
+	}
+	logger = logger.With("address", ln.Addr().String())
+	if config.MaxConnections > 0 {
+		ln = newLimitListener(ln, config.MaxConnections, logger)
+	}
+	if config.ReadTimeout > 0 || config.WriteTimeout > 0 || config.IdleTimeout > 0 {
+		ln = &timeoutListener{Listener: ln, read: config.ReadTimeout, write: config.WriteTimeout, idle: config.IdleTimeout, logger: logger}
+	}
+	if certs != nil {
+		ln = tls.NewListener(ln, certs.tlsConfig())
+	}
+	return &Listener{Listener: ln, certs: certs, logger: logger}, nil
+}
+
+// ReloadCertificates loads the certificate, key and client CAs again from
+// their files. Connections accepted from then on use them, the ones already
+// open are left alone. If loading fails the previous ones stay in use.
+func (l *Listener) ReloadCertificates() error {
+	if l.certs == nil {
+		return nil
+	}
+	if err := l.certs.reload(); err != nil {
+		return err
+	}
+	l.logger.Info("reloaded TLS certificates")
+	return nil
+}
+
+// ReloadOnSIGHUP reloads the certificates every time the process gets a
+// SIGHUP, until ctx is done. A failed reload is logged and the previous
+// certificates stay in use.
+func (l *Listener) ReloadOnSIGHUP(ctx context.Context) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	for {
+		select {
+		case <-hup:
+			if err := l.ReloadCertificates(); err != nil {
+				l.logger.Error("failed to reload TLS certificates", "error", err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,264 @@
+package network
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// testCA issues certificates for the tests.
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pool *x509.CertPool
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "brook test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return &testCA{cert: cert, key: key, pool: pool}
+}
+
+// issue writes a certificate and key signed by the CA to dir, and returns
+// their paths.
+func (ca *testCA) issue(t *testing.T, dir string, name string, serial int64) (string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	certFile := filepath.Join(dir, name+".crt")
+	keyFile := filepath.Join(dir, name+".key")
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o644))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600))
+	return certFile, keyFile
+}
+
+func (ca *testCA) writePEM(t *testing.T, path string) {
+	t.Helper()
+	require.NoError(t, os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.cert.Raw}), 0o644))
+}
+
+// serve echoes one line back on every connection accepted by ln.
+func serve(ln net.Listener) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer conn.Close()
+			buf := make([]byte, 5)
+			if _, err := io.ReadFull(conn, buf); err == nil {
+				conn.Write(buf)
+			}
+		}()
+	}
+}
+
+// roundTrip dials ln with config and returns the serial of the server's
+// certificate.
+func roundTrip(ln net.Listener, config *tls.Config) (int64, error) {
+	conn, err := tls.Dial("tcp", ln.Addr().String(), config)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte("hello")); err != nil {
+		return 0, err
+	}
+	buf := make([]byte, 5)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		return 0, err
+	}
+	return conn.ConnectionState().PeerCertificates[0].SerialNumber.Int64(), nil
+}
+
+func TestListener(t *testing.T) {
+	t.Run("plain TCP", func(t *testing.T) {
+		ln, err := Listen(Config{Address: "127.0.0.1:0"})
+		require.NoError(t, err)
+		defer ln.Close()
+		go serve(ln)
+
+		conn, err := net.Dial("tcp", ln.Addr().String())
+		require.NoError(t, err)
+		defer conn.Close()
+		_, err = conn.Write([]byte("hello"))
+		require.NoError(t, err)
+		buf := make([]byte, 5)
+		_, err = io.ReadFull(conn, buf)
+		require.NoError(t, err)
+		require.NoError(t, ln.ReloadCertificates())
+	})
+	t.Run("TLS with certificate reload", func(t *testing.T) {
+		dir := t.TempDir()
+		ca := newTestCA(t)
+		certFile, keyFile := ca.issue(t, dir, "server", 10)
+
+		ln, err := Listen(Config{Address: "127.0.0.1:0", TLS: &TLSConfig{CertFile: certFile, KeyFile: keyFile}})
+		require.NoError(t, err)
+		defer ln.Close()
+		go serve(ln)
+
+		client := &tls.Config{RootCAs: ca.pool, ServerName: "localhost"}
+		serial, err := roundTrip(ln, client)
+		require.NoError(t, err)
+		require.Equal(t, int64(10), serial)
+
+		// A broken file keeps the certificate in use
+		require.NoError(t, os.WriteFile(keyFile, []byte("garbage"), 0o600))
+		require.Error(t, ln.ReloadCertificates())
+		serial, err = roundTrip(ln, client)
+		require.NoError(t, err)
+		require.Equal(t, int64(10), serial)
+
+		ca.issue(t, dir, "server", 11)
+		require.NoError(t, ln.ReloadCertificates())
+		serial, err = roundTrip(ln, client)
+		require.NoError(t, err)
+		require.Equal(t, int64(11), serial)
+	})
+	t.Run("mutual TLS", func(t *testing.T) {
+		dir := t.TempDir()
+		ca := newTestCA(t)
+		certFile, keyFile := ca.issue(t, dir, "server", 10)
+		clientCert, clientKey := ca.issue(t, dir, "client", 20)
+		caFile := filepath.Join(dir, "ca.crt")
+		ca.writePEM(t, caFile)
+
+		ln, err := Listen(Config{
+			Address: "127.0.0.1:0",
+			TLS:     &TLSConfig{CertFile: certFile, KeyFile: keyFile, ClientCAFile: caFile},
+		})
+		require.NoError(t, err)
+		defer ln.Close()
+		go serve(ln)
+
+		pair, err := tls.LoadX509KeyPair(clientCert, clientKey)
+		require.NoError(t, err)
+		_, err = roundTrip(ln, &tls.Config{RootCAs: ca.pool, ServerName: "localhost", Certificates: []tls.Certificate{pair}})
+		require.NoError(t, err)
+
+		// No client certificate, or one from another CA, is turned away
+		_, err = roundTrip(ln, &tls.Config{RootCAs: ca.pool, ServerName: "localhost"})
+		require.Error(t, err)
+
+		otherDir := t.TempDir()
+		otherCert, otherKey := newTestCA(t).issue(t, otherDir, "client", 30)
+		pair, err = tls.LoadX509KeyPair(otherCert, otherKey)
+		require.NoError(t, err)
+		_, err = roundTrip(ln, &tls.Config{RootCAs: ca.pool, ServerName: "localhost", Certificates: []tls.Certificate{pair}})
+		require.Error(t, err)
+	})
+	t.Run("connections over the limit are closed", func(t *testing.T) {
+		ln, err := Listen(Config{Address: "127.0.0.1:0", MaxConnections: 1})
+		require.NoError(t, err)
+		defer ln.Close()
+		go serve(ln)
+
+		first, err := net.Dial("tcp", ln.Addr().String())
+		require.NoError(t, err)
+		defer first.Close()
+		second, err := net.Dial("tcp", ln.Addr().String())
+		require.NoError(t, err)
+		defer second.Close()
+		_, err = io.ReadFull(second, make([]byte, 1))
+		require.ErrorIs(t, err, io.EOF)
+
+		// The slot of the first one is free again once it's closed
+		_, err = first.Write([]byte("hello"))
+		require.NoError(t, err)
+		_, err = io.ReadFull(first, make([]byte, 5))
+		require.NoError(t, err)
+		require.Eventually(t, func() bool {
+			conn, err := net.Dial("tcp", ln.Addr().String())
+			if err != nil {
+				return false
+			}
+			defer conn.Close()
+			if _, err := conn.Write([]byte("hello")); err != nil {
+				return false
+			}
+			_, err = io.ReadFull(conn, make([]byte, 5))
+			return err == nil
+		}, time.Second, 10*time.Millisecond)
+	})
+	t.Run("stalled connections are closed", func(t *testing.T) {
+		for _, config := range []Config{
+			{Address: "127.0.0.1:0", IdleTimeout: 50 * time.Millisecond},
+			{Address: "127.0.0.1:0", ReadTimeout: 50 * time.Millisecond},
+		} {
+			ln, err := Listen(config)
+			require.NoError(t, err)
+			defer ln.Close()
+			go serve(ln)
+
+			conn, err := net.Dial("tcp", ln.Addr().String())
+			require.NoError(t, err)
+			defer conn.Close()
+			// Half a message, then nothing
+			_, err = conn.Write([]byte("he"))
+			require.NoError(t, err)
+			require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+			_, err = io.ReadFull(conn, make([]byte, 1))
+			require.ErrorIs(t, err, io.EOF)
+		}
+	})
+	t.Run("invalid config", func(t *testing.T) {
+		_, err := Listen(Config{Address: "127.0.0.1:0", TLS: &TLSConfig{CertFile: "server.crt"}})
+		require.Error(t, err)
+		_, err = Listen(Config{Address: "127.0.0.1:0", TLS: &TLSConfig{CertFile: "missing.crt", KeyFile: "missing.key"}})
+		require.Error(t, err)
+		_, err = Listen(Config{})
+		require.Error(t, err)
+		_, err = Listen(Config{Address: "127.0.0.1:0", MaxConnections: -1})
+		require.Error(t, err)
+		_, err = Listen(Config{Address: "127.0.0.1:0", IdleTimeout: -time.Second})
+		require.Error(t, err)
+	})
+}
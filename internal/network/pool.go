@@ -0,0 +1,119 @@
+package network
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"sync/atomic"
+)
+
+// ErrBusy is what the requests a HandlerPool sheds are answered with, as a
+// 503 with a Retry-After header. They weren't handled at all, so they can be
+// sent again as they are.
+var ErrBusy = errors.New("broker busy, retry later")
+
+// PoolConfig configures a HandlerPool.
+type PoolConfig struct {
+	// Handlers is how many requests are handled at once. Zero leaves it
+	// unlimited.
+	Handlers int
+	// QueueDepth is how many requests wait for a handler while they are all
+	// busy. The ones over it are shed.
+	QueueDepth int
+	// ConnectionQueueDepth caps the requests of a single connection that
+	// are handled or waiting at once, so a client multiplexing requests
+	// can't take the whole queue. The ones over it are shed. Zero leaves it
+	// unlimited.
+	ConnectionQueueDepth int
+	// Logger receives the shed requests. Defaults to slog.Default().
+	Logger *slog.Logger
+}
+
+// HandlerPool runs the requests of a server on a bounded number of handlers,
+// queueing the ones that find them all busy and shedding them once the queue
+// is full, so the broker degrades gracefully instead of piling up
+// goroutines. Its ConnContext must be the ConnContext of the server for
+// ConnectionQueueDepth to apply.
+type HandlerPool struct {
+	handler        http.Handler
+	handlers       chan struct{} // holds a token for every request handled, nil without a limit
+	queue          chan struct{} // holds a token for every request waiting
+	connQueueDepth int32
+	logger         *slog.Logger
+}
+
+// connRequestsKey is the context key of the requests of a connection in
+// flight.
+type connRequestsKey struct{}
+
+func NewHandlerPool(h http.Handler, config PoolConfig) (*HandlerPool, error) {
+	if config.Handlers < 0 || config.QueueDepth < 0 || config.ConnectionQueueDepth < 0 {
+		return nil, errors.New("handlers and queue depths can't be negative")
+	}
+	logger := config.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+
+	p := &HandlerPool{handler: h, connQueueDepth: int32(config.ConnectionQueueDepth), logger: logger}
+	if config.Handlers > 0 {
+		p.handlers = make(chan struct{}, config.Handlers)
+		p.queue = make(chan struct{}, config.QueueDepth)
+	}
+	return p, nil
+}
+
+// ConnContext keeps count of the requests of the connection c in flight.
+func (p *HandlerPool) ConnContext(ctx context.Context, c net.Conn) context.Context {
+	return context.WithValue(ctx, connRequestsKey{}, new(atomic.Int32))
+}
+
+func (p *HandlerPool) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if inFlight, ok := r.Context().Value(connRequestsKey{}).(*atomic.Int32); ok && p.connQueueDepth > 0 {
+		defer inFlight.Add(-1)
+		if inFlight.Add(1) > p.connQueueDepth {
+			p.shed(w, r, "connection queue full")
+			return
+		}
+	}
+	if p.handlers == nil {
+		p.handler.ServeHTTP(w, r)
+		return
+	}
+
+	select {
+	case p.handlers <- struct{}{}:
+	default:
+		// Every handler is busy, wait for one if there's room to
+		select {
+		case p.queue <- struct{}{}:
+		default:
+			p.shed(w, r, "queue full")
+			return
+		}
+		select {
+		case p.handlers <- struct{}{}:
+			<-p.queue
+		case <-r.Context().Done():
+			// The client went away, nobody reads the answer
+			<-p.queue
+			return
+		}
+	}
+	defer func() { <-p.handlers }()
+	p.handler.ServeHTTP(w, r)
+}
+
+// shed answers r with ErrBusy without handling it.
+func (p *HandlerPool) shed(w http.ResponseWriter, r *http.Request, reason string) {
+	p.logger.Debug("shed request", "reason", reason, "remote", r.RemoteAddr, "method", r.Method, "path", r.URL.Path)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Retry-After", "1")
+	w.WriteHeader(http.StatusServiceUnavailable)
+	_ = json.NewEncoder(w).Encode(struct {
+		Error string `json:"error"`
+	}{ErrBusy.Error()})
+}
@@ -0,0 +1,94 @@
+package network
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandlerPool(t *testing.T) {
+	// blocking answers once release is closed, telling entered first
+	blocking := func() (http.Handler, chan struct{}, chan struct{}) {
+		entered, release := make(chan struct{}, 10), make(chan struct{})
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			entered <- struct{}{}
+			<-release
+		}), entered, release
+	}
+	// send serves a request with ctx in the background
+	send := func(h http.Handler, ctx context.Context) chan *httptest.ResponseRecorder {
+		done := make(chan *httptest.ResponseRecorder, 1)
+		go func() {
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequestWithContext(ctx, http.MethodGet, "/", nil))
+			done <- w
+		}()
+		return done
+	}
+
+	t.Run("requests over the queue are shed", func(t *testing.T) {
+		h, entered, release := blocking()
+		p, err := NewHandlerPool(h, PoolConfig{Handlers: 1, QueueDepth: 1})
+		require.NoError(t, err)
+
+		handled := send(p, context.Background())
+		<-entered
+		queued := send(p, context.Background())
+		require.Eventually(t, func() bool { return len(p.queue) == 1 }, time.Second, time.Millisecond)
+
+		shed := <-send(p, context.Background())
+		require.Equal(t, http.StatusServiceUnavailable, shed.Code)
+		require.Equal(t, "1", shed.Header().Get("Retry-After"))
+		require.Contains(t, shed.Body.String(), ErrBusy.Error())
+
+		close(release)
+		require.Equal(t, http.StatusOK, (<-handled).Code)
+		require.Equal(t, http.StatusOK, (<-queued).Code)
+		require.Empty(t, p.handlers)
+	})
+
+	t.Run("queued request whose client went away", func(t *testing.T) {
+		h, entered, release := blocking()
+		defer close(release)
+		p, err := NewHandlerPool(h, PoolConfig{Handlers: 1, QueueDepth: 1})
+		require.NoError(t, err)
+
+		send(p, context.Background())
+		<-entered
+		ctx, cancel := context.WithCancel(context.Background())
+		queued := send(p, ctx)
+		require.Eventually(t, func() bool { return len(p.queue) == 1 }, time.Second, time.Millisecond)
+		cancel()
+		<-queued
+		require.Empty(t, p.queue)
+	})
+
+	t.Run("connection queue depth", func(t *testing.T) {
+		h, entered, release := blocking()
+		p, err := NewHandlerPool(h, PoolConfig{ConnectionQueueDepth: 1})
+		require.NoError(t, err)
+
+		conn := p.ConnContext(context.Background(), nil)
+		first := send(p, conn)
+		<-entered
+		require.Equal(t, http.StatusServiceUnavailable, (<-send(p, conn)).Code)
+
+		// Other connections have their own
+		other := send(p, p.ConnContext(context.Background(), nil))
+		<-entered
+		close(release)
+		require.Equal(t, http.StatusOK, (<-first).Code)
+		require.Equal(t, http.StatusOK, (<-other).Code)
+	})
+
+	t.Run("invalid config", func(t *testing.T) {
+		_, err := NewHandlerPool(http.NotFoundHandler(), PoolConfig{Handlers: -1})
+		require.Error(t, err)
+		_, err = NewHandlerPool(http.NotFoundHandler(), PoolConfig{Handlers: 1, QueueDepth: -1})
+		require.Error(t, err)
+	})
+}
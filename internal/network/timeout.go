@@ -0,0 +1,75 @@
+package network
+
+import (
+	"log/slog"
+	"net"
+	"time"
+)
+
+// timeoutListener applies the timeouts of a Config to the connections it
+// accepts, so dead clients don't pin a goroutine and a file descriptor
+// forever.
+type timeoutListener struct {
+	net.Listener
+	read, write, idle time.Duration
+	logger            *slog.Logger
+}
+
+func (l *timeoutListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	c := &timeoutConn{Conn: conn, read: l.read, write: l.write, idle: l.idle}
+	if l.idle > 0 {
+		c.idleTimer = time.AfterFunc(l.idle, func() {
+			l.logger.Debug("closed idle connection", "remote", conn.RemoteAddr().String(), "idle_timeout", l.idle)
+			conn.Close()
+		})
+	}
+	return c, nil
+}
+
+// timeoutConn sets a deadline before every read and write, and is closed
+// once nothing went through it either way for its idle timeout.
+type timeoutConn struct {
+	net.Conn
+	read, write, idle time.Duration
+	idleTimer         *time.Timer // nil without an idle timeout
+}
+
+func (c *timeoutConn) Read(b []byte) (int, error) {
+	if c.read > 0 {
+		if err := c.Conn.SetReadDeadline(time.Now().Add(c.read)); err != nil {
+			return 0, err
+		}
+	}
+	n, err := c.Conn.Read(b)
+	c.active(n)
+	return n, err
+}
+
+func (c *timeoutConn) Write(b []byte) (int, error) {
+	if c.write > 0 {
+		if err := c.Conn.SetWriteDeadline(time.Now().Add(c.write)); err != nil {
+			return 0, err
+		}
+	}
+	n, err := c.Conn.Write(b)
+	c.active(n)
+	return n, err
+}
+
+// active pushes the idle timeout back after n bytes went through.
+func (c *timeoutConn) active(n int) {
+	if n > 0 && c.idleTimer != nil {
+		c.idleTimer.Reset(c.idle)
+	}
+}
+
+func (c *timeoutConn) Close() error {
+	if c.idleTimer != nil {
+		c.idleTimer.Stop()
+	}
+	return c.Conn.Close()
+}
@@ -0,0 +1,93 @@
+package network
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"sync/atomic"
+)
+
+// TLSConfig points to the PEM files a listener serves TLS with.
+type TLSConfig struct {
+	CertFile string
+	KeyFile  string
+	// ClientCAFile turns on mutual TLS: clients must present a certificate
+	// signed by one of the CAs in it.
+	ClientCAFile string
+}
+
+func (c TLSConfig) validate() error {
+	if c.CertFile == "" || c.KeyFile == "" {
+		return errors.New("TLS needs both a certificate and a key file")
+	}
+	return nil
+}
+
+// certReloader hands out the latest certificate and client CAs to every new
+// TLS connection, so they can be replaced while the listener runs.
+type certReloader struct {
+	config    TLSConfig
+	cert      atomic.Pointer[tls.Certificate]
+	clientCAs atomic.Pointer[x509.CertPool] // nil without mutual TLS
+}
+
+func newCertReloader(config TLSConfig) (*certReloader, error) {
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+	r := &certReloader{config: config}
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// reload loads every file again, replacing nothing unless all of them load.
+func (r *certReloader) reload() error {
+	cert, err := tls.LoadX509KeyPair(r.config.CertFile, r.config.KeyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load TLS certificate: %w", err)
+	}
+
+	var pool *x509.CertPool
+	if r.config.ClientCAFile != "" {
+		data, err := os.ReadFile(r.config.ClientCAFile)
+		if err != nil {
+			return fmt.Errorf("failed to read client CAs: %w", err)
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return fmt.Errorf("no certificate found in %s", r.config.ClientCAFile)
+		}
+	}
+
+	r.cert.Store(&cert)
+	r.clientCAs.Store(pool)
+	return nil
+}
+
+func (r *certReloader) tlsConfig() *tls.Config {
+	base := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return r.cert.Load(), nil
+		},
+	}
+	if r.config.ClientCAFile == "" {
+		return base
+	}
+
+	// The client CAs aren't looked up per handshake like the certificate,
+	// so every handshake gets a config with the current ones
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			config := base.Clone()
+			config.ClientAuth = tls.RequireAndVerifyClientCert
+			config.ClientCAs = r.clientCAs.Load()
+			return config, nil
+		},
+	}
+}
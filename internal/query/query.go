@@ -0,0 +1,244 @@
+// Package query scans partitions for the records matching conditions on their
+// offsets, timestamps and JSON payload fields, and picks fields out of them.
+// It is for debugging and ad hoc analytics: there are no indexes on payloads,
+// every query reads the whole offset range it is given.
+package query
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"iter"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mvaleed/brook/internal/storage"
+)
+
+// Op compares a payload field with the value of a condition.
+type Op string
+
+const (
+	OpEqual        Op = "="
+	OpNotEqual     Op = "!="
+	OpLess         Op = "<"
+	OpLessEqual    Op = "<="
+	OpGreater      Op = ">"
+	OpGreaterEqual Op = ">="
+	// OpContains matches strings containing the value.
+	OpContains Op = "~"
+)
+
+// Longer operators first, so that <= isn't read as <.
+var ops = []Op{OpNotEqual, OpLessEqual, OpGreaterEqual, OpEqual, OpLess, OpGreater, OpContains}
+
+// Condition is a comparison of the field of a payload, a dotted path into
+// its JSON object, with a value. A number field is compared as a number if
+// the value is one, any other field as its JSON text, strings unquoted.
+type Condition struct {
+	Field string
+	Op    Op
+	Value string
+}
+
+// ParseCondition parses a condition written as FIELD OP VALUE, such as
+// status=paid or total>=100.
+func ParseCondition(s string) (Condition, error) {
+	best := -1
+	var op Op
+	for _, candidate := range ops {
+		if i := strings.Index(s, string(candidate)); i > 0 && (best < 0 || i < best) {
+			best, op = i, candidate
+		}
+	}
+	if best < 0 {
+		return Condition{}, fmt.Errorf("invalid condition %q, expected FIELD OP VALUE with OP one of %v", s, ops)
+	}
+	return Condition{
+		Field: strings.TrimSpace(s[:best]),
+		Op:    op,
+		Value: strings.TrimSpace(s[best+len(op):]),
+	}, nil
+}
+
+// matches tells if the payload field v, missing if ok is false, satisfies c.
+// A missing field only satisfies !=.
+func (c Condition) matches(v any, ok bool) bool {
+	if !ok {
+		return c.Op == OpNotEqual
+	}
+
+	var cmp int
+	number, isNumber := v.(float64)
+	value, err := strconv.ParseFloat(c.Value, 64)
+	if isNumber && err == nil {
+		switch {
+		case number < value:
+			cmp = -1
+		case number > value:
+			cmp = 1
+		}
+	} else {
+		text := fieldText(v)
+		if c.Op == OpContains {
+			return strings.Contains(text, c.Value)
+		}
+		cmp = strings.Compare(text, c.Value)
+	}
+
+	switch c.Op {
+	case OpEqual:
+		return cmp == 0
+	case OpNotEqual:
+		return cmp != 0
+	case OpLess:
+		return cmp < 0
+	case OpLessEqual:
+		return cmp <= 0
+	case OpGreater:
+		return cmp > 0
+	case OpGreaterEqual:
+		return cmp >= 0
+	default:
+		return false
+	}
+}
+
+// fieldText is a field as text: strings as they are, anything else as JSON.
+func fieldText(v any) string {
+	if s, ok := v.(string); ok {
+		return s
+	}
+	data, _ := json.Marshal(v)
+	return string(data)
+}
+
+// Query selects records of partitions. The zero Query selects every record
+// with its whole payload.
+type Query struct {
+	// Select are the payload fields returned, dotted paths into the JSON
+	// object. If empty the whole payload is.
+	Select []string
+	// Where are conditions a record must all satisfy.
+	Where []Condition
+	// FromOffset and ToOffset bound the offsets scanned, ToOffset excluded
+	// and zero for the end of the partition.
+	FromOffset int
+	ToOffset   int
+	// Since and Until bound the timestamps, Until excluded. Zero leaves
+	// them unbounded.
+	Since time.Time
+	Until time.Time
+	// Limit caps the rows across every source, zero for no limit.
+	Limit int
+}
+
+// Source is a partition to query, and the name it is reported under.
+type Source struct {
+	Name      string
+	Partition *storage.Partition
+}
+
+// Row is a record a query selected.
+type Row struct {
+	Source    string
+	Offset    int
+	Timestamp time.Time
+	// Fields are the values of the selected fields, in order, nil for the
+	// ones the payload doesn't have.
+	Fields []any
+	// Payload is the whole payload, when no field is selected.
+	Payload []byte
+}
+
+// Run runs q over sources one after the other, yielding the rows it selects
+// in offset order. It stops at the first error, after yielding it.
+func Run(ctx context.Context, sources []Source, q Query) iter.Seq2[Row, error] {
+	return func(yield func(Row, error) bool) {
+		rows := 0
+		for _, source := range sources {
+			p := source.Partition
+			end := p.NextOffset()
+			if q.ToOffset > 0 && q.ToOffset < end {
+				end = q.ToOffset
+			}
+			for offset := max(q.FromOffset, p.FirstOffset()); offset < end; offset++ {
+				if q.Limit > 0 && rows == q.Limit {
+					return
+				}
+				record, err := p.ReadContext(ctx, offset)
+				if errors.Is(err, storage.ErrRecordExpired) {
+					continue
+				}
+				if err != nil {
+					yield(Row{}, fmt.Errorf("offset %d of %s: %w", offset, source.Name, err))
+					return
+				}
+
+				row, ok := q.match(record)
+				if !ok {
+					continue
+				}
+				row.Source = source.Name
+				row.Offset = offset
+				rows++
+				if !yield(row, nil) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// match returns the row of record if q selects it.
+func (q Query) match(record storage.Record) (Row, bool) {
+	row := Row{Timestamp: time.Unix(0, int64(record.Header.Timestamp))}
+	if !q.Since.IsZero() && row.Timestamp.Before(q.Since) {
+		return Row{}, false
+	}
+	if !q.Until.IsZero() && !row.Timestamp.Before(q.Until) {
+		return Row{}, false
+	}
+	if len(q.Select) == 0 && len(q.Where) == 0 {
+		row.Payload = record.Payload
+		return row, true
+	}
+
+	// Payloads that aren't JSON objects have no fields to match
+	var object map[string]any
+	if err := json.Unmarshal(record.Payload, &object); err != nil {
+		return Row{}, false
+	}
+	for _, c := range q.Where {
+		v, ok := field(object, c.Field)
+		if !c.matches(v, ok) {
+			return Row{}, false
+		}
+	}
+	if len(q.Select) == 0 {
+		row.Payload = record.Payload
+		return row, true
+	}
+	row.Fields = make([]any, len(q.Select))
+	for i, name := range q.Select {
+		row.Fields[i], _ = field(object, name)
+	}
+	return row, true
+}
+
+// field returns the field at the dotted path in object.
+func field(object map[string]any, path string) (any, bool) {
+	var v any = object
+	for name := range strings.SplitSeq(path, ".") {
+		m, ok := v.(map[string]any)
+		if !ok {
+			return nil, false
+		}
+		if v, ok = m[name]; !ok {
+			return nil, false
+		}
+	}
+	return v, true
+}
@@ -0,0 +1,113 @@
+package query
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/mvaleed/brook/internal/storage"
+)
+
+func openTestSource(t *testing.T, name string, payloads ...string) Source {
+	t.Helper()
+	p, err := storage.NewPartitionWithConfig(filepath.Join(t.TempDir(), name), storage.DefaultPartitionConfig())
+	require.NoError(t, err)
+	t.Cleanup(func() { p.Close() })
+	for _, payload := range payloads {
+		require.NoError(t, p.Append([]byte(payload)))
+	}
+	return Source{Name: name, Partition: p}
+}
+
+func collect(t *testing.T, sources []Source, q Query) []Row {
+	t.Helper()
+	var rows []Row
+	for row, err := range Run(context.Background(), sources, q) {
+		require.NoError(t, err)
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+func TestRun(t *testing.T) {
+	orders := openTestSource(t, "orders",
+		`{"id": 1, "status": "paid", "total": 120, "customer": {"country": "FR"}}`,
+		`{"id": 2, "status": "new", "total": 15}`,
+		`not json`,
+		`{"id": 3, "status": "paid", "total": 99.5, "customer": {"country": "DE"}}`,
+	)
+	refunds := openTestSource(t, "refunds", `{"id": 9, "status": "paid", "total": 500}`)
+	sources := []Source{orders, refunds}
+
+	rows := collect(t, sources, Query{})
+	require.Len(t, rows, 5)
+	require.Equal(t, "not json", string(rows[2].Payload))
+
+	where := func(conditions ...string) []Condition {
+		var cs []Condition
+		for _, s := range conditions {
+			c, err := ParseCondition(s)
+			require.NoError(t, err)
+			cs = append(cs, c)
+		}
+		return cs
+	}
+
+	rows = collect(t, sources, Query{Select: []string{"id", "customer.country"}, Where: where("status=paid", "total>=100")})
+	require.Len(t, rows, 2)
+	require.Equal(t, Row{Source: "orders", Offset: 0, Timestamp: rows[0].Timestamp, Fields: []any{1.0, "FR"}}, rows[0])
+	require.Equal(t, "refunds", rows[1].Source)
+	require.Equal(t, []any{9.0, nil}, rows[1].Fields)
+
+	for _, test := range []struct {
+		where []string
+		ids   []any
+	}{
+		{[]string{"total<100"}, []any{2.0, 3.0}},
+		{[]string{"status!=paid"}, []any{2.0}},
+		{[]string{"customer.country!=FR"}, []any{2.0, 3.0, 9.0}},
+		{[]string{"customer~{"}, []any{1.0, 3.0}},
+		{[]string{"customer.country>E"}, []any{1.0}},
+		{[]string{"id=3"}, []any{3.0}},
+	} {
+		var ids []any
+		for _, row := range collect(t, sources, Query{Select: []string{"id"}, Where: where(test.where...)}) {
+			ids = append(ids, row.Fields[0])
+		}
+		require.Equal(t, test.ids, ids, test.where)
+	}
+
+	// Offsets, limit and time range
+	rows = collect(t, sources, Query{FromOffset: 1, ToOffset: 3})
+	require.Len(t, rows, 2)
+	require.Equal(t, []int{1, 2}, []int{rows[0].Offset, rows[1].Offset})
+	rows = collect(t, sources, Query{Limit: 2})
+	require.Len(t, rows, 2)
+	rows = collect(t, sources, Query{Since: time.Now().Add(time.Hour)})
+	require.Empty(t, rows)
+	rows = collect(t, sources, Query{Until: time.Now().Add(time.Hour)})
+	require.Len(t, rows, 5)
+}
+
+func TestParseCondition(t *testing.T) {
+	for s, want := range map[string]Condition{
+		"status=paid":      {Field: "status", Op: OpEqual, Value: "paid"},
+		"status != paid":   {Field: "status", Op: OpNotEqual, Value: "paid"},
+		"total<=10":        {Field: "total", Op: OpLessEqual, Value: "10"},
+		"total>=10":        {Field: "total", Op: OpGreaterEqual, Value: "10"},
+		"a.b<1":            {Field: "a.b", Op: OpLess, Value: "1"},
+		"note~a=b":         {Field: "note", Op: OpContains, Value: "a=b"},
+		"url=http://x?a=b": {Field: "url", Op: OpEqual, Value: "http://x?a=b"},
+	} {
+		c, err := ParseCondition(s)
+		require.NoError(t, err, s)
+		require.Equal(t, want, c, s)
+	}
+	for _, s := range []string{"status", "=paid", ""} {
+		_, err := ParseCondition(s)
+		require.Error(t, err, s)
+	}
+}
@@ -2,31 +2,62 @@
 package asyncwriter
 
 import (
-	"bufio"
 	"bytes"
 	"errors"
 	"io"
+	"log/slog"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 var ErrWriteAfterClose = errors.New("write called after writer closed")
 
+// FlushInterval is how often buffered frames are handed to the underlying
+// writer, and so how long a frame waits at most.
+const FlushInterval = 100 * time.Millisecond
+
+// AsyncWriter buffers writes on a background goroutine and flushes them
+// periodically.
+//
+// Every Write call is treated as a single frame (for the Log that is one
+// encoded record). Frames are never split across flushes, so anything that
+// reads the underlying file only ever observes whole frames, no matter when
+// the ticker fires or the buffer fills up.
+//
+// At most the buffer size is ever waiting to be written: a Write that takes
+// the pending bytes past it flushes before returning.
 type AsyncWriter struct {
 	queue    chan *bytes.Buffer
 	done     chan struct{}
-	writer   *bufio.Writer
+	w        io.Writer
+	buf      []byte // pending frames, only ever holds whole frames
+	size     int
+	pending  atomic.Int64          // bytes written but not handed to w yet, queued or buffered
+	err      error                 // first write error, reported by Flush
+	failed   atomic.Pointer[error] // err once set, for Write to refuse frames
+	logger   *slog.Logger
 	wg       sync.WaitGroup
 	flushReq chan chan error
 	once     sync.Once
 	pool     sync.Pool
 }
 
-func NewAsyncWriterSize(w io.Writer, writerBufferSize int) *AsyncWriter {
+// NewAsyncWriterSize returns an AsyncWriter buffering up to writerBufferSize
+// bytes. Write errors that happen in the background are logged to logger
+// (slog.Default() if nil) as soon as they happen, and returned by the next
+// Flush or Close, and by every Write after them.
+func NewAsyncWriterSize(w io.Writer, writerBufferSize int, logger *slog.Logger) *AsyncWriter {
+	if logger == nil {
+		logger = slog.Default()
+	}
 	aw := &AsyncWriter{
+		logger:   logger,
 		queue:    make(chan *bytes.Buffer, 10), // Tune buffer size for performance
 		done:     make(chan struct{}),
-		writer:   bufio.NewWriterSize(w, writerBufferSize),
+		w:        w,
+		buf:      make([]byte, 0, writerBufferSize),
+		size:     writerBufferSize,
 		flushReq: make(chan chan error),
 		pool: sync.Pool{
 			New: func() any {
@@ -41,18 +72,19 @@ func NewAsyncWriterSize(w io.Writer, writerBufferSize int) *AsyncWriter {
 
 func (aw *AsyncWriter) writerLoop() {
 	defer aw.wg.Done()
-	ticker := time.NewTicker(100 * time.Millisecond)
+	ticker := time.NewTicker(FlushInterval)
 	defer ticker.Stop()
 
 	for {
 		select {
 		case data := <-aw.queue:
-			_, _ = aw.writer.Write(data.Bytes()) // TODO: handle error on write
+			aw.writeFrame(data.Bytes())
 			aw.pool.Put(data)
 		case <-ticker.C:
-			aw.writer.Flush()
+			aw.flush()
 		case resp := <-aw.flushReq:
-			resp <- aw.writer.Flush()
+			aw.drainQueue()
+			resp <- aw.flush()
 		case <-aw.done:
 			aw.onDone()
 			return
@@ -60,33 +92,128 @@ func (aw *AsyncWriter) writerLoop() {
 	}
 }
 
+// drainQueue buffers every frame already queued, so that a flush covers all
+// writes that returned before it was requested.
+func (aw *AsyncWriter) drainQueue() {
+	for {
+		select {
+		case data := <-aw.queue:
+			aw.writeFrame(data.Bytes())
+			aw.pool.Put(data)
+		default:
+			return
+		}
+	}
+}
+
+// fail records the first write error, which every later flush returns.
+func (aw *AsyncWriter) fail(err error) {
+	if err == nil || aw.err != nil {
+		return
+	}
+	aw.err = err
+	aw.failed.Store(&err)
+	aw.logger.Error("async write failed, refusing all later writes", "err", err)
+}
+
 func (aw *AsyncWriter) onDone() {
 	for {
 		select {
 		case data := <-aw.queue:
-			_, _ = aw.writer.Write(data.Bytes())
+			aw.writeFrame(data.Bytes())
 			aw.pool.Put(data)
 		case resp := <-aw.flushReq:
-			resp <- aw.writer.Flush()
+			resp <- aw.flush()
 		default:
-			aw.writer.Flush()
+			aw.flush()
+			return
+		}
+	}
+}
+
+// writeFrame buffers a whole frame. If the frame does not fit in the space
+// left, the pending frames are flushed first; a frame larger than the whole
+// buffer is handed to the underlying writer in a single call.
+func (aw *AsyncWriter) writeFrame(frame []byte) {
+	if aw.err != nil {
+		return
+	}
+
+	if len(aw.buf)+len(frame) > cap(aw.buf) {
+		if aw.flush() != nil {
 			return
 		}
 	}
+
+	if len(frame) > cap(aw.buf) {
+		_, err := aw.w.Write(frame)
+		aw.pending.Add(-int64(len(frame)))
+		aw.fail(err)
+		return
+	}
+
+	aw.buf = append(aw.buf, frame...)
+}
+
+// flush writes all pending frames to the underlying writer. Once a write has
+// failed every later flush returns the same error, since the frames after it
+// can no longer be placed correctly.
+func (aw *AsyncWriter) flush() error {
+	if aw.err != nil {
+		return aw.err
+	}
+	if len(aw.buf) == 0 {
+		return nil
+	}
+
+	_, err := aw.w.Write(aw.buf)
+	aw.pending.Add(-int64(len(aw.buf)))
+	aw.fail(err)
+	aw.buf = aw.buf[:0]
+	return aw.err
 }
 
 func (aw *AsyncWriter) Write(b []byte) (int, error) {
+	return aw.WriteBuffers([][]byte{b})
+}
+
+// WriteBuffers writes bufs as a single frame, as if they were concatenated,
+// without the caller having to concatenate them first.
+func (aw *AsyncWriter) WriteBuffers(bufs [][]byte) (int, error) {
+	// select picks randomly between ready cases, so check done on its own
+	// first or a write after Close could still land in the queue and be lost.
+	select {
+	case <-aw.done:
+		return 0, ErrWriteAfterClose
+	default:
+	}
+	// The frames queued after a failed write would be dropped
+	if err := aw.failed.Load(); err != nil {
+		return 0, *err
+	}
+
 	poolBuf := aw.pool.Get().(*bytes.Buffer)
 	poolBuf.Reset()
-	poolBuf.Write(b)
+	for _, b := range bufs {
+		poolBuf.Write(b)
+	}
+	n := poolBuf.Len()
 
+	aw.pending.Add(int64(n))
 	select {
 	case aw.queue <- poolBuf:
-		return len(b), nil
 	case <-aw.done:
+		aw.pending.Add(-int64(n))
 		aw.pool.Put(poolBuf)
 		return 0, ErrWriteAfterClose
 	}
+
+	if aw.pending.Load() > int64(aw.size) {
+		if err := aw.Flush(); err != nil {
+			return 0, err
+		}
+	}
+	return n, nil
 }
 
 func (aw *AsyncWriter) Flush() error {
@@ -104,7 +231,7 @@ func (aw *AsyncWriter) Close() error {
 		close(aw.done)
 	})
 	aw.wg.Wait()
-	return nil
+	return aw.err
 }
 
 var _ io.WriteCloser = (*AsyncWriter)(nil)
@@ -0,0 +1,165 @@
+package asyncwriter
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// recordingWriter keeps every Write call separately so tests can check where
+// the flush boundaries landed.
+type recordingWriter struct {
+	mu     sync.Mutex
+	writes [][]byte
+}
+
+func (r *recordingWriter) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.writes = append(r.writes, bytes.Clone(p))
+	return len(p), nil
+}
+
+func frame(size int, fill byte) []byte {
+	return bytes.Repeat([]byte{fill}, size)
+}
+
+// requireWholeFrames checks that every underlying write is a concatenation of
+// complete frames, in order.
+func requireWholeFrames(t *testing.T, frames [][]byte, writes [][]byte) {
+	t.Helper()
+
+	next := 0
+	for i, w := range writes {
+		for len(w) > 0 {
+			require.Less(t, next, len(frames), "write %d has more data than was framed", i)
+			f := frames[next]
+			require.GreaterOrEqual(t, len(w), len(f), "write %d splits frame %d", i, next)
+			require.Equal(t, f, w[:len(f)], "write %d splits frame %d", i, next)
+			w = w[len(f):]
+			next++
+		}
+	}
+	require.Equal(t, len(frames), next, "not all frames were written")
+}
+
+func TestAsyncWriter_FlushBoundaries(t *testing.T) {
+	t.Run("frames are not split when the buffer fills", func(t *testing.T) {
+		rw := &recordingWriter{}
+		aw := NewAsyncWriterSize(rw, 100, nil)
+
+		var frames [][]byte
+		for i := range 20 {
+			f := frame(30+i, byte(i))
+			frames = append(frames, f)
+			_, err := aw.Write(f)
+			require.NoError(t, err)
+		}
+		require.NoError(t, aw.Flush())
+		require.NoError(t, aw.Close())
+
+		requireWholeFrames(t, frames, rw.writes)
+		for _, w := range rw.writes {
+			require.LessOrEqual(t, len(w), 100)
+		}
+	})
+
+	t.Run("frame larger than the buffer is written in one call", func(t *testing.T) {
+		rw := &recordingWriter{}
+		aw := NewAsyncWriterSize(rw, 16, nil)
+
+		frames := [][]byte{frame(10, 'a'), frame(64, 'b'), frame(4, 'c')}
+		for _, f := range frames {
+			_, err := aw.Write(f)
+			require.NoError(t, err)
+		}
+		require.NoError(t, aw.Close())
+
+		requireWholeFrames(t, frames, rw.writes)
+		require.Contains(t, rw.writes, frames[1])
+	})
+
+	t.Run("buffers written together are one frame", func(t *testing.T) {
+		rw := &recordingWriter{}
+		aw := NewAsyncWriterSize(rw, 100, nil)
+
+		_, err := aw.Write(frame(80, 'a'))
+		require.NoError(t, err)
+		n, err := aw.WriteBuffers([][]byte{frame(10, 'b'), frame(20, 'c')})
+		require.NoError(t, err)
+		require.Equal(t, 30, n)
+		require.NoError(t, aw.Close())
+
+		joined := append(frame(10, 'b'), frame(20, 'c')...)
+		requireWholeFrames(t, [][]byte{frame(80, 'a'), joined}, rw.writes)
+		require.Contains(t, rw.writes, joined)
+	})
+
+	t.Run("write after close", func(t *testing.T) {
+		aw := NewAsyncWriterSize(&recordingWriter{}, 16, nil)
+		require.NoError(t, aw.Close())
+
+		_, err := aw.Write([]byte("late"))
+		require.ErrorIs(t, err, ErrWriteAfterClose)
+	})
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) {
+	return 0, errors.New("disk on fire")
+}
+
+func TestAsyncWriter_Errors(t *testing.T) {
+	t.Run("background write errors are logged once and returned", func(t *testing.T) {
+		var logs bytes.Buffer
+		aw := NewAsyncWriterSize(failingWriter{}, 64, slog.New(slog.NewTextHandler(&logs, nil)))
+
+		for range 3 {
+			_, err := aw.Write(frame(10, 'a'))
+			require.NoError(t, err)
+		}
+
+		require.ErrorContains(t, aw.Flush(), "disk on fire")
+		// Later writes are refused rather than dropped
+		_, err := aw.Write(frame(10, 'b'))
+		require.ErrorContains(t, err, "disk on fire")
+		require.ErrorContains(t, aw.Close(), "disk on fire")
+		require.Equal(t, 1, strings.Count(logs.String(), "async write failed"))
+	})
+	t.Run("write past the buffer size fails right away", func(t *testing.T) {
+		aw := NewAsyncWriterSize(failingWriter{}, 16, slog.New(slog.DiscardHandler))
+		defer aw.Close()
+
+		_, err := aw.Write(frame(10, 'a'))
+		require.NoError(t, err)
+		_, err = aw.Write(frame(10, 'b'))
+		require.ErrorContains(t, err, "disk on fire")
+	})
+}
+
+func TestAsyncWriter_PendingBound(t *testing.T) {
+	w := &recordingWriter{}
+	aw := NewAsyncWriterSize(w, 16, nil)
+	defer aw.Close()
+
+	// Nothing is flushed while the frames fit in the buffer
+	_, err := aw.Write(frame(8, 'a'))
+	require.NoError(t, err)
+	_, err = aw.Write(frame(8, 'b'))
+	require.NoError(t, err)
+	require.LessOrEqual(t, aw.pending.Load(), int64(16))
+
+	// Going past it hands everything to w before Write returns
+	_, err = aw.Write(frame(8, 'c'))
+	require.NoError(t, err)
+	require.Zero(t, aw.pending.Load())
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	requireWholeFrames(t, [][]byte{frame(8, 'a'), frame(8, 'b'), frame(8, 'c')}, w.writes)
+}
@@ -0,0 +1,178 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"time"
+)
+
+// BulkFormat is how BulkAppend splits its input into records.
+type BulkFormat string
+
+const (
+	// BulkRaw makes a record of every line, without its line ending.
+	BulkRaw BulkFormat = "raw"
+	// BulkJSONL makes a record of every line, which must be JSON. Blank
+	// lines are skipped.
+	BulkJSONL BulkFormat = "jsonl"
+	// BulkCSV makes a record of every row after the header: the payload
+	// column if there is one, as files exported with brook export have,
+	// otherwise the row as a JSON object keyed by the header.
+	BulkCSV BulkFormat = "csv"
+)
+
+// bulkBatchBytes caps the payloads BulkAppend writes in one go, besides
+// maxPipelineBatch.
+const bulkBatchBytes = 4 << 20
+
+// BulkAppend appends the records read from r in the given format and returns
+// how many it appended. It is meant for loading large files: records are
+// written in batches straight to the active segment, other appends waiting
+// in between, and fsynced once at the end rather than as they are written.
+// On error the records before the one that failed have been appended.
+func (p *Partition) BulkAppend(r io.Reader, format BulkFormat) (int, error) {
+	if p.config.Mode == OpenReadOnly {
+		return 0, ErrPartitionReadOnly
+	}
+	next, err := bulkReader(r, format)
+	if err != nil {
+		return 0, err
+	}
+
+	appended := 0
+	var batch [][]byte
+	size := 0
+	flush := func() error {
+		n, err := p.appendBulkBatch(batch)
+		appended += n
+		batch, size = batch[:0], 0
+		return err
+	}
+	for {
+		data, err := next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return appended, errors.Join(fmt.Errorf("record %d: %w", appended+len(batch), err), flush())
+		}
+		if data, err = p.preparePayload(data); err != nil {
+			return appended, errors.Join(fmt.Errorf("record %d: %w", appended+len(batch), err), flush())
+		}
+		batch = append(batch, data)
+		size += len(data)
+		if len(batch) == maxPipelineBatch || size >= bulkBatchBytes {
+			if err := flush(); err != nil {
+				return appended, err
+			}
+		}
+	}
+	if err := flush(); err != nil {
+		return appended, err
+	}
+	if appended == 0 {
+		return 0, nil
+	}
+	return appended, p.Sync()
+}
+
+// appendBulkBatch appends payloads and returns how many it appended.
+func (p *Partition) appendBulkBatch(payloads [][]byte) (int, error) {
+	if len(payloads) == 0 {
+		return 0, nil
+	}
+	p.writerMu.Lock()
+	sealed, err := p.sealPayloads(payloads)
+	written := 0
+	if sealed > 0 {
+		var appendErr error
+		p.mu.Lock()
+		if p.closed {
+			appendErr = ErrPartitionClosed
+		} else {
+			written, appendErr = p.appendBatch(payloads[:sealed], make([]time.Duration, sealed), nil)
+		}
+		p.mu.Unlock()
+		if appendErr != nil {
+			err = appendErr
+		}
+	}
+	p.writerMu.Unlock()
+
+	// A rotation fsyncs the segment it seals
+	p.notifyDurable()
+	if written > 0 {
+		p.notifyAppended()
+	}
+	return written, err
+}
+
+// bulkReader returns a function returning the payload of each record in r,
+// then io.EOF.
+func bulkReader(r io.Reader, format BulkFormat) (func() ([]byte, error), error) {
+	switch format {
+	case BulkRaw, BulkJSONL:
+		br := bufio.NewReader(r)
+		return func() ([]byte, error) {
+			for {
+				line, err := br.ReadBytes('\n')
+				if len(line) == 0 {
+					if err == nil {
+						err = io.EOF
+					}
+					return nil, err
+				}
+				if err != nil && err != io.EOF {
+					return nil, err
+				}
+				line = bytes.TrimSuffix(bytes.TrimSuffix(line, []byte("\n")), []byte("\r"))
+				if format == BulkRaw {
+					return line, nil
+				}
+				if len(bytes.TrimSpace(line)) == 0 {
+					continue
+				}
+				if !json.Valid(line) {
+					return nil, errors.New("invalid json")
+				}
+				return line, nil
+			}
+		}, nil
+
+	case BulkCSV:
+		cr := csv.NewReader(r)
+		cr.ReuseRecord = true
+		header, err := cr.Read()
+		if err == io.EOF {
+			return func() ([]byte, error) { return nil, io.EOF }, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read csv header: %w", err)
+		}
+		header = slices.Clone(header)
+		payload := slices.Index(header, "payload")
+		return func() ([]byte, error) {
+			row, err := cr.Read()
+			if err != nil {
+				return nil, err
+			}
+			if payload >= 0 {
+				return []byte(row[payload]), nil
+			}
+			object := make(map[string]string, len(header))
+			for i, name := range header {
+				object[name] = row[i]
+			}
+			return json.Marshal(object)
+		}, nil
+
+	default:
+		return nil, fmt.Errorf("unknown bulk format %q", format)
+	}
+}
@@ -0,0 +1,60 @@
+package storage
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPartition_BulkAppend(t *testing.T) {
+	t.Run("raw lines rotate segments and are durable", func(t *testing.T) {
+		p, _ := newTruncateTestPartition(t, 10)
+		var input strings.Builder
+		for i := 10; i < 2500; i++ {
+			fmt.Fprintf(&input, "data %d\r\n", i)
+		}
+		input.WriteString("\n")
+
+		n, err := p.BulkAppend(strings.NewReader(input.String()), BulkRaw)
+		require.NoError(t, err)
+		require.Equal(t, 2491, n)
+		require.Equal(t, 2501, p.NextOffset())
+		require.Equal(t, 2501, p.DurableOffset())
+		require.Len(t, p.segments, 26)
+		for _, offset := range []int{0, 10, 1034, 2499} {
+			requireRecord(t, p, offset, fmt.Sprintf("data %d", offset))
+		}
+		requireRecord(t, p, 2500, "")
+	})
+	t.Run("jsonl skips blank lines and stops at invalid json", func(t *testing.T) {
+		p, _ := newTruncateTestPartition(t, 0)
+		n, err := p.BulkAppend(strings.NewReader("{\"a\": 1}\n\n  \n[2]\n{oops\n3\n"), BulkJSONL)
+		require.ErrorContains(t, err, "record 2: invalid json")
+		require.Equal(t, 2, n)
+		require.Equal(t, 2, p.NextOffset())
+		requireRecord(t, p, 1, "[2]")
+	})
+	t.Run("csv", func(t *testing.T) {
+		p, _ := newTruncateTestPartition(t, 0)
+		n, err := p.BulkAppend(strings.NewReader("offset,timestamp,payload\n0,2026-01-01T00:00:00Z,\"a,b\"\n"), BulkCSV)
+		require.NoError(t, err)
+		require.Equal(t, 1, n)
+		requireRecord(t, p, 0, "a,b")
+
+		n, err = p.BulkAppend(strings.NewReader("user,total\nann,3\nbob,4\n"), BulkCSV)
+		require.NoError(t, err)
+		require.Equal(t, 2, n)
+		requireRecord(t, p, 2, `{"total":"4","user":"bob"}`)
+
+		n, err = p.BulkAppend(strings.NewReader(""), BulkCSV)
+		require.NoError(t, err)
+		require.Zero(t, n)
+	})
+	t.Run("unknown format", func(t *testing.T) {
+		p, _ := newTruncateTestPartition(t, 0)
+		_, err := p.BulkAppend(strings.NewReader("a"), "xml")
+		require.Error(t, err)
+	})
+}
@@ -0,0 +1,115 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+const checkpointFileName = "checkpoint"
+
+// checkpoint is left behind by a clean Partition.Close. As long as the segment
+// files on disk still look exactly like they did at close time, the next open
+// can trust it instead of scanning: the contiguity check and the tail scan of
+// the active segment are both skipped.
+type checkpoint struct {
+	NextOffset int                 `json:"next_offset"`
+	Segments   []checkpointSegment `json:"segments"`
+}
+
+type checkpointSegment struct {
+	BaseOffset int   `json:"base_offset"`
+	Size       int64 `json:"size"`
+}
+
+func newCheckpoint(nextOffset int, segments []Segment) (checkpoint, error) {
+	cp := checkpoint{NextOffset: nextOffset}
+	for _, segment := range segments {
+		info, err := os.Stat(segment.Path)
+		if err != nil {
+			return checkpoint{}, err
+		}
+		cp.Segments = append(cp.Segments, checkpointSegment{
+			BaseOffset: segment.BaseOffset,
+			Size:       info.Size(),
+		})
+	}
+	return cp, nil
+}
+
+// writeCheckpoint atomically replaces the checkpoint in dir.
+func writeCheckpoint(dir string, cp checkpoint) error {
+	data, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+
+	if err := WriteFileAtomic(dir, checkpointFileName, data); err != nil {
+		return fmt.Errorf("failed to write checkpoint: %w", err)
+	}
+	return nil
+}
+
+// readCheckpoint reads the checkpoint in dir without removing it. A missing
+// or unreadable checkpoint is reported as not found.
+func readCheckpoint(dir string) (checkpoint, bool, error) {
+	data, err := os.ReadFile(filepath.Join(dir, checkpointFileName))
+	if errors.Is(err, os.ErrNotExist) {
+		return checkpoint{}, false, nil
+	}
+	if err != nil {
+		return checkpoint{}, false, fmt.Errorf("failed to read checkpoint: %w", err)
+	}
+
+	var cp checkpoint
+	if err := json.Unmarshal(data, &cp); err != nil {
+		return checkpoint{}, false, nil
+	}
+	return cp, true, nil
+}
+
+// consumeCheckpoint reads and removes the checkpoint in dir. The checkpoint is
+// removed even when it can't be used: once the partition is open again it
+// can be written to, and a crash from then on must lead to a full recovery.
+func consumeCheckpoint(dir string) (checkpoint, bool, error) {
+	cp, found, err := readCheckpoint(dir)
+	if err != nil {
+		return checkpoint{}, false, err
+	}
+
+	err = os.Remove(filepath.Join(dir, checkpointFileName))
+	if errors.Is(err, os.ErrNotExist) {
+		return cp, found, nil
+	}
+	if err != nil {
+		return checkpoint{}, false, fmt.Errorf("failed to remove checkpoint: %w", err)
+	}
+	if err := syncDir(dir); err != nil {
+		return checkpoint{}, false, err
+	}
+
+	return cp, found, nil
+}
+
+// matches reports whether the segments on disk are exactly the ones the
+// checkpoint was taken of, with the same sizes.
+func (cp checkpoint) matches(segments []Segment) bool {
+	if len(cp.Segments) != len(segments) {
+		return false
+	}
+
+	for i, segment := range segments {
+		info, err := os.Stat(segment.Path)
+		if err != nil {
+			return false
+		}
+		if cp.Segments[i].BaseOffset != segment.BaseOffset || cp.Segments[i].Size != info.Size() {
+			return false
+		}
+	}
+
+	last := segments[len(segments)-1]
+	return cp.NextOffset >= last.BaseOffset
+}
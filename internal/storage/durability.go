@@ -0,0 +1,198 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	asyncwriter "github.com/mvaleed/brook/internal/storage/async-writer"
+)
+
+// DurabilityMode is how eagerly a Log persists appended records.
+type DurabilityMode int
+
+const (
+	// DurabilityAsync buffers records in the process and hands them to the
+	// OS in the background (NewLogAsync).
+	DurabilityAsync DurabilityMode = iota + 1
+	// DurabilityMedium hands every append to the OS before returning
+	// (NewLogMediumDurable). Partitions write their segments this way unless
+	// PartitionConfig.Durability says otherwise.
+	DurabilityMedium
+	// DurabilityFull fsyncs every append before returning (NewLogFullDurable,
+	// or NewLogGroupCommit where concurrent appends share fsyncs).
+	DurabilityFull
+)
+
+func (m DurabilityMode) String() string {
+	switch m {
+	case DurabilityAsync:
+		return "async"
+	case DurabilityMedium:
+		return "medium"
+	case DurabilityFull:
+		return "full"
+	default:
+		return fmt.Sprintf("DurabilityMode(%d)", int(m))
+	}
+}
+
+// DurabilityInfo states what a Log promises about the records it has
+// appended, should the process crash or the machine lose power. The limits
+// hold for records whose append has returned; a Log that was closed, or a
+// record that was fsynced by other means (a rotation, Partition.Sync), is
+// safe either way.
+type DurabilityInfo struct {
+	Mode DurabilityMode
+	// MaxUnflushedAge is how long an appended record may stay in the
+	// process' memory before it is handed to the OS, 0 if that happens before
+	// the append returns.
+	MaxUnflushedAge time.Duration
+	// MaxUnflushedBytes is how much appended data may be in the process'
+	// memory at once. All of it is lost if the process crashes.
+	MaxUnflushedBytes int64
+	// SurvivesProcessCrash is true if every returned append survives a crash
+	// of the process. Records in the OS are safe from it.
+	SurvivesProcessCrash bool
+	// SurvivesPowerLoss is true if every returned append survives the machine
+	// going down. Records only reach the disk once fsynced.
+	SurvivesPowerLoss bool
+}
+
+// durabilityInfo returns the contract of a writable log in mode, buffering up
+// to writerBufferSize bytes.
+func durabilityInfo(mode DurabilityMode, writerBufferSize int) DurabilityInfo {
+	switch mode {
+	case DurabilityAsync:
+		return DurabilityInfo{
+			Mode:              mode,
+			MaxUnflushedAge:   asyncwriter.FlushInterval,
+			MaxUnflushedBytes: int64(writerBufferSize),
+		}
+	case DurabilityMedium:
+		return DurabilityInfo{Mode: mode, SurvivesProcessCrash: true}
+	default:
+		return DurabilityInfo{Mode: mode, SurvivesProcessCrash: true, SurvivesPowerLoss: true}
+	}
+}
+
+// DurabilityInfo returns the durability contract of the log. Read only and
+// remote logs can't be appended to and return the zero DurabilityInfo.
+func (l *Log) DurabilityInfo() DurabilityInfo {
+	return l.durability
+}
+
+// DurabilityInfo returns the durability contract of the partition's active
+// segment, see PartitionConfig.Durability.
+func (p *Partition) DurabilityInfo() DurabilityInfo {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.activeLog.DurabilityInfo()
+}
+
+// durableHook is a callback waiting for offset to be fsynced.
+type durableHook struct {
+	offset int
+	fn     func(error)
+}
+
+// OnDurable registers fn to be called once the record at offset has been
+// fsynced, so an embedding application can release its own commit record only
+// after brook has persisted the data it refers to. fn gets nil then, or the
+// error that stopped the partition from ever making the record durable (at
+// the latest when the partition is closed).
+//
+// Records become durable when the partition is synced (see Sync), when the
+// active segment is rotated and when the partition is closed, or as soon as
+// they are appended with DurabilityFull. If offset is
+// already durable, fn is called before OnDurable returns. Callbacks run on the
+// goroutine that made the records durable and must not block for long.
+func (p *Partition) OnDurable(offset int, fn func(error)) {
+	p.hooksMu.Lock()
+	i := sort.Search(len(p.hooks), func(i int) bool {
+		return p.hooks[i].offset > offset
+	})
+	p.hooks = append(p.hooks, durableHook{})
+	copy(p.hooks[i+1:], p.hooks[i:])
+	p.hooks[i] = durableHook{offset: offset, fn: fn}
+	p.hooksMu.Unlock()
+
+	p.notifyDurable()
+
+	p.mu.RLock()
+	closed := p.closed
+	p.mu.RUnlock()
+	if closed {
+		p.failHooks(ErrPartitionClosed)
+	}
+}
+
+// WaitDurable returns once the record at offset has been fsynced, with the
+// error OnDurable would pass on, or with the error of ctx if it is done first.
+func (p *Partition) WaitDurable(ctx context.Context, offset int) error {
+	done := make(chan error, 1)
+	p.OnDurable(offset, func(err error) { done <- err })
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// DurableOffset returns the offset below which every record has been fsynced.
+func (p *Partition) DurableOffset() int {
+	return int(p.durableOffset.Load())
+}
+
+// Sync fsyncs everything appended to the partition so far and runs the
+// callbacks of the records that became durable.
+func (p *Partition) Sync() error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return ErrPartitionClosed
+	}
+	err := p.activeLog.sync()
+	if err == nil {
+		p.durableOffset.Store(int64(p.nextOffset))
+	}
+	p.mu.Unlock()
+
+	if err != nil {
+		return p.failWrite(fmt.Errorf("failed to sync active log: %w", err))
+	}
+	p.notifyDurable()
+	return nil
+}
+
+// notifyDurable runs the callbacks of every hook below the durable offset.
+func (p *Partition) notifyDurable() {
+	durable := int(p.durableOffset.Load())
+
+	p.hooksMu.Lock()
+	n := sort.Search(len(p.hooks), func(i int) bool {
+		return p.hooks[i].offset >= durable
+	})
+	ready := p.hooks[:n:n]
+	p.hooks = p.hooks[n:]
+	p.hooksMu.Unlock()
+
+	for _, hook := range ready {
+		hook.fn(nil)
+	}
+}
+
+// failHooks runs every callback still waiting with err.
+func (p *Partition) failHooks(err error) {
+	p.hooksMu.Lock()
+	pending := p.hooks
+	p.hooks = nil
+	p.hooksMu.Unlock()
+
+	for _, hook := range pending {
+		hook.fn(err)
+	}
+}
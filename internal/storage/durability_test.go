@@ -0,0 +1,205 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPartition_OnDurable(t *testing.T) {
+	t.Run("fires after sync", func(t *testing.T) {
+		p, err := NewPartition(filepath.Join(t.TempDir(), "partition/"))
+		require.NoError(t, err)
+		defer p.Close()
+
+		for i := range 10 {
+			require.NoError(t, p.Append(fmt.Appendf(nil, "data %d", i)))
+		}
+		require.Equal(t, 0, p.DurableOffset())
+
+		var fired []int
+		for _, offset := range []int{9, 3, 12} {
+			p.OnDurable(offset, func(err error) {
+				if err == nil {
+					fired = append(fired, offset)
+				}
+			})
+		}
+		require.Empty(t, fired)
+
+		require.NoError(t, p.Sync())
+		require.Equal(t, 10, p.DurableOffset())
+		require.Equal(t, []int{3, 9}, fired)
+
+		// Already durable
+		p.OnDurable(5, func(err error) {
+			require.NoError(t, err)
+			fired = append(fired, 5)
+		})
+		require.Equal(t, []int{3, 9, 5}, fired)
+	})
+	t.Run("fires on rotation", func(t *testing.T) {
+		config := DefaultPartitionConfig()
+		config.MaxSegmentRecords = 5
+		p, err := NewPartitionWithConfig(filepath.Join(t.TempDir(), "partition/"), config)
+		require.NoError(t, err)
+		defer p.Close()
+
+		fired := false
+		p.OnDurable(4, func(err error) {
+			require.NoError(t, err)
+			fired = true
+		})
+
+		for i := range 5 {
+			require.NoError(t, p.Append(fmt.Appendf(nil, "data %d", i)))
+		}
+		require.False(t, fired)
+
+		require.NoError(t, p.Append([]byte("data 5")))
+		require.True(t, fired)
+		require.Equal(t, 5, p.DurableOffset())
+	})
+	t.Run("close makes appended records durable and fails the rest", func(t *testing.T) {
+		p, err := NewPartition(filepath.Join(t.TempDir(), "partition/"))
+		require.NoError(t, err)
+		require.NoError(t, p.Append([]byte("data")))
+
+		results := map[int]error{}
+		for _, offset := range []int{0, 1} {
+			p.OnDurable(offset, func(err error) {
+				results[offset] = err
+			})
+		}
+
+		require.NoError(t, p.Close())
+		require.NoError(t, results[0])
+		require.ErrorIs(t, results[1], ErrPartitionClosed)
+
+		p.OnDurable(2, func(err error) {
+			results[2] = err
+		})
+		require.ErrorIs(t, results[2], ErrPartitionClosed)
+		require.ErrorIs(t, p.Sync(), ErrPartitionClosed)
+	})
+	t.Run("wait durable", func(t *testing.T) {
+		p, err := NewPartition(filepath.Join(t.TempDir(), "partition/"))
+		require.NoError(t, err)
+		defer p.Close()
+		require.NoError(t, p.Append([]byte("data")))
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		require.ErrorIs(t, p.WaitDurable(ctx, 0), context.DeadlineExceeded)
+
+		var wg sync.WaitGroup
+		wg.Go(func() { require.NoError(t, p.WaitDurable(context.Background(), 0)) })
+		require.NoError(t, p.Sync())
+		wg.Wait()
+	})
+}
+
+// TestLog_DurabilityInfo checks each mode against its contract. A process
+// crash is simulated by reading the segment from a second Log while the first
+// is still open, which only sees what was handed to the OS. Power loss can't
+// be simulated here.
+func TestLog_DurabilityInfo(t *testing.T) {
+	for _, tc := range []struct {
+		open func(path string, baseOffset int) (*Log, error)
+		want DurabilityInfo
+	}{
+		{NewLogAsync, DurabilityInfo{Mode: DurabilityAsync, MaxUnflushedAge: 100 * time.Millisecond, MaxUnflushedBytes: 8192}},
+		{NewLogMediumDurable, DurabilityInfo{Mode: DurabilityMedium, SurvivesProcessCrash: true}},
+		{NewLogFullDurable, DurabilityInfo{Mode: DurabilityFull, SurvivesProcessCrash: true, SurvivesPowerLoss: true}},
+	} {
+		t.Run(tc.want.Mode.String(), func(t *testing.T) {
+			logPath := filepath.Join(t.TempDir(), "test.log")
+			l, err := tc.open(logPath, 0)
+			require.NoError(t, err)
+			defer l.Close()
+			require.Equal(t, tc.want, l.DurabilityInfo())
+
+			for i := range 1000 {
+				require.NoError(t, l.Append(fmt.Appendf(nil, "record %d", i)))
+
+				info, err := os.Stat(logPath)
+				require.NoError(t, err)
+				require.GreaterOrEqual(t, info.Size(), l.Size()-tc.want.MaxUnflushedBytes)
+			}
+
+			crashed := func() int64 {
+				reader, err := NewLogReadOnly(logPath, 0)
+				require.NoError(t, err)
+				defer reader.Close()
+				return reader.NextOffset()
+			}
+			if tc.want.SurvivesProcessCrash {
+				require.Equal(t, int64(1000), crashed())
+				return
+			}
+			require.Eventually(t, func() bool {
+				return crashed() == 1000
+			}, 2*tc.want.MaxUnflushedAge, tc.want.MaxUnflushedAge/10)
+		})
+	}
+
+	t.Run("read only", func(t *testing.T) {
+		logPath := filepath.Join(t.TempDir(), "test.log")
+		l, err := NewLogMediumDurable(logPath, 0)
+		require.NoError(t, err)
+		require.NoError(t, l.Close())
+
+		l, err = NewLogReadOnly(logPath, 0)
+		require.NoError(t, err)
+		defer l.Close()
+		require.Zero(t, l.DurabilityInfo())
+	})
+}
+
+func TestPartition_Durability(t *testing.T) {
+	for _, mode := range []DurabilityMode{DurabilityAsync, DurabilityMedium, DurabilityFull} {
+		t.Run(mode.String(), func(t *testing.T) {
+			config := DefaultPartitionConfig()
+			config.MaxSegmentRecords = 4
+			config.Durability = mode
+			dir := filepath.Join(t.TempDir(), "partition")
+			p, err := NewPartitionWithConfig(dir, config)
+			require.NoError(t, err)
+			require.Equal(t, mode, p.DurabilityInfo().Mode)
+
+			for i := range 10 {
+				require.NoError(t, p.Append(fmt.Appendf(nil, "data %d", i)))
+			}
+			// Rotated segments are written the same way
+			require.Equal(t, mode, p.DurabilityInfo().Mode)
+			if mode == DurabilityFull {
+				require.Equal(t, 10, p.DurableOffset())
+			} else {
+				require.Equal(t, 8, p.DurableOffset())
+			}
+
+			data, err := p.Read(9)
+			require.NoError(t, err)
+			require.Equal(t, []byte("data 9"), data.Payload)
+			require.NoError(t, p.Close())
+
+			p, err = NewPartitionWithConfig(dir, config)
+			require.NoError(t, err)
+			defer p.Close()
+			require.Equal(t, 10, p.NextOffset())
+		})
+	}
+
+	t.Run("defaults to medium", func(t *testing.T) {
+		p, err := NewPartition(filepath.Join(t.TempDir(), "partition"))
+		require.NoError(t, err)
+		defer p.Close()
+		require.Equal(t, DurabilityMedium, p.DurabilityInfo().Mode)
+	})
+}
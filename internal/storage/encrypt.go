@@ -0,0 +1,274 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+)
+
+var ErrDecryptionFailed = errors.New("record decryption failed")
+
+// Keyring hands out the keys payloads are encrypted with, such as a client of
+// a KMS. Keys are AES keys of 16, 24 or 32 bytes, named by an ID of at most
+// 255 bytes that is stored with every record encrypted with them.
+type Keyring interface {
+	// EncryptionKey returns the key new records are encrypted with. Changing
+	// it rotates the key: older records stay readable as long as Key still
+	// returns theirs.
+	EncryptionKey() (id string, key []byte, err error)
+	// Key returns the key called id.
+	Key(id string) ([]byte, error)
+}
+
+// StaticKeyring is a Keyring over keys known upfront.
+type StaticKeyring struct {
+	// Current is the ID of the key new records are encrypted with.
+	Current string
+	Keys    map[string][]byte
+}
+
+func (k *StaticKeyring) EncryptionKey() (string, []byte, error) {
+	key, err := k.Key(k.Current)
+	return k.Current, key, err
+}
+
+func (k *StaticKeyring) Key(id string) ([]byte, error) {
+	key, ok := k.Keys[id]
+	if !ok {
+		return nil, fmt.Errorf("unknown key %q", id)
+	}
+	return key, nil
+}
+
+// An encrypted payload is an envelope:
+//
+//	magic | key ID length (1 byte) | key ID | nonce | AES-GCM ciphertext
+//
+// The record header has no room for attributes, so the key ID travels in the
+// payload. Everything before the nonce is authenticated along with the
+// ciphertext, and so are the ID of the partition and the offset of the
+// record: an envelope copied to another record or partition doesn't open.
+var envelopeMagic = []byte("\xb7enc")
+
+const (
+	nonceSize = 12
+	tagSize   = 16
+
+	// encryptionFileName keeps the encryptionState of a partition next to
+	// its segments.
+	encryptionFileName = "encryption.json"
+)
+
+// encryptionState is what a partition keeps about its encrypted records.
+type encryptionState struct {
+	// ID names the partition in what is authenticated with every payload.
+	ID []byte `json:"id"`
+	// StartOffset is the first encrypted record. Every record from there on
+	// is an envelope, the ones before are read as they are, even when they
+	// happen to start like one.
+	StartOffset int `json:"start_offset"`
+}
+
+// readEncryptionState reads the encryption state kept in dir, found is false
+// if nothing was ever encrypted.
+func readEncryptionState(dir string) (state encryptionState, found bool, err error) {
+	data, err := os.ReadFile(filepath.Join(dir, encryptionFileName))
+	if errors.Is(err, os.ErrNotExist) {
+		return encryptionState{}, false, nil
+	}
+	if err != nil {
+		return encryptionState{}, false, fmt.Errorf("failed to read encryption state: %w", err)
+	}
+	if err := json.Unmarshal(data, &state); err != nil {
+		return encryptionState{}, false, fmt.Errorf("invalid encryption state: %w", err)
+	}
+	return state, true, nil
+}
+
+// encryptor seals and opens the payloads of a partition with the keys of its
+// keyring.
+type encryptor struct {
+	keyring Keyring
+	aeads   sync.Map // key ID to cipher.AEAD
+
+	id    []byte       // set before start, never changed after
+	start atomic.Int64 // first encrypted offset, math.MaxInt64 until there is one
+}
+
+func newEncryptor(keyring Keyring) *encryptor {
+	if keyring == nil {
+		return nil
+	}
+	e := &encryptor{keyring: keyring}
+	e.start.Store(math.MaxInt64)
+	return e
+}
+
+// begin makes the encryptor use state.
+func (e *encryptor) begin(state encryptionState) {
+	if e.id == nil {
+		e.id = state.ID
+	}
+	e.start.Store(int64(state.StartOffset))
+}
+
+func (e *encryptor) aead(id string, key []byte) (cipher.AEAD, error) {
+	if aead, ok := e.aeads.Load(id); ok {
+		return aead.(cipher.AEAD), nil
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("key %q: %w", id, err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	e.aeads.Store(id, aead)
+	return aead, nil
+}
+
+// overhead returns how much sealing a payload with the current key adds to
+// it.
+func (e *encryptor) overhead() (int64, error) {
+	id, _, err := e.keyring.EncryptionKey()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get the encryption key: %w", err)
+	}
+	return int64(len(envelopeMagic) + 1 + len(id) + nonceSize + tagSize), nil
+}
+
+// additionalData returns what is authenticated with the payload at offset
+// whose envelope starts with header.
+func (e *encryptor) additionalData(header []byte, offset int) []byte {
+	data := make([]byte, 0, len(header)+len(e.id)+8)
+	data = append(data, header...)
+	data = append(data, e.id...)
+	return binary.BigEndian.AppendUint64(data, uint64(offset))
+}
+
+// seal encrypts payload, the record at offset, with the current key of the
+// keyring.
+func (e *encryptor) seal(payload []byte, offset int) ([]byte, error) {
+	id, key, err := e.keyring.EncryptionKey()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the encryption key: %w", err)
+	}
+	if len(id) > 255 {
+		return nil, fmt.Errorf("key ID %q is longer than 255 bytes", id)
+	}
+	aead, err := e.aead(id, key)
+	if err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, 0, len(envelopeMagic)+1+len(id)+nonceSize+len(payload)+aead.Overhead())
+	header = append(header, envelopeMagic...)
+	header = append(header, byte(len(id)))
+	header = append(header, id...)
+	nonce := header[len(header) : len(header)+nonceSize]
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(header[:len(header)+nonceSize], nonce, payload, e.additionalData(header, offset)), nil
+}
+
+// open decrypts the envelope of the record at offset in place and returns
+// the payload. Records from before encryption was turned on are returned as
+// they are, after it anything but an envelope is an error.
+func (e *encryptor) open(envelope []byte, offset int) ([]byte, error) {
+	if int64(offset) < e.start.Load() {
+		return envelope, nil
+	}
+	if !bytes.HasPrefix(envelope, envelopeMagic) {
+		return nil, fmt.Errorf("%w: record isn't encrypted", ErrDecryptionFailed)
+	}
+
+	rest := envelope[len(envelopeMagic):]
+	if len(rest) < 1 || len(rest) < 1+int(rest[0])+nonceSize {
+		return nil, fmt.Errorf("%w: truncated envelope", ErrDecryptionFailed)
+	}
+	id := string(rest[1 : 1+rest[0]])
+	header := envelope[:len(envelopeMagic)+1+len(id)]
+	nonce := envelope[len(header) : len(header)+nonceSize]
+	ciphertext := envelope[len(header)+nonceSize:]
+
+	key, err := e.keyring.Key(id)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrDecryptionFailed, err)
+	}
+	aead, err := e.aead(id, key)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrDecryptionFailed, err)
+	}
+	payload, err := aead.Open(ciphertext[:0], nonce, ciphertext, e.additionalData(header, offset))
+	if err != nil {
+		return nil, fmt.Errorf("%w: key %q: %w", ErrDecryptionFailed, id, err)
+	}
+	return payload, nil
+}
+
+// sealPayloads encrypts payloads, the records appended next, in place if the
+// partition has a keyring. It returns how many it sealed, the ones after a
+// payload that failed aren't. Caller must hold p.writerMu.
+func (p *Partition) sealPayloads(payloads [][]byte) (int, error) {
+	if p.encryptor == nil {
+		return len(payloads), nil
+	}
+	if p.closed {
+		return 0, ErrPartitionClosed
+	}
+	if err := p.beginEncryption(); err != nil {
+		return 0, err
+	}
+	for i, payload := range payloads {
+		sealed, err := p.encryptor.seal(payload, p.nextOffset+i)
+		if err == nil {
+			// The key may have changed for a longer ID since the payload
+			// was checked
+			err = p.checkRecordSize(int64(len(sealed)))
+		}
+		if err != nil {
+			return i, err
+		}
+		payloads[i] = sealed
+	}
+	return len(payloads), nil
+}
+
+// beginEncryption records that the records from the end of the partition on
+// are encrypted, unless it already starts before. Caller must hold
+// p.writerMu.
+func (p *Partition) beginEncryption() error {
+	if p.encryptor.start.Load() <= int64(p.nextOffset) {
+		return nil
+	}
+	// Either nothing was encrypted yet, or truncation took the partition
+	// back before the first encrypted record
+	state := encryptionState{ID: p.encryptor.id, StartOffset: p.nextOffset}
+	if state.ID == nil {
+		state.ID = make([]byte, 16)
+		if _, err := rand.Read(state.ID); err != nil {
+			return err
+		}
+	}
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	if err := WriteFileAtomic(p.metaDir, encryptionFileName, data); err != nil {
+		return fmt.Errorf("failed to write encryption state: %w", err)
+	}
+	p.encryptor.begin(state)
+	return nil
+}
@@ -0,0 +1,176 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPartition_Encryption(t *testing.T) {
+	keyring := &StaticKeyring{
+		Current: "k1",
+		Keys: map[string][]byte{
+			"k1": bytes.Repeat([]byte{1}, 32),
+			"k2": bytes.Repeat([]byte{2}, 16),
+		},
+	}
+	openPartition := func(t *testing.T, dir string, keyring Keyring) *Partition {
+		t.Helper()
+		config := DefaultPartitionConfig()
+		config.Keyring = keyring
+		p, err := NewPartitionWithConfig(dir, config)
+		require.NoError(t, err)
+		return p
+	}
+
+	t.Run("payloads are encrypted at rest", func(t *testing.T) {
+		dir := filepath.Join(t.TempDir(), "partition")
+		p := openPartition(t, dir, keyring)
+		defer p.Close()
+
+		for i := range 3 {
+			require.NoError(t, p.Append(fmt.Appendf(nil, "secret %d", i)))
+		}
+		_, err := p.AppendFrom(strings.NewReader("streamed secret"), -1)
+		require.NoError(t, err)
+		require.NoError(t, p.Sync())
+
+		segment, err := os.ReadFile(filepath.Join(dir, newLogNameFromInt(0).string()))
+		require.NoError(t, err)
+		require.NotContains(t, string(segment), "secret")
+		require.Contains(t, string(segment), "k1")
+
+		for i := range 3 {
+			requireRecord(t, p, i, fmt.Sprintf("secret %d", i))
+		}
+		requireRecord(t, p, 3, "streamed secret")
+	})
+	t.Run("rotated keys and older plaintext stay readable", func(t *testing.T) {
+		dir := filepath.Join(t.TempDir(), "partition")
+		p := openPartition(t, dir, nil)
+		require.NoError(t, p.Append([]byte("plaintext")))
+		require.NoError(t, p.Append([]byte("\xb7enc looks encrypted")))
+		require.NoError(t, p.Close())
+
+		p = openPartition(t, dir, keyring)
+		require.NoError(t, p.Append([]byte("under k1")))
+		require.NoError(t, p.Close())
+
+		rotated := &StaticKeyring{Current: "k2", Keys: keyring.Keys}
+		p = openPartition(t, dir, rotated)
+		defer p.Close()
+		require.NoError(t, p.Append([]byte("under k2")))
+
+		requireRecord(t, p, 0, "plaintext")
+		requireRecord(t, p, 1, "\xb7enc looks encrypted")
+		requireRecord(t, p, 2, "under k1")
+		requireRecord(t, p, 3, "under k2")
+
+		// Turning encryption off would have plaintext follow encrypted
+		// records
+		require.NoError(t, p.Close())
+		_, err := NewPartition(dir)
+		require.ErrorContains(t, err, "needs a keyring")
+		config := DefaultPartitionConfig()
+		config.Mode = OpenReadOnly
+		ro, err := NewPartitionWithConfig(dir, config)
+		require.NoError(t, err)
+		requireRecord(t, ro, 0, "plaintext")
+		require.NoError(t, ro.Close())
+	})
+	t.Run("plaintext after encryption was turned on is refused", func(t *testing.T) {
+		dir := filepath.Join(t.TempDir(), "partition")
+		p := openPartition(t, dir, nil)
+		require.NoError(t, p.Append([]byte("before")))
+		require.NoError(t, p.Append([]byte("slipped in")))
+		require.NoError(t, p.Close())
+		state, err := json.Marshal(encryptionState{ID: []byte("partition"), StartOffset: 1})
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(filepath.Join(dir, encryptionFileName), state, 0o644))
+
+		p = openPartition(t, dir, keyring)
+		defer p.Close()
+		requireRecord(t, p, 0, "before")
+		_, err = p.Read(1)
+		require.ErrorIs(t, err, ErrDecryptionFailed)
+	})
+	t.Run("envelopes are bound to their partition and offset", func(t *testing.T) {
+		e := newEncryptor(keyring)
+		e.begin(encryptionState{ID: []byte("p1")})
+		envelope, err := e.seal([]byte("secret"), 3)
+		require.NoError(t, err)
+
+		_, err = e.open(bytes.Clone(envelope), 4)
+		require.ErrorIs(t, err, ErrDecryptionFailed)
+		other := newEncryptor(keyring)
+		other.begin(encryptionState{ID: []byte("p2")})
+		_, err = other.open(bytes.Clone(envelope), 3)
+		require.ErrorIs(t, err, ErrDecryptionFailed)
+		payload, err := e.open(envelope, 3)
+		require.NoError(t, err)
+		require.Equal(t, "secret", string(payload))
+	})
+	t.Run("restored snapshots stay readable", func(t *testing.T) {
+		p := openPartition(t, filepath.Join(t.TempDir(), "partition"), keyring)
+		require.NoError(t, p.Append([]byte("secret")))
+		var buf bytes.Buffer
+		_, err := p.Snapshot(&buf)
+		require.NoError(t, err)
+		require.NoError(t, p.Close())
+
+		dir := filepath.Join(t.TempDir(), "restored")
+		require.NoError(t, RestorePartition(dir, &buf))
+		p = openPartition(t, dir, keyring)
+		defer p.Close()
+		requireRecord(t, p, 0, "secret")
+	})
+	t.Run("records of a missing key can't be read", func(t *testing.T) {
+		dir := filepath.Join(t.TempDir(), "partition")
+		p := openPartition(t, dir, keyring)
+		require.NoError(t, p.Append([]byte("secret")))
+		require.NoError(t, p.Close())
+
+		p = openPartition(t, dir, &StaticKeyring{Current: "k2", Keys: map[string][]byte{"k2": keyring.Keys["k2"]}})
+		defer p.Close()
+		_, err := p.Read(0)
+		require.ErrorIs(t, err, ErrDecryptionFailed)
+
+		// A wrong key is caught by the authentication tag
+		dir2 := t.TempDir()
+		p2 := openPartition(t, dir2, &StaticKeyring{Current: "k1", Keys: map[string][]byte{"k1": keyring.Keys["k2"]}})
+		require.NoError(t, p2.Append([]byte("secret")))
+		require.NoError(t, p2.Close())
+		p2 = openPartition(t, dir2, keyring)
+		defer p2.Close()
+		_, err = p2.Read(0)
+		require.ErrorIs(t, err, ErrDecryptionFailed)
+	})
+	t.Run("the size limit applies to the encrypted payload", func(t *testing.T) {
+		config := DefaultPartitionConfig()
+		config.Keyring = keyring
+		config.MaxRecordBytes = 64
+		p, err := NewPartitionWithConfig(filepath.Join(t.TempDir(), "partition"), config)
+		require.NoError(t, err)
+		defer p.Close()
+
+		require.NoError(t, p.Append(make([]byte, 20)))
+		require.ErrorIs(t, p.Append(make([]byte, 60)), ErrRecordTooLarge)
+		_, err = p.AppendFrom(bytes.NewReader(make([]byte, 100)), -1)
+		require.ErrorIs(t, err, ErrRecordTooLarge)
+	})
+	t.Run("bad keys fail the append", func(t *testing.T) {
+		p := openPartition(t, filepath.Join(t.TempDir(), "partition"), &StaticKeyring{Current: "missing"})
+		defer p.Close()
+		require.Error(t, p.Append([]byte("secret")))
+
+		p2 := openPartition(t, filepath.Join(t.TempDir(), "partition"), &StaticKeyring{Current: "short", Keys: map[string][]byte{"short": {1, 2, 3}}})
+		defer p2.Close()
+		require.Error(t, p2.Append([]byte("secret")))
+	})
+}
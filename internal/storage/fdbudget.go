@@ -0,0 +1,174 @@
+package storage
+
+import (
+	"container/list"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/mvaleed/brook/internal/metrics"
+)
+
+var ErrFDBudgetExhausted = errors.New("file descriptor budget exhausted")
+
+// logFDs is the number of descriptors an open Log holds: the segment file,
+// the index file and the index's mapping.
+const logFDs = 3
+
+// FDBudget caps the number of file descriptors held by the partitions that
+// share it. Active segments pin their descriptors for as long as they are
+// open. Sealed segments opened for reads are kept open in a cache so the next
+// read doesn't have to open them again; when the budget runs out the coldest
+// of those are closed first to make room.
+type FDBudget struct {
+	mu        sync.Mutex
+	limit     int
+	used      int
+	cold      *list.List // *fdHandle nobody is using, least recently used at the front
+	cached    int        // handles alive, in use or not
+	reclaimed uint64
+}
+
+// DefaultFDBudget is shared by every partition that isn't given a budget of
+// its own.
+var DefaultFDBudget = NewFDBudget(4096)
+
+func NewFDBudget(limit int) *FDBudget {
+	return &FDBudget{
+		limit: limit,
+		cold:  list.New(),
+	}
+}
+
+// reserve takes fds descriptors from the budget, closing cold cached ones if
+// that's needed to stay under the limit.
+func (b *FDBudget) reserve(fds int) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for b.used+fds > b.limit && b.cold.Len() > 0 {
+		b.evictLocked(b.cold.Front().Value.(*fdHandle))
+		b.reclaimed++
+	}
+	if b.used+fds > b.limit {
+		return fmt.Errorf("%w: %d of %d in use", ErrFDBudgetExhausted, b.used, b.limit)
+	}
+
+	b.used += fds
+	return nil
+}
+
+// release gives back descriptors taken with reserve.
+func (b *FDBudget) release(fds int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.used -= fds
+}
+
+// track hands the fds descriptors already reserved by the caller over to a
+// cached handle, which closeFn closes once it's reclaimed. The handle starts
+// out in use.
+func (b *FDBudget) track(fds int, closeFn func() error) *fdHandle {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.cached++
+	return &fdHandle{budget: b, fds: fds, users: 1, closeFn: closeFn}
+}
+
+// evictLocked closes h and gives its descriptors back.
+// Caller must hold b.mu.
+func (b *FDBudget) evictLocked(h *fdHandle) {
+	if h.elem != nil {
+		b.cold.Remove(h.elem)
+		h.elem = nil
+	}
+	h.closed = true
+	b.used -= h.fds
+	b.cached--
+	_ = h.closeFn() // read only, nothing to lose
+}
+
+// Collect implements metrics.Collector.
+func (b *FDBudget) Collect() []metrics.Sample {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return []metrics.Sample{
+		{
+			Name:  "brook_fd_budget_limit",
+			Help:  "Maximum number of file descriptors the budget hands out.",
+			Type:  metrics.Gauge,
+			Value: float64(b.limit),
+		},
+		{
+			Name:  "brook_fd_budget_used",
+			Help:  "File descriptors currently held, including cached segments.",
+			Type:  metrics.Gauge,
+			Value: float64(b.used),
+		},
+		{
+			Name:  "brook_fd_budget_cached_segments",
+			Help:  "Sealed segments kept open for reads.",
+			Type:  metrics.Gauge,
+			Value: float64(b.cached),
+		},
+		{
+			Name:  "brook_fd_budget_reclaimed_total",
+			Help:  "Cached segments closed to make room for other descriptors.",
+			Type:  metrics.Counter,
+			Value: float64(b.reclaimed),
+		},
+	}
+}
+
+// fdHandle is a cached resource holding descriptors of an FDBudget. It can
+// only be reclaimed while nobody is using it.
+type fdHandle struct {
+	budget  *FDBudget
+	fds     int
+	users   int
+	elem    *list.Element // position in budget.cold while users == 0
+	closed  bool
+	closeFn func() error
+}
+
+// acquire marks h as in use. It reports false if h was reclaimed already.
+func (h *fdHandle) acquire() bool {
+	b := h.budget
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if h.closed {
+		return false
+	}
+	if h.elem != nil {
+		b.cold.Remove(h.elem)
+		h.elem = nil
+	}
+	h.users++
+	return true
+}
+
+// done ends a use of h started by track or acquire.
+func (h *fdHandle) done() {
+	b := h.budget
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	h.users--
+	if h.users == 0 && !h.closed {
+		h.elem = b.cold.PushBack(h)
+	}
+}
+
+// close closes h right away. The caller must make sure nobody is using it.
+func (h *fdHandle) close() {
+	b := h.budget
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !h.closed {
+		b.evictLocked(h)
+	}
+}
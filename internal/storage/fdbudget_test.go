@@ -0,0 +1,93 @@
+package storage
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFDBudget(t *testing.T) {
+	t.Run("reclaims the coldest handle", func(t *testing.T) {
+		b := NewFDBudget(6)
+
+		closed := map[string]bool{}
+		handle := func(name string) *fdHandle {
+			require.NoError(t, b.reserve(2))
+			h := b.track(2, func() error {
+				closed[name] = true
+				return nil
+			})
+			h.done()
+			return h
+		}
+		first := handle("first")
+		second := handle("second")
+		handle("third")
+
+		// first is in use, so second is the coldest one
+		require.True(t, first.acquire())
+		require.NoError(t, b.reserve(2))
+		require.Equal(t, map[string]bool{"second": true}, closed)
+		require.False(t, second.acquire())
+
+		require.NoError(t, b.reserve(2))
+		require.True(t, closed["third"])
+
+		// Everything left is in use or reserved
+		require.ErrorIs(t, b.reserve(2), ErrFDBudgetExhausted)
+
+		first.done()
+		require.NoError(t, b.reserve(2))
+		require.True(t, closed["first"])
+	})
+}
+
+func TestPartition_FDBudget(t *testing.T) {
+	t.Run("sealed segments are cached within the budget", func(t *testing.T) {
+		budget := NewFDBudget(3 * logFDs)
+		config := DefaultPartitionConfig()
+		config.MaxSegmentRecords = 10
+		config.FDBudget = budget
+
+		p, err := NewPartitionWithConfig(filepath.Join(t.TempDir(), "partition/"), config)
+		require.NoError(t, err)
+		for i := range 50 {
+			require.NoError(t, p.Append(fmt.Appendf(nil, "data %d", i)))
+		}
+
+		// Every segment gets read, the budget never has room for more than two
+		// cached ones next to the active log
+		for i := range 40 {
+			record, err := p.Read(i)
+			require.NoError(t, err)
+			require.Equal(t, fmt.Sprintf("data %d", i), string(record.Payload))
+		}
+		require.Equal(t, 3*logFDs, budget.used)
+		require.Equal(t, 2, budget.cached)
+		require.Equal(t, uint64(2), budget.reclaimed)
+
+		// The active segment isn't cached, its fds are only held during a read
+		record, err := p.Read(45)
+		require.NoError(t, err)
+		require.Equal(t, "data 45", string(record.Payload))
+		require.Equal(t, 2*logFDs, budget.used)
+		require.Equal(t, 1, budget.cached)
+
+		require.NoError(t, p.Close())
+		require.Equal(t, 0, budget.used)
+		require.Equal(t, 0, budget.cached)
+	})
+	t.Run("open fails when the budget is exhausted", func(t *testing.T) {
+		config := DefaultPartitionConfig()
+		config.FDBudget = NewFDBudget(logFDs)
+
+		p, err := NewPartitionWithConfig(filepath.Join(t.TempDir(), "partition/"), config)
+		require.NoError(t, err)
+		defer p.Close()
+
+		_, err = NewPartitionWithConfig(filepath.Join(t.TempDir(), "partition/"), config)
+		require.ErrorIs(t, err, ErrFDBudgetExhausted)
+	})
+}
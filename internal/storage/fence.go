@@ -0,0 +1,62 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"syscall"
+)
+
+var ErrPartitionFenced = errors.New("partition is fenced")
+
+// fencedPartitions counts the partitions fenced since the process started.
+var fencedPartitions atomic.Int64
+
+// FencedError is returned for every write to a partition that ran out of disk
+// space, reads carry on. The partition stays fenced until it's opened again,
+// which cuts off whatever the failed write left behind. It matches
+// ErrPartitionFenced and the error that fenced the partition, such as
+// syscall.ENOSPC.
+type FencedError struct {
+	// Partition is the directory of the partition.
+	Partition string
+	Cause     error
+}
+
+func (e *FencedError) Error() string {
+	return fmt.Sprintf("partition %s is fenced, its disk is full: %v", e.Partition, e.Cause)
+}
+
+func (e *FencedError) Is(target error) bool {
+	return target == ErrPartitionFenced
+}
+
+func (e *FencedError) Unwrap() error {
+	return e.Cause
+}
+
+// Fenced reports whether p ran out of disk space and refuses writes.
+func (p *Partition) Fenced() bool {
+	return p.fenced.Load() != nil
+}
+
+// checkFenced returns the FencedError of p if it's fenced, nil if it isn't.
+func (p *Partition) checkFenced() error {
+	if e := p.fenced.Load(); e != nil {
+		return e
+	}
+	return nil
+}
+
+// failWrite fences p if err is the disk running out of space, and returns
+// the error to report for the write.
+func (p *Partition) failWrite(err error) error {
+	if !errors.Is(err, syscall.ENOSPC) {
+		return err
+	}
+	if p.fenced.CompareAndSwap(nil, &FencedError{Partition: p.dir, Cause: err}) {
+		fencedPartitions.Add(1)
+		p.logger.Error("disk full, fencing partition", "err", err)
+	}
+	return p.fenced.Load()
+}
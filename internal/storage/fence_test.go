@@ -0,0 +1,65 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPartition_DiskFull(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "partition")
+	p, err := NewPartition(dir)
+	require.NoError(t, err)
+	defer p.Close()
+	for i := range 3 {
+		require.NoError(t, p.Append(fmt.Appendf(nil, "record %d", i)))
+	}
+
+	// The disk fills up under the active segment
+	p.mu.Lock()
+	p.activeLog.writeFunc = func(bufs [][]byte) (int, error) {
+		return 0, &os.PathError{Op: "write", Path: p.activeLog.path, Err: syscall.ENOSPC}
+	}
+	p.mu.Unlock()
+	fencedBefore := fencedPartitions.Load()
+
+	err = p.Append([]byte("record 3"))
+	require.ErrorIs(t, err, ErrPartitionFenced)
+	require.ErrorIs(t, err, syscall.ENOSPC)
+	var fenced *FencedError
+	require.True(t, errors.As(err, &fenced))
+	require.Equal(t, dir, fenced.Partition)
+	require.True(t, p.Fenced())
+	require.Equal(t, fencedBefore+1, fencedPartitions.Load())
+
+	// Writes are refused without touching the disk again, reads carry on
+	p.mu.Lock()
+	p.activeLog.writeFunc = func(bufs [][]byte) (int, error) {
+		t.Fatal("fenced partition wrote to its segment")
+		return 0, nil
+	}
+	p.mu.Unlock()
+	require.ErrorIs(t, p.Append([]byte("record 3")), ErrPartitionFenced)
+	_, err = p.AppendFrom(nil, 0)
+	require.ErrorIs(t, err, ErrPartitionFenced)
+	requireRecord(t, p, 2, "record 2")
+	require.Equal(t, 3, p.NextOffset())
+	require.Equal(t, fencedBefore+1, fencedPartitions.Load())
+
+	// Other write errors don't fence
+	other, err := NewPartition(filepath.Join(t.TempDir(), "other"))
+	require.NoError(t, err)
+	defer other.Close()
+	other.mu.Lock()
+	other.activeLog.writeFunc = func(bufs [][]byte) (int, error) {
+		return 0, errors.New("disk on fire")
+	}
+	other.mu.Unlock()
+	require.ErrorContains(t, other.Append([]byte("record")), "disk on fire")
+	require.False(t, other.Fenced())
+}
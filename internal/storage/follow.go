@@ -0,0 +1,141 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"iter"
+	"os"
+	"time"
+
+	"github.com/mvaleed/brook/internal/storage/mmap"
+)
+
+// ErrLogTruncated is returned when following a log whose writer cut records
+// off it. The records read so far may not be in the log anymore, it has to be
+// opened again.
+var ErrLogTruncated = errors.New("log was truncated by its writer")
+
+// followPollInterval is how often Tail looks for new records once it has read
+// all there are.
+const followPollInterval = 50 * time.Millisecond
+
+// Refresh picks up what a writer, in this process or another one, appended to
+// a read only log since it was opened or last refreshed, and returns how many
+// records it added. A record still being written is left for a later
+// refresh. A log that got sealed in the meantime is sealed from then on.
+func (l *Log) Refresh() (int64, error) {
+	if !l.readOnly || l.file == nil {
+		return 0, errors.New("only a local log opened read only can be refreshed")
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.sealed {
+		return 0, nil
+	}
+	info, err := l.file.Stat()
+	if err != nil {
+		return 0, err
+	}
+	size := info.Size()
+	if size < SegmentHeaderSize && l.nextMemoryPos == SegmentHeaderSize {
+		// The writer hasn't finished creating the segment
+		return 0, nil
+	}
+	if size < l.nextMemoryPos {
+		return 0, fmt.Errorf("%w: %s went from %d bytes to %d", ErrLogTruncated, l.path, l.nextMemoryPos, size)
+	}
+
+	// The index goes first: its entries may run ahead of the records
+	// visible so far, but never point behind them
+	if err := l.index.follow(l.indexPath); err != nil {
+		return 0, fmt.Errorf("failed to refresh index: %w", err)
+	}
+
+	before := l.nextOffset
+	end := l.nextMemoryPos
+	l.nextMemoryPos = size
+	err = l.scanFrom(end, func(h RecordHeader, payloadPos int64) bool {
+		l.nextOffset = int64(h.LogicalOffset) + 1
+		end = payloadPos + int64(h.PayloadSize)
+		return false
+	})
+	l.nextMemoryPos = end
+	l.tornBytes = size - end
+	if err != nil && !errors.Is(err, ErrRecordNotFoundFullScan) {
+		return 0, err
+	}
+
+	if seal, ok := readSeal(l.path); ok && seal.Size == end {
+		l.sealed = true
+		l.lastPosition = seal.LastPosition
+	}
+	return l.nextOffset - before, nil
+}
+
+// Tail yields the records of a read only log from offset on, an offset in the
+// partition like FindRecord takes. At the end of the log it waits for a writer
+// to append more, refreshing the log every 50ms, and resumes with them. It
+// stops once it has yielded the last record of a log that got sealed, as the
+// writer moved on to the next segment, and otherwise after yielding the
+// error of ctx once it is done, or the first error reading the log.
+func (l *Log) Tail(ctx context.Context, offset int64) iter.Seq2[Record, error] {
+	return func(yield func(Record, error) bool) {
+		for {
+			for ; offset < l.baseOffset+l.NextOffset(); offset++ {
+				record, err := l.FindRecord(offset)
+				if err != nil {
+					yield(Record{}, err)
+					return
+				}
+				if !yield(record, nil) {
+					return
+				}
+			}
+
+			added, err := l.Refresh()
+			if err != nil {
+				yield(Record{}, err)
+				return
+			}
+			if added > 0 {
+				continue
+			}
+			if l.Sealed() {
+				return
+			}
+			select {
+			case <-ctx.Done():
+				yield(Record{}, ctx.Err())
+				return
+			case <-time.After(followPollInterval):
+			}
+		}
+	}
+}
+
+// follow maps the read only index at path again, if a writer grew or
+// shrank it since it was mapped, or maps it if it didn't exist then, and
+// counts its entries anew.
+// LOCK STRATEGY: Exclusive Lock, the map moves.
+func (i *Index) follow(path string) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if i.store == nil {
+		store, err := mmap.NewMmapStore(path)
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		i.store = store
+	} else if err := i.store.Sync(); err != nil {
+		return err
+	}
+	i.entries.Store(countEntries(i.store, i.store.Size()/entryWidth))
+	return nil
+}
@@ -0,0 +1,137 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLog_Follow(t *testing.T) {
+	// newFollowed opens a writer with records already in it, and a read only
+	// log following it
+	newFollowed := func(t *testing.T, records int) (*Log, *Log) {
+		t.Helper()
+		path := filepath.Join(t.TempDir(), "000000000000100.log")
+		writer, err := NewLogMediumDurable(path, 100)
+		require.NoError(t, err)
+		t.Cleanup(func() { writer.Close() })
+		for i := range records {
+			require.NoError(t, writer.Append(fmt.Appendf(nil, "record %d", i)))
+		}
+
+		follower, err := NewLogReadOnly(path, 100)
+		require.NoError(t, err)
+		t.Cleanup(func() { follower.Close() })
+		require.Equal(t, int64(records), follower.NextOffset())
+		return writer, follower
+	}
+
+	t.Run("refresh picks up appended records", func(t *testing.T) {
+		writer, follower := newFollowed(t, 10)
+
+		added, err := follower.Refresh()
+		require.NoError(t, err)
+		require.Zero(t, added)
+
+		// Past a few index entries, which the follower maps anew
+		for i := 10; i < 1600; i++ {
+			require.NoError(t, writer.Append(fmt.Appendf(nil, "record %d", i)))
+		}
+		added, err = follower.Refresh()
+		require.NoError(t, err)
+		require.Equal(t, int64(1590), added)
+		require.Equal(t, int64(1600), follower.NextOffset())
+		entry, err := follower.index.LastEntry()
+		require.NoError(t, err)
+		require.Equal(t, uint32(1500), entry.LogicalOff)
+
+		record, err := follower.FindRecord(100 + 1555)
+		require.NoError(t, err)
+		require.Equal(t, "record 1555", string(record.Payload))
+	})
+
+	t.Run("a record being written is left for later", func(t *testing.T) {
+		writer, follower := newFollowed(t, 3)
+
+		// Half a record, as a writer in the middle of an append leaves it
+		header := make([]byte, HeaderSize)
+		h := RecordHeader{LogicalOffset: 3, PayloadSize: 100}
+		h.encodeWithChecksum(header, make([]byte, 100))
+		_, err := writer.file.Write(header)
+		require.NoError(t, err)
+
+		added, err := follower.Refresh()
+		require.NoError(t, err)
+		require.Zero(t, added)
+		require.Equal(t, int64(HeaderSize), follower.tornBytes)
+	})
+
+	t.Run("a truncated log can't be followed", func(t *testing.T) {
+		writer, follower := newFollowed(t, 10)
+
+		require.NoError(t, writer.truncate(5))
+		_, err := follower.Refresh()
+		require.ErrorIs(t, err, ErrLogTruncated)
+	})
+
+	t.Run("tail waits for new records", func(t *testing.T) {
+		writer, follower := newFollowed(t, 5)
+
+		go func() {
+			for i := 5; i < 20; i++ {
+				time.Sleep(time.Millisecond)
+				writer.Append(fmt.Appendf(nil, "record %d", i))
+			}
+		}()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		offset := 102
+		for record, err := range follower.Tail(ctx, int64(offset)) {
+			require.NoError(t, err)
+			require.Equal(t, fmt.Sprintf("record %d", offset-100), string(record.Payload))
+			offset++
+			if offset == 120 {
+				break
+			}
+		}
+		require.Equal(t, 120, offset)
+	})
+
+	t.Run("tail ends with its context", func(t *testing.T) {
+		_, follower := newFollowed(t, 2)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		var records int
+		var tailErr error
+		for _, err := range follower.Tail(ctx, 100) {
+			if err != nil {
+				tailErr = err
+				continue
+			}
+			records++
+		}
+		require.Equal(t, 2, records)
+		require.ErrorIs(t, tailErr, context.DeadlineExceeded)
+	})
+
+	t.Run("tail ends with a sealed log", func(t *testing.T) {
+		writer, follower := newFollowed(t, 4)
+
+		require.NoError(t, writer.Close())
+		require.NoError(t, sealSegment(writer.path, 4, 3*int64(HeaderSize+len("record 0")), time.Time{}))
+
+		var records int
+		for _, err := range follower.Tail(context.Background(), 100) {
+			require.NoError(t, err)
+			records++
+		}
+		require.Equal(t, 4, records)
+		require.True(t, follower.Sealed())
+	})
+}
@@ -0,0 +1,93 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Every segment starts with a header naming the format of its records:
+//
+//	Magic(8) "brookseg" + Version(4) + Reserved(4) = 16 bytes
+//
+// Segments from before the header existed start straight with a record. They
+// are refused with a SegmentFormatError instead of being taken for a torn
+// record and cut off.
+const (
+	segmentMagic = "brookseg"
+	// SegmentHeaderSize is where the first record of a segment starts.
+	SegmentHeaderSize = 16
+
+	// SegmentFormatVersion is the format of the segments written: records
+	// with a 36 byte header (see HeaderSize) followed by their payload.
+	SegmentFormatVersion = 2
+)
+
+var ErrSegmentFormat = errors.New("unsupported segment format")
+
+// SegmentFormatError is returned for a segment written in a format this
+// version of brook doesn't read. It matches ErrSegmentFormat.
+type SegmentFormatError struct {
+	Path string
+	// Version is the format version of the segment, 1 for one from before
+	// segments had a header.
+	Version uint32
+}
+
+func (e *SegmentFormatError) Error() string {
+	if e.Version == 1 {
+		return fmt.Sprintf("%s: segment has no format header, it was written by a version of brook whose segments can't be read anymore", e.Path)
+	}
+	return fmt.Sprintf("%s: segment format version %d isn't supported, expected %d", e.Path, e.Version, SegmentFormatVersion)
+}
+
+func (e *SegmentFormatError) Is(target error) bool {
+	return target == ErrSegmentFormat
+}
+
+// encodeSegmentHeader returns the header starting a segment written now.
+func encodeSegmentHeader() []byte {
+	header := make([]byte, SegmentHeaderSize)
+	copy(header, segmentMagic)
+	binary.BigEndian.PutUint32(header[len(segmentMagic):], SegmentFormatVersion)
+	return header
+}
+
+// writeSegmentHeader replaces the contents of f, opened for appending, with
+// the header of an empty segment.
+func writeSegmentHeader(f *os.File) error {
+	if err := f.Truncate(0); err != nil {
+		return err
+	}
+	_, err := f.Write(encodeSegmentHeader())
+	return err
+}
+
+// checkSegmentHeader checks the header of the segment at path, size bytes
+// long, read from r. A segment shorter than its header that holds the
+// beginning of one was torn while being created and has no records: partial
+// is set for it.
+func checkSegmentHeader(r io.ReaderAt, size int64, path string) (partial bool, err error) {
+	want := encodeSegmentHeader()
+	n := min(size, SegmentHeaderSize)
+	header := make([]byte, n)
+	if _, err := r.ReadAt(header, 0); err != nil {
+		return false, fmt.Errorf("failed to read segment header of %s: %w", path, err)
+	}
+	if !bytes.HasPrefix(header, want[:min(n, int64(len(segmentMagic)))]) {
+		return false, &SegmentFormatError{Path: path, Version: 1}
+	}
+	if n < SegmentHeaderSize {
+		if !bytes.Equal(header, want[:n]) {
+			return false, &SegmentFormatError{Path: path, Version: 1}
+		}
+		return true, nil
+	}
+	if version := binary.BigEndian.Uint32(header[len(segmentMagic):]); version != SegmentFormatVersion {
+		return false, &SegmentFormatError{Path: path, Version: version}
+	}
+	return false, nil
+}
@@ -0,0 +1,89 @@
+package storage
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSegmentFormat(t *testing.T) {
+	// A segment the way brook wrote them before the format header: records
+	// with a 24 byte header (offset, size, checksum) from the first byte on
+	legacySegment := func(t *testing.T, dir string) (string, []byte) {
+		t.Helper()
+		require.NoError(t, os.MkdirAll(dir, 0o755))
+		var data []byte
+		for i, payload := range []string{"first", "second"} {
+			header := make([]byte, 24)
+			binary.BigEndian.PutUint64(header[0:], uint64(i))
+			binary.BigEndian.PutUint64(header[8:], uint64(len(payload)))
+			data = append(append(data, header...), payload...)
+		}
+		path := filepath.Join(dir, newLogNameFromInt(0).string())
+		require.NoError(t, os.WriteFile(path, data, 0o644))
+		return path, data
+	}
+
+	t.Run("new segments start with the header", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "test.log")
+		l, err := NewLogMediumDurable(path, 0)
+		require.NoError(t, err)
+		require.NoError(t, l.Append([]byte("data")))
+		require.NoError(t, l.Close())
+
+		data, err := os.ReadFile(path)
+		require.NoError(t, err)
+		require.Equal(t, encodeSegmentHeader(), data[:SegmentHeaderSize])
+	})
+
+	t.Run("legacy segment is refused, not cut", func(t *testing.T) {
+		dir := filepath.Join(t.TempDir(), "p")
+		path, data := legacySegment(t, dir)
+
+		_, err := NewPartition(dir)
+		require.ErrorIs(t, err, ErrSegmentFormat)
+		var formatErr *SegmentFormatError
+		require.ErrorAs(t, err, &formatErr)
+		require.Equal(t, uint32(1), formatErr.Version)
+
+		_, err = NewLogReadOnly(path, 0)
+		require.ErrorIs(t, err, ErrSegmentFormat)
+
+		after, err := os.ReadFile(path)
+		require.NoError(t, err)
+		require.Equal(t, data, after)
+	})
+
+	t.Run("unknown version is refused", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "test.log")
+		header := encodeSegmentHeader()
+		binary.BigEndian.PutUint32(header[len(segmentMagic):], SegmentFormatVersion+1)
+		require.NoError(t, os.WriteFile(path, header, 0o644))
+
+		_, err := NewLogMediumDurable(path, 0)
+		var formatErr *SegmentFormatError
+		require.ErrorAs(t, err, &formatErr)
+		require.Equal(t, uint32(SegmentFormatVersion+1), formatErr.Version)
+	})
+
+	t.Run("header torn while creating the segment is written again", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "test.log")
+		require.NoError(t, os.WriteFile(path, encodeSegmentHeader()[:5], 0o644))
+
+		l, err := NewLogReadOnly(path, 0)
+		require.NoError(t, err)
+		require.Equal(t, int64(0), l.NextOffset())
+		require.NoError(t, l.Close())
+
+		l, err = NewLogMediumDurable(path, 0)
+		require.NoError(t, err)
+		require.NoError(t, l.Append([]byte("data")))
+		record, err := l.FindRecord(0)
+		require.NoError(t, err)
+		require.Equal(t, "data", string(record.Payload))
+		require.NoError(t, l.Close())
+	})
+}
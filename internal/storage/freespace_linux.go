@@ -0,0 +1,15 @@
+//go:build linux
+
+package storage
+
+import "syscall"
+
+// FreeSpace returns the bytes left to unprivileged users on the file system
+// holding dir.
+func FreeSpace(dir string) (uint64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(dir, &st); err != nil {
+		return 0, err
+	}
+	return st.Bavail * uint64(st.Bsize), nil
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package storage
+
+import "errors"
+
+// FreeSpace needs statfs(2), which is only wired up on Linux.
+func FreeSpace(dir string) (uint64, error) {
+	return 0, errors.ErrUnsupported
+}
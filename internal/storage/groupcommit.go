@@ -0,0 +1,123 @@
+package storage
+
+import (
+	"os"
+	"slices"
+	"sync"
+	"time"
+)
+
+// groupSyncer lets appends that wait on an fsync share one. The first append
+// to wait leads: it fsyncs, releases every waiter the fsync covered and goes
+// again as long as anyone is left, so appends that arrive during an fsync are
+// all covered by the next one instead of each queueing for their own.
+type groupSyncer struct {
+	// sync fsyncs the log and returns the offset everything below was
+	// handed to the OS by the time it started, which the fsync covers.
+	sync func() (int64, error)
+
+	mu      sync.Mutex
+	synced  int64 // every offset below it is durable
+	leading bool
+	waiters []syncWaiter
+}
+
+type syncWaiter struct {
+	offset int64
+	done   chan error
+}
+
+// wait returns once every record below offset is durable, or with the error
+// of the fsync that should have made it so.
+func (g *groupSyncer) wait(offset int64) error {
+	g.mu.Lock()
+	if offset <= g.synced {
+		g.mu.Unlock()
+		return nil
+	}
+	done := make(chan error, 1)
+	g.waiters = append(g.waiters, syncWaiter{offset: offset, done: done})
+	lead := !g.leading
+	g.leading = true
+	g.mu.Unlock()
+
+	if lead {
+		g.lead()
+	}
+	return <-done
+}
+
+// truncated forgets that records from offset on were synced, they are gone
+// and the records that take their place are not.
+func (g *groupSyncer) truncated(offset int64) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.synced = min(g.synced, offset)
+}
+
+func (g *groupSyncer) lead() {
+	for {
+		end, err := g.sync()
+
+		g.mu.Lock()
+		if err == nil {
+			g.synced = max(g.synced, end)
+		}
+		g.waiters = slices.DeleteFunc(g.waiters, func(w syncWaiter) bool {
+			switch {
+			case w.offset <= g.synced:
+				w.done <- nil
+			case err != nil && w.offset <= end:
+				w.done <- err
+			default:
+				return false
+			}
+			return true
+		})
+		if len(g.waiters) == 0 {
+			g.leading = false
+			g.mu.Unlock()
+			return
+		}
+		g.mu.Unlock()
+	}
+}
+
+// fsyncLatencyWindow is how many of the latest fsyncs FsyncCollector computes
+// its quantile over.
+const fsyncLatencyWindow = 1024
+
+// fsyncLatencies is the process wide record of how long log fsyncs take.
+var fsyncLatencies = struct {
+	mu     sync.Mutex
+	total  int64
+	recent [fsyncLatencyWindow]time.Duration
+}{}
+
+// syncFile fsyncs f, recording how long it took.
+func syncFile(f *os.File) error {
+	start := time.Now()
+	err := f.Sync()
+	took := time.Since(start)
+
+	fsyncLatencies.mu.Lock()
+	fsyncLatencies.recent[fsyncLatencies.total%fsyncLatencyWindow] = took
+	fsyncLatencies.total++
+	fsyncLatencies.mu.Unlock()
+	return err
+}
+
+// fsyncStats returns how many log fsyncs there were and the 99th percentile
+// of how long the latest ones took.
+func fsyncStats() (int64, time.Duration) {
+	fsyncLatencies.mu.Lock()
+	total := fsyncLatencies.total
+	recent := slices.Clone(fsyncLatencies.recent[:min(total, fsyncLatencyWindow)])
+	fsyncLatencies.mu.Unlock()
+
+	if len(recent) == 0 {
+		return total, 0
+	}
+	slices.Sort(recent)
+	return total, recent[(len(recent)-1)*99/100]
+}
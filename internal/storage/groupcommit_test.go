@@ -0,0 +1,107 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGroupSyncer(t *testing.T) {
+	t.Run("waiters during an fsync share the next one", func(t *testing.T) {
+		var end atomic.Int64
+		var syncs atomic.Int32
+		release := make(chan struct{})
+		g := &groupSyncer{sync: func() (int64, error) {
+			syncs.Add(1)
+			covered := end.Load()
+			<-release
+			return covered, nil
+		}}
+
+		end.Store(1)
+		var wg sync.WaitGroup
+		wg.Go(func() { require.NoError(t, g.wait(1)) })
+		// The leader is in its fsync, the rest queue up behind it
+		require.Eventually(t, func() bool { return syncs.Load() == 1 }, time.Second, time.Millisecond)
+		end.Store(10)
+		for i := int64(2); i <= 10; i++ {
+			wg.Go(func() { require.NoError(t, g.wait(i)) })
+		}
+		require.Eventually(t, func() bool {
+			g.mu.Lock()
+			defer g.mu.Unlock()
+			return len(g.waiters) == 10
+		}, time.Second, time.Millisecond)
+
+		close(release)
+		wg.Wait()
+		require.Equal(t, int32(2), syncs.Load())
+
+		// Already covered, no fsync needed
+		require.NoError(t, g.wait(5))
+		require.Equal(t, int32(2), syncs.Load())
+	})
+
+	t.Run("a failed fsync fails the waiters it covered", func(t *testing.T) {
+		errSync := errors.New("disk on fire")
+		fail := true
+		g := &groupSyncer{sync: func() (int64, error) {
+			if fail {
+				fail = false
+				return 3, errSync
+			}
+			return 3, nil
+		}}
+
+		require.ErrorIs(t, g.wait(3), errSync)
+		require.NoError(t, g.wait(3))
+	})
+
+	t.Run("truncation forgets synced offsets", func(t *testing.T) {
+		var syncs int
+		g := &groupSyncer{sync: func() (int64, error) {
+			syncs++
+			return 10, nil
+		}}
+		require.NoError(t, g.wait(10))
+		g.truncated(4)
+		require.NoError(t, g.wait(6))
+		require.Equal(t, 2, syncs)
+	})
+}
+
+func TestLog_GroupCommit(t *testing.T) {
+	logPath := filepath.Join(t.TempDir(), "test.log")
+	log, err := NewLogGroupCommit(logPath, 0)
+	require.NoError(t, err)
+	require.True(t, log.DurabilityInfo().SurvivesPowerLoss)
+
+	before, _ := fsyncStats()
+	const writers, appends = 20, 25
+	var wg sync.WaitGroup
+	for w := range writers {
+		wg.Go(func() {
+			for i := range appends {
+				require.NoError(t, log.Append(fmt.Appendf(nil, "writer %d record %d", w, i)))
+			}
+		})
+	}
+	wg.Wait()
+
+	after, p99 := fsyncStats()
+	require.LessOrEqual(t, after-before, int64(writers*appends))
+	require.Positive(t, p99)
+	require.Equal(t, int64(writers*appends), log.NextOffset())
+	require.NoError(t, log.Close())
+
+	reopened, err := NewLogReadOnly(logPath, 0)
+	require.NoError(t, err)
+	defer reopened.Close()
+	require.Equal(t, int64(writers*appends), reopened.NextOffset())
+}
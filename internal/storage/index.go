@@ -1,11 +1,14 @@
 package storage
 
 import (
-	"bufio"
+	"encoding/binary"
+	"errors"
 	"fmt"
+	"math"
 	"os"
 	"sort"
 	"sync"
+	"sync/atomic"
 
 	"github.com/mvaleed/brook/internal/storage/mmap"
 )
@@ -21,7 +24,7 @@ import (
   Goal: Find Log Entry with Offset 800.
 
   Part 1: The Index Search (Binary Search via mmap)
-  1. Sync(): Ensure mmap reader sees the latest writes.
+  1. Load the entry count: entries are written into the map, nothing to sync.
   2. Binary Search: Find the smallest index 'i' where Entry[i].Offset > 800.
      -> In this example, it finds Entry 2 (Offset 1000).
   3. The "Floor" Step: We want the range *containing* 800, so we take (i - 1).
@@ -35,18 +38,38 @@ import (
   8. Caller stops when it finds Offset 800 (Success) or Offset > 800 (Not Found).
 */
 
+// indexInterval is how many records apart the entries of the index are.
+const indexInterval = 500
+
+// maxIndexSize is the size a writable index is preallocated to, enough for
+// the largest segment there can be: one entry every 500 records in a segment
+// of at most 4GiB where every record is at least a header long.
+const maxIndexSize = (math.MaxUint32/(HeaderSize*indexInterval) + 1) * entryWidth
+
+// Index is mmapped whole. A writable index preallocates its file to
+// maxIndexSize and writes entries straight into the map, the unused space
+// stays zeroed. entries says how much of the map is in use, Close trims the
+// file down to it so a closed index is exactly its entries on disk.
+//
+// Lookups don't wait on appends: an entry is written with a single atomic
+// store past the end readers look at, and only then counted in entries.
 type Index struct {
-	// RWMutex allows multiple readers OR one writer.
+	// mu keeps the map in place: lookups and appends hold it for reading,
+	// TruncateAfter and Close, which pull entries or the map away, for
+	// writing.
 	mu sync.RWMutex
+	// writeMu serializes appends.
+	writeMu sync.Mutex
 
-	file             *os.File
-	writer           *bufio.Writer
-	writerBufferSize int
-	reader           *mmap.MmapStore
+	// file is nil for a read only index.
+	file    *os.File
+	store   *mmap.MmapStore
+	entries atomic.Int64
 }
 
+// NewIndex opens the index at path for writing, creating it if needed.
 func NewIndex(path string) (*Index, error) {
-	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_RDWR, 0o644)
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0o644)
 	if err != nil {
 		return nil, err
 	}
@@ -65,141 +88,257 @@ func NewIndex(path string) (*Index, error) {
 		}
 	}
 
-	reader, err := mmap.NewMmapStore(path)
+	store, err := mmap.NewWritableMmapStore(path, maxIndexSize)
 	if err != nil {
 		f.Close()
 		return nil, err
 	}
 
-	writerBufferSize := entryWidth * 5
-	return &Index{
-		file:             f,
-		writer:           bufio.NewWriterSize(f, writerBufferSize),
-		reader:           reader,
-		writerBufferSize: writerBufferSize,
-	}, nil
+	// An index that wasn't closed is still preallocated, and after a crash
+	// the pages that made it to disk might not be the first ones, or hold
+	// garbage. Count up to the first entry that can't follow the one before,
+	// a hole or garbage, and drop everything past it, so the entries in use
+	// are always followed by zeros.
+	n := min(fi.Size(), store.Size()) / entryWidth
+	entries := n
+	var prev IndexEntry
+	for k := range n {
+		entry := readEntry(store, int(k))
+		if !entry.follows(prev) {
+			entries = k
+			break
+		}
+		prev = entry
+	}
+	if entries < n {
+		if err := errors.Join(f.Truncate(entries*entryWidth), f.Truncate(store.Size())); err != nil {
+			store.Close()
+			f.Close()
+			return nil, fmt.Errorf("failed to drop stale index entries: %w", err)
+		}
+	}
+
+	i := &Index{file: f, store: store}
+	i.entries.Store(entries)
+	return i, nil
+}
+
+// newReadOnlyIndex maps the index at path as it is, without preallocating
+// it. A missing index is an empty one.
+func newReadOnlyIndex(path string) (*Index, error) {
+	store, err := mmap.NewMmapStore(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return &Index{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	i := &Index{store: store}
+	i.entries.Store(countEntries(store, store.Size()/entryWidth))
+	return i, nil
+}
+
+// countEntries finds how many of the first n entries in store are in use.
+// Real entries never have offset 0 (the first is for record 500), so the first
+// zeroed entry is where the preallocated space starts. NewIndex makes sure
+// nothing but zeros follows it, so it can be binary searched.
+func countEntries(store *mmap.MmapStore, n int64) int64 {
+	return int64(sort.Search(int(n), func(k int) bool {
+		return readEntry(store, k).LogicalOff == 0
+	}))
 }
 
 // WriteEntry appends a new entry.
-// LOCK STRATEGY: Exclusive Lock (Lock).
+// LOCK STRATEGY: Read Lock, plus writeMu against other appends.
 func (i *Index) WriteEntry(entry IndexEntry) error {
-	i.mu.Lock()
-	defer i.mu.Unlock()
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	i.writeMu.Lock()
+	defer i.writeMu.Unlock()
+
+	if i.file == nil {
+		return fmt.Errorf("index is read only")
+	}
 
 	var buf [entryWidth]byte
 	entry.Marshal(buf[:])
 
-	_, err := i.writer.Write(buf[:])
-	return err
+	n := i.entries.Load()
+	if err := i.store.StoreUint64(int(n)*entryWidth, binary.NativeEndian.Uint64(buf[:])); err != nil {
+		return fmt.Errorf("failed to write entry %d: %w", n, err)
+	}
+	// Publishes the entry to lookups
+	i.entries.Store(n + 1)
+	return nil
 }
 
-// readEntryInternal is a private helper without locks.
-func (i *Index) readEntryInternal(idx int) (IndexEntry, error) {
-	offset := idx * entryWidth
+// readEntry is a private helper without locks. Entries past the map read as
+// zero.
+func readEntry(store *mmap.MmapStore, idx int) IndexEntry {
+	var buf [entryWidth]byte
+	binary.NativeEndian.PutUint64(buf[:], store.LoadUint64(idx*entryWidth))
+
+	indexEntry := IndexEntry{}
+	indexEntry.Unmarshal(buf[:])
+	return indexEntry
+}
 
-	chunk, err := i.reader.ReadAt(offset, entryWidth)
+// refresh counts the entries a writer added to the file since this read only
+// index was opened. The preallocated file is mapped whole, so they are
+// already in the map, unless the writer closed the index since and trimmed
+// the file: nothing past its end is looked at.
+func (i *Index) refresh() {
+	size, err := i.store.FileSize()
 	if err != nil {
-		return IndexEntry{}, fmt.Errorf("failed to read entry %d: %w", idx, err)
+		return
+	}
+	n := i.entries.Load()
+	total := min(i.store.Size(), size) / entryWidth
+	for n < total && readEntry(i.store, int(n)).LogicalOff != 0 {
+		n++
 	}
+	for {
+		cur := i.entries.Load()
+		if n <= cur || i.entries.CompareAndSwap(cur, n) {
+			return
+		}
+	}
+}
 
-	indexEntry := IndexEntry{}
-	indexEntry.Unmarshal(chunk)
-	return indexEntry, nil
+// search returns how many entries have an offset at or below logicalOff.
+// Caller must hold i.mu.
+func (i *Index) search(logicalOff uint32) int {
+	return sort.Search(int(i.entries.Load()), func(k int) bool {
+		return readEntry(i.store, k).LogicalOff > logicalOff
+	})
 }
 
 // FindNearest finds the closest offset.
-// LOCK STRATEGY: Mixed.
-// 1. Lock() to Sync (Writer Lock).
-// 2. Downgrade to RLock() to Search (Reader Lock).
+// LOCK STRATEGY: Read Lock. Entries are written straight into the map, so
+// there is nothing to flush or remap first. A read only index only looks for
+// new entries when the target is past the last one it knows of.
 func (i *Index) FindNearest(targetOffset uint32) (IndexEntry, error) {
-	// Sync the Reader (Needs Write Lock because Sync modifies mmap slice)
-	// We wrap this in a closure or block to ensure Unlock happens immediately
-	if err := func() error {
-		i.mu.Lock()
-		defer i.mu.Unlock()
-
-		// Flush writer to disk so reader can see it
-		if err := i.writer.Flush(); err != nil {
-			return fmt.Errorf("failed to flush: %w", err)
-		}
-
-		// Remap memory if file grew
-		return i.reader.Sync()
-	}(); err != nil {
-		return IndexEntry{}, fmt.Errorf("failed to sync: %w", err)
-	}
-
-	// The Search (Needs Read Lock)
 	i.mu.RLock()
 	defer i.mu.RUnlock()
 
-	totalEntries := int(i.reader.Size()) / entryWidth
-
-	var readErr error
-	idx := sort.Search(totalEntries, func(k int) bool {
-		entry, err := i.readEntryInternal(k)
-		if err != nil {
-			readErr = err
-		}
-		return entry.LogicalOff > targetOffset
-	})
-
-	if readErr != nil {
-		return IndexEntry{}, fmt.Errorf("failed to read index entry: %w", readErr)
+	if i.store == nil {
+		return IndexEntry{}, nil
 	}
 
+	idx := i.search(targetOffset)
+	if i.file == nil && idx == int(i.entries.Load()) {
+		i.refresh()
+		idx = i.search(targetOffset)
+	}
 	if idx == 0 {
 		return IndexEntry{}, nil
 	}
-
-	return i.readEntryInternal(idx - 1)
+	return readEntry(i.store, idx-1), nil
 }
 
+// LastEntry returns the last entry, or the zero entry if there is none.
+// LOCK STRATEGY: Read Lock.
 func (i *Index) LastEntry() (IndexEntry, error) {
-	i.mu.Lock()
-	if err := i.writer.Flush(); err != nil {
-		i.mu.Unlock()
-		return IndexEntry{}, err
-	}
-	if err := i.reader.Sync(); err != nil {
-		i.mu.Unlock()
-		return IndexEntry{}, err
-	}
-	i.mu.Unlock()
-
 	i.mu.RLock()
 	defer i.mu.RUnlock()
 
-	size := i.reader.Size()
-	if size == 0 {
+	n := i.entries.Load()
+	if n == 0 {
 		return IndexEntry{}, nil
 	}
-
-	lastIndex := int(size/entryWidth) - 1
-	return i.readEntryInternal(lastIndex)
+	return readEntry(i.store, int(n-1)), nil
 }
 
-// Close flushes and cleans up.
+// Close trims a writable index down to its entries, syncs it and cleans up.
 // LOCK STRATEGY: Exclusive Lock.
 func (i *Index) Close() error {
 	i.mu.Lock()
 	defer i.mu.Unlock()
 
-	if err := i.writer.Flush(); err != nil {
-		return fmt.Errorf("failed to flush index writer: %w", err)
+	if i.store != nil {
+		if err := i.store.Close(); err != nil {
+			return fmt.Errorf("failed to close reader: %w", err)
+		}
+	}
+	if i.file == nil {
+		return nil
+	}
+
+	if err := i.file.Truncate(i.entries.Load() * entryWidth); err != nil {
+		i.file.Close()
+		return fmt.Errorf("failed to trim index: %w", err)
 	}
 
 	if err := i.file.Sync(); err != nil {
+		i.file.Close()
 		return fmt.Errorf("failed to sync file: %w", err)
 	}
 
-	if err := i.reader.Close(); err != nil {
-		return fmt.Errorf("failed to close reader: %w", err)
+	return i.file.Close()
+}
+
+// TruncateAfter drops every entry pointing past logicalOff, so the index never
+// references records that were cut off the log. The dropped entries are zeroed
+// so a reopen doesn't count them again, a read only index only forgets them.
+// LOCK STRATEGY: Exclusive Lock.
+func (i *Index) TruncateAfter(logicalOff uint32) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	totalEntries := int(i.entries.Load())
+	keep := i.search(logicalOff)
+	if keep == totalEntries {
+		return nil
+	}
+	if i.file == nil {
+		i.entries.Store(int64(keep))
+		return nil
 	}
 
-	return i.file.Close()
+	for k := keep; k < totalEntries; k++ {
+		if err := i.store.StoreUint64(k*entryWidth, 0); err != nil {
+			return fmt.Errorf("failed to truncate index: %w", err)
+		}
+	}
+	i.entries.Store(int64(keep))
+	return nil
+}
+
+// Sync fsyncs the index, the map included.
+// LOCK STRATEGY: Read Lock.
+func (i *Index) Sync() error {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	if i.file == nil {
+		return nil
+	}
+	return i.file.Sync()
 }
 
-func (i *Index) Flush() error {
-	return i.writer.Flush()
+// Size returns how many bytes of the index are in use.
+func (i *Index) Size() int64 {
+	return i.entries.Load() * entryWidth
+}
+
+// MappedSize returns how many bytes of the index are currently mmapped.
+// LOCK STRATEGY: Read Lock.
+func (i *Index) MappedSize() int64 {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	if i.store == nil {
+		return 0
+	}
+	return i.store.Size()
+}
+
+// Residency reports how much of the mapped index is in the page cache.
+// LOCK STRATEGY: Read Lock.
+func (i *Index) Residency() (mmap.Residency, error) {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	if i.store == nil {
+		return mmap.Residency{}, nil
+	}
+	return i.store.Residency()
 }
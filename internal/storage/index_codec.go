@@ -28,3 +28,11 @@ func (ie *IndexEntry) Unmarshal(src []byte) {
 	ie.LogicalOff = binary.BigEndian.Uint32(src[0:offWidth])
 	ie.MemoryPos = binary.BigEndian.Uint32(src[offWidth:entryWidth])
 }
+
+// follows reports whether ie can come right after prev in an index: entries
+// are for every indexInterval-th record, so offsets are multiples of it, and
+// both offsets and positions only grow. The zeros of preallocated space can't
+// follow anything, and garbage left by a crash hardly ever can.
+func (ie IndexEntry) follows(prev IndexEntry) bool {
+	return ie.LogicalOff > prev.LogicalOff && ie.LogicalOff%indexInterval == 0 && ie.MemoryPos > prev.MemoryPos
+}
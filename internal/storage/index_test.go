@@ -3,6 +3,7 @@ package storage
 import (
 	"os"
 	"path/filepath"
+	"sync"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -37,8 +38,10 @@ func TestIndex_NewIndex(t *testing.T) {
 			t.Run(tc.name, func(t *testing.T) {
 				indexPath := filepath.Join(t.TempDir(), "test.index")
 
-				data := make([]byte, tc.inputSize)
-				err := os.WriteFile(indexPath, data, 0o644)
+				data := make([]byte, 2*entryWidth)
+				IndexEntry{LogicalOff: 500, MemoryPos: 100}.Marshal(data)
+				IndexEntry{LogicalOff: 1000, MemoryPos: 200}.Marshal(data[entryWidth:])
+				err := os.WriteFile(indexPath, data[:tc.inputSize], 0o644)
 				require.NoError(t, err)
 
 				index, err := NewIndex(indexPath)
@@ -52,6 +55,31 @@ func TestIndex_NewIndex(t *testing.T) {
 		}
 	})
 
+	t.Run("drops garbage entries", func(t *testing.T) {
+		for name, garbage := range map[string]IndexEntry{
+			"offset not a multiple of the interval": {LogicalOff: 1234, MemoryPos: 300},
+			"offset going back":                     {LogicalOff: 500, MemoryPos: 300},
+			"position going back":                   {LogicalOff: 1500, MemoryPos: 50},
+		} {
+			t.Run(name, func(t *testing.T) {
+				indexPath := filepath.Join(t.TempDir(), "test.index")
+				data := make([]byte, 4*entryWidth)
+				IndexEntry{LogicalOff: 500, MemoryPos: 100}.Marshal(data)
+				IndexEntry{LogicalOff: 1000, MemoryPos: 200}.Marshal(data[entryWidth:])
+				garbage.Marshal(data[2*entryWidth:])
+				IndexEntry{LogicalOff: 2000, MemoryPos: 400}.Marshal(data[3*entryWidth:])
+				require.NoError(t, os.WriteFile(indexPath, data, 0o644))
+
+				index, err := NewIndex(indexPath)
+				require.NoError(t, err)
+				defer index.Close()
+				last, err := index.LastEntry()
+				require.NoError(t, err)
+				require.Equal(t, IndexEntry{LogicalOff: 1000, MemoryPos: 200}, last)
+			})
+		}
+	})
+
 	t.Run("cleans up on truncate failure", func(t *testing.T) {
 		indexPath := filepath.Join(t.TempDir(), "test.index")
 
@@ -67,67 +95,138 @@ func TestIndex_NewIndex(t *testing.T) {
 }
 
 func TestIndex_WriteEntry(t *testing.T) {
-	t.Run("buffers writes until close and content correct", func(t *testing.T) {
+	t.Run("entries are visible right away and close trims the file", func(t *testing.T) {
 		indexPath := filepath.Join(t.TempDir(), "test.index")
 		index, err := NewIndex(indexPath)
 		require.NoError(t, err)
 
 		entries := []IndexEntry{
-			{LogicalOff: 1, MemoryPos: 100},
-			{LogicalOff: 2, MemoryPos: 200},
+			{LogicalOff: 500, MemoryPos: 100},
+			{LogicalOff: 1000, MemoryPos: 200},
 		}
 		for _, entry := range entries {
 			err = index.WriteEntry(entry)
 			require.NoError(t, err)
 		}
 
-		// File should be empty (buffered)
+		// Preallocated, the entries are already in the file
 		contents, err := os.ReadFile(indexPath)
 		require.NoError(t, err)
-		assert.Empty(t, contents)
+		require.Len(t, contents, maxIndexSize)
+		expected := make([]byte, len(entries)*entryWidth)
+		for i, e := range entries {
+			e.Marshal(expected[i*entryWidth:])
+		}
+		assert.Equal(t, expected, contents[:len(expected)])
 
 		err = index.Close()
 		require.NoError(t, err)
 
-		// Verify written content
 		contents, err = os.ReadFile(indexPath)
 		require.NoError(t, err)
+		assert.Equal(t, expected, contents)
+	})
 
-		expected := make([]byte, len(entries)*entryWidth)
-		for i, e := range entries {
-			e.Marshal(expected[i*entryWidth:])
+	t.Run("reopening an index that wasn't closed", func(t *testing.T) {
+		indexPath := filepath.Join(t.TempDir(), "test.index")
+		index, err := NewIndex(indexPath)
+		require.NoError(t, err)
+		require.NoError(t, index.WriteEntry(IndexEntry{LogicalOff: 500, MemoryPos: 100}))
+		require.NoError(t, index.WriteEntry(IndexEntry{LogicalOff: 1000, MemoryPos: 200}))
+
+		// A crash leaves the preallocated file behind
+		reopened, err := NewIndex(indexPath)
+		require.NoError(t, err)
+		defer reopened.Close()
+		readOnly, err := newReadOnlyIndex(indexPath)
+		require.NoError(t, err)
+		defer readOnly.Close()
+
+		for _, i := range []*Index{reopened, readOnly} {
+			last, err := i.LastEntry()
+			require.NoError(t, err)
+			assert.Equal(t, IndexEntry{LogicalOff: 1000, MemoryPos: 200}, last)
 		}
-		assert.Equal(t, expected, contents)
+
+		require.NoError(t, reopened.WriteEntry(IndexEntry{LogicalOff: 1500, MemoryPos: 300}))
+		nearest, err := reopened.FindNearest(1600)
+		require.NoError(t, err)
+		assert.Equal(t, IndexEntry{LogicalOff: 1500, MemoryPos: 300}, nearest)
+		require.NoError(t, index.Close())
 	})
 
-	t.Run("flushes when buffer is full", func(t *testing.T) {
+	t.Run("truncated entries stay dropped after a reopen", func(t *testing.T) {
 		indexPath := filepath.Join(t.TempDir(), "test.index")
 		index, err := NewIndex(indexPath)
 		require.NoError(t, err)
 		defer index.Close()
+		for i := 1; i <= 3; i++ {
+			require.NoError(t, index.WriteEntry(IndexEntry{LogicalOff: uint32(i * 500), MemoryPos: uint32(i * 100)}))
+		}
+		require.NoError(t, index.TruncateAfter(999))
+		assert.Equal(t, int64(entryWidth), index.Size())
 
-		entriesPerBuffer := index.writerBufferSize / entryWidth
+		reopened, err := newReadOnlyIndex(indexPath)
+		require.NoError(t, err)
+		defer reopened.Close()
+		assert.Equal(t, int64(entryWidth), reopened.Size())
+	})
+}
+
+func TestIndex_FindNearest(t *testing.T) {
+	t.Run("lookups run alongside appends", func(t *testing.T) {
+		index, err := NewIndex(filepath.Join(t.TempDir(), "test.index"))
+		require.NoError(t, err)
+		defer index.Close()
 
-		// Fill buffer exactly
-		for i := range entriesPerBuffer {
-			err = index.WriteEntry(IndexEntry{
-				LogicalOff: uint32(i),
-				MemoryPos:  uint32(i * 8),
+		const total = 2000
+		var wg sync.WaitGroup
+		wg.Go(func() {
+			for k := 1; k <= total; k++ {
+				assert.NoError(t, index.WriteEntry(IndexEntry{LogicalOff: uint32(k * 500), MemoryPos: uint32(k)}))
+			}
+		})
+		for range 4 {
+			wg.Go(func() {
+				for k := 1; k <= total; k++ {
+					entry, err := index.FindNearest(uint32(k*500 + 1))
+					assert.NoError(t, err)
+					// Either not there yet or the right one, never torn
+					if entry.LogicalOff != 0 {
+						assert.Equal(t, entry.LogicalOff, entry.MemoryPos*500)
+					}
+				}
 			})
-			require.NoError(t, err)
 		}
+		wg.Wait()
 
-		// Buffer full but not yet flushed
-		contents, err := os.ReadFile(indexPath)
+		entry, err := index.FindNearest(total*500 + 1)
+		require.NoError(t, err)
+		assert.Equal(t, IndexEntry{LogicalOff: total * 500, MemoryPos: total}, entry)
+	})
+
+	t.Run("read only index picks up entries written after it was opened", func(t *testing.T) {
+		indexPath := filepath.Join(t.TempDir(), "test.index")
+		index, err := NewIndex(indexPath)
 		require.NoError(t, err)
-		assert.Empty(t, contents)
+		defer index.Close()
+		require.NoError(t, index.WriteEntry(IndexEntry{LogicalOff: 500, MemoryPos: 100}))
 
-		// One more entry should trigger flush
-		err = index.WriteEntry(IndexEntry{LogicalOff: 9999, MemoryPos: 9999})
+		readOnly, err := newReadOnlyIndex(indexPath)
 		require.NoError(t, err)
+		defer readOnly.Close()
+		require.Equal(t, int64(entryWidth), readOnly.Size())
 
-		contents, err = os.ReadFile(indexPath)
+		require.NoError(t, index.WriteEntry(IndexEntry{LogicalOff: 1000, MemoryPos: 200}))
+
+		// Within what it knew of, no need to look further
+		entry, err := readOnly.FindNearest(400)
+		require.NoError(t, err)
+		assert.Zero(t, entry)
+		assert.Equal(t, int64(entryWidth), readOnly.Size())
+
+		entry, err = readOnly.FindNearest(1200)
 		require.NoError(t, err)
-		assert.NotEmpty(t, contents, "buffer should have flushed")
+		assert.Equal(t, IndexEntry{LogicalOff: 1000, MemoryPos: 200}, entry)
 	})
 }
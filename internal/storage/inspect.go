@@ -0,0 +1,174 @@
+package storage
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// DiagnosticKind classifies what InspectSegment found wrong.
+type DiagnosticKind int
+
+const (
+	// DiagnosticOffsetGap is a record whose offset doesn't follow the one
+	// before it.
+	DiagnosticOffsetGap DiagnosticKind = iota + 1
+	// DiagnosticChecksum is a record that doesn't match its checksum.
+	DiagnosticChecksum
+	// DiagnosticBadHeader is a record header that makes no sense.
+	DiagnosticBadHeader
+	// DiagnosticTornTail is a partially written record at the end of the
+	// segment. Nothing can be read past it.
+	DiagnosticTornTail
+	// DiagnosticFormat is a segment written in a format this version doesn't
+	// read, such as one from before segments had a format header. None of it
+	// is read.
+	DiagnosticFormat
+)
+
+func (k DiagnosticKind) String() string {
+	switch k {
+	case DiagnosticOffsetGap:
+		return "offset-gap"
+	case DiagnosticChecksum:
+		return "checksum"
+	case DiagnosticBadHeader:
+		return "bad-header"
+	case DiagnosticTornTail:
+		return "torn-tail"
+	case DiagnosticFormat:
+		return "format"
+	default:
+		return fmt.Sprintf("DiagnosticKind(%d)", int(k))
+	}
+}
+
+func (k DiagnosticKind) MarshalText() ([]byte, error) {
+	return []byte(k.String()), nil
+}
+
+type SegmentDiagnostic struct {
+	Kind   DiagnosticKind `json:"kind"`
+	Detail string         `json:"detail"`
+}
+
+// SegmentEntry is what InspectSegment found at Position in the segment: a
+// record, what is wrong with it, or both.
+type SegmentEntry struct {
+	Position int64
+	// Offset is the absolute offset of Record, taken from the segment's base
+	// offset.
+	Offset int
+	// Record is nil for a torn tail, which has no record to show.
+	Record      *Record
+	Diagnostics []SegmentDiagnostic
+}
+
+// InspectSegment walks the segment file at path record by record. Unlike a
+// Log it doesn't stop at the first problem: a record with the wrong checksum
+// or after a gap in the offsets is yielded along with a diagnostic, and only
+// a torn tail ends the walk. I/O errors are yielded as errors and end it too.
+// A segment in another format is yielded as a single diagnostic, with no
+// record. The base offset is taken from the file name when it is a segment
+// name.
+func InspectSegment(path string) iter.Seq2[SegmentEntry, error] {
+	return func(yield func(SegmentEntry, error) bool) {
+		f, err := os.Open(path)
+		if err != nil {
+			yield(SegmentEntry{}, err)
+			return
+		}
+		defer f.Close()
+
+		info, err := f.Stat()
+		if err != nil {
+			yield(SegmentEntry{}, err)
+			return
+		}
+
+		size := info.Size()
+		partial, err := checkSegmentHeader(f, size, path)
+		if errors.Is(err, ErrSegmentFormat) {
+			yield(SegmentEntry{Diagnostics: []SegmentDiagnostic{{Kind: DiagnosticFormat, Detail: err.Error()}}}, nil)
+			return
+		}
+		if err != nil || partial {
+			if err != nil {
+				yield(SegmentEntry{}, err)
+			}
+			return
+		}
+
+		// Anything but a segment name gives 0
+		base, _ := strconv.Atoi(strings.TrimSuffix(filepath.Base(path), ".log"))
+		r := io.NewSectionReader(f, SegmentHeaderSize, size-SegmentHeaderSize)
+		inspectRecords(bufio.NewReaderSize(r, 64*1024), SegmentHeaderSize, size, base, yield)
+	}
+}
+
+func inspectRecords(r io.Reader, pos, size int64, base int, yield func(SegmentEntry, error) bool) {
+	var expected uint64
+	var headerBuf [HeaderSize]byte
+	for pos < size {
+		entry := SegmentEntry{Position: pos}
+		if size-pos < HeaderSize {
+			entry.Diagnostics = []SegmentDiagnostic{{
+				Kind:   DiagnosticTornTail,
+				Detail: fmt.Sprintf("%d bytes, too short for a header", size-pos),
+			}}
+			yield(entry, nil)
+			return
+		}
+		if _, err := io.ReadFull(r, headerBuf[:]); err != nil {
+			yield(entry, fmt.Errorf("failed to read header at byte %d: %w", pos, err))
+			return
+		}
+
+		var h RecordHeader
+		h.Decode(headerBuf[:])
+		if h.PayloadSize > uint64(size-pos-HeaderSize) {
+			entry.Diagnostics = []SegmentDiagnostic{{
+				Kind:   DiagnosticTornTail,
+				Detail: fmt.Sprintf("record %d has a %d byte payload but only %d bytes follow", base+int(h.LogicalOffset), h.PayloadSize, size-pos-HeaderSize),
+			}}
+			yield(entry, nil)
+			return
+		}
+
+		payload := make([]byte, h.PayloadSize)
+		if _, err := io.ReadFull(r, payload); err != nil {
+			yield(entry, fmt.Errorf("failed to read payload at byte %d: %w", pos, err))
+			return
+		}
+		entry.Offset = base + int(h.LogicalOffset)
+		entry.Record = &Record{Header: h, Payload: payload}
+
+		if h.LogicalOffset != expected {
+			entry.Diagnostics = append(entry.Diagnostics, SegmentDiagnostic{
+				Kind:   DiagnosticOffsetGap,
+				Detail: fmt.Sprintf("expected offset %d", base+int(expected)),
+			})
+		}
+		if h.ExpiresAt != 0 && h.ExpiresAt < h.Timestamp {
+			entry.Diagnostics = append(entry.Diagnostics, SegmentDiagnostic{
+				Kind:   DiagnosticBadHeader,
+				Detail: "expires before it was written",
+			})
+		}
+		if err := h.verify(payload); err != nil {
+			entry.Diagnostics = append(entry.Diagnostics, SegmentDiagnostic{Kind: DiagnosticChecksum, Detail: err.Error()})
+		}
+
+		if !yield(entry, nil) {
+			return
+		}
+		pos += HeaderSize + int64(h.PayloadSize)
+		expected = h.LogicalOffset + 1
+	}
+}
@@ -0,0 +1,110 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestInspectSegment(t *testing.T) {
+	writeSegment := func(t *testing.T, records int) string {
+		t.Helper()
+		logPath := filepath.Join(t.TempDir(), newLogNameFromInt(1000).string())
+		l, err := NewLogMediumDurable(logPath, 1000)
+		require.NoError(t, err)
+		for i := range records {
+			require.NoError(t, l.Append(fmt.Appendf(nil, "data %d", i)))
+		}
+		require.NoError(t, l.Close())
+		return logPath
+	}
+	collect := func(t *testing.T, path string) []SegmentEntry {
+		t.Helper()
+		var entries []SegmentEntry
+		for entry, err := range InspectSegment(path) {
+			require.NoError(t, err)
+			entries = append(entries, entry)
+		}
+		return entries
+	}
+
+	t.Run("healthy segment", func(t *testing.T) {
+		entries := collect(t, writeSegment(t, 3))
+		require.Len(t, entries, 3)
+		for i, entry := range entries {
+			require.Equal(t, 1000+i, entry.Offset)
+			require.Equal(t, int64(SegmentHeaderSize+i*(HeaderSize+len("data 0"))), entry.Position)
+			require.Equal(t, fmt.Sprintf("data %d", i), string(entry.Record.Payload))
+			require.Empty(t, entry.Diagnostics)
+		}
+	})
+	t.Run("keeps going past a bad record and stops at a torn tail", func(t *testing.T) {
+		path := writeSegment(t, 3)
+		f, err := os.OpenFile(path, os.O_RDWR, 0)
+		require.NoError(t, err)
+		// Flip a payload byte of the first record
+		_, err = f.WriteAt([]byte{'X'}, SegmentHeaderSize+HeaderSize)
+		require.NoError(t, err)
+		// Half a record at the end
+		_, err = f.WriteAt(make([]byte, 10), int64(SegmentHeaderSize+3*(HeaderSize+len("data 0"))))
+		require.NoError(t, err)
+		require.NoError(t, f.Close())
+
+		entries := collect(t, path)
+		require.Len(t, entries, 4)
+		require.Equal(t, DiagnosticChecksum, entries[0].Diagnostics[0].Kind)
+		require.Empty(t, entries[1].Diagnostics)
+		require.Empty(t, entries[2].Diagnostics)
+		require.Nil(t, entries[3].Record)
+		require.Equal(t, DiagnosticTornTail, entries[3].Diagnostics[0].Kind)
+	})
+	t.Run("offset gap", func(t *testing.T) {
+		path := writeSegment(t, 3)
+		f, err := os.OpenFile(path, os.O_RDWR, 0)
+		require.NoError(t, err)
+		// Renumber the second record
+		_, err = f.WriteAt([]byte{0, 0, 0, 0, 0, 0, 0, 7}, int64(SegmentHeaderSize+HeaderSize+len("data 0")))
+		require.NoError(t, err)
+		require.NoError(t, f.Close())
+
+		entries := collect(t, path)
+		require.Len(t, entries, 3)
+		require.Equal(t, 1007, entries[1].Offset)
+		kinds := []DiagnosticKind{}
+		for _, d := range entries[1].Diagnostics {
+			kinds = append(kinds, d.Kind)
+		}
+		require.Equal(t, []DiagnosticKind{DiagnosticOffsetGap, DiagnosticChecksum}, kinds)
+		require.Equal(t, DiagnosticOffsetGap, entries[2].Diagnostics[0].Kind)
+	})
+	t.Run("segment in an old format", func(t *testing.T) {
+		path := writeSegment(t, 3)
+		data, err := os.ReadFile(path)
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(path, data[SegmentHeaderSize:], 0o644))
+
+		entries := collect(t, path)
+		require.Len(t, entries, 1)
+		require.Nil(t, entries[0].Record)
+		require.Equal(t, DiagnosticFormat, entries[0].Diagnostics[0].Kind)
+	})
+	t.Run("stops when asked to", func(t *testing.T) {
+		path := writeSegment(t, 10)
+		seen := 0
+		for range InspectSegment(path) {
+			seen++
+			if seen == 2 {
+				break
+			}
+		}
+		require.Equal(t, 2, seen)
+	})
+	t.Run("missing file", func(t *testing.T) {
+		for _, err := range InspectSegment(filepath.Join(t.TempDir(), "missing.log")) {
+			require.ErrorIs(t, err, os.ErrNotExist)
+		}
+	})
+}
@@ -0,0 +1,44 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+// ErrPartitionLocked is returned when opening a partition for writing that
+// another process, or another Partition of this one, already has open for
+// writing.
+var ErrPartitionLocked = errors.New("partition is locked by another writer")
+
+// lockDir takes the exclusive advisory lock on dir that a partition holds for
+// as long as it is open for writing, so that two writers never interleave
+// their records. The lock goes away with the returned file, even if the
+// process dies. Where flock isn't supported it returns nil: the directory is
+// left unlocked.
+func lockDir(dir string) (*os.File, error) {
+	f, err := os.Open(dir)
+	if err != nil {
+		return nil, err
+	}
+	err = flock(f)
+	if errors.Is(err, errors.ErrUnsupported) {
+		return nil, f.Close()
+	}
+	if err != nil {
+		f.Close()
+		if errors.Is(err, errWouldBlock) {
+			return nil, fmt.Errorf("%w: %s", ErrPartitionLocked, dir)
+		}
+		return nil, fmt.Errorf("failed to lock %s: %w", dir, err)
+	}
+	return f, nil
+}
+
+// unlockDir releases a lock taken by lockDir, nil being no lock.
+func unlockDir(lock *os.File) error {
+	if lock == nil {
+		return nil
+	}
+	return lock.Close()
+}
@@ -0,0 +1,21 @@
+//go:build linux
+
+package storage
+
+import (
+	"os"
+	"syscall"
+)
+
+// errWouldBlock is what flock fails with when another file holds the lock.
+const errWouldBlock = syscall.EWOULDBLOCK
+
+// flock takes an exclusive flock on f without waiting for it.
+func flock(f *os.File) error {
+	for {
+		err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+		if err != syscall.EINTR {
+			return err
+		}
+	}
+}
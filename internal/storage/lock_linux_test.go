@@ -0,0 +1,63 @@
+package storage
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPartition_Lock(t *testing.T) {
+	t.Run("one writer at a time", func(t *testing.T) {
+		dir := t.TempDir()
+		p, err := NewPartition(dir)
+		require.NoError(t, err)
+		require.NoError(t, p.Append([]byte("first writer")))
+
+		_, err = NewPartition(dir)
+		require.ErrorIs(t, err, ErrPartitionLocked)
+
+		// Readers don't take the lock
+		config := DefaultPartitionConfig()
+		config.Mode = OpenReadOnly
+		reader, err := NewPartitionWithConfig(dir, config)
+		require.NoError(t, err)
+		require.NoError(t, reader.Close())
+
+		require.NoError(t, p.Close())
+		p, err = NewPartition(dir)
+		require.NoError(t, err)
+		require.NoError(t, p.Close())
+	})
+
+	t.Run("a failed open releases the lock", func(t *testing.T) {
+		dir := t.TempDir()
+		writeSegment(t, dir, 0, 10)
+		writeSegment(t, dir, 20, 10)
+
+		_, err := NewPartition(dir)
+		require.ErrorIs(t, err, ErrSegmentGap)
+
+		config := DefaultPartitionConfig()
+		config.Mode = OpenForce
+		p, err := NewPartitionWithConfig(dir, config)
+		require.NoError(t, err)
+		require.NoError(t, p.Close())
+	})
+
+	t.Run("the lock moves with the partition", func(t *testing.T) {
+		from := t.TempDir()
+		to := filepath.Join(t.TempDir(), "moved")
+		p, err := NewPartition(from)
+		require.NoError(t, err)
+		defer p.Close()
+		require.NoError(t, p.Append([]byte("moving")))
+
+		require.NoError(t, p.Move(to))
+		_, err = NewPartition(to)
+		require.ErrorIs(t, err, ErrPartitionLocked)
+		old, err := NewPartition(from)
+		require.NoError(t, err)
+		require.NoError(t, old.Close())
+	})
+}
@@ -0,0 +1,16 @@
+//go:build !linux
+
+package storage
+
+import (
+	"errors"
+	"os"
+)
+
+// errWouldBlock is never returned by flock here.
+var errWouldBlock = errors.New("would block")
+
+// flock needs flock(2), which is only wired up on Linux.
+func flock(f *os.File) error {
+	return errors.ErrUnsupported
+}
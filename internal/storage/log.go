@@ -5,32 +5,58 @@ import (
 	"bufio"
 	"errors"
 	"fmt"
+	"hash/crc32"
+	"io"
+	"log/slog"
+	"math"
 	"os"
+	"path/filepath"
+	"slices"
+	"strings"
 	"sync"
 	"time"
 
 	asyncwriter "github.com/mvaleed/brook/internal/storage/async-writer"
+	"github.com/mvaleed/brook/internal/storage/uring"
 )
 
 var ErrRecordNotFoundFullScan = errors.New("Record with offset not found after full scan")
 
 type Log struct {
-	mu            sync.RWMutex
-	readOnly      bool
-	file          *os.File
-	path          string
-	nextMemoryPos int64
-	nextOffset    int64
-	baseOffset    int64 // Represents global offset
-	createdAt     time.Time
-	writeFunc     func([]byte) (int, error)
-	flushFunc     func() error
-	closeFunc     func() error
+	mu                sync.RWMutex
+	writeMu           sync.Mutex // serializes writers, taken before mu; held alone while AppendFrom streams
+	readOnly          bool
+	file              *os.File    // nil for remote logs
+	reader            io.ReaderAt // where records are read from, the file itself for local logs
+	path              string
+	nextMemoryPos     int64
+	nextOffset        int64
+	baseOffset        int64  // Represents global offset
+	tornBytes         int64  // size of the partial record found at the end of the file on open
+	maxTimestamp      uint64 // newest timestamp of the records, once maxTimestampKnown
+	maxTimestampKnown bool
+	sealed            bool  // opened read only with a seal matching the file, the count comes from it
+	lastPosition      int64 // where the last record of a sealed log starts, -1 if it has none
+	createdAt         time.Time
+	writeFunc         func(bufs [][]byte) (int, error) // writes bufs as one, a whole batch of records
+	commitFunc        func() error                     // makes written records as durable as the mode promises
+	flushFunc         func() error
+	closeFunc         func() error
+	durability        DurabilityInfo
+	groupSync         *groupSyncer // set with group commit, appends wait on it for their fsync
+	preallocated      bool         // disk reserved past the end of the file, given back on close
+	maxRecordSize     int64        // larger payloads are refused, and taken for corruption when read
 
 	index     *Index
+	sub       *subIndex  // finer positions between index entries, in memory only
+	times     *timeIndex // positions by timestamp, nil until the log is first searched by time
 	indexPath string
+	logger    *slog.Logger
 }
 
+// NewLogReadOnly opens the log at path for reading. A writer, in this process
+// or another one, may keep appending to it: Refresh and Tail pick up what it
+// adds.
 func NewLogReadOnly(path string, baseOffset int) (*Log, error) {
 	f, err := os.OpenFile(path, os.O_RDONLY, 0)
 	if err != nil {
@@ -42,52 +68,124 @@ func NewLogReadOnly(path string, baseOffset int) (*Log, error) {
 		f.Close()
 		return nil, err
 	}
-	indexPath := path + ".index"
-	index, err := NewIndex(indexPath)
+	// A segment still being created reads as an empty one
+	size := info.Size()
+	partial, err := checkSegmentHeader(f, size, path)
 	if err != nil {
 		f.Close()
 		return nil, err
 	}
-
-	lastEntry, err := index.LastEntry()
+	if partial {
+		size = SegmentHeaderSize
+	}
+	indexPath := path + ".index"
+	index, err := newReadOnlyIndex(indexPath)
 	if err != nil {
 		f.Close()
-		index.Close()
 		return nil, err
 	}
 
 	l := &Log{
 		file:          f,
-		nextMemoryPos: info.Size(),
+		reader:        f,
+		nextMemoryPos: size,
 		nextOffset:    0,
-		writeFunc: func([]byte) (int, error) {
+		writeFunc: func([][]byte) (int, error) {
 			return 0, nil
 		},
+		commitFunc: func() error {
+			return nil
+		},
 		flushFunc: func() error {
 			return nil
 		},
 		closeFunc: func() error {
 			return nil
 		},
-		index:      index,
-		indexPath:  indexPath,
-		path:       path,
-		createdAt:  TimeNowInUtc(),
-		readOnly:   true,
-		baseOffset: int64(baseOffset),
-	}
-	if info.Size() != 0 {
-		l.nextOffset, err = l.reloadNextOffset(lastEntry)
-		if err != nil {
+		index:         index,
+		sub:           new(subIndex),
+		indexPath:     indexPath,
+		path:          path,
+		createdAt:     TimeNowInUtc(),
+		readOnly:      true,
+		baseOffset:    int64(baseOffset),
+		maxRecordSize: DefaultMaxRecordBytes,
+		logger:        slog.Default().With("segment", filepath.Base(path)),
+	}
+	// A sealed segment was fsynced whole, index included, before the seal
+	// went out: neither has to be read to count it
+	if seal, ok := readSeal(path); ok && seal.Size == size {
+		l.nextOffset = seal.Records
+		l.lastPosition = seal.LastPosition
+		l.sealed = true
+		// Seals from before the newest timestamp was kept leave it to a scan
+		l.maxTimestamp = seal.MaxTimestamp
+		l.maxTimestampKnown = seal.MaxTimestamp != 0 || seal.Records == 0
+		l.setCreatedAt()
+		return l, nil
+	}
+	lastEntry, err := l.checkIndexTail()
+	if err != nil {
+		f.Close()
+		index.Close()
+		return nil, err
+	}
+	if size > SegmentHeaderSize {
+		if err := l.loadTail(lastEntry); err != nil {
+			f.Close()
+			index.Close()
 			return nil, fmt.Errorf("failed to initialize read only log: %w", err)
 		}
-		l.createdAt = info.ModTime()
+		l.setCreatedAt()
 	}
 
 	return l, nil
 }
 
-func newLog(path string, baseOffset int, writerBufferSize int, flushToOSOnEveryAppend bool, flushToDiskOnEveryAppend bool) (*Log, error) {
+// openRemoteLog opens a read only log whose records live somewhere other than
+// a local file (a tiered segment in an object store). Only the index has to be
+// on local disk.
+func openRemoteLog(reader io.ReaderAt, size int64, indexPath string, baseOffset int) (*Log, error) {
+	if size > 0 {
+		if _, err := checkSegmentHeader(reader, size, strings.TrimSuffix(indexPath, ".index")); err != nil {
+			return nil, err
+		}
+	}
+	index, err := newReadOnlyIndex(indexPath)
+	if err != nil {
+		return nil, err
+	}
+
+	noop := func() error { return nil }
+	return &Log{
+		reader:        reader,
+		nextMemoryPos: size,
+		writeFunc: func([][]byte) (int, error) {
+			return 0, nil
+		},
+		commitFunc:    noop,
+		flushFunc:     noop,
+		closeFunc:     noop,
+		index:         index,
+		sub:           new(subIndex),
+		indexPath:     indexPath,
+		createdAt:     TimeNowInUtc(),
+		readOnly:      true,
+		baseOffset:    int64(baseOffset),
+		maxRecordSize: DefaultMaxRecordBytes,
+		logger:        slog.Default(),
+	}, nil
+}
+
+// newLog opens a writable log. knownNextOffset skips the tail scan when the
+// caller already knows how many records the log holds (from a checkpoint);
+// pass a negative value to scan. logger defaults to slog.Default().
+func newLog(path string, baseOffset int, writerBufferSize int, flushToOSOnEveryAppend bool, flushToDiskOnEveryAppend bool, knownNextOffset int64, logger *slog.Logger) (*Log, error) {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	logger = logger.With("segment", filepath.Base(path))
+
 	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_RDWR, 0o644)
 	if err != nil {
 		return nil, err
@@ -98,87 +196,135 @@ func newLog(path string, baseOffset int, writerBufferSize int, flushToOSOnEveryA
 		f.Close()
 		return nil, err
 	}
-
-	indexPath := path + ".index"
-	index, err := NewIndex(indexPath)
+	size := info.Size()
+	partial, err := checkSegmentHeader(f, size, path)
 	if err != nil {
 		f.Close()
 		return nil, err
 	}
+	if partial {
+		// New, or torn while being created
+		if err := writeSegmentHeader(f); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("failed to write segment header of %s: %w", path, err)
+		}
+		size = SegmentHeaderSize
+	}
+
+	// Written to from now on, the seal would go stale
+	if err := removeSeal(path); err != nil {
+		f.Close()
+		return nil, err
+	}
 
-	lastEntry, err := index.LastEntry()
+	indexPath := path + ".index"
+	index, err := NewIndex(indexPath)
 	if err != nil {
 		f.Close()
-		index.Close()
 		return nil, err
 	}
-	var writeFunc func([]byte) (int, error)
+
+	var writeFunc func([][]byte) (int, error)
+	var commitFunc func() error
 	var flushFunc func() error
 	var closeFunc func() error
+	var mode DurabilityMode
 
 	if flushToDiskOnEveryAppend || flushToOSOnEveryAppend {
+		mode = DurabilityMedium
+		if flushToDiskOnEveryAppend {
+			mode = DurabilityFull
+		}
 		// Synchronous modes - use bufio.Writer
 		writer := bufio.NewWriterSize(f, writerBufferSize)
 
-		writeFunc = func(data []byte) (int, error) {
-			n, err := writer.Write(data)
-			if err != nil {
-				return n, err
+		writeFunc = func(bufs [][]byte) (int, error) {
+			total := 0
+			for _, b := range bufs {
+				n, err := writer.Write(b)
+				total += n
+				if err != nil {
+					return total, err
+				}
 			}
-			if flushToOSOnEveryAppend {
+			return total, nil
+		}
+		commitFunc = func() error {
+			// An fsync only covers what the OS has been handed
+			if flushToOSOnEveryAppend || flushToDiskOnEveryAppend {
 				if err := writer.Flush(); err != nil {
-					return 0, err
+					return err
 				}
 			}
 			if flushToDiskOnEveryAppend {
-				if err := f.Sync(); err != nil {
-					return 0, err
+				if err := syncFile(f); err != nil {
+					return err
 				}
 			}
-			return n, nil
+			return nil
 		}
 
 		flushFunc = func() error { return writer.Flush() }
 		closeFunc = func() error { return writer.Flush() }
 	} else {
 		// Async mode - use AsyncWriter with periodic flushing
-		asyncWriter := asyncwriter.NewAsyncWriterSize(f, writerBufferSize)
+		mode = DurabilityAsync
+		asyncWriter := asyncwriter.NewAsyncWriterSize(f, writerBufferSize, logger)
 
-		writeFunc = func(data []byte) (int, error) {
-			return asyncWriter.Write(data)
+		writeFunc = func(bufs [][]byte) (int, error) {
+			return asyncWriter.WriteBuffers(bufs)
 		}
+		commitFunc = func() error { return nil }
 		flushFunc = func() error { return asyncWriter.Flush() }
 		closeFunc = func() error { return asyncWriter.Close() }
 	}
 
 	l := &Log{
 		file:          f,
-		nextMemoryPos: info.Size(),
+		reader:        f,
+		nextMemoryPos: size,
 		nextOffset:    0,
 		writeFunc:     writeFunc,
+		commitFunc:    commitFunc,
 		flushFunc:     flushFunc,
 		closeFunc:     closeFunc,
+		durability:    durabilityInfo(mode, writerBufferSize),
 		index:         index,
+		sub:           new(subIndex),
 		indexPath:     indexPath,
 		path:          path,
 		createdAt:     TimeNowInUtc(),
 		readOnly:      false,
 		baseOffset:    int64(baseOffset),
+		maxRecordSize: DefaultMaxRecordBytes,
+		logger:        logger,
+		// An empty log has seen every record it will hold
+		maxTimestampKnown: size == SegmentHeaderSize,
 	}
 
-	if info.Size() != 0 {
-		l.nextOffset, err = l.reloadNextOffset(lastEntry)
-		if err != nil {
+	lastEntry, err := l.checkIndexTail()
+	if err != nil {
+		closeFunc()
+		f.Close()
+		index.Close()
+		return nil, err
+	}
+	if size > SegmentHeaderSize {
+		if knownNextOffset >= 0 {
+			l.nextOffset = knownNextOffset
+		} else if err := l.loadTail(lastEntry); err != nil {
+			f.Close()
+			index.Close()
 			return nil, fmt.Errorf("failed to initialize log: %w", err)
 		}
-		l.createdAt = info.ModTime()
+		l.setCreatedAt()
 	}
 
 	return l, nil
 }
 
 func NewLogAsync(path string, baseOffset int) (*Log, error) {
-	l, err := newLog(path, baseOffset, 4096*2, false, false)
+	l, err := newLog(path, baseOffset, 4096*2, false, false, -1, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -187,7 +333,7 @@ func NewLogAsync(path string, baseOffset int) (*Log, error) {
 }
 
 func NewLogMediumDurable(path string, baseOffset int) (*Log, error) {
-	l, err := newLog(path, baseOffset, 4096, true, false)
+	l, err := newLog(path, baseOffset, 4096, true, false, -1, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -196,7 +342,7 @@ func NewLogMediumDurable(path string, baseOffset int) (*Log, error) {
 }
 
 func NewLogFullDurable(path string, baseOffset int) (*Log, error) {
-	l, err := newLog(path, baseOffset, 4096, true, true)
+	l, err := newLog(path, baseOffset, 4096, true, true, -1, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -204,33 +350,194 @@ func NewLogFullDurable(path string, baseOffset int) (*Log, error) {
 	return l, nil
 }
 
+// NewLogGroupCommit opens a log as durable as NewLogFullDurable, where
+// concurrent appends share fsyncs instead of taking turns: every append is
+// handed to the OS right away, and then waits, without holding up the appends
+// behind it, for an fsync that covers it.
+func NewLogGroupCommit(path string, baseOffset int) (*Log, error) {
+	l, err := newLog(path, baseOffset, 4096, true, false, -1, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	l.durability = durabilityInfo(DurabilityFull, 4096)
+	l.groupSync = &groupSyncer{sync: func() (int64, error) {
+		l.mu.RLock()
+		end := l.nextOffset
+		l.mu.RUnlock()
+		return end, syncFile(l.file)
+	}}
+	return l, nil
+}
+
+// NewLogURing opens a log that writes through io_uring instead of a
+// bufio.Writer, as durable as mode says (medium or full, async isn't
+// supported). It is experimental, and fails with an error wrapping
+// errors.ErrUnsupported anywhere but on Linux with io_uring enabled.
+func NewLogURing(path string, baseOffset int, mode DurabilityMode) (*Log, error) {
+	if mode != DurabilityMedium && mode != DurabilityFull {
+		return nil, fmt.Errorf("io_uring log doesn't support %s durability", mode)
+	}
+	const writerBufferSize = 64 * 1024
+	l, err := newLog(path, baseOffset, writerBufferSize, true, mode == DurabilityFull, -1, nil)
+	if err != nil {
+		return nil, err
+	}
+	// Nothing went through the writer newLog set up yet, swap it out
+	writer, err := uring.NewWriter(l.file, writerBufferSize)
+	if err != nil {
+		return nil, errors.Join(err, l.Close())
+	}
+
+	l.writeFunc = func(bufs [][]byte) (int, error) {
+		total := 0
+		for _, b := range bufs {
+			n, err := writer.Write(b)
+			total += n
+			if err != nil {
+				return total, err
+			}
+		}
+		return total, nil
+	}
+	l.commitFunc = writer.Flush
+	if mode == DurabilityFull {
+		l.commitFunc = writer.Sync
+	}
+	l.flushFunc = writer.Flush
+	l.closeFunc = writer.Close
+	return l, nil
+}
+
 // Append adds a new record to the log.
 func (l *Log) Append(payload []byte) error {
+	return l.AppendBatch([][]byte{payload})
+}
+
+// AppendWithTTL adds a new record to the log that expires after ttl.
+func (l *Log) AppendWithTTL(payload []byte, ttl time.Duration) error {
+	return l.appendBatch([][]byte{payload}, []time.Duration{ttl}, nil)
+}
+
+// AppendBatch adds all payloads to the log as consecutive records. The batch
+// is handed to the writer in a single write and is flushed (and fsynced, in
+// full durable mode) once, instead of once per record.
+func (l *Log) AppendBatch(payloads [][]byte) error {
+	return l.appendBatch(payloads, nil, nil)
+}
+
+// appendBatch is AppendBatch with a TTL and a timestamp per payload. ttls is
+// either nil (no record expires) or as long as payloads, and so is
+// timestamps (nil, or a zero timestamp, stamps a record with the time it is
+// appended). TTLs run from the time of the append whatever the timestamp.
+func (l *Log) appendBatch(payloads [][]byte, ttls []time.Duration, timestamps []time.Time) error {
+	for _, payload := range payloads {
+		if int64(len(payload)) > l.maxRecordSize {
+			return &RecordTooLargeError{Size: int64(len(payload)), Limit: l.maxRecordSize}
+		}
+	}
+
+	now := time.Now()
+	// A single record, the common case, keeps its header on the stack
+	var one [1]RecordHeader
+	headers := one[:]
+	if len(payloads) != 1 {
+		headers = make([]RecordHeader, len(payloads))
+	}
+	for i := range headers {
+		headers[i].Timestamp = uint64(now.UnixNano())
+		if timestamps != nil && !timestamps[i].IsZero() {
+			headers[i].Timestamp = uint64(timestamps[i].UnixNano())
+		}
+		if ttls != nil {
+			headers[i].ExpiresAt = expiresAt(now, ttls[i])
+		}
+	}
+
+	return l.writeRecords(headers, payloads)
+}
+
+// writeVec is the scratch space of writeRecords: the encoded headers of a
+// batch, and the headers and payloads interleaved for a single vectored write.
+// Pooled so appends don't allocate.
+type writeVec struct {
+	headers []byte
+	bufs    [][]byte
+}
+
+var writeVecPool = sync.Pool{New: func() any { return new(writeVec) }}
+
+// release puts vec back in the pool, without holding on to the payloads.
+func (vec *writeVec) release() {
+	clear(vec.bufs)
+	writeVecPool.Put(vec)
+}
+
+// writeRecords appends payloads as consecutive records. Only the Timestamp and
+// ExpiresAt of headers are used, offsets and sizes are filled in here.
+func (l *Log) writeRecords(headers []RecordHeader, payloads [][]byte) error {
 	if l.readOnly {
 		return errors.New("cannot append record when lo is opended in read only mode")
 	}
 
+	end, err := l.write(headers, payloads)
+	if err != nil || l.groupSync == nil {
+		return err
+	}
+	// Outside the locks, so the next appends can join the fsync
+	return l.groupSync.wait(end)
+}
+
+// write is writeRecords up to the commit, it returns the offset after the
+// last record.
+func (l *Log) write(headers []RecordHeader, payloads [][]byte) (int64, error) {
+	l.writeMu.Lock()
+	defer l.writeMu.Unlock()
 	l.mu.Lock()
 	defer l.mu.Unlock()
 
-	header := RecordHeader{
-		LogicalOffset: uint64(l.nextOffset),
-		PayloadSize:   uint64(len(payload)),
-		Timestamp:     uint64(time.Now().UnixNano()),
+	vec := writeVecPool.Get().(*writeVec)
+	defer vec.release()
+	vec.headers = slices.Grow(vec.headers[:0], len(payloads)*HeaderSize)[:len(payloads)*HeaderSize]
+	vec.bufs = vec.bufs[:0]
+	for i, payload := range payloads {
+		header := headers[i]
+		header.LogicalOffset = uint64(l.nextOffset + int64(i))
+		header.PayloadSize = uint64(len(payload))
+		encoded := vec.headers[i*HeaderSize : (i+1)*HeaderSize]
+		header.encodeWithChecksum(encoded, payload)
+		vec.bufs = append(vec.bufs, encoded, payload)
 	}
 
-	buf := make([]byte, HeaderSize+len(payload))
-	header.Encode(buf[:HeaderSize])
-	copy(buf[HeaderSize:], payload)
-	if _, err := l.writeFunc(buf); err != nil {
-		return fmt.Errorf("error writing record: %w", err)
+	if _, err := l.writeFunc(vec.bufs); err != nil {
+		return 0, fmt.Errorf("error writing record: %w", err)
 	}
 
-	bytesWritten := HeaderSize + len(payload)
-	l.nextMemoryPos += int64(bytesWritten)
+	for i, payload := range payloads {
+		if err := l.advance(HeaderSize + int64(len(payload))); err != nil {
+			return 0, err
+		}
+		l.observeTimestamp(headers[i].Timestamp)
+	}
+
+	if err := l.commitFunc(); err != nil {
+		return 0, fmt.Errorf("error committing records: %w", err)
+	}
+
+	return l.nextOffset, nil
+}
+
+// advance accounts for a record of recordSize bytes that was just written,
+// adding an index entry every 500 records. Caller must hold l.mu.
+func (l *Log) advance(recordSize int64) error {
+	l.sub.add(uint32(l.nextOffset), l.nextMemoryPos)
+	if l.times != nil {
+		l.times.add(uint32(l.nextOffset), l.nextMemoryPos, l.maxTimestamp)
+	}
+	l.nextMemoryPos += recordSize
 	l.nextOffset += 1
 
-	if l.nextOffset%500 != 0 {
+	if l.nextOffset%indexInterval != 0 {
 		return nil
 	}
 
@@ -238,24 +545,152 @@ func (l *Log) Append(payload []byte) error {
 		MemoryPos:  uint32(l.nextMemoryPos),
 		LogicalOff: uint32(l.nextOffset),
 	}
-
 	return l.index.WriteEntry(indexEntry)
 }
 
+// AppendFrom adds a new record whose payload is streamed from r, so it never
+// has to be held in memory. With n >= 0 exactly n bytes are read and a
+// shorter reader fails with io.ErrUnexpectedEOF; with n < 0 r is read until
+// EOF. It returns the size of the payload.
+//
+// The header goes out first with a placeholder size that runs past the end
+// of any log, and is backpatched once the payload is written. A crash in
+// between leaves a torn record that is cut off on the next open, and a failed
+// append is removed right away. Readers aren't held up while the payload
+// streams in, only other writers are.
+func (l *Log) AppendFrom(r io.Reader, n int64) (int64, error) {
+	if l.readOnly {
+		return 0, errors.New("cannot append record when log is opened in read only mode")
+	}
+
+	l.writeMu.Lock()
+	defer l.writeMu.Unlock()
+
+	l.mu.Lock()
+	start := l.nextMemoryPos
+	offset := l.nextOffset
+	// The record is written to the file directly, behind whatever is buffered.
+	err := l.flushFunc()
+	l.mu.Unlock()
+	if err != nil {
+		return 0, fmt.Errorf("error flushing log: %w", err)
+	}
+
+	// Index positions are 32 bit, so that's as far as a record may reach.
+	limit := min(math.MaxUint32-start-HeaderSize, l.maxRecordSize)
+	if n > limit {
+		return 0, &RecordTooLargeError{Size: n, Limit: limit}
+	}
+
+	timestamp := uint64(time.Now().UnixNano())
+	size, err := l.streamRecord(r, n, limit, start, offset, timestamp)
+	if err != nil {
+		if truncErr := l.file.Truncate(start); truncErr != nil {
+			return 0, errors.Join(err, fmt.Errorf("failed to remove partial record: %w", truncErr))
+		}
+		return 0, err
+	}
+
+	l.mu.Lock()
+	if err := l.advance(HeaderSize + size); err != nil {
+		l.mu.Unlock()
+		return 0, err
+	}
+	l.observeTimestamp(timestamp)
+	if err := l.commitFunc(); err != nil {
+		l.mu.Unlock()
+		return 0, fmt.Errorf("error committing record: %w", err)
+	}
+	end := l.nextOffset
+	l.mu.Unlock()
+
+	if l.groupSync != nil {
+		if err := l.groupSync.wait(end); err != nil {
+			return 0, err
+		}
+	}
+	return size, nil
+}
+
+// streamRecord writes the record of AppendFrom at pos, which must be the end
+// of the file.
+func (l *Log) streamRecord(r io.Reader, n int64, limit int64, pos int64, offset int64, timestamp uint64) (int64, error) {
+	header := RecordHeader{
+		LogicalOffset: uint64(offset),
+		PayloadSize:   streamingPayloadSize,
+		Timestamp:     timestamp,
+	}
+	var headerBuf [HeaderSize]byte
+	header.Encode(headerBuf[:])
+
+	w := bufio.NewWriterSize(l.file, 64*1024)
+	if _, err := w.Write(headerBuf[:]); err != nil {
+		return 0, err
+	}
+
+	// Reading one byte past the limit tells a payload that is too large from
+	// one that fits exactly.
+	src := io.LimitReader(r, limit+1)
+	if n >= 0 {
+		src = io.LimitReader(r, n)
+	}
+	crc := crc32.New(crcTable)
+	size, err := io.Copy(io.MultiWriter(w, crc), src)
+	if err != nil {
+		return 0, fmt.Errorf("error streaming payload: %w", err)
+	}
+	if n >= 0 && size < n {
+		return 0, fmt.Errorf("payload ended after %d of %d bytes: %w", size, n, io.ErrUnexpectedEOF)
+	}
+	if size > limit {
+		return 0, fmt.Errorf("%w: more than %d bytes", ErrRecordTooLarge, limit)
+	}
+	if err := w.Flush(); err != nil {
+		return 0, fmt.Errorf("error writing record: %w", err)
+	}
+
+	header.PayloadSize = uint64(size)
+	header.Encode(headerBuf[:])
+	header.Checksum = finishChecksum(crc.Sum32(), headerBuf[:])
+	header.Encode(headerBuf[:])
+
+	// l.file appends no matter the offset, the header needs its own descriptor.
+	f, err := os.OpenFile(l.path, os.O_WRONLY, 0)
+	if err != nil {
+		return 0, fmt.Errorf("error opening log to write header: %w", err)
+	}
+	if _, err := f.WriteAt(headerBuf[:], pos); err != nil {
+		f.Close()
+		return 0, fmt.Errorf("error writing header: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return 0, fmt.Errorf("error writing header: %w", err)
+	}
+
+	return size, nil
+}
+
 func (l *Log) scanFrom(startMemoryPos int64, handleFn func(h RecordHeader, payloadPos int64) bool) error {
 	err := l.flushFunc()
 	if err != nil {
 		return fmt.Errorf("failed to flush writer in scanFrom: %w", err)
 	}
+	return l.scanFlushed(startMemoryPos, handleFn)
+}
 
+// scanFlushed is scanFrom for a caller that flushed the writer already.
+func (l *Log) scanFlushed(startMemoryPos int64, handleFn func(h RecordHeader, payloadPos int64) bool) error {
 	currentPos := startMemoryPos
+	// Escapes to the heap through ReadAt, one for the whole scan
+	var headerBuf [HeaderSize]byte
 	for {
-		var headerBuf [HeaderSize]byte
 
-		if currentPos >= l.nextMemoryPos {
+		// A record that does not fit entirely before nextMemoryPos has not been
+		// fully written yet, so it must not be observed.
+		if currentPos+HeaderSize > l.nextMemoryPos {
 			return ErrRecordNotFoundFullScan
 		}
-		_, err := l.file.ReadAt(headerBuf[:], currentPos)
+		_, err := l.reader.ReadAt(headerBuf[:], currentPos)
 		if err != nil {
 			return fmt.Errorf("failed read header data in scan from: %w", err)
 		}
@@ -264,7 +699,11 @@ func (l *Log) scanFrom(startMemoryPos int64, handleFn func(h RecordHeader, paylo
 		header.Decode(headerBuf[:])
 
 		payloadStartPos := currentPos + HeaderSize
+		if header.PayloadSize > uint64(l.nextMemoryPos-payloadStartPos) {
+			return ErrRecordNotFoundFullScan
+		}
 
+		l.sub.add(uint32(header.LogicalOffset), currentPos)
 		if handleFn(header, payloadStartPos) {
 			return nil
 		}
@@ -273,21 +712,119 @@ func (l *Log) scanFrom(startMemoryPos int64, handleFn func(h RecordHeader, paylo
 	}
 }
 
-func (l *Log) reloadNextOffset(lastEntry IndexEntry) (int64, error) {
-	var lastRecordOffset uint64
-	err := l.scanFrom(int64(lastEntry.MemoryPos), func(h RecordHeader, payloadPos int64) bool {
-		lastRecordOffset = h.LogicalOffset
+// checkIndexTail returns the last entry of the index that points where its
+// record starts in the log, dropping the entries after it. An entry can make
+// it to disk ahead of the records before it, or be garbage after a crash,
+// and lookups and loadTail must not start from there.
+func (l *Log) checkIndexTail() (IndexEntry, error) {
+	for {
+		last, err := l.index.LastEntry()
+		if err != nil || last.LogicalOff == 0 {
+			return last, err
+		}
+		var prev IndexEntry
+		if last.LogicalOff > indexInterval {
+			if prev, err = l.index.FindNearest(last.LogicalOff - 1); err != nil {
+				return IndexEntry{}, err
+			}
+		}
+		if l.recordStartsAt(prev, last) {
+			return last, nil
+		}
+
+		l.logger.Warn("dropping index entry that doesn't match the log", "offset", last.LogicalOff, "position", last.MemoryPos)
+		if err := l.index.TruncateAfter(last.LogicalOff - 1); err != nil {
+			return IndexEntry{}, err
+		}
+	}
+}
+
+// recordStartsAt reports whether the headers of the log, walked from the
+// record from points at, lead to the record entry points at, or to the end
+// of the log right where it would start.
+func (l *Log) recordStartsAt(from IndexEntry, entry IndexEntry) bool {
+	pos, offset := max(int64(from.MemoryPos), SegmentHeaderSize), uint64(from.LogicalOff)
+	var headerBuf [HeaderSize]byte
+	for pos < int64(entry.MemoryPos) {
+		if pos+HeaderSize > l.nextMemoryPos {
+			return false
+		}
+		if _, err := l.reader.ReadAt(headerBuf[:], pos); err != nil {
+			return false
+		}
+		var header RecordHeader
+		header.Decode(headerBuf[:])
+		if header.LogicalOffset != offset || header.PayloadSize > uint64(l.nextMemoryPos-pos-HeaderSize) {
+			return false
+		}
+		pos += HeaderSize + int64(header.PayloadSize)
+		offset++
+	}
+	return pos == int64(entry.MemoryPos) && offset == uint64(entry.LogicalOff) && pos <= l.nextMemoryPos
+}
+
+// loadTail scans the records after the last index entry to find where the
+// log ends. Anything past the last complete record is a torn write: its size
+// ends up in tornBytes and, for writable logs, it is cut off so that new
+// records don't land behind it.
+func (l *Log) loadTail(lastEntry IndexEntry) error {
+	// The last index entry points at the record with offset LogicalOff, which
+	// may not have been written yet (entries are added right after record
+	// LogicalOff-1), so that's the floor when nothing follows it.
+	nextOffset := int64(lastEntry.LogicalOff)
+	end := max(int64(lastEntry.MemoryPos), SegmentHeaderSize)
+	err := l.scanFrom(end, func(h RecordHeader, payloadPos int64) bool {
+		nextOffset = int64(h.LogicalOffset) + 1
+		end = payloadPos + int64(h.PayloadSize)
 		return false
 	})
-	if err != nil {
-		if errors.Is(err, ErrRecordNotFoundFullScan) {
-			return int64(lastRecordOffset) + 1, nil
+	if err != nil && !errors.Is(err, ErrRecordNotFoundFullScan) {
+		return err
+	}
+
+	l.nextOffset = nextOffset
+	if end >= l.nextMemoryPos {
+		return nil
+	}
+
+	l.tornBytes = l.nextMemoryPos - end
+	if !l.readOnly {
+		if err := l.file.Truncate(end); err != nil {
+			return fmt.Errorf("failed to cut torn record: %w", err)
 		}
+		l.logger.Warn("cut torn record at the end of the log", "bytes", l.tornBytes, "position", end)
+	}
+	l.nextMemoryPos = end
+	return nil
+}
 
-		return 0, err
+// flush pushes everything buffered in the log writer to the OS, so that the
+// file can be read by something other than this Log. The index has no buffer,
+// its entries are written straight into its map.
+func (l *Log) flush() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.flushFunc()
+}
+
+// sync flushes the log and fsyncs it along with its index, so every record
+// appended so far survives a crash.
+func (l *Log) sync() error {
+	if l.readOnly {
+		return nil
 	}
 
-	return int64(lastRecordOffset) + 1, nil
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if err := l.flushFunc(); err != nil {
+		return err
+	}
+	if err := l.index.Sync(); err != nil {
+		return err
+	}
+	return syncFile(l.file)
 }
 
 // NextOffset Public: acquires lock
@@ -298,30 +835,94 @@ func (l *Log) NextOffset() int64 {
 	return l.nextOffset
 }
 
+// Size Public: acquires lock
+// Returns the number of bytes written to the log file, including whatever is
+// still buffered in the writer.
+func (l *Log) Size() int64 {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.nextMemoryPos
+}
+
+// preallocate reserves disk for the log to grow to size bytes, so that the
+// filesystem allocates it in one go instead of an extent at a time as the log
+// is appended to. The file keeps its size, Close gives back whatever the log
+// didn't grow into.
+func (l *Log) preallocate(size int64) error {
+	if size <= l.nextMemoryPos {
+		return nil
+	}
+	if err := fallocate(l.file, size); err != nil {
+		return fmt.Errorf("failed to preallocate %s: %w", l.path, err)
+	}
+	l.preallocated = true
+	return nil
+}
+
+// startOf returns where to start scanning for the record at the relative
+// offset: the closest record before it that the index or the sub-index knows
+// the position of.
+func (l *Log) startOf(offset uint32) (int64, error) {
+	if l.sealed && int64(offset) == l.nextOffset-1 {
+		return l.lastPosition, nil
+	}
+	entry, err := l.index.FindNearest(offset)
+	if err != nil {
+		return 0, err
+	}
+	if nearest, pos, ok := l.sub.nearest(offset); ok && nearest > entry.LogicalOff {
+		return pos, nil
+	}
+	// Without an entry, the first record
+	return max(int64(entry.MemoryPos), SegmentHeaderSize), nil
+}
+
 func (l *Log) FindRecord(targetLogicalOffset int64) (Record, error) {
+	return l.FindRecordInto(targetLogicalOffset, nil)
+}
+
+// FindRecordInto is FindRecord reading the payload into buf when it has the
+// capacity for it, so the payload of the record returned aliases buf. A
+// payload that doesn't fit gets a slice of its own.
+func (l *Log) FindRecordInto(targetLogicalOffset int64, buf []byte) (Record, error) {
+	return l.findRecord(targetLogicalOffset, buf, -1)
+}
+
+// findRecord is FindRecordInto failing with ErrRecordOverLimit, before the
+// payload is read, for a payload over limit. A negative limit is none.
+func (l *Log) findRecord(targetLogicalOffset int64, buf []byte, limit int64) (Record, error) {
 	l.mu.RLock()
 	defer l.mu.RUnlock()
 
 	targetLogicalOffset = targetLogicalOffset - l.baseOffset
 
-	baseIndexEntry, err := l.index.FindNearest(uint32(targetLogicalOffset))
+	start, err := l.startOf(uint32(targetLogicalOffset))
 	if err != nil {
 		return Record{}, err
 	}
 
 	var record Record
 	var loadErr error
-	err = l.scanFrom(int64(baseIndexEntry.MemoryPos), func(h RecordHeader, payloadPos int64) bool {
+	err = l.scanFrom(start, func(h RecordHeader, payloadPos int64) bool {
 		if h.LogicalOffset == uint64(targetLogicalOffset) {
 			record.Header = h
+			if limit >= 0 && int64(h.PayloadSize) > limit {
+				loadErr = fmt.Errorf("%w: payload of %d bytes, the limit is %d", ErrRecordOverLimit, h.PayloadSize, limit)
+				return true
+			}
 
 			payloadBytes, err := l.loadPayload(
+				buf,
 				payloadPos,
 				int64(h.PayloadSize),
 			)
 			if err != nil {
 				loadErr = err
-				return false
+				return true
+			}
+			if err := h.verify(payloadBytes); err != nil {
+				loadErr = fmt.Errorf("record %d: %w", int64(h.LogicalOffset)+l.baseOffset, err)
+				return true
 			}
 
 			record.Payload = payloadBytes
@@ -345,19 +946,203 @@ func (l *Log) FindRecord(targetLogicalOffset int64) (Record, error) {
 	return record, nil
 }
 
-func (l *Log) loadPayload(payloadPos int64, payloadSize int64) ([]byte, error) {
-	payloadBytes := make([]byte, payloadSize)
-	_, err := l.file.ReadAt(payloadBytes, payloadPos)
+// copyTo appends the records of l from the relative offset from onwards to
+// dst, keeping their timestamps and expiry.
+func (l *Log) copyTo(dst *Log, from int64) error {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	start, err := l.startOf(uint32(from))
+	if err != nil {
+		return err
+	}
+
+	const maxCopyBatch = 1 << 20 // bytes
+	var headers []RecordHeader
+	var payloads [][]byte
+	batchSize := 0
+	flushBatch := func() error {
+		err := dst.writeRecords(headers, payloads)
+		headers, payloads, batchSize = headers[:0], payloads[:0], 0
+		return err
+	}
+
+	var copyErr error
+	err = l.scanFrom(start, func(h RecordHeader, payloadPos int64) bool {
+		if h.LogicalOffset < uint64(from) {
+			return false
+		}
+
+		payload, err := l.loadPayload(nil, payloadPos, int64(h.PayloadSize))
+		if err != nil {
+			copyErr = err
+			return true
+		}
+		headers = append(headers, h)
+		payloads = append(payloads, payload)
+		batchSize += HeaderSize + len(payload)
+
+		if batchSize >= maxCopyBatch {
+			copyErr = flushBatch()
+			return copyErr != nil
+		}
+		return false
+	})
+	if copyErr != nil {
+		return copyErr
+	}
+	if err != nil && !errors.Is(err, ErrRecordNotFoundFullScan) {
+		return err
+	}
+
+	if len(payloads) == 0 {
+		return nil
+	}
+	return flushBatch()
+}
+
+// truncate cuts the log down to its first `records` records, dropping the
+// rest from both the log file and the index.
+func (l *Log) truncate(records int64) error {
+	if l.readOnly {
+		return errors.New("cannot truncate log opened in read only mode")
+	}
+
+	l.writeMu.Lock()
+	defer l.writeMu.Unlock()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if records >= l.nextOffset {
+		return nil
+	}
+	if records < 0 {
+		return fmt.Errorf("cannot truncate log to %d records", records)
+	}
+
+	start, err := l.startOf(uint32(records))
+	if err != nil {
+		return err
+	}
+
+	var truncatePos int64
+	err = l.scanFrom(start, func(h RecordHeader, payloadPos int64) bool {
+		truncatePos = payloadPos - HeaderSize
+		return h.LogicalOffset == uint64(records)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to find truncation point: %w", err)
+	}
+
+	if err := l.file.Truncate(truncatePos); err != nil {
+		return fmt.Errorf("failed to truncate log file: %w", err)
+	}
+	if err := l.index.TruncateAfter(uint32(records)); err != nil {
+		return fmt.Errorf("failed to truncate index: %w", err)
+	}
+	l.sub.truncate(uint32(records))
+	l.times = nil
+	if l.groupSync != nil {
+		l.groupSync.truncated(records)
+	}
+
+	l.logger.Info("truncated log", "records", records, "dropped", l.nextOffset-records)
+	l.nextMemoryPos = truncatePos
+	l.nextOffset = records
+	// The newest of the records left is found out again when asked for
+	l.maxTimestamp, l.maxTimestampKnown = 0, records == 0
+	return nil
+}
+
+// loadPayload reads the payload at payloadPos into buf, or into a new slice
+// if buf is too small. A size over the record limit comes from a corrupt
+// header, and is refused before anything is allocated for it.
+func (l *Log) loadPayload(buf []byte, payloadPos int64, payloadSize int64) ([]byte, error) {
+	if payloadSize > l.maxRecordSize {
+		return nil, fmt.Errorf("%w: payload of %d bytes at byte %d, at most %d are allowed",
+			ErrRecordCorrupt, payloadSize, payloadPos, l.maxRecordSize)
+	}
+	if int64(cap(buf)) < payloadSize {
+		buf = make([]byte, payloadSize)
+	}
+	payloadBytes := buf[:payloadSize]
+	_, err := l.reader.ReadAt(payloadBytes, payloadPos)
 
 	return payloadBytes, err
 }
 
 func (l *Log) Close() error {
+	l.writeMu.Lock()
+	defer l.writeMu.Unlock()
 	l.mu.Lock()
 	defer l.mu.Unlock()
 
 	writerErr := l.closeFunc()
+	var syncErr error
+	if !l.readOnly && writerErr == nil {
+		if l.preallocated {
+			// Drops the blocks reserved past the end along with nothing else
+			syncErr = l.file.Truncate(l.nextMemoryPos)
+		}
+		syncErr = errors.Join(syncErr, l.file.Sync())
+	}
 	indexErr := l.index.Close()
-	fileErr := l.file.Close()
-	return errors.Join(writerErr, indexErr, fileErr)
+	var fileErr error
+	if l.file != nil {
+		fileErr = l.file.Close()
+	}
+	return errors.Join(writerErr, syncErr, indexErr, fileErr)
+}
+
+// observeTimestamp accounts for a record stamped with timestamp that was just
+// written. Caller must hold l.mu.
+func (l *Log) observeTimestamp(timestamp uint64) {
+	if l.maxTimestampKnown {
+		l.maxTimestamp = max(l.maxTimestamp, timestamp)
+	}
+}
+
+// MaxTimestamp returns the newest timestamp of the records of the log, which
+// needn't be the one of its last record when producers pick the timestamps,
+// and the zero time if it has no records. The first call scans the log,
+// unless its records were all appended by this Log.
+func (l *Log) MaxTimestamp() (time.Time, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if !l.maxTimestampKnown {
+		var newest uint64
+		err := l.scanFrom(SegmentHeaderSize, func(h RecordHeader, payloadPos int64) bool {
+			newest = max(newest, h.Timestamp)
+			return false
+		})
+		if err != nil && !errors.Is(err, ErrRecordNotFoundFullScan) {
+			return time.Time{}, fmt.Errorf("failed to scan for the newest timestamp: %w", err)
+		}
+		l.maxTimestamp, l.maxTimestampKnown = newest, true
+	}
+	if l.maxTimestamp == 0 {
+		return time.Time{}, nil
+	}
+	return time.Unix(0, int64(l.maxTimestamp)), nil
+}
+
+// setCreatedAt takes the log to be created when its first record was, rather
+// than when its file was last modified, which copying or restoring the file
+// changes. A log whose first record can't be read keeps the time it was
+// opened.
+func (l *Log) setCreatedAt() {
+	if l.nextMemoryPos < SegmentHeaderSize+HeaderSize {
+		return
+	}
+	var headerBuf [HeaderSize]byte
+	if _, err := l.reader.ReadAt(headerBuf[:], SegmentHeaderSize); err != nil {
+		return
+	}
+	var header RecordHeader
+	header.Decode(headerBuf[:])
+	if header.LogicalOffset != 0 || header.Timestamp == 0 {
+		return
+	}
+	l.createdAt = time.Unix(0, int64(header.Timestamp)).UTC()
 }
@@ -1,6 +1,8 @@
 package storage
 
 import (
+	"errors"
+	"fmt"
 	"path/filepath"
 	"testing"
 )
@@ -64,3 +66,139 @@ func BenchmarkLogAppend_Async(b *testing.B) {
 		}
 	}
 }
+
+func BenchmarkLogFindRecordInto(b *testing.B) {
+	logPath := filepath.Join(b.TempDir(), "test.log")
+	l, err := NewLogMediumDurable(logPath, 0)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer l.Close()
+
+	payload, err := GenerateRandomBytes(100)
+	if err != nil {
+		b.Fatal(err)
+	}
+	for range 1000 {
+		if err := l.Append(payload); err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	buf := make([]byte, 0, 100)
+	b.ReportAllocs()
+	for i := 0; b.Loop(); i++ {
+		if _, err := l.FindRecordInto(int64(i%1000), buf); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkLogAppend_GroupCommit(b *testing.B) {
+	logPath := filepath.Join(b.TempDir(), "test.log")
+	l, err := NewLogGroupCommit(logPath, 0)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer l.Close()
+
+	payload, err := GenerateRandomBytes(100)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.SetParallelism(16)
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if err := l.Append(payload); err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+}
+
+// BenchmarkLogAppendBatch_Writers compares the writers behind a log on
+// batches of records: bufio and AsyncWriter against io_uring.
+func BenchmarkLogAppendBatch_Writers(b *testing.B) {
+	writers := []struct {
+		name string
+		open func(path string) (*Log, error)
+	}{
+		{"bufio/medium", func(path string) (*Log, error) { return NewLogMediumDurable(path, 0) }},
+		{"bufio/full", func(path string) (*Log, error) { return NewLogFullDurable(path, 0) }},
+		{"async", func(path string) (*Log, error) { return NewLogAsync(path, 0) }},
+		{"uring/medium", func(path string) (*Log, error) { return NewLogURing(path, 0, DurabilityMedium) }},
+		{"uring/full", func(path string) (*Log, error) { return NewLogURing(path, 0, DurabilityFull) }},
+	}
+
+	batch := make([][]byte, 100)
+	for i := range batch {
+		payload, err := GenerateRandomBytes(100)
+		if err != nil {
+			b.Fatal(err)
+		}
+		batch[i] = payload
+	}
+
+	for _, w := range writers {
+		b.Run(w.name, func(b *testing.B) {
+			l, err := w.open(filepath.Join(b.TempDir(), "test.log"))
+			if errors.Is(err, errors.ErrUnsupported) {
+				b.Skip(err)
+			}
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer l.Close()
+
+			b.SetBytes(int64(len(batch) * (HeaderSize + 100)))
+			for b.Loop() {
+				if err := l.AppendBatch(batch); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// benchPayloadSizes are the payload sizes the read benchmarks sweep over.
+var benchPayloadSizes = []int{64, 1024, 16 * 1024}
+
+func BenchmarkLogFindRecord(b *testing.B) {
+	const records = 5000
+	for _, size := range benchPayloadSizes {
+		l, err := NewLogMediumDurable(filepath.Join(b.TempDir(), "test.log"), 0)
+		if err != nil {
+			b.Fatal(err)
+		}
+		payload, err := GenerateRandomBytes(size)
+		if err != nil {
+			b.Fatal(err)
+		}
+		for range records {
+			if err := l.Append(payload); err != nil {
+				b.Fatal(err)
+			}
+		}
+
+		for _, at := range []struct {
+			name   string
+			offset int64
+		}{
+			{"head", 0},
+			{"middle", records / 2},
+			{"tail", records - 1},
+		} {
+			b.Run(fmt.Sprintf("%s/%dB", at.name, size), func(b *testing.B) {
+				b.SetBytes(int64(size))
+				b.ReportAllocs()
+				for b.Loop() {
+					if _, err := l.FindRecord(at.offset); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+		l.Close()
+	}
+}
@@ -1,15 +1,22 @@
 package storage
 
 import (
+	"bytes"
 	"crypto/rand"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"math"
 	randm "math/rand/v2"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"testing"
+	"testing/iotest"
+	"time"
 
 	"github.com/stretchr/testify/require"
 )
@@ -35,11 +42,80 @@ func TestLog_NewLog(t *testing.T) {
 		require.NotNil(t, log)
 
 		require.NotNil(t, log.file)
-		require.Equal(t, int64(0), log.nextMemoryPos)
+		require.Equal(t, int64(SegmentHeaderSize), log.nextMemoryPos)
 		require.Equal(t, int64(0), log.nextOffset)
 		require.NotNil(t, log.index)
 	})
 
+	t.Run("reopen with record count on index boundary", func(t *testing.T) {
+		logPath := filepath.Join(t.TempDir(), "test.log")
+		log, err := NewLogMediumDurable(logPath, 0)
+		require.NoError(t, err)
+
+		for i := range 1000 {
+			err = log.Append(fmt.Appendf(nil, "record %d", i))
+			require.NoError(t, err)
+		}
+		require.NoError(t, log.Close())
+
+		log, err = NewLogMediumDurable(logPath, 0)
+		require.NoError(t, err)
+		defer log.Close()
+
+		require.Equal(t, int64(1000), log.NextOffset())
+	})
+
+	t.Run("index entries that don't match the log are dropped", func(t *testing.T) {
+		for name, corrupt := range map[string]func(entries []IndexEntry, size int64) []IndexEntry{
+			// The entry made it to disk, the records before it didn't
+			"entry past the end": func(entries []IndexEntry, size int64) []IndexEntry {
+				return append(entries, IndexEntry{LogicalOff: 1500, MemoryPos: uint32(size) + 100})
+			},
+			"entry in the middle of a record": func(entries []IndexEntry, size int64) []IndexEntry {
+				entries[1].MemoryPos += 3
+				return entries
+			},
+		} {
+			t.Run(name, func(t *testing.T) {
+				logPath := filepath.Join(t.TempDir(), "test.log")
+				log, err := NewLogMediumDurable(logPath, 0)
+				require.NoError(t, err)
+				for i := range 1200 {
+					require.NoError(t, log.Append(fmt.Appendf(nil, "record %d", i)))
+				}
+				size := log.Size()
+				require.NoError(t, log.Close())
+
+				data, err := os.ReadFile(logPath + ".index")
+				require.NoError(t, err)
+				entries := make([]IndexEntry, len(data)/entryWidth)
+				for i := range entries {
+					entries[i].Unmarshal(data[i*entryWidth:])
+				}
+				entries = corrupt(entries, size)
+				data = make([]byte, len(entries)*entryWidth)
+				for i, entry := range entries {
+					entry.Marshal(data[i*entryWidth:])
+				}
+				require.NoError(t, os.WriteFile(logPath+".index", data, 0o644))
+
+				log, err = NewLogMediumDurable(logPath, 0)
+				require.NoError(t, err)
+				defer log.Close()
+				require.Equal(t, int64(1200), log.NextOffset())
+				require.Equal(t, size, log.Size())
+				last, err := log.index.LastEntry()
+				require.NoError(t, err)
+				require.LessOrEqual(t, last.LogicalOff, uint32(1000))
+				for _, offset := range []int64{0, 999, 1000, 1199} {
+					record, err := log.FindRecord(offset)
+					require.NoError(t, err)
+					require.Equal(t, fmt.Sprintf("record %d", offset), string(record.Payload))
+				}
+			})
+		}
+	})
+
 	t.Run("Close on error", func(t *testing.T) {
 		// TODO: Use mock
 	})
@@ -84,7 +160,7 @@ func TestLog_Append(t *testing.T) {
 		require.NoError(t, err)
 		logContents, err := os.ReadFile(logPath)
 		require.NoError(t, err)
-		require.NotEmpty(t, logContents) // Flushed b/c medium durabability !(Not Flushed yet: as 4070 + 24(HeaderSize) < 4096)
+		require.NotEmpty(t, logContents) // Flushed b/c medium durabability
 
 		payloadByte, err = GenerateRandomBytes(1)
 		require.NoError(t, err)
@@ -92,7 +168,36 @@ func TestLog_Append(t *testing.T) {
 		require.NoError(t, err)
 		logContents, err = os.ReadFile(logPath)
 		require.NoError(t, err)
-		require.NotEmpty(t, logContents) // Flushed to disk as 1+4070+24+24 > 4096
+		require.NotEmpty(t, logContents) // Flushed to disk as 1+4070+36+36 > 4096
+	})
+
+	t.Run("append batch", func(t *testing.T) {
+		logPath := filepath.Join(t.TempDir(), "test.log")
+		log, err := NewLogMediumDurable(logPath, 0)
+		require.NoError(t, err)
+		defer log.Close()
+
+		payloads := make([][]byte, 0, 1200)
+		for i := range 1200 {
+			payloads = append(payloads, fmt.Appendf(nil, "record %d", i))
+		}
+
+		err = log.AppendBatch(payloads[:700])
+		require.NoError(t, err)
+		err = log.AppendBatch(payloads[700:])
+		require.NoError(t, err)
+
+		require.Equal(t, int64(1200), log.NextOffset())
+
+		lastEntry, err := log.index.LastEntry()
+		require.NoError(t, err)
+		require.Equal(t, uint32(1000), lastEntry.LogicalOff)
+
+		for _, offset := range []int64{0, 499, 500, 699, 700, 1000, 1199} {
+			record, err := log.FindRecord(offset)
+			require.NoError(t, err)
+			require.Equal(t, payloads[offset], record.Payload)
+		}
 	})
 
 	t.Run("append records to test index", func(t *testing.T) {
@@ -110,26 +215,20 @@ func TestLog_Append(t *testing.T) {
 		}
 		logContents, err := os.ReadFile(logPath)
 		require.NoError(t, err)
-		require.NotEmpty(t, logContents) // Flushed to disk as 24*499 + payload_bytes > 4096
-
-		err = log.index.Flush()
-		require.NoError(t, err)
+		require.NotEmpty(t, logContents) // Flushed to disk as 36*499 + payload_bytes > 4096
 
-		indexContents, err := os.ReadFile(log.indexPath)
+		lastEntry, err := log.index.LastEntry()
 		require.NoError(t, err)
-		require.Empty(t, indexContents) // records < 500
+		require.Zero(t, lastEntry) // records < 500
 
 		payloadByte, err := GenerateRandomBytes(1)
 		require.NoError(t, err)
 		err = log.Append(payloadByte)
 		require.NoError(t, err)
 
-		err = log.index.Flush()
-		require.NoError(t, err)
-
-		indexContents, err = os.ReadFile(log.indexPath)
+		lastEntry, err = log.index.LastEntry()
 		require.NoError(t, err)
-		require.NotEmpty(t, indexContents) // records >= 500
+		require.Equal(t, uint32(500), lastEntry.LogicalOff) // records >= 500
 	})
 
 	t.Run("Concurrent Appends to verify offsets", func(t *testing.T) {
@@ -231,6 +330,35 @@ func TestLog_Append(t *testing.T) {
 	})
 }
 
+func TestLog_AppendAllocations(t *testing.T) {
+	if raceEnabled {
+		t.Skip("the race detector makes pools allocate")
+	}
+
+	for name, open := range map[string]func(string, int) (*Log, error){
+		"medium": NewLogMediumDurable,
+		"async":  NewLogAsync,
+	} {
+		t.Run(name, func(t *testing.T) {
+			log, err := open(filepath.Join(t.TempDir(), "test.log"), 0)
+			require.NoError(t, err)
+			defer log.Close()
+
+			payload := []byte("payload")
+			allocs := testing.AllocsPerRun(1000, func() {
+				if err := log.Append(payload); err != nil {
+					t.Fatal(err)
+				}
+			})
+			require.Zero(t, allocs)
+
+			record, err := log.FindRecord(999)
+			require.NoError(t, err)
+			require.Equal(t, payload, record.Payload)
+		})
+	}
+}
+
 func TestLog_FindRecord(t *testing.T) {
 	t.Run("Find 1 record from 1", func(t *testing.T) {
 		logPath := filepath.Join(t.TempDir(), "test.log")
@@ -374,3 +502,258 @@ func TestLog_FindRecord(t *testing.T) {
 		require.Equal(t, 200322, int(record.Header.PayloadSize))
 	})
 }
+
+func TestLog_truncate(t *testing.T) {
+	t.Run("drops records and index entries past the cut", func(t *testing.T) {
+		logPath := filepath.Join(t.TempDir(), "test.log")
+		log, err := NewLogMediumDurable(logPath, 0)
+		require.NoError(t, err)
+		defer log.Close()
+
+		for i := range 1700 {
+			err = log.Append(fmt.Appendf(nil, "record %d", i))
+			require.NoError(t, err)
+		}
+
+		err = log.truncate(730)
+		require.NoError(t, err)
+		require.Equal(t, int64(730), log.NextOffset())
+
+		lastEntry, err := log.index.LastEntry()
+		require.NoError(t, err)
+		require.Equal(t, uint32(500), lastEntry.LogicalOff)
+
+		_, err = log.FindRecord(730)
+		require.Error(t, err)
+
+		err = log.Append([]byte("after truncate"))
+		require.NoError(t, err)
+
+		record, err := log.FindRecord(730)
+		require.NoError(t, err)
+		require.Equal(t, "after truncate", string(record.Payload))
+
+		record, err = log.FindRecord(729)
+		require.NoError(t, err)
+		require.Equal(t, "record 729", string(record.Payload))
+	})
+
+	t.Run("truncate to zero", func(t *testing.T) {
+		logPath := filepath.Join(t.TempDir(), "test.log")
+		log, err := NewLogMediumDurable(logPath, 0)
+		require.NoError(t, err)
+		defer log.Close()
+
+		for i := range 600 {
+			err = log.Append(fmt.Appendf(nil, "record %d", i))
+			require.NoError(t, err)
+		}
+
+		err = log.truncate(0)
+		require.NoError(t, err)
+		require.Equal(t, int64(0), log.NextOffset())
+		require.Equal(t, int64(SegmentHeaderSize), log.Size())
+
+		info, err := os.Stat(logPath)
+		require.NoError(t, err)
+		require.Equal(t, int64(SegmentHeaderSize), info.Size())
+	})
+}
+
+func TestLog_AppendFrom(t *testing.T) {
+	t.Run("known and unknown length", func(t *testing.T) {
+		logPath := filepath.Join(t.TempDir(), "test.log")
+		log, err := NewLogMediumDurable(logPath, 0)
+		require.NoError(t, err)
+		defer log.Close()
+
+		require.NoError(t, log.Append([]byte("before")))
+
+		size, err := log.AppendFrom(strings.NewReader("known length, extra"), 12)
+		require.NoError(t, err)
+		require.Equal(t, int64(12), size)
+
+		size, err = log.AppendFrom(strings.NewReader("unknown length"), -1)
+		require.NoError(t, err)
+		require.Equal(t, int64(14), size)
+
+		require.NoError(t, log.Append([]byte("after")))
+
+		for offset, want := range []string{"before", "known length", "unknown length", "after"} {
+			record, err := log.FindRecord(int64(offset))
+			require.NoError(t, err)
+			require.Equal(t, want, string(record.Payload))
+		}
+		require.Equal(t, int64(SegmentHeaderSize+4*HeaderSize+6+12+14+5), log.Size())
+	})
+
+	t.Run("failed appends leave nothing behind", func(t *testing.T) {
+		logPath := filepath.Join(t.TempDir(), "test.log")
+		log, err := NewLogMediumDurable(logPath, 0)
+		require.NoError(t, err)
+		defer log.Close()
+
+		require.NoError(t, log.Append([]byte("first")))
+
+		_, err = log.AppendFrom(strings.NewReader("short"), 10)
+		require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+
+		_, err = log.AppendFrom(iotest.TimeoutReader(strings.NewReader("broken reader")), -1)
+		require.ErrorIs(t, err, iotest.ErrTimeout)
+
+		_, err = log.AppendFrom(strings.NewReader("huge"), math.MaxUint32)
+		require.ErrorIs(t, err, ErrRecordTooLarge)
+
+		require.Equal(t, int64(1), log.NextOffset())
+		info, err := os.Stat(logPath)
+		require.NoError(t, err)
+		require.Equal(t, int64(SegmentHeaderSize+HeaderSize+5), info.Size())
+
+		require.NoError(t, log.Append([]byte("second")))
+		record, err := log.FindRecord(1)
+		require.NoError(t, err)
+		require.Equal(t, "second", string(record.Payload))
+	})
+
+	t.Run("header not backpatched is cut on open", func(t *testing.T) {
+		logPath := filepath.Join(t.TempDir(), "test.log")
+		log, err := NewLogMediumDurable(logPath, 0)
+		require.NoError(t, err)
+		require.NoError(t, log.Append([]byte("complete")))
+		require.NoError(t, log.Close())
+
+		// What a crash halfway through AppendFrom leaves behind
+		header := RecordHeader{LogicalOffset: 1, PayloadSize: streamingPayloadSize}
+		buf := make([]byte, HeaderSize+100)
+		header.Encode(buf)
+		f, err := os.OpenFile(logPath, os.O_APPEND|os.O_WRONLY, 0)
+		require.NoError(t, err)
+		_, err = f.Write(buf)
+		require.NoError(t, err)
+		require.NoError(t, f.Close())
+
+		log, err = NewLogMediumDurable(logPath, 0)
+		require.NoError(t, err)
+		defer log.Close()
+		require.Equal(t, int64(1), log.NextOffset())
+		require.Equal(t, int64(HeaderSize+100), log.tornBytes)
+		require.Equal(t, int64(SegmentHeaderSize+HeaderSize+8), log.Size())
+	})
+
+	t.Run("rejected in read only mode", func(t *testing.T) {
+		logPath := filepath.Join(t.TempDir(), "test.log")
+		log, err := NewLogMediumDurable(logPath, 0)
+		require.NoError(t, err)
+		require.NoError(t, log.Append([]byte("record")))
+		require.NoError(t, log.Close())
+
+		log, err = NewLogReadOnly(logPath, 0)
+		require.NoError(t, err)
+		defer log.Close()
+		_, err = log.AppendFrom(strings.NewReader("data"), 4)
+		require.Error(t, err)
+	})
+}
+
+func TestLog_URing(t *testing.T) {
+	for _, mode := range []DurabilityMode{DurabilityMedium, DurabilityFull} {
+		t.Run(mode.String(), func(t *testing.T) {
+			logPath := filepath.Join(t.TempDir(), "test.log")
+			log, err := NewLogURing(logPath, 0, mode)
+			if errors.Is(err, errors.ErrUnsupported) {
+				t.Skip(err)
+			}
+			require.NoError(t, err)
+			require.Equal(t, mode, log.DurabilityInfo().Mode)
+
+			payloads := make([][]byte, 3000)
+			for i := range payloads {
+				payloads[i] = fmt.Appendf(nil, "record %d %*s", i, i%50, "")
+			}
+			// Batches larger than the writer's buffer, and single appends
+			require.NoError(t, log.AppendBatch(payloads[:2000]))
+			_, err = log.AppendFrom(bytes.NewReader(payloads[2000]), -1)
+			require.NoError(t, err)
+			for _, payload := range payloads[2001:] {
+				require.NoError(t, log.Append(payload))
+			}
+
+			require.NoError(t, log.truncate(2500))
+			require.NoError(t, log.AppendBatch(payloads[2500:]))
+
+			for i, payload := range payloads {
+				record, err := log.FindRecord(int64(i))
+				require.NoError(t, err)
+				require.Equal(t, payload, record.Payload)
+			}
+			require.NoError(t, log.Close())
+
+			reopened, err := NewLogReadOnly(logPath, 0)
+			require.NoError(t, err)
+			defer reopened.Close()
+			require.Equal(t, int64(len(payloads)), reopened.NextOffset())
+		})
+	}
+
+	_, err := NewLogURing(filepath.Join(t.TempDir(), "test.log"), 0, DurabilityAsync)
+	require.Error(t, err)
+}
+
+func TestLog_MaxRecordSize(t *testing.T) {
+	logPath := filepath.Join(t.TempDir(), "test.log")
+	log, err := NewLogMediumDurable(logPath, 0)
+	require.NoError(t, err)
+	defer log.Close()
+	require.NoError(t, log.Append([]byte("twenty bytes payload")))
+
+	log.maxRecordSize = 10
+	err = log.Append([]byte("eleven byte"))
+	require.ErrorIs(t, err, ErrRecordTooLarge)
+	var tooLarge *RecordTooLargeError
+	require.ErrorAs(t, err, &tooLarge)
+	require.Equal(t, RecordTooLargeError{Size: 11, Limit: 10}, *tooLarge)
+
+	_, err = log.AppendFrom(strings.NewReader("eleven byte"), 11)
+	require.ErrorAs(t, err, &tooLarge)
+	_, err = log.AppendFrom(strings.NewReader("eleven byte"), -1)
+	require.ErrorIs(t, err, ErrRecordTooLarge)
+	require.Equal(t, int64(1), log.NextOffset())
+
+	// Stored before the limit came down, it now looks corrupt
+	_, err = log.FindRecord(0)
+	require.ErrorIs(t, err, ErrRecordCorrupt)
+
+	require.NoError(t, log.Append([]byte("ten bytes!")))
+	record, err := log.FindRecord(1)
+	require.NoError(t, err)
+	require.Equal(t, "ten bytes!", string(record.Payload))
+}
+
+func TestLog_MaxTimestamp(t *testing.T) {
+	logPath := filepath.Join(t.TempDir(), "test.log")
+	log, err := NewLogMediumDurable(logPath, 0)
+	require.NoError(t, err)
+	newest, err := log.MaxTimestamp()
+	require.NoError(t, err)
+	require.True(t, newest.IsZero())
+
+	base := time.Unix(1700000000, 0)
+	timestamps := []time.Time{base.Add(time.Minute), base, base.Add(-time.Minute)}
+	require.NoError(t, log.appendBatch([][]byte{[]byte("a"), []byte("b"), []byte("c")}, nil, timestamps))
+	record, err := log.FindRecord(1)
+	require.NoError(t, err)
+	require.Equal(t, uint64(base.UnixNano()), record.Header.Timestamp)
+
+	newest, err = log.MaxTimestamp()
+	require.NoError(t, err)
+	require.True(t, newest.Equal(base.Add(time.Minute)), newest)
+	require.NoError(t, log.Close())
+
+	// Opened again, the log is scanned for it
+	log, err = NewLogReadOnly(logPath, 0)
+	require.NoError(t, err)
+	defer log.Close()
+	newest, err = log.MaxTimestamp()
+	require.NoError(t, err)
+	require.True(t, newest.Equal(base.Add(time.Minute)), newest)
+}
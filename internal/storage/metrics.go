@@ -0,0 +1,137 @@
+package storage
+
+import (
+	"github.com/mvaleed/brook/internal/metrics"
+	"github.com/mvaleed/brook/internal/storage/mmap"
+)
+
+// MmapCollector reports the process wide mmap counters of all indexes.
+var MmapCollector = metrics.CollectorFunc(func() []metrics.Sample {
+	stats := mmap.ReadStats()
+	return []metrics.Sample{
+		{
+			Name:  "brook_mmap_mapped_bytes",
+			Help:  "Total size of all live index mappings.",
+			Type:  metrics.Gauge,
+			Value: float64(stats.MappedBytes),
+		},
+		{
+			Name:  "brook_mmap_mappings",
+			Help:  "Number of live index mappings.",
+			Type:  metrics.Gauge,
+			Value: float64(stats.Mappings),
+		},
+		{
+			Name:  "brook_mmap_remaps_total",
+			Help:  "Number of times an index had to be remapped after its file changed size.",
+			Type:  metrics.Counter,
+			Value: float64(stats.Remaps),
+		},
+	}
+})
+
+// FsyncCollector reports how many times logs were fsynced, process wide, and
+// how long that took lately.
+var FsyncCollector = metrics.CollectorFunc(func() []metrics.Sample {
+	total, p99 := fsyncStats()
+	return []metrics.Sample{
+		{
+			Name:  "brook_fsyncs_total",
+			Help:  "Number of times a log was fsynced to make appends durable.",
+			Type:  metrics.Counter,
+			Value: float64(total),
+		},
+		{
+			Name:  "brook_fsync_p99_seconds",
+			Help:  "99th percentile of how long the latest 1024 log fsyncs took.",
+			Type:  metrics.Gauge,
+			Value: p99.Seconds(),
+		},
+	}
+})
+
+// FenceCollector reports how many partitions were fenced for running out of
+// disk space, process wide.
+var FenceCollector = metrics.CollectorFunc(func() []metrics.Sample {
+	return []metrics.Sample{
+		{
+			Name:  "brook_partitions_fenced_total",
+			Help:  "Number of partitions that refused writes after their disk filled up.",
+			Type:  metrics.Counter,
+			Value: float64(fencedPartitions.Load()),
+		},
+	}
+})
+
+// Collect implements metrics.Collector. Page cache residency is only sampled
+// for the active segment and its index: those are the hot files, and once
+// they start dropping out of the page cache reads are going to disk.
+// Residency samples are left out where mincore isn't available.
+func (p *Partition) Collect() []metrics.Sample {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	labels := map[string]string{"partition": p.dir}
+	fenced := 0.0
+	if p.Fenced() {
+		fenced = 1
+	}
+	samples := []metrics.Sample{
+		{
+			Name:   "brook_partition_segments",
+			Help:   "Number of segments in the partition.",
+			Type:   metrics.Gauge,
+			Labels: labels,
+			Value:  float64(len(p.segments)),
+		},
+		{
+			Name:   "brook_partition_active_segment_bytes",
+			Help:   "Size of the active segment.",
+			Type:   metrics.Gauge,
+			Labels: labels,
+			Value:  float64(p.activeLog.Size()),
+		},
+		{
+			Name:   "brook_partition_active_index_mapped_bytes",
+			Help:   "Mapped size of the active segment's index.",
+			Type:   metrics.Gauge,
+			Labels: labels,
+			Value:  float64(p.activeLog.index.MappedSize()),
+		},
+		{
+			Name:   "brook_partition_fenced",
+			Help:   "1 if the partition refuses writes because its disk filled up, 0 otherwise.",
+			Type:   metrics.Gauge,
+			Labels: labels,
+			Value:  fenced,
+		},
+	}
+
+	if r, err := mmap.FileResidency(p.activeLog.path); err == nil {
+		samples = append(samples, residencySamples("segment", labels, r)...)
+	}
+	if r, err := p.activeLog.index.Residency(); err == nil {
+		samples = append(samples, residencySamples("index", labels, r)...)
+	}
+
+	return samples
+}
+
+func residencySamples(kind string, labels map[string]string, r mmap.Residency) []metrics.Sample {
+	return []metrics.Sample{
+		{
+			Name:   "brook_partition_active_" + kind + "_resident_pages",
+			Help:   "Pages of the active " + kind + " file that are in the page cache.",
+			Type:   metrics.Gauge,
+			Labels: labels,
+			Value:  float64(r.ResidentPages),
+		},
+		{
+			Name:   "brook_partition_active_" + kind + "_pages",
+			Help:   "Total pages of the active " + kind + " file.",
+			Type:   metrics.Gauge,
+			Labels: labels,
+			Value:  float64(r.TotalPages),
+		},
+	}
+}
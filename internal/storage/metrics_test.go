@@ -0,0 +1,48 @@
+package storage
+
+import (
+	"fmt"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/mvaleed/brook/internal/metrics"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPartition_Collect(t *testing.T) {
+	partitionDir := filepath.Join(t.TempDir(), "partition/")
+	p, err := NewPartition(partitionDir)
+	require.NoError(t, err)
+
+	for i := range 1500 {
+		require.NoError(t, p.Append(fmt.Appendf(nil, "record %d", i)))
+	}
+	_, err = p.Read(10)
+	require.NoError(t, err)
+
+	r := &metrics.Registry{}
+	r.Register(p)
+	r.Register(MmapCollector)
+	r.Register(FsyncCollector)
+	r.Register(FenceCollector)
+
+	var b strings.Builder
+	_, err = r.WriteTo(&b)
+	require.NoError(t, err)
+
+	out := b.String()
+	label := fmt.Sprintf("{partition=%q}", partitionDir)
+	require.Contains(t, out, "brook_partition_segments"+label+" 1\n")
+	require.Contains(t, out, fmt.Sprintf("brook_partition_active_segment_bytes%s %d\n", label, p.activeLog.Size()))
+	require.Contains(t, out, "brook_partition_active_index_mapped_bytes"+label)
+	require.Contains(t, out, "brook_mmap_remaps_total ")
+	require.Contains(t, out, "brook_fsync_p99_seconds ")
+	require.Contains(t, out, "brook_partition_fenced"+label+" 0\n")
+	require.Contains(t, out, "brook_partitions_fenced_total ")
+	if runtime.GOOS == "linux" {
+		require.Contains(t, out, "brook_partition_active_segment_resident_pages"+label)
+		require.Contains(t, out, "brook_partition_active_index_pages"+label)
+	}
+}
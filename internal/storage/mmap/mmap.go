@@ -3,12 +3,15 @@ package mmap
 import (
 	"fmt"
 	"os"
+	"sync/atomic"
 	"syscall" // For production consider using: "golang.org/x/sys/unix"
+	"unsafe"
 )
 
 type MmapStore struct {
-	file *os.File
-	data []byte
+	file     *os.File
+	data     []byte
+	writable bool
 }
 
 // NewMmapStore opens the file and maps it into memory.
@@ -54,14 +57,73 @@ func NewMmapStore(path string) (*MmapStore, error) {
 		return nil, fmt.Errorf("failed to mmap: %w", err)
 	}
 
+	trackMap(data)
 	return &MmapStore{
 		file: f,
 		data: data,
 	}, nil
 }
 
-// Sync checks if the file has grown and remaps it if necessary.
+// NewWritableMmapStore opens the file read-write, grows it to at least size
+// bytes and maps all of it, so the caller can write through the map with
+// WriteAt. The growth is a plain truncate, the new space is sparse and reads
+// as zeros.
+func NewWritableMmapStore(path string, size int64) (*MmapStore, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %w", err)
+	}
+
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to stat file: %w", err)
+	}
+	if fi.Size() < size {
+		if err := f.Truncate(size); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("failed to grow file: %w", err)
+		}
+	}
+	size = max(size, fi.Size())
+	if size == 0 {
+		return &MmapStore{file: f, writable: true}, nil
+	}
+
+	data, err := syscall.Mmap(
+		int(f.Fd()),
+		0,
+		int(size),
+		syscall.PROT_READ|syscall.PROT_WRITE,
+		syscall.MAP_SHARED,
+	)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to mmap: %w", err)
+	}
+
+	trackMap(data)
+	return &MmapStore{
+		file:     f,
+		data:     data,
+		writable: true,
+	}, nil
+}
+
+func trackMap(data []byte) {
+	mappedBytes.Add(int64(len(data)))
+	mappings.Add(1)
+}
+
+func trackUnmap(data []byte) {
+	mappedBytes.Add(-int64(len(data)))
+	mappings.Add(-1)
+}
+
+// Sync checks if the file has changed size and remaps it if necessary.
 // Call this periodically or when a lookup fails to find an expected offset.
+// A file that shrunk (truncation) must be remapped too, touching pages past
+// the end of the file raises SIGBUS.
 func (m *MmapStore) Sync() error {
 	stat, err := m.file.Stat()
 	if err != nil {
@@ -70,28 +132,36 @@ func (m *MmapStore) Sync() error {
 
 	currentSize := stat.Size()
 
-	if currentSize <= int64(len(m.data)) {
+	if currentSize == int64(len(m.data)) {
 		return nil
 	}
 
 	// 3. Unmap the old view (if it exists)
+	remaps.Add(1)
 	if len(m.data) > 0 {
 		if err = syscall.Munmap(m.data); err != nil {
 			return fmt.Errorf("munmap failed: %w", err)
 		}
+		trackUnmap(m.data)
+		m.data = nil
+	}
+
+	if currentSize == 0 {
+		return nil
 	}
 
 	data, err := syscall.Mmap(
 		int(m.file.Fd()),
 		0,
 		int(currentSize),
-		syscall.PROT_READ,
+		m.prot(),
 		syscall.MAP_SHARED,
 	)
 	if err != nil {
 		m.data = nil
 		return fmt.Errorf("remap failed, we lost our map. index is now broken: %w", err)
 	}
+	trackMap(data)
 	m.data = data
 
 	return nil
@@ -106,6 +176,7 @@ func (m *MmapStore) Close() error {
 			m.file.Close()
 			return fmt.Errorf("munmap failed: %w", err)
 		}
+		trackUnmap(m.data)
 		m.data = nil
 	}
 
@@ -131,3 +202,58 @@ func (m *MmapStore) ReadAt(offset int, length int) ([]byte, error) {
 func (m *MmapStore) Size() int64 {
 	return int64(len(m.data))
 }
+
+// FileSize returns the size of the file right now, which a writer through
+// another handle may have made smaller than the map. Touching the pages of the
+// map past it raises SIGBUS.
+func (m *MmapStore) FileSize() (int64, error) {
+	stat, err := m.file.Stat()
+	if err != nil {
+		return 0, err
+	}
+	return stat.Size(), nil
+}
+
+// WriteAt copies b into the map at offset. The write lands in the page cache
+// right away, visible to every other mapping of the file, and reaches the disk
+// whenever the kernel writes the page back (or on fsync of the file).
+func (m *MmapStore) WriteAt(offset int, b []byte) error {
+	if !m.writable {
+		return fmt.Errorf("storage is read only")
+	}
+	if offset+len(b) > len(m.data) {
+		return fmt.Errorf("out of bounds: len=%d, req_off=%d, req_len=%d", len(m.data), offset, len(b))
+	}
+	copy(m.data[offset:], b)
+	return nil
+}
+
+// LoadUint64 atomically reads the 8 bytes at offset, which must be 8 byte
+// aligned, as a native endian uint64. Out of bounds reads give 0. Paired with
+// StoreUint64 a reader never sees half of a write, even through another
+// mapping of the same file.
+func (m *MmapStore) LoadUint64(offset int) uint64 {
+	if offset+8 > len(m.data) {
+		return 0
+	}
+	return atomic.LoadUint64((*uint64)(unsafe.Pointer(&m.data[offset])))
+}
+
+// StoreUint64 atomically writes v at offset, which must be 8 byte aligned.
+func (m *MmapStore) StoreUint64(offset int, v uint64) error {
+	if !m.writable {
+		return fmt.Errorf("storage is read only")
+	}
+	if offset+8 > len(m.data) {
+		return fmt.Errorf("out of bounds: len=%d, req_off=%d, req_len=8", len(m.data), offset)
+	}
+	atomic.StoreUint64((*uint64)(unsafe.Pointer(&m.data[offset])), v)
+	return nil
+}
+
+func (m *MmapStore) prot() int {
+	if m.writable {
+		return syscall.PROT_READ | syscall.PROT_WRITE
+	}
+	return syscall.PROT_READ
+}
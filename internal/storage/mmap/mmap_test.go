@@ -1,6 +1,80 @@
 package mmap
 
-import "testing"
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
 
 func TestMmapStoreNew(t *testing.T) {
 }
+
+func TestMmapStore_Stats(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.index")
+	require.NoError(t, os.WriteFile(path, make([]byte, 64), 0o644))
+
+	before := ReadStats()
+
+	m, err := NewMmapStore(path)
+	require.NoError(t, err)
+	require.Equal(t, before.MappedBytes+64, ReadStats().MappedBytes)
+	require.Equal(t, before.Mappings+1, ReadStats().Mappings)
+
+	require.NoError(t, os.WriteFile(path, make([]byte, 128), 0o644))
+	require.NoError(t, m.Sync())
+	require.Equal(t, before.MappedBytes+128, ReadStats().MappedBytes)
+	require.Equal(t, before.Remaps+1, ReadStats().Remaps)
+
+	require.NoError(t, m.Close())
+	require.Equal(t, before.MappedBytes, ReadStats().MappedBytes)
+	require.Equal(t, before.Mappings, ReadStats().Mappings)
+}
+
+func TestMmapStore_Residency(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("mincore residency is only supported on linux")
+	}
+
+	path := filepath.Join(t.TempDir(), "test.index")
+	size := 4 * os.Getpagesize()
+	require.NoError(t, os.WriteFile(path, make([]byte, size), 0o644))
+
+	m, err := NewMmapStore(path)
+	require.NoError(t, err)
+	defer m.Close()
+
+	r, err := m.Residency()
+	require.NoError(t, err)
+	require.Equal(t, int64(4), r.TotalPages)
+	// Just written, so the pages are still in the page cache
+	require.Positive(t, r.ResidentPages)
+
+	r, err = FileResidency(path)
+	require.NoError(t, err)
+	require.Equal(t, int64(4), r.TotalPages)
+}
+
+func TestMmapStore_Writable(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.index")
+
+	m, err := NewWritableMmapStore(path, 64)
+	require.NoError(t, err)
+	require.Equal(t, int64(64), m.Size())
+	require.NoError(t, m.WriteAt(8, []byte("entry")))
+	require.Error(t, m.WriteAt(60, []byte("entry")))
+
+	// Visible through the file without a flush
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Len(t, data, 64)
+	require.Equal(t, "entry", string(data[8:13]))
+	require.NoError(t, m.Close())
+
+	readOnly, err := NewMmapStore(path)
+	require.NoError(t, err)
+	defer readOnly.Close()
+	require.Error(t, readOnly.WriteAt(0, []byte("x")))
+}
@@ -0,0 +1,66 @@
+package mmap
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// Residency reports how many pages of the store's mapping are currently in
+// the page cache.
+func (m *MmapStore) Residency() (Residency, error) {
+	return residency(m.data)
+}
+
+// FileResidency maps the file at path just long enough to ask the kernel
+// which of its pages are in the page cache. It doesn't fault any page in.
+func FileResidency(path string) (Residency, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return Residency{}, fmt.Errorf("failed to open file: %w", err)
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return Residency{}, fmt.Errorf("failed to stat file: %w", err)
+	}
+	if fi.Size() == 0 {
+		return Residency{}, nil
+	}
+
+	data, err := syscall.Mmap(int(f.Fd()), 0, int(fi.Size()), syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		return Residency{}, fmt.Errorf("failed to mmap: %w", err)
+	}
+	defer syscall.Munmap(data)
+
+	return residency(data)
+}
+
+func residency(data []byte) (Residency, error) {
+	if len(data) == 0 {
+		return Residency{}, nil
+	}
+
+	pageSize := os.Getpagesize()
+	vec := make([]byte, (len(data)+pageSize-1)/pageSize)
+
+	_, _, errno := syscall.Syscall(
+		syscall.SYS_MINCORE,
+		uintptr(unsafe.Pointer(&data[0])),
+		uintptr(len(data)),
+		uintptr(unsafe.Pointer(&vec[0])),
+	)
+	if errno != 0 {
+		return Residency{}, fmt.Errorf("mincore failed: %w", errno)
+	}
+
+	r := Residency{TotalPages: int64(len(vec))}
+	for _, v := range vec {
+		// Only the least significant bit is defined, the rest is reserved
+		r.ResidentPages += int64(v & 1)
+	}
+	return r, nil
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+
+package mmap
+
+import "errors"
+
+// Residency needs mincore(2), which is only wired up on Linux.
+func (m *MmapStore) Residency() (Residency, error) {
+	return Residency{}, errors.ErrUnsupported
+}
+
+func FileResidency(path string) (Residency, error) {
+	return Residency{}, errors.ErrUnsupported
+}
@@ -0,0 +1,34 @@
+package mmap
+
+import "sync/atomic"
+
+// Process wide counters over every MmapStore, read by the metrics collector.
+var (
+	mappedBytes atomic.Int64
+	mappings    atomic.Int64
+	remaps      atomic.Int64
+)
+
+type Stats struct {
+	// MappedBytes is the total size of all live mappings.
+	MappedBytes int64
+	// Mappings is the number of live mappings.
+	Mappings int64
+	// Remaps counts how many times a store had to be remapped because its
+	// file changed size.
+	Remaps int64
+}
+
+func ReadStats() Stats {
+	return Stats{
+		MappedBytes: mappedBytes.Load(),
+		Mappings:    mappings.Load(),
+		Remaps:      remaps.Load(),
+	}
+}
+
+// Residency describes how much of a file (or mapping) is in the page cache.
+type Residency struct {
+	ResidentPages int64
+	TotalPages    int64
+}
@@ -0,0 +1,237 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"sort"
+	"time"
+)
+
+// readBudget is what is left of the payload bytes a read of many records may
+// return. The first record is read whatever its size, so that a record
+// larger than the budget can still be read on its own.
+type readBudget struct {
+	left int64
+	read int
+	full bool
+	// now leaves out the records expired by then, unless zero
+	now time.Time
+}
+
+// take spends size bytes on a record, or reports false, and that the budget
+// is full, if it doesn't fit.
+func (b *readBudget) take(size int64) bool {
+	if b.full {
+		return false
+	}
+	if b.read > 0 && size > b.left {
+		b.full = true
+		return false
+	}
+	b.left -= size
+	b.read++
+	return true
+}
+
+// FindRecords returns the records at offsets, keyed by offset, in a single
+// pass over the log: the writer is flushed once, the offsets are looked up
+// in order, and the records close to each other are read in one sequential
+// scan, the index only skipping ahead to the ones further away. Offsets the
+// log holds no record at are left out. Once the payloads found take maxBytes,
+// the record that doesn't fit and the ones after it are left out too, but
+// the first record found is returned whatever its size.
+func (l *Log) FindRecords(offsets []int64, maxBytes int64) (map[int64]Record, error) {
+	return l.findRecords(offsets, &readBudget{left: maxBytes})
+}
+
+func (l *Log) findRecords(offsets []int64, budget *readBudget) (map[int64]Record, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	targets := slices.Clone(offsets)
+	slices.Sort(targets)
+	targets = slices.Compact(targets)
+
+	if err := l.flushFunc(); err != nil {
+		return nil, fmt.Errorf("failed to flush writer in FindRecords: %w", err)
+	}
+
+	records := make(map[int64]Record, len(targets))
+	pos := int64(-1) // where the scan is, the start of a record or of the end of the log
+	for _, target := range targets {
+		relative := target - l.baseOffset
+		if relative < 0 || relative > math.MaxUint32 {
+			continue
+		}
+		start, err := l.startOf(uint32(relative))
+		if err != nil {
+			return nil, err
+		}
+		// Scanning on from the last record beats going back to the index
+		pos = max(pos, start)
+
+		var loadErr error
+		err = l.scanFlushed(pos, func(h RecordHeader, payloadPos int64) bool {
+			if h.LogicalOffset < uint64(relative) {
+				return false
+			}
+			pos = payloadPos - HeaderSize
+			if h.LogicalOffset > uint64(relative) {
+				// Not in the log, the record found may be the next target
+				return true
+			}
+			if !budget.now.IsZero() && h.Expired(budget.now) {
+				// Costs nothing, the next record may be the first to return
+				pos = payloadPos + int64(h.PayloadSize)
+				return true
+			}
+			if !budget.take(int64(h.PayloadSize)) {
+				// Left on disk
+				return true
+			}
+
+			payload, err := l.loadPayload(nil, payloadPos, int64(h.PayloadSize))
+			if err != nil {
+				loadErr = err
+				return true
+			}
+			if err := h.verify(payload); err != nil {
+				loadErr = fmt.Errorf("record %d: %w", target, err)
+				return true
+			}
+			records[target] = Record{Header: h, Payload: payload}
+			pos = payloadPos + int64(h.PayloadSize)
+			return true
+		})
+		if loadErr != nil {
+			return nil, fmt.Errorf("load err: %w", loadErr)
+		}
+		if errors.Is(err, ErrRecordNotFoundFullScan) {
+			// Past the end of the log, and so are the offsets after it
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failure in scanFrom: %w", err)
+		}
+		if budget.full {
+			break
+		}
+	}
+	return records, nil
+}
+
+// FindRecords returns the records at offsets, keyed by offset, like Read
+// would one by one but reading each segment they fall in only once, see
+// Log.FindRecords. Offsets the partition holds no record at, out of range,
+// compacted away or expired, are left out. Records in tiered storage are
+// still fetched one at a time. Like Log.FindRecords it stops before the
+// record whose payload doesn't fit in maxBytes, past the first.
+func (p *Partition) FindRecords(ctx context.Context, offsets []int, maxBytes int64) (map[int]Record, error) {
+	targets := slices.Clone(offsets)
+	slices.Sort(targets)
+	targets = slices.Compact(targets)
+	budget := &readBudget{left: maxBytes, now: time.Now()}
+
+	// The tiered offsets come first, and their reads check expiry and
+	// decrypt already
+	records := make(map[int]Record, len(targets))
+	tiered := p.tieredTargets(targets)
+	for _, offset := range tiered {
+		var record Record
+		var err error
+		if budget.read == 0 {
+			record, err = p.ReadContext(ctx, offset)
+		} else {
+			record, err = p.ReadWithin(ctx, offset, budget.left)
+		}
+		if errors.Is(err, ErrRecordOverLimit) {
+			return records, nil
+		}
+		if errors.Is(err, ErrRecordNotFoundFullScan) || errors.Is(err, ErrRecordExpired) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		budget.take(int64(len(record.Payload)))
+		records[offset] = record
+	}
+	if budget.full {
+		return records, nil
+	}
+
+	local, err := p.findLocalRecords(targets[len(tiered):], budget)
+	if err != nil {
+		return nil, err
+	}
+	for offset, record := range local {
+		if p.encryptor != nil {
+			if record.Payload, err = p.encryptor.open(record.Payload, offset); err != nil {
+				return nil, fmt.Errorf("offset %d: %w", offset, err)
+			}
+		}
+		records[offset] = record
+	}
+	return records, nil
+}
+
+// tieredTargets returns the targets, sorted, below the local segments, to
+// read from tiered storage.
+func (p *Partition) tieredTargets(targets []int) []int {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if p.closed || p.tiering == nil {
+		return nil
+	}
+	return targets[:sort.SearchInts(targets, p.segments[0].BaseOffset)]
+}
+
+// findLocalRecords returns the records at targets, sorted, held in local
+// segments, within budget.
+func (p *Partition) findLocalRecords(targets []int, budget *readBudget) (map[int]Record, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if p.closed {
+		return nil, ErrPartitionClosed
+	}
+	records := make(map[int]Record, len(targets))
+	// Moved to tiered storage since, or gone
+	targets = targets[sort.SearchInts(targets, p.segments[0].BaseOffset):]
+
+	for i, segment := range p.segments {
+		end := len(targets)
+		if i+1 < len(p.segments) {
+			end = sort.SearchInts(targets, p.segments[i+1].BaseOffset)
+		}
+		if end == 0 {
+			continue
+		}
+		offsets := make([]int64, end)
+		for j, offset := range targets[:end] {
+			offsets[j] = int64(offset)
+		}
+		targets = targets[end:]
+
+		l, done, err := p.openSegment(segment)
+		if err != nil {
+			return nil, err
+		}
+		found, err := l.findRecords(offsets, budget)
+		done()
+		if err != nil {
+			return nil, err
+		}
+		for offset, record := range found {
+			records[int(offset)] = record
+		}
+		if len(targets) == 0 || budget.full {
+			break
+		}
+	}
+	return records, nil
+}
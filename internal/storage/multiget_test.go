@@ -0,0 +1,81 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"math"
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPartition_FindRecords(t *testing.T) {
+	config := DefaultPartitionConfig()
+	config.MaxSegmentRecords = 1200
+	p, err := NewPartitionWithConfig(t.TempDir(), config)
+	require.NoError(t, err)
+	defer p.Close()
+
+	ctx := context.Background()
+	// Three segments, the last one active
+	for i := range 3000 {
+		require.NoError(t, p.Append(fmt.Appendf(nil, "data %d", i)))
+	}
+
+	t.Run("across segments", func(t *testing.T) {
+		offsets := []int{2999, 5, 1200, 6, 5, 1199, 2400, 3}
+		records, err := p.FindRecords(ctx, offsets, math.MaxInt64)
+		require.NoError(t, err)
+		require.Len(t, records, 7)
+		for _, offset := range offsets {
+			want, err := p.Read(offset)
+			require.NoError(t, err)
+			require.Equal(t, want, records[offset])
+		}
+	})
+
+	t.Run("byte budget", func(t *testing.T) {
+		offsets := []int{2999, 1200, 6, 5}
+		keys := func(records map[int]Record) []int {
+			return slices.Sorted(maps.Keys(records))
+		}
+		// "data 5" and "data 6" take 12 bytes, "data 1200" 9 more
+		records, err := p.FindRecords(ctx, offsets, 12)
+		require.NoError(t, err)
+		require.Equal(t, []int{5, 6}, keys(records))
+		records, err = p.FindRecords(ctx, offsets, 21)
+		require.NoError(t, err)
+		require.Equal(t, []int{5, 6, 1200}, keys(records), "the budget spans segments")
+		records, err = p.FindRecords(ctx, offsets, 0)
+		require.NoError(t, err)
+		require.Equal(t, []int{5}, keys(records), "the first record is always returned")
+	})
+
+	t.Run("offsets out of range are left out", func(t *testing.T) {
+		records, err := p.FindRecords(ctx, []int{-1, 10, 3000, 5000}, math.MaxInt64)
+		require.NoError(t, err)
+		require.Len(t, records, 1)
+		require.Equal(t, []byte("data 10"), records[10].Payload)
+
+		require.NoError(t, p.TruncateBefore(1500))
+		records, err = p.FindRecords(ctx, []int{10, 1499, 1500, 2999}, math.MaxInt64)
+		require.NoError(t, err)
+		require.Len(t, records, 2)
+		require.Equal(t, []byte("data 1500"), records[1500].Payload)
+		require.Equal(t, []byte("data 2999"), records[2999].Payload)
+	})
+
+	t.Run("no offsets", func(t *testing.T) {
+		records, err := p.FindRecords(ctx, nil, math.MaxInt64)
+		require.NoError(t, err)
+		require.Empty(t, records)
+	})
+
+	t.Run("closed", func(t *testing.T) {
+		require.NoError(t, p.Close())
+		_, err := p.FindRecords(ctx, []int{2000}, math.MaxInt64)
+		require.ErrorIs(t, err, ErrPartitionClosed)
+	})
+}
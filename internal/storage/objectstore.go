@@ -0,0 +1,206 @@
+package storage
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// ObjectStore is the part of an object store (S3, GCS, ...) that tiered
+// storage needs. Keys use "/" as separator.
+type ObjectStore interface {
+	Put(key string, r io.Reader, size int64) error
+	// GetRange returns n bytes of the object starting at off (a ranged GET).
+	GetRange(key string, off int64, n int64) ([]byte, error)
+	Delete(key string) error
+}
+
+// DirObjectStore is an ObjectStore backed by a local directory, for tests and
+// for tiering onto a network mount.
+type DirObjectStore struct {
+	root string
+}
+
+func NewDirObjectStore(root string) (*DirObjectStore, error) {
+	if err := os.MkdirAll(root, 0o755); err != nil {
+		return nil, err
+	}
+	return &DirObjectStore{root: root}, nil
+}
+
+func (s *DirObjectStore) path(key string) (string, error) {
+	rel := filepath.FromSlash(key)
+	if !filepath.IsLocal(rel) {
+		return "", fmt.Errorf("invalid object key %q", key)
+	}
+	return filepath.Join(s.root, rel), nil
+}
+
+func (s *DirObjectStore) Put(key string, r io.Reader, size int64) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+
+	data, err := io.ReadAll(io.LimitReader(r, size))
+	if err != nil {
+		return err
+	}
+	if int64(len(data)) != size {
+		return fmt.Errorf("object %s: expected %d bytes, got %d", key, size, len(data))
+	}
+	return WriteFileAtomic(filepath.Dir(path), filepath.Base(path), data)
+}
+
+func (s *DirObjectStore) GetRange(key string, off int64, n int64) ([]byte, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	buf := make([]byte, n)
+	read, err := f.ReadAt(buf, off)
+	if err != nil && !(errors.Is(err, io.EOF) && int64(read) == n) {
+		return nil, err
+	}
+	return buf, nil
+}
+
+func (s *DirObjectStore) Delete(key string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// blockCache keeps recently fetched blocks of remote objects in memory, least
+// recently used blocks are evicted once the cache is over capacity.
+type blockCache struct {
+	mu       sync.Mutex
+	capacity int64
+	size     int64
+	lru      *list.List // front is most recently used
+	items    map[blockKey]*list.Element
+}
+
+type blockKey struct {
+	object string
+	block  int64
+}
+
+type cachedBlock struct {
+	key  blockKey
+	data []byte
+}
+
+func newBlockCache(capacity int64) *blockCache {
+	return &blockCache{
+		capacity: capacity,
+		lru:      list.New(),
+		items:    make(map[blockKey]*list.Element),
+	}
+}
+
+func (c *blockCache) get(key blockKey) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	c.lru.MoveToFront(el)
+	return el.Value.(*cachedBlock).data, true
+}
+
+func (c *blockCache) put(key blockKey, data []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.items[key]; ok {
+		return
+	}
+	c.items[key] = c.lru.PushFront(&cachedBlock{key: key, data: data})
+	c.size += int64(len(data))
+
+	for c.size > c.capacity && c.lru.Len() > 0 {
+		oldest := c.lru.Back()
+		block := c.lru.Remove(oldest).(*cachedBlock)
+		delete(c.items, block.key)
+		c.size -= int64(len(block.data))
+	}
+}
+
+// remoteReaderAt reads an object with ranged GETs of whole blocks, going
+// through the block cache.
+type remoteReaderAt struct {
+	ctx       context.Context // no more blocks are fetched once it is done
+	store     ObjectStore
+	key       string
+	size      int64
+	blockSize int64
+	cache     *blockCache
+}
+
+func (r *remoteReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if off >= r.size {
+		return 0, io.EOF
+	}
+
+	n := 0
+	for n < len(p) && off < r.size {
+		block := off / r.blockSize
+		data, err := r.block(block)
+		if err != nil {
+			return n, err
+		}
+
+		copied := copy(p[n:], data[off-block*r.blockSize:])
+		n += copied
+		off += int64(copied)
+	}
+
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (r *remoteReaderAt) block(block int64) ([]byte, error) {
+	key := blockKey{object: r.key, block: block}
+	if data, ok := r.cache.get(key); ok {
+		return data, nil
+	}
+
+	if err := r.ctx.Err(); err != nil {
+		return nil, err
+	}
+	start := block * r.blockSize
+	data, err := r.store.GetRange(r.key, start, min(r.blockSize, r.size-start))
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch %s block %d: %w", r.key, block, err)
+	}
+	r.cache.put(key, data)
+	return data, nil
+}
+
+var _ io.ReaderAt = (*remoteReaderAt)(nil)
@@ -1,16 +1,25 @@
 package storage
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"log/slog"
+	"math"
 	"os"
 	"path/filepath"
 	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
+var ErrSegmentGap = errors.New("gap between partition segments")
+
 type logName string
 
 func newLogNameFromInt(number int) logName {
@@ -50,85 +59,404 @@ type Segment struct {
 	Path       string
 }
 
+// PartitionConfig controls when the active segment of a partition is rolled
+// over into a new one. A segment is rotated as soon as any of the limits is hit.
+type PartitionConfig struct {
+	// MaxSegmentBytes caps the size of a segment file. Positions in the index
+	// are 32 bit, so it can't be larger than math.MaxUint32. A single record
+	// bigger than the limit still gets a segment of its own.
+	MaxSegmentBytes int64
+	// MaxSegmentRecords caps the number of records in a segment.
+	MaxSegmentRecords int64
+	// MaxSegmentAge caps how long a segment stays active.
+	MaxSegmentAge time.Duration
+	// MetaDir is where the partition keeps its metadata (the checkpoint).
+	// Defaults to the partition directory.
+	MetaDir string
+	// BatchWindow is how long the append pipeline waits for more records
+	// after the first one of a batch arrives. Everything that arrives within
+	// the window is written and flushed/fsynced once. Each Append still waits
+	// for its own record to be written. With zero the pipeline only batches
+	// appends that are already waiting.
+	BatchWindow time.Duration
+	// Mode decides what happens to questionable data found on open, see
+	// OpenMode. Defaults to OpenNormal.
+	Mode OpenMode
+	// FDBudget caps the file descriptors the partition holds, together with
+	// the other partitions sharing it. Defaults to DefaultFDBudget.
+	FDBudget *FDBudget
+	// MaxRecordBytes caps the size of a payload. Larger appends fail with a
+	// RecordTooLargeError, and a stored record claiming to be larger is taken
+	// for corruption instead of being read, so lowering it makes the records
+	// above it unreadable. Defaults to DefaultMaxRecordBytes.
+	MaxRecordBytes int64
+	// Keyring, when set, has every payload appended from then on encrypted
+	// with AES-GCM under its current key, and decrypted again when read.
+	// Payloads written before are read as they are, so encryption can be
+	// turned on for an existing partition; the offset it was turned on at is
+	// kept, and a record after it that isn't encrypted fails to read with
+	// ErrDecryptionFailed. Segments, snapshots and tiered objects only ever
+	// hold the encrypted payloads. A partition with encrypted records can only
+	// be opened read only without its keyring.
+	Keyring Keyring
+	// PreallocateSegments reserves MaxSegmentBytes of disk for every active
+	// segment when it is opened, so that its blocks are allocated together
+	// rather than scattered as it grows. The file size doesn't change, and
+	// whatever the segment doesn't use is given back when it is closed.
+	// Linux only, ignored elsewhere.
+	PreallocateSegments bool
+	// Durability is how eagerly the active segment persists appends, see
+	// DurabilityMode. Defaults to DurabilityMedium. With DurabilityFull every
+	// batch of appends is fsynced before it returns, and its records are
+	// durable (see OnDurable) right away.
+	Durability DurabilityMode
+	// Logger receives rotation, recovery, truncation and deletion events.
+	// Defaults to slog.Default().
+	Logger *slog.Logger
+}
+
+func DefaultPartitionConfig() PartitionConfig {
+	return PartitionConfig{
+		MaxSegmentBytes:   1 << 30, // 1GiB
+		MaxSegmentRecords: 10000,   // TODO: think about this
+		MaxSegmentAge:     24 * time.Hour,
+	}
+}
+
+// Validate returns why c can't configure a partition, nil if it can.
+func (c PartitionConfig) Validate() error {
+	if c.MaxSegmentBytes <= 0 || c.MaxSegmentBytes > math.MaxUint32 {
+		return fmt.Errorf("max segment bytes must be in (0, %d], got %d", uint32(math.MaxUint32), c.MaxSegmentBytes)
+	}
+	if c.MaxSegmentRecords <= 0 {
+		return errors.New("max segment records must be positive")
+	}
+	if c.MaxSegmentAge <= 0 {
+		return errors.New("max segment age must be positive")
+	}
+	if c.BatchWindow < 0 {
+		return errors.New("batch window can't be negative")
+	}
+	if c.MaxRecordBytes < 0 || c.MaxRecordBytes > math.MaxUint32-HeaderSize {
+		return fmt.Errorf("max record bytes must be in [0, %d], got %d", uint32(math.MaxUint32-HeaderSize), c.MaxRecordBytes)
+	}
+	if c.Mode < OpenNormal || c.Mode > OpenForce {
+		return fmt.Errorf("unknown open mode %d", c.Mode)
+	}
+	if c.Durability != 0 && (c.Durability < DurabilityAsync || c.Durability > DurabilityFull) {
+		return fmt.Errorf("unknown durability %s", c.Durability)
+	}
+	return nil
+}
+
+// maxRecordBytes is MaxRecordBytes with the default filled in.
+func (c PartitionConfig) maxRecordBytes() int64 {
+	if c.MaxRecordBytes == 0 {
+		return DefaultMaxRecordBytes
+	}
+	return c.MaxRecordBytes
+}
+
+// newLog opens a writable segment as durable as c says.
+func (c PartitionConfig) newLog(path string, baseOffset int, knownNextOffset int64, logger *slog.Logger) (*Log, error) {
+	switch c.Durability {
+	case DurabilityAsync:
+		return newLog(path, baseOffset, 4096*2, false, false, knownNextOffset, logger)
+	case DurabilityFull:
+		return newLog(path, baseOffset, 4096, true, true, knownNextOffset, logger)
+	default:
+		return newLog(path, baseOffset, 4096, true, false, knownNextOffset, logger)
+	}
+}
+
 type Partition struct {
+	// writerMu serializes everything that writes to the active segment and is
+	// taken before mu, so that AppendFrom can stream a payload in without
+	// holding up readers.
+	writerMu      sync.Mutex
 	mu            sync.RWMutex
+	closed        bool
 	dir           string
+	metaDir       string
+	lock          *os.File // holds the lock on dir while open for writing, see lockDir
+	config        PartitionConfig
 	segments      []Segment
 	activeLog     *Log
 	activeLogName logName
 	nextOffset    int
+	recovery      RecoveryReport
+	logger        *slog.Logger
+
+	fds       *FDBudget
+	readersMu sync.Mutex
+	readers   map[int]*segmentReader // cached sealed segments by base offset
+
+	durableOffset atomic.Int64 // every offset below it has been fsynced; written under mu
+	hooksMu       sync.Mutex
+	hooks         []durableHook // sorted by offset
+
+	pipeline  *appendPipeline
+	tiering   *TieringManager // nil unless tiered storage is attached
+	encryptor *encryptor      // nil unless payloads are encrypted
+
+	appendedMu sync.Mutex
+	appended   chan struct{} // closed when records are appended, nil until a subscription waits
+
+	fenced atomic.Pointer[FencedError] // set once a write runs out of disk space
 }
 
 func NewPartition(dir string) (*Partition, error) {
-	if err := os.MkdirAll(dir, 0o755); err != nil {
-		return nil, err
+	return NewPartitionWithConfig(dir, DefaultPartitionConfig())
+}
+
+func NewPartitionWithConfig(dir string, config PartitionConfig) (p *Partition, err error) {
+	if err := config.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid partition config: %w", err)
 	}
-	logs, err := os.ReadDir(dir)
+	metaDir := config.MetaDir
+	if metaDir == "" {
+		metaDir = dir
+	}
+	logger := config.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	logger = logger.With("partition", dir)
+
+	readOnly := config.Mode == OpenReadOnly
+	var lock *os.File
+	if readOnly {
+		if err := ensureDir(dir); err != nil {
+			return nil, fmt.Errorf("partition directory: %w", err)
+		}
+	} else {
+		if err := ensureWritableDir(dir); err != nil {
+			return nil, fmt.Errorf("partition directory: %w", err)
+		}
+		if err := ensureWritableDir(metaDir); err != nil {
+			return nil, fmt.Errorf("partition metadata directory: %w", err)
+		}
+		// Before anything is read, let alone recovered: another writer
+		// could be appending to the segments
+		if lock, err = lockDir(dir); err != nil {
+			return nil, err
+		}
+		defer func() {
+			if err != nil {
+				unlockDir(lock)
+			}
+		}()
+	}
+	segments, err := listSegments(dir)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read directory: %w", err)
+		return nil, err
 	}
 
 	var activeLogName logName
-	segments := make([]Segment, 0)
-
-	if len(logs) == 0 {
+	if len(segments) == 0 {
+		if readOnly {
+			return nil, fmt.Errorf("partition %s has no segments to open read only", dir)
+		}
 		activeLogName = newLogNameFromInt(0)
 		segments = append(segments, Segment{
 			BaseOffset: activeLogName.toInt(),
 			Path:       filepath.Join(dir, activeLogName.string()),
 		})
 	} else {
-		logNames := make([]logName, 0)
-		for _, entry := range logs {
-			if !(strings.HasSuffix(entry.Name(), ".log")) {
-				continue
-			}
+		activeLogName = newLogNameFromInt(segments[len(segments)-1].BaseOffset)
+	}
 
-			ln := newLogNameFromString(entry.Name())
+	baseOffsetForActiveLog := activeLogName.toInt()
+
+	report := RecoveryReport{Mode: config.Mode}
 
-			logNames = append(logNames, ln)
-			segments = append(segments, Segment{
-				BaseOffset: ln.toInt(),
-				Path:       filepath.Join(dir, ln.string()),
-			})
+	// After a clean shutdown the checkpoint says where the partition ends, so
+	// the recovery scan can be skipped.
+	readCp := consumeCheckpoint
+	if readOnly {
+		readCp = readCheckpoint
+	}
+	cp, found, err := readCp(metaDir)
+	if err != nil {
+		return nil, err
+	}
+	knownNextOffset := int64(-1)
+	if found && cp.matches(segments) {
+		report.CleanShutdown = true
+		knownNextOffset = int64(cp.NextOffset - baseOffsetForActiveLog)
+	} else {
+		if found {
+			logger.Info("checkpoint doesn't match the segments on disk, recovering")
 		}
+		if config.Mode != OpenForce {
+			if err := checkSegments(segments, config.Mode, &report, logger); err != nil {
+				return nil, err
+			}
+		}
+	}
 
-		activeLogName = logNames[len(logNames)-1]
+	fds := config.FDBudget
+	if fds == nil {
+		fds = DefaultFDBudget
+	}
+	// The active log holds on to its descriptors until the partition is closed
+	if err := fds.reserve(logFDs); err != nil {
+		return nil, err
 	}
 
-	baseOffsetForActiveLog := activeLogName.toInt()
-	activeLog, err := NewLogMediumDurable(filepath.Join(dir, activeLogName.string()), baseOffsetForActiveLog)
+	activeLogPath := filepath.Join(dir, activeLogName.string())
+	var activeLog *Log
+	if readOnly {
+		activeLog, err = NewLogReadOnly(activeLogPath, baseOffsetForActiveLog)
+	} else {
+		activeLog, err = config.newLog(activeLogPath, baseOffsetForActiveLog, knownNextOffset, logger)
+	}
 	if err != nil {
+		fds.release(logFDs)
 		return nil, err
 	}
+	activeLog.maxRecordSize = config.maxRecordBytes()
+	if !readOnly {
+		preallocateSegment(activeLog, config, logger)
+	}
+	report.TornTailBytes = activeLog.tornBytes
 
 	nextOffset := baseOffsetForActiveLog + int(activeLog.NextOffset())
 
-	p := &Partition{
+	encryption, encrypted, err := readEncryptionState(metaDir)
+	if err == nil && encrypted && config.Keyring == nil && !readOnly {
+		err = fmt.Errorf("partition %s holds encrypted records from offset %d on, it needs a keyring", dir, encryption.StartOffset)
+	}
+	if err != nil {
+		activeLog.Close()
+		fds.release(logFDs)
+		return nil, err
+	}
+	encryptor := newEncryptor(config.Keyring)
+	if encryptor != nil && encrypted {
+		encryptor.begin(encryption)
+	}
+
+	p = &Partition{
 		dir:           dir,
+		metaDir:       metaDir,
+		lock:          lock,
+		config:        config,
 		activeLog:     activeLog,
 		nextOffset:    nextOffset,
 		activeLogName: activeLogName,
 		segments:      segments,
+		recovery:      report,
+		fds:           fds,
+		readers:       make(map[int]*segmentReader),
+		logger:        logger,
+		encryptor:     encryptor,
 	}
+	logger.Info("opened partition",
+		"mode", report.Mode,
+		"segments", len(segments),
+		"next_offset", nextOffset,
+		"clean_shutdown", report.CleanShutdown,
+		"torn_tail_bytes", report.TornTailBytes,
+		"overlaps", len(report.Overlaps),
+		"gaps", len(report.Gaps),
+		"scanned_segments", report.ScannedSegments,
+	)
+	// Whatever survived until now is on disk
+	p.durableOffset.Store(int64(nextOffset))
+	p.pipeline = newAppendPipeline(p)
 	return p, nil
 }
 
-func (p *Partition) rotate() error {
-	if time.Since(p.activeLog.createdAt) > 24*time.Hour ||
-		p.activeLog.NextOffset() >= 10000 { // TODO: think about this
-		err := p.activeLog.Close()
+// listSegments returns the segments in dir, ordered by base offset.
+func listSegments(dir string) ([]Segment, error) {
+	logs, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read directory: %w", err)
+	}
+
+	segments := make([]Segment, 0)
+	for _, entry := range logs {
+		if !(strings.HasSuffix(entry.Name(), ".log")) {
+			continue
+		}
+
+		ln := newLogNameFromString(entry.Name())
+		segments = append(segments, Segment{
+			BaseOffset: ln.toInt(),
+			Path:       filepath.Join(dir, ln.string()),
+		})
+	}
+	return segments, nil
+}
+
+// shouldRotate reports whether the active segment has to be rolled before a
+// record of recordSize bytes (header included) is appended to it.
+func (p *Partition) shouldRotate(recordSize int64) bool {
+	if time.Since(p.activeLog.createdAt) > p.config.MaxSegmentAge {
+		return true
+	}
+	if p.activeLog.NextOffset() >= p.config.MaxSegmentRecords {
+		return true
+	}
+
+	size := p.activeLog.Size()
+	return size > SegmentHeaderSize && size+recordSize > p.config.MaxSegmentBytes
+}
+
+// openLog opens a writable segment of the partition.
+func (p *Partition) openLog(path string, baseOffset int) (*Log, error) {
+	l, err := p.config.newLog(path, baseOffset, -1, p.logger)
+	if err != nil {
+		return nil, err
+	}
+	l.maxRecordSize = p.config.maxRecordBytes()
+	preallocateSegment(l, p.config, p.logger)
+	return l, nil
+}
+
+// preallocateSegment reserves disk for an active segment if config asks for
+// it. Preallocating only saves fragmentation, a segment it fails for is used
+// as it is.
+func preallocateSegment(l *Log, config PartitionConfig, logger *slog.Logger) {
+	if !config.PreallocateSegments {
+		return
+	}
+	if err := l.preallocate(config.MaxSegmentBytes); err != nil && !errors.Is(err, errors.ErrUnsupported) {
+		logger.Warn("failed to preallocate segment", "error", err)
+	}
+}
+
+func (p *Partition) rotate(recordSize int64) error {
+	if p.shouldRotate(recordSize) {
+		sealing := p.activeLog
+		lastPosition, err := sealing.lastRecordStart()
+		if err != nil {
+			return fmt.Errorf("error while finding the last record of active log: %w", err)
+		}
+		maxTimestamp, err := sealing.MaxTimestamp()
+		if err != nil {
+			return fmt.Errorf("error while finding the newest record of active log: %w", err)
+		}
+		err = sealing.Close()
 		if err != nil {
 			return fmt.Errorf("error while closing active log: %w", err)
 		}
+		// Closing fsynced the records and the index. Without its seal the
+		// segment is only scanned when opened, so rotating goes on
+		if err := sealSegment(sealing.path, sealing.nextOffset, lastPosition, maxTimestamp); err != nil {
+			p.logger.Warn("failed to seal segment", "segment", sealing.path, "error", err)
+		}
+		p.durableOffset.Store(int64(p.nextOffset))
 		p.activeLogName = newLogNameFromInt(p.nextOffset)
 		baseOffsetForActiveLog := p.activeLogName.toInt()
 		newLogPath := filepath.Join(p.dir, p.activeLogName.string())
 
-		p.activeLog, err = NewLogMediumDurable(newLogPath, baseOffsetForActiveLog)
+		p.activeLog, err = p.openLog(newLogPath, baseOffsetForActiveLog)
 		if err != nil {
 			return fmt.Errorf("error while createing new active log: %w", err)
 		}
+		p.logger.Info("rotated segment", "base_offset", baseOffsetForActiveLog)
 		p.segments = append(p.segments, Segment{
 			BaseOffset: baseOffsetForActiveLog,
 			Path:       newLogPath,
@@ -137,26 +465,449 @@ func (p *Partition) rotate() error {
 	return nil
 }
 
+// Append adds a record to the partition. It is safe to call from many
+// goroutines at once: the records are handed to the partition's append
+// pipeline, which writes concurrent appends together as one batch.
 func (p *Partition) Append(data []byte) error {
-	p.mu.Lock()
-	defer p.mu.Unlock()
+	return p.AppendContext(context.Background(), data)
+}
+
+// AppendContext is Append that stops waiting once ctx is done, returning its
+// error. The record may still be appended then: it is only left out if ctx is
+// done before the pipeline picks it up.
+func (p *Partition) AppendContext(ctx context.Context, data []byte) error {
+	data, err := p.preparePayload(data)
+	if err != nil {
+		return err
+	}
+	return p.pipeline.append(ctx, data, 0, time.Time{})
+}
+
+// AppendAt is AppendContext for a record stamped with timestamp instead of
+// the time it is appended, like the time its producer created it. Timestamps
+// needn't go up with offsets then. A zero timestamp stamps the record with
+// the time it is appended.
+func (p *Partition) AppendAt(ctx context.Context, data []byte, timestamp time.Time) error {
+	if !timestamp.IsZero() && timestamp.Before(time.Unix(0, 0)) {
+		return fmt.Errorf("timestamp %s is before the Unix epoch", timestamp)
+	}
+	data, err := p.preparePayload(data)
+	if err != nil {
+		return err
+	}
+	return p.pipeline.append(ctx, data, 0, timestamp)
+}
+
+// AppendWithTTL is Append for a record that must not be delivered once ttl
+// has passed: reads of it fail with ErrRecordExpired. A ttl of 0 means the
+// record never expires.
+func (p *Partition) AppendWithTTL(data []byte, ttl time.Duration) error {
+	if ttl < 0 {
+		return fmt.Errorf("ttl can't be negative, got %s", ttl)
+	}
+	data, err := p.preparePayload(data)
+	if err != nil {
+		return err
+	}
+	return p.pipeline.append(context.Background(), data, ttl, time.Time{})
+}
+
+// preparePayload checks the size data takes stored, encrypted if the
+// partition has a keyring. It is sealed by the writer, once its offset is
+// known.
+func (p *Partition) preparePayload(data []byte) ([]byte, error) {
+	size := int64(len(data))
+	if p.encryptor != nil {
+		overhead, err := p.encryptor.overhead()
+		if err != nil {
+			return nil, err
+		}
+		size += overhead
+	}
+	if err := p.checkRecordSize(size); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
 
-	err := p.rotate()
+// checkRecordSize refuses a payload over the limit before it joins a batch,
+// where it would fail the appends batched with it.
+func (p *Partition) checkRecordSize(size int64) error {
+	if limit := p.config.maxRecordBytes(); size > limit {
+		return &RecordTooLargeError{Size: size, Limit: limit}
+	}
+	return nil
+}
+
+// AppendFrom adds a record whose payload is streamed from r, such as the body
+// of an upload. With n >= 0 exactly n bytes are read, with n < 0 r is read
+// until EOF. It returns the offset of the record. The payload goes straight to
+// the active segment instead of through the append pipeline; reads carry on
+// while it streams in, other appends wait for it.
+func (p *Partition) AppendFrom(r io.Reader, n int64) (int, error) {
+	if p.config.Mode == OpenReadOnly {
+		return 0, ErrPartitionReadOnly
+	}
+	var payloads [][]byte
+	if p.encryptor != nil {
+		// The payload is sealed as a whole, it can't be streamed
+		data, err := readPayload(r, n, p.config.maxRecordBytes())
+		if err != nil {
+			return 0, err
+		}
+		if data, err = p.preparePayload(data); err != nil {
+			return 0, err
+		}
+		payloads = [][]byte{data}
+	}
+
+	p.writerMu.Lock()
+	defer p.writerMu.Unlock()
+
+	if payloads != nil {
+		if _, err := p.sealPayloads(payloads); err != nil {
+			return 0, err
+		}
+		r, n = bytes.NewReader(payloads[0]), int64(len(payloads[0]))
+	}
+
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return 0, ErrPartitionClosed
+	}
+	if err := p.checkFenced(); err != nil {
+		p.mu.Unlock()
+		return 0, err
+	}
+	// The size of a payload of unknown length is found out too late, a
+	// segment may end up above MaxSegmentBytes because of it.
+	err := p.rotate(HeaderSize + max(n, 0))
+	activeLog := p.activeLog
+	offset := p.nextOffset
+	p.mu.Unlock()
+	// A rotation fsyncs the segment it seals
+	defer p.notifyDurable()
 	if err != nil {
-		return fmt.Errorf("error appending new record to partition because rotation failed: %w", err)
+		return 0, p.failWrite(fmt.Errorf("error appending new record to partition because rotation failed: %w", err))
+	}
+
+	// Nothing else writes to the active segment while writerMu is held
+	if _, err := activeLog.AppendFrom(r, n); err != nil {
+		return 0, p.failWrite(fmt.Errorf("error appending new record: %w", err))
+	}
+
+	p.mu.Lock()
+	p.nextOffset++
+	if p.config.Durability == DurabilityFull {
+		p.durableOffset.Store(int64(p.nextOffset))
+	}
+	p.mu.Unlock()
+	p.notifyAppended()
+	return offset, nil
+}
+
+// Close stops accepting appends, waits for the batch in flight, then flushes,
+// fsyncs and closes the active segment. Finally it writes a checkpoint so the
+// next open can skip the recovery scan.
+func (p *Partition) Close() error {
+	p.pipeline.stop()
+
+	p.mu.RLock()
+	tiering := p.tiering
+	p.mu.RUnlock()
+	if tiering != nil {
+		tiering.Stop()
 	}
 
-	err = p.activeLog.Append(data)
+	err := p.close()
+	p.notifyDurable()
+	p.notifyAppended()
+
+	// Nothing else is going to become durable
+	hookErr := ErrPartitionClosed
 	if err != nil {
-		return fmt.Errorf("error appending new record: %w", err)
+		hookErr = err
+	}
+	p.failHooks(hookErr)
+
+	return err
+}
+
+// Delete closes the partition and removes it for good: its segments, its
+// metadata and, with tiered storage attached, its objects in the store. Reads
+// in flight finish first, later ones fail with ErrPartitionClosed. The
+// directories are renamed to tombstones before they are removed, so a crash
+// halfway never leaves behind something that can be opened as a partition.
+func (p *Partition) Delete() error {
+	if p.config.Mode == OpenReadOnly {
+		return ErrPartitionReadOnly
 	}
 
-	p.nextOffset += 1
+	p.pipeline.stop()
+
+	p.mu.RLock()
+	tiering := p.tiering
+	p.mu.RUnlock()
+	if tiering != nil {
+		tiering.Stop()
+	}
+
+	defer p.failHooks(ErrPartitionClosed)
+	p.writerMu.Lock()
+	defer p.writerMu.Unlock()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return ErrPartitionClosed
+	}
+	p.closed = true
+	defer unlockDir(p.lock)
+
+	p.dropReaders()
+	p.fds.release(logFDs)
+	if err := p.activeLog.Close(); err != nil {
+		return fmt.Errorf("error while closing active log: %w", err)
+	}
+
+	dirs := []string{p.dir}
+	if rel, err := filepath.Rel(p.dir, p.metaDir); err != nil || !filepath.IsLocal(rel) && rel != "." {
+		// Metadata lives outside of the partition directory
+		dirs = append(dirs, p.metaDir)
+	}
+	for _, dir := range dirs {
+		if err := removeDir(dir); err != nil {
+			return err
+		}
+	}
+
+	if tiering != nil {
+		if err := tiering.deleteObjects(); err != nil {
+			return fmt.Errorf("failed to delete tiered segments: %w", err)
+		}
+	}
+	p.logger.Info("deleted partition")
+	return nil
+}
+
+// removeDir renames dir to a tombstone next to it, then removes it.
+func removeDir(dir string) error {
+	dir = filepath.Clean(dir)
+	tombstone := fmt.Sprintf("%s.deleted-%d", dir, time.Now().UnixNano())
+	if err := os.Rename(dir, tombstone); err != nil {
+		return fmt.Errorf("failed to tombstone %s: %w", dir, err)
+	}
+	if err := syncDir(filepath.Dir(dir)); err != nil {
+		return err
+	}
+	if err := os.RemoveAll(tombstone); err != nil {
+		return fmt.Errorf("failed to remove %s: %w", tombstone, err)
+	}
 	return nil
 }
 
+func (p *Partition) close() error {
+	p.writerMu.Lock()
+	defer p.writerMu.Unlock()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return nil
+	}
+	p.closed = true
+	// Last, the checkpoint is written under the lock too
+	defer unlockDir(p.lock)
+
+	p.dropReaders()
+	p.fds.release(logFDs)
+	if err := p.activeLog.Close(); err != nil {
+		return fmt.Errorf("error while closing active log: %w", err)
+	}
+	p.durableOffset.Store(int64(p.nextOffset))
+	if p.config.Mode == OpenReadOnly {
+		return nil
+	}
+
+	cp, err := newCheckpoint(p.nextOffset, p.segments)
+	if err != nil {
+		return fmt.Errorf("error while taking checkpoint: %w", err)
+	}
+	return writeCheckpoint(p.metaDir, cp)
+}
+
+// appendBatch appends payloads in order, rotating segments as needed, and
+// returns how many of them were written before an error occurred. ttls holds
+// the TTL of each payload.
+// Caller must hold p.mu.
+func (p *Partition) appendBatch(payloads [][]byte, ttls []time.Duration, timestamps []time.Time) (int, error) {
+	if p.config.Mode == OpenReadOnly {
+		return 0, ErrPartitionReadOnly
+	}
+	if err := p.checkFenced(); err != nil {
+		return 0, err
+	}
+
+	written := 0
+	for written < len(payloads) {
+		err := p.rotate(int64(HeaderSize + len(payloads[written])))
+		if err != nil {
+			return written, p.failWrite(fmt.Errorf("error appending new record to partition because rotation failed: %w", err))
+		}
+
+		chunk := p.fitActiveSegment(payloads[written:])
+		var chunkTimestamps []time.Time
+		if timestamps != nil {
+			chunkTimestamps = timestamps[written : written+len(chunk)]
+		}
+		err = p.activeLog.appendBatch(chunk, ttls[written:written+len(chunk)], chunkTimestamps)
+		if err != nil {
+			return written, p.failWrite(fmt.Errorf("error appending new record: %w", err))
+		}
+
+		written += len(chunk)
+		p.nextOffset += len(chunk)
+		if p.config.Durability == DurabilityFull {
+			p.durableOffset.Store(int64(p.nextOffset))
+		}
+	}
+
+	return written, nil
+}
+
+// fitActiveSegment returns the longest prefix of payloads that can go into the
+// active segment without crossing the rotation limits. It always contains at
+// least one record, rotate has already made room for it.
+func (p *Partition) fitActiveSegment(payloads [][]byte) [][]byte {
+	records := p.activeLog.NextOffset()
+	size := p.activeLog.Size()
+
+	n := 1
+	records++
+	size += int64(HeaderSize + len(payloads[0]))
+	for n < len(payloads) {
+		recordSize := int64(HeaderSize + len(payloads[n]))
+		if records+1 > p.config.MaxSegmentRecords || size+recordSize > p.config.MaxSegmentBytes {
+			break
+		}
+		n++
+		records++
+		size += recordSize
+	}
+
+	return payloads[:n]
+}
+
+// NextOffset returns the offset the next appended record will get.
+func (p *Partition) NextOffset() int {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.nextOffset
+}
+
+// FirstOffset returns the offset of the oldest record the partition still
+// holds, locally or in tiered storage. It is NextOffset for an empty
+// partition.
+func (p *Partition) FirstOffset() int {
+	p.mu.RLock()
+	first := p.segments[0].BaseOffset
+	tiering := p.tiering
+	p.mu.RUnlock()
+
+	if tiering != nil {
+		if tiered, ok := tiering.firstOffset(); ok {
+			first = min(first, tiered)
+		}
+	}
+	return first
+}
+
+// Read returns the record at offset. Records whose TTL has run out are
+// reported as ErrRecordExpired instead of being returned.
 func (p *Partition) Read(offset int) (Record, error) {
+	return p.ReadInto(offset, nil)
+}
+
+// ReadInto is Read with the payload read into buf when it has the capacity
+// for it, so a consumer that is done with a record before it reads the next
+// can keep reusing one buffer. The payload of the record returned aliases buf
+// unless it didn't fit.
+func (p *Partition) ReadInto(offset int, buf []byte) (Record, error) {
+	return p.readInto(context.Background(), offset, buf, -1)
+}
+
+// ReadContext is Read that gives up on reads from tiered storage once ctx is
+// done, they fetch from the object store block by block. Reads of local
+// segments are short and always go through.
+func (p *Partition) ReadContext(ctx context.Context, offset int) (Record, error) {
+	return p.readInto(ctx, offset, nil, -1)
+}
+
+// ReadWithin is ReadContext for a record whose payload takes at most limit
+// bytes on disk. A larger payload isn't read at all, the read fails with
+// ErrRecordOverLimit instead, so that a reader on a byte budget stops before
+// loading the record that doesn't fit.
+func (p *Partition) ReadWithin(ctx context.Context, offset int, limit int64) (Record, error) {
+	return p.readInto(ctx, offset, nil, max(limit, 0))
+}
+
+func (p *Partition) readInto(ctx context.Context, offset int, buf []byte, limit int64) (Record, error) {
+	record, err := p.read(ctx, offset, buf, limit)
+	if err != nil {
+		return Record{}, err
+	}
+	if record.Header.Expired(time.Now()) {
+		return Record{}, fmt.Errorf("offset %d: %w", offset, ErrRecordExpired)
+	}
+	if p.encryptor != nil {
+		if record.Payload, err = p.encryptor.open(record.Payload, offset); err != nil {
+			return Record{}, fmt.Errorf("offset %d: %w", offset, err)
+		}
+	}
+	return record, nil
+}
+
+// readPayload reads the payload AppendFrom is given into memory, refusing
+// one over limit.
+func readPayload(r io.Reader, n int64, limit int64) ([]byte, error) {
+	if n > limit {
+		return nil, &RecordTooLargeError{Size: n, Limit: limit}
+	}
+	if n >= 0 {
+		data := make([]byte, n)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, fmt.Errorf("error reading payload: %w", err)
+		}
+		return data, nil
+	}
+
+	data, err := io.ReadAll(io.LimitReader(r, limit+1))
+	if err != nil {
+		return nil, fmt.Errorf("error reading payload: %w", err)
+	}
+	if int64(len(data)) > limit {
+		return nil, &RecordTooLargeError{Size: int64(len(data)), Limit: limit}
+	}
+	return data, nil
+}
+
+// read returns the record at offset, failing with ErrRecordOverLimit for a
+// payload over limit unless limit is negative.
+func (p *Partition) read(ctx context.Context, offset int, buf []byte, limit int64) (Record, error) {
 	p.mu.RLock()
+
+	if p.closed {
+		p.mu.RUnlock()
+		return Record{}, ErrPartitionClosed
+	}
+
+	if offset < p.segments[0].BaseOffset && p.tiering != nil {
+		tiering := p.tiering
+		p.mu.RUnlock()
+		// Don't hold up appends while waiting on the object store
+		return tiering.read(ctx, offset, buf, limit)
+	}
 	defer p.mu.RUnlock()
 
 	nearestSegmentIdx := sort.Search(len(p.segments), func(i int) bool {
@@ -166,11 +917,11 @@ func (p *Partition) Read(offset int) (Record, error) {
 
 	nearestSegment := p.segments[nearestSegmentIdx]
 
-	l, err := NewLogReadOnly(nearestSegment.Path, nearestSegment.BaseOffset) // Cache this or smth
+	l, done, err := p.openSegment(nearestSegment)
 	if err != nil {
-		return Record{}, fmt.Errorf("unable to open log segment in read only: %w", err)
+		return Record{}, err
 	}
-	defer l.Close()
+	defer done()
 
-	return l.FindRecord(int64(offset))
+	return l.findRecord(int64(offset), buf, limit)
 }
@@ -0,0 +1,124 @@
+package storage
+
+import (
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"sync/atomic"
+	"testing"
+)
+
+// newBenchPartition returns a partition holding records payloads of size
+// bytes, rolled over into a segment every segmentRecords records.
+func newBenchPartition(b *testing.B, records, segmentRecords, size int) *Partition {
+	b.Helper()
+	config := DefaultPartitionConfig()
+	config.MaxSegmentRecords = int64(segmentRecords)
+	// Rotations would drown out the results
+	config.Logger = slog.New(slog.DiscardHandler)
+	p, err := NewPartitionWithConfig(b.TempDir(), config)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() { p.Close() })
+
+	payload, err := GenerateRandomBytes(size)
+	if err != nil {
+		b.Fatal(err)
+	}
+	for range records {
+		if err := p.Append(payload); err != nil {
+			b.Fatal(err)
+		}
+	}
+	return p
+}
+
+func BenchmarkPartitionRead_AcrossSegments(b *testing.B) {
+	const records = 10000
+	for _, size := range benchPayloadSizes {
+		b.Run(fmt.Sprintf("%dB", size), func(b *testing.B) {
+			p := newBenchPartition(b, records, 1000, size)
+			rng := rand.New(rand.NewPCG(1, 2))
+
+			b.SetBytes(int64(size))
+			b.ReportAllocs()
+			for b.Loop() {
+				if _, err := p.Read(rng.IntN(records)); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkReader_Sequential(b *testing.B) {
+	const records = 10000
+	for _, size := range benchPayloadSizes {
+		b.Run(fmt.Sprintf("%dB", size), func(b *testing.B) {
+			p := newBenchPartition(b, records, 1000, size)
+			reader := p.NewReader(ReaderConfig{})
+			defer reader.Close()
+
+			b.SetBytes(int64(size))
+			b.ReportAllocs()
+			for i := 0; b.Loop(); i++ {
+				if _, err := reader.Read(i % records); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkPartition_MixedReadWrite has every goroutine read random records
+// and append one every tenth operation.
+func BenchmarkPartition_MixedReadWrite(b *testing.B) {
+	const records = 5000
+	for _, size := range benchPayloadSizes {
+		b.Run(fmt.Sprintf("%dB", size), func(b *testing.B) {
+			p := newBenchPartition(b, records, 1000, size)
+			payload, err := GenerateRandomBytes(size)
+			if err != nil {
+				b.Fatal(err)
+			}
+			var seed atomic.Uint64
+
+			b.SetBytes(int64(size))
+			b.ReportAllocs()
+			b.RunParallel(func(pb *testing.PB) {
+				rng := rand.New(rand.NewPCG(seed.Add(1), 0))
+				for i := 0; pb.Next(); i++ {
+					if i%10 == 0 {
+						if err := p.Append(payload); err != nil {
+							b.Error(err)
+							return
+						}
+						continue
+					}
+					if _, err := p.Read(rng.IntN(records)); err != nil {
+						b.Error(err)
+						return
+					}
+				}
+			})
+		})
+	}
+}
+
+// BenchmarkPartitionAppend_Rotation appends to a partition that rolls over
+// into a new segment every 100 records, so rotation weighs in.
+func BenchmarkPartitionAppend_Rotation(b *testing.B) {
+	p := newBenchPartition(b, 0, 100, 0)
+	payload, err := GenerateRandomBytes(100)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.ReportAllocs()
+	for b.Loop() {
+		if err := p.Append(payload); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
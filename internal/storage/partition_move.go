@@ -0,0 +1,198 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// Move relocates the partition to dir, which must not exist yet or be empty,
+// typically on another disk. Sealed segments are copied first while the
+// partition carries on as usual; appends and reads are only held up at the
+// end, while the segments sealed in the meantime and the active one are
+// copied and the partition switches over.
+//
+// The old directory is left as it was at the switch, for the caller to remove
+// once it has recorded the new location. Metadata kept in the partition
+// directory moves with it, a separate MetaDir stays where it is. Moving isn't
+// supported on a tiered partition.
+func (p *Partition) Move(dir string) error {
+	if p.config.Mode == OpenReadOnly {
+		return ErrPartitionReadOnly
+	}
+
+	p.mu.RLock()
+	closed, tiered, oldDir := p.closed, p.tiering != nil, p.dir
+	sealed := slices.Clone(p.segments[:len(p.segments)-1])
+	p.mu.RUnlock()
+
+	if closed {
+		return ErrPartitionClosed
+	}
+	if tiered {
+		return errors.New("can't move a tiered partition")
+	}
+	if filepath.Clean(dir) == filepath.Clean(oldDir) {
+		return fmt.Errorf("partition is already in %s", dir)
+	}
+	if err := ensureEmptyDir(dir); err != nil {
+		return err
+	}
+	lock, err := lockDir(dir)
+	if err != nil {
+		return err
+	}
+
+	copied := make(map[int]bool, len(sealed))
+	err = func() error {
+		for _, segment := range sealed {
+			if err := copySegment(segment, dir); err != nil {
+				return err
+			}
+			copied[segment.BaseOffset] = true
+		}
+		return p.switchDir(dir, lock, copied)
+	}()
+	if err != nil {
+		return errors.Join(fmt.Errorf("failed to move partition to %s: %w", dir, err), unlockDir(lock), os.RemoveAll(dir))
+	}
+	return nil
+}
+
+// switchDir copies what's left of the partition to dir and reopens it from
+// there, trading the lock on the old directory for lock, the one on dir.
+// copied holds the base offsets of the segments already in dir.
+func (p *Partition) switchDir(dir string, lock *os.File, copied map[int]bool) error {
+	// Closing the active log fsyncs it, runs after the unlock
+	defer p.notifyDurable()
+	p.writerMu.Lock()
+	defer p.writerMu.Unlock()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return ErrPartitionClosed
+	}
+
+	// Catch up with the rotations and truncations since the first pass
+	for base := range copied {
+		if !slices.ContainsFunc(p.segments, func(s Segment) bool { return s.BaseOffset == base }) {
+			if err := removeSegment(Segment{Path: filepath.Join(dir, newLogNameFromInt(base).string())}); err != nil {
+				return err
+			}
+		}
+	}
+	for _, segment := range p.segments[:len(p.segments)-1] {
+		if copied[segment.BaseOffset] {
+			continue
+		}
+		if err := copySegment(segment, dir); err != nil {
+			return err
+		}
+	}
+
+	if err := p.activeLog.Close(); err != nil {
+		return fmt.Errorf("error while closing active log: %w", err)
+	}
+	err := copySegment(p.segments[len(p.segments)-1], dir)
+	if err == nil && p.metaDir == p.dir {
+		err = copyMetadata(p.dir, dir)
+	}
+	if err == nil {
+		err = syncDir(dir)
+	}
+	if err != nil {
+		// Carry on from where the partition was
+		return errors.Join(err, p.reopenActiveLog())
+	}
+
+	p.dropReaders()
+	for i, segment := range p.segments {
+		p.segments[i].Path = filepath.Join(dir, filepath.Base(segment.Path))
+	}
+	if p.metaDir == p.dir {
+		p.metaDir = dir
+	}
+	oldDir := p.dir
+	p.dir = dir
+	unlockDir(p.lock)
+	p.lock = lock
+	if err := p.reopenActiveLog(); err != nil {
+		return err
+	}
+
+	p.logger.Info("moved partition", "from", oldDir, "to", dir)
+	return nil
+}
+
+// ensureEmptyDir creates dir if it doesn't exist and checks that it's empty.
+func ensureEmptyDir(dir string) error {
+	if err := ensureWritableDir(dir); err != nil {
+		return err
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	if len(entries) > 0 {
+		return fmt.Errorf("%s is not empty", dir)
+	}
+	return nil
+}
+
+// copySegment copies the log file of segment, its index and its seal if it
+// has one into dir.
+func copySegment(segment Segment, dir string) error {
+	for _, path := range []string{segment.Path, segment.Path + ".index"} {
+		if err := copyFile(path, filepath.Join(dir, filepath.Base(path))); err != nil {
+			return err
+		}
+	}
+	sealPath := segment.Path + sealSuffix
+	err := copyFile(sealPath, filepath.Join(dir, filepath.Base(sealPath)))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// copyMetadata copies the files of from that aren't segments into to.
+func copyMetadata(from string, to string) error {
+	entries, err := os.ReadDir(from)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if !entry.Type().IsRegular() || strings.HasSuffix(name, ".log") || strings.HasSuffix(name, ".index") || strings.HasSuffix(name, sealSuffix) {
+			continue
+		}
+		if err := copyFile(filepath.Join(from, name), filepath.Join(to, name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copyFile copies src to dst, which must not exist, and fsyncs the copy.
+func copyFile(src string, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if err := errors.Join(err, out.Sync(), out.Close()); err != nil {
+		return fmt.Errorf("failed to copy %s: %w", src, err)
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPartition_Move(t *testing.T) {
+	t.Run("moves every segment and carries on from the new directory", func(t *testing.T) {
+		p, oldDir := newTruncateTestPartition(t, 350)
+		dir := filepath.Join(t.TempDir(), "moved")
+
+		require.NoError(t, p.Move(dir))
+		require.Equal(t, dir, p.dir)
+		require.Len(t, p.segments, 4)
+		for _, segment := range p.segments {
+			require.FileExists(t, segment.Path)
+			require.Equal(t, dir, filepath.Dir(segment.Path))
+		}
+		// The old directory is left for the caller
+		require.FileExists(t, filepath.Join(oldDir, newLogNameFromInt(300).string()))
+
+		for _, offset := range []int{0, 150, 349} {
+			requireRecord(t, p, offset, fmt.Sprintf("data %d", offset))
+		}
+		require.NoError(t, p.Append([]byte("data 350")))
+
+		// The move is complete once the old directory is gone
+		require.NoError(t, os.RemoveAll(oldDir))
+		require.NoError(t, p.Close())
+		p, err := NewPartitionWithConfig(dir, p.config)
+		require.NoError(t, err)
+		defer p.Close()
+		require.True(t, p.RecoveryReport().CleanShutdown)
+		require.Equal(t, 351, p.NextOffset())
+		requireRecord(t, p, 350, "data 350")
+		requireRecord(t, p, 42, "data 42")
+	})
+	t.Run("appends carry on during the move", func(t *testing.T) {
+		p, _ := newTruncateTestPartition(t, 250)
+		dir := filepath.Join(t.TempDir(), "moved")
+
+		var wg sync.WaitGroup
+		wg.Go(func() {
+			for i := 250; i < 500; i++ {
+				require.NoError(t, p.Append(fmt.Appendf(nil, "data %d", i)))
+			}
+		})
+		require.NoError(t, p.Move(dir))
+		wg.Wait()
+
+		require.Equal(t, 500, p.NextOffset())
+		for offset := range 500 {
+			requireRecord(t, p, offset, fmt.Sprintf("data %d", offset))
+		}
+	})
+	t.Run("the target must be empty", func(t *testing.T) {
+		p, dir := newTruncateTestPartition(t, 10)
+		require.Error(t, p.Move(dir))
+
+		target := t.TempDir()
+		require.NoError(t, os.WriteFile(filepath.Join(target, "other"), nil, 0o644))
+		require.Error(t, p.Move(target))
+		require.FileExists(t, filepath.Join(target, "other"))
+		requireRecord(t, p, 9, "data 9")
+	})
+	t.Run("closed partition", func(t *testing.T) {
+		p, _ := newTruncateTestPartition(t, 10)
+		require.NoError(t, p.Close())
+		require.ErrorIs(t, p.Move(t.TempDir()), ErrPartitionClosed)
+	})
+}
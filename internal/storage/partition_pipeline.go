@@ -0,0 +1,174 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+var ErrPartitionClosed = errors.New("partition is closed")
+
+// maxPipelineBatch caps how many records the pipeline writes in one go, so a
+// steady stream of producers can't grow a batch without bound.
+const maxPipelineBatch = 1024
+
+type appendRequest struct {
+	data      []byte
+	ttl       time.Duration
+	timestamp time.Time // zero for the time of the append
+	done      chan error
+}
+
+// appendPipeline funnels every append of a partition through a single writer
+// goroutine. The writer takes the first waiting request, collects whatever
+// else is queued up (for up to BatchWindow) and writes it all under one
+// acquisition of the partition lock with one flush/fsync, so concurrent
+// producers share the cost of the write instead of queueing on p.mu one by
+// one (group commit).
+type appendPipeline struct {
+	p        *Partition
+	window   time.Duration
+	requests chan appendRequest // unbuffered: a sent request is always picked up by the writer
+	done     chan struct{}
+	wg       sync.WaitGroup
+	once     sync.Once
+}
+
+func newAppendPipeline(p *Partition) *appendPipeline {
+	ap := &appendPipeline{
+		p:        p,
+		window:   p.config.BatchWindow,
+		requests: make(chan appendRequest),
+		done:     make(chan struct{}),
+	}
+	ap.wg.Add(1)
+	go ap.writerLoop()
+	return ap
+}
+
+// append waits for data to be written, or for ctx to be done. Once the
+// request has been handed to the writer the record may still be written after
+// ctx is done.
+func (ap *appendPipeline) append(ctx context.Context, data []byte, ttl time.Duration, timestamp time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	req := appendRequest{data: data, ttl: ttl, timestamp: timestamp, done: make(chan error, 1)}
+
+	select {
+	case ap.requests <- req:
+	case <-ap.done:
+		return ErrPartitionClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	select {
+	case err := <-req.done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (ap *appendPipeline) writerLoop() {
+	defer ap.wg.Done()
+
+	for {
+		select {
+		case req := <-ap.requests:
+			ap.commit(ap.collect(req))
+		case <-ap.done:
+			return
+		}
+	}
+}
+
+// collect gathers the batch that starts with first. Without a window it only
+// takes requests whose senders are already waiting.
+func (ap *appendPipeline) collect(first appendRequest) []appendRequest {
+	batch := []appendRequest{first}
+
+	if ap.window == 0 {
+		for len(batch) < maxPipelineBatch {
+			select {
+			case req := <-ap.requests:
+				batch = append(batch, req)
+			default:
+				return batch
+			}
+		}
+		return batch
+	}
+
+	timer := time.NewTimer(ap.window)
+	defer timer.Stop()
+
+	for len(batch) < maxPipelineBatch {
+		select {
+		case req := <-ap.requests:
+			batch = append(batch, req)
+		case <-timer.C:
+			return batch
+		case <-ap.done:
+			return batch
+		}
+	}
+	return batch
+}
+
+func (ap *appendPipeline) commit(batch []appendRequest) {
+	payloads := make([][]byte, len(batch))
+	ttls := make([]time.Duration, len(batch))
+	var timestamps []time.Time
+	for i, req := range batch {
+		payloads[i] = req.data
+		ttls[i] = req.ttl
+		if !req.timestamp.IsZero() {
+			if timestamps == nil {
+				timestamps = make([]time.Time, len(batch))
+			}
+			timestamps[i] = req.timestamp
+		}
+	}
+
+	ap.p.writerMu.Lock()
+	// Sealed outside of p.mu, the payloads after one that fails aren't
+	// appended
+	sealed, err := ap.p.sealPayloads(payloads)
+	written := 0
+	if sealed > 0 {
+		var appendErr error
+		ap.p.mu.Lock()
+		written, appendErr = ap.p.appendBatch(payloads[:sealed], ttls, timestamps)
+		ap.p.mu.Unlock()
+		if appendErr != nil {
+			err = appendErr
+		}
+	}
+	ap.p.writerMu.Unlock()
+
+	// A rotation fsyncs the segment it seals
+	ap.p.notifyDurable()
+	if written > 0 {
+		ap.p.notifyAppended()
+	}
+
+	for i, req := range batch {
+		if i < written {
+			req.done <- nil
+		} else {
+			req.done <- err
+		}
+	}
+}
+
+// stop finishes the batch in flight and shuts the writer down. Appends after
+// stop fail with ErrPartitionClosed.
+func (ap *appendPipeline) stop() {
+	ap.once.Do(func() {
+		close(ap.done)
+	})
+	ap.wg.Wait()
+}
@@ -1,11 +1,20 @@
 package storage
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"log/slog"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
@@ -85,17 +94,9 @@ func TestPartition_NewPartition(t *testing.T) {
 		partitionDir := filepath.Join(t.TempDir(), "partition/1/")
 		err := os.MkdirAll(partitionDir, 0o755)
 		require.NoError(t, err)
-		logPath1 := filepath.Join(partitionDir, newLogNameFromInt(0).string())
-		_, err = NewLogMediumDurable(logPath1, 0)
-		require.NoError(t, err)
-
-		logPath2 := filepath.Join(partitionDir, newLogNameFromInt(101).string())
-		_, err = NewLogMediumDurable(logPath2, 0)
-		require.NoError(t, err)
-
-		logPath3 := filepath.Join(partitionDir, newLogNameFromInt(201).string())
-		_, err = NewLogMediumDurable(logPath3, 0)
-		require.NoError(t, err)
+		logPath1 := writeSegment(t, partitionDir, 0, 101)
+		logPath2 := writeSegment(t, partitionDir, 101, 100)
+		logPath3 := writeSegment(t, partitionDir, 201, 0)
 
 		p, err := NewPartition(partitionDir)
 		require.NoError(t, err)
@@ -117,6 +118,197 @@ func TestPartition_NewPartition(t *testing.T) {
 		require.Equal(t, "000000000000201.log", p.activeLogName.string())
 		require.Equal(t, 201, p.nextOffset)
 	})
+	t.Run("overlapping segments are repaired", func(t *testing.T) {
+		partitionDir := filepath.Join(t.TempDir(), "partition/1/")
+		err := os.MkdirAll(partitionDir, 0o755)
+		require.NoError(t, err)
+
+		writeSegment(t, partitionDir, 0, 1200)
+		writeSegment(t, partitionDir, 1000, 50)
+
+		p, err := NewPartition(partitionDir)
+		require.NoError(t, err)
+		require.Equal(t, 1050, p.nextOffset)
+
+		l, err := NewLogReadOnly(p.segments[0].Path, 0)
+		require.NoError(t, err)
+		defer l.Close()
+		require.Equal(t, int64(1000), l.NextOffset())
+
+		record, err := p.Read(999)
+		require.NoError(t, err)
+		require.Equal(t, "segment 0 record 999", string(record.Payload))
+
+		record, err = p.Read(1000)
+		require.NoError(t, err)
+		require.Equal(t, "segment 1000 record 0", string(record.Payload))
+	})
+	t.Run("gap between segments", func(t *testing.T) {
+		partitionDir := filepath.Join(t.TempDir(), "partition/1/")
+		err := os.MkdirAll(partitionDir, 0o755)
+		require.NoError(t, err)
+
+		writeSegment(t, partitionDir, 0, 10)
+		writeSegment(t, partitionDir, 20, 10)
+
+		p, err := NewPartition(partitionDir)
+		require.ErrorIs(t, err, ErrSegmentGap)
+		require.Nil(t, p)
+	})
+}
+
+func TestPartition_OpenModes(t *testing.T) {
+	// tearTail appends the first bytes of a record that never finished
+	// writing to the segment at path.
+	tearTail := func(t *testing.T, path string) {
+		t.Helper()
+		f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+		require.NoError(t, err)
+		_, err = f.Write(make([]byte, HeaderSize/2))
+		require.NoError(t, err)
+		require.NoError(t, f.Close())
+	}
+
+	t.Run("normal mode cuts a torn tail", func(t *testing.T) {
+		partitionDir := filepath.Join(t.TempDir(), "partition/1/")
+		require.NoError(t, os.MkdirAll(partitionDir, 0o755))
+		path := writeSegment(t, partitionDir, 0, 10)
+		tearTail(t, path)
+
+		p, err := NewPartition(partitionDir)
+		require.NoError(t, err)
+		defer p.Close()
+
+		report := p.RecoveryReport()
+		require.Equal(t, OpenNormal, report.Mode)
+		require.False(t, report.CleanShutdown)
+		require.Equal(t, int64(HeaderSize/2), report.TornTailBytes)
+
+		require.NoError(t, p.Append([]byte("after recovery")))
+		record, err := p.Read(10)
+		require.NoError(t, err)
+		require.Equal(t, "after recovery", string(record.Payload))
+	})
+	t.Run("read only mode reports without touching the disk", func(t *testing.T) {
+		partitionDir := filepath.Join(t.TempDir(), "partition/1/")
+		require.NoError(t, os.MkdirAll(partitionDir, 0o755))
+		writeSegment(t, partitionDir, 0, 1200)
+		writeSegment(t, partitionDir, 1000, 50)
+		writeSegment(t, partitionDir, 1100, 100)
+		path := writeSegment(t, partitionDir, 1200, 10)
+		tearTail(t, path)
+		before, err := os.Stat(path)
+		require.NoError(t, err)
+
+		config := DefaultPartitionConfig()
+		config.Mode = OpenReadOnly
+		p, err := NewPartitionWithConfig(partitionDir, config)
+		require.NoError(t, err)
+
+		report := p.RecoveryReport()
+		require.Equal(t, int64(HeaderSize/2), report.TornTailBytes)
+		require.Equal(t, []SegmentOverlap{{Path: filepath.Join(partitionDir, newLogNameFromInt(0).string()), Records: 200}}, report.Overlaps)
+		require.Equal(t, []SegmentGap{{From: 1050, To: 1100}}, report.Gaps)
+
+		record, err := p.Read(1205)
+		require.NoError(t, err)
+		require.Equal(t, "segment 1200 record 5", string(record.Payload))
+
+		require.ErrorIs(t, p.Append([]byte("nope")), ErrPartitionReadOnly)
+		require.NoError(t, p.Close())
+
+		after, err := os.Stat(path)
+		require.NoError(t, err)
+		require.Equal(t, before.Size(), after.Size())
+		require.NoFileExists(t, filepath.Join(partitionDir, checkpointFileName))
+
+		l, err := NewLogReadOnly(filepath.Join(partitionDir, newLogNameFromInt(0).string()), 0)
+		require.NoError(t, err)
+		defer l.Close()
+		require.Equal(t, int64(1200), l.NextOffset())
+	})
+	t.Run("read only mode needs an existing partition", func(t *testing.T) {
+		config := DefaultPartitionConfig()
+		config.Mode = OpenReadOnly
+
+		_, err := NewPartitionWithConfig(filepath.Join(t.TempDir(), "missing"), config)
+		require.ErrorIs(t, err, os.ErrNotExist)
+
+		_, err = NewPartitionWithConfig(t.TempDir(), config)
+		require.Error(t, err)
+	})
+	t.Run("force mode opens past a gap", func(t *testing.T) {
+		partitionDir := filepath.Join(t.TempDir(), "partition/1/")
+		require.NoError(t, os.MkdirAll(partitionDir, 0o755))
+		writeSegment(t, partitionDir, 0, 10)
+		writeSegment(t, partitionDir, 20, 10)
+
+		config := DefaultPartitionConfig()
+		config.Mode = OpenForce
+		p, err := NewPartitionWithConfig(partitionDir, config)
+		require.NoError(t, err)
+		defer p.Close()
+
+		require.Empty(t, p.RecoveryReport().Gaps)
+		require.Equal(t, 30, p.nextOffset)
+
+		record, err := p.Read(25)
+		require.NoError(t, err)
+		require.Equal(t, "segment 20 record 5", string(record.Payload))
+	})
+	t.Run("clean shutdown", func(t *testing.T) {
+		partitionDir := filepath.Join(t.TempDir(), "partition/")
+		p, err := NewPartition(partitionDir)
+		require.NoError(t, err)
+		require.NoError(t, p.Append([]byte("data")))
+		require.NoError(t, p.Close())
+
+		p, err = NewPartition(partitionDir)
+		require.NoError(t, err)
+		defer p.Close()
+		require.True(t, p.RecoveryReport().CleanShutdown)
+	})
+}
+
+// writeSegment creates a closed segment holding `records` records, named
+// after baseOffset, and returns its path.
+func writeSegment(t *testing.T, dir string, baseOffset int, records int) string {
+	t.Helper()
+
+	path := filepath.Join(dir, newLogNameFromInt(baseOffset).string())
+	l, err := NewLogMediumDurable(path, baseOffset)
+	require.NoError(t, err)
+
+	for i := range records {
+		err = l.Append(fmt.Appendf(nil, "segment %d record %d", baseOffset, i))
+		require.NoError(t, err)
+	}
+	require.NoError(t, l.Close())
+
+	return path
+}
+
+func TestPartition_NewPartitionWithConfig(t *testing.T) {
+	testCases := []struct {
+		name   string
+		modify func(c *PartitionConfig)
+	}{
+		{"zero max segment bytes", func(c *PartitionConfig) { c.MaxSegmentBytes = 0 }},
+		{"max segment bytes over 4GiB", func(c *PartitionConfig) { c.MaxSegmentBytes = 1 << 32 }},
+		{"zero max segment records", func(c *PartitionConfig) { c.MaxSegmentRecords = 0 }},
+		{"negative max segment age", func(c *PartitionConfig) { c.MaxSegmentAge = -1 }},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			config := DefaultPartitionConfig()
+			tc.modify(&config)
+
+			p, err := NewPartitionWithConfig(t.TempDir(), config)
+			require.Error(t, err)
+			require.Nil(t, p)
+		})
+	}
 }
 
 func TestPartition_Append(t *testing.T) {
@@ -170,6 +362,194 @@ func TestPartition_Append(t *testing.T) {
 
 		require.Equal(t, 7, int(record.Header.PayloadSize))
 		require.Equal(t, "payload", string(record.Payload))
+
+		// The new segment is named after the exact offset of its first record
+		require.Equal(t, 0, int(record.Header.LogicalOffset))
+		record, err = p.Read(9999)
+		require.NoError(t, err)
+		require.Equal(t, 9999, int(record.Header.LogicalOffset))
+	})
+	t.Run("rotate on max segment bytes", func(t *testing.T) {
+		partitionDir := filepath.Join(t.TempDir(), "partition/")
+
+		config := DefaultPartitionConfig()
+		config.MaxSegmentBytes = SegmentHeaderSize + 10*(HeaderSize+1000)
+
+		p, err := NewPartitionWithConfig(partitionDir, config)
+		require.NoError(t, err)
+
+		for range 10 {
+			data, err := GenerateRandomBytes(1000)
+			require.NoError(t, err)
+
+			err = p.Append(data)
+			require.NoError(t, err)
+		}
+		require.Equal(t, "000000000000000.log", p.activeLogName.string())
+		require.Equal(t, config.MaxSegmentBytes, p.activeLog.Size())
+
+		err = p.Append([]byte("payload"))
+		require.NoError(t, err)
+
+		require.Equal(t, "000000000000010.log", p.activeLogName.string())
+		require.Len(t, p.segments, 2)
+
+		record, err := p.Read(10)
+		require.NoError(t, err)
+		require.Equal(t, "payload", string(record.Payload))
+	})
+	t.Run("record larger than max segment bytes", func(t *testing.T) {
+		partitionDir := filepath.Join(t.TempDir(), "partition/")
+
+		config := DefaultPartitionConfig()
+		config.MaxSegmentBytes = 512
+
+		p, err := NewPartitionWithConfig(partitionDir, config)
+		require.NoError(t, err)
+
+		for range 3 {
+			data, err := GenerateRandomBytes(1000)
+			require.NoError(t, err)
+
+			err = p.Append(data)
+			require.NoError(t, err)
+		}
+
+		require.Len(t, p.segments, 3)
+		require.Equal(t, "000000000000002.log", p.activeLogName.string())
+	})
+	t.Run("record larger than max record bytes", func(t *testing.T) {
+		config := DefaultPartitionConfig()
+		config.MaxRecordBytes = 10
+		config.BatchWindow = 5 * time.Millisecond
+
+		p, err := NewPartitionWithConfig(filepath.Join(t.TempDir(), "partition/"), config)
+		require.NoError(t, err)
+		defer p.Close()
+
+		// Refused before it joins a batch, so the appends that fit and may
+		// be waiting for the same batch don't fail with it
+		var wg sync.WaitGroup
+		errs := make([]error, 5)
+		for i := range 5 {
+			wg.Go(func() { errs[i] = p.Append(fmt.Appendf(nil, "data %d", i)) })
+		}
+		err = p.Append([]byte("way more than ten bytes"))
+		wg.Wait()
+		for _, err := range errs {
+			require.NoError(t, err)
+		}
+		var tooLarge *RecordTooLargeError
+		require.ErrorAs(t, err, &tooLarge)
+		require.Equal(t, int64(10), tooLarge.Limit)
+		require.ErrorIs(t, p.AppendWithTTL([]byte("way more than ten bytes"), time.Hour), ErrRecordTooLarge)
+		require.Equal(t, 5, p.NextOffset())
+
+		config.MaxRecordBytes = -1
+		_, err = NewPartitionWithConfig(filepath.Join(t.TempDir(), "partition/"), config)
+		require.Error(t, err)
+	})
+	t.Run("append context", func(t *testing.T) {
+		config := DefaultPartitionConfig()
+		config.BatchWindow = time.Hour
+		p, err := NewPartitionWithConfig(filepath.Join(t.TempDir(), "partition/"), config)
+		require.NoError(t, err)
+		defer p.Close()
+
+		canceled, cancel := context.WithCancel(context.Background())
+		cancel()
+		require.ErrorIs(t, p.AppendContext(canceled, []byte("never")), context.Canceled)
+		require.Equal(t, 0, p.NextOffset())
+
+		// Stuck in a batch that won't be written for an hour
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		require.ErrorIs(t, p.AppendContext(ctx, []byte("late")), context.DeadlineExceeded)
+	})
+	t.Run("batch window groups concurrent appends", func(t *testing.T) {
+		partitionDir := filepath.Join(t.TempDir(), "partition/")
+
+		config := DefaultPartitionConfig()
+		config.BatchWindow = 5 * time.Millisecond
+		config.MaxSegmentRecords = 30
+
+		p, err := NewPartitionWithConfig(partitionDir, config)
+		require.NoError(t, err)
+
+		var wg sync.WaitGroup
+		errs := make([]error, 100)
+		for i := range 100 {
+			wg.Go(func() { errs[i] = p.Append(fmt.Appendf(nil, "data %d", i)) })
+		}
+		wg.Wait()
+		for _, err := range errs {
+			require.NoError(t, err)
+		}
+
+		require.Equal(t, 100, p.nextOffset)
+		require.Len(t, p.segments, 4)
+
+		seen := make(map[string]bool)
+		for offset := range 100 {
+			record, err := p.Read(offset)
+			require.NoError(t, err)
+			require.Equal(t, offset-p.segments[offset/30].BaseOffset, int(record.Header.LogicalOffset))
+			seen[string(record.Payload)] = true
+		}
+		require.Len(t, seen, 100)
+	})
+	t.Run("concurrent appends through the pipeline", func(t *testing.T) {
+		partitionDir := filepath.Join(t.TempDir(), "partition/")
+
+		config := DefaultPartitionConfig()
+		config.MaxSegmentRecords = 1000
+
+		p, err := NewPartitionWithConfig(partitionDir, config)
+		require.NoError(t, err)
+
+		var wg sync.WaitGroup
+		errs := make([]error, 8)
+		for producer := range 8 {
+			wg.Go(func() {
+				for i := range 500 {
+					if errs[producer] = p.Append(fmt.Appendf(nil, "producer %d record %d", producer, i)); errs[producer] != nil {
+						return
+					}
+				}
+			})
+		}
+		wg.Wait()
+		for _, err := range errs {
+			require.NoError(t, err)
+		}
+
+		require.Equal(t, 4000, p.nextOffset)
+		require.Len(t, p.segments, 4)
+
+		// Each producer's records keep their relative order
+		lastSeen := make(map[int]int)
+		for offset := range 4000 {
+			record, err := p.Read(offset)
+			require.NoError(t, err)
+
+			var producer, i int
+			_, err = fmt.Sscanf(string(record.Payload), "producer %d record %d", &producer, &i)
+			require.NoError(t, err)
+			if last, ok := lastSeen[producer]; ok {
+				require.Greater(t, i, last)
+			}
+			lastSeen[producer] = i
+		}
+	})
+	t.Run("append after pipeline stopped", func(t *testing.T) {
+		p, err := NewPartition(filepath.Join(t.TempDir(), "partition/"))
+		require.NoError(t, err)
+
+		require.NoError(t, p.Append([]byte("before")))
+		p.pipeline.stop()
+
+		err = p.Append([]byte("after"))
+		require.ErrorIs(t, err, ErrPartitionClosed)
 	})
 	// t.Run("verity multiple rotates", func(t *testing.T) {
 	// 	partitionDir := filepath.Join(t.TempDir(), "partition/")
@@ -221,6 +601,38 @@ func TestPartition_Read(t *testing.T) {
 		require.NoError(t, err)
 		require.Equal(t, data2, record.Payload)
 	})
+	t.Run("read context", func(t *testing.T) {
+		p, err := NewPartition(filepath.Join(t.TempDir(), "partition/"))
+		require.NoError(t, err)
+		defer p.Close()
+		require.NoError(t, p.Append([]byte("hello")))
+
+		record, err := p.ReadContext(context.Background(), 0)
+		require.NoError(t, err)
+		require.Equal(t, "hello", string(record.Payload))
+
+		// Local reads don't block on anything ctx could cut short
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		record, err = p.ReadContext(ctx, 0)
+		require.NoError(t, err)
+		require.Equal(t, "hello", string(record.Payload))
+	})
+	t.Run("read within a limit", func(t *testing.T) {
+		p, err := NewPartition(filepath.Join(t.TempDir(), "partition/"))
+		require.NoError(t, err)
+		defer p.Close()
+		require.NoError(t, p.Append([]byte("hello")))
+
+		record, err := p.ReadWithin(context.Background(), 0, 5)
+		require.NoError(t, err)
+		require.Equal(t, "hello", string(record.Payload))
+
+		_, err = p.ReadWithin(context.Background(), 0, 4)
+		require.ErrorIs(t, err, ErrRecordOverLimit)
+		_, err = p.ReadWithin(context.Background(), 0, -1)
+		require.ErrorIs(t, err, ErrRecordOverLimit)
+	})
 	t.Run("multi-log partition read", func(t *testing.T) {
 		partitionDir := filepath.Join(t.TempDir(), "partition/")
 
@@ -275,3 +687,346 @@ func TestPartition_Read(t *testing.T) {
 	})
 	// TODO: add more complicated tests
 }
+
+func TestPartition_Close(t *testing.T) {
+	t.Run("close and reopen", func(t *testing.T) {
+		partitionDir := filepath.Join(t.TempDir(), "partition/")
+
+		p, err := NewPartition(partitionDir)
+		require.NoError(t, err)
+
+		for i := range 1200 {
+			err = p.Append(fmt.Appendf(nil, "data %d", i))
+			require.NoError(t, err)
+		}
+
+		err = p.Close()
+		require.NoError(t, err)
+		require.FileExists(t, filepath.Join(partitionDir, checkpointFileName))
+
+		err = p.Append([]byte("after close"))
+		require.ErrorIs(t, err, ErrPartitionClosed)
+		_, err = p.Read(0)
+		require.ErrorIs(t, err, ErrPartitionClosed)
+
+		// Closing twice is fine
+		require.NoError(t, p.Close())
+
+		p, err = NewPartition(partitionDir)
+		require.NoError(t, err)
+		defer p.Close()
+
+		require.NoFileExists(t, filepath.Join(partitionDir, checkpointFileName))
+		require.Equal(t, 1200, p.nextOffset)
+
+		record, err := p.Read(1199)
+		require.NoError(t, err)
+		require.Equal(t, "data 1199", string(record.Payload))
+
+		err = p.Append([]byte("after reopen"))
+		require.NoError(t, err)
+		record, err = p.Read(1200)
+		require.NoError(t, err)
+		require.Equal(t, "after reopen", string(record.Payload))
+	})
+}
+
+func TestPartition_Checkpoint(t *testing.T) {
+	t.Run("valid checkpoint skips the recovery scan", func(t *testing.T) {
+		partitionDir := filepath.Join(t.TempDir(), "partition/1/")
+		err := os.MkdirAll(partitionDir, 0o755)
+		require.NoError(t, err)
+
+		// A gap would fail the recovery scan
+		segments := []Segment{
+			{BaseOffset: 0, Path: writeSegment(t, partitionDir, 0, 10)},
+			{BaseOffset: 20, Path: writeSegment(t, partitionDir, 20, 10)},
+		}
+		cp, err := newCheckpoint(30, segments)
+		require.NoError(t, err)
+		require.NoError(t, writeCheckpoint(partitionDir, cp))
+
+		p, err := NewPartition(partitionDir)
+		require.NoError(t, err)
+		require.Equal(t, 30, p.nextOffset)
+		require.NoError(t, p.Close())
+
+		// Without the checkpoint (a crash instead of Close) the scan runs again
+		require.NoError(t, os.Remove(filepath.Join(partitionDir, checkpointFileName)))
+		_, err = NewPartition(partitionDir)
+		require.ErrorIs(t, err, ErrSegmentGap)
+	})
+	t.Run("stale checkpoint falls back to recovery", func(t *testing.T) {
+		partitionDir := filepath.Join(t.TempDir(), "partition/")
+
+		p, err := NewPartition(partitionDir)
+		require.NoError(t, err)
+		for i := range 100 {
+			require.NoError(t, p.Append(fmt.Appendf(nil, "data %d", i)))
+		}
+		require.NoError(t, p.Close())
+
+		// Something wrote to the segment behind the checkpoint's back
+		l, err := NewLogMediumDurable(filepath.Join(partitionDir, newLogNameFromInt(0).string()), 0)
+		require.NoError(t, err)
+		require.NoError(t, l.Append([]byte("data 100")))
+		require.NoError(t, l.Close())
+
+		p, err = NewPartition(partitionDir)
+		require.NoError(t, err)
+		defer p.Close()
+		require.Equal(t, 101, p.nextOffset)
+	})
+	t.Run("corrupt checkpoint falls back to recovery", func(t *testing.T) {
+		partitionDir := filepath.Join(t.TempDir(), "partition/")
+
+		p, err := NewPartition(partitionDir)
+		require.NoError(t, err)
+		for i := range 100 {
+			require.NoError(t, p.Append(fmt.Appendf(nil, "data %d", i)))
+		}
+		require.NoError(t, p.Close())
+
+		err = os.WriteFile(filepath.Join(partitionDir, checkpointFileName), []byte("{not json"), 0o644)
+		require.NoError(t, err)
+
+		p, err = NewPartition(partitionDir)
+		require.NoError(t, err)
+		defer p.Close()
+		require.Equal(t, 100, p.nextOffset)
+		require.NoFileExists(t, filepath.Join(partitionDir, checkpointFileName))
+	})
+}
+
+func TestPartition_AppendWithTTL(t *testing.T) {
+	t.Run("expired records are not delivered", func(t *testing.T) {
+		p, err := NewPartition(filepath.Join(t.TempDir(), "partition/"))
+		require.NoError(t, err)
+		defer p.Close()
+
+		require.NoError(t, p.AppendWithTTL([]byte("short lived"), time.Millisecond))
+		require.NoError(t, p.AppendWithTTL([]byte("long lived"), time.Hour))
+		require.NoError(t, p.Append([]byte("forever")))
+
+		time.Sleep(5 * time.Millisecond)
+
+		_, err = p.Read(0)
+		require.ErrorIs(t, err, ErrRecordExpired)
+
+		record, err := p.Read(1)
+		require.NoError(t, err)
+		require.Equal(t, "long lived", string(record.Payload))
+		require.NotZero(t, record.Header.ExpiresAt)
+
+		record, err = p.Read(2)
+		require.NoError(t, err)
+		require.Equal(t, "forever", string(record.Payload))
+		require.Zero(t, record.Header.ExpiresAt)
+	})
+	t.Run("negative ttl", func(t *testing.T) {
+		p, err := NewPartition(filepath.Join(t.TempDir(), "partition/"))
+		require.NoError(t, err)
+		defer p.Close()
+
+		require.Error(t, p.AppendWithTTL([]byte("data"), -time.Second))
+	})
+}
+
+func TestPartition_AppendFrom(t *testing.T) {
+	t.Run("records land between appends and survive a reopen", func(t *testing.T) {
+		dir := filepath.Join(t.TempDir(), "partition/")
+		config := DefaultPartitionConfig()
+		config.MaxSegmentRecords = 2
+		p, err := NewPartitionWithConfig(dir, config)
+		require.NoError(t, err)
+
+		require.NoError(t, p.Append([]byte("first")))
+		offset, err := p.AppendFrom(strings.NewReader("streamed"), 8)
+		require.NoError(t, err)
+		require.Equal(t, 1, offset)
+		offset, err = p.AppendFrom(strings.NewReader("unknown length"), -1)
+		require.NoError(t, err)
+		require.Equal(t, 2, offset)
+		require.NoError(t, p.Append([]byte("last")))
+		require.NoError(t, p.Close())
+
+		p, err = NewPartitionWithConfig(dir, config)
+		require.NoError(t, err)
+		defer p.Close()
+		require.Equal(t, 4, p.NextOffset())
+		for offset, want := range []string{"first", "streamed", "unknown length", "last"} {
+			record, err := p.Read(offset)
+			require.NoError(t, err)
+			require.Equal(t, want, string(record.Payload))
+		}
+	})
+
+	t.Run("reads go on while a payload streams in", func(t *testing.T) {
+		p, err := NewPartition(filepath.Join(t.TempDir(), "partition/"))
+		require.NoError(t, err)
+		defer p.Close()
+		require.NoError(t, p.Append([]byte("existing")))
+
+		pr, pw := io.Pipe()
+		done := make(chan error, 1)
+		go func() {
+			_, err := p.AppendFrom(pr, -1)
+			done <- err
+		}()
+		_, err = pw.Write([]byte("partial "))
+		require.NoError(t, err)
+
+		record, err := p.Read(0)
+		require.NoError(t, err)
+		require.Equal(t, "existing", string(record.Payload))
+		_, err = p.Read(1)
+		require.Error(t, err)
+
+		_, err = pw.Write([]byte("payload"))
+		require.NoError(t, err)
+		require.NoError(t, pw.Close())
+		require.NoError(t, <-done)
+
+		record, err = p.Read(1)
+		require.NoError(t, err)
+		require.Equal(t, "partial payload", string(record.Payload))
+	})
+
+	t.Run("failed append keeps the offset free", func(t *testing.T) {
+		p, err := NewPartition(filepath.Join(t.TempDir(), "partition/"))
+		require.NoError(t, err)
+		defer p.Close()
+
+		_, err = p.AppendFrom(strings.NewReader("short"), 100)
+		require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+		require.Equal(t, 0, p.NextOffset())
+
+		require.NoError(t, p.Append([]byte("record")))
+		record, err := p.Read(0)
+		require.NoError(t, err)
+		require.Equal(t, "record", string(record.Payload))
+	})
+
+	t.Run("closed partition", func(t *testing.T) {
+		p, err := NewPartition(filepath.Join(t.TempDir(), "partition/"))
+		require.NoError(t, err)
+		require.NoError(t, p.Close())
+
+		_, err = p.AppendFrom(strings.NewReader("data"), 4)
+		require.ErrorIs(t, err, ErrPartitionClosed)
+	})
+}
+
+func TestPartition_Delete(t *testing.T) {
+	t.Run("removes data and metadata", func(t *testing.T) {
+		root := t.TempDir()
+		paths := Paths{Data: filepath.Join(root, "data"), Meta: filepath.Join(root, "meta")}
+		config := DefaultPartitionConfig()
+		config.MaxSegmentRecords = 10
+
+		p, err := paths.OpenPartition("orders-0", config)
+		require.NoError(t, err)
+		for i := range 25 {
+			require.NoError(t, p.Append(fmt.Appendf(nil, "data %d", i)))
+		}
+		_, err = p.Read(3)
+		require.NoError(t, err)
+		require.NoError(t, p.Close())
+
+		// The checkpoint in the metadata directory goes too
+		p, err = paths.OpenPartition("orders-0", config)
+		require.NoError(t, err)
+		require.NoError(t, p.Close())
+		p, err = paths.OpenPartition("orders-0", config)
+		require.NoError(t, err)
+
+		require.NoError(t, p.Delete())
+		require.NoDirExists(t, filepath.Join(paths.Data, "orders-0"))
+		require.NoDirExists(t, filepath.Join(paths.Meta, "orders-0"))
+		for _, dir := range []string{paths.Data, paths.Meta} {
+			entries, err := os.ReadDir(dir)
+			require.NoError(t, err)
+			require.Empty(t, entries, "tombstones are removed")
+		}
+
+		_, err = p.Read(3)
+		require.ErrorIs(t, err, ErrPartitionClosed)
+		require.ErrorIs(t, p.Append([]byte("data")), ErrPartitionClosed)
+		require.ErrorIs(t, p.Delete(), ErrPartitionClosed)
+		require.NoError(t, p.Close())
+	})
+	t.Run("with concurrent reads", func(t *testing.T) {
+		p, err := NewPartition(filepath.Join(t.TempDir(), "partition/"))
+		require.NoError(t, err)
+		for i := range 100 {
+			require.NoError(t, p.Append(fmt.Appendf(nil, "data %d", i)))
+		}
+
+		var wg sync.WaitGroup
+		for range 4 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := 0; ; i = (i + 1) % 100 {
+					record, err := p.Read(i)
+					if errors.Is(err, ErrPartitionClosed) {
+						return
+					}
+					assert.NoError(t, err)
+					assert.Equal(t, fmt.Sprintf("data %d", i), string(record.Payload))
+				}
+			}()
+		}
+
+		require.NoError(t, p.Delete())
+		wg.Wait()
+	})
+	t.Run("removes tiered segments", func(t *testing.T) {
+		root := t.TempDir()
+		p, m := newTieredPartition(t, root, 0)
+		for i := range 250 {
+			require.NoError(t, p.Append(fmt.Appendf(nil, "data %d", i)))
+		}
+		require.NoError(t, m.Sync())
+		_, err := p.Read(0)
+		require.NoError(t, err)
+
+		require.NoError(t, p.Delete())
+
+		for _, dir := range []string{"bucket/topic/0", "cache"} {
+			entries, err := os.ReadDir(filepath.Join(root, dir))
+			require.NoError(t, err)
+			require.Empty(t, entries)
+		}
+	})
+}
+
+func TestPartition_Logger(t *testing.T) {
+	var buf bytes.Buffer
+	config := DefaultPartitionConfig()
+	config.MaxSegmentRecords = 5
+	config.Logger = slog.New(slog.NewTextHandler(&buf, nil))
+
+	partitionDir := filepath.Join(t.TempDir(), "partition/")
+	require.NoError(t, os.MkdirAll(partitionDir, 0o755))
+	path := writeSegment(t, partitionDir, 0, 3)
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	require.NoError(t, err)
+	_, err = f.Write([]byte("torn"))
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	p, err := NewPartitionWithConfig(partitionDir, config)
+	require.NoError(t, err)
+	for i := range 3 {
+		require.NoError(t, p.Append(fmt.Appendf(nil, "data %d", i)))
+	}
+	require.NoError(t, p.TruncateTo(4))
+	require.NoError(t, p.Close())
+
+	logs := buf.String()
+	require.Contains(t, logs, `msg="cut torn record at the end of the log" partition=`+partitionDir+" segment=000000000000000.log bytes=4")
+	require.Contains(t, logs, `msg="opened partition"`)
+	require.Contains(t, logs, `msg="rotated segment" partition=`+partitionDir+" base_offset=5")
+	require.Contains(t, logs, `msg="truncating partition" partition=`+partitionDir+" to=4 dropped=2")
+}
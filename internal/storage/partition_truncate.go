@@ -0,0 +1,222 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// TruncateTo drops every record at or after offset, so that offset is the
+// next one to be appended. Segments that start after offset are deleted and
+// the one holding it is cut short. This is how a replica throws away the
+// part of its log that diverged from the leader.
+func (p *Partition) TruncateTo(offset int) error {
+	if p.config.Mode == OpenReadOnly {
+		return ErrPartitionReadOnly
+	}
+
+	// Closing the active log fsyncs it, runs after the unlock
+	defer p.notifyDurable()
+	p.writerMu.Lock()
+	defer p.writerMu.Unlock()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return ErrPartitionClosed
+	}
+	if offset >= p.nextOffset {
+		return nil
+	}
+	if offset < p.segments[0].BaseOffset {
+		return fmt.Errorf("can't truncate to offset %d, the partition starts at %d", offset, p.segments[0].BaseOffset)
+	}
+
+	if err := p.activeLog.Close(); err != nil {
+		return fmt.Errorf("error while closing active log: %w", err)
+	}
+
+	p.logger.Warn("truncating partition", "to", offset, "dropped", p.nextOffset-offset)
+	err := p.truncateSegmentsTo(offset)
+	// Whatever happened, the last segment left is the active one again
+	if openErr := p.reopenActiveLog(); openErr != nil {
+		return errors.Join(err, openErr)
+	}
+	return err
+}
+
+// truncateSegmentsTo does the work of TruncateTo with the active log closed.
+// Caller must hold p.mu.
+func (p *Partition) truncateSegmentsTo(offset int) error {
+	idx := sort.Search(len(p.segments), func(i int) bool {
+		return p.segments[i].BaseOffset > offset
+	}) - 1
+
+	// Newest first, so a crash halfway leaves a contiguous log behind
+	for len(p.segments)-1 > idx {
+		last := p.segments[len(p.segments)-1]
+		p.dropReader(last.BaseOffset)
+		if err := removeSegment(last); err != nil {
+			return err
+		}
+		p.segments = p.segments[:len(p.segments)-1]
+	}
+	if err := syncDir(p.dir); err != nil {
+		return err
+	}
+
+	segment := p.segments[idx]
+	p.dropReader(segment.BaseOffset)
+	if err := truncateSegment(segment, int64(offset-segment.BaseOffset), p.logger); err != nil {
+		return fmt.Errorf("failed to truncate segment %s: %w", segment.Path, err)
+	}
+	return nil
+}
+
+// TruncateBefore drops every record before offset, so that offset becomes the
+// first one of the partition. Segments that end before offset are deleted and
+// the one holding it is rewritten to start at offset. Segments in tiered
+// storage are not touched, so this isn't supported on a tiered partition.
+func (p *Partition) TruncateBefore(offset int) error {
+	if p.config.Mode == OpenReadOnly {
+		return ErrPartitionReadOnly
+	}
+
+	// Closing the active log fsyncs it, runs after the unlock
+	defer p.notifyDurable()
+	p.writerMu.Lock()
+	defer p.writerMu.Unlock()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return ErrPartitionClosed
+	}
+	if p.tiering != nil {
+		return errors.New("can't truncate the head of a tiered partition")
+	}
+	if offset > p.nextOffset {
+		return fmt.Errorf("can't truncate before offset %d, the partition ends at %d", offset, p.nextOffset)
+	}
+	if offset <= p.segments[0].BaseOffset {
+		return nil
+	}
+
+	p.logger.Info("truncating partition head", "before", offset, "dropped", offset-p.segments[0].BaseOffset)
+
+	// Oldest first, so a crash halfway leaves a contiguous log behind
+	for len(p.segments) > 1 && p.segments[1].BaseOffset <= offset {
+		p.dropReader(p.segments[0].BaseOffset)
+		if err := removeSegment(p.segments[0]); err != nil {
+			return err
+		}
+		p.segments = p.segments[1:]
+	}
+	if err := syncDir(p.dir); err != nil {
+		return err
+	}
+	if p.segments[0].BaseOffset == offset {
+		return nil
+	}
+
+	// The segment holding offset has to be rewritten, which for the active
+	// segment means closing it first
+	active := len(p.segments) == 1
+	if active {
+		if err := p.activeLog.Close(); err != nil {
+			return fmt.Errorf("error while closing active log: %w", err)
+		}
+	}
+
+	p.dropReader(p.segments[0].BaseOffset)
+	segment, err := rewriteSegment(p.dir, p.segments[0], offset, p.logger)
+	if err == nil {
+		p.segments[0] = segment
+	}
+	if !active {
+		return err
+	}
+	if openErr := p.reopenActiveLog(); openErr != nil {
+		return errors.Join(err, openErr)
+	}
+	return err
+}
+
+// reopenActiveLog opens the last segment as the active log after it was
+// closed to be modified.
+// Caller must hold p.mu.
+func (p *Partition) reopenActiveLog() error {
+	last := p.segments[len(p.segments)-1]
+
+	activeLog, err := p.openLog(last.Path, last.BaseOffset)
+	if err != nil {
+		return fmt.Errorf("error while reopening active log: %w", err)
+	}
+
+	p.activeLog = activeLog
+	p.activeLogName = newLogNameFromInt(last.BaseOffset)
+	p.nextOffset = last.BaseOffset + int(activeLog.NextOffset())
+	// Everything left was fsynced when it was closed
+	p.durableOffset.Store(int64(p.nextOffset))
+	return nil
+}
+
+// rewriteSegment copies the records of segment from offset on into a new
+// segment named after offset, then removes the old one. The new segment is
+// built under a temporary name and renamed into place, so until the old
+// segment is gone a crash leaves an overlap that the next open repairs.
+func rewriteSegment(dir string, segment Segment, offset int, logger *slog.Logger) (Segment, error) {
+	old, err := NewLogReadOnly(segment.Path, segment.BaseOffset)
+	if err != nil {
+		return Segment{}, fmt.Errorf("unable to open log segment %s in read only: %w", segment.Path, err)
+	}
+	defer old.Close()
+
+	path := filepath.Join(dir, newLogNameFromInt(offset).string())
+	tmpPath := path + ".tmp"
+	if err := removeSegment(Segment{Path: tmpPath}); err != nil {
+		return Segment{}, err
+	}
+
+	rewritten, err := newLog(tmpPath, offset, 4096, true, false, -1, logger)
+	if err != nil {
+		return Segment{}, err
+	}
+	err = old.copyTo(rewritten, int64(offset-segment.BaseOffset))
+	if err := errors.Join(err, rewritten.Close()); err != nil {
+		return Segment{}, fmt.Errorf("failed to rewrite segment %s: %w", segment.Path, err)
+	}
+
+	// The index goes first, a segment exists as soon as its log file does
+	if err := os.Rename(tmpPath+".index", path+".index"); err != nil {
+		return Segment{}, err
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return Segment{}, err
+	}
+	if err := syncDir(dir); err != nil {
+		return Segment{}, err
+	}
+
+	if err := removeSegment(segment); err != nil {
+		return Segment{}, err
+	}
+	if err := syncDir(dir); err != nil {
+		return Segment{}, err
+	}
+
+	return Segment{BaseOffset: offset, Path: path}, nil
+}
+
+// removeSegment deletes the log file of segment, its index and its seal.
+func removeSegment(segment Segment) error {
+	for _, path := range []string{segment.Path, segment.Path + ".index", segment.Path + sealSuffix} {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to remove %s: %w", path, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,145 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newTruncateTestPartition(t *testing.T, records int) (*Partition, string) {
+	t.Helper()
+
+	dir := filepath.Join(t.TempDir(), "partition/")
+	config := DefaultPartitionConfig()
+	config.MaxSegmentRecords = 100
+	p, err := NewPartitionWithConfig(dir, config)
+	require.NoError(t, err)
+	t.Cleanup(func() { p.Close() })
+
+	for i := range records {
+		require.NoError(t, p.Append(fmt.Appendf(nil, "data %d", i)))
+	}
+	return p, dir
+}
+
+func requireRecord(t *testing.T, p *Partition, offset int, payload string) {
+	t.Helper()
+	record, err := p.Read(offset)
+	require.NoError(t, err)
+	require.Equal(t, payload, string(record.Payload))
+}
+
+func TestPartition_TruncateTo(t *testing.T) {
+	t.Run("drops later segments and cuts the one holding offset", func(t *testing.T) {
+		p, dir := newTruncateTestPartition(t, 350)
+
+		require.NoError(t, p.TruncateTo(150))
+		require.Equal(t, 150, p.nextOffset)
+		require.Len(t, p.segments, 2)
+		require.NoFileExists(t, filepath.Join(dir, newLogNameFromInt(200).string()))
+		require.NoFileExists(t, filepath.Join(dir, newLogNameFromInt(300).string()+".index"))
+
+		requireRecord(t, p, 149, "data 149")
+		_, err := p.Read(150)
+		require.Error(t, err)
+
+		require.NoError(t, p.Append([]byte("replacement")))
+		requireRecord(t, p, 150, "replacement")
+
+		// Survives a reopen
+		require.NoError(t, p.Close())
+		p, err = NewPartitionWithConfig(dir, p.config)
+		require.NoError(t, err)
+		defer p.Close()
+		require.Equal(t, 151, p.nextOffset)
+		requireRecord(t, p, 150, "replacement")
+	})
+	t.Run("to a segment boundary", func(t *testing.T) {
+		p, _ := newTruncateTestPartition(t, 250)
+
+		require.NoError(t, p.TruncateTo(200))
+		require.Equal(t, 200, p.nextOffset)
+		require.Len(t, p.segments, 3)
+
+		require.NoError(t, p.Append([]byte("replacement")))
+		requireRecord(t, p, 200, "replacement")
+	})
+	t.Run("past the end is a no-op", func(t *testing.T) {
+		p, _ := newTruncateTestPartition(t, 50)
+
+		require.NoError(t, p.TruncateTo(80))
+		require.Equal(t, 50, p.nextOffset)
+	})
+}
+
+func TestPartition_TruncateBefore(t *testing.T) {
+	t.Run("drops earlier segments and rewrites the one holding offset", func(t *testing.T) {
+		p, dir := newTruncateTestPartition(t, 350)
+
+		require.Equal(t, 0, p.FirstOffset())
+		require.NoError(t, p.TruncateBefore(150))
+		require.Equal(t, 150, p.FirstOffset())
+		require.Len(t, p.segments, 3)
+		require.Equal(t, 150, p.segments[0].BaseOffset)
+		require.NoFileExists(t, filepath.Join(dir, newLogNameFromInt(0).string()))
+		require.NoFileExists(t, filepath.Join(dir, newLogNameFromInt(100).string()))
+		require.NoFileExists(t, filepath.Join(dir, newLogNameFromInt(150).string()+".tmp"))
+
+		requireRecord(t, p, 150, "data 150")
+		requireRecord(t, p, 199, "data 199")
+		requireRecord(t, p, 349, "data 349")
+
+		// Survives a reopen, including the recovery scan
+		require.NoError(t, p.Close())
+		require.NoError(t, os.Remove(filepath.Join(dir, checkpointFileName)))
+		p, err := NewPartitionWithConfig(dir, p.config)
+		require.NoError(t, err)
+		defer p.Close()
+		require.Equal(t, 350, p.nextOffset)
+		requireRecord(t, p, 150, "data 150")
+	})
+	t.Run("inside the active segment", func(t *testing.T) {
+		p, _ := newTruncateTestPartition(t, 250)
+
+		require.NoError(t, p.TruncateBefore(220))
+		require.Len(t, p.segments, 1)
+		require.Equal(t, 220, p.segments[0].BaseOffset)
+		requireRecord(t, p, 249, "data 249")
+
+		require.NoError(t, p.Append([]byte("data 250")))
+		requireRecord(t, p, 250, "data 250")
+	})
+	t.Run("everything", func(t *testing.T) {
+		p, _ := newTruncateTestPartition(t, 250)
+
+		require.NoError(t, p.TruncateBefore(250))
+		require.Len(t, p.segments, 1)
+		require.Equal(t, 250, p.segments[0].BaseOffset)
+
+		require.NoError(t, p.Append([]byte("data 250")))
+		requireRecord(t, p, 250, "data 250")
+	})
+	t.Run("keeps expiry", func(t *testing.T) {
+		p, _ := newTruncateTestPartition(t, 0)
+		require.NoError(t, p.Append([]byte("data 0")))
+		require.NoError(t, p.AppendWithTTL([]byte("data 1"), time.Hour))
+
+		before, err := p.Read(1)
+		require.NoError(t, err)
+		require.NoError(t, p.TruncateBefore(1))
+
+		after, err := p.Read(1)
+		require.NoError(t, err)
+		require.Equal(t, before.Header.Timestamp, after.Header.Timestamp)
+		require.Equal(t, before.Header.ExpiresAt, after.Header.ExpiresAt)
+	})
+	t.Run("past the end", func(t *testing.T) {
+		p, _ := newTruncateTestPartition(t, 50)
+
+		require.Error(t, p.TruncateBefore(51))
+	})
+}
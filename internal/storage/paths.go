@@ -0,0 +1,92 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Paths lists every directory brook writes to. Only Data is required: Meta
+// defaults to it, so a container with a read-only root filesystem needs a
+// single writable volume mounted at Data. Brook has no scratch space of its
+// own: the temporary files it writes are renamed into place, so they are
+// created next to their target, on the same filesystem.
+type Paths struct {
+	// Data holds one directory per partition with its segments and indexes.
+	Data string
+	// Meta holds per-partition metadata such as checkpoints.
+	Meta string
+}
+
+func (p Paths) withDefaults() Paths {
+	if p.Meta == "" {
+		p.Meta = p.Data
+	}
+	return p
+}
+
+// Validate makes sure every directory exists (creating it if needed) and can
+// be written to. Call it at startup to fail fast on a read-only or missing
+// mount rather than on the first append.
+func (p Paths) Validate() error {
+	if p.Data == "" {
+		return errors.New("data path is required")
+	}
+
+	p = p.withDefaults()
+	for _, dir := range []struct{ name, path string }{
+		{"data", p.Data},
+		{"metadata", p.Meta},
+	} {
+		if err := ensureWritableDir(dir.path); err != nil {
+			return fmt.Errorf("%s path: %w", dir.name, err)
+		}
+	}
+	return nil
+}
+
+// OpenPartition opens the partition called name, keeping its segments under
+// Data and its metadata under Meta.
+func (p Paths) OpenPartition(name string, config PartitionConfig) (*Partition, error) {
+	if p.Data == "" {
+		return nil, errors.New("data path is required")
+	}
+	if name == "" || name != filepath.Base(name) {
+		return nil, fmt.Errorf("invalid partition name %q", name)
+	}
+
+	p = p.withDefaults()
+	config.MetaDir = filepath.Join(p.Meta, name)
+	return NewPartitionWithConfig(filepath.Join(p.Data, name), config)
+}
+
+// ensureDir checks that dir exists and is a directory.
+func ensureDir(dir string) error {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", dir)
+	}
+	return nil
+}
+
+// ensureWritableDir creates dir if needed and checks that files can be
+// created in it.
+func ensureWritableDir(dir string) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+
+	if err := ensureDir(dir); err != nil {
+		return err
+	}
+
+	probe, err := os.CreateTemp(dir, ".brook-probe-*")
+	if err != nil {
+		return fmt.Errorf("%s is not writable: %w", dir, err)
+	}
+	return errors.Join(probe.Close(), os.Remove(probe.Name()))
+}
@@ -0,0 +1,70 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPaths_Validate(t *testing.T) {
+	t.Run("creates missing directories", func(t *testing.T) {
+		root := t.TempDir()
+		paths := Paths{
+			Data: filepath.Join(root, "data"),
+			Meta: filepath.Join(root, "meta"),
+		}
+
+		require.NoError(t, paths.Validate())
+		require.DirExists(t, paths.Data)
+		require.DirExists(t, paths.Meta)
+
+		entries, err := os.ReadDir(paths.Data)
+		require.NoError(t, err)
+		require.Empty(t, entries, "probe file must be cleaned up")
+	})
+	t.Run("data path is required", func(t *testing.T) {
+		require.Error(t, Paths{Meta: t.TempDir()}.Validate())
+	})
+	t.Run("path is a file", func(t *testing.T) {
+		file := filepath.Join(t.TempDir(), "file")
+		require.NoError(t, os.WriteFile(file, nil, 0o644))
+
+		err := Paths{Data: t.TempDir(), Meta: file}.Validate()
+		require.ErrorContains(t, err, "metadata path")
+	})
+}
+
+func TestPaths_OpenPartition(t *testing.T) {
+	t.Run("metadata goes to the meta path", func(t *testing.T) {
+		root := t.TempDir()
+		paths := Paths{
+			Data: filepath.Join(root, "data"),
+			Meta: filepath.Join(root, "meta"),
+		}
+
+		p, err := paths.OpenPartition("orders-0", DefaultPartitionConfig())
+		require.NoError(t, err)
+		require.NoError(t, p.Append([]byte("data")))
+		require.NoError(t, p.Close())
+
+		require.FileExists(t, filepath.Join(paths.Data, "orders-0", newLogNameFromInt(0).string()))
+		require.FileExists(t, filepath.Join(paths.Meta, "orders-0", checkpointFileName))
+		require.NoFileExists(t, filepath.Join(paths.Data, "orders-0", checkpointFileName))
+
+		p, err = paths.OpenPartition("orders-0", DefaultPartitionConfig())
+		require.NoError(t, err)
+		defer p.Close()
+		require.Equal(t, 1, p.nextOffset)
+		require.NoFileExists(t, filepath.Join(paths.Meta, "orders-0", checkpointFileName))
+	})
+	t.Run("invalid partition name", func(t *testing.T) {
+		paths := Paths{Data: t.TempDir()}
+
+		_, err := paths.OpenPartition("../escape", DefaultPartitionConfig())
+		require.Error(t, err)
+		_, err = paths.OpenPartition("", DefaultPartitionConfig())
+		require.Error(t, err)
+	})
+}
@@ -0,0 +1,22 @@
+//go:build linux
+
+package storage
+
+import (
+	"os"
+	"syscall"
+)
+
+// FALLOC_FL_KEEP_SIZE from linux/falloc.h
+const fallocKeepSize = 0x1
+
+// fallocate reserves disk blocks for the first size bytes of f without
+// changing its size.
+func fallocate(f *os.File, size int64) error {
+	for {
+		err := syscall.Fallocate(int(f.Fd()), fallocKeepSize, 0, size)
+		if err != syscall.EINTR {
+			return err
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// allocatedBytes returns how much disk the file at path takes up.
+func allocatedBytes(t *testing.T, path string) int64 {
+	t.Helper()
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	return info.Sys().(*syscall.Stat_t).Blocks * 512
+}
+
+func TestPartition_PreallocateSegments(t *testing.T) {
+	partitionDir := t.TempDir()
+	probe, err := os.Create(filepath.Join(t.TempDir(), "probe"))
+	require.NoError(t, err)
+	defer probe.Close()
+	if err := fallocate(probe, 4096); err != nil {
+		t.Skipf("filesystem can't preallocate: %v", err)
+	}
+
+	config := DefaultPartitionConfig()
+	config.MaxSegmentBytes = 1 << 20
+	config.MaxSegmentRecords = 10
+	config.PreallocateSegments = true
+	p, err := NewPartitionWithConfig(partitionDir, config)
+	require.NoError(t, err)
+
+	for i := range 15 {
+		require.NoError(t, p.Append(fmt.Appendf(nil, "record %d", i)))
+	}
+
+	sealed := filepath.Join(partitionDir, newLogNameFromInt(0).string())
+	active := filepath.Join(partitionDir, newLogNameFromInt(10).string())
+
+	// The active segment has its disk reserved but keeps its size
+	require.GreaterOrEqual(t, allocatedBytes(t, active), config.MaxSegmentBytes)
+	info, err := os.Stat(active)
+	require.NoError(t, err)
+	require.Equal(t, p.activeLog.Size(), info.Size())
+
+	// Rotation gave back what the sealed one didn't use
+	require.Less(t, allocatedBytes(t, sealed), config.MaxSegmentBytes)
+
+	require.NoError(t, p.Close())
+	require.Less(t, allocatedBytes(t, active), config.MaxSegmentBytes)
+
+	p, err = NewPartitionWithConfig(partitionDir, config)
+	require.NoError(t, err)
+	defer p.Close()
+	for i := range 15 {
+		record, err := p.Read(i)
+		require.NoError(t, err)
+		require.Equal(t, fmt.Sprintf("record %d", i), string(record.Payload))
+	}
+}
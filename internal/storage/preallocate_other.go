@@ -0,0 +1,13 @@
+//go:build !linux
+
+package storage
+
+import (
+	"errors"
+	"os"
+)
+
+// fallocate needs fallocate(2), which is only wired up on Linux.
+func fallocate(f *os.File, size int64) error {
+	return errors.ErrUnsupported
+}
@@ -0,0 +1,71 @@
+package storage
+
+import "sync"
+
+// BufferPool hands out payload buffers to Readers, so consumers that come and
+// go share their buffers instead of each growing its own. It is safe for
+// concurrent use.
+type BufferPool struct {
+	pool sync.Pool
+}
+
+// get returns a buffer from the pool, nil if it is empty.
+func (bp *BufferPool) get() []byte {
+	if buf, ok := bp.pool.Get().(*[]byte); ok {
+		return *buf
+	}
+	return nil
+}
+
+func (bp *BufferPool) put(buf []byte) {
+	if cap(buf) == 0 {
+		return
+	}
+	bp.pool.Put(&buf)
+}
+
+type ReaderConfig struct {
+	// Buffer is what payloads are read into. It is replaced by a larger one
+	// the first time a payload doesn't fit.
+	Buffer []byte
+	// Pool, when set and Buffer isn't, is where the reader takes its buffer
+	// from. Close gives it back.
+	Pool *BufferPool
+}
+
+// Reader reads records from a partition into one buffer it keeps reusing,
+// for consumers that are done with a record before they read the next one.
+// The payload of a record it returns is only valid until the next Read or
+// Close. A Reader is not safe for concurrent use.
+type Reader struct {
+	p    *Partition
+	buf  []byte
+	pool *BufferPool
+}
+
+func (p *Partition) NewReader(config ReaderConfig) *Reader {
+	r := &Reader{p: p, buf: config.Buffer, pool: config.Pool}
+	if r.buf == nil && r.pool != nil {
+		r.buf = r.pool.get()
+	}
+	return r
+}
+
+// Read returns the record at offset, like Partition.Read.
+func (r *Reader) Read(offset int) (Record, error) {
+	record, err := r.p.ReadInto(offset, r.buf)
+	if cap(record.Payload) > cap(r.buf) {
+		// Grown for a large payload, keep it for the next one
+		r.buf = record.Payload[:0]
+	}
+	return record, err
+}
+
+// Close gives the reader's buffer back to its pool, if it has one. The reader
+// must not be used afterwards.
+func (r *Reader) Close() {
+	if r.pool != nil {
+		r.pool.put(r.buf)
+	}
+	r.buf = nil
+}
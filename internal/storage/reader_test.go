@@ -0,0 +1,72 @@
+package storage
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestReader(t *testing.T) {
+	newReaderPartition := func(t *testing.T, payloads ...string) *Partition {
+		t.Helper()
+		p, err := NewPartition(filepath.Join(t.TempDir(), "partition"))
+		require.NoError(t, err)
+		t.Cleanup(func() { p.Close() })
+		for _, payload := range payloads {
+			require.NoError(t, p.Append([]byte(payload)))
+		}
+		return p
+	}
+
+	t.Run("reads into the caller's buffer", func(t *testing.T) {
+		p := newReaderPartition(t, "first", "second")
+		buf := make([]byte, 0, 64)
+
+		record, err := p.ReadInto(1, buf)
+		require.NoError(t, err)
+		require.Equal(t, "second", string(record.Payload))
+		require.Same(t, &buf[:1][0], &record.Payload[0])
+
+		r := p.NewReader(ReaderConfig{Buffer: buf})
+		record, err = r.Read(0)
+		require.NoError(t, err)
+		require.Equal(t, "first", string(record.Payload))
+		require.Same(t, &buf[:1][0], &record.Payload[0])
+	})
+
+	t.Run("grows the buffer for a large payload and keeps it", func(t *testing.T) {
+		large := strings.Repeat("x", 1000)
+		p := newReaderPartition(t, large, "small")
+		r := p.NewReader(ReaderConfig{Buffer: make([]byte, 0, 8)})
+
+		record, err := r.Read(0)
+		require.NoError(t, err)
+		require.Equal(t, large, string(record.Payload))
+		grown := &record.Payload[0]
+
+		record, err = r.Read(1)
+		require.NoError(t, err)
+		require.Equal(t, "small", string(record.Payload))
+		require.Same(t, grown, &record.Payload[0])
+	})
+
+	t.Run("pooled buffers go back on close", func(t *testing.T) {
+		p := newReaderPartition(t, "pooled")
+		var pool BufferPool
+
+		r := p.NewReader(ReaderConfig{Pool: &pool})
+		record, err := r.Read(0)
+		require.NoError(t, err)
+		require.Equal(t, "pooled", string(record.Payload))
+		r.Close()
+
+		// sync.Pool may drop what it's given, only check the buffer is usable
+		r = p.NewReader(ReaderConfig{Pool: &pool})
+		defer r.Close()
+		record, err = r.Read(0)
+		require.NoError(t, err)
+		require.Equal(t, "pooled", string(record.Payload))
+	})
+}
@@ -2,17 +2,82 @@ package storage
 
 import (
 	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"math"
+	"time"
 )
 
 const (
-	// Offset(8) + Size(8) + Timestamp(8) = 24 bytes
-	HeaderSize = 24
+	// The record header of segment format version 2 (see SegmentFormatVersion):
+	//
+	//	Offset(8) + Size(8) + Timestamp(8) + ExpiresAt(8) + Checksum(4) = 36 bytes
+	//
+	// Checksum is the CRC32-C of the payload followed by the fields before it.
+	HeaderSize = 36
+
+	checksumPos = 32
+
+	// streamingPayloadSize is the size in the header of a record whose payload
+	// is still being streamed. It runs past the end of any log, so scans treat
+	// the record as torn until the real size is written.
+	streamingPayloadSize = math.MaxUint64
+
+	// DefaultMaxRecordBytes is the largest payload a log takes unless it is
+	// configured otherwise.
+	DefaultMaxRecordBytes = 16 << 20 // 16MiB
 )
 
+var (
+	ErrRecordExpired    = errors.New("record has expired")
+	ErrChecksumMismatch = errors.New("record checksum mismatch")
+	ErrRecordTooLarge   = errors.New("record too large")
+	// ErrRecordCorrupt is returned for a record whose header can't be right,
+	// such as one claiming a payload larger than any the log takes.
+	ErrRecordCorrupt = errors.New("record corrupt")
+	// ErrRecordOverLimit is returned by Partition.ReadWithin for a record
+	// whose payload is over the limit it was given.
+	ErrRecordOverLimit = errors.New("record over the read limit")
+)
+
+// RecordTooLargeError is returned for a payload larger than the log takes. It
+// matches ErrRecordTooLarge.
+type RecordTooLargeError struct {
+	Size  int64
+	Limit int64
+}
+
+func (e *RecordTooLargeError) Error() string {
+	return fmt.Sprintf("record too large: %d bytes, at most %d", e.Size, e.Limit)
+}
+
+func (e *RecordTooLargeError) Is(target error) bool {
+	return target == ErrRecordTooLarge
+}
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
 type RecordHeader struct {
 	LogicalOffset uint64
 	PayloadSize   uint64
 	Timestamp     uint64
+	ExpiresAt     uint64 // unix nanoseconds, 0 if the record never expires
+	Checksum      uint32 // CRC32-C of the other header fields and the payload
+}
+
+// Expired reports whether the record's TTL has run out at now.
+func (h *RecordHeader) Expired(now time.Time) bool {
+	return h.ExpiresAt != 0 && uint64(now.UnixNano()) >= h.ExpiresAt
+}
+
+// expiresAt returns the ExpiresAt of a record written at now that should live
+// for ttl. A ttl of 0 means forever.
+func expiresAt(now time.Time, ttl time.Duration) uint64 {
+	if ttl <= 0 {
+		return 0
+	}
+	return uint64(now.Add(ttl).UnixNano())
 }
 
 type Record struct {
@@ -30,10 +95,42 @@ func (h *RecordHeader) Encode(dst []byte) {
 	binary.BigEndian.PutUint64(dst[0:8], h.LogicalOffset)
 	binary.BigEndian.PutUint64(dst[8:16], h.PayloadSize)
 	binary.BigEndian.PutUint64(dst[16:24], h.Timestamp)
+	binary.BigEndian.PutUint64(dst[24:32], h.ExpiresAt)
+	binary.BigEndian.PutUint32(dst[32:36], h.Checksum)
+}
+
+// encodeWithChecksum sets the checksum of h for payload and encodes h.
+func (h *RecordHeader) encodeWithChecksum(dst []byte, payload []byte) {
+	h.Encode(dst)
+	h.Checksum = checksum(dst, payload)
+	binary.BigEndian.PutUint32(dst[checksumPos:HeaderSize], h.Checksum)
+}
+
+// verify checks payload and the header fields against the checksum.
+func (h *RecordHeader) verify(payload []byte) error {
+	var buf [HeaderSize]byte
+	h.Encode(buf[:])
+	if checksum(buf[:], payload) != h.Checksum {
+		return ErrChecksumMismatch
+	}
+	return nil
+}
+
+// checksum covers the payload first and the header fields after it, so that
+// a payload can be checksummed while it is streamed, before its size is known.
+func checksum(encodedHeader []byte, payload []byte) uint32 {
+	return finishChecksum(crc32.Checksum(payload, crcTable), encodedHeader)
+}
+
+// finishChecksum adds the header fields to the checksum of a payload.
+func finishChecksum(payloadCRC uint32, encodedHeader []byte) uint32 {
+	return crc32.Update(payloadCRC, crcTable, encodedHeader[:checksumPos])
 }
 
 func (h *RecordHeader) Decode(src []byte) {
 	h.LogicalOffset = binary.BigEndian.Uint64(src[0:8])
 	h.PayloadSize = binary.BigEndian.Uint64(src[8:16])
 	h.Timestamp = binary.BigEndian.Uint64(src[16:24])
+	h.ExpiresAt = binary.BigEndian.Uint64(src[24:32])
+	h.Checksum = binary.BigEndian.Uint32(src[32:36])
 }
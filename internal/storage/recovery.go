@@ -0,0 +1,159 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+)
+
+var ErrPartitionReadOnly = errors.New("partition is opened read only")
+
+// OpenMode controls how much a partition trusts, and repairs, what it finds on
+// disk when it is opened.
+type OpenMode int
+
+const (
+	// OpenNormal recovers the partition: a torn record at the end of the active
+	// segment is cut off, a segment overlapping the next one is truncated and a
+	// gap between segments fails the open.
+	OpenNormal OpenMode = iota
+	// OpenReadOnly never writes to the partition. Problems are looked for and
+	// reported but left on disk as they are, and appends fail with
+	// ErrPartitionReadOnly.
+	OpenReadOnly
+	// OpenForce skips the segment validation and opens whatever is on disk.
+	// The torn tail of the active segment is still cut off, appends can't go
+	// behind it.
+	OpenForce
+)
+
+func (m OpenMode) String() string {
+	switch m {
+	case OpenNormal:
+		return "normal"
+	case OpenReadOnly:
+		return "read-only"
+	case OpenForce:
+		return "force"
+	default:
+		return fmt.Sprintf("OpenMode(%d)", int(m))
+	}
+}
+
+// RecoveryReport describes what a partition found, and what it did about it,
+// while being opened.
+type RecoveryReport struct {
+	Mode OpenMode
+	// CleanShutdown is set when the checkpoint of a clean close matched the
+	// segments on disk, so nothing had to be scanned.
+	CleanShutdown bool
+	// TornTailBytes is the size of the partially written record found at the
+	// end of the active segment. It is cut off unless the partition is opened
+	// read only.
+	TornTailBytes int64
+	// Overlaps lists segments holding records that are also in the segment
+	// after them. In normal mode those records were dropped from the older
+	// segment.
+	Overlaps []SegmentOverlap
+	// Gaps lists offset ranges missing between segments. Only a read only
+	// open gets past a gap; normal mode fails and force mode doesn't look.
+	Gaps []SegmentGap
+	// ScannedSegments counts the segments before the active one that had to
+	// be scanned to find where they end, because they had no seal matching
+	// them. The others were only looked up.
+	ScannedSegments int
+}
+
+type SegmentOverlap struct {
+	Path    string
+	Records int64 // number of records shared with the next segment
+}
+
+// SegmentGap is a range of missing offsets, From inclusive and To exclusive.
+type SegmentGap struct {
+	From int
+	To   int
+}
+
+// checkSegments checks that every sealed segment ends exactly where the next
+// one begins and adds what it finds to report. In normal mode an overlap is
+// repaired by truncating the older segment, the newer one was written later
+// and wins. A gap means records are missing and can't be repaired, so normal
+// mode fails on it.
+func checkSegments(segments []Segment, mode OpenMode, report *RecoveryReport, logger *slog.Logger) error {
+	for i := 0; i < len(segments)-1; i++ {
+		cur, next := segments[i], segments[i+1]
+
+		end, scanned, err := segmentEnd(cur)
+		if err != nil {
+			return err
+		}
+		if scanned {
+			report.ScannedSegments++
+		}
+
+		if end < next.BaseOffset {
+			if mode == OpenNormal {
+				return fmt.Errorf("%w: segment %s ends at offset %d but the next one starts at %d",
+					ErrSegmentGap, cur.Path, end, next.BaseOffset)
+			}
+			report.Gaps = append(report.Gaps, SegmentGap{From: end, To: next.BaseOffset})
+			logger.Warn("offsets missing between segments", "from", end, "to", next.BaseOffset)
+		}
+		if end > next.BaseOffset {
+			report.Overlaps = append(report.Overlaps, SegmentOverlap{
+				Path:    cur.Path,
+				Records: int64(end - next.BaseOffset),
+			})
+			if mode != OpenNormal {
+				logger.Warn("segment overlaps the next one", "segment", cur.Path, "records", end-next.BaseOffset)
+				continue
+			}
+			logger.Warn("truncating segment that overlaps the next one", "segment", cur.Path, "records", end-next.BaseOffset)
+			if err := truncateSegment(cur, int64(next.BaseOffset-cur.BaseOffset), logger); err != nil {
+				return fmt.Errorf("failed to repair overlapping segment %s: %w", cur.Path, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// segmentEnd returns the offset after the last record of segment. A sealed
+// segment still the size it was sealed at is taken at its seal's word, so
+// finding its end costs a stat and the read of its seal. Any other is opened
+// and scanned from its last index entry, and scanned is set.
+func segmentEnd(segment Segment) (end int, scanned bool, err error) {
+	if seal, ok := readSeal(segment.Path); ok {
+		info, err := os.Stat(segment.Path)
+		if err != nil {
+			return 0, false, err
+		}
+		if info.Size() == seal.Size {
+			return segment.BaseOffset + int(seal.Records), false, nil
+		}
+	}
+
+	l, err := NewLogReadOnly(segment.Path, segment.BaseOffset)
+	if err != nil {
+		return 0, false, fmt.Errorf("unable to open log segment %s in read only: %w", segment.Path, err)
+	}
+	end = segment.BaseOffset + int(l.NextOffset())
+	return end, true, l.Close()
+}
+
+func truncateSegment(segment Segment, records int64, logger *slog.Logger) error {
+	l, err := newLog(segment.Path, segment.BaseOffset, 4096, true, false, -1, logger)
+	if err != nil {
+		return err
+	}
+
+	return errors.Join(l.truncate(records), l.Close())
+}
+
+// RecoveryReport returns what was found and repaired when the partition was
+// opened.
+func (p *Partition) RecoveryReport() RecoveryReport {
+	return p.recovery
+}
@@ -0,0 +1,72 @@
+package storage
+
+import (
+	"fmt"
+	"time"
+)
+
+// DeleteSegmentsBefore deletes the sealed segments whose records are all
+// timestamped before cutoff, oldest first, and returns how many records went
+// with them. Retention only ever drops whole segments: the active one, and
+// the first one holding a record timestamped at or after cutoff, are kept with
+// every record in them. Like TruncateBefore it isn't supported on a tiered
+// partition.
+func (p *Partition) DeleteSegmentsBefore(cutoff time.Time) (int, error) {
+	p.mu.RLock()
+	if p.closed {
+		p.mu.RUnlock()
+		return 0, ErrPartitionClosed
+	}
+	segments := append([]Segment(nil), p.segments...)
+	p.mu.RUnlock()
+
+	// Timestamps picked by producers needn't go up, the last record of a
+	// segment isn't always its newest one
+	keep := segments[0].BaseOffset
+	for i := 0; i+1 < len(segments); i++ {
+		newest, err := p.segmentMaxTimestamp(segments[i])
+		if err != nil {
+			return 0, fmt.Errorf("failed to find the newest record of segment %s: %w", segments[i].Path, err)
+		}
+		if !newest.Before(cutoff) {
+			break
+		}
+		keep = segments[i+1].BaseOffset
+	}
+	if keep == segments[0].BaseOffset {
+		return 0, nil
+	}
+
+	// Segments deleted in the meantime, by another retention pass for
+	// instance, make this drop fewer records than counted
+	if err := p.TruncateBefore(keep); err != nil {
+		return 0, err
+	}
+	return keep - segments[0].BaseOffset, nil
+}
+
+// segmentMaxTimestamp returns the newest timestamp of the records of segment,
+// see Log.MaxTimestamp.
+func (p *Partition) segmentMaxTimestamp(segment Segment) (time.Time, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.closed {
+		return time.Time{}, ErrPartitionClosed
+	}
+	return p.maxTimestampOf(segment)
+}
+
+// maxTimestampOf is segmentMaxTimestamp for a caller holding p.mu for
+// reading. The active segment is asked through the active log, which keeps
+// track of its newest timestamp as records are appended.
+func (p *Partition) maxTimestampOf(segment Segment) (time.Time, error) {
+	if segment.BaseOffset == p.segments[len(p.segments)-1].BaseOffset {
+		return p.activeLog.MaxTimestamp()
+	}
+	l, done, err := p.openSegment(segment)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer done()
+	return l.MaxTimestamp()
+}
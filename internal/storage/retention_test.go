@@ -0,0 +1,105 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPartition_DeleteSegmentsBefore(t *testing.T) {
+	p, _ := newTruncateTestPartition(t, 250)
+	// Segments of 100 records: [0, 100) and [100, 200) were written before
+	// cutoff, the active one [200, 250) partly after
+	time.Sleep(5 * time.Millisecond)
+	cutoff := time.Now()
+	for i := 250; i < 260; i++ {
+		require.NoError(t, p.Append(fmt.Appendf(nil, "data %d", i)))
+	}
+
+	deleted, err := p.DeleteSegmentsBefore(cutoff.Add(-time.Hour))
+	require.NoError(t, err)
+	require.Zero(t, deleted)
+	require.Equal(t, 0, p.FirstOffset())
+
+	deleted, err = p.DeleteSegmentsBefore(cutoff)
+	require.NoError(t, err)
+	require.Equal(t, 200, deleted)
+	require.Equal(t, 200, p.FirstOffset())
+	requireRecord(t, p, 200, "data 200")
+
+	// The active segment stays, however old
+	deleted, err = p.DeleteSegmentsBefore(time.Now().Add(time.Hour))
+	require.NoError(t, err)
+	require.Zero(t, deleted)
+	require.Equal(t, 200, p.FirstOffset())
+	require.Equal(t, 260, p.NextOffset())
+}
+
+func TestPartition_DeleteSegmentsBefore_CreateTime(t *testing.T) {
+	config := DefaultPartitionConfig()
+	config.MaxSegmentRecords = 2
+	p, err := NewPartitionWithConfig(t.TempDir(), config)
+	require.NoError(t, err)
+	defer p.Close()
+
+	ctx := context.Background()
+	cutoff := time.Now().Add(-time.Hour)
+	// The first segment ends with an old record, but holds a newer one
+	require.NoError(t, p.AppendAt(ctx, []byte("late"), cutoff.Add(time.Minute)))
+	require.NoError(t, p.AppendAt(ctx, []byte("early"), cutoff.Add(-time.Minute)))
+	require.NoError(t, p.Append([]byte("now")))
+
+	deleted, err := p.DeleteSegmentsBefore(cutoff)
+	require.NoError(t, err)
+	require.Zero(t, deleted)
+
+	deleted, err = p.DeleteSegmentsBefore(cutoff.Add(2 * time.Minute))
+	require.NoError(t, err)
+	require.Equal(t, 2, deleted)
+
+	record, err := p.Read(2)
+	require.NoError(t, err)
+	require.WithinDuration(t, time.Now(), time.Unix(0, int64(record.Header.Timestamp)), time.Minute)
+}
+
+func TestPartition_RecordTimestamps(t *testing.T) {
+	dir := t.TempDir()
+	config := DefaultPartitionConfig()
+	config.MaxSegmentRecords = 2
+	config.MaxSegmentAge = time.Hour
+	p, err := NewPartitionWithConfig(dir, config)
+	require.NoError(t, err)
+	for i := range 3 {
+		require.NoError(t, p.Append(fmt.Appendf(nil, "data %d", i)))
+	}
+	require.NoError(t, p.Close())
+
+	// Restored from a backup, the files all look a day old
+	segments, err := listSegments(dir)
+	require.NoError(t, err)
+	require.Len(t, segments, 2)
+	restored := time.Now().Add(-24 * time.Hour)
+	for _, segment := range segments {
+		require.NoError(t, os.Chtimes(segment.Path, restored, restored))
+	}
+
+	p, err = NewPartitionWithConfig(dir, config)
+	require.NoError(t, err)
+	defer p.Close()
+
+	t.Run("retention goes by the seal", func(t *testing.T) {
+		deleted, err := p.DeleteSegmentsBefore(time.Now().Add(-time.Hour))
+		require.NoError(t, err)
+		require.Zero(t, deleted)
+	})
+	t.Run("segment age goes by the first record", func(t *testing.T) {
+		require.NoError(t, p.Append([]byte("data 3")))
+		segments, err := listSegments(dir)
+		require.NoError(t, err)
+		require.Len(t, segments, 2)
+	})
+}
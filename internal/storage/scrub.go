@@ -0,0 +1,381 @@
+package storage
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/mvaleed/brook/internal/metrics"
+)
+
+const scrubManifestFileName = "scrub.json"
+
+var (
+	ErrSegmentCorrupt = errors.New("segment is corrupt")
+	errScrubStopped   = errors.New("scrubber stopped")
+)
+
+// ScrubConfig controls how a Scrubber goes through the sealed segments of a
+// partition.
+type ScrubConfig struct {
+	// Interval is the pause between two segments being verified.
+	Interval time.Duration
+	// MaxBytesPerSecond caps how fast segments are read, so scrubbing doesn't
+	// compete with producers and consumers for the disk. 0 means no cap.
+	MaxBytesPerSecond int64
+	// OnCorruption is called for every segment that fails verification.
+	OnCorruption func(segment Segment, err error)
+}
+
+// SegmentScrubStatus is the outcome of the last verification of a segment.
+type SegmentScrubStatus struct {
+	BaseOffset int       `json:"base_offset"`
+	VerifiedAt time.Time `json:"verified_at"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// Scrubber slowly re-reads the sealed segments of a partition in the
+// background and verifies every record against its checksum, to find latent
+// sector errors while the data can still be recovered from elsewhere. Segments
+// are verified one at a time, least recently verified first. When each one
+// was last verified, and how that went, is kept in a manifest in the
+// partition's metadata directory.
+type Scrubber struct {
+	p      *Partition
+	config ScrubConfig
+
+	mu       sync.Mutex
+	status   map[int]SegmentScrubStatus
+	verified uint64
+
+	done chan struct{}
+	wg   sync.WaitGroup
+	once sync.Once
+}
+
+func NewScrubber(p *Partition, config ScrubConfig) (*Scrubber, error) {
+	if config.Interval <= 0 {
+		return nil, errors.New("scrub interval must be positive")
+	}
+	if config.MaxBytesPerSecond < 0 {
+		return nil, errors.New("max bytes per second can't be negative")
+	}
+
+	s := &Scrubber{
+		p:      p,
+		config: config,
+		status: make(map[int]SegmentScrubStatus),
+		done:   make(chan struct{}),
+	}
+
+	data, err := os.ReadFile(filepath.Join(p.metaDir, scrubManifestFileName))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to read scrub manifest: %w", err)
+	}
+	if err == nil {
+		var statuses []SegmentScrubStatus
+		if err := json.Unmarshal(data, &statuses); err != nil {
+			return nil, fmt.Errorf("invalid scrub manifest: %w", err)
+		}
+		for _, status := range statuses {
+			s.status[status.BaseOffset] = status
+		}
+	}
+
+	return s, nil
+}
+
+// Start verifies a segment every Interval until Stop is called.
+func (s *Scrubber) Start() {
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		ticker := time.NewTicker(s.config.Interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				// Corruption is logged and reported through OnCorruption already
+				if err := s.ScrubNext(); err != nil && !errors.Is(err, ErrSegmentCorrupt) {
+					s.p.logger.Error("scrub failed", "err", err)
+				}
+			case <-s.done:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops the background loop, interrupting a verification in progress.
+func (s *Scrubber) Stop() {
+	s.once.Do(func() {
+		close(s.done)
+	})
+	s.wg.Wait()
+}
+
+// ScrubNext verifies the sealed segment that went the longest without being
+// verified. It returns an error wrapping ErrSegmentCorrupt if the segment is
+// corrupt. A segment that is truncated or removed while it's being read is
+// skipped.
+func (s *Scrubber) ScrubNext() error {
+	segment, f, size, ok, err := s.next()
+	if err != nil || !ok {
+		return err
+	}
+	defer f.Close()
+
+	verifyErr := verifySegment(f, segment.Path, size, segment.BaseOffset, s.throttle())
+	if errors.Is(verifyErr, errScrubStopped) {
+		return nil
+	}
+
+	// The segment may have changed under us, in which case what we read
+	// says nothing about it
+	s.p.mu.RLock()
+	current := s.p.sealedSegment(segment.BaseOffset)
+	info, statErr := os.Stat(segment.Path)
+	live := make(map[int]bool, len(s.p.segments))
+	for _, segment := range s.p.segments[:len(s.p.segments)-1] {
+		live[segment.BaseOffset] = true
+	}
+	s.p.mu.RUnlock()
+	if current == nil || statErr != nil || info.Size() != size {
+		return nil
+	}
+	if fInfo, err := f.Stat(); err != nil || !os.SameFile(info, fInfo) {
+		return nil
+	}
+
+	status := SegmentScrubStatus{BaseOffset: segment.BaseOffset, VerifiedAt: time.Now()}
+	if verifyErr != nil {
+		status.Error = verifyErr.Error()
+	}
+
+	s.mu.Lock()
+	s.status[segment.BaseOffset] = status
+	s.verified++
+	persistErr := s.persistLocked(live)
+	s.mu.Unlock()
+
+	if verifyErr != nil {
+		verifyErr = fmt.Errorf("%w: %s: %w", ErrSegmentCorrupt, segment.Path, verifyErr)
+		s.p.logger.Error("scrub found a corrupt segment", "segment", segment.Path, "err", verifyErr)
+		if s.config.OnCorruption != nil {
+			s.config.OnCorruption(segment, verifyErr)
+		}
+		return verifyErr
+	}
+	return persistErr
+}
+
+// next picks the segment to verify and opens it, so that it can be read even
+// if it's removed in the meantime.
+func (s *Scrubber) next() (Segment, *os.File, int64, bool, error) {
+	s.p.mu.RLock()
+	defer s.p.mu.RUnlock()
+
+	sealed := s.p.segments[:len(s.p.segments)-1]
+	if len(sealed) == 0 {
+		return Segment{}, nil, 0, false, nil
+	}
+
+	s.mu.Lock()
+	candidate := sealed[0]
+	oldest := s.status[candidate.BaseOffset].VerifiedAt
+	for _, segment := range sealed[1:] {
+		verifiedAt := s.status[segment.BaseOffset].VerifiedAt
+		if verifiedAt.Before(oldest) {
+			candidate, oldest = segment, verifiedAt
+		}
+	}
+	s.mu.Unlock()
+
+	f, err := os.Open(candidate.Path)
+	if err != nil {
+		return Segment{}, nil, 0, false, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return Segment{}, nil, 0, false, err
+	}
+	return candidate, f, info.Size(), true, nil
+}
+
+// throttle returns a function to call after every n bytes read, which sleeps
+// as long as needed to stay under MaxBytesPerSecond.
+func (s *Scrubber) throttle() func(n int) error {
+	start := time.Now()
+	var read int64
+	return func(n int) error {
+		read += int64(n)
+		if s.config.MaxBytesPerSecond > 0 {
+			due := start.Add(time.Duration(float64(read) / float64(s.config.MaxBytesPerSecond) * float64(time.Second)))
+			if wait := time.Until(due); wait > 0 {
+				timer := time.NewTimer(wait)
+				defer timer.Stop()
+				select {
+				case <-timer.C:
+				case <-s.done:
+					return errScrubStopped
+				}
+			}
+		}
+
+		select {
+		case <-s.done:
+			return errScrubStopped
+		default:
+			return nil
+		}
+	}
+}
+
+// Status returns the last verification of every sealed segment that has been
+// verified, oldest segment first.
+func (s *Scrubber) Status() []SegmentScrubStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	statuses := make([]SegmentScrubStatus, 0, len(s.status))
+	for _, status := range s.status {
+		statuses = append(statuses, status)
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].BaseOffset < statuses[j].BaseOffset
+	})
+	return statuses
+}
+
+// Collect implements metrics.Collector.
+func (s *Scrubber) Collect() []metrics.Sample {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	corrupt := 0
+	for _, status := range s.status {
+		if status.Error != "" {
+			corrupt++
+		}
+	}
+
+	labels := map[string]string{"partition": s.p.dir}
+	return []metrics.Sample{
+		{
+			Name:   "brook_scrub_segments_verified_total",
+			Help:   "Number of segment verifications done by the scrubber.",
+			Type:   metrics.Counter,
+			Labels: labels,
+			Value:  float64(s.verified),
+		},
+		{
+			Name:   "brook_scrub_corrupt_segments",
+			Help:   "Segments that failed their last verification.",
+			Type:   metrics.Gauge,
+			Labels: labels,
+			Value:  float64(corrupt),
+		},
+	}
+}
+
+// persistLocked writes the manifest, forgetting segments that aren't in live
+// anymore.
+// Caller must hold s.mu.
+func (s *Scrubber) persistLocked(live map[int]bool) error {
+	statuses := make([]SegmentScrubStatus, 0, len(s.status))
+	for baseOffset, status := range s.status {
+		if !live[baseOffset] {
+			delete(s.status, baseOffset)
+			continue
+		}
+		statuses = append(statuses, status)
+	}
+	if s.p.config.Mode == OpenReadOnly {
+		return nil
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].BaseOffset < statuses[j].BaseOffset
+	})
+
+	data, err := json.Marshal(statuses)
+	if err != nil {
+		return err
+	}
+	if err := WriteFileAtomic(s.p.metaDir, scrubManifestFileName, data); err != nil {
+		return fmt.Errorf("failed to write scrub manifest: %w", err)
+	}
+	return nil
+}
+
+// sealedSegment returns the sealed segment with the given base offset, if any.
+// Caller must hold p.mu for reading.
+func (p *Partition) sealedSegment(baseOffset int) *Segment {
+	sealed := p.segments[:len(p.segments)-1]
+	i := sort.Search(len(sealed), func(i int) bool {
+		return sealed[i].BaseOffset >= baseOffset
+	})
+	if i == len(sealed) || sealed[i].BaseOffset != baseOffset {
+		return nil
+	}
+	return &sealed[i]
+}
+
+// verifySegment reads the first size bytes of a segment record by record and
+// checks that the offsets follow each other, that the headers make sense and
+// that every record matches its checksum. progress is called after every record with its size, and stops
+// the verification when it returns an error.
+func verifySegment(r io.ReaderAt, path string, size int64, baseOffset int, progress func(n int) error) error {
+	if partial, err := checkSegmentHeader(r, size, path); err != nil || partial {
+		return err
+	}
+	reader := bufio.NewReaderSize(io.NewSectionReader(r, SegmentHeaderSize, size-SegmentHeaderSize), 64*1024)
+
+	pos := int64(SegmentHeaderSize)
+	var headerBuf [HeaderSize]byte
+	for expected := uint64(0); pos < size; expected++ {
+		if size-pos < HeaderSize {
+			return fmt.Errorf("torn record at byte %d", pos)
+		}
+		if _, err := io.ReadFull(reader, headerBuf[:]); err != nil {
+			return fmt.Errorf("failed to read header at byte %d: %w", pos, err)
+		}
+
+		var h RecordHeader
+		h.Decode(headerBuf[:])
+		if h.LogicalOffset != expected {
+			return fmt.Errorf("record at byte %d has offset %d, expected %d",
+				pos, baseOffset+int(h.LogicalOffset), baseOffset+int(expected))
+		}
+		if h.PayloadSize > uint64(size-pos-HeaderSize) {
+			return fmt.Errorf("record %d at byte %d runs past the end of the segment", baseOffset+int(expected), pos)
+		}
+		if h.ExpiresAt != 0 && h.ExpiresAt < h.Timestamp {
+			return fmt.Errorf("record %d at byte %d expires before it was written", baseOffset+int(expected), pos)
+		}
+
+		payload := make([]byte, h.PayloadSize)
+		if _, err := io.ReadFull(reader, payload); err != nil {
+			return fmt.Errorf("failed to read payload at byte %d: %w", pos, err)
+		}
+		if err := h.verify(payload); err != nil {
+			return fmt.Errorf("record %d at byte %d: %w", baseOffset+int(expected), pos, err)
+		}
+
+		n := HeaderSize + len(payload)
+		pos += int64(n)
+		if err := progress(n); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,97 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestScrubber_ScrubNext(t *testing.T) {
+	newScrubbedPartition := func(t *testing.T, config ScrubConfig) (*Partition, *Scrubber) {
+		t.Helper()
+
+		partitionConfig := DefaultPartitionConfig()
+		partitionConfig.MaxSegmentRecords = 100
+		p, err := NewPartitionWithConfig(filepath.Join(t.TempDir(), "partition/"), partitionConfig)
+		require.NoError(t, err)
+		t.Cleanup(func() { p.Close() })
+		for i := range 250 {
+			require.NoError(t, p.Append(fmt.Appendf(nil, "data %d", i)))
+		}
+
+		config.Interval = time.Hour
+		s, err := NewScrubber(p, config)
+		require.NoError(t, err)
+		return p, s
+	}
+
+	t.Run("verifies sealed segments least recently verified first", func(t *testing.T) {
+		p, s := newScrubbedPartition(t, ScrubConfig{})
+
+		require.NoError(t, s.ScrubNext())
+		require.NoError(t, s.ScrubNext())
+		status := s.Status()
+		require.Len(t, status, 2)
+		require.Equal(t, 0, status[0].BaseOffset)
+		require.Equal(t, 100, status[1].BaseOffset)
+		require.Empty(t, status[0].Error)
+
+		// Back to the first one
+		require.NoError(t, s.ScrubNext())
+		require.True(t, s.Status()[0].VerifiedAt.After(status[1].VerifiedAt))
+
+		// The manifest outlives the scrubber
+		s, err := NewScrubber(p, ScrubConfig{Interval: time.Hour})
+		require.NoError(t, err)
+		require.Len(t, s.Status(), 2)
+	})
+	t.Run("reports a flipped bit", func(t *testing.T) {
+		var reported []Segment
+		p, s := newScrubbedPartition(t, ScrubConfig{
+			OnCorruption: func(segment Segment, err error) {
+				reported = append(reported, segment)
+			},
+		})
+
+		f, err := os.OpenFile(p.segments[0].Path, os.O_RDWR, 0)
+		require.NoError(t, err)
+		// Into the payload of record 50
+		pos := 10*(HeaderSize+len("data 0")) + 40*(HeaderSize+len("data 10")) + HeaderSize
+		_, err = f.WriteAt([]byte{'X'}, int64(pos))
+		require.NoError(t, err)
+		require.NoError(t, f.Close())
+
+		err = s.ScrubNext()
+		require.ErrorIs(t, err, ErrSegmentCorrupt)
+		require.Equal(t, []Segment{p.segments[0]}, reported)
+		require.Contains(t, s.Status()[0].Error, "record 50")
+
+		_, err = p.Read(50)
+		require.ErrorIs(t, err, ErrChecksumMismatch)
+	})
+	t.Run("nothing to verify without sealed segments", func(t *testing.T) {
+		p, err := NewPartition(filepath.Join(t.TempDir(), "partition/"))
+		require.NoError(t, err)
+		defer p.Close()
+
+		s, err := NewScrubber(p, ScrubConfig{Interval: time.Hour})
+		require.NoError(t, err)
+		require.NoError(t, s.ScrubNext())
+		require.Empty(t, s.Status())
+	})
+	t.Run("throttled and stopped", func(t *testing.T) {
+		_, s := newScrubbedPartition(t, ScrubConfig{MaxBytesPerSecond: 100})
+
+		done := make(chan error)
+		go func() { done <- s.ScrubNext() }()
+		time.Sleep(10 * time.Millisecond)
+		s.Stop()
+
+		require.NoError(t, <-done)
+		require.Empty(t, s.Status())
+	})
+}
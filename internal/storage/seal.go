@@ -0,0 +1,120 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// sealSuffix names the file, next to a segment, that seals it.
+const sealSuffix = ".seal"
+
+// segmentSeal is written once a segment is rotated out and won't change
+// anymore, after its records and index are fsynced. A reader trusts its count
+// instead of scanning the segment, for as long as the segment has the size it
+// was sealed at.
+type segmentSeal struct {
+	Records int64 `json:"records"`
+	Size    int64 `json:"size"`
+	// LastPosition is where the last record starts, -1 in an empty segment
+	LastPosition int64 `json:"last_position"`
+	// MaxTimestamp is the newest timestamp of the records in Unix
+	// nanoseconds, 0 in an empty segment or one sealed before it was kept.
+	MaxTimestamp uint64 `json:"max_timestamp,omitempty"`
+	CRC          uint32 `json:"crc"` // CRC-32C of the whole log file
+}
+
+// sealSegment computes the checksum of the closed log file at path and seals
+// it with records records, the last of which starts at lastPosition, and the
+// newest of which is stamped maxTimestamp.
+func sealSegment(path string, records int64, lastPosition int64, maxTimestamp time.Time) error {
+	size, crc, err := fileCRC(path)
+	if err != nil {
+		return err
+	}
+	seal := segmentSeal{Records: records, Size: size, LastPosition: lastPosition, CRC: crc}
+	if !maxTimestamp.IsZero() {
+		seal.MaxTimestamp = uint64(maxTimestamp.UnixNano())
+	}
+	data, err := json.Marshal(seal)
+	if err != nil {
+		return err
+	}
+	return WriteFileAtomic(filepath.Dir(path), filepath.Base(path)+sealSuffix, data)
+}
+
+// readSeal returns the seal of the segment at path. A missing or unreadable
+// seal only means the segment has to be scanned, so it isn't an error.
+func readSeal(path string) (segmentSeal, bool) {
+	data, err := os.ReadFile(path + sealSuffix)
+	if err != nil {
+		return segmentSeal{}, false
+	}
+	var seal segmentSeal
+	if err := json.Unmarshal(data, &seal); err != nil {
+		return segmentSeal{}, false
+	}
+	return seal, true
+}
+
+// removeSeal unseals the segment at path, before it is written to again.
+func removeSeal(path string) error {
+	if err := os.Remove(path + sealSuffix); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to remove seal of %s: %w", path, err)
+	}
+	return nil
+}
+
+// fileCRC returns the size and the CRC-32C of the file at path.
+func fileCRC(path string) (int64, uint32, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+
+	crc := crc32.New(crcTable)
+	size, err := io.Copy(crc, f)
+	if err != nil {
+		return 0, 0, err
+	}
+	return size, crc.Sum32(), nil
+}
+
+// lastRecordStart returns where the last record of the log starts, -1 if it
+// has none.
+func (l *Log) lastRecordStart() (int64, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	if l.nextOffset == 0 {
+		return -1, nil
+	}
+	last := l.nextOffset - 1
+	start, err := l.startOf(uint32(last))
+	if err != nil {
+		return 0, err
+	}
+	pos := int64(-1)
+	err = l.scanFrom(start, func(h RecordHeader, payloadPos int64) bool {
+		pos = payloadPos - HeaderSize
+		return h.LogicalOffset == uint64(last)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return pos, nil
+}
+
+// Sealed reports whether the log is of a sealed segment, whose record count
+// was taken from its seal rather than from a scan.
+func (l *Log) Sealed() bool {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.sealed
+}
@@ -0,0 +1,174 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSegmentSeal(t *testing.T) {
+	const recordSize = HeaderSize + len("data 0000")
+
+	// newSealedPartition writes 1500 records in segments of 600, so the first
+	// two are sealed, and closes the partition.
+	newSealedPartition := func(t *testing.T) (string, []Segment) {
+		t.Helper()
+
+		dir := filepath.Join(t.TempDir(), "partition")
+		config := DefaultPartitionConfig()
+		config.MaxSegmentRecords = 600
+		p, err := NewPartitionWithConfig(dir, config)
+		require.NoError(t, err)
+		for i := range 1500 {
+			require.NoError(t, p.Append(fmt.Appendf(nil, "data %04d", i)))
+		}
+		require.NoError(t, p.Close())
+
+		segments, err := listSegments(dir)
+		require.NoError(t, err)
+		require.Len(t, segments, 3)
+		return dir, segments
+	}
+
+	t.Run("rotation seals the segment", func(t *testing.T) {
+		_, segments := newSealedPartition(t)
+
+		for _, segment := range segments[:2] {
+			seal, ok := readSeal(segment.Path)
+			require.True(t, ok, segment.Path)
+			size, crc, err := fileCRC(segment.Path)
+			require.NoError(t, err)
+			l, err := NewLogReadOnly(segment.Path, segment.BaseOffset)
+			require.NoError(t, err)
+			last, err := l.FindRecord(int64(segment.BaseOffset + 599))
+			require.NoError(t, err)
+			require.NoError(t, l.Close())
+			require.Equal(t, segmentSeal{
+				Records:      600,
+				Size:         SegmentHeaderSize + 600*int64(recordSize),
+				LastPosition: SegmentHeaderSize + 599*int64(recordSize),
+				MaxTimestamp: last.Header.Timestamp,
+				CRC:          crc,
+			}, seal)
+			require.Equal(t, seal.Size, size)
+		}
+		_, ok := readSeal(segments[2].Path)
+		require.False(t, ok, "the active segment isn't sealed")
+	})
+
+	t.Run("readers trust the seal", func(t *testing.T) {
+		_, segments := newSealedPartition(t)
+
+		// A scan past the last index entry would stop at record 550
+		f, err := os.OpenFile(segments[0].Path, os.O_RDWR, 0)
+		require.NoError(t, err)
+		_, err = f.WriteAt(make([]byte, HeaderSize), int64(SegmentHeaderSize+550*recordSize))
+		require.NoError(t, err)
+		require.NoError(t, f.Close())
+
+		l, err := NewLogReadOnly(segments[0].Path, segments[0].BaseOffset)
+		require.NoError(t, err)
+		defer l.Close()
+		require.True(t, l.Sealed())
+		require.Equal(t, int64(600), l.NextOffset())
+	})
+
+	t.Run("the last record is found from the seal", func(t *testing.T) {
+		_, segments := newSealedPartition(t)
+
+		l, err := NewLogReadOnly(segments[1].Path, segments[1].BaseOffset)
+		require.NoError(t, err)
+		defer l.Close()
+		start, err := l.startOf(599)
+		require.NoError(t, err)
+		require.Equal(t, int64(SegmentHeaderSize+599*recordSize), start)
+		record, err := l.FindRecord(1199)
+		require.NoError(t, err)
+		require.Equal(t, "data 1199", string(record.Payload))
+	})
+
+	t.Run("recovery only looks sealed segments up", func(t *testing.T) {
+		dir, segments := newSealedPartition(t)
+		// Without the checkpoint of the clean close, the open has to find
+		// where every segment ends
+		require.NoError(t, os.Remove(filepath.Join(dir, checkpointFileName)))
+
+		p, err := NewPartition(dir)
+		require.NoError(t, err)
+		require.False(t, p.RecoveryReport().CleanShutdown)
+		require.Equal(t, 0, p.RecoveryReport().ScannedSegments)
+		require.Equal(t, 1500, p.NextOffset())
+		require.NoError(t, p.Close())
+
+		require.NoError(t, os.Remove(filepath.Join(dir, checkpointFileName)))
+		require.NoError(t, removeSeal(segments[0].Path))
+		p, err = NewPartition(dir)
+		require.NoError(t, err)
+		require.Equal(t, 1, p.RecoveryReport().ScannedSegments)
+		require.Equal(t, 1500, p.NextOffset())
+		require.NoError(t, p.Close())
+	})
+
+	t.Run("a seal that doesn't match the size is ignored", func(t *testing.T) {
+		_, segments := newSealedPartition(t)
+
+		f, err := os.OpenFile(segments[0].Path, os.O_RDWR|os.O_APPEND, 0)
+		require.NoError(t, err)
+		_, err = f.Write([]byte("torn"))
+		require.NoError(t, err)
+		require.NoError(t, f.Close())
+
+		l, err := NewLogReadOnly(segments[0].Path, segments[0].BaseOffset)
+		require.NoError(t, err)
+		defer l.Close()
+		require.False(t, l.Sealed())
+		require.Equal(t, int64(600), l.NextOffset())
+		require.Equal(t, int64(4), l.tornBytes)
+	})
+
+	t.Run("writing to a segment unseals it", func(t *testing.T) {
+		_, segments := newSealedPartition(t)
+
+		require.NoError(t, truncateSegment(segments[0], 300, nil))
+		_, ok := readSeal(segments[0].Path)
+		require.False(t, ok)
+
+		l, err := NewLogReadOnly(segments[0].Path, segments[0].BaseOffset)
+		require.NoError(t, err)
+		defer l.Close()
+		require.False(t, l.Sealed())
+		require.Equal(t, int64(300), l.NextOffset())
+	})
+
+	t.Run("verify checks the seal", func(t *testing.T) {
+		dir, segments := newSealedPartition(t)
+
+		seal, ok := readSeal(segments[1].Path)
+		require.True(t, ok)
+		seal.CRC++
+		data, err := json.Marshal(seal)
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(segments[1].Path+sealSuffix, data, 0o644))
+
+		report, err := VerifyPartition(dir, false)
+		require.NoError(t, err)
+		require.False(t, report.Healthy())
+		require.Len(t, report.Issues, 1)
+		require.Equal(t, segments[1].Path+sealSuffix, report.Issues[0].Path)
+		require.Contains(t, report.Issues[0].Problem, "checksum")
+
+		report, err = VerifyPartition(dir, true)
+		require.NoError(t, err)
+		require.True(t, report.Healthy())
+		_, ok = readSeal(segments[1].Path)
+		require.False(t, ok)
+
+		report, err = VerifyPartition(dir, false)
+		require.NoError(t, err)
+		require.Equal(t, VerifyReport{Segments: 3, Records: 1500}, report)
+	})
+}
@@ -0,0 +1,81 @@
+package storage
+
+import "fmt"
+
+// segmentReader is a sealed segment kept open for reads.
+type segmentReader struct {
+	log    *Log
+	handle *fdHandle
+}
+
+// openSegment returns a read only log for segment and a function to call once
+// done with it. Sealed segments stay open in the partition's cache, within
+// the limits of its FDBudget. The active segment keeps growing, so it is
+// opened afresh for every read, sharing the sub-index of the active log. An
+// async active segment is flushed first, so the reader sees every record.
+// Caller must hold p.mu for reading.
+func (p *Partition) openSegment(segment Segment) (*Log, func(), error) {
+	active := segment.BaseOffset == p.segments[len(p.segments)-1].BaseOffset
+	if active && p.config.Durability == DurabilityAsync {
+		if err := p.activeLog.flush(); err != nil {
+			return nil, nil, fmt.Errorf("failed to flush active log: %w", err)
+		}
+	}
+
+	if !active {
+		p.readersMu.Lock()
+		defer p.readersMu.Unlock()
+
+		if r, ok := p.readers[segment.BaseOffset]; ok && r.handle.acquire() {
+			return r.log, r.handle.done, nil
+		}
+	}
+
+	if err := p.fds.reserve(logFDs); err != nil {
+		return nil, nil, err
+	}
+	l, err := NewLogReadOnly(segment.Path, segment.BaseOffset)
+	if err != nil {
+		p.fds.release(logFDs)
+		return nil, nil, fmt.Errorf("unable to open log segment in read only: %w", err)
+	}
+	l.maxRecordSize = p.config.maxRecordBytes()
+
+	if active {
+		// Positions the writer already knows of hold for this reader too
+		l.sub = p.activeLog.sub
+		return l, func() {
+			l.Close()
+			p.fds.release(logFDs)
+		}, nil
+	}
+
+	handle := p.fds.track(logFDs, l.Close)
+	p.readers[segment.BaseOffset] = &segmentReader{log: l, handle: handle}
+	return l, handle.done, nil
+}
+
+// dropReader closes the cached reader of the segment at baseOffset, if there
+// is one. Call it before the segment's files are changed or removed.
+// Caller must hold p.mu.
+func (p *Partition) dropReader(baseOffset int) {
+	p.readersMu.Lock()
+	defer p.readersMu.Unlock()
+
+	if r, ok := p.readers[baseOffset]; ok {
+		r.handle.close()
+		delete(p.readers, baseOffset)
+	}
+}
+
+// dropReaders closes every cached reader of the partition.
+// Caller must hold p.mu.
+func (p *Partition) dropReaders() {
+	p.readersMu.Lock()
+	defer p.readersMu.Unlock()
+
+	for baseOffset, r := range p.readers {
+		r.handle.close()
+		delete(p.readers, baseOffset)
+	}
+}
@@ -0,0 +1,253 @@
+package storage
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// snapshotFile is a file captured by Snapshot, together with the size it had
+// at snapshot time. Segments and indexes only ever grow at the end, so the
+// first Size bytes stay stable while appends carry on.
+type snapshotFile struct {
+	name string
+	path string
+	size int64
+}
+
+// Snapshot writes a tar archive of the partition to w: every segment and its
+// index, plus a checkpoint describing them and the encryption state of the
+// partition if it has encrypted records. The snapshot is consistent at the
+// offset the partition had when Snapshot was called; appends are only blocked
+// while that point is captured, not while the files are copied.
+//
+// A snapshot can be turned back into a partition directory with
+// RestorePartition.
+func (p *Partition) Snapshot(w io.Writer) (int, error) {
+	cp, files, err := p.captureSnapshot()
+	if err != nil {
+		return 0, err
+	}
+
+	tw := tar.NewWriter(w)
+
+	meta, err := json.Marshal(cp)
+	if err != nil {
+		return 0, err
+	}
+	err = tw.WriteHeader(&tar.Header{
+		Name:    checkpointFileName,
+		Mode:    0o644,
+		Size:    int64(len(meta)),
+		ModTime: time.Now(),
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to write snapshot metadata: %w", err)
+	}
+	if _, err := tw.Write(meta); err != nil {
+		return 0, fmt.Errorf("failed to write snapshot metadata: %w", err)
+	}
+
+	// Encrypted records can't be read back without it
+	encryption, err := os.ReadFile(filepath.Join(p.metaDir, encryptionFileName))
+	if err == nil {
+		err = tw.WriteHeader(&tar.Header{
+			Name:    encryptionFileName,
+			Mode:    0o644,
+			Size:    int64(len(encryption)),
+			ModTime: time.Now(),
+		})
+		if err == nil {
+			_, err = tw.Write(encryption)
+		}
+	} else if errors.Is(err, os.ErrNotExist) {
+		err = nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to write encryption state: %w", err)
+	}
+
+	for _, file := range files {
+		if err := writeSnapshotFile(tw, file); err != nil {
+			return 0, fmt.Errorf("failed to add %s to snapshot: %w", file.name, err)
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return 0, err
+	}
+	return cp.NextOffset, nil
+}
+
+// captureSnapshot flushes the active segment and records which files, and how
+// much of each, make up the partition right now.
+func (p *Partition) captureSnapshot() (checkpoint, []snapshotFile, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return checkpoint{}, nil, ErrPartitionClosed
+	}
+
+	if err := p.activeLog.flush(); err != nil {
+		return checkpoint{}, nil, fmt.Errorf("failed to flush active log: %w", err)
+	}
+
+	cp, err := newCheckpoint(p.nextOffset, p.segments)
+	if err != nil {
+		return checkpoint{}, nil, fmt.Errorf("error while taking checkpoint: %w", err)
+	}
+
+	var files []snapshotFile
+	for i, segment := range p.segments {
+		indexPath := segment.Path + ".index"
+		indexInfo, err := os.Stat(indexPath)
+		if err != nil {
+			return checkpoint{}, nil, err
+		}
+		indexSize := indexInfo.Size()
+		if i == len(p.segments)-1 {
+			// The active index is preallocated, only its entries are worth
+			// copying
+			indexSize = p.activeLog.index.Size()
+		}
+
+		files = append(files,
+			snapshotFile{
+				name: filepath.Base(segment.Path),
+				path: segment.Path,
+				size: cp.Segments[i].Size,
+			},
+			snapshotFile{
+				name: filepath.Base(indexPath),
+				path: indexPath,
+				size: indexSize,
+			},
+		)
+	}
+
+	return cp, files, nil
+}
+
+func writeSnapshotFile(tw *tar.Writer, file snapshotFile) error {
+	f, err := os.Open(file.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	err = tw.WriteHeader(&tar.Header{
+		Name:    file.name,
+		Mode:    0o644,
+		Size:    file.size,
+		ModTime: time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(tw, io.NewSectionReader(f, 0, file.size))
+	return err
+}
+
+// RestorePartition unpacks a snapshot taken with Partition.Snapshot into dir,
+// which must not exist yet or be empty. The files are unpacked next to dir
+// first and moved into place once the whole snapshot checked out, so a failed
+// restore leaves nothing behind. Open the result with NewPartition.
+//
+// The snapshot's checkpoint is restored into dir. A partition opened with a
+// separate MetaDir doesn't see it and recovers by scanning instead.
+func RestorePartition(dir string, r io.Reader) error {
+	if entries, err := os.ReadDir(dir); err == nil {
+		if len(entries) > 0 {
+			return fmt.Errorf("restore target %s is not empty", dir)
+		}
+		if err := os.Remove(dir); err != nil {
+			return err
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	parent := filepath.Dir(filepath.Clean(dir))
+	if err := os.MkdirAll(parent, 0o755); err != nil {
+		return err
+	}
+	tmpDir, err := os.MkdirTemp(parent, filepath.Base(dir)+".restore-*")
+	if err != nil {
+		return err
+	}
+
+	if err := unpackSnapshot(tmpDir, r); err != nil {
+		os.RemoveAll(tmpDir)
+		return fmt.Errorf("failed to restore snapshot: %w", err)
+	}
+
+	if err := os.Rename(tmpDir, dir); err != nil {
+		os.RemoveAll(tmpDir)
+		return err
+	}
+	return syncDir(parent)
+}
+
+func unpackSnapshot(dir string, r io.Reader) error {
+	tr := tar.NewReader(r)
+	sizes := make(map[string]int64)
+
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		if hdr.Typeflag != tar.TypeReg || hdr.Name != filepath.Base(hdr.Name) {
+			return fmt.Errorf("unexpected entry %q in snapshot", hdr.Name)
+		}
+
+		if err := unpackSnapshotFile(filepath.Join(dir, hdr.Name), tr); err != nil {
+			return fmt.Errorf("failed to unpack %s: %w", hdr.Name, err)
+		}
+		sizes[hdr.Name] = hdr.Size
+	}
+
+	// The checkpoint lists every segment and its size; make sure they all made
+	// it. It is also what lets the restored partition open without a scan.
+	data, err := os.ReadFile(filepath.Join(dir, checkpointFileName))
+	if err != nil {
+		return fmt.Errorf("snapshot has no metadata: %w", err)
+	}
+	var cp checkpoint
+	if err := json.Unmarshal(data, &cp); err != nil {
+		return fmt.Errorf("invalid snapshot metadata: %w", err)
+	}
+	for _, segment := range cp.Segments {
+		name := newLogNameFromInt(segment.BaseOffset).string()
+		size, ok := sizes[name]
+		if !ok || size != segment.Size {
+			return fmt.Errorf("segment %s is missing or incomplete", name)
+		}
+		if _, ok := sizes[name+".index"]; !ok {
+			return fmt.Errorf("index of segment %s is missing", name)
+		}
+	}
+
+	return syncDir(dir)
+}
+
+func unpackSnapshotFile(path string, r io.Reader) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(f, r)
+	return errors.Join(err, f.Sync(), f.Close())
+}
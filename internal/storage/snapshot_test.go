@@ -0,0 +1,108 @@
+package storage
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPartition_Snapshot(t *testing.T) {
+	t.Run("snapshot and restore", func(t *testing.T) {
+		config := DefaultPartitionConfig()
+		config.MaxSegmentRecords = 1000
+
+		p, err := NewPartitionWithConfig(filepath.Join(t.TempDir(), "partition/"), config)
+		require.NoError(t, err)
+		defer p.Close()
+
+		for i := range 2500 {
+			require.NoError(t, p.Append(fmt.Appendf(nil, "data %d", i)))
+		}
+
+		var buf bytes.Buffer
+		offset, err := p.Snapshot(&buf)
+		require.NoError(t, err)
+		require.Equal(t, 2500, offset)
+
+		// Not part of the snapshot
+		require.NoError(t, p.Append([]byte("data 2500")))
+
+		restoreDir := filepath.Join(t.TempDir(), "restored/")
+		err = RestorePartition(restoreDir, &buf)
+		require.NoError(t, err)
+
+		restored, err := NewPartitionWithConfig(restoreDir, config)
+		require.NoError(t, err)
+		defer restored.Close()
+
+		require.Equal(t, 2500, restored.nextOffset)
+		require.Len(t, restored.segments, 3)
+		for _, offset := range []int{0, 999, 1000, 2499} {
+			record, err := restored.Read(offset)
+			require.NoError(t, err)
+			require.Equal(t, fmt.Sprintf("data %d", offset), string(record.Payload))
+		}
+		_, err = restored.Read(2500)
+		require.Error(t, err)
+	})
+	t.Run("restore into non empty dir", func(t *testing.T) {
+		p, err := NewPartition(filepath.Join(t.TempDir(), "partition/"))
+		require.NoError(t, err)
+		defer p.Close()
+
+		var buf bytes.Buffer
+		_, err = p.Snapshot(&buf)
+		require.NoError(t, err)
+
+		restoreDir := t.TempDir()
+		require.NoError(t, os.WriteFile(filepath.Join(restoreDir, "something"), nil, 0o644))
+
+		err = RestorePartition(restoreDir, &buf)
+		require.Error(t, err)
+	})
+	t.Run("restore rejects paths outside the partition", func(t *testing.T) {
+		var buf bytes.Buffer
+		tw := tar.NewWriter(&buf)
+		require.NoError(t, tw.WriteHeader(&tar.Header{Name: "../evil.log", Mode: 0o644, Size: 1}))
+		_, err := tw.Write([]byte("x"))
+		require.NoError(t, err)
+		require.NoError(t, tw.Close())
+
+		parent := t.TempDir()
+		err = RestorePartition(filepath.Join(parent, "restored"), &buf)
+		require.Error(t, err)
+		require.NoFileExists(t, filepath.Join(parent, "evil.log"))
+		require.NoDirExists(t, filepath.Join(parent, "restored"))
+	})
+	t.Run("restore rejects incomplete snapshot", func(t *testing.T) {
+		p, err := NewPartition(filepath.Join(t.TempDir(), "partition/"))
+		require.NoError(t, err)
+		defer p.Close()
+		require.NoError(t, p.Append([]byte("data")))
+
+		var buf bytes.Buffer
+		_, err = p.Snapshot(&buf)
+		require.NoError(t, err)
+
+		// Keep only the metadata entry
+		var truncated bytes.Buffer
+		tr := tar.NewReader(&buf)
+		tw := tar.NewWriter(&truncated)
+		hdr, err := tr.Next()
+		require.NoError(t, err)
+		require.Equal(t, checkpointFileName, hdr.Name)
+		require.NoError(t, tw.WriteHeader(hdr))
+		_, err = io.Copy(tw, tr)
+		require.NoError(t, err)
+		require.NoError(t, tw.Close())
+
+		err = RestorePartition(filepath.Join(t.TempDir(), "restored"), &truncated)
+		require.Error(t, err)
+	})
+}
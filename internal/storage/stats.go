@@ -0,0 +1,95 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"time"
+)
+
+// PartitionStats is what a partition holds on local disk, segments uploaded
+// to tiered storage and removed locally aside.
+type PartitionStats struct {
+	// Segments counts the segments, the active one included.
+	Segments int `json:"segments"`
+	// Bytes is the disk usage of the segment files, which can be larger
+	// than the records they hold while the active one is preallocated.
+	Bytes int64 `json:"bytes"`
+	// IndexBytes is the disk usage of the index files.
+	IndexBytes int64 `json:"index_bytes"`
+	// FirstOffset and NextOffset bound the offsets of the local records.
+	FirstOffset int `json:"first_offset"`
+	NextOffset  int `json:"next_offset"`
+	// OldestTimestamp and NewestTimestamp are the timestamps of the first
+	// and last local records, zero if there are none.
+	OldestTimestamp time.Time `json:"oldest_timestamp"`
+	NewestTimestamp time.Time `json:"newest_timestamp"`
+	// ActiveSegmentBytes is the size of the records of the active segment.
+	ActiveSegmentBytes int64 `json:"active_segment_bytes"`
+	// ActiveSegmentFill is how close the active segment is to its size or
+	// record limit, whichever is closer, from 0 to 1.
+	ActiveSegmentFill float64 `json:"active_segment_fill"`
+}
+
+// Stats returns what the partition holds on disk. Segments removed while it
+// runs, by retention for instance, are left out.
+func (p *Partition) Stats() (PartitionStats, error) {
+	p.mu.RLock()
+	if p.closed {
+		p.mu.RUnlock()
+		return PartitionStats{}, ErrPartitionClosed
+	}
+	segments := append([]Segment(nil), p.segments...)
+	stats := PartitionStats{
+		Segments:           len(segments),
+		FirstOffset:        segments[0].BaseOffset,
+		NextOffset:         p.nextOffset,
+		ActiveSegmentBytes: p.activeLog.Size() - SegmentHeaderSize,
+	}
+	stats.ActiveSegmentFill = max(
+		float64(stats.ActiveSegmentBytes)/float64(p.config.MaxSegmentBytes),
+		float64(p.activeLog.NextOffset())/float64(p.config.MaxSegmentRecords),
+	)
+	p.mu.RUnlock()
+
+	for _, segment := range segments {
+		size, err := diskUsage(segment.Path)
+		if err != nil {
+			return PartitionStats{}, err
+		}
+		indexSize, err := diskUsage(segment.Path + ".index")
+		if err != nil {
+			return PartitionStats{}, err
+		}
+		stats.Bytes += size
+		stats.IndexBytes += indexSize
+	}
+
+	if stats.FirstOffset < stats.NextOffset {
+		oldest, err := p.read(context.Background(), stats.FirstOffset, nil, -1)
+		if err != nil {
+			return PartitionStats{}, fmt.Errorf("failed to read the oldest record: %w", err)
+		}
+		newest, err := p.read(context.Background(), stats.NextOffset-1, nil, -1)
+		if err != nil {
+			return PartitionStats{}, fmt.Errorf("failed to read the newest record: %w", err)
+		}
+		stats.OldestTimestamp = time.Unix(0, int64(oldest.Header.Timestamp))
+		stats.NewestTimestamp = time.Unix(0, int64(newest.Header.Timestamp))
+	}
+	return stats, nil
+}
+
+// diskUsage returns the size of the file at path, 0 if it doesn't exist.
+func diskUsage(path string) (int64, error) {
+	info, err := os.Stat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
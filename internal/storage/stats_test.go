@@ -0,0 +1,53 @@
+package storage
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPartition_Stats(t *testing.T) {
+	t.Run("empty", func(t *testing.T) {
+		p, err := NewPartition(filepath.Join(t.TempDir(), "p"))
+		require.NoError(t, err)
+		defer p.Close()
+
+		stats, err := p.Stats()
+		require.NoError(t, err)
+		require.Equal(t, 1, stats.Segments)
+		require.Zero(t, stats.NextOffset)
+		require.True(t, stats.OldestTimestamp.IsZero())
+		require.Zero(t, stats.ActiveSegmentFill)
+	})
+
+	t.Run("segments", func(t *testing.T) {
+		before := time.Now()
+		p, _ := newTruncateTestPartition(t, 250)
+		after := time.Now()
+
+		stats, err := p.Stats()
+		require.NoError(t, err)
+		require.Equal(t, 3, stats.Segments)
+		require.Equal(t, 0, stats.FirstOffset)
+		require.Equal(t, 250, stats.NextOffset)
+		require.GreaterOrEqual(t, stats.Bytes, int64(250*(HeaderSize+len("data 0"))))
+		require.Positive(t, stats.IndexBytes)
+		require.Equal(t, int64(50*(HeaderSize+len("data 200"))), stats.ActiveSegmentBytes) // offsets 200 to 249
+		require.InDelta(t, 0.5, stats.ActiveSegmentFill, 0.001)                            // of 100 records
+		require.False(t, stats.OldestTimestamp.Before(before.Truncate(0)))
+		require.False(t, stats.NewestTimestamp.After(after))
+		require.False(t, stats.NewestTimestamp.Before(stats.OldestTimestamp))
+
+		require.NoError(t, p.TruncateBefore(100))
+		stats, err = p.Stats()
+		require.NoError(t, err)
+		require.Equal(t, 2, stats.Segments)
+		require.Equal(t, 100, stats.FirstOffset)
+
+		require.NoError(t, p.Close())
+		_, err = p.Stats()
+		require.ErrorIs(t, err, ErrPartitionClosed)
+	})
+}
@@ -0,0 +1,77 @@
+package storage
+
+import "sync"
+
+// subIndexInterval is how many records apart the positions kept by the
+// sub-index are.
+const subIndexInterval = 32
+
+// subIndex remembers where every 32nd record starts between two entries of
+// the sparse index, so a lookup decodes at most 31 headers instead of up to
+// 499. It only lives in memory: the writer fills it in as it appends, and any
+// scan over the log fills in what it walks past, so a reader that keeps a log
+// open gets faster lookups the more it reads.
+//
+// The zero value is ready to use.
+type subIndex struct {
+	mu sync.Mutex
+	// gaps maps the offset starting a gap of the sparse index (a multiple of
+	// 500) to the positions of records gap, gap+32, gap+64 and so on, as
+	// far as they are known. Positions are only ever appended, so the known
+	// ones are always a prefix.
+	gaps map[uint32][]uint32
+}
+
+// add remembers that the record at offset starts at pos, if it's the next
+// position its gap is missing.
+func (s *subIndex) add(offset uint32, pos int64) {
+	within := offset % indexInterval
+	if within%subIndexInterval != 0 {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.gaps == nil {
+		s.gaps = make(map[uint32][]uint32)
+	}
+	gap := offset - within
+	positions := s.gaps[gap]
+	if int(within/subIndexInterval) == len(positions) {
+		s.gaps[gap] = append(positions, uint32(pos))
+	}
+}
+
+// nearest returns the closest record at or before offset that the sub-index
+// knows the position of. ok is false if it knows none in offset's gap.
+func (s *subIndex) nearest(offset uint32) (nearestOffset uint32, pos int64, ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	within := offset % indexInterval
+	gap := offset - within
+	positions := s.gaps[gap]
+	if len(positions) == 0 {
+		return 0, 0, false
+	}
+
+	k := min(int(within/subIndexInterval), len(positions)-1)
+	return gap + uint32(k*subIndexInterval), int64(positions[k]), true
+}
+
+// truncate forgets the positions of records at or past offset.
+func (s *subIndex) truncate(offset uint32) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for gap, positions := range s.gaps {
+		switch {
+		case gap >= offset:
+			delete(s.gaps, gap)
+		case gap+uint32(len(positions)-1)*subIndexInterval >= offset:
+			keep := (offset - gap + subIndexInterval - 1) / subIndexInterval
+			s.gaps[gap] = positions[:keep]
+		}
+	}
+}
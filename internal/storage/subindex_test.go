@@ -0,0 +1,97 @@
+package storage
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSubIndex(t *testing.T) {
+	t.Run("keeps a prefix of every gap", func(t *testing.T) {
+		var s subIndex
+		_, _, ok := s.nearest(10)
+		require.False(t, ok)
+
+		s.add(500, 5000)
+		s.add(532, 5320)
+		// Not a multiple of 32 within the gap, and past a hole
+		s.add(540, 5400)
+		s.add(596, 5960)
+
+		off, pos, ok := s.nearest(600)
+		require.True(t, ok)
+		require.Equal(t, uint32(532), off)
+		require.Equal(t, int64(5320), pos)
+
+		off, _, ok = s.nearest(531)
+		require.True(t, ok)
+		require.Equal(t, uint32(500), off)
+
+		_, _, ok = s.nearest(1000)
+		require.False(t, ok)
+	})
+
+	t.Run("truncate forgets records past the cut", func(t *testing.T) {
+		var s subIndex
+		for off := range uint32(1500) {
+			s.add(off, int64(off))
+		}
+		s.truncate(533)
+
+		off, _, ok := s.nearest(600)
+		require.True(t, ok)
+		require.Equal(t, uint32(532), off)
+		_, _, ok = s.nearest(1100)
+		require.False(t, ok)
+
+		s.truncate(532)
+		off, _, _ = s.nearest(600)
+		require.Equal(t, uint32(500), off)
+	})
+}
+
+func TestLog_FindRecordWithSubIndex(t *testing.T) {
+	logPath := filepath.Join(t.TempDir(), "test.log")
+	log, err := NewLogMediumDurable(logPath, 0)
+	require.NoError(t, err)
+	defer log.Close()
+
+	for i := range 1200 {
+		// Records of varying size
+		require.NoError(t, log.Append(fmt.Appendf(nil, "record %d %*s", i, i%17, "")))
+	}
+
+	// The writer filled in the sub-index as it went
+	off, _, ok := log.sub.nearest(1100)
+	require.True(t, ok)
+	require.Equal(t, uint32(1096), off)
+
+	check := func(t *testing.T, l *Log, offsets ...int) {
+		t.Helper()
+		for _, i := range offsets {
+			record, err := l.FindRecord(int64(i))
+			require.NoError(t, err)
+			require.Equal(t, fmt.Sprintf("record %d %*s", i, i%17, ""), string(record.Payload))
+		}
+	}
+	check(t, log, 0, 31, 32, 33, 499, 500, 531, 532, 1000, 1095, 1096, 1199)
+
+	// A reader starts out empty and fills it in as it scans
+	reader, err := NewLogReadOnly(logPath, 0)
+	require.NoError(t, err)
+	defer reader.Close()
+	_, _, ok = reader.sub.nearest(700)
+	require.False(t, ok)
+	check(t, reader, 1199, 700, 640, 641)
+	off, _, ok = reader.sub.nearest(700)
+	require.True(t, ok)
+	require.Equal(t, uint32(692), off)
+
+	require.NoError(t, log.truncate(1050))
+	for i := 1050; i < 1100; i++ {
+		require.NoError(t, log.Append(fmt.Appendf(nil, "record %d %*s", i, i%17, "")))
+	}
+	check(t, log, 1020, 1049, 1050, 1056, 1099)
+}
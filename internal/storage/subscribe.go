@@ -0,0 +1,145 @@
+package storage
+
+import (
+	"errors"
+	"sync"
+)
+
+// SlowConsumerPolicy is what happens to a subscription that falls more than
+// MaxLag records behind the end of the partition.
+type SlowConsumerPolicy int
+
+const (
+	// SlowConsumerWait keeps delivering every record, read back from disk
+	// however far behind the subscriber is. Appends are never held up by
+	// it either way.
+	SlowConsumerWait SlowConsumerPolicy = iota
+	// SlowConsumerSkip drops the records the subscriber fell behind on and
+	// carries on from the newest one.
+	SlowConsumerSkip
+	// SlowConsumerClose ends the subscription, closing its channel.
+	SlowConsumerClose
+)
+
+// defaultSubscribeBuffer is how many records a subscription delivers ahead of
+// its subscriber unless configured otherwise.
+const defaultSubscribeBuffer = 64
+
+type SubscribeConfig struct {
+	// Buffer is how many records are delivered ahead of the subscriber.
+	// Defaults to 64.
+	Buffer int
+	// MaxLag is how far behind the end of the partition the subscriber may
+	// fall before Policy applies. Zero means no limit.
+	MaxLag int
+	// Policy is what happens once the subscriber is past MaxLag.
+	Policy SlowConsumerPolicy
+}
+
+// Subscribe delivers the records of the partition from fromOffset on, and
+// every record appended after them, as they are appended. It buffers up to 64
+// records ahead of the subscriber and never gives up on one that falls
+// behind, see SubscribeWithConfig for other policies.
+func (p *Partition) Subscribe(fromOffset int) (<-chan Record, func()) {
+	return p.SubscribeWithConfig(fromOffset, SubscribeConfig{})
+}
+
+// SubscribeWithConfig is Subscribe with the buffering and the slow consumer
+// policy of config.
+//
+// The records are read back from the partition, so their
+// Header.LogicalOffset is set to their offset in the partition rather than in
+// their segment. Expired records are skipped. The channel is closed once
+// cancel is called, the partition is closed, the policy drops the subscriber,
+// or a record can't be read (it was truncated away, say). cancel may be
+// called more than once.
+func (p *Partition) SubscribeWithConfig(fromOffset int, config SubscribeConfig) (<-chan Record, func()) {
+	buffer := config.Buffer
+	if buffer <= 0 {
+		buffer = defaultSubscribeBuffer
+	}
+	records := make(chan Record, buffer)
+	stop := make(chan struct{})
+	var once sync.Once
+
+	go p.deliver(records, stop, fromOffset, config)
+	return records, func() { once.Do(func() { close(stop) }) }
+}
+
+// deliver sends the records from offset on to records until stop is closed.
+func (p *Partition) deliver(records chan<- Record, stop <-chan struct{}, offset int, config SubscribeConfig) {
+	defer close(records)
+
+	for {
+		// Grab the signal before looking, so an append in between isn't missed
+		appended := p.appendedSignal()
+		p.mu.RLock()
+		closed, next := p.closed, p.nextOffset
+		p.mu.RUnlock()
+		if closed {
+			return
+		}
+
+		if offset >= next {
+			select {
+			case <-appended:
+				continue
+			case <-stop:
+				return
+			}
+		}
+
+		if config.MaxLag > 0 && next-offset > config.MaxLag {
+			switch config.Policy {
+			case SlowConsumerSkip:
+				offset = next - 1
+			case SlowConsumerClose:
+				p.logger.Warn("closed slow subscription", "offset", offset, "lag", next-offset)
+				return
+			}
+		}
+
+		record, err := p.Read(offset)
+		if errors.Is(err, ErrRecordExpired) {
+			offset++
+			continue
+		}
+		if err != nil {
+			if !errors.Is(err, ErrPartitionClosed) {
+				p.logger.Warn("closed subscription on failed read", "offset", offset, "error", err)
+			}
+			return
+		}
+		record.Header.LogicalOffset = uint64(offset)
+
+		select {
+		case records <- record:
+			offset++
+		case <-stop:
+			return
+		}
+	}
+}
+
+// appendedSignal returns a channel that is closed once records are appended
+// after the call, or once the partition is closed.
+func (p *Partition) appendedSignal() <-chan struct{} {
+	p.appendedMu.Lock()
+	defer p.appendedMu.Unlock()
+	if p.appended == nil {
+		p.appended = make(chan struct{})
+	}
+	return p.appended
+}
+
+// notifyAppended wakes up the subscriptions waiting for records. The channel
+// is only made once someone waits, appends without subscribers don't pay for
+// it.
+func (p *Partition) notifyAppended() {
+	p.appendedMu.Lock()
+	defer p.appendedMu.Unlock()
+	if p.appended != nil {
+		close(p.appended)
+		p.appended = nil
+	}
+}
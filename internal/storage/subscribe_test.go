@@ -0,0 +1,103 @@
+package storage
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// receive returns the next record of a subscription, failing if none comes.
+func receive(t *testing.T, records <-chan Record) (Record, bool) {
+	t.Helper()
+	select {
+	case record, ok := <-records:
+		return record, ok
+	case <-time.After(time.Second):
+		t.Fatal("no record delivered")
+		return Record{}, false
+	}
+}
+
+func TestPartition_Subscribe(t *testing.T) {
+	newPartition := func(t *testing.T) *Partition {
+		config := DefaultPartitionConfig()
+		config.MaxSegmentRecords = 10
+		p, err := NewPartitionWithConfig(filepath.Join(t.TempDir(), "partition/"), config)
+		require.NoError(t, err)
+		return p
+	}
+
+	t.Run("delivers stored and new records", func(t *testing.T) {
+		p := newPartition(t)
+		defer p.Close()
+		for i := range 15 {
+			require.NoError(t, p.Append(fmt.Appendf(nil, "record %d", i)))
+		}
+
+		records, cancel := p.Subscribe(5)
+		for i := 5; i < 15; i++ {
+			record, ok := receive(t, records)
+			require.True(t, ok)
+			require.Equal(t, uint64(i), record.Header.LogicalOffset)
+			require.Equal(t, fmt.Sprintf("record %d", i), string(record.Payload))
+		}
+
+		// Caught up, the next one comes when it's appended
+		go func() {
+			time.Sleep(10 * time.Millisecond)
+			p.Append([]byte("record 15"))
+		}()
+		record, ok := receive(t, records)
+		require.True(t, ok)
+		require.Equal(t, "record 15", string(record.Payload))
+
+		cancel()
+		cancel()
+		for range records {
+		}
+	})
+
+	t.Run("closing the partition ends subscriptions", func(t *testing.T) {
+		p := newPartition(t)
+		records, _ := p.Subscribe(0)
+		require.NoError(t, p.Close())
+		_, ok := receive(t, records)
+		require.False(t, ok)
+	})
+
+	t.Run("slow subscribers skip ahead", func(t *testing.T) {
+		p := newPartition(t)
+		defer p.Close()
+
+		records, cancel := p.SubscribeWithConfig(0, SubscribeConfig{Buffer: 1, MaxLag: 5, Policy: SlowConsumerSkip})
+		defer cancel()
+		for i := range 20 {
+			require.NoError(t, p.Append(fmt.Appendf(nil, "record %d", i)))
+		}
+
+		var offsets []uint64
+		for len(offsets) == 0 || offsets[len(offsets)-1] != 19 {
+			record, ok := receive(t, records)
+			require.True(t, ok)
+			offsets = append(offsets, record.Header.LogicalOffset)
+		}
+		require.Less(t, len(offsets), 20)
+		require.IsIncreasing(t, offsets)
+	})
+
+	t.Run("slow subscribers can be closed", func(t *testing.T) {
+		p := newPartition(t)
+		defer p.Close()
+		for i := range 20 {
+			require.NoError(t, p.Append(fmt.Appendf(nil, "record %d", i)))
+		}
+
+		records, cancel := p.SubscribeWithConfig(0, SubscribeConfig{MaxLag: 5, Policy: SlowConsumerClose})
+		defer cancel()
+		_, ok := receive(t, records)
+		require.False(t, ok)
+	})
+}
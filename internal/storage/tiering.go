@@ -0,0 +1,393 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+const tieringManifestFileName = "tiered.json"
+
+// TierConfig configures tiered storage for a partition.
+type TierConfig struct {
+	Store ObjectStore
+	// Prefix is prepended to every object key of the partition.
+	Prefix string
+	// LocalRetention is how long a segment stays on local disk after it was
+	// uploaded. Reads of older segments go to the object store.
+	LocalRetention time.Duration
+	// CacheDir keeps the indexes of tiered segments. They are tiny (8 bytes
+	// per 500 records) and are fetched whole on first use.
+	CacheDir string
+	// CacheBytes bounds the in-memory cache of fetched segment blocks.
+	CacheBytes int64
+	// BlockSize is the size of a single ranged GET.
+	BlockSize int64
+}
+
+func (c TierConfig) validate() error {
+	if c.Store == nil {
+		return errors.New("object store is required")
+	}
+	if c.CacheDir == "" {
+		return errors.New("cache dir is required")
+	}
+	if c.LocalRetention < 0 {
+		return errors.New("local retention can't be negative")
+	}
+	if c.BlockSize <= 0 {
+		return errors.New("block size must be positive")
+	}
+	if c.CacheBytes < c.BlockSize {
+		return errors.New("cache must hold at least one block")
+	}
+	return nil
+}
+
+// tieredSegment is a sealed segment that was uploaded to the object store.
+type tieredSegment struct {
+	BaseOffset int       `json:"base_offset"`
+	EndOffset  int       `json:"end_offset"`
+	Size       int64     `json:"size"`
+	IndexSize  int64     `json:"index_size"`
+	UploadedAt time.Time `json:"uploaded_at"`
+	// Local is cleared once the local copy was deleted.
+	Local bool `json:"local"`
+}
+
+// TieringManager offloads a partition's sealed segments to an object store.
+// Segments are uploaded as soon as they are sealed and deleted from local disk
+// once LocalRetention has passed; the partition keeps reading them through
+// ranged GETs. Only a prefix of the log is ever tiered, so the local segments
+// are always a contiguous suffix of it.
+type TieringManager struct {
+	p      *Partition
+	config TierConfig
+	cache  *blockCache
+
+	mu       sync.Mutex // guards segments and the manifest file
+	segments []tieredSegment
+
+	done chan struct{}
+	wg   sync.WaitGroup
+	once sync.Once
+}
+
+// NewTieringManager attaches tiered storage to p. The list of tiered segments
+// is kept in the partition's metadata directory.
+func NewTieringManager(p *Partition, config TierConfig) (*TieringManager, error) {
+	if err := config.validate(); err != nil {
+		return nil, fmt.Errorf("invalid tier config: %w", err)
+	}
+	if err := ensureWritableDir(config.CacheDir); err != nil {
+		return nil, fmt.Errorf("tier cache directory: %w", err)
+	}
+
+	m := &TieringManager{
+		p:      p,
+		config: config,
+		cache:  newBlockCache(config.CacheBytes),
+		done:   make(chan struct{}),
+	}
+
+	data, err := os.ReadFile(filepath.Join(p.metaDir, tieringManifestFileName))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to read tiering manifest: %w", err)
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &m.segments); err != nil {
+			return nil, fmt.Errorf("invalid tiering manifest: %w", err)
+		}
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.tiering != nil {
+		return nil, errors.New("partition already has a tiering manager")
+	}
+	p.tiering = m
+
+	return m, nil
+}
+
+// Start runs Sync every interval until Stop is called.
+func (m *TieringManager) Start(interval time.Duration) {
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				if err := m.Sync(); err != nil {
+					m.p.logger.Error("tiering sync failed", "err", err)
+				}
+			case <-m.done:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops the background loop started with Start.
+func (m *TieringManager) Stop() {
+	m.once.Do(func() {
+		close(m.done)
+	})
+	m.wg.Wait()
+}
+
+// Sync uploads every sealed segment that isn't in the object store yet and
+// deletes local copies that outlived LocalRetention.
+func (m *TieringManager) Sync() error {
+	if m.p.config.Mode == OpenReadOnly {
+		return ErrPartitionReadOnly
+	}
+	if err := m.upload(); err != nil {
+		return err
+	}
+	return m.expire(time.Now())
+}
+
+func (m *TieringManager) upload() error {
+	m.p.mu.RLock()
+	sealed := append([]Segment(nil), m.p.segments[:len(m.p.segments)-1]...)
+	m.p.mu.RUnlock()
+
+	for _, segment := range sealed {
+		m.mu.Lock()
+		uploaded := m.find(segment.BaseOffset) != nil
+		m.mu.Unlock()
+		if uploaded {
+			continue
+		}
+
+		ts, err := m.uploadSegment(segment)
+		if err != nil {
+			return fmt.Errorf("failed to upload segment %s: %w", segment.Path, err)
+		}
+		m.p.logger.Info("uploaded segment to tiered storage", "segment", segment.Path, "bytes", ts.Size)
+
+		m.mu.Lock()
+		m.segments = append(m.segments, ts)
+		sort.Slice(m.segments, func(i, j int) bool {
+			return m.segments[i].BaseOffset < m.segments[j].BaseOffset
+		})
+		err = m.persistLocked()
+		m.mu.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m *TieringManager) uploadSegment(segment Segment) (tieredSegment, error) {
+	l, err := NewLogReadOnly(segment.Path, segment.BaseOffset)
+	if err != nil {
+		return tieredSegment{}, err
+	}
+	records := l.NextOffset()
+	if err := l.Close(); err != nil {
+		return tieredSegment{}, err
+	}
+
+	size, err := m.putFile(segment.Path)
+	if err != nil {
+		return tieredSegment{}, err
+	}
+	indexSize, err := m.putFile(segment.Path + ".index")
+	if err != nil {
+		return tieredSegment{}, err
+	}
+
+	return tieredSegment{
+		BaseOffset: segment.BaseOffset,
+		EndOffset:  segment.BaseOffset + int(records),
+		Size:       size,
+		IndexSize:  indexSize,
+		UploadedAt: time.Now(),
+		Local:      true,
+	}, nil
+}
+
+func (m *TieringManager) putFile(localPath string) (int64, error) {
+	f, err := os.Open(localPath)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+
+	return info.Size(), m.config.Store.Put(m.key(filepath.Base(localPath)), f, info.Size())
+}
+
+// expire deletes local copies of uploaded segments past LocalRetention,
+// oldest first. It stops at the first segment that has to stay, so the local
+// segments remain a suffix of the log.
+func (m *TieringManager) expire(now time.Time) error {
+	for {
+		m.p.mu.Lock()
+		if len(m.p.segments) < 2 {
+			m.p.mu.Unlock()
+			return nil
+		}
+		oldest := m.p.segments[0]
+
+		m.mu.Lock()
+		ts := m.find(oldest.BaseOffset)
+		if ts == nil || now.Sub(ts.UploadedAt) < m.config.LocalRetention {
+			m.mu.Unlock()
+			m.p.mu.Unlock()
+			return nil
+		}
+
+		m.p.segments = m.p.segments[1:]
+		m.p.dropReader(oldest.BaseOffset)
+		ts.Local = false
+		err := m.persistLocked()
+		m.mu.Unlock()
+		m.p.mu.Unlock()
+		if err != nil {
+			return err
+		}
+
+		if err := errors.Join(os.Remove(oldest.Path), os.Remove(oldest.Path+".index"), removeSeal(oldest.Path)); err != nil {
+			return fmt.Errorf("failed to delete local copy of %s: %w", oldest.Path, err)
+		}
+		m.p.logger.Info("deleted local copy of tiered segment", "segment", oldest.Path)
+	}
+}
+
+// read serves a record from a segment that only exists in the object store.
+func (m *TieringManager) read(ctx context.Context, offset int, buf []byte, limit int64) (Record, error) {
+	m.mu.Lock()
+	idx := sort.Search(len(m.segments), func(i int) bool {
+		return m.segments[i].EndOffset > offset
+	})
+	if idx == len(m.segments) || m.segments[idx].BaseOffset > offset {
+		m.mu.Unlock()
+		return Record{}, fmt.Errorf("offset %d is not in tiered storage", offset)
+	}
+	ts := m.segments[idx]
+	m.mu.Unlock()
+
+	name := newLogNameFromInt(ts.BaseOffset).string()
+	indexPath, err := m.cachedIndex(name, ts.IndexSize)
+	if err != nil {
+		return Record{}, err
+	}
+
+	reader := &remoteReaderAt{
+		ctx:       ctx,
+		store:     m.config.Store,
+		key:       m.key(name),
+		size:      ts.Size,
+		blockSize: m.config.BlockSize,
+		cache:     m.cache,
+	}
+	l, err := openRemoteLog(reader, ts.Size, indexPath, ts.BaseOffset)
+	if err != nil {
+		return Record{}, fmt.Errorf("unable to open tiered segment %s: %w", name, err)
+	}
+	defer l.Close()
+	l.maxRecordSize = m.p.config.maxRecordBytes()
+
+	return l.findRecord(int64(offset), buf, limit)
+}
+
+// cachedIndex returns the local path of a tiered segment's index, fetching it
+// from the object store the first time.
+func (m *TieringManager) cachedIndex(name string, size int64) (string, error) {
+	indexName := name + ".index"
+	indexPath := filepath.Join(m.config.CacheDir, indexName)
+	if info, err := os.Stat(indexPath); err == nil && info.Size() == size {
+		return indexPath, nil
+	}
+
+	var data []byte
+	if size > 0 {
+		var err error
+		data, err = m.config.Store.GetRange(m.key(indexName), 0, size)
+		if err != nil {
+			return "", fmt.Errorf("failed to fetch index %s: %w", indexName, err)
+		}
+	}
+	if err := WriteFileAtomic(m.config.CacheDir, indexName, data); err != nil {
+		return "", err
+	}
+	return indexPath, nil
+}
+
+// find returns the tiered segment with the given base offset, if any.
+// Caller must hold m.mu.
+// firstOffset returns the base offset of the oldest tiered segment, false if
+// there is none.
+func (m *TieringManager) firstOffset() (int, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.segments) == 0 {
+		return 0, false
+	}
+	return m.segments[0].BaseOffset, true
+}
+
+func (m *TieringManager) find(baseOffset int) *tieredSegment {
+	for i := range m.segments {
+		if m.segments[i].BaseOffset == baseOffset {
+			return &m.segments[i]
+		}
+	}
+	return nil
+}
+
+// deleteObjects removes every tiered segment of the partition from the store,
+// along with the indexes cached locally.
+func (m *TieringManager) deleteObjects() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var errs []error
+	for _, ts := range m.segments {
+		name := newLogNameFromInt(ts.BaseOffset).string()
+		errs = append(errs,
+			m.config.Store.Delete(m.key(name)),
+			m.config.Store.Delete(m.key(name+".index")),
+		)
+		err := os.Remove(filepath.Join(m.config.CacheDir, name+".index"))
+		if !errors.Is(err, os.ErrNotExist) {
+			errs = append(errs, err)
+		}
+	}
+	m.segments = nil
+	return errors.Join(errs...)
+}
+
+func (m *TieringManager) key(name string) string {
+	return path.Join(m.config.Prefix, name)
+}
+
+func (m *TieringManager) persistLocked() error {
+	data, err := json.Marshal(m.segments)
+	if err != nil {
+		return err
+	}
+	if err := WriteFileAtomic(m.p.metaDir, tieringManifestFileName, data); err != nil {
+		return fmt.Errorf("failed to write tiering manifest: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,185 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newTieredPartition(t *testing.T, root string, retention time.Duration) (*Partition, *TieringManager) {
+	t.Helper()
+
+	config := DefaultPartitionConfig()
+	config.MaxSegmentRecords = 100
+	p, err := NewPartitionWithConfig(filepath.Join(root, "partition"), config)
+	require.NoError(t, err)
+
+	store, err := NewDirObjectStore(filepath.Join(root, "bucket"))
+	require.NoError(t, err)
+
+	m, err := NewTieringManager(p, TierConfig{
+		Store:          store,
+		Prefix:         "topic/0",
+		LocalRetention: retention,
+		CacheDir:       filepath.Join(root, "cache"),
+		CacheBytes:     1 << 20,
+		BlockSize:      512,
+	})
+	require.NoError(t, err)
+
+	return p, m
+}
+
+func TestTieringManager_Sync(t *testing.T) {
+	t.Run("offloads sealed segments and reads them back", func(t *testing.T) {
+		root := t.TempDir()
+		p, m := newTieredPartition(t, root, 0)
+
+		for i := range 350 {
+			require.NoError(t, p.Append(fmt.Appendf(nil, "data %d", i)))
+		}
+		require.Len(t, p.segments, 4)
+
+		require.NoError(t, m.Sync())
+
+		// Nothing is fetched from the object store once the read is canceled
+		canceled, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, err := p.ReadContext(canceled, 0)
+		require.ErrorIs(t, err, context.Canceled)
+
+		require.Len(t, p.segments, 1)
+		require.Equal(t, 300, p.segments[0].BaseOffset)
+		// Tiered records are still the partition's
+		require.Equal(t, 0, p.FirstOffset())
+		require.NoFileExists(t, filepath.Join(root, "partition", newLogNameFromInt(0).string()))
+		require.FileExists(t, filepath.Join(root, "bucket", "topic", "0", newLogNameFromInt(100).string()))
+
+		for _, offset := range []int{0, 99, 100, 250, 299, 300, 349} {
+			record, err := p.Read(offset)
+			require.NoError(t, err)
+			require.Equal(t, fmt.Sprintf("data %d", offset), string(record.Payload))
+		}
+
+		_, err = p.Read(350)
+		require.Error(t, err)
+
+		// The tiered segments survive a restart
+		require.NoError(t, p.Close())
+		p, err = NewPartitionWithConfig(filepath.Join(root, "partition"), p.config)
+		require.NoError(t, err)
+		defer p.Close()
+		_, err = NewTieringManager(p, m.config)
+		require.NoError(t, err)
+
+		record, err := p.Read(42)
+		require.NoError(t, err)
+		require.Equal(t, "data 42", string(record.Payload))
+	})
+	t.Run("local copies are kept during retention", func(t *testing.T) {
+		root := t.TempDir()
+		p, m := newTieredPartition(t, root, time.Hour)
+		defer p.Close()
+
+		for i := range 250 {
+			require.NoError(t, p.Append(fmt.Appendf(nil, "data %d", i)))
+		}
+		require.NoError(t, m.Sync())
+
+		require.Len(t, p.segments, 3)
+		require.FileExists(t, filepath.Join(root, "bucket", "topic", "0", newLogNameFromInt(0).string()))
+		require.FileExists(t, filepath.Join(root, "partition", newLogNameFromInt(0).string()))
+
+		// Once the retention has passed they go
+		require.NoError(t, m.expire(time.Now().Add(2*time.Hour)))
+		require.Len(t, p.segments, 1)
+
+		record, err := p.Read(10)
+		require.NoError(t, err)
+		require.Equal(t, "data 10", string(record.Payload))
+	})
+	t.Run("active segment is never tiered", func(t *testing.T) {
+		root := t.TempDir()
+		p, m := newTieredPartition(t, root, 0)
+		defer p.Close()
+
+		for i := range 50 {
+			require.NoError(t, p.Append(fmt.Appendf(nil, "data %d", i)))
+		}
+		require.NoError(t, m.Sync())
+
+		require.Len(t, p.segments, 1)
+		entries, err := os.ReadDir(filepath.Join(root, "bucket"))
+		require.NoError(t, err)
+		require.Empty(t, entries)
+	})
+}
+
+// countingStore counts ranged GETs so tests can see the block cache work.
+type countingStore struct {
+	ObjectStore
+	gets int
+}
+
+func (s *countingStore) GetRange(key string, off int64, n int64) ([]byte, error) {
+	s.gets++
+	return s.ObjectStore.GetRange(key, off, n)
+}
+
+func TestRemoteReaderAt(t *testing.T) {
+	dirStore, err := NewDirObjectStore(t.TempDir())
+	require.NoError(t, err)
+	store := &countingStore{ObjectStore: dirStore}
+
+	data := make([]byte, 1000)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	require.NoError(t, store.Put("obj", bytes.NewReader(data), int64(len(data))))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r := &remoteReaderAt{
+		ctx:       ctx,
+		store:     store,
+		key:       "obj",
+		size:      int64(len(data)),
+		blockSize: 64,
+		cache:     newBlockCache(256),
+	}
+
+	buf := make([]byte, 100)
+	n, err := r.ReadAt(buf, 30)
+	require.NoError(t, err)
+	require.Equal(t, 100, n)
+	require.Equal(t, data[30:130], buf)
+	require.Equal(t, 3, store.gets)
+
+	// Served from the cache
+	_, err = r.ReadAt(buf[:10], 64)
+	require.NoError(t, err)
+	require.Equal(t, 3, store.gets)
+
+	n, err = r.ReadAt(buf, 950)
+	require.ErrorIs(t, err, io.EOF)
+	require.Equal(t, 50, n)
+	require.Equal(t, data[950:], buf[:50])
+
+	// Only 4 blocks fit, the oldest ones were evicted
+	require.LessOrEqual(t, r.cache.size, int64(256))
+	_, ok := r.cache.get(blockKey{object: "obj", block: 0})
+	require.False(t, ok)
+
+	// Nothing more is fetched once the read is canceled
+	gets := store.gets
+	cancel()
+	_, err = r.ReadAt(buf[:10], 0)
+	require.ErrorIs(t, err, context.Canceled)
+	require.Equal(t, gets, store.gets)
+}
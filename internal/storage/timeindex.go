@@ -0,0 +1,151 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// timeEntry says that the records of a log before offset are all stamped at
+// or before maxBefore, and that the record at offset starts at pos.
+type timeEntry struct {
+	offset    uint32
+	pos       int64
+	maxBefore uint64
+}
+
+// timeIndex finds the first record of a log stamped at or after a timestamp
+// without reading every header. It keeps an entry every indexInterval
+// records with the newest timestamp of the records before it: unlike the
+// timestamps themselves, which producers may pick, that only goes up, so the
+// entries can be binary searched. It only lives in memory, a log builds it
+// with a scan the first time it is searched by time and its writer keeps it
+// up from then on.
+type timeIndex struct {
+	entries []timeEntry
+}
+
+// add takes note of the record at offset, starting at pos, after records
+// stamped at most maxBefore.
+func (t *timeIndex) add(offset uint32, pos int64, maxBefore uint64) {
+	if offset%indexInterval == 0 {
+		t.entries = append(t.entries, timeEntry{offset: offset, pos: pos, maxBefore: maxBefore})
+	}
+}
+
+// start returns where to start scanning for the first record stamped at or
+// after timestamp: the last entry with only older records before it.
+func (t *timeIndex) start(timestamp uint64) timeEntry {
+	i := sort.Search(len(t.entries), func(i int) bool {
+		return t.entries[i].maxBefore >= timestamp
+	})
+	return t.entries[max(i-1, 0)]
+}
+
+// buildTimeIndex scans the log for its time index and its newest timestamp.
+// Caller must hold l.mu.
+func (l *Log) buildTimeIndex() error {
+	times := &timeIndex{}
+	var newest uint64
+	err := l.scanFrom(SegmentHeaderSize, func(h RecordHeader, payloadPos int64) bool {
+		times.add(uint32(h.LogicalOffset), payloadPos-HeaderSize, newest)
+		newest = max(newest, h.Timestamp)
+		return false
+	})
+	if err != nil && !errors.Is(err, ErrRecordNotFoundFullScan) {
+		return fmt.Errorf("failed to build time index: %w", err)
+	}
+	l.times = times
+	l.maxTimestamp, l.maxTimestampKnown = newest, true
+	return nil
+}
+
+// offsetForTimestamp returns the offset of the first record of the log
+// stamped at or after timestamp, false if there is none.
+func (l *Log) offsetForTimestamp(timestamp uint64) (int64, bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.times == nil {
+		if err := l.buildTimeIndex(); err != nil {
+			return 0, false, err
+		}
+	}
+	if l.nextOffset == 0 || l.maxTimestamp < timestamp {
+		return 0, false, nil
+	}
+
+	found := int64(-1)
+	err := l.scanFrom(l.times.start(timestamp).pos, func(h RecordHeader, payloadPos int64) bool {
+		if h.Timestamp >= timestamp {
+			found = int64(h.LogicalOffset)
+			return true
+		}
+		return false
+	})
+	if err != nil && !errors.Is(err, ErrRecordNotFoundFullScan) {
+		return 0, false, err
+	}
+	if found < 0 {
+		return 0, false, nil
+	}
+	return l.baseOffset + found, true, nil
+}
+
+// OffsetForTimestamp returns the offset of the first record stamped at or
+// after timestamp, or NextOffset if there is none, so a consumer can start
+// from a point in time. Records out of order, with timestamps their
+// producers picked, don't throw it off: the segment holding the answer is
+// binary searched by the newest timestamp of every segment up to it, and
+// then by its time index. Only the local segments are searched, a tiered
+// partition answers with its first local offset for anything older.
+func (p *Partition) OffsetForTimestamp(timestamp time.Time) (int, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if p.closed {
+		return 0, ErrPartitionClosed
+	}
+	target := uint64(max(timestamp.UnixNano(), 0))
+
+	// The newest timestamp of each segment and the ones before it
+	maxUpTo := make([]uint64, len(p.segments))
+	for i, segment := range p.segments {
+		newest, err := p.maxTimestampOf(segment)
+		if err != nil {
+			return 0, fmt.Errorf("failed to find the newest record of segment %s: %w", segment.Path, err)
+		}
+		if !newest.IsZero() {
+			maxUpTo[i] = uint64(newest.UnixNano())
+		}
+		if i > 0 {
+			maxUpTo[i] = max(maxUpTo[i], maxUpTo[i-1])
+		}
+	}
+	i := sort.Search(len(maxUpTo), func(i int) bool {
+		return maxUpTo[i] >= target
+	})
+	if i == len(p.segments) {
+		return p.nextOffset, nil
+	}
+
+	l := p.activeLog
+	if i < len(p.segments)-1 {
+		sealed, done, err := p.openSegment(p.segments[i])
+		if err != nil {
+			return 0, err
+		}
+		defer done()
+		l = sealed
+	}
+	offset, ok, err := l.offsetForTimestamp(target)
+	if err != nil {
+		return 0, err
+	}
+	if !ok {
+		// The segment was truncated since its newest timestamp was looked up
+		return p.nextOffset, nil
+	}
+	return int(offset), nil
+}
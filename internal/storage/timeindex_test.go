@@ -0,0 +1,71 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPartition_OffsetForTimestamp(t *testing.T) {
+	config := DefaultPartitionConfig()
+	config.MaxSegmentRecords = 1200
+	p, err := NewPartitionWithConfig(t.TempDir(), config)
+	require.NoError(t, err)
+	defer p.Close()
+
+	ctx := context.Background()
+	start := time.Unix(1_700_000_000, 0)
+	// A record a second over three segments, the last one active
+	for i := range 3000 {
+		require.NoError(t, p.AppendAt(ctx, fmt.Appendf(nil, "data %d", i), start.Add(time.Duration(i)*time.Second)))
+	}
+
+	for _, tc := range []struct {
+		name      string
+		timestamp time.Time
+		want      int
+	}{
+		{"before the first record", start.Add(-time.Hour), 0},
+		{"first record", start, 0},
+		{"between records", start.Add(1500*time.Second - time.Millisecond), 1500},
+		{"sealed segment", start.Add(700 * time.Second), 700},
+		{"active segment", start.Add(2999 * time.Second), 2999},
+		{"after the last record", start.Add(time.Hour), 3000},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			offset, err := p.OffsetForTimestamp(tc.timestamp)
+			require.NoError(t, err)
+			require.Equal(t, tc.want, offset)
+		})
+	}
+
+	t.Run("out of order timestamps", func(t *testing.T) {
+		// Stamped before most of the partition, but found after all of it
+		late := start.Add(10 * time.Hour)
+		require.NoError(t, p.AppendAt(ctx, []byte("late"), late))
+		require.NoError(t, p.AppendAt(ctx, []byte("early"), start.Add(5*time.Second)))
+
+		offset, err := p.OffsetForTimestamp(start.Add(time.Hour))
+		require.NoError(t, err)
+		require.Equal(t, 3000, offset)
+		offset, err = p.OffsetForTimestamp(late.Add(time.Nanosecond))
+		require.NoError(t, err)
+		require.Equal(t, 3002, offset)
+	})
+
+	t.Run("after truncation", func(t *testing.T) {
+		require.NoError(t, p.TruncateTo(2500))
+		offset, err := p.OffsetForTimestamp(start.Add(2600 * time.Second))
+		require.NoError(t, err)
+		require.Equal(t, 2500, offset)
+	})
+
+	t.Run("closed", func(t *testing.T) {
+		require.NoError(t, p.Close())
+		_, err := p.OffsetForTimestamp(start)
+		require.ErrorIs(t, err, ErrPartitionClosed)
+	})
+}
@@ -0,0 +1,222 @@
+//go:build linux
+
+package uring
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync/atomic"
+	"syscall"
+	"unsafe"
+)
+
+// The parts of the io_uring ABI (linux/io_uring.h) the writer needs. The
+// syscall numbers are the same on every architecture.
+const (
+	sysSetup = 425
+	sysEnter = 426
+
+	opFsync = 3
+	opWrite = 23
+
+	sqeIOLink      = 1 << 2
+	enterGetEvents = 1 << 0
+
+	featSingleMmap = 1 << 0
+	featRWCurPos   = 1 << 3
+
+	offSQRing = 0
+	offCQRing = 0x8000000
+	offSQEs   = 0x10000000
+)
+
+type params struct {
+	sqEntries    uint32
+	cqEntries    uint32
+	flags        uint32
+	sqThreadCPU  uint32
+	sqThreadIdle uint32
+	features     uint32
+	wqFd         uint32
+	resv         [3]uint32
+	sqOff        sqRingOffsets
+	cqOff        cqRingOffsets
+}
+
+type sqRingOffsets struct {
+	head, tail, ringMask, ringEntries, flags, dropped, array, resv1 uint32
+	userAddr                                                        uint64
+}
+
+type cqRingOffsets struct {
+	head, tail, ringMask, ringEntries, overflow, cqes, flags, resv1 uint32
+	userAddr                                                        uint64
+}
+
+// sqe is a submission queue entry, struct io_uring_sqe.
+type sqe struct {
+	opcode      uint8
+	flags       uint8
+	ioprio      uint16
+	fd          int32
+	off         uint64
+	addr        uint64
+	len         uint32
+	opFlags     uint32
+	userData    uint64
+	bufIndex    uint16
+	personality uint16
+	spliceFdIn  int32
+	addr3       uint64
+	_           uint64
+}
+
+// cqe is a completion queue entry, struct io_uring_cqe.
+type cqe struct {
+	userData uint64
+	res      int32
+	flags    uint32
+}
+
+// ring is an io_uring instance with a single submitter, which is what lets it
+// get away with plain loads of the indexes only it moves.
+type ring struct {
+	fd int
+
+	sqRing, cqRing, sqeMap []byte
+
+	sqHead, sqTail *uint32
+	sqMask         uint32
+	sqArray        []uint32
+	sqes           []sqe
+	tail           uint32 // sq tail as far as entries have been queued
+
+	cqHead, cqTail *uint32
+	cqMask         uint32
+	cqes           []cqe
+}
+
+func newRing(entries uint32) (*ring, error) {
+	var p params
+	fd, _, errno := syscall.Syscall(sysSetup, uintptr(entries), uintptr(unsafe.Pointer(&p)), 0)
+	if errno != 0 {
+		err := os.NewSyscallError("io_uring_setup", errno)
+		if errno == syscall.ENOSYS || errno == syscall.EPERM {
+			// Not built in, or turned off with kernel.io_uring_disabled
+			return nil, fmt.Errorf("%w: %w", errors.ErrUnsupported, err)
+		}
+		return nil, err
+	}
+	r := &ring{fd: int(fd)}
+	if p.features&featRWCurPos == 0 {
+		r.close()
+		return nil, fmt.Errorf("%w: kernel can't write at the current file position through io_uring", errors.ErrUnsupported)
+	}
+
+	sqSize := int(p.sqOff.array + p.sqEntries*4)
+	cqSize := int(p.cqOff.cqes + p.cqEntries*uint32(unsafe.Sizeof(cqe{})))
+	if p.features&featSingleMmap != 0 {
+		sqSize = max(sqSize, cqSize)
+	}
+	if err := r.mmap(&r.sqRing, offSQRing, sqSize); err != nil {
+		return nil, err
+	}
+	r.cqRing = r.sqRing
+	if p.features&featSingleMmap == 0 {
+		if err := r.mmap(&r.cqRing, offCQRing, cqSize); err != nil {
+			return nil, err
+		}
+	}
+	if err := r.mmap(&r.sqeMap, offSQEs, int(p.sqEntries)*int(unsafe.Sizeof(sqe{}))); err != nil {
+		return nil, err
+	}
+
+	r.sqHead = (*uint32)(unsafe.Pointer(&r.sqRing[p.sqOff.head]))
+	r.sqTail = (*uint32)(unsafe.Pointer(&r.sqRing[p.sqOff.tail]))
+	r.sqMask = *(*uint32)(unsafe.Pointer(&r.sqRing[p.sqOff.ringMask]))
+	r.sqArray = unsafe.Slice((*uint32)(unsafe.Pointer(&r.sqRing[p.sqOff.array])), p.sqEntries)
+	r.sqes = unsafe.Slice((*sqe)(unsafe.Pointer(&r.sqeMap[0])), p.sqEntries)
+	r.tail = *r.sqTail
+
+	r.cqHead = (*uint32)(unsafe.Pointer(&r.cqRing[p.cqOff.head]))
+	r.cqTail = (*uint32)(unsafe.Pointer(&r.cqRing[p.cqOff.tail]))
+	r.cqMask = *(*uint32)(unsafe.Pointer(&r.cqRing[p.cqOff.ringMask]))
+	r.cqes = unsafe.Slice((*cqe)(unsafe.Pointer(&r.cqRing[p.cqOff.cqes])), p.cqEntries)
+	return r, nil
+}
+
+func (r *ring) mmap(dst *[]byte, offset int64, size int) error {
+	data, err := syscall.Mmap(r.fd, offset, size, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED|syscall.MAP_POPULATE)
+	if err != nil {
+		r.close()
+		return fmt.Errorf("failed to map io_uring: %w", err)
+	}
+	*dst = data
+	return nil
+}
+
+// queue adds e to the submission queue. It is only submitted by the next
+// enter.
+func (r *ring) queue(e sqe) error {
+	if r.tail-atomic.LoadUint32(r.sqHead) == uint32(len(r.sqes)) {
+		return errors.New("io_uring submission queue is full")
+	}
+	idx := r.tail & r.sqMask
+	r.sqes[idx] = e
+	r.sqArray[idx] = idx
+	r.tail++
+	atomic.StoreUint32(r.sqTail, r.tail)
+	return nil
+}
+
+// enter submits everything queued and, with wait above zero, blocks until
+// that many completions are there to pop. A signal can cut the wait short,
+// callers pop what there is and enter again for the rest.
+func (r *ring) enter(wait uint32) error {
+	var flags uintptr
+	if wait > 0 {
+		flags = enterGetEvents
+	}
+	for {
+		submit := r.tail - atomic.LoadUint32(r.sqHead)
+		_, _, errno := syscall.Syscall6(sysEnter, uintptr(r.fd), uintptr(submit), uintptr(wait), flags, 0, 0)
+		switch errno {
+		case 0:
+			return nil
+		case syscall.EINTR:
+			continue
+		default:
+			return os.NewSyscallError("io_uring_enter", errno)
+		}
+	}
+}
+
+// pop takes the oldest completion off the completion queue, ok is false if
+// there is none.
+func (r *ring) pop() (c cqe, ok bool) {
+	head := atomic.LoadUint32(r.cqHead)
+	if head == atomic.LoadUint32(r.cqTail) {
+		return cqe{}, false
+	}
+	c = r.cqes[head&r.cqMask]
+	atomic.StoreUint32(r.cqHead, head+1)
+	return c, true
+}
+
+func (r *ring) close() error {
+	var errs []error
+	if r.sqeMap != nil {
+		errs = append(errs, syscall.Munmap(r.sqeMap))
+	}
+	// With a single mmap the completion ring is the submission ring
+	if r.cqRing != nil && (r.sqRing == nil || &r.cqRing[0] != &r.sqRing[0]) {
+		errs = append(errs, syscall.Munmap(r.cqRing))
+	}
+	if r.sqRing != nil {
+		errs = append(errs, syscall.Munmap(r.sqRing))
+	}
+	errs = append(errs, syscall.Close(r.fd))
+	r.sqRing, r.cqRing, r.sqeMap = nil, nil, nil
+	return errors.Join(errs...)
+}
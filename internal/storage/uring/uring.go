@@ -0,0 +1,18 @@
+// Package uring writes files through io_uring, so that filling one buffer
+// overlaps with the kernel writing the last one, and a write and the fsync
+// after it cost a single syscall. It is experimental and Linux only.
+package uring
+
+import "os"
+
+// Writer appends to a file, buffering writes like a bufio.Writer. Full
+// buffers are handed to the kernel without waiting for them to be written,
+// while the next one fills up. A Writer is not safe for concurrent use.
+type Writer struct {
+	ring    *ring
+	file    *os.File
+	buf     []byte // being filled
+	spare   []byte // the other buffer, the one in flight while writing is set
+	writing []byte // the buffer the kernel is writing, nil if none
+	err     error  // first error, every call after returns it
+}
@@ -0,0 +1,194 @@
+//go:build linux
+
+package uring
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// userData of the fsync submissions, writes carry their length instead.
+const fsyncTag = 1 << 63
+
+// NewWriter returns a Writer appending to f through buffers of size bytes.
+// It fails with an error wrapping errors.ErrUnsupported where the kernel has
+// no usable io_uring.
+func NewWriter(f *os.File, size int) (*Writer, error) {
+	r, err := newRing(8)
+	if err != nil {
+		return nil, err
+	}
+	return &Writer{
+		ring:  r,
+		file:  f,
+		buf:   make([]byte, 0, size),
+		spare: make([]byte, 0, size),
+	}, nil
+}
+
+// Write copies p into the buffer, handing every buffer that fills up to the
+// kernel. It only blocks for the previous buffer to be written.
+func (w *Writer) Write(p []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	n := 0
+	for len(p) > 0 {
+		copied := copy(w.buf[len(w.buf):cap(w.buf)], p)
+		w.buf = w.buf[:len(w.buf)+copied]
+		n += copied
+		p = p[copied:]
+		if len(w.buf) == cap(w.buf) {
+			if err := w.submit(); err != nil {
+				return n, err
+			}
+		}
+	}
+	return n, nil
+}
+
+// Flush returns once everything written so far has been handed to the OS.
+func (w *Writer) Flush() error {
+	if w.err != nil {
+		return w.err
+	}
+	if len(w.buf) > 0 {
+		if err := w.submit(); err != nil {
+			return err
+		}
+	}
+	return w.await()
+}
+
+// Sync returns once everything written so far is on stable storage. The
+// buffer and the fsync go to the kernel together, the fsync linked to run
+// after the write, and cost one syscall between them.
+func (w *Writer) Sync() error {
+	if err := w.await(); err != nil {
+		return err
+	}
+
+	n := 1
+	if len(w.buf) > 0 {
+		if err := w.queueWrite(w.buf, sqeIOLink); err != nil {
+			return w.fail(err)
+		}
+		w.writing = w.buf
+		n++
+	}
+	if err := w.ring.queue(sqe{opcode: opFsync, fd: int32(w.file.Fd()), userData: fsyncTag}); err != nil {
+		return w.fail(err)
+	}
+	if err := w.complete(n); err != nil {
+		return err
+	}
+	w.buf = w.buf[:0]
+	return nil
+}
+
+// Close flushes the writer and tears down its ring. The file stays open.
+func (w *Writer) Close() error {
+	return errors.Join(w.Flush(), w.ring.close())
+}
+
+// submit hands the buffer to the kernel once the previous one is written,
+// and carries on filling the other one.
+func (w *Writer) submit() error {
+	if err := w.await(); err != nil {
+		return err
+	}
+	if err := w.queueWrite(w.buf, 0); err != nil {
+		return w.fail(err)
+	}
+	if err := w.ring.enter(0); err != nil {
+		return w.fail(err)
+	}
+	w.writing = w.buf
+	w.buf, w.spare = w.spare[:0], w.buf
+	return nil
+}
+
+func (w *Writer) queueWrite(buf []byte, flags uint8) error {
+	return w.ring.queue(sqe{
+		opcode: opWrite,
+		flags:  flags,
+		fd:     int32(w.file.Fd()),
+		off:    ^uint64(0), // the current position, the end for O_APPEND
+		addr:   uint64(uintptr(unsafe.Pointer(&buf[0]))),
+		len:    uint32(len(buf)),
+		// The length is what tells a short write apart
+		userData: uint64(len(buf)),
+	})
+}
+
+// await waits for the buffer in flight, if there is one, to be written.
+func (w *Writer) await() error {
+	if w.err != nil {
+		return w.err
+	}
+	if w.writing == nil {
+		return nil
+	}
+	return w.complete(1)
+}
+
+// complete waits for the n submissions in flight. Only one write is ever in
+// flight, so the kernel can't reorder writes and leave a hole in the file.
+func (w *Writer) complete(n int) error {
+	var first error
+	for n > 0 {
+		c, ok := w.ring.pop()
+		if !ok {
+			if err := w.ring.enter(uint32(n)); err != nil {
+				return w.fail(err)
+			}
+			continue
+		}
+		n--
+		if err := w.result(c); err != nil && first == nil {
+			first = err
+		}
+	}
+	w.writing = nil
+	if first != nil {
+		return w.fail(first)
+	}
+	return nil
+}
+
+func (w *Writer) result(c cqe) error {
+	if c.userData == fsyncTag {
+		if c.res == -int32(syscall.ECANCELED) {
+			// The write it was linked to came up short, and has been
+			// finished since
+			return w.file.Sync()
+		}
+		if c.res < 0 {
+			return os.NewSyscallError("fsync", syscall.Errno(-c.res))
+		}
+		return nil
+	}
+
+	if c.res < 0 {
+		return os.NewSyscallError("write", syscall.Errno(-c.res))
+	}
+	if written := int(c.res); written < int(c.userData) {
+		// Nothing else is in flight, so the rest still goes right after
+		if _, err := w.file.Write(w.writing[written:]); err != nil {
+			return fmt.Errorf("short io_uring write: %w", err)
+		}
+	}
+	return nil
+}
+
+// fail makes err sticky: like a bufio.Writer, a writer that failed to write
+// doesn't know what made it to the file and refuses to write more.
+func (w *Writer) fail(err error) error {
+	if w.err == nil {
+		w.err = err
+	}
+	return w.err
+}
@@ -0,0 +1,85 @@
+//go:build linux
+
+package uring
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newTestWriter(t *testing.T, size int) (*Writer, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "test.log")
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_RDWR, 0o644)
+	require.NoError(t, err)
+	t.Cleanup(func() { f.Close() })
+
+	w, err := NewWriter(f, size)
+	if errors.Is(err, errors.ErrUnsupported) {
+		t.Skip(err)
+	}
+	require.NoError(t, err)
+	return w, path
+}
+
+func TestWriter(t *testing.T) {
+	t.Run("writes land in order across buffers", func(t *testing.T) {
+		w, path := newTestWriter(t, 64)
+
+		var want bytes.Buffer
+		for i := range 200 {
+			// Sizes that straddle buffer boundaries
+			chunk := bytes.Repeat([]byte{byte(i)}, i%97+1)
+			want.Write(chunk)
+			n, err := w.Write(chunk)
+			require.NoError(t, err)
+			require.Equal(t, len(chunk), n)
+		}
+		require.NoError(t, w.Flush())
+
+		got, err := os.ReadFile(path)
+		require.NoError(t, err)
+		require.Equal(t, want.Bytes(), got)
+		require.NoError(t, w.Close())
+	})
+
+	t.Run("sync writes the buffer and fsyncs", func(t *testing.T) {
+		w, path := newTestWriter(t, 4096)
+
+		_, err := w.Write([]byte("hello "))
+		require.NoError(t, err)
+		require.NoError(t, w.Sync())
+		// Nothing buffered, just the fsync
+		require.NoError(t, w.Sync())
+		_, err = w.Write([]byte("world"))
+		require.NoError(t, err)
+		require.NoError(t, w.Sync())
+
+		got, err := os.ReadFile(path)
+		require.NoError(t, err)
+		require.Equal(t, "hello world", string(got))
+		require.NoError(t, w.Close())
+	})
+
+	t.Run("a failed write sticks", func(t *testing.T) {
+		w, _ := newTestWriter(t, 16)
+		// Writes to a read only descriptor fail in the kernel
+		readOnly, err := os.Open(w.file.Name())
+		require.NoError(t, err)
+		defer readOnly.Close()
+		w.file = readOnly
+
+		_, err = w.Write([]byte("0123456789"))
+		require.NoError(t, err)
+		require.ErrorIs(t, w.Flush(), syscall.EBADF)
+		_, err = w.Write([]byte("more"))
+		require.Error(t, err)
+		require.Error(t, w.Close())
+	})
+}
@@ -0,0 +1,20 @@
+//go:build !linux
+
+package uring
+
+import (
+	"errors"
+	"os"
+)
+
+type ring struct{}
+
+// NewWriter always fails with errors.ErrUnsupported, io_uring is Linux only.
+func NewWriter(f *os.File, size int) (*Writer, error) {
+	return nil, errors.ErrUnsupported
+}
+
+func (w *Writer) Write(p []byte) (int, error) { return 0, errors.ErrUnsupported }
+func (w *Writer) Flush() error                { return errors.ErrUnsupported }
+func (w *Writer) Sync() error                 { return errors.ErrUnsupported }
+func (w *Writer) Close() error                { return errors.ErrUnsupported }
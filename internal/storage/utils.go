@@ -1,69 +1,43 @@
 package storage
 
 import (
-	"fmt"
-	"io"
+	"errors"
 	"os"
+	"path/filepath"
 	"time"
 )
 
-// DumpFile prints all records in a file for debugging
-func DumpFile(path string, head int) error {
-	f, err := os.Open(path)
+func TimeNowInUtc() time.Time {
+	return time.Now().UTC()
+}
+
+// syncDir fsyncs a directory so that files created, renamed or removed in it
+// survive a crash.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
 	if err != nil {
 		return err
 	}
-	defer f.Close()
-
-	headerBuf := make([]byte, HeaderSize)
-	recordNum := 0
-
-	for {
-		// Read header
-		_, err := io.ReadFull(f, headerBuf)
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			return fmt.Errorf("reading header %d: %w", recordNum, err)
-		}
-
-		var h RecordHeader
-		h.Decode(headerBuf)
-
-		// Read payload
-		payload := make([]byte, h.PayloadSize)
-		if h.PayloadSize > 0 {
-			if _, err := io.ReadFull(f, payload); err != nil {
-				return fmt.Errorf("reading payload %d: %w", recordNum, err)
-			}
-		}
-
-		// Print record
-		fmt.Printf("Record #%d\n", recordNum)
-		fmt.Printf("  Offset:    %d\n", h.LogicalOffset)
-		fmt.Printf("  Size:      %d\n", h.PayloadSize)
-		fmt.Printf("  Timestamp: %d (%s)\n", h.Timestamp, time.Unix(0, int64(h.Timestamp)))
-		fmt.Printf("  Payload:   %q\n", truncate(payload, 100))
-		fmt.Println()
-
-		recordNum++
-		if recordNum == head {
-			break
-		}
-	}
-
-	fmt.Printf("Total: %d records\n", recordNum)
-	return nil
+	return errors.Join(d.Sync(), d.Close())
 }
 
-func truncate(b []byte, max int) []byte {
-	if len(b) <= max {
-		return b
+// WriteFileAtomic replaces dir/name with data: the data is written and fsynced
+// to a temporary file first, which is then renamed over the target. The
+// temporary file starts with a dot and is removed if anything fails, so a
+// crash leaves either the old file or the new one.
+func WriteFileAtomic(dir string, name string, data []byte) error {
+	tmp, err := os.CreateTemp(dir, "."+name+"-*")
+	if err != nil {
+		return err
 	}
-	return b[:max]
-}
+	defer os.Remove(tmp.Name())
 
-func TimeNowInUtc() time.Time {
-	return time.Now().UTC()
+	_, err = tmp.Write(data)
+	if err := errors.Join(err, tmp.Sync(), tmp.Close()); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), filepath.Join(dir, name)); err != nil {
+		return err
+	}
+	return syncDir(dir)
 }
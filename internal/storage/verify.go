@@ -0,0 +1,274 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+)
+
+// VerifyIssue is a problem VerifyPartition found in a segment or its index.
+type VerifyIssue struct {
+	Path string `json:"path"`
+	// Position is the byte of the file where the problem starts, -1 if it is
+	// about the file as a whole.
+	Position int64  `json:"position"`
+	Problem  string `json:"problem"`
+	Repaired bool   `json:"repaired"`
+}
+
+// VerifyReport is the outcome of VerifyPartition.
+type VerifyReport struct {
+	Segments int           `json:"segments"`
+	Records  int64         `json:"records"`
+	Issues   []VerifyIssue `json:"issues"`
+}
+
+// Healthy reports whether the partition is sound, either because nothing was
+// found or because everything found was repaired.
+func (r VerifyReport) Healthy() bool {
+	for _, issue := range r.Issues {
+		if !issue.Repaired {
+			return false
+		}
+	}
+	return true
+}
+
+// VerifyPartition checks every segment of the partition in dir: that the
+// record headers make sense, that the records match their checksums and have
+// consecutive offsets, that every index entry points at the record it claims
+// to, that a sealed segment still matches its seal, and that each segment
+// ends where the next one begins. It is an offline check, the partition must
+// not be open.
+//
+// With repair, a segment is cut at its first bad record (the records after it
+// are lost), a bad index is rebuilt from its segment, a seal that doesn't
+// match is removed and a segment overlapping the next one is truncated, as a
+// normal open would. Gaps between segments can't be repaired, and neither can
+// a segment in a format this version doesn't read: it is reported as such and
+// left alone.
+func VerifyPartition(dir string, repair bool) (VerifyReport, error) {
+	segments, err := listSegments(dir)
+	if err != nil {
+		return VerifyReport{}, err
+	}
+
+	report := VerifyReport{Segments: len(segments)}
+	ends := make([]int, len(segments))
+	for i, segment := range segments {
+		records, err := verifySegmentFiles(segment, repair, &report)
+		if errors.Is(err, ErrSegmentFormat) {
+			// Its records can't be told from garbage, a repair would cut them all
+			report.Issues = append(report.Issues, VerifyIssue{Path: segment.Path, Position: -1, Problem: err.Error()})
+			ends[i] = -1
+			continue
+		}
+		if err != nil {
+			return report, fmt.Errorf("failed to verify segment %s: %w", segment.Path, err)
+		}
+		report.Records += records
+		ends[i] = segment.BaseOffset + int(records)
+	}
+
+	for i := 0; i < len(segments)-1; i++ {
+		cur, next := segments[i], segments[i+1]
+		if ends[i] < 0 {
+			// Where it ends is unknown
+			continue
+		}
+		if ends[i] < next.BaseOffset {
+			report.Issues = append(report.Issues, VerifyIssue{
+				Path:     cur.Path,
+				Position: -1,
+				Problem:  fmt.Sprintf("offsets %d to %d are missing before the next segment", ends[i], next.BaseOffset),
+			})
+		}
+		if ends[i] > next.BaseOffset {
+			shared := ends[i] - next.BaseOffset
+			issue := VerifyIssue{
+				Path:     cur.Path,
+				Position: -1,
+				Problem:  fmt.Sprintf("the last %d records are also in the next segment", shared),
+			}
+			if repair {
+				err := truncateSegment(cur, int64(next.BaseOffset-cur.BaseOffset), slog.Default())
+				if err != nil {
+					return report, fmt.Errorf("failed to repair overlapping segment %s: %w", cur.Path, err)
+				}
+				issue.Repaired = true
+				report.Records -= int64(shared)
+			}
+			report.Issues = append(report.Issues, issue)
+		}
+	}
+
+	return report, nil
+}
+
+// verifySegmentFiles checks a segment and its index, adding what it finds to
+// report, and returns the number of good records in the segment. It returns a
+// SegmentFormatError without touching anything for a segment in another
+// format.
+func verifySegmentFiles(segment Segment, repair bool, report *VerifyReport) (int64, error) {
+	f, err := os.Open(segment.Path)
+	if err != nil {
+		return 0, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return 0, err
+	}
+
+	// What the index should hold, an entry for every 500th record
+	var expected []IndexEntry
+	var records int64
+	goodEnd := int64(SegmentHeaderSize)
+	verifyErr := verifySegment(f, segment.Path, info.Size(), segment.BaseOffset, func(n int) error {
+		records++
+		goodEnd += int64(n)
+		if records%500 == 0 {
+			expected = append(expected, IndexEntry{LogicalOff: uint32(records), MemoryPos: uint32(goodEnd)})
+		}
+		return nil
+	})
+	if err := f.Close(); err != nil {
+		return 0, err
+	}
+	if errors.Is(verifyErr, ErrSegmentFormat) {
+		return 0, verifyErr
+	}
+
+	rebuildIndex := false
+	if verifyErr != nil {
+		issue := VerifyIssue{Path: segment.Path, Position: goodEnd, Problem: verifyErr.Error()}
+		if repair {
+			if err := os.Truncate(segment.Path, goodEnd); err != nil {
+				return 0, fmt.Errorf("failed to cut bad records: %w", err)
+			}
+			issue.Repaired = true
+			// Entries past the cut are wrong now
+			rebuildIndex = true
+		}
+		report.Issues = append(report.Issues, issue)
+	}
+
+	indexPath := segment.Path + ".index"
+	issue, err := verifyIndex(indexPath, expected)
+	if err != nil {
+		return 0, err
+	}
+	if issue != nil {
+		issue.Repaired = repair
+		report.Issues = append(report.Issues, *issue)
+		rebuildIndex = rebuildIndex || repair
+	}
+	if rebuildIndex {
+		if err := writeIndex(indexPath, expected); err != nil {
+			return 0, fmt.Errorf("failed to rebuild index: %w", err)
+		}
+	}
+
+	// A bad record already tells the segment changed since it was sealed,
+	// the seal only has to go with the repair
+	if verifyErr != nil {
+		if repair {
+			if err := removeSeal(segment.Path); err != nil {
+				return 0, err
+			}
+		}
+		return records, nil
+	}
+	issue, err = verifySeal(segment.Path, records)
+	if err != nil {
+		return 0, err
+	}
+	if issue != nil {
+		if repair {
+			// The segment is scanned when opened again, as if never sealed
+			if err := removeSeal(segment.Path); err != nil {
+				return 0, err
+			}
+			issue.Repaired = true
+		}
+		report.Issues = append(report.Issues, *issue)
+	}
+
+	return records, nil
+}
+
+// verifySeal checks that the seal of the segment at path, if it has one,
+// matches the file and the records found in it.
+func verifySeal(path string, records int64) (*VerifyIssue, error) {
+	seal, ok := readSeal(path)
+	if !ok {
+		return nil, nil
+	}
+	size, crc, err := fileCRC(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var problem string
+	switch {
+	case size != seal.Size:
+		problem = fmt.Sprintf("segment was sealed at %d bytes but has %d", seal.Size, size)
+	case crc != seal.CRC:
+		problem = fmt.Sprintf("segment checksum is %08x but was sealed as %08x", crc, seal.CRC)
+	case records != seal.Records:
+		problem = fmt.Sprintf("segment was sealed with %d records but has %d good ones", seal.Records, records)
+	default:
+		return nil, nil
+	}
+	return &VerifyIssue{Path: path + sealSuffix, Position: -1, Problem: problem}, nil
+}
+
+// verifyIndex checks that every entry of the index is one of the expected
+// ones, in order. Entries may be missing: a crash can lose the pages of the
+// index that weren't written back yet, and lookups only get slower without
+// them.
+func verifyIndex(path string, expected []IndexEntry) (*VerifyIssue, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return &VerifyIssue{Path: path, Position: -1, Problem: "index is missing"}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(data)%entryWidth != 0 {
+		return &VerifyIssue{Path: path, Position: int64(len(data) - len(data)%entryWidth), Problem: "partial entry at the end"}, nil
+	}
+
+	next := 0
+	for pos := 0; pos < len(data); pos += entryWidth {
+		var entry IndexEntry
+		entry.Unmarshal(data[pos : pos+entryWidth])
+		if entry.LogicalOff == 0 {
+			// The preallocated space of an index that wasn't closed
+			break
+		}
+		for next < len(expected) && expected[next].LogicalOff < entry.LogicalOff {
+			next++
+		}
+		if next == len(expected) || expected[next] != entry {
+			return &VerifyIssue{
+				Path:     path,
+				Position: int64(pos),
+				Problem:  fmt.Sprintf("entry for offset %d at byte %d doesn't point at that record", entry.LogicalOff, entry.MemoryPos),
+			}, nil
+		}
+		next++
+	}
+	return nil, nil
+}
+
+// writeIndex atomically replaces the index at path with entries.
+func writeIndex(path string, entries []IndexEntry) error {
+	data := make([]byte, len(entries)*entryWidth)
+	for i, entry := range entries {
+		entry.Marshal(data[i*entryWidth:])
+	}
+	return WriteFileAtomic(filepath.Dir(path), filepath.Base(path), data)
+}
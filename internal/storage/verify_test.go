@@ -0,0 +1,151 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerifyPartition(t *testing.T) {
+	const recordSize = HeaderSize + len("data 0000")
+
+	// newVerifiedPartition writes 1500 records in segments of 600 and closes
+	// the partition.
+	newVerifiedPartition := func(t *testing.T) (string, []Segment) {
+		t.Helper()
+
+		dir := filepath.Join(t.TempDir(), "partition")
+		config := DefaultPartitionConfig()
+		config.MaxSegmentRecords = 600
+		p, err := NewPartitionWithConfig(dir, config)
+		require.NoError(t, err)
+		for i := range 1500 {
+			require.NoError(t, p.Append(fmt.Appendf(nil, "data %04d", i)))
+		}
+		require.NoError(t, p.Close())
+
+		segments, err := listSegments(dir)
+		require.NoError(t, err)
+		require.Len(t, segments, 3)
+		return dir, segments
+	}
+
+	t.Run("healthy partition", func(t *testing.T) {
+		dir, _ := newVerifiedPartition(t)
+
+		report, err := VerifyPartition(dir, false)
+		require.NoError(t, err)
+		require.Equal(t, VerifyReport{Segments: 3, Records: 1500}, report)
+		require.True(t, report.Healthy())
+	})
+	t.Run("corrupt record is cut off", func(t *testing.T) {
+		dir, segments := newVerifiedPartition(t)
+
+		f, err := os.OpenFile(segments[1].Path, os.O_RDWR, 0)
+		require.NoError(t, err)
+		// Into the payload of record 550
+		_, err = f.WriteAt([]byte{'X'}, int64(SegmentHeaderSize+550*recordSize+HeaderSize))
+		require.NoError(t, err)
+		require.NoError(t, f.Close())
+
+		report, err := VerifyPartition(dir, false)
+		require.NoError(t, err)
+		require.False(t, report.Healthy())
+		require.Len(t, report.Issues, 2)
+		require.Equal(t, int64(SegmentHeaderSize+550*recordSize), report.Issues[0].Position)
+		require.Contains(t, report.Issues[0].Problem, "record 1150")
+		require.Contains(t, report.Issues[1].Problem, "offsets 1150 to 1200 are missing")
+
+		report, err = VerifyPartition(dir, true)
+		require.NoError(t, err)
+		require.True(t, report.Issues[0].Repaired)
+		require.False(t, report.Healthy(), "the gap stays")
+
+		info, err := os.Stat(segments[1].Path)
+		require.NoError(t, err)
+		require.Equal(t, int64(SegmentHeaderSize+550*recordSize), info.Size())
+
+		report, err = VerifyPartition(dir, false)
+		require.NoError(t, err)
+		require.Len(t, report.Issues, 1)
+		require.Equal(t, int64(1450), report.Records)
+	})
+	t.Run("bad index is rebuilt", func(t *testing.T) {
+		dir, segments := newVerifiedPartition(t)
+
+		indexPath := segments[0].Path + ".index"
+		var buf [entryWidth]byte
+		IndexEntry{LogicalOff: 500, MemoryPos: 123}.Marshal(buf[:])
+		require.NoError(t, os.WriteFile(indexPath, buf[:], 0o644))
+
+		report, err := VerifyPartition(dir, true)
+		require.NoError(t, err)
+		require.Len(t, report.Issues, 1)
+		require.Equal(t, indexPath, report.Issues[0].Path)
+		require.True(t, report.Healthy())
+
+		data, err := os.ReadFile(indexPath)
+		require.NoError(t, err)
+		var entry IndexEntry
+		entry.Unmarshal(data)
+		require.Equal(t, IndexEntry{LogicalOff: 500, MemoryPos: uint32(SegmentHeaderSize + 500*recordSize)}, entry)
+
+		p, err := NewPartition(dir)
+		require.NoError(t, err)
+		defer p.Close()
+		record, err := p.Read(520)
+		require.NoError(t, err)
+		require.Equal(t, "data 0520", string(record.Payload))
+	})
+	t.Run("index missing entries is fine", func(t *testing.T) {
+		dir, segments := newVerifiedPartition(t)
+		require.NoError(t, os.Truncate(segments[0].Path+".index", 0))
+
+		report, err := VerifyPartition(dir, false)
+		require.NoError(t, err)
+		require.Empty(t, report.Issues)
+	})
+	t.Run("overlap is truncated", func(t *testing.T) {
+		dir, segments := newVerifiedPartition(t)
+		// The next segment starts 100 records earlier than it should
+		overlapping := filepath.Join(dir, newLogNameFromInt(1100).string())
+		require.NoError(t, os.Rename(segments[2].Path, overlapping))
+		require.NoError(t, os.Rename(segments[2].Path+".index", overlapping+".index"))
+
+		report, err := VerifyPartition(dir, true)
+		require.NoError(t, err)
+		require.Len(t, report.Issues, 1)
+		require.Contains(t, report.Issues[0].Problem, "last 100 records")
+		require.True(t, report.Healthy())
+		require.Equal(t, int64(1400), report.Records)
+
+		report, err = VerifyPartition(dir, false)
+		require.NoError(t, err)
+		require.Empty(t, report.Issues)
+	})
+	t.Run("segment in an old format is reported, not cut", func(t *testing.T) {
+		dir, segments := newVerifiedPartition(t)
+		// Segments had no format header before
+		data, err := os.ReadFile(segments[1].Path)
+		require.NoError(t, err)
+		legacy := data[SegmentHeaderSize:]
+		require.NoError(t, os.WriteFile(segments[1].Path, legacy, 0o644))
+
+		report, err := VerifyPartition(dir, true)
+		require.NoError(t, err)
+		require.Len(t, report.Issues, 1)
+		require.Equal(t, segments[1].Path, report.Issues[0].Path)
+		require.Equal(t, int64(-1), report.Issues[0].Position)
+		require.Contains(t, report.Issues[0].Problem, "no format header")
+		require.NotContains(t, report.Issues[0].Problem, "checksum")
+		require.False(t, report.Healthy())
+		require.Equal(t, int64(900), report.Records)
+
+		after, err := os.ReadFile(segments[1].Path)
+		require.NoError(t, err)
+		require.Equal(t, legacy, after)
+	})
+}
@@ -0,0 +1,258 @@
+// Package log is brook's storage engine for programs that embed it:
+// partitions of append-only records on local disk, with batched appends,
+// crash recovery and TTLs, and standalone logs for a single file.
+//
+// It is the supported surface of the engine, everything else lives under
+// internal/ and may change at any time.
+package log
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/mvaleed/brook/internal/storage"
+)
+
+var (
+	// ErrClosed is returned by every operation on a closed partition.
+	ErrClosed = storage.ErrPartitionClosed
+	// ErrRecordExpired is returned for a record whose TTL has run out.
+	ErrRecordExpired = storage.ErrRecordExpired
+	// ErrRecordTooLarge is returned for a payload over the record size limit.
+	ErrRecordTooLarge = storage.ErrRecordTooLarge
+	// ErrRecordCorrupt is returned for a record that fails its checksum or
+	// whose header can't be right.
+	ErrRecordCorrupt = storage.ErrRecordCorrupt
+	// ErrDecryptionFailed is returned for an encrypted record that can't be
+	// decrypted with the keys of the partition's keyring.
+	ErrDecryptionFailed = storage.ErrDecryptionFailed
+)
+
+// Keyring hands out the AES keys a partition encrypts payloads with, see
+// Config.Keyring.
+type Keyring = storage.Keyring
+
+// StaticKeyring is a Keyring over keys known upfront.
+type StaticKeyring = storage.StaticKeyring
+
+// Record is a record read back from a partition or a log.
+type Record struct {
+	Offset    int
+	Timestamp time.Time
+	ExpiresAt time.Time // zero if the record never expires
+	Payload   []byte
+}
+
+func newRecord(offset int, r storage.Record) Record {
+	record := Record{
+		Offset:    offset,
+		Timestamp: time.Unix(0, int64(r.Header.Timestamp)),
+		Payload:   r.Payload,
+	}
+	if r.Header.ExpiresAt != 0 {
+		record.ExpiresAt = time.Unix(0, int64(r.Header.ExpiresAt))
+	}
+	return record
+}
+
+// Config is how a partition is laid out on disk and batches its appends.
+// Start from DefaultConfig, the zero Config is not valid.
+type Config struct {
+	// MaxSegmentBytes caps the size of a segment file, at most 4GiB.
+	MaxSegmentBytes int64
+	// MaxSegmentRecords caps the number of records in a segment.
+	MaxSegmentRecords int64
+	// MaxSegmentAge caps how long a segment takes appends.
+	MaxSegmentAge time.Duration
+	// MaxRecordBytes caps the size of a payload. Zero means 16MiB.
+	MaxRecordBytes int64
+	// BatchWindow is how long appends wait for others to be written and
+	// flushed together with. Zero only batches appends that are already
+	// waiting.
+	BatchWindow time.Duration
+	// PreallocateSegments reserves MaxSegmentBytes of disk for a segment
+	// up front, on Linux.
+	PreallocateSegments bool
+	// Keyring, when set, encrypts payloads at rest with AES-GCM. Records
+	// written before it was set are still read as they are. Once records
+	// are encrypted, the partition can only be opened read only without it.
+	Keyring Keyring
+	// Logger receives rotation and recovery events. Defaults to
+	// slog.Default().
+	Logger *slog.Logger
+}
+
+// DefaultConfig returns segments of up to 1GiB, 10000 records or a day.
+func DefaultConfig() Config {
+	defaults := storage.DefaultPartitionConfig()
+	return Config{
+		MaxSegmentBytes:   defaults.MaxSegmentBytes,
+		MaxSegmentRecords: defaults.MaxSegmentRecords,
+		MaxSegmentAge:     defaults.MaxSegmentAge,
+	}
+}
+
+func (c Config) partitionConfig() storage.PartitionConfig {
+	config := storage.DefaultPartitionConfig()
+	config.MaxSegmentBytes = c.MaxSegmentBytes
+	config.MaxSegmentRecords = c.MaxSegmentRecords
+	config.MaxSegmentAge = c.MaxSegmentAge
+	config.MaxRecordBytes = c.MaxRecordBytes
+	config.BatchWindow = c.BatchWindow
+	config.PreallocateSegments = c.PreallocateSegments
+	config.Keyring = c.Keyring
+	config.Logger = c.Logger
+	return config
+}
+
+// Partition is an ordered sequence of records stored in a directory, split
+// into segment files. Every append is handed to the OS before it returns, so
+// it survives the process crashing; Sync makes it survive a power loss too.
+// A Partition is safe for concurrent use.
+type Partition struct {
+	p *storage.Partition
+}
+
+// Open opens the partition in dir, creating it if it doesn't exist, and
+// recovers whatever a crash left half written.
+func Open(dir string, config Config) (*Partition, error) {
+	p, err := storage.NewPartitionWithConfig(dir, config.partitionConfig())
+	if err != nil {
+		return nil, err
+	}
+	return &Partition{p: p}, nil
+}
+
+// Append adds a record to the partition.
+func (p *Partition) Append(payload []byte) error {
+	return p.p.Append(payload)
+}
+
+// AppendContext is Append that stops waiting once ctx is done. The record may
+// still be appended then.
+func (p *Partition) AppendContext(ctx context.Context, payload []byte) error {
+	return p.p.AppendContext(ctx, payload)
+}
+
+// AppendWithTTL adds a record that can't be read once ttl has passed.
+func (p *Partition) AppendWithTTL(payload []byte, ttl time.Duration) error {
+	return p.p.AppendWithTTL(payload, ttl)
+}
+
+// Read returns the record at offset.
+func (p *Partition) Read(offset int) (Record, error) {
+	return p.ReadContext(context.Background(), offset)
+}
+
+// ReadContext is Read that gives up once ctx is done, if the read has to wait
+// on anything.
+func (p *Partition) ReadContext(ctx context.Context, offset int) (Record, error) {
+	r, err := p.p.ReadContext(ctx, offset)
+	if err != nil {
+		return Record{}, err
+	}
+	return newRecord(offset, r), nil
+}
+
+// NextOffset returns the offset the next appended record gets.
+func (p *Partition) NextOffset() int {
+	return p.p.NextOffset()
+}
+
+// Sync fsyncs every record appended so far.
+func (p *Partition) Sync() error {
+	return p.p.Sync()
+}
+
+// DurableOffset returns the offset below which every record is fsynced.
+func (p *Partition) DurableOffset() int {
+	return p.p.DurableOffset()
+}
+
+// WaitDurable returns once the record at offset is fsynced, or once ctx is
+// done.
+func (p *Partition) WaitDurable(ctx context.Context, offset int) error {
+	return p.p.WaitDurable(ctx, offset)
+}
+
+// Close flushes and fsyncs the partition. Appends waiting on it fail with
+// ErrClosed.
+func (p *Partition) Close() error {
+	return p.p.Close()
+}
+
+// Durability is how eagerly a Log persists its records.
+type Durability int
+
+const (
+	// Async buffers appends and hands them to the OS in the background, at
+	// most 100ms later. A process crash loses what is buffered.
+	Async Durability = iota + 1
+	// Medium hands every append to the OS before it returns.
+	Medium
+	// Full fsyncs every append before it returns.
+	Full
+)
+
+// Log is a single append-only file, with its offset index next to it. It
+// doesn't roll over into new files, use a Partition for a log that keeps
+// growing. A Log is safe for concurrent use.
+type Log struct {
+	l *storage.Log
+}
+
+// OpenLog opens the log at path, creating it if it doesn't exist.
+func OpenLog(path string, durability Durability) (*Log, error) {
+	var l *storage.Log
+	var err error
+	switch durability {
+	case Async:
+		l, err = storage.NewLogAsync(path, 0)
+	case Medium:
+		l, err = storage.NewLogMediumDurable(path, 0)
+	case Full:
+		l, err = storage.NewLogFullDurable(path, 0)
+	default:
+		return nil, fmt.Errorf("unknown durability %d", durability)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &Log{l: l}, nil
+}
+
+// Append adds a record to the log.
+func (l *Log) Append(payload []byte) error {
+	return l.l.Append(payload)
+}
+
+// AppendBatch adds payloads as consecutive records, written and flushed once.
+func (l *Log) AppendBatch(payloads [][]byte) error {
+	return l.l.AppendBatch(payloads)
+}
+
+// Read returns the record at offset.
+func (l *Log) Read(offset int) (Record, error) {
+	r, err := l.l.FindRecord(int64(offset))
+	if err != nil {
+		return Record{}, err
+	}
+	return newRecord(offset, r), nil
+}
+
+// NextOffset returns the offset the next appended record gets.
+func (l *Log) NextOffset() int {
+	return int(l.l.NextOffset())
+}
+
+// Size returns the size of the log in bytes.
+func (l *Log) Size() int64 {
+	return l.l.Size()
+}
+
+// Close flushes and fsyncs the log.
+func (l *Log) Close() error {
+	return l.l.Close()
+}
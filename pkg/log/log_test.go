@@ -0,0 +1,96 @@
+package log
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPartition(t *testing.T) {
+	dir := t.TempDir()
+	config := DefaultConfig()
+	config.MaxSegmentRecords = 10
+	config.MaxRecordBytes = 100
+	p, err := Open(dir, config)
+	require.NoError(t, err)
+
+	before := time.Now()
+	for i := range 25 {
+		require.NoError(t, p.Append(fmt.Appendf(nil, "record %d", i)))
+	}
+	require.NoError(t, p.AppendWithTTL([]byte("short lived"), time.Nanosecond))
+	require.ErrorIs(t, p.Append(make([]byte, 101)), ErrRecordTooLarge)
+	require.Equal(t, 26, p.NextOffset())
+
+	// Offsets are the partition's, not the segment's
+	record, err := p.Read(23)
+	require.NoError(t, err)
+	require.Equal(t, 23, record.Offset)
+	require.Equal(t, "record 23", string(record.Payload))
+	require.False(t, record.Timestamp.Before(before.Truncate(time.Second)))
+	require.True(t, record.ExpiresAt.IsZero())
+	_, err = p.Read(25)
+	require.ErrorIs(t, err, ErrRecordExpired)
+
+	require.NoError(t, p.WaitDurable(context.Background(), 0))
+	require.NoError(t, p.Sync())
+	require.Equal(t, 26, p.DurableOffset())
+	require.NoError(t, p.Close())
+	require.ErrorIs(t, p.Append([]byte("late")), ErrClosed)
+
+	p, err = Open(dir, config)
+	require.NoError(t, err)
+	defer p.Close()
+	record, err = p.Read(7)
+	require.NoError(t, err)
+	require.Equal(t, "record 7", string(record.Payload))
+}
+
+func TestPartition_Encrypted(t *testing.T) {
+	dir := t.TempDir()
+	config := DefaultConfig()
+	config.Keyring = &StaticKeyring{Current: "k1", Keys: map[string][]byte{"k1": make([]byte, 32)}}
+	p, err := Open(dir, config)
+	require.NoError(t, err)
+	require.NoError(t, p.Append([]byte("secret")))
+	require.NoError(t, p.Close())
+
+	config.Keyring = &StaticKeyring{Current: "k2", Keys: map[string][]byte{"k2": make([]byte, 32)}}
+	p, err = Open(dir, config)
+	require.NoError(t, err)
+	defer p.Close()
+	_, err = p.Read(0)
+	require.ErrorIs(t, err, ErrDecryptionFailed)
+}
+
+func TestLog(t *testing.T) {
+	for _, durability := range []Durability{Async, Medium, Full} {
+		t.Run(fmt.Sprint(durability), func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "test.log")
+			l, err := OpenLog(path, durability)
+			require.NoError(t, err)
+
+			require.NoError(t, l.Append([]byte("first")))
+			require.NoError(t, l.AppendBatch([][]byte{[]byte("second"), []byte("third")}))
+			require.Equal(t, 3, l.NextOffset())
+
+			record, err := l.Read(2)
+			require.NoError(t, err)
+			require.Equal(t, 2, record.Offset)
+			require.Equal(t, "third", string(record.Payload))
+			require.NoError(t, l.Close())
+
+			l, err = OpenLog(path, durability)
+			require.NoError(t, err)
+			defer l.Close()
+			require.Equal(t, 3, l.NextOffset())
+		})
+	}
+
+	_, err := OpenLog(filepath.Join(t.TempDir(), "test.log"), 0)
+	require.Error(t, err)
+}
@@ -0,0 +1,236 @@
+package streams
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/mvaleed/brook/internal/brain"
+	"github.com/mvaleed/brook/internal/client"
+)
+
+// Broker is what an App consumes, publishes and keeps its state through.
+// *brain.Broker implements it.
+type Broker interface {
+	client.Broker
+	CreateTopic(ctx context.Context, name string, config brain.TopicConfig) error
+}
+
+var _ Broker = (*brain.Broker)(nil)
+
+const (
+	defaultPollInterval = 100 * time.Millisecond
+	restoreFetchBytes   = 1 << 20
+)
+
+// Config configures an App.
+type Config struct {
+	// Application names the app. It is the consumer group the app commits
+	// its offsets for, and prefixes the changelog topics of its state
+	// stores.
+	Application string
+	// PollInterval is how long Run waits for messages once it has caught
+	// up. Defaults to 100ms.
+	PollInterval time.Duration
+	// Logger defaults to slog.Default().
+	Logger *slog.Logger
+}
+
+// App runs a topology on the messages of its source topic. An App is not
+// safe for concurrent use.
+type App struct {
+	broker   Broker
+	topology *Topology
+	config   Config
+	consumer *client.Consumer
+	producer *client.Producer
+}
+
+func NewApp(broker Broker, topology *Topology, config Config) (*App, error) {
+	if config.Application == "" {
+		return nil, errors.New("application name is required")
+	}
+	names := make(map[string]bool)
+	for _, store := range topology.stores {
+		if store.window.Size <= 0 {
+			return nil, fmt.Errorf("window of store %s must have a positive size", store.name)
+		}
+		if store.window.Grace < 0 {
+			return nil, fmt.Errorf("window of store %s can't have a negative grace", store.name)
+		}
+		if names[store.name] {
+			return nil, fmt.Errorf("two state stores are called %s", store.name)
+		}
+		names[store.name] = true
+	}
+	if config.PollInterval <= 0 {
+		config.PollInterval = defaultPollInterval
+	}
+	if config.Logger == nil {
+		config.Logger = slog.Default()
+	}
+
+	a := &App{
+		broker:   broker,
+		topology: topology,
+		config:   config,
+		producer: client.NewProducer(broker, client.ProducerConfig{}),
+	}
+	if err := a.resetConsumer(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// Run restores the state stores, then processes the messages of the source
+// topic as they are published until ctx is done, committing after every
+// batch. It returns the first error processing fails with, once the records
+// since the last commit are left to be processed again.
+func (a *App) Run(ctx context.Context) error {
+	if err := a.restore(ctx); err != nil {
+		return err
+	}
+	for {
+		n, err := a.step(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+		if n > 0 {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(a.config.PollInterval):
+		}
+	}
+}
+
+// step processes a batch of records and commits it, returning the number of
+// records processed. On failure the consumer goes back to the last commit,
+// for the batch to be processed again.
+func (a *App) step(ctx context.Context) (int, error) {
+	records, err := a.consumer.Poll(ctx)
+	if err == nil {
+		err = a.process(ctx, records)
+	}
+	if err != nil {
+		if resetErr := a.resetConsumer(); resetErr != nil {
+			return 0, errors.Join(err, resetErr)
+		}
+		return 0, err
+	}
+	return len(records), nil
+}
+
+func (a *App) process(ctx context.Context, records []client.Record) error {
+	for _, r := range records {
+		record := Record{Value: r.Value, Timestamp: r.Timestamp}
+		if err := a.topology.root.push(ctx, a, record); err != nil {
+			return fmt.Errorf("processing offset %d of partition %d of topic %s: %w",
+				r.Offset, r.Partition, a.topology.source, err)
+		}
+	}
+	for _, store := range a.topology.stores {
+		if err := a.closeWindows(ctx, store); err != nil {
+			return err
+		}
+	}
+	if len(records) == 0 {
+		return nil
+	}
+	// The outputs and state changes are written by now, committing after
+	// them is what makes it at least once
+	return a.consumer.Commit(ctx)
+}
+
+func (a *App) resetConsumer() error {
+	consumer, err := client.NewConsumer(a.broker, client.ConsumerConfig{
+		Group: a.config.Application,
+		Topic: a.topology.source,
+	})
+	if err != nil {
+		return err
+	}
+	a.consumer = consumer
+	return nil
+}
+
+// changelog returns the topic the changes to store are written to.
+func (a *App) changelog(store *windowStore) string {
+	return a.config.Application + "-" + store.name + "-changelog"
+}
+
+// putState sets the aggregate of key in store, writing it to the changelog
+// first.
+func (a *App) putState(ctx context.Context, store *windowStore, key windowKey, value []byte) error {
+	data, err := json.Marshal(stateEntry{Key: []byte(key.key), Window: key.start, Value: value})
+	if err != nil {
+		return err
+	}
+	if err := a.broker.Produce(ctx, a.changelog(store), 0, data); err != nil {
+		return err
+	}
+	store.apply(key, value)
+	return nil
+}
+
+// closeWindows drops the windows of store that closed.
+func (a *App) closeWindows(ctx context.Context, store *windowStore) error {
+	for key := range store.values {
+		if !store.closed(time.Unix(0, key.start)) {
+			continue
+		}
+		if err := a.putState(ctx, store, key, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// restore creates the changelog topics that don't exist yet and loads the
+// state stores from them.
+func (a *App) restore(ctx context.Context) error {
+	for _, store := range a.topology.stores {
+		topic := a.changelog(store)
+		config := brain.DefaultTopicConfig()
+		config.Compact = true
+		err := a.broker.CreateTopic(ctx, topic, config)
+		if err != nil && !errors.Is(err, brain.ErrTopicExists) {
+			return err
+		}
+
+		clear(store.values)
+		offset, _, err := a.broker.Offsets(topic, 0)
+		if err != nil {
+			return err
+		}
+		for {
+			msgs, err := a.broker.Fetch(ctx, topic, 0, offset, restoreFetchBytes)
+			if err != nil {
+				return err
+			}
+			if len(msgs) == 0 {
+				break
+			}
+			for _, msg := range msgs {
+				entry, err := parseStateEntry(msg.Data)
+				if err != nil {
+					return fmt.Errorf("offset %d of changelog %s: %w", msg.Offset, topic, err)
+				}
+				store.apply(entry.windowKey(), entry.Value)
+				offset = msg.Offset + 1
+			}
+		}
+		a.config.Logger.Info("restored state store",
+			"store", store.name, "windows", len(store.values))
+	}
+	return nil
+}
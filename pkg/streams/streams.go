@@ -0,0 +1,108 @@
+// Package streams processes the messages of a topic as they are published:
+// it maps and filters them, aggregates them over time windows, and publishes
+// the results to other topics.
+//
+// A Topology describes the processing and an App runs it for a consumer
+// group. Delivery is at least once: an App that restarts carries on from its
+// last commit, with the state of its windows restored from their changelog
+// topics, and processes again what it had processed since.
+package streams
+
+import (
+	"context"
+	"time"
+)
+
+// Record is a message flowing through a topology.
+type Record struct {
+	// Key routes the record to a partition when it's published, and groups
+	// it in a windowed aggregate. Messages consumed from the source topic
+	// have none, set one with Map.
+	Key       []byte
+	Value     []byte
+	Timestamp time.Time
+	// Window is the start of the window an aggregate emitted the record
+	// for, zero for the others.
+	Window time.Time
+}
+
+// Topology is the graph of processing steps an App runs on the messages of
+// its source topic. It is built up front, before the App starts.
+type Topology struct {
+	source string
+	root   *node
+	stores []*windowStore
+}
+
+// NewTopology returns a topology that consumes the topic called source.
+func NewTopology(source string) *Topology {
+	return &Topology{source: source, root: &node{}}
+}
+
+// Source returns the stream of the messages of the source topic. Every
+// stream derived from it gets every message.
+func (t *Topology) Source() *Stream {
+	return &Stream{topology: t, node: t.root}
+}
+
+// node is a processing step. It hands what it makes of a record to every one
+// of its children.
+type node struct {
+	// process calls emit with what the record turns into, any number of
+	// times. A nil process passes the record on as it is.
+	process  func(ctx context.Context, app *App, r Record, emit func(Record) error) error
+	children []*node
+}
+
+func (n *node) push(ctx context.Context, app *App, r Record) error {
+	emit := func(r Record) error {
+		for _, child := range n.children {
+			if err := child.push(ctx, app, r); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if n.process == nil {
+		return emit(r)
+	}
+	return n.process(ctx, app, r, emit)
+}
+
+// Stream is a sequence of records in a topology, to process further.
+type Stream struct {
+	topology *Topology
+	node     *node
+}
+
+func (s *Stream) then(process func(ctx context.Context, app *App, r Record, emit func(Record) error) error) *Stream {
+	child := &node{process: process}
+	s.node.children = append(s.node.children, child)
+	return &Stream{topology: s.topology, node: child}
+}
+
+// Map returns the stream of the records of s turned into fn's result.
+func (s *Stream) Map(fn func(Record) Record) *Stream {
+	return s.then(func(ctx context.Context, app *App, r Record, emit func(Record) error) error {
+		return emit(fn(r))
+	})
+}
+
+// Filter returns the stream of the records of s that fn keeps.
+func (s *Stream) Filter(fn func(Record) bool) *Stream {
+	return s.then(func(ctx context.Context, app *App, r Record, emit func(Record) error) error {
+		if !fn(r) {
+			return nil
+		}
+		return emit(r)
+	})
+}
+
+// To publishes the records of s to the topic called topic, on the partition
+// their key is routed to.
+func (s *Stream) To(topic string) {
+	s.then(func(ctx context.Context, app *App, r Record, emit func(Record) error) error {
+		_, err := app.producer.Send(ctx, topic, r.Key, r.Value)
+		return err
+	})
+}
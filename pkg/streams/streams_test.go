@@ -0,0 +1,141 @@
+package streams
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/mvaleed/brook/internal/brain"
+	"github.com/mvaleed/brook/internal/storage"
+)
+
+// clickCounts counts the clicks of every user in 10 minute windows. Clicks
+// are "<user> <minute>", their timestamp is the minute.
+func clickCounts() *Topology {
+	t := NewTopology("clicks")
+	clicks := t.Source().
+		Filter(func(r Record) bool { return len(r.Value) > 0 }).
+		Map(func(r Record) Record {
+			user, minute, _ := strings.Cut(string(r.Value), " ")
+			m, _ := strconv.Atoi(minute)
+			return Record{Key: []byte(user), Value: r.Value, Timestamp: time.Unix(0, 0).Add(time.Duration(m) * time.Minute)}
+		})
+	clicks.To("clicks-by-user")
+	clicks.
+		Aggregate("counts", Window{Size: 10 * time.Minute}, func(aggregate []byte, r Record) []byte {
+			n, _ := strconv.Atoi(string(aggregate))
+			return strconv.AppendInt(nil, int64(n+1), 10)
+		}).
+		Map(func(r Record) Record {
+			r.Value = []byte(string(r.Key) + "=" + string(r.Value) + "@" + strconv.Itoa(int(r.Window.Unix()/60)))
+			return r
+		}).
+		To("counts")
+	return t
+}
+
+func topicValues(t *testing.T, b *brain.Broker, topic string) []string {
+	t.Helper()
+	msgs, err := b.Fetch(context.Background(), topic, 0, 0, 1<<20)
+	require.NoError(t, err)
+	var values []string
+	for _, msg := range msgs {
+		values = append(values, string(msg.Data))
+	}
+	return values
+}
+
+func TestApp(t *testing.T) {
+	ctx := context.Background()
+	b, err := brain.OpenBroker(storage.Paths{Data: t.TempDir()}, brain.DefaultBrokerConfig())
+	require.NoError(t, err)
+	defer b.Close()
+	require.NoError(t, b.CreateTopic(ctx, "clicks", brain.DefaultTopicConfig()))
+	require.NoError(t, b.CreateTopic(ctx, "clicks-by-user", brain.DefaultTopicConfig()))
+
+	produce := func(values ...string) {
+		for _, v := range values {
+			require.NoError(t, b.Produce(ctx, "clicks", 0, []byte(v)))
+		}
+	}
+	newApp := func() *App {
+		a, err := NewApp(b, clickCounts(), Config{Application: "dashboard"})
+		require.NoError(t, err)
+		require.NoError(t, a.restore(ctx))
+		return a
+	}
+
+	a := newApp()
+	produce("a 1", "", "b 2", "a 3")
+
+	// The counts topic doesn't exist, nothing is committed
+	_, err = a.step(ctx)
+	require.ErrorIs(t, err, brain.ErrUnknownTopic)
+	_, found, err := b.CommittedOffset(ctx, "dashboard", "clicks", 0)
+	require.NoError(t, err)
+	require.False(t, found)
+
+	// So the batch is processed again, at least once: the first click was
+	// counted by the failed attempt already
+	require.NoError(t, b.CreateTopic(ctx, "counts", brain.DefaultTopicConfig()))
+	n, err := a.step(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 4, n)
+	require.Equal(t, []string{"a=2@0", "b=1@0", "a=3@0"}, topicValues(t, b, "counts"))
+	require.Equal(t, []string{"a 1", "a 1", "b 2", "a 3"}, topicValues(t, b, "clicks-by-user"))
+	committed, _, err := b.CommittedOffset(ctx, "dashboard", "clicks", 0)
+	require.NoError(t, err)
+	require.Equal(t, 4, committed)
+
+	n, err = a.step(ctx)
+	require.NoError(t, err)
+	require.Zero(t, n)
+
+	// A restarted app picks up where it left off, with its state
+	a = newApp()
+	produce("a 4")
+	_, err = a.step(ctx)
+	require.NoError(t, err)
+	require.Equal(t, "a=4@0", topicValues(t, b, "counts")[3])
+
+	// A click in the next window closes the first one, later clicks for it
+	// are dropped
+	produce("a 12", "b 5", "b 13")
+	_, err = a.step(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []string{"a=1@10", "b=1@10"}, topicValues(t, b, "counts")[4:])
+	require.Len(t, a.topology.stores[0].values, 2)
+
+	a = newApp()
+	require.Equal(t, map[windowKey][]byte{
+		{key: "a", start: int64(10 * time.Minute)}: []byte("1"),
+		{key: "b", start: int64(10 * time.Minute)}: []byte("1"),
+	}, a.topology.stores[0].values)
+}
+
+func TestNewApp(t *testing.T) {
+	b, err := brain.OpenBroker(storage.Paths{Data: t.TempDir()}, brain.DefaultBrokerConfig())
+	require.NoError(t, err)
+	defer b.Close()
+	require.NoError(t, b.CreateTopic(context.Background(), "clicks", brain.DefaultTopicConfig()))
+
+	_, err = NewApp(b, clickCounts(), Config{})
+	require.Error(t, err)
+	_, err = NewApp(b, NewTopology("missing"), Config{Application: "dashboard"})
+	require.ErrorIs(t, err, brain.ErrUnknownTopic)
+
+	topology := NewTopology("clicks")
+	topology.Source().Aggregate("counts", Window{}, nil)
+	_, err = NewApp(b, topology, Config{Application: "dashboard"})
+	require.Error(t, err)
+
+	topology = NewTopology("clicks")
+	topology.Source().Aggregate("counts", Window{Size: time.Minute}, nil)
+	topology.Source().Aggregate("counts", Window{Size: time.Hour}, nil)
+	_, err = NewApp(b, topology, Config{Application: "dashboard"})
+	require.Error(t, err)
+}
@@ -0,0 +1,107 @@
+package streams
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Window splits time into back to back windows of Size, the first one
+// starting at the zero time.
+type Window struct {
+	Size time.Duration
+	// Grace is how long after its end a window still takes records that
+	// come late. Once it's over the window is closed and dropped from the
+	// state, and its late records are dropped too.
+	Grace time.Duration
+}
+
+// Aggregator folds a record into the aggregate of its key and window, which
+// is nil for the first record of the window.
+type Aggregator func(aggregate []byte, r Record) []byte
+
+// Aggregate returns the stream of the aggregates of the records of s by key
+// and window, emitting the new aggregate after every record. The aggregates
+// are kept in a state store called store, backed by a changelog topic they
+// are restored from when the App restarts.
+func (s *Stream) Aggregate(store string, window Window, fn Aggregator) *Stream {
+	ws := &windowStore{
+		name:   store,
+		window: window,
+		values: make(map[windowKey][]byte),
+	}
+	s.topology.stores = append(s.topology.stores, ws)
+
+	return s.then(func(ctx context.Context, app *App, r Record, emit func(Record) error) error {
+		start := r.Timestamp.Truncate(window.Size)
+		if r.Timestamp.After(ws.streamTime) {
+			ws.streamTime = r.Timestamp
+		}
+		if ws.closed(start) {
+			app.config.Logger.Debug("dropped record for a closed window",
+				"store", store, "window", start)
+			return nil
+		}
+
+		key := windowKey{key: string(r.Key), start: start.UnixNano()}
+		aggregate := fn(ws.values[key], r)
+		if aggregate == nil {
+			// nil deletes in the changelog
+			return fmt.Errorf("aggregator of store %s returned nil", store)
+		}
+		if err := app.putState(ctx, ws, key, aggregate); err != nil {
+			return err
+		}
+		return emit(Record{Key: r.Key, Value: aggregate, Timestamp: r.Timestamp, Window: start})
+	})
+}
+
+type windowKey struct {
+	key   string
+	start int64 // unix nanoseconds
+}
+
+// windowStore holds the aggregates of the open windows of an Aggregate step.
+type windowStore struct {
+	name   string
+	window Window
+	values map[windowKey][]byte
+	// streamTime is the latest timestamp seen, windows close as it moves
+	// on.
+	streamTime time.Time
+}
+
+// closed tells if the window starting at start no longer takes records.
+func (s *windowStore) closed(start time.Time) bool {
+	return !start.Add(s.window.Size + s.window.Grace).After(s.streamTime)
+}
+
+// apply sets the aggregate of key, deleting it if value is nil.
+func (s *windowStore) apply(key windowKey, value []byte) {
+	if value == nil {
+		delete(s.values, key)
+		return
+	}
+	s.values[key] = value
+}
+
+// stateEntry is a change to a window store, as written to its changelog. The
+// latest entry of a key and window wins, a nil Value deletes it.
+type stateEntry struct {
+	Key    []byte `json:"key"`
+	Window int64  `json:"window"`
+	Value  []byte `json:"value"`
+}
+
+func (e stateEntry) windowKey() windowKey {
+	return windowKey{key: string(e.Key), start: e.Window}
+}
+
+func parseStateEntry(data []byte) (stateEntry, error) {
+	var e stateEntry
+	if err := json.Unmarshal(data, &e); err != nil {
+		return stateEntry{}, err
+	}
+	return e, nil
+}